@@ -0,0 +1,208 @@
+// Package audit with a security audit log of received commands
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Audit log defaults
+const (
+	DefaultMaxFileSize = 10 * 1024 * 1024 // size in bytes of the audit log before it is rotated
+	DefaultMaxFiles    = 10               // nr of rotated audit log files to keep
+)
+
+// rotatedTimeFormat is the suffix of rotated audit log files. Files sort by the time they are rotated.
+const rotatedTimeFormat = "20060102-150405.000000000"
+
+// AuditConfig with the location and rotation of the audit log
+type AuditConfig struct {
+	File        string `yaml:"file"`        // audit log file. Required
+	MaxFileSize int64  `yaml:"maxFileSize"` // size in bytes of the audit log before it is rotated. Default is DefaultMaxFileSize
+	MaxFiles    int    `yaml:"maxFiles"`    // nr of rotated audit log files to keep. The oldest are removed. Default is DefaultMaxFiles
+}
+
+// AuditRecord is the outcome of a received command in the audit log
+type AuditRecord struct {
+	Accepted  bool   `json:"accepted"`         // the command is accepted
+	Address   string `json:"address"`          // address the command is received on
+	Command   string `json:"command"`          // message type of the command, eg $set
+	Encrypted bool   `json:"encrypted"`        // the command is encrypted
+	Reason    string `json:"reason,omitempty"` // reason the command is rejected
+	Sender    string `json:"sender"`           // sender of the command as stated in the message
+	Signed    bool   `json:"signed"`           // the command is signed
+	Timestamp string `json:"timestamp"`        // time the command is received
+}
+
+// AuditLog records the outcome of every received command in an append-only file, one JSON record
+// per line. The file is rotated when it exceeds the maximum size, keeping the configured number of
+// rotated files. Each record is written to the file before the next command is recorded.
+// Attach the audit log to a publisher with AddIntegration to record the commands it receives.
+type AuditLog struct {
+	config      AuditConfig
+	file        *os.File    // the audit log file, nil if not open
+	size        int64       // size of the audit log file
+	updateMutex *sync.Mutex // mutex for concurrent recording
+}
+
+// Close the audit log file. Recording can continue afterwards.
+func (auditLog *AuditLog) Close() error {
+	auditLog.updateMutex.Lock()
+	defer auditLog.updateMutex.Unlock()
+	if auditLog.file == nil {
+		return nil
+	}
+	err := auditLog.file.Close()
+	auditLog.file = nil
+	if err != nil {
+		return lib.MakeErrorf("AuditLog.Close: Error closing %s: %s", auditLog.config.File, err)
+	}
+	return nil
+}
+
+// CommandReceived records the outcome of a received command. The publisher invokes this on
+// attached integrations. See RecordCommand.
+func (auditLog *AuditLog) CommandReceived(address string, sender string, isEncrypted bool, isSigned bool, rejectErr error) {
+	auditLog.RecordCommand(address, sender, isEncrypted, isSigned, rejectErr)
+}
+
+// RecordCommand appends the outcome of a received command to the audit log
+//  address is the address the command is received on
+//  sender is the sender of the command, "" if the command can't be decoded
+//  rejectErr is the reason the command is rejected, nil if accepted
+func (auditLog *AuditLog) RecordCommand(address string, sender string, isEncrypted bool, isSigned bool, rejectErr error) {
+	record := AuditRecord{
+		Accepted:  rejectErr == nil,
+		Address:   address,
+		Command:   address[strings.LastIndex(address, "/")+1:],
+		Encrypted: isEncrypted,
+		Sender:    sender,
+		Signed:    isSigned,
+		Timestamp: lib.Now().Format(types.TimeFormat),
+	}
+	if rejectErr != nil {
+		record.Reason = rejectErr.Error()
+	}
+	err := auditLog.Record(&record)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// Record appends a record to the audit log. The audit log is rotated when it exceeds the
+// configured maximum size.
+func (auditLog *AuditLog) Record(record *AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return lib.MakeErrorf("AuditLog.Record: Unable to marshal record of %s: %s", record.Address, err)
+	}
+	line = append(line, '\n')
+
+	auditLog.updateMutex.Lock()
+	defer auditLog.updateMutex.Unlock()
+	if auditLog.file == nil {
+		err = auditLog.openFile()
+		if err != nil {
+			return err
+		}
+	}
+	_, err = auditLog.file.Write(line)
+	if err == nil {
+		err = auditLog.file.Sync()
+	}
+	if err != nil {
+		return lib.MakeErrorf("AuditLog.Record: Unable to write to %s: %s", auditLog.config.File, err)
+	}
+	auditLog.size += int64(len(line))
+	if auditLog.size >= auditLog.config.MaxFileSize {
+		return auditLog.rotate()
+	}
+	return nil
+}
+
+// Start the audit log. The file is opened when the first command is recorded.
+func (auditLog *AuditLog) Start() error {
+	return nil
+}
+
+// Stop the audit log and close its file
+func (auditLog *AuditLog) Stop() {
+	err := auditLog.Close()
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// openFile opens the audit log file for appending. The file is created if it doesn't exist.
+// For internal use only. Use within locked section.
+func (auditLog *AuditLog) openFile() error {
+	err := os.MkdirAll(path.Dir(auditLog.config.File), 0750)
+	if err != nil {
+		return lib.MakeErrorf("AuditLog.openFile: Unable to create folder of %s: %s", auditLog.config.File, err)
+	}
+	file, err := os.OpenFile(auditLog.config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return lib.MakeErrorf("AuditLog.openFile: Unable to open %s: %s", auditLog.config.File, err)
+	}
+	info, err := file.Stat()
+	if err == nil {
+		auditLog.size = info.Size()
+	}
+	auditLog.file = file
+	return nil
+}
+
+// rotate closes the audit log file, renames it with the time of rotation and removes the oldest
+// rotated files beyond the maximum nr of files. The next record creates a new audit log file.
+// For internal use only. Use within locked section.
+func (auditLog *AuditLog) rotate() error {
+	auditLog.file.Close()
+	auditLog.file = nil
+	rotatedFile := auditLog.config.File + "." + time.Now().UTC().Format(rotatedTimeFormat)
+	err := os.Rename(auditLog.config.File, rotatedFile)
+	if err != nil {
+		return lib.MakeErrorf("AuditLog.rotate: Unable to rotate %s: %s", auditLog.config.File, err)
+	}
+	logrus.Infof("AuditLog.rotate: Rotated audit log to %s", rotatedFile)
+
+	rotatedFiles := ListRotatedFiles(auditLog.config.File)
+	for len(rotatedFiles) > auditLog.config.MaxFiles {
+		logrus.Infof("AuditLog.rotate: Removing audit log %s", rotatedFiles[0])
+		os.Remove(rotatedFiles[0])
+		rotatedFiles = rotatedFiles[1:]
+	}
+	return nil
+}
+
+// ListRotatedFiles returns the paths of the rotated files of an audit log, oldest first
+func ListRotatedFiles(filename string) []string {
+	paths, _ := filepath.Glob(filename + ".*")
+	sort.Strings(paths)
+	return paths
+}
+
+// NewAuditLog creates an audit log of received commands. The file is opened when the first
+// command is recorded.
+func NewAuditLog(config AuditConfig) *AuditLog {
+	if config.MaxFileSize <= 0 {
+		config.MaxFileSize = DefaultMaxFileSize
+	}
+	if config.MaxFiles <= 0 {
+		config.MaxFiles = DefaultMaxFiles
+	}
+	auditLog := &AuditLog{
+		config:      config,
+		updateMutex: &sync.Mutex{},
+	}
+	return auditLog
+}
@@ -0,0 +1,116 @@
+package audit_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/audit"
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const domain = "test"
+const publisherID = "publisher1"
+const node1ID = "node1"
+
+// readRecords reads the records of an audit log file
+func readRecords(t *testing.T, filename string) []audit.AuditRecord {
+	records := make([]audit.AuditRecord, 0)
+	file, err := os.Open(filename)
+	require.NoError(t, err)
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record audit.AuditRecord
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		require.NoError(t, err)
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditSetCommands(t *testing.T) {
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	auditFile := path.Join(folder, "audit", "audit.log")
+	auditLog := audit.NewAuditLog(audit.AuditConfig{File: auditFile})
+	defer auditLog.Close()
+
+	privKey := messaging.CreateAsymKeys()
+	messenger := messaging.NewDummyMessenger(nil)
+	signer := messaging.NewMessageSigner(messenger, privKey, func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	})
+	signer.SetAuditHandler(auditLog.RecordCommand)
+	registeredInputs := inputs.NewRegisteredInputs(domain, publisherID)
+	receiver := inputs.NewReceiveFromSetCommands(domain, publisherID, signer, registeredInputs)
+	receiver.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance, nil)
+	setAddr := inputs.MakeSetInputAddress(domain, publisherID, node1ID, types.InputTypeSwitch, types.DefaultInputInstance)
+	sender := domain + "/publisher2"
+
+	// accepted, rejected because unencrypted, rejected because the node is disabled
+	err = inputs.PublishSetInput(setAddr, "on", sender, signer, &privKey.PublicKey)
+	require.NoError(t, err)
+	signer.PublishObject(setAddr, false, types.SetInputMessage{Address: setAddr, Sender: sender, Value: "off"}, nil)
+	registeredInputs.SetNodeDisabled(node1ID, true)
+	inputs.PublishSetInput(setAddr, "on", sender, signer, &privKey.PublicKey)
+
+	records := readRecords(t, auditFile)
+	require.Equal(t, 3, len(records))
+	assert.True(t, records[0].Accepted)
+	assert.Equal(t, setAddr, records[0].Address)
+	assert.Equal(t, types.MessageTypeSetInput, records[0].Command)
+	assert.Equal(t, sender, records[0].Sender)
+	assert.True(t, records[0].Encrypted)
+	assert.True(t, records[0].Signed)
+	assert.Empty(t, records[0].Reason)
+	assert.False(t, records[1].Accepted)
+	assert.False(t, records[1].Encrypted)
+	assert.NotEmpty(t, records[1].Reason)
+	assert.False(t, records[2].Accepted)
+	assert.Contains(t, records[2].Reason, "disabled")
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	auditFile := path.Join(folder, "audit.log")
+	auditLog := audit.NewAuditLog(audit.AuditConfig{File: auditFile, MaxFileSize: 100, MaxFiles: 2})
+
+	// each record exceeds the max size and is rotated
+	for i := 0; i < 5; i++ {
+		auditLog.RecordCommand(domain+"/publisher1/$setIdentity", domain+"/$dss", true, true, errors.New("rejected"))
+	}
+	rotatedFiles := audit.ListRotatedFiles(auditFile)
+	assert.Equal(t, 2, len(rotatedFiles))
+	records := readRecords(t, rotatedFiles[1])
+	require.Equal(t, 1, len(records))
+	assert.Equal(t, types.MessageTypeSetIdentity, records[0].Command)
+	assert.Equal(t, "rejected", records[0].Reason)
+
+	// recording continues after closing in a new file
+	err = auditLog.Close()
+	assert.NoError(t, err)
+	auditLog2 := audit.NewAuditLog(audit.AuditConfig{File: auditFile})
+	auditLog2.RecordCommand(domain+"/publisher1/node1/$configure", domain+"/publisher2", true, true, nil)
+	auditLog2.Close()
+	records = readRecords(t, auditFile)
+	require.Equal(t, 1, len(records))
+	assert.True(t, records[0].Accepted)
+
+	// error case - the audit log file can't be created
+	auditLog3 := audit.NewAuditLog(audit.AuditConfig{File: path.Join(auditFile, "notafolder", "audit.log")})
+	err = auditLog3.Record(&audit.AuditRecord{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+// Package bridge with republishing of nodes from one domain into another domain
+package bridge
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// BridgedNodeIDSeparator separates the source publisher ID and node ID in the node ID of a
+// republished node
+const BridgedNodeIDSeparator = "."
+
+// IdentityFileSuffix to append to the name of the file containing the bridge identity in the target domain
+const IdentityFileSuffix = "-identity.json"
+
+// node level and input/output level message types that are republished
+var nodeMessageTypes = []string{types.MessageTypeNodeDiscovery, types.MessageTypeEvent}
+var ioMessageTypes = []string{types.MessageTypeOutputDiscovery, types.MessageTypeInputDiscovery,
+	types.MessageTypeLatest, types.MessageTypeHistory, types.MessageTypeForecast}
+
+// BridgeConfig with the domain to republish nodes in
+type BridgeConfig struct {
+	Domain    string                     `yaml:"domain"`    // domain to republish nodes in, eg cloud
+	Messenger *messaging.MessengerConfig `yaml:"messenger"` // optional message bus of the domain. Default is the message bus of the publisher
+	Nodes     []string                   `yaml:"nodes"`     // nodes to republish as publisherID/nodeID. Use + as wildcard
+}
+
+// DomainBridge republishes selected nodes of the source domain into a target domain. The bridge
+// participates in the target domain with its own identity and re-signs the republished messages.
+// Nodes are republished under the bridge publisher, with node ID <publisherID>.<nodeID>, so
+// consumers in the target domain can verify them using the bridge identity. Attach the bridge
+// to a publisher with AddIntegration.
+type DomainBridge struct {
+	config           BridgeConfig                   // bridge configuration
+	ownsMessenger    bool                           // the target messenger is created by the bridge
+	publisherID      string                         // ID of the bridge publisher in both domains
+	sourceDomain     string                         // domain of the nodes to republish
+	sourceSigner     *messaging.MessageSigner       // receive and verify messages from the source domain
+	targetIdentity   *identities.RegisteredIdentity // identity of the bridge in the target domain
+	targetMessenger  messaging.IMessenger           // message bus of the target domain
+	targetSigner     *messaging.MessageSigner       // sign messages republished in the target domain
+	isRunning        bool                           // the bridge is started
+	republishedCount int                            // nr of messages republished
+	updateMutex      *sync.Mutex                    // mutex for concurrent start and stop
+}
+
+// Domain returns the target domain of the bridge
+func (bridge *DomainBridge) Domain() string {
+	return bridge.config.Domain
+}
+
+// RepublishedCount returns the nr of messages republished into the target domain
+func (bridge *DomainBridge) RepublishedCount() int {
+	bridge.updateMutex.Lock()
+	defer bridge.updateMutex.Unlock()
+	return bridge.republishedCount
+}
+
+// Start publishes the bridge identity in the target domain and subscribes to the selected nodes
+// of the source domain
+func (bridge *DomainBridge) Start() error {
+	bridge.updateMutex.Lock()
+	defer bridge.updateMutex.Unlock()
+	if bridge.isRunning {
+		return nil
+	}
+	if bridge.ownsMessenger {
+		err := bridge.targetMessenger.Connect("", "")
+		if err != nil {
+			return lib.MakeErrorf("DomainBridge.Start: Unable to connect to the message bus of domain %s: %s",
+				bridge.config.Domain, err)
+		}
+	}
+	bridge.isRunning = true
+	fullIdentity, _ := bridge.targetIdentity.GetFullIdentity()
+	identities.PublishIdentity(&fullIdentity.PublisherIdentityMessage, bridge.targetSigner)
+	for _, address := range bridge.subscriptions() {
+		bridge.sourceSigner.Subscribe(address, bridge.handleSourceMessage)
+	}
+	logrus.Infof("DomainBridge.Start: Republishing nodes %s of domain %s into domain %s",
+		bridge.config.Nodes, bridge.sourceDomain, bridge.config.Domain)
+	return nil
+}
+
+// Stop republishing
+func (bridge *DomainBridge) Stop() {
+	bridge.updateMutex.Lock()
+	defer bridge.updateMutex.Unlock()
+	if !bridge.isRunning {
+		return
+	}
+	bridge.isRunning = false
+	for _, address := range bridge.subscriptions() {
+		bridge.sourceSigner.Unsubscribe(address, bridge.handleSourceMessage)
+	}
+	if bridge.ownsMessenger {
+		bridge.targetMessenger.Disconnect()
+	}
+}
+
+// handleSourceMessage verifies a message of a selected source node and republishes it in the
+// target domain, signed by the bridge. Cleared retained messages are cleared in the target domain.
+func (bridge *DomainBridge) handleSourceMessage(address string, message string) error {
+	targetAddress := MakeBridgedAddress(address, bridge.config.Domain, bridge.publisherID)
+	if targetAddress == "" {
+		return lib.MakeErrorf("DomainBridge.handleSourceMessage: Invalid address %s", address)
+	}
+	if message == "" {
+		return bridge.targetMessenger.Publish(targetAddress, true, "")
+	}
+	payload, err := bridge.verifySourceMessage(address, message)
+	if err != nil {
+		return lib.MakeErrorf("DomainBridge.handleSourceMessage: Message on %s is discarded: %s", address, err)
+	}
+	var object map[string]interface{}
+	err = json.Unmarshal([]byte(payload), &object)
+	if err != nil {
+		return lib.MakeErrorf("DomainBridge.handleSourceMessage: Message on %s is not a JSON object: %s", address, err)
+	}
+	object["address"] = targetAddress
+	if _, hasNodeID := object["nodeId"]; hasNodeID {
+		segments := strings.Split(targetAddress, "/")
+		object["nodeId"] = segments[2]
+	}
+	// discovery and values are retained so consumers in the target domain receive them on connect
+	err = bridge.targetSigner.PublishObject(targetAddress, true, object, nil)
+	if err == nil {
+		bridge.updateMutex.Lock()
+		bridge.republishedCount++
+		bridge.updateMutex.Unlock()
+	}
+	return err
+}
+
+// subscriptions returns the source domain addresses of the selected nodes
+func (bridge *DomainBridge) subscriptions() []string {
+	addresses := make([]string, 0)
+	for _, node := range bridge.config.Nodes {
+		segments := strings.Split(node, "/")
+		if len(segments) != 2 {
+			logrus.Warningf("DomainBridge.subscriptions: Node '%s' is not of the form publisherID/nodeID", node)
+			continue
+		}
+		nodeAddr := fmt.Sprintf("%s/%s/%s", bridge.sourceDomain, segments[0], segments[1])
+		for _, messageType := range nodeMessageTypes {
+			addresses = append(addresses, nodeAddr+"/"+messageType)
+		}
+		for _, messageType := range ioMessageTypes {
+			addresses = append(addresses, nodeAddr+"/+/+/"+messageType)
+		}
+	}
+	return addresses
+}
+
+// verifySourceMessage verifies the signature of a message from the source domain and returns
+// its payload. Unsigned messages are only accepted if the source domain doesn't sign messages.
+func (bridge *DomainBridge) verifySourceMessage(address string, message string) (payload string, err error) {
+	var publicKey *ecdsa.PublicKey
+	if bridge.sourceSigner.GetPublicKey != nil {
+		publicKey = bridge.sourceSigner.GetPublicKey(address)
+	}
+	if publicKey != nil {
+		payload, err = messaging.VerifyJWSMessage(message, publicKey)
+		if err == nil {
+			return payload, nil
+		}
+	}
+	if !bridge.sourceSigner.SignMessages() && strings.HasPrefix(strings.TrimSpace(message), "{") {
+		return message, nil
+	}
+	if publicKey == nil {
+		return "", fmt.Errorf("no public key for the publisher of %s", address)
+	}
+	return "", err
+}
+
+// MakeBridgedAddress returns the address of a source domain message republished by a bridge
+//  sourceAddress is the address in the source domain: domain/publisherID/nodeID/...
+//  domain is the target domain
+//  bridgeID is the publisher ID of the bridge
+// Returns "" if the source address has no node
+func MakeBridgedAddress(sourceAddress string, domain string, bridgeID string) string {
+	segments := strings.Split(sourceAddress, "/")
+	if len(segments) < 4 {
+		return ""
+	}
+	nodeID := segments[1] + BridgedNodeIDSeparator + segments[2]
+	targetSegments := append([]string{domain, bridgeID, nodeID}, segments[3:]...)
+	return strings.Join(targetSegments, "/")
+}
+
+// NewDomainBridge creates a bridge that republishes nodes into the configured domain
+//  config is the bridge configuration with the target domain and the nodes to republish
+//  sourceDomain is the domain of the publisher
+//  publisherID is the publisher ID of the bridge in both domains
+//  configFolder is the location of the bridge identity in the target domain
+//  messenger is the message bus of the publisher. Used for the target domain if no messenger is configured
+//  sourceSigner receives and verifies messages of the source domain
+func NewDomainBridge(config BridgeConfig, sourceDomain string, publisherID string, configFolder string,
+	messenger messaging.IMessenger, sourceSigner *messaging.MessageSigner) *DomainBridge {
+
+	targetMessenger := messenger
+	ownsMessenger := false
+	if config.Messenger != nil {
+		targetMessenger = messaging.NewMessenger(config.Messenger)
+		ownsMessenger = true
+	}
+	identityFile := path.Join(configFolder, publisherID+"-"+config.Domain+IdentityFileSuffix)
+	targetIdentity := identities.NewRegisteredIdentity(config.Domain, publisherID, identityFile)
+	_, privKey, err := targetIdentity.LoadIdentity()
+	if err != nil {
+		// save the identity as the loaded one isn't valid
+		targetIdentity.SaveIdentity()
+		privKey = targetIdentity.GetPrivateKey()
+	}
+	bridge := &DomainBridge{
+		config:          config,
+		ownsMessenger:   ownsMessenger,
+		publisherID:     publisherID,
+		sourceDomain:    sourceDomain,
+		sourceSigner:    sourceSigner,
+		targetIdentity:  targetIdentity,
+		targetMessenger: targetMessenger,
+		targetSigner:    messaging.NewMessageSigner(targetMessenger, privKey, nil),
+		updateMutex:     &sync.Mutex{},
+	}
+	return bridge
+}
@@ -0,0 +1,109 @@
+package bridge_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/bridge"
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sourceDomain = "local"
+const targetDomain = "cloud"
+const bridgeID = "bridge1"
+
+func TestMakeBridgedAddress(t *testing.T) {
+	addr := bridge.MakeBridgedAddress("local/pub1/node1/temperature/0/$latest", targetDomain, bridgeID)
+	assert.Equal(t, "cloud/bridge1/pub1.node1/temperature/0/$latest", addr)
+	addr = bridge.MakeBridgedAddress("local/pub1/node1", targetDomain, bridgeID)
+	assert.Equal(t, "", addr)
+}
+
+func TestDomainBridge(t *testing.T) {
+	configFolder, _ := ioutil.TempDir("", "bridge")
+	defer os.RemoveAll(configFolder)
+
+	sourceKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &sourceKey.PublicKey
+	}
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	sourceSigner := messaging.NewMessageSigner(messenger, sourceKey, getPubKey)
+
+	domainBridge := bridge.NewDomainBridge(bridge.BridgeConfig{
+		Domain: targetDomain,
+		Nodes:  []string{"pub1/node1", "+/node2"},
+	}, sourceDomain, bridgeID, configFolder, messenger, sourceSigner)
+	assert.Equal(t, targetDomain, domainBridge.Domain())
+	err := domainBridge.Start()
+	require.NoError(t, err)
+
+	// the bridge identity in the target domain is saved and published
+	bridgeIdentity := identities.NewRegisteredIdentity(targetDomain, bridgeID,
+		path.Join(configFolder, bridgeID+"-"+targetDomain+bridge.IdentityFileSuffix))
+	_, bridgeKey, err := bridgeIdentity.LoadIdentity()
+	require.NoError(t, err)
+	identAddr := identities.MakePublisherIdentityAddress(targetDomain, bridgeID)
+	assert.NotEmpty(t, messenger.FindLastPublication(identAddr))
+
+	// selected nodes and outputs are republished and signed by the bridge
+	node1 := nodes.NewNode(sourceDomain, "pub1", "node1", types.NodeTypeMultisensor)
+	sourceSigner.PublishObject(node1.Address, true, node1, nil)
+	latestAddr := outputs.ReplaceMessageType(outputs.MakeOutputDiscoveryAddress(sourceDomain, "pub1", "node1",
+		types.OutputTypeTemperature, types.DefaultOutputInstance), types.MessageTypeLatest)
+	sourceSigner.PublishObject(latestAddr, true, &types.OutputLatestMessage{Address: latestAddr, Value: "21"}, nil)
+
+	targetNodeAddr := bridge.MakeBridgedAddress(node1.Address, targetDomain, bridgeID)
+	raw := messenger.FindLastPublication(targetNodeAddr)
+	require.NotEmpty(t, raw)
+	payload, err := messaging.VerifyJWSMessage(raw, &bridgeKey.PublicKey)
+	require.NoError(t, err)
+	var targetNode types.NodeDiscoveryMessage
+	err = json.Unmarshal([]byte(payload), &targetNode)
+	require.NoError(t, err)
+	assert.Equal(t, targetNodeAddr, targetNode.Address)
+	assert.Equal(t, "pub1.node1", targetNode.NodeID)
+	assert.Equal(t, node1.HWID, targetNode.HWID)
+
+	targetLatestAddr := bridge.MakeBridgedAddress(latestAddr, targetDomain, bridgeID)
+	raw = messenger.FindLastPublication(targetLatestAddr)
+	payload, err = messaging.VerifyJWSMessage(raw, &bridgeKey.PublicKey)
+	require.NoError(t, err)
+	var latest types.OutputLatestMessage
+	json.Unmarshal([]byte(payload), &latest)
+	assert.Equal(t, "21", latest.Value)
+	assert.Equal(t, targetLatestAddr, latest.Address)
+	assert.Equal(t, 2, domainBridge.RepublishedCount())
+
+	// nodes that are not selected are not republished
+	node3 := nodes.NewNode(sourceDomain, "pub1", "node3", types.NodeTypeMultisensor)
+	sourceSigner.PublishObject(node3.Address, true, node3, nil)
+	assert.Equal(t, 2, domainBridge.RepublishedCount())
+
+	// messages with an invalid signature are not republished
+	node2Addr := nodes.MakeNodeDiscoveryAddress(sourceDomain, "pub2", "node2")
+	otherSigner := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), nil)
+	otherSigner.PublishObject(node2Addr, true, nodes.NewNode(sourceDomain, "pub2", "node2", types.NodeTypeAlarm), nil)
+	assert.Equal(t, 2, domainBridge.RepublishedCount())
+	assert.Empty(t, messenger.FindLastPublication(bridge.MakeBridgedAddress(node2Addr, targetDomain, bridgeID)))
+
+	// a removed node is removed from the target domain
+	messenger.Publish(node1.Address, true, "")
+	assert.Empty(t, messenger.FindLastPublication(targetNodeAddr))
+
+	// no republishing after stop
+	domainBridge.Stop()
+	sourceSigner.PublishObject(node1.Address, true, node1, nil)
+	assert.Empty(t, messenger.FindLastPublication(targetNodeAddr))
+	assert.Equal(t, 2, domainBridge.RepublishedCount())
+}
@@ -0,0 +1,88 @@
+// Package main with the fleetmon service that reports the health of the publishers of a domain
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/monitor"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// AppID is the publisher ID of the fleet monitor, and the name of its configuration file fleetmon.yaml
+const AppID = "fleetmon"
+
+const usage = `Usage: fleetmon [options]
+
+Monitors the $identity and $status of the publishers of a domain and publishes a fleet health
+report on domain/fleetmon/$fleethealth. With -l the report is also served over HTTP, responding
+with status 503 while a publisher is offline or silent. Options override the fleetMonitor section
+of fleetmon.yaml in the configuration folder.
+
+Options:
+`
+
+func main() {
+	flags := flag.NewFlagSet(AppID, flag.ExitOnError)
+	configFolder := flags.String("c", "", "configuration folder with messenger.yaml. Default is ~/.config/iotdomain")
+	domain := flags.String("d", "", "domain to monitor. Default is the domain from the messenger configuration")
+	listenAddress := flags.String("l", "", "listen address of the HTTP report, eg localhost:9679")
+	threshold := flags.Int("t", monitor.DefaultOfflineThreshold, "seconds after which a publisher that isn't seen is silent")
+	interval := flags.Int("i", monitor.DefaultReportInterval, "seconds between publishing the report")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	var messengerConfig = messaging.MessengerConfig{}
+	err := lib.LoadMessengerConfig(*configFolder, &messengerConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load the messenger configuration: %s\n", err)
+		os.Exit(1)
+	}
+	if *configFolder == "" {
+		messaging.ResolveTLSFiles(&messengerConfig, lib.DefaultConfigFolder)
+	} else {
+		messaging.ResolveTLSFiles(&messengerConfig, *configFolder)
+	}
+	pubConfig := &publisher.PublisherConfig{
+		ConfigFolder: *configFolder,
+		CacheFolder:  lib.DefaultCacheFolder,
+		Loglevel:     "warning",
+		Domain:       messengerConfig.Domain,
+		PublisherID:  AppID,
+	}
+	lib.LoadAppConfig(*configFolder, AppID, &pubConfig)
+	monitorConfig := struct {
+		FleetMonitor monitor.FleetMonitorConfig `yaml:"fleetMonitor"`
+	}{}
+	lib.LoadAppConfig(*configFolder, AppID, &monitorConfig)
+	// options that are given override the configuration file
+	flags.Visit(func(option *flag.Flag) {
+		switch option.Name {
+		case "d":
+			pubConfig.Domain = *domain
+		case "l":
+			monitorConfig.FleetMonitor.ListenAddress = *listenAddress
+		case "t":
+			monitorConfig.FleetMonitor.OfflineThreshold = *threshold
+		case "i":
+			monitorConfig.FleetMonitor.ReportInterval = *interval
+		}
+	})
+
+	pub := publisher.NewPublisher(pubConfig, messaging.NewMessenger(&messengerConfig))
+	fleetMonitor := monitor.NewFleetMonitor(pub.Domain(), pub.PublisherID(), monitorConfig.FleetMonitor, pub.MessageSigner())
+	pub.AddIntegration(fleetMonitor)
+	fleetMonitor.SetHealthHandler(func(health types.FleetPublisherHealth) {
+		logrus.Warningf("Publisher %s is %s (status %s)", health.Address, health.Health, health.Status)
+	})
+	pub.Run(context.Background())
+}
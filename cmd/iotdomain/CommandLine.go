@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// CommandLine runs the commands of the commandline tool using a publisher to access the domain
+type CommandLine struct {
+	domain string               // domain to browse
+	out    io.Writer            // output of the commands
+	pub    *publisher.Publisher // publisher for discovery and sending commands
+	wait   time.Duration        // time to wait for discovery and values
+}
+
+// Run the command with its arguments
+func (cli *CommandLine) Run(args []string) error {
+	if len(args) == 0 {
+		return errors.New("Missing command")
+	}
+	command, params := args[0], args[1:]
+	publisherID := ""
+	if len(params) > 0 {
+		publisherID = params[0]
+	}
+	switch command {
+	case "publishers":
+		cli.discover("")
+		return cli.ListPublishers()
+	case "nodes":
+		cli.discover(publisherID)
+		return cli.ListNodes(publisherID)
+	case "inputs":
+		cli.discover(publisherID)
+		return cli.ListInputs(publisherID)
+	case "outputs":
+		cli.discover(publisherID)
+		return cli.ListOutputs(publisherID)
+	case "latest":
+		if len(params) != 1 {
+			return errors.New("Usage: latest <outputAddress>")
+		}
+		return cli.ShowLatest(params[0])
+	case "history":
+		if len(params) != 1 {
+			return errors.New("Usage: history <outputAddress>")
+		}
+		return cli.ShowHistory(params[0])
+	case "set":
+		if len(params) != 2 {
+			return errors.New("Usage: set <inputAddress> <value>")
+		}
+		cli.discover("")
+		return cli.pub.PublishSetInput(MakeValueAddress(params[0], types.MessageTypeSetInput), params[1])
+	case "configure":
+		if len(params) < 2 {
+			return errors.New("Usage: configure <nodeAddress> <attr=value>")
+		}
+		attr, err := ParseAttrs(params[1:])
+		if err != nil {
+			return err
+		}
+		cli.discover("")
+		if !cli.pub.PublishNodeConfigure(params[0], attr) {
+			return lib.MakeErrorf("configure: The publisher of node %s is not known", params[0])
+		}
+		return nil
+	case "watch":
+		return cli.Watch(publisherID)
+	}
+	return lib.MakeErrorf("Unknown command '%s'", command)
+}
+
+// ListInputs lists the discovered inputs, optionally of a single publisher
+func (cli *CommandLine) ListInputs(publisherID string) error {
+	table := cli.newTable("ADDRESS", "DATATYPE", "UNIT", "SOURCE")
+	for _, input := range cli.pub.GetDomainInputs() {
+		if isOfPublisher(input.Address, publisherID) {
+			table.addRow(input.Address, string(input.DataType), string(input.Unit), input.Source)
+		}
+	}
+	return table.print()
+}
+
+// ListNodes lists the discovered nodes, optionally of a single publisher
+func (cli *CommandLine) ListNodes(publisherID string) error {
+	table := cli.newTable("ADDRESS", "TYPE", "NAME", "LAST SEEN")
+	for _, node := range cli.pub.GetDomainNodes() {
+		if isOfPublisher(node.Address, publisherID) {
+			table.addRow(node.Address, node.Attr[types.NodeAttrType], node.Attr[types.NodeAttrName], node.Timestamp)
+		}
+	}
+	return table.print()
+}
+
+// ListOutputs lists the discovered outputs, optionally of a single publisher
+func (cli *CommandLine) ListOutputs(publisherID string) error {
+	table := cli.newTable("ADDRESS", "DATATYPE", "UNIT")
+	for _, output := range cli.pub.GetDomainOutputs() {
+		if isOfPublisher(output.Address, publisherID) {
+			table.addRow(output.Address, string(output.DataType), string(output.Unit))
+		}
+	}
+	return table.print()
+}
+
+// ListPublishers lists the discovered publishers
+func (cli *CommandLine) ListPublishers() error {
+	table := cli.newTable("ADDRESS", "ORGANIZATION", "ISSUER", "VALID UNTIL")
+	for _, ident := range cli.pub.GetDomainPublishers() {
+		if isOfPublisher(ident.Address, "") {
+			table.addRow(ident.Address, ident.Organization, ident.IssuerID, ident.ValidUntil)
+		}
+	}
+	return table.print()
+}
+
+// ShowHistory shows the history of an output, newest value first
+func (cli *CommandLine) ShowHistory(outputAddress string) error {
+	received := make(chan *types.OutputHistoryMessage, 1)
+	historyAddress := MakeValueAddress(outputAddress, types.MessageTypeHistory)
+	cli.pub.SubscribeToOutputHistory(historyAddress, func(history *types.OutputHistoryMessage) {
+		select {
+		case received <- history:
+		default:
+		}
+	})
+	select {
+	case history := <-received:
+		table := cli.newTable("TIMESTAMP", "VALUE")
+		for _, value := range history.History {
+			table.addRow(value.Timestamp, value.Value+" "+string(history.Unit))
+		}
+		table.sorted = false
+		return table.print()
+	case <-time.After(cli.wait):
+		return lib.MakeErrorf("history: No history received on %s", historyAddress)
+	}
+}
+
+// ShowLatest shows the latest value of an output
+func (cli *CommandLine) ShowLatest(outputAddress string) error {
+	received := make(chan *types.OutputLatestMessage, 1)
+	latestAddress := MakeValueAddress(outputAddress, types.MessageTypeLatest)
+	cli.pub.SubscribeToOutputLatest(latestAddress, func(latest *types.OutputLatestMessage) {
+		select {
+		case received <- latest:
+		default:
+		}
+	})
+	select {
+	case latest := <-received:
+		fmt.Fprintf(cli.out, "%s %s %s\n", latest.Timestamp, latest.Value, latest.Unit)
+		return nil
+	case <-time.After(cli.wait):
+		return lib.MakeErrorf("latest: No value received on %s", latestAddress)
+	}
+}
+
+// Watch shows the output values of the domain, optionally of a single publisher, as they are published
+// until the process is interrupted.
+func (cli *CommandLine) Watch(publisherID string) error {
+	if publisherID == "" {
+		publisherID = "+"
+	}
+	latestAddress := fmt.Sprintf("%s/%s/+/+/+/%s", cli.domain, publisherID, types.MessageTypeLatest)
+	cli.pub.SubscribeToOutputLatest(latestAddress, func(latest *types.OutputLatestMessage) {
+		fmt.Fprintf(cli.out, "%s %s: %s %s\n", latest.Timestamp, latest.Address, latest.Value, latest.Unit)
+	})
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, syscall.SIGINT, syscall.SIGTERM)
+	<-interrupted
+	return nil
+}
+
+// discover subscribes to the domain discovery and waits for the retained discovery messages
+func (cli *CommandLine) discover(publisherID string) {
+	cli.pub.Subscribe(cli.domain, publisherID)
+	time.Sleep(cli.wait)
+}
+
+// isOfPublisher returns true if the address is of the domain and publisher, or of any publisher if "".
+func isOfPublisher(address string, publisherID string) bool {
+	segments := strings.Split(address, "/")
+	return len(segments) > 1 && (publisherID == "" || segments[1] == publisherID)
+}
+
+// MakeValueAddress returns the address of the message type of an input or output
+// The address can be the discovery address or the address without message type.
+func MakeValueAddress(address string, messageType string) string {
+	segments := strings.Split(address, "/")
+	if len(segments) > 5 {
+		segments = segments[:5]
+	}
+	return strings.Join(append(segments, messageType), "/")
+}
+
+// ParseAttrs parses a list of attr=value arguments into an attribute map
+func ParseAttrs(args []string) (types.NodeAttrMap, error) {
+	attr := make(types.NodeAttrMap)
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, lib.MakeErrorf("ParseAttrs: Invalid attribute '%s'. Expected attr=value", arg)
+		}
+		attr[types.NodeAttr(parts[0])] = parts[1]
+	}
+	return attr, nil
+}
+
+// table for printing aligned columns, sorted by the first column
+type table struct {
+	out    io.Writer
+	header []string
+	rows   [][]string
+	sorted bool
+}
+
+func (cli *CommandLine) newTable(header ...string) *table {
+	return &table{out: cli.out, header: header, rows: make([][]string, 0), sorted: true}
+}
+
+func (t *table) addRow(columns ...string) {
+	t.rows = append(t.rows, columns)
+}
+
+func (t *table) print() error {
+	if t.sorted {
+		sort.Slice(t.rows, func(i, j int) bool { return t.rows[i][0] < t.rows[j][0] })
+	}
+	writer := tabwriter.NewWriter(t.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(t.header, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	return writer.Flush()
+}
+
+// NewCommandLine creates the commandline tool
+//  pub is the started publisher used to access the domain
+//  domain is the domain to browse
+//  wait is the time to wait for discovery and values
+func NewCommandLine(pub *publisher.Publisher, domain string, wait time.Duration, out io.Writer) *CommandLine {
+	cli := &CommandLine{
+		domain: domain,
+		out:    out,
+		pub:    pub,
+		wait:   wait,
+	}
+	return cli
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const node1ID = "node1"
+
+var outputAddr = "test/publisher1/node1/temperature/0/$output"
+
+func newTestPublisher(publisherID string, configFolder string, messenger messaging.IMessenger) *publisher.Publisher {
+	config := &publisher.PublisherConfig{
+		ConfigFolder: configFolder,
+		Domain:       "test",
+		PublisherID:  publisherID,
+	}
+	return publisher.NewPublisher(config, messenger)
+}
+
+func TestParseAttrs(t *testing.T) {
+	attr, err := ParseAttrs([]string{"name=kitchen", "description=a=b"})
+	require.NoError(t, err)
+	assert.Equal(t, "kitchen", attr[types.NodeAttrName])
+	assert.Equal(t, "a=b", attr[types.NodeAttrDescription])
+
+	_, err = ParseAttrs([]string{"name"})
+	assert.Error(t, err)
+	_, err = ParseAttrs([]string{"=value"})
+	assert.Error(t, err)
+}
+
+func TestMakeValueAddress(t *testing.T) {
+	assert.Equal(t, "test/publisher1/node1/temperature/0/$latest",
+		MakeValueAddress(outputAddr, types.MessageTypeLatest))
+	assert.Equal(t, "test/publisher1/node1/temperature/0/$latest",
+		MakeValueAddress("test/publisher1/node1/temperature/0", types.MessageTypeLatest))
+}
+
+func TestCommands(t *testing.T) {
+	configFolder, _ := ioutil.TempDir("", "iotdomain-cli")
+	defer os.RemoveAll(configFolder)
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	pub1 := newTestPublisher("publisher1", configFolder, messenger)
+	cliPub := newTestPublisher(AppID, configFolder, messenger)
+	out := &bytes.Buffer{}
+	cli := NewCommandLine(cliPub, "test", 10*time.Millisecond, out)
+	// the cli must receive the identity of publisher1 to verify its messages
+	cliPub.Start()
+	cliPub.Subscribe("test", "")
+	pub1.Start()
+
+	pub1.CreateNode(node1ID, types.NodeTypeMultisensor)
+	pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.PublishUpdates()
+
+	err := cli.Run([]string{"nodes"})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "test/publisher1/node1/$node")
+	out.Reset()
+	err = cli.Run([]string{"outputs", "publisher1"})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), outputAddr)
+	out.Reset()
+	err = cli.Run([]string{"outputs", "publisher2"})
+	assert.NoError(t, err)
+	assert.NotContains(t, out.String(), outputAddr)
+	err = cli.Run([]string{"publishers"})
+	assert.NoError(t, err)
+
+	// values are received after the command subscribed
+	go func() {
+		time.Sleep(2 * time.Millisecond)
+		pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "21.5")
+		pub1.PublishUpdates()
+	}()
+	out.Reset()
+	cli.wait = time.Second
+	err = cli.Run([]string{"latest", outputAddr})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "21.5")
+
+	// errors
+	cli.wait = 10 * time.Millisecond
+	err = cli.Run([]string{"history", "test/publisher1/node1/humidity/0"})
+	assert.Error(t, err)
+	err = cli.Run([]string{"set", "test/publisher1/node1/switch/0"})
+	assert.Error(t, err)
+	err = cli.Run([]string{"configure", "test/publisher1/node1", "name"})
+	assert.Error(t, err)
+	err = cli.Run([]string{"unknown"})
+	assert.Error(t, err)
+	err = cli.Run([]string{})
+	assert.Error(t, err)
+
+	cliPub.Stop()
+	pub1.Stop()
+}
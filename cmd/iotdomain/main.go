@@ -0,0 +1,67 @@
+// Package main with the iotdomain commandline tool for browsing and controlling a domain
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+)
+
+// AppID is the publisher ID used by the commandline tool
+const AppID = "iotdomain-cli"
+
+// DefaultDiscoveryWait is the default time in seconds to wait for discovery before running a command
+const DefaultDiscoveryWait = 3
+
+const usage = `Usage: iotdomain [options] <command> [arguments]
+
+Commands:
+  publishers                     list the discovered publishers
+  nodes [publisherID]            list the discovered nodes
+  inputs [publisherID]           list the discovered inputs
+  outputs [publisherID]          list the discovered outputs
+  latest <outputAddress>         show the latest value of an output
+  history <outputAddress>        show the history of an output
+  set <inputAddress> <value>     send a signed and encrypted $set command to an input
+  configure <nodeAddress> <attr=value>...
+                                 send a signed and encrypted $configure command to a node
+  watch [publisherID]            show output values as they are published, until interrupted
+
+Options:
+`
+
+func main() {
+	flags := flag.NewFlagSet("iotdomain", flag.ExitOnError)
+	configFolder := flags.String("c", "", "configuration folder with messenger.yaml. Default is ~/.config/iotdomain")
+	domain := flags.String("d", "", "domain to browse. Default is the domain from the messenger configuration")
+	wait := flags.Int("w", DefaultDiscoveryWait, "seconds to wait for discovery and values")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	pub, err := publisher.NewAppPublisher(AppID, *configFolder, nil, "", false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load the messenger configuration: %s\n", err)
+		os.Exit(1)
+	}
+	if *domain == "" {
+		*domain = pub.Domain()
+	}
+	cli := NewCommandLine(pub, *domain, time.Duration(*wait)*time.Second, os.Stdout)
+	pub.Start()
+	err = cli.Run(flags.Args())
+	pub.Stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,52 @@
+// Package main with the simpub publisher of simulated nodes for load testing, integration testing and demos
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/simulator"
+)
+
+// AppID is the publisher ID of the simulator. Its configuration is loaded from simpub.yaml.
+const AppID = "simpub"
+
+func main() {
+	simConfig := simulator.SimulatorConfig{}
+	flags := flag.NewFlagSet(AppID, flag.ExitOnError)
+	configFolder := flags.String("c", "", "configuration folder with messenger.yaml and simpub.yaml. Default is ~/.config/iotdomain")
+	nodeCount := flags.Int("nodes", 0, "number of simulated nodes. Overrides simpub.yaml")
+	interval := flags.Int("interval", 0, "seconds between value updates. Overrides simpub.yaml")
+	faultRate := flags.Float64("faults", 0, "probability 0-1 that a node fails each interval. Overrides simpub.yaml")
+	seed := flags.Int64("seed", 0, "seed of the random values for reproducible runs. Overrides simpub.yaml")
+	flags.Parse(os.Args[1:])
+
+	pub, err := publisher.NewAppPublisher(AppID, *configFolder, &simConfig, "", false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load the messenger configuration: %s\n", err)
+		os.Exit(1)
+	}
+	// commandline flags override the configuration file
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "nodes":
+			simConfig.NodeCount = *nodeCount
+		case "interval":
+			simConfig.Interval = *interval
+		case "faults":
+			simConfig.FaultRate = *faultRate
+		case "seed":
+			simConfig.Seed = *seed
+		}
+	})
+	sim := simulator.NewSimulator(pub, simConfig)
+	sim.Start()
+	pub.AddShutdownHook("simulator", func() error {
+		sim.Stop()
+		return nil
+	})
+	pub.Run(context.Background())
+}
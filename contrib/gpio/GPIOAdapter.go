@@ -0,0 +1,248 @@
+// Package gpio with a publisher adapter that exposes GPIO pins as switch inputs and outputs
+package gpio
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// GPIO adapter defaults
+const (
+	DefaultNodeHWID       = "gpio" // hardware ID of the node with the pins
+	DefaultPollMillis     = 10     // milliseconds between reading the pins
+	DefaultDebounceMillis = 50     // milliseconds a pin must be stable before its change is published
+)
+
+// Pin directions
+const (
+	PinDirectionIn  = "in"  // pin is read, eg a button or sensor, and published as a switch output
+	PinDirectionOut = "out" // pin is written, eg a relay, and controlled with a switch input
+)
+
+// Switch values of the inputs and outputs
+const (
+	SwitchOff = "off"
+	SwitchOn  = "on"
+)
+
+// PinConfig with the configuration of a GPIO pin
+type PinConfig struct {
+	Pin       int    `yaml:"pin"`       // GPIO pin number
+	Name      string `yaml:"name"`      // name of the pin used as input/output instance. Default is the pin number
+	Direction string `yaml:"direction"` // PinDirectionIn or PinDirectionOut. Default is in
+	ActiveLow bool   `yaml:"activeLow"` // the pin is on when its level is low, eg a button to ground
+}
+
+// GPIOConfig with the pins of the GPIO adapter
+type GPIOConfig struct {
+	NodeHWID       string      `yaml:"nodeHWID"`       // hardware ID of the node. Default is DefaultNodeHWID
+	Pins           []PinConfig `yaml:"pins"`           // pins to expose
+	PollMillis     int         `yaml:"pollMillis"`     // interval of reading the pins. Default is DefaultPollMillis
+	DebounceMillis int         `yaml:"debounceMillis"` // debounce time of pins that are read. Default is DefaultDebounceMillis
+	SysfsRoot      string      `yaml:"sysfsRoot"`      // sysfs GPIO folder. Default is DefaultSysfsRoot
+}
+
+// pinState holds the debounce state of a pin that is read
+type pinState struct {
+	candidate bool      // last level read
+	changed   time.Time // time the candidate level was first read
+	published bool      // a stable level has been published
+	stable    bool      // the last published level
+}
+
+// GPIOAdapter publishes GPIO pins as a node. Pins that are read are published as switch outputs
+// after debouncing. Pins that are written are controlled with a switch input and report their
+// state with a switch output of the same instance.
+type GPIOAdapter struct {
+	config      GPIOConfig
+	driver      PinDriver            // access to the pins
+	pub         *publisher.Publisher // publisher of the GPIO node
+	states      map[int]*pinState    // debounce state of pins that are read, by pin number
+	stopChannel chan bool            // stop polling the pins
+	updateMutex *sync.Mutex          // mutex for concurrent polling
+}
+
+// Poll reads the input pins and publishes the levels that are stable for the debounce time
+// This is invoked at the poll interval after Start.
+//  now is the time of reading the pins
+func (adapter *GPIOAdapter) Poll(now time.Time) {
+	debounce := time.Duration(adapter.config.DebounceMillis) * time.Millisecond
+	changes := make(map[string]bool)
+
+	adapter.updateMutex.Lock()
+	for _, pinConfig := range adapter.config.Pins {
+		state := adapter.states[pinConfig.Pin]
+		if state == nil {
+			continue
+		}
+		level, err := adapter.driver.Read(pinConfig.Pin)
+		if err != nil {
+			logrus.Warningf("GPIOAdapter.Poll: %s", err)
+			continue
+		}
+		isOn := level != pinConfig.ActiveLow
+		if isOn != state.candidate || state.changed.IsZero() {
+			state.candidate = isOn
+			state.changed = now
+		}
+		// the initial level is published without delay
+		if !state.published || (state.candidate != state.stable && now.Sub(state.changed) >= debounce) {
+			state.stable = state.candidate
+			state.published = true
+			changes[pinInstance(pinConfig)] = state.stable
+		}
+	}
+	adapter.updateMutex.Unlock()
+
+	for instance, isOn := range changes {
+		adapter.pub.UpdateOutputValue(adapter.config.NodeHWID, types.OutputTypeSwitch, instance, switchValue(isOn))
+	}
+}
+
+// SetPin sets the level of a pin that is written and publishes its new state
+//  instance is the name of the pin
+//  isOn sets the pin on, taking ActiveLow into account
+func (adapter *GPIOAdapter) SetPin(instance string, isOn bool) error {
+	for _, pinConfig := range adapter.config.Pins {
+		if pinInstance(pinConfig) == instance && pinConfig.Direction == PinDirectionOut {
+			err := adapter.driver.Write(pinConfig.Pin, isOn != pinConfig.ActiveLow)
+			if err != nil {
+				adapter.pub.UpdateNodeErrorStatus(adapter.config.NodeHWID, types.NodeRunStateError, err.Error())
+				return err
+			}
+			adapter.pub.UpdateOutputValue(adapter.config.NodeHWID, types.OutputTypeSwitch, instance, switchValue(isOn))
+			return nil
+		}
+	}
+	return lib.MakeErrorf("SetPin: '%s' is not a GPIO output pin", instance)
+}
+
+// Start reading the input pins at the configured poll interval
+// The publisher must be started separately.
+func (adapter *GPIOAdapter) Start() {
+	adapter.updateMutex.Lock()
+	defer adapter.updateMutex.Unlock()
+	if adapter.stopChannel != nil {
+		return
+	}
+	adapter.stopChannel = make(chan bool)
+	go adapter.pollLoop(adapter.stopChannel)
+}
+
+// Stop reading the input pins
+func (adapter *GPIOAdapter) Stop() {
+	adapter.updateMutex.Lock()
+	defer adapter.updateMutex.Unlock()
+	if adapter.stopChannel != nil {
+		close(adapter.stopChannel)
+		adapter.stopChannel = nil
+	}
+}
+
+// handleSetCommand sets the pin of a switch input
+func (adapter *GPIOAdapter) handleSetCommand(input *types.InputDiscoveryMessage, sender string, value string) {
+	isOn, err := parseSwitchValue(value)
+	if err != nil {
+		logrus.Warningf("GPIOAdapter.handleSetCommand: Invalid value '%s' from %s for input %s", value, sender, input.Address)
+		return
+	}
+	adapter.SetPin(input.Instance, isOn)
+}
+
+// pollLoop reads the input pins until the stop channel is closed
+func (adapter *GPIOAdapter) pollLoop(stopChannel chan bool) {
+	ticker := time.NewTicker(time.Duration(adapter.config.PollMillis) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChannel:
+			return
+		case now := <-ticker.C:
+			adapter.Poll(now)
+		}
+	}
+}
+
+// parseSwitchValue returns true if the value is on. Accepted values are on/off, true/false and 1/0.
+func parseSwitchValue(value string) (bool, error) {
+	switch value {
+	case SwitchOn:
+		return true, nil
+	case SwitchOff:
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// pinInstance returns the input/output instance of a pin
+func pinInstance(pinConfig PinConfig) string {
+	if pinConfig.Name != "" {
+		return pinConfig.Name
+	}
+	return strconv.Itoa(pinConfig.Pin)
+}
+
+// switchValue returns the switch value of a pin level
+func switchValue(isOn bool) string {
+	if isOn {
+		return SwitchOn
+	}
+	return SwitchOff
+}
+
+// NewGPIOAdapter creates the GPIO node with the inputs and outputs of the configured pins in the
+// publisher. Pins that can't be opened are skipped and reported in the node error status.
+// Use Start() to start reading the pins.
+//  driver accesses the pins, nil to use the sysfs driver
+func NewGPIOAdapter(pub *publisher.Publisher, config GPIOConfig, driver PinDriver) *GPIOAdapter {
+	if config.NodeHWID == "" {
+		config.NodeHWID = DefaultNodeHWID
+	}
+	if config.PollMillis <= 0 {
+		config.PollMillis = DefaultPollMillis
+	}
+	if config.DebounceMillis <= 0 {
+		config.DebounceMillis = DefaultDebounceMillis
+	}
+	if driver == nil {
+		driver = NewSysfsDriver(config.SysfsRoot)
+	}
+	adapter := &GPIOAdapter{
+		config:      config,
+		driver:      driver,
+		pub:         pub,
+		states:      make(map[int]*pinState),
+		updateMutex: &sync.Mutex{},
+	}
+	pub.CreateNode(config.NodeHWID, types.NodeTypeGateway)
+	pub.UpdateNodeAttr(config.NodeHWID, types.NodeAttrMap{
+		types.NodeAttrName: "GPIO",
+	})
+	for _, pinConfig := range config.Pins {
+		isOutput := pinConfig.Direction == PinDirectionOut
+		err := driver.Open(pinConfig.Pin, isOutput)
+		if err != nil {
+			logrus.Errorf("NewGPIOAdapter: %s", err)
+			pub.UpdateNodeErrorStatus(config.NodeHWID, types.NodeRunStateError, err.Error())
+			continue
+		}
+		instance := pinInstance(pinConfig)
+		pub.CreateOutput(config.NodeHWID, types.OutputTypeSwitch, instance)
+		if isOutput {
+			pub.CreateInput(config.NodeHWID, types.InputTypeSwitch, instance, adapter.handleSetCommand)
+			// report the current state of the pin
+			if level, err := driver.Read(pinConfig.Pin); err == nil {
+				pub.UpdateOutputValue(config.NodeHWID, types.OutputTypeSwitch, instance, switchValue(level != pinConfig.ActiveLow))
+			}
+		} else {
+			adapter.states[pinConfig.Pin] = &pinState{}
+		}
+	}
+	return adapter
+}
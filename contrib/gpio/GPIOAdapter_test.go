@@ -0,0 +1,142 @@
+package gpio_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/contrib/gpio"
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSysfs creates a sysfs GPIO folder with the given pins exported
+func newTestSysfs(t *testing.T, pins ...string) string {
+	root, err := ioutil.TempDir("", "gpio")
+	require.NoError(t, err)
+	for _, pin := range pins {
+		err = os.MkdirAll(path.Join(root, "gpio"+pin), 0755)
+		require.NoError(t, err)
+		setLevel(t, root, pin, "0")
+	}
+	return root
+}
+
+// setLevel sets the level of a pin in the sysfs folder
+func setLevel(t *testing.T, root string, pin string, level string) {
+	err := ioutil.WriteFile(path.Join(root, "gpio"+pin, "value"), []byte(level+"\n"), 0644)
+	require.NoError(t, err)
+}
+
+func getSwitch(pub *publisher.Publisher, instance string) string {
+	value := pub.GetOutputValueByNodeHWID(gpio.DefaultNodeHWID, types.OutputTypeSwitch, instance)
+	if value == nil {
+		return ""
+	}
+	return value.Value
+}
+
+func TestDebouncedInput(t *testing.T) {
+	configFolder, err := ioutil.TempDir("", "gpiopub")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+	pub := publisher.NewPublisher(&publisher.PublisherConfig{
+		ConfigFolder: configFolder,
+		Domain:       "test",
+		PublisherID:  "gpiopub",
+	}, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+	root := newTestSysfs(t, "17")
+	defer os.RemoveAll(root)
+	adapter := gpio.NewGPIOAdapter(pub, gpio.GPIOConfig{
+		Pins:      []gpio.PinConfig{{Pin: 17, Name: "button", ActiveLow: true}},
+		SysfsRoot: root,
+	}, nil)
+	require.NotNil(t, pub.GetOutputByNodeHWID(gpio.DefaultNodeHWID, types.OutputTypeSwitch, "button"))
+	direction, _ := ioutil.ReadFile(path.Join(root, "gpio17", "direction"))
+	assert.Equal(t, gpio.PinDirectionIn, string(direction))
+
+	// the initial level is published immediately, low is on for an active low pin
+	start := time.Now()
+	adapter.Poll(start)
+	assert.Equal(t, gpio.SwitchOn, getSwitch(pub, "button"))
+
+	// a bounce shorter than the debounce time is ignored
+	setLevel(t, root, "17", "1")
+	adapter.Poll(start.Add(10 * time.Millisecond))
+	setLevel(t, root, "17", "0")
+	adapter.Poll(start.Add(20 * time.Millisecond))
+	setLevel(t, root, "17", "1")
+	adapter.Poll(start.Add(30 * time.Millisecond))
+	adapter.Poll(start.Add(60 * time.Millisecond))
+	assert.Equal(t, gpio.SwitchOn, getSwitch(pub, "button"))
+
+	// a stable level is published after the debounce time
+	adapter.Poll(start.Add(90 * time.Millisecond))
+	assert.Equal(t, gpio.SwitchOff, getSwitch(pub, "button"))
+
+	// polling at the interval
+	adapter.Start()
+	setLevel(t, root, "17", "0")
+	time.Sleep(200 * time.Millisecond)
+	adapter.Stop()
+	assert.Equal(t, gpio.SwitchOn, getSwitch(pub, "button"))
+}
+
+func TestSwitchOutputPin(t *testing.T) {
+	configFolder, err := ioutil.TempDir("", "gpiopub")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+	pub := publisher.NewPublisher(&publisher.PublisherConfig{
+		ConfigFolder: configFolder,
+		Domain:       "test",
+		PublisherID:  "gpiopub",
+	}, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+	root := newTestSysfs(t, "4")
+	defer os.RemoveAll(root)
+	adapter := gpio.NewGPIOAdapter(pub, gpio.GPIOConfig{
+		Pins:      []gpio.PinConfig{{Pin: 4, Direction: gpio.PinDirectionOut}},
+		SysfsRoot: root,
+	}, nil)
+	input := pub.GetInputByNodeHWID(gpio.DefaultNodeHWID, types.InputTypeSwitch, "4")
+	require.NotNil(t, input)
+	assert.Equal(t, gpio.SwitchOff, getSwitch(pub, "4"))
+
+	// a set command writes the pin and reports its state
+	pub.Start()
+	defer pub.Stop()
+	setAddr := inputs.MakeSetInputAddress(pub.Domain(), pub.PublisherID(), gpio.DefaultNodeHWID,
+		types.InputTypeSwitch, "4")
+	err = pub.PublishSetInput(setAddr, gpio.SwitchOn)
+	require.NoError(t, err)
+	level, _ := ioutil.ReadFile(path.Join(root, "gpio4", "value"))
+	assert.Equal(t, "1", string(level))
+	assert.Equal(t, gpio.SwitchOn, getSwitch(pub, "4"))
+	err = adapter.SetPin("4", false)
+	assert.NoError(t, err)
+	level, _ = ioutil.ReadFile(path.Join(root, "gpio4", "value"))
+	assert.Equal(t, "0", string(level))
+
+	// error cases - invalid values and pins are ignored
+	pub.PublishSetInput(setAddr, "maybe")
+	assert.Equal(t, gpio.SwitchOff, getSwitch(pub, "4"))
+	err = adapter.SetPin("5", true)
+	assert.Error(t, err)
+
+	// error case - a pin that can't be exported is skipped
+	pub2 := publisher.NewPublisher(&publisher.PublisherConfig{
+		ConfigFolder: configFolder,
+		Domain:       "test",
+		PublisherID:  "gpiopub2",
+	}, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+	gpio.NewGPIOAdapter(pub2, gpio.GPIOConfig{
+		Pins:      []gpio.PinConfig{{Pin: 5}},
+		SysfsRoot: path.Join(root, "notafolder"),
+	}, nil)
+	assert.Nil(t, pub2.GetOutputByNodeHWID(gpio.DefaultNodeHWID, types.OutputTypeSwitch, "5"))
+}
@@ -0,0 +1,87 @@
+// Package gpio with the driver for reading and writing GPIO pins using the Linux sysfs interface
+package gpio
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DefaultSysfsRoot is the folder of the Linux sysfs GPIO interface
+const DefaultSysfsRoot = "/sys/class/gpio"
+
+// PinDriver reads and writes GPIO pins. Replace the sysfs driver for other platforms or testing.
+type PinDriver interface {
+	// Open exports the pin and sets its direction, output is true for a pin that is written
+	Open(pin int, output bool) error
+	// Read returns the raw level of the pin, true is high
+	Read(pin int) (bool, error)
+	// Write sets the raw level of the pin, true is high
+	Write(pin int, high bool) error
+}
+
+// SysfsDriver accesses GPIO pins through the Linux sysfs interface. This works on the Raspberry Pi
+// and most other single board computers without additional dependencies.
+type SysfsDriver struct {
+	root string // folder of the sysfs GPIO interface
+}
+
+// Open exports the pin if it isn't exported yet and sets its direction
+func (driver *SysfsDriver) Open(pin int, output bool) error {
+	pinFolder := driver.pinPath(pin, "")
+	if _, err := os.Stat(pinFolder); os.IsNotExist(err) {
+		exportPath := path.Join(driver.root, "export")
+		err = ioutil.WriteFile(exportPath, []byte(strconv.Itoa(pin)), 0644)
+		if err != nil {
+			return fmt.Errorf("Open: Failed exporting GPIO pin %d: %s", pin, err)
+		}
+	}
+	direction := "in"
+	if output {
+		direction = "out"
+	}
+	err := ioutil.WriteFile(driver.pinPath(pin, "direction"), []byte(direction), 0644)
+	if err != nil {
+		return fmt.Errorf("Open: Failed setting direction of GPIO pin %d: %s", pin, err)
+	}
+	return nil
+}
+
+// Read returns the raw level of the pin
+func (driver *SysfsDriver) Read(pin int) (bool, error) {
+	value, err := ioutil.ReadFile(driver.pinPath(pin, "value"))
+	if err != nil {
+		return false, fmt.Errorf("Read: Failed reading GPIO pin %d: %s", pin, err)
+	}
+	return strings.TrimSpace(string(value)) == "1", nil
+}
+
+// Write sets the raw level of the pin
+func (driver *SysfsDriver) Write(pin int, high bool) error {
+	value := "0"
+	if high {
+		value = "1"
+	}
+	err := ioutil.WriteFile(driver.pinPath(pin, "value"), []byte(value), 0644)
+	if err != nil {
+		return fmt.Errorf("Write: Failed writing GPIO pin %d: %s", pin, err)
+	}
+	return nil
+}
+
+// pinPath returns the path of a file in the sysfs folder of the pin
+func (driver *SysfsDriver) pinPath(pin int, filename string) string {
+	return path.Join(driver.root, "gpio"+strconv.Itoa(pin), filename)
+}
+
+// NewSysfsDriver creates a driver for the sysfs GPIO interface
+//  root is the sysfs GPIO folder, "" for DefaultSysfsRoot
+func NewSysfsDriver(root string) *SysfsDriver {
+	if root == "" {
+		root = DefaultSysfsRoot
+	}
+	return &SysfsDriver{root: root}
+}
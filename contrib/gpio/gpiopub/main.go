@@ -0,0 +1,39 @@
+// Package main with the gpiopub publisher of the GPIO pins of a Raspberry Pi or other single board computer
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/iotdomain/iotdomain-go/contrib/gpio"
+	"github.com/iotdomain/iotdomain-go/publisher"
+)
+
+// AppID is the publisher ID of the GPIO publisher. Its configuration is loaded from gpiopub.yaml.
+const AppID = "gpiopub"
+
+func main() {
+	gpioConfig := gpio.GPIOConfig{}
+	flags := flag.NewFlagSet(AppID, flag.ExitOnError)
+	configFolder := flags.String("c", "", "configuration folder with messenger.yaml and gpiopub.yaml. Default is ~/.config/iotdomain")
+	flags.Parse(os.Args[1:])
+
+	pub, err := publisher.NewAppPublisher(AppID, *configFolder, &gpioConfig, "", false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load the messenger configuration: %s\n", err)
+		os.Exit(1)
+	}
+	if len(gpioConfig.Pins) == 0 {
+		fmt.Fprintf(os.Stderr, "No pins configured in %s.yaml\n", AppID)
+		os.Exit(1)
+	}
+	adapter := gpio.NewGPIOAdapter(pub, gpioConfig, nil)
+	adapter.Start()
+	pub.AddShutdownHook("gpio", func() error {
+		adapter.Stop()
+		return nil
+	})
+	pub.Run(context.Background())
+}
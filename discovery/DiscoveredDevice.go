@@ -0,0 +1,78 @@
+// Package discovery with scanning of the local network for devices that adapters can adopt as nodes
+package discovery
+
+import (
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// DiscoveredDevice describes a device that is found with an mDNS or SSDP scan
+type DiscoveredDevice struct {
+	Address      string // IP address of the device
+	DeviceID     string // unique ID of the device, the UDN of SSDP devices or the instance name of mDNS services
+	Host         string // host name of the device, if known
+	Location     string // URL of the SSDP device description, or the host:port of an mDNS service
+	Manufacturer string // device manufacturer, if known
+	Model        string // device model, if known
+	Name         string // friendly name of the device
+	Port         uint16 // port of the service
+	Service      string // mDNS service type or SSDP search target the device responded to
+}
+
+// PairCandidate returns the device as a candidate for adoption as a node
+//  nodeType is the type of node the device becomes when adopted
+func (device *DiscoveredDevice) PairCandidate(nodeType types.NodeType) types.PairCandidate {
+	attr := types.NodeAttrMap{}
+	setAttr := func(attrName types.NodeAttr, value string) {
+		if value != "" {
+			attr[attrName] = value
+		}
+	}
+	setAttr(types.NodeAttrLocalIP, device.Address)
+	setAttr(types.NodeAttrHostname, strings.TrimSuffix(device.Host, "."))
+	setAttr(types.NodeAttrManufacturer, device.Manufacturer)
+	setAttr(types.NodeAttrModel, device.Model)
+	setAttr(types.NodeAttrName, device.Name)
+	setAttr(types.NodeAttrURL, device.Location)
+	return types.PairCandidate{
+		Attr:        attr,
+		CandidateID: device.DeviceID,
+		NodeType:    nodeType,
+	}
+}
+
+// AddPairCandidates adds the discovered devices to the pairing candidates of the publisher, so a UI
+// can offer them for adoption. Devices that are already registered as a node with their device ID
+// as hardware ID are skipped. Typically invoked by the adapter's pairing handler when inclusion
+// mode starts.
+// Returns the number of candidates that are added.
+func AddPairCandidates(pub *publisher.Publisher, devices []DiscoveredDevice, nodeType types.NodeType) int {
+	count := 0
+	for _, device := range devices {
+		if pub.GetNodeByHWID(device.DeviceID) != nil {
+			continue
+		}
+		pub.AddPairCandidate(device.PairCandidate(nodeType))
+		count++
+	}
+	return count
+}
+
+// mergeDevices adds devices that are not yet in the list
+func mergeDevices(devices []DiscoveredDevice, found []DiscoveredDevice) []DiscoveredDevice {
+	for _, newDevice := range found {
+		isKnown := false
+		for _, device := range devices {
+			if device.DeviceID == newDevice.DeviceID {
+				isKnown = true
+				break
+			}
+		}
+		if !isKnown {
+			devices = append(devices, newDevice)
+		}
+	}
+	return devices
+}
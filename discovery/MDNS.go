@@ -0,0 +1,82 @@
+// Package discovery with scanning for devices that advertise their services with mDNS/DNS-SD
+package discovery
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/discovery/mdns"
+)
+
+// Common DNS-SD service types of local devices
+const (
+	MDNSServiceHue     = "_hue._tcp"        // Philips Hue bridges
+	MDNSServiceHTTP    = "_http._tcp"       // devices with a web interface
+	MDNSServiceHomekit = "_hap._tcp"        // HomeKit accessories
+	MDNSServiceSonos   = "_sonos._tcp"      // Sonos speakers
+	MDNSServiceCast    = "_googlecast._tcp" // Chromecast and Google Home devices
+)
+
+// TXT record keys that hold the device model, manufacturer and name. Devices use various keys.
+var (
+	mdnsModelKeys        = []string{"model", "md", "modelid", "mdl"}
+	mdnsManufacturerKeys = []string{"manufacturer", "mf", "vendor", "brand"}
+	mdnsNameKeys         = []string{"fn", "name", "friendlyName"}
+)
+
+// ScanMDNS discovers devices that advertise the given service on the local network. The query is
+// multicast with IPv4 and IPv6 on each multicast capable interface.
+//  service is the DNS-SD service type, eg MDNSServiceHue
+//  timeout is the time to wait for responses
+func ScanMDNS(service string, timeout time.Duration) ([]DiscoveredDevice, error) {
+	services, err := mdns.Browse(service, timeout)
+	return toDevices(service, services), err
+}
+
+// QueryMDNS sends a DNS-SD query for the given service to an mDNS address and returns the devices
+// that respond before the timeout.
+//  mdnsAddress is the multicast address of mDNS, eg mdns.IPv4Address, or the address of a specific responder
+//  service is the DNS-SD service type, eg MDNSServiceHue
+//  timeout is the time to wait for responses
+func QueryMDNS(mdnsAddress string, service string, timeout time.Duration) ([]DiscoveredDevice, error) {
+	services, err := mdns.Query(mdnsAddress, service, timeout)
+	return toDevices(service, services), err
+}
+
+// getText returns the value of the first of the keys in the TXT record that has a value
+func getText(text map[string]string, keys []string) string {
+	for _, key := range keys {
+		if value := text[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// toDevices converts the discovered service instances to devices
+func toDevices(service string, services []mdns.ServiceInstance) []DiscoveredDevice {
+	devices := make([]DiscoveredDevice, 0, len(services))
+	for _, instance := range services {
+		device := DiscoveredDevice{
+			DeviceID:     strings.TrimSuffix(instance.Instance, "."),
+			Host:         instance.Host,
+			Manufacturer: getText(instance.Text, mdnsManufacturerKeys),
+			Model:        getText(instance.Text, mdnsModelKeys),
+			Name:         getText(instance.Text, mdnsNameKeys),
+			Port:         instance.Port,
+			Service:      service,
+		}
+		if len(instance.Addresses) > 0 {
+			device.Address = instance.Addresses[0]
+			device.Location = net.JoinHostPort(device.Address, strconv.Itoa(int(device.Port)))
+		}
+		// the instance name is the name of the device, eg "Living Room._sonos._tcp.local."
+		if device.Name == "" {
+			device.Name = strings.TrimSuffix(device.DeviceID, "."+strings.TrimSuffix(service, ".")+".local")
+		}
+		devices = append(devices, device)
+	}
+	return devices
+}
@@ -0,0 +1,79 @@
+package discovery_test
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/discovery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// appendName appends a DNS name in label format
+func appendName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// appendRecord appends a resource record with the given name, type and data
+func appendRecord(msg []byte, name string, rrType uint16, data []byte) []byte {
+	msg = appendName(msg, name)
+	header := make([]byte, 10)
+	binary.BigEndian.PutUint16(header[0:], rrType)
+	binary.BigEndian.PutUint16(header[2:], 1)
+	binary.BigEndian.PutUint32(header[4:], 120)
+	binary.BigEndian.PutUint16(header[8:], uint16(len(data)))
+	msg = append(msg, header...)
+	return append(msg, data...)
+}
+
+// makeHueResponse creates an mDNS response that advertises a Hue bridge
+func makeHueResponse() []byte {
+	const instance = "Philips Hue - 1A2B3C._hue._tcp.local."
+	msg := make([]byte, 12)
+	msg[2] = 0x84 // response, authoritative
+	binary.BigEndian.PutUint16(msg[6:], 1)
+	binary.BigEndian.PutUint16(msg[10:], 3)
+	msg = appendRecord(msg, "_hue._tcp.local.", 12, appendName(nil, instance))
+	srvData := []byte{0, 0, 0, 0, 0x01, 0xBB} // priority, weight, port 443
+	msg = appendRecord(msg, instance, 33, appendName(srvData, "huebridge.local."))
+	txtData := append([]byte{14}, "modelid=BSB002"...)
+	msg = appendRecord(msg, instance, 16, txtData)
+	msg = appendRecord(msg, "huebridge.local.", 1, []byte{192, 168, 0, 30})
+	return msg
+}
+
+func TestQueryMDNS(t *testing.T) {
+	responder, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer responder.Close()
+	go func() {
+		buffer := make([]byte, 1500)
+		n, source, err := responder.ReadFromUDP(buffer)
+		if err == nil && strings.Contains(string(buffer[:n]), "_hue") {
+			responder.WriteToUDP(makeHueResponse(), source)
+		}
+	}()
+
+	devices, err := discovery.QueryMDNS(responder.LocalAddr().String(), discovery.MDNSServiceHue, time.Millisecond*300)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(devices))
+	assert.Equal(t, "Philips Hue - 1A2B3C._hue._tcp.local", devices[0].DeviceID)
+	assert.Equal(t, "Philips Hue - 1A2B3C", devices[0].Name)
+	assert.Equal(t, "192.168.0.30", devices[0].Address)
+	assert.Equal(t, "huebridge.local.", devices[0].Host)
+	assert.Equal(t, uint16(443), devices[0].Port)
+	assert.Equal(t, "192.168.0.30:443", devices[0].Location)
+	assert.Equal(t, "BSB002", devices[0].Model)
+	assert.Empty(t, devices[0].Manufacturer)
+
+	// error case - invalid address
+	_, err = discovery.QueryMDNS("not an address", discovery.MDNSServiceHue, time.Millisecond)
+	assert.Error(t, err)
+}
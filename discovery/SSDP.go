@@ -0,0 +1,152 @@
+// Package discovery with scanning for UPnP devices using SSDP
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SSDP discovery defaults
+const (
+	SSDPAddress         = "239.255.255.250:1900"                        // SSDP IPv4 multicast address
+	SSDPSearchAll       = "ssdp:all"                                    // search target of all devices and services
+	SSDPSearchRoot      = "upnp:rootdevice"                             // search target of root devices
+	SSDPSearchSonos     = "urn:schemas-upnp-org:device:ZonePlayer:1"    // search target of Sonos speakers
+	SSDPSearchMediaRend = "urn:schemas-upnp-org:device:MediaRenderer:1" // search target of media renderers
+)
+
+// ssdpDescription is the part of the UPnP device description that describes the device
+type ssdpDescription struct {
+	Device struct {
+		FriendlyName string `xml:"friendlyName"`
+		Manufacturer string `xml:"manufacturer"`
+		ModelName    string `xml:"modelName"`
+		ModelNumber  string `xml:"modelNumber"`
+	} `xml:"device"`
+}
+
+// ScanSSDP discovers UPnP devices on the local network that respond to the search target.
+// The device description of each device is loaded to obtain its name, model and manufacturer.
+//  searchTarget is the device or service type to search for, eg SSDPSearchRoot
+//  timeout is the time to wait for responses
+func ScanSSDP(searchTarget string, timeout time.Duration) ([]DiscoveredDevice, error) {
+	return QuerySSDP(SSDPAddress, searchTarget, timeout)
+}
+
+// QuerySSDP sends an M-SEARCH request to an SSDP address and returns the devices that respond
+// before the timeout. Devices are returned in order of discovery.
+//  ssdpAddress is the multicast address of SSDP, or the address of a specific device
+//  searchTarget is the device or service type to search for, eg SSDPSearchRoot
+//  timeout is the time to wait for responses
+func QuerySSDP(ssdpAddress string, searchTarget string, timeout time.Duration) ([]DiscoveredDevice, error) {
+	devices := make([]DiscoveredDevice, 0)
+	destination, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return devices, fmt.Errorf("QuerySSDP: Invalid SSDP address %s: %s", ssdpAddress, err)
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return devices, fmt.Errorf("QuerySSDP: Unable to open socket: %s", err)
+	}
+	defer conn.Close()
+	// devices respond within a random delay of up to MX seconds
+	mx := int(timeout / time.Second)
+	if mx < 1 {
+		mx = 1
+	}
+	request := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: %d\r\nST: %s\r\n\r\n",
+		SSDPAddress, mx, searchTarget)
+	_, err = conn.WriteToUDP([]byte(request), destination)
+	if err != nil {
+		return devices, fmt.Errorf("QuerySSDP: Unable to send search to %s: %s", ssdpAddress, err)
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buffer := make([]byte, 9000)
+	for {
+		n, source, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			// the deadline ends the discovery
+			break
+		}
+		device, err := parseSSDPResponse(buffer[:n], source)
+		if err != nil {
+			logrus.Infof("QuerySSDP: Ignored invalid response from %s: %s", source, err)
+			continue
+		}
+		devices = mergeDevices(devices, []DiscoveredDevice{device})
+	}
+	// the description is loaded after the scan, as devices respond only once
+	client := http.Client{Timeout: timeout}
+	for i := range devices {
+		loadDescription(&client, &devices[i])
+	}
+	return devices, nil
+}
+
+// loadDescription loads the name, model and manufacturer of a device from its description.
+// If the description can't be loaded the device is left unchanged.
+func loadDescription(client *http.Client, device *DiscoveredDevice) {
+	if device.Location == "" {
+		return
+	}
+	resp, err := client.Get(device.Location)
+	if err != nil {
+		logrus.Infof("loadDescription: Unable to load description of device %s: %s", device.DeviceID, err)
+		return
+	}
+	defer resp.Body.Close()
+	description := ssdpDescription{}
+	err = xml.NewDecoder(resp.Body).Decode(&description)
+	if resp.StatusCode != http.StatusOK || err != nil {
+		logrus.Infof("loadDescription: Invalid description of device %s: status %s, %v",
+			device.DeviceID, resp.Status, err)
+		return
+	}
+	device.Name = description.Device.FriendlyName
+	device.Manufacturer = description.Device.Manufacturer
+	device.Model = description.Device.ModelName
+	if description.Device.ModelNumber != "" && device.Model != description.Device.ModelNumber {
+		device.Model = strings.TrimSpace(device.Model + " " + description.Device.ModelNumber)
+	}
+}
+
+// parseSSDPResponse returns the device of a response to an M-SEARCH request
+//  source is the sender of the response, used as device address
+func parseSSDPResponse(msg []byte, source *net.UDPAddr) (device DiscoveredDevice, err error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(msg)), nil)
+	if err != nil {
+		return device, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return device, fmt.Errorf("status %s", resp.Status)
+	}
+	// the USN holds the UDN of the device, eg uuid:RINCON_000E58::urn:schemas-upnp-org:device:ZonePlayer:1
+	usn := resp.Header.Get("USN")
+	device.DeviceID = strings.TrimPrefix(strings.SplitN(usn, "::", 2)[0], "uuid:")
+	if device.DeviceID == "" {
+		return device, fmt.Errorf("response has no USN")
+	}
+	device.Location = resp.Header.Get("LOCATION")
+	device.Service = resp.Header.Get("ST")
+	if source != nil {
+		device.Address = source.IP.String()
+	}
+	if location, err := url.Parse(device.Location); err == nil && location.Hostname() != "" {
+		device.Address = location.Hostname()
+		if port, err := strconv.ParseUint(location.Port(), 10, 16); err == nil {
+			device.Port = uint16(port)
+		}
+	}
+	return device, nil
+}
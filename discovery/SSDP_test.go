@@ -0,0 +1,117 @@
+package discovery_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/discovery"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sonosDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:ZonePlayer:1</deviceType>
+    <friendlyName>Living Room</friendlyName>
+    <manufacturer>Sonos, Inc.</manufacturer>
+    <modelName>Sonos One</modelName>
+    <modelNumber>S18</modelNumber>
+    <UDN>uuid:RINCON_000E58A0</UDN>
+  </device>
+</root>`
+
+// startSSDPResponder starts a fake device that responds to M-SEARCH requests
+func startSSDPResponder(t *testing.T, location string) *net.UDPConn {
+	responder, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	go func() {
+		buffer := make([]byte, 1500)
+		n, source, err := responder.ReadFromUDP(buffer)
+		if err == nil && strings.HasPrefix(string(buffer[:n]), "M-SEARCH") {
+			responder.WriteToUDP([]byte("invalid"), source)
+			response := fmt.Sprintf("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nLOCATION: %s\r\n"+
+				"ST: %s\r\nUSN: uuid:RINCON_000E58A0::%s\r\n\r\n",
+				location, discovery.SSDPSearchSonos, discovery.SSDPSearchSonos)
+			responder.WriteToUDP([]byte(response), source)
+			// repeated responses are ignored
+			responder.WriteToUDP([]byte(response), source)
+		}
+	}()
+	return responder
+}
+
+func TestQuerySSDP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(sonosDescription))
+	}))
+	defer server.Close()
+	responder := startSSDPResponder(t, server.URL+"/xml/device_description.xml")
+	defer responder.Close()
+
+	devices, err := discovery.QuerySSDP(responder.LocalAddr().String(), discovery.SSDPSearchSonos, time.Millisecond*300)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(devices))
+	assert.Equal(t, "RINCON_000E58A0", devices[0].DeviceID)
+	assert.Equal(t, "127.0.0.1", devices[0].Address)
+	assert.NotZero(t, devices[0].Port)
+	assert.Equal(t, "Living Room", devices[0].Name)
+	assert.Equal(t, "Sonos, Inc.", devices[0].Manufacturer)
+	assert.Equal(t, "Sonos One S18", devices[0].Model)
+	assert.Equal(t, discovery.SSDPSearchSonos, devices[0].Service)
+
+	// a device without a valid description is still discovered
+	responder2 := startSSDPResponder(t, server.URL+"notfound")
+	defer responder2.Close()
+	devices, err = discovery.QuerySSDP(responder2.LocalAddr().String(), discovery.SSDPSearchSonos, time.Millisecond*300)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(devices))
+	assert.Empty(t, devices[0].Name)
+
+	// error case - invalid address
+	_, err = discovery.QuerySSDP("not an address", discovery.SSDPSearchAll, time.Millisecond)
+	assert.Error(t, err)
+}
+
+func TestAddPairCandidates(t *testing.T) {
+	configFolder, err := ioutil.TempDir("", "discovery")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+	pub := publisher.NewPublisher(&publisher.PublisherConfig{
+		ConfigFolder: configFolder,
+		Domain:       "test",
+		PublisherID:  "sonos",
+	}, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+
+	devices := []discovery.DiscoveredDevice{
+		{DeviceID: "RINCON_1", Address: "192.168.0.20", Manufacturer: "Sonos, Inc.", Model: "Sonos One", Name: "Kitchen"},
+		{DeviceID: "RINCON_2", Address: "192.168.0.21", Host: "sonos2.local."},
+	}
+	// registered devices are not offered again
+	pub.CreateNode("RINCON_2", types.NodeTypeAVReceiver)
+	count := discovery.AddPairCandidates(pub, devices, types.NodeTypeAVReceiver)
+	assert.Equal(t, 1, count)
+	candidates := pub.GetPairCandidates()
+	require.Equal(t, 1, len(candidates))
+	assert.Equal(t, "RINCON_1", candidates[0].CandidateID)
+	assert.Equal(t, types.NodeTypeAVReceiver, candidates[0].NodeType)
+	assert.Equal(t, "192.168.0.20", candidates[0].Attr[types.NodeAttrLocalIP])
+	assert.Equal(t, "Sonos One", candidates[0].Attr[types.NodeAttrModel])
+	assert.Equal(t, "Sonos, Inc.", candidates[0].Attr[types.NodeAttrManufacturer])
+	assert.Equal(t, "Kitchen", candidates[0].Attr[types.NodeAttrName])
+
+	candidate := devices[1].PairCandidate(types.NodeTypeAVReceiver)
+	assert.Equal(t, "sonos2.local", candidate.Attr[types.NodeAttrHostname])
+	_, hasModel := candidate.Attr[types.NodeAttrModel]
+	assert.False(t, hasModel)
+}
@@ -0,0 +1,331 @@
+// Package mdns with queries for services that are advertised on the local network with mDNS/DNS-SD
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Multicast addresses of mDNS
+const (
+	IPv4Address = "224.0.0.251:5353" // mDNS IPv4 multicast address
+	IPv6Address = "[ff02::fb]:5353"  // mDNS IPv6 link-local multicast address
+)
+
+// DNS record types and class used in queries
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+	dnsClassIN  = 1
+	// request a unicast response to the query
+	dnsClassUnicastResponse = 0x8000
+)
+
+// ServiceInstance describes an instance of a service that is advertised on the local network
+type ServiceInstance struct {
+	Instance  string   // service instance name, eg "mosquitto._mqtt._tcp.local."
+	Host      string   // host name of the instance, eg "raspberrypi.local."
+	Addresses []string // IP addresses of the host. IPv6 link-local addresses include the zone, eg fe80::1%eth0
+	Port      uint16   // port of the service
+	// key-value pairs of the TXT record of the service instance, eg model and manufacturer of devices
+	Text map[string]string
+}
+
+// Browse discovers instances of the given service type on the local network. The query is
+// multicast with IPv4 and with IPv6 on each multicast capable interface. Instances are returned
+// in order of discovery.
+//  service is the DNS-SD service type, eg "_mqtt._tcp"
+//  timeout is the time to wait for responses
+func Browse(service string, timeout time.Duration) ([]ServiceInstance, error) {
+	mdnsAddresses := []string{IPv4Address}
+	interfaces, _ := net.Interfaces()
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 {
+			mdnsAddresses = append(mdnsAddresses, fmt.Sprintf("[ff02::fb%%%s]:5353", iface.Name))
+		}
+	}
+	var instances = make([]ServiceInstance, 0)
+	var lastErr error
+	failures := 0
+	mutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, mdnsAddress := range mdnsAddresses {
+		wg.Add(1)
+		go func(mdnsAddress string) {
+			found, err := Query(mdnsAddress, service, timeout)
+			mutex.Lock()
+			if err != nil {
+				lastErr = err
+				failures++
+			}
+			instances = mergeInstances(instances, found)
+			mutex.Unlock()
+			wg.Done()
+		}(mdnsAddress)
+	}
+	wg.Wait()
+	// only fail if none of the queries could be sent
+	if failures == len(mdnsAddresses) {
+		return instances, lastErr
+	}
+	return instances, nil
+}
+
+// Query sends a DNS-SD query for the given service type to an mDNS address and collects the
+// service instances from the responses until the timeout expires.
+//  mdnsAddress is the multicast address of mDNS, eg IPv4Address, or the address of a specific responder
+//  service is the DNS-SD service type, eg "_mqtt._tcp"
+//  timeout is the time to wait for responses
+func Query(mdnsAddress string, service string, timeout time.Duration) ([]ServiceInstance, error) {
+	instances := make([]ServiceInstance, 0)
+	destination, err := net.ResolveUDPAddr("udp", mdnsAddress)
+	if err != nil {
+		return instances, fmt.Errorf("Query: Invalid mDNS address %s: %s", mdnsAddress, err)
+	}
+	network := "udp4"
+	if destination.IP.To4() == nil {
+		network = "udp6"
+	}
+	// responders reply with unicast to queries that are not sent from the mDNS port
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return instances, fmt.Errorf("Query: Unable to open socket: %s", err)
+	}
+	defer conn.Close()
+	serviceName := strings.TrimSuffix(service, ".") + ".local."
+	_, err = conn.WriteToUDP(makeDNSQuery(serviceName), destination)
+	if err != nil {
+		return instances, fmt.Errorf("Query: Unable to send query to %s: %s", mdnsAddress, err)
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buffer := make([]byte, 9000)
+	for {
+		n, source, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			// the deadline ends the query
+			break
+		}
+		found, err := parseDNSResponse(buffer[:n], serviceName, source)
+		if err != nil {
+			logrus.Infof("Query: Ignored invalid response from %s: %s", source, err)
+			continue
+		}
+		instances = mergeInstances(instances, found)
+	}
+	return instances, nil
+}
+
+// makeDNSQuery creates a DNS query message for PTR records of the given name
+func makeDNSQuery(name string) []byte {
+	// header: id, flags, 1 question, 0 answers, 0 authority, 0 additional
+	msg := make([]byte, 12, 64)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	msg = appendDNSName(msg, name)
+	msg = append(msg, 0, dnsTypePTR)
+	msg = append(msg, byte((dnsClassIN|dnsClassUnicastResponse)>>8), byte(dnsClassIN))
+	return msg
+}
+
+// appendDNSName appends a name in DNS label format to a message
+func appendDNSName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// mergeInstances adds service instances that are not yet in the list, and adds new addresses of known instances
+func mergeInstances(instances []ServiceInstance, found []ServiceInstance) []ServiceInstance {
+	for _, newInstance := range found {
+		isKnown := false
+		for i := range instances {
+			if strings.EqualFold(instances[i].Instance, newInstance.Instance) {
+				isKnown = true
+				for _, address := range newInstance.Addresses {
+					if !containsString(instances[i].Addresses, address) {
+						instances[i].Addresses = append(instances[i].Addresses, address)
+					}
+				}
+			}
+		}
+		if !isKnown {
+			instances = append(instances, newInstance)
+		}
+	}
+	return instances
+}
+
+// containsString returns true if the list contains the value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDNSResponse returns the instances of the given service in a DNS response message
+//  source is the sender of the response, used as address if the response holds no address records
+func parseDNSResponse(msg []byte, serviceName string, source *net.UDPAddr) ([]ServiceInstance, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("message too short")
+	}
+	if msg[2]&0x80 == 0 {
+		return nil, errors.New("message is not a response")
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	records := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+	offset := 12
+	for i := 0; i < questions; i++ {
+		_, next, err := readDNSName(msg, offset)
+		if err != nil || next+4 > len(msg) {
+			return nil, errors.New("invalid question")
+		}
+		offset = next + 4
+	}
+	instances := make([]string, 0)
+	targets := make(map[string]string)
+	ports := make(map[string]uint16)
+	addresses := make(map[string][]string)
+	texts := make(map[string]map[string]string)
+	for i := 0; i < records; i++ {
+		name, next, err := readDNSName(msg, offset)
+		if err != nil || next+10 > len(msg) {
+			return nil, errors.New("invalid record")
+		}
+		rrType := binary.BigEndian.Uint16(msg[next:])
+		dataLength := int(binary.BigEndian.Uint16(msg[next+8:]))
+		dataOffset := next + 10
+		if dataOffset+dataLength > len(msg) {
+			return nil, errors.New("record data exceeds message")
+		}
+		name = strings.ToLower(name)
+		switch rrType {
+		case dnsTypePTR:
+			if name == strings.ToLower(serviceName) {
+				instance, _, err := readDNSName(msg, dataOffset)
+				if err == nil && !containsString(instances, instance) {
+					instances = append(instances, instance)
+				}
+			}
+		case dnsTypeSRV:
+			if dataLength >= 7 {
+				target, _, err := readDNSName(msg, dataOffset+6)
+				if err == nil {
+					targets[name] = strings.ToLower(target)
+					ports[name] = binary.BigEndian.Uint16(msg[dataOffset+4:])
+				}
+			}
+		case dnsTypeTXT:
+			texts[name] = parseDNSText(msg[dataOffset : dataOffset+dataLength])
+		case dnsTypeA, dnsTypeAAAA:
+			if dataLength == net.IPv4len || dataLength == net.IPv6len {
+				ip := net.IP(append([]byte{}, msg[dataOffset:dataOffset+dataLength]...))
+				address := ip.String()
+				if ip.IsLinkLocalUnicast() && ip.To4() == nil && source != nil && source.Zone != "" {
+					address += "%" + source.Zone
+				}
+				addresses[name] = append(addresses[name], address)
+			}
+		}
+		offset = dataOffset + dataLength
+	}
+
+	result := make([]ServiceInstance, 0, len(instances))
+	for _, instance := range instances {
+		serviceInstance := ServiceInstance{Instance: instance}
+		key := strings.ToLower(instance)
+		serviceInstance.Host = targets[key]
+		serviceInstance.Port = ports[key]
+		serviceInstance.Text = texts[key]
+		serviceInstance.Addresses = addresses[serviceInstance.Host]
+		if len(serviceInstance.Addresses) == 0 && source != nil {
+			address := source.IP.String()
+			if source.Zone != "" {
+				address += "%" + source.Zone
+			}
+			serviceInstance.Addresses = []string{address}
+		}
+		result = append(result, serviceInstance)
+	}
+	return result, nil
+}
+
+// parseDNSText returns the key-value pairs of TXT record data. Each string holds key=value, or
+// only a key for boolean attributes.
+func parseDNSText(data []byte) map[string]string {
+	text := make(map[string]string)
+	for offset := 0; offset < len(data); {
+		length := int(data[offset])
+		if offset+1+length > len(data) {
+			break
+		}
+		entry := string(data[offset+1 : offset+1+length])
+		offset += 1 + length
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) == 2 {
+			text[parts[0]] = parts[1]
+		} else {
+			text[parts[0]] = ""
+		}
+	}
+	return text
+}
+
+// readDNSName reads a name at the offset of a DNS message and follows compression pointers
+// This returns the name with a trailing dot and the offset following the name.
+func readDNSName(msg []byte, offset int) (name string, next int, err error) {
+	labels := make([]string, 0)
+	next = -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errors.New("name exceeds message")
+		}
+		length := int(msg[offset])
+		if length == 0 {
+			offset++
+			break
+		} else if length&0xC0 == 0xC0 {
+			if offset+1 >= len(msg) {
+				return "", 0, errors.New("invalid name pointer")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			jumps++
+			if jumps > 10 {
+				return "", 0, errors.New("too many name pointers")
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3FFF)
+			continue
+		}
+		if offset+1+length > len(msg) {
+			return "", 0, errors.New("label exceeds message")
+		}
+		labels = append(labels, string(msg[offset+1:offset+1+length]))
+		offset += 1 + length
+	}
+	if next < 0 {
+		next = offset
+	}
+	return strings.Join(labels, ".") + ".", next, nil
+}
@@ -0,0 +1,79 @@
+package mdns_test
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/discovery/mdns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// appendName appends a DNS name in label format
+func appendName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// appendRecord appends a resource record with the given name, type and data
+func appendRecord(msg []byte, name []byte, rrType uint16, data []byte) []byte {
+	msg = append(msg, name...)
+	header := make([]byte, 10)
+	binary.BigEndian.PutUint16(header[0:], rrType)
+	binary.BigEndian.PutUint16(header[2:], 1)
+	binary.BigEndian.PutUint32(header[4:], 120)
+	binary.BigEndian.PutUint16(header[8:], uint16(len(data)))
+	msg = append(msg, header...)
+	return append(msg, data...)
+}
+
+// makePrinterResponse creates an mDNS response that advertises a printer without address records
+func makePrinterResponse() []byte {
+	msg := make([]byte, 12)
+	msg[2] = 0x84 // response, authoritative
+	binary.BigEndian.PutUint16(msg[6:], 1)
+	binary.BigEndian.PutUint16(msg[10:], 2)
+	// PTR record with the instance name, using a pointer to the service name
+	serviceOffset := len(msg)
+	msg = appendRecord(msg, appendName(nil, "_ipp._tcp.local."), 12,
+		append([]byte{6}, append([]byte("office"), 0xC0, byte(serviceOffset))...))
+	srvData := []byte{0, 0, 0, 0, 0x02, 0x77} // priority, weight, port 631
+	srvData = appendName(srvData, "printer.local.")
+	msg = appendRecord(msg, appendName(nil, "office._ipp._tcp.local."), 33, srvData)
+	txtData := append([]byte{6}, "ty=Ink"...)
+	msg = appendRecord(msg, appendName(nil, "office._ipp._tcp.local."), 16, txtData)
+	return msg
+}
+
+func TestQuery(t *testing.T) {
+	responder, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer responder.Close()
+	go func() {
+		buffer := make([]byte, 1500)
+		n, source, err := responder.ReadFromUDP(buffer)
+		if err == nil && strings.Contains(string(buffer[:n]), "_ipp") {
+			responder.WriteToUDP([]byte("invalid"), source)
+			responder.WriteToUDP(makePrinterResponse(), source)
+		}
+	}()
+
+	instances, err := mdns.Query(responder.LocalAddr().String(), "_ipp._tcp", time.Millisecond*300)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(instances))
+	assert.Equal(t, "office._ipp._tcp.local.", instances[0].Instance)
+	assert.Equal(t, "printer.local.", instances[0].Host)
+	assert.Equal(t, uint16(631), instances[0].Port)
+	assert.Equal(t, map[string]string{"ty": "Ink"}, instances[0].Text)
+	// without address records the sender of the response is the address
+	assert.Equal(t, []string{"127.0.0.1"}, instances[0].Addresses)
+
+	_, err = mdns.Query("not an address", "_ipp._tcp", time.Millisecond)
+	assert.Error(t, err)
+}
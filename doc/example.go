@@ -50,6 +50,7 @@ Change myweather.go to look like this:
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/iotdomain/iotdomain-go/publisher"
@@ -127,16 +128,20 @@ func UpdateWeather(pub *publisher.Publisher) {
 	}
 }
 
-// Run the example
-func main() {
+// RunWeather runs the weather publisher until a TERM or INT signal is received or the context ends,
+// then stops it in an orderly manner
+func RunWeather(ctx context.Context) {
 	// this auto loads the messenger.yaml and myweather.yaml from ~/.config/iotd
-	pub, _ := publisher.NewAppPublisher(appID, "", appConfig, false)
+	pub, _ := publisher.NewAppPublisher(appID, "", appConfig, "", false)
 
 	SetupNodes(pub, weatherCity)
 	// Update the forecast once an hour
 	pub.SetPollInterval(3600, UpdateWeather)
 
-	pub.Start()
-	pub.WaitForSignal()
-	pub.Stop()
+	pub.Run(ctx)
+}
+
+// Run the example
+func main() {
+	RunWeather(context.Background())
 }
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"testing"
+	"time"
 )
 
 // TestNewPublisher instance
+// The example runs until its context ends
 func TestExample(t *testing.T) {
-	main()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	RunWeather(ctx)
 }
@@ -0,0 +1,205 @@
+// Package domainstats with opt-in reception statistics of a consumer
+package domainstats
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultGapThreshold is the default silence in seconds after which a message of a publisher is
+// counted as a gap
+const DefaultGapThreshold = 300
+
+// DomainStats collects anonymous reception statistics of a consumer: the nr of messages received,
+// the nr that failed verification, and gaps in the messages of publishers. The statistics are
+// published periodically and reset, so domain operators can detect broker or publisher problems
+// from the aggregate of consumers. Addresses of received messages are not included.
+// Attach the statistics to a publisher with AddIntegration, after setting the message signer of
+// the publisher with SetMessageSigner.
+type DomainStats struct {
+	domain               string                   // the domain of the consumer
+	publisherID          string                   // the consumer that publishes the statistics
+	interval             int                      // interval in seconds to publish the statistics
+	gapThreshold         int                      // silence in seconds of a publisher that is counted as a gap
+	messageSigner        *messaging.MessageSigner // publishing of the statistics, nil when not started
+	gaps                 int                      // nr of gaps since the last publication
+	messagesReceived     int                      // nr of received messages since the last publication
+	verificationFailures int                      // nr of verification failures since the last publication
+	lastReceived         map[string]time.Time     // time of the last received message by publisher address
+	publishers           map[string]bool          // publishers received from since the last publication
+	since                time.Time                // start of the collected statistics
+	stopChannel          chan bool                // stop the publication loop
+	updateMutex          *sync.Mutex              // mutex for async counting
+}
+
+// GetStats returns the statistics collected since the last publication
+func (stats *DomainStats) GetStats() types.DomainStatsMessage {
+	stats.updateMutex.Lock()
+	defer stats.updateMutex.Unlock()
+	return types.DomainStatsMessage{
+		Address:              MakeDomainStatsAddress(stats.domain, stats.publisherID),
+		Gaps:                 stats.gaps,
+		Interval:             int(time.Since(stats.since).Round(time.Second).Seconds()),
+		MessagesReceived:     stats.messagesReceived,
+		Publishers:           len(stats.publishers),
+		Timestamp:            lib.Now().Format(types.TimeFormat),
+		VerificationFailures: stats.verificationFailures,
+	}
+}
+
+// PublishStats publishes the statistics collected since the last publication and resets them
+func (stats *DomainStats) PublishStats() error {
+	message := stats.GetStats()
+	stats.updateMutex.Lock()
+	messageSigner := stats.messageSigner
+	stats.gaps = 0
+	stats.messagesReceived = 0
+	stats.verificationFailures = 0
+	stats.publishers = make(map[string]bool)
+	stats.since = time.Now()
+	stats.updateMutex.Unlock()
+	if messageSigner == nil {
+		return nil
+	}
+	return PublishDomainStats(&message, messageSigner)
+}
+
+// RecordReceived counts a received message. A message of a publisher that was silent for longer
+// than the gap threshold is counted as a gap.
+func (stats *DomainStats) RecordReceived(address string) {
+	publisherAddress := makePublisherAddress(address)
+	now := time.Now()
+	stats.updateMutex.Lock()
+	defer stats.updateMutex.Unlock()
+	stats.messagesReceived++
+	if publisherAddress == "" {
+		return
+	}
+	stats.publishers[publisherAddress] = true
+	lastReceived, found := stats.lastReceived[publisherAddress]
+	if found && now.Sub(lastReceived) > time.Duration(stats.gapThreshold)*time.Second {
+		stats.gaps++
+	}
+	stats.lastReceived[publisherAddress] = now
+}
+
+// RecordVerificationFailure counts a received message that failed decryption or signature verification
+func (stats *DomainStats) RecordVerificationFailure() {
+	stats.updateMutex.Lock()
+	defer stats.updateMutex.Unlock()
+	stats.verificationFailures++
+}
+
+// SetMessageSigner sets the signer that publishes the statistics, eg the signer of the publisher
+func (stats *DomainStats) SetMessageSigner(messageSigner *messaging.MessageSigner) {
+	stats.updateMutex.Lock()
+	defer stats.updateMutex.Unlock()
+	stats.messageSigner = messageSigner
+}
+
+// SetGapThreshold sets the silence in seconds of a publisher after which its next message is counted
+// as a gap. Default is DefaultGapThreshold.
+func (stats *DomainStats) SetGapThreshold(gapThreshold int) {
+	stats.updateMutex.Lock()
+	defer stats.updateMutex.Unlock()
+	stats.gapThreshold = gapThreshold
+}
+
+// Start publishing the statistics at the interval
+// This returns an error if the message signer isn't set.
+func (stats *DomainStats) Start() error {
+	stats.updateMutex.Lock()
+	defer stats.updateMutex.Unlock()
+	if stats.messageSigner == nil {
+		return lib.MakeErrorf("DomainStats.Start: No message signer to publish the statistics")
+	}
+	if stats.stopChannel != nil {
+		return nil
+	}
+	stats.since = time.Now()
+	stats.stopChannel = make(chan bool)
+	go stats.publishLoop(stats.stopChannel)
+	return nil
+}
+
+// Stop publishing the statistics
+func (stats *DomainStats) Stop() {
+	stats.updateMutex.Lock()
+	defer stats.updateMutex.Unlock()
+	if stats.stopChannel != nil {
+		close(stats.stopChannel)
+		stats.stopChannel = nil
+	}
+}
+
+// VerificationFailed counts a received message that failed decryption or signature verification.
+// The publisher invokes this on attached integrations.
+func (stats *DomainStats) VerificationFailed(err error) {
+	stats.RecordVerificationFailure()
+}
+
+// publishLoop publishes the statistics at the interval until stopped
+func (stats *DomainStats) publishLoop(stopChannel chan bool) {
+	ticker := time.NewTicker(time.Duration(stats.interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChannel:
+			return
+		case <-ticker.C:
+			err := stats.PublishStats()
+			if err != nil {
+				logrus.Errorf("DomainStats.publishLoop: %s", err)
+			}
+		}
+	}
+}
+
+// MakeDomainStatsAddress returns the address of the statistics: domain/publisherID/$domainstats
+func MakeDomainStatsAddress(domain string, publisherID string) string {
+	address := fmt.Sprintf("%s/%s/%s", domain, publisherID, types.MessageTypeDomainStats)
+	return address
+}
+
+// makePublisherAddress returns the domain/publisherID part of a message address, or "" if the
+// address doesn't have a publisher
+func makePublisherAddress(address string) string {
+	parts := strings.SplitN(address, "/", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[0] + "/" + parts[1]
+}
+
+// PublishDomainStats publishes the reception statistics of a consumer
+func PublishDomainStats(message *types.DomainStatsMessage, messageSigner *messaging.MessageSigner) error {
+	logrus.Infof("PublishDomainStats: publish %d received messages to %s", message.MessagesReceived, message.Address)
+	err := messageSigner.PublishObject(message.Address, false, message, nil)
+	return err
+}
+
+// NewDomainStats creates a new instance of the reception statistics of a consumer.
+// Use a StatsMessenger to count the received messages and Start() to start publishing.
+//  domain is the domain of the consumer
+//  publisherID is the consumer that publishes the statistics
+//  interval is the interval in seconds to publish the statistics
+func NewDomainStats(domain string, publisherID string, interval int) *DomainStats {
+	stats := &DomainStats{
+		domain:       domain,
+		publisherID:  publisherID,
+		interval:     interval,
+		gapThreshold: DefaultGapThreshold,
+		lastReceived: make(map[string]time.Time),
+		publishers:   make(map[string]bool),
+		since:        time.Now(),
+		updateMutex:  &sync.Mutex{},
+	}
+	return stats
+}
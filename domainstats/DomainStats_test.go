@@ -0,0 +1,83 @@
+package domainstats_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/domainstats"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const domain = "test"
+const publisher1ID = "publisher1"
+
+func TestDomainStats(t *testing.T) {
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	dummyMessenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	stats := domainstats.NewDomainStats(domain, publisher1ID, 60)
+	messenger := domainstats.NewStatsMessenger(dummyMessenger, stats)
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	err := stats.Start()
+	assert.Error(t, err, "Expected an error without message signer")
+	stats.SetMessageSigner(signer)
+	err = stats.Start()
+	require.NoError(t, err)
+	defer stats.Stop()
+
+	received := 0
+	handler := func(address string, message string) error {
+		received++
+		return nil
+	}
+	messenger.Subscribe("test/+/+/$latest", handler)
+	messenger.Subscribe("test/#", handler)
+
+	// a message matching multiple subscriptions is counted once
+	dummyMessenger.Publish("test/publisher2/node1/$latest", false, "20")
+	assert.Equal(t, 2, received)
+	dummyMessenger.Publish("test/publisher3/node1/$latest", false, "21")
+	current := stats.GetStats()
+	assert.Equal(t, 2, current.MessagesReceived)
+	assert.Equal(t, 2, current.Publishers)
+	assert.Equal(t, 0, current.Gaps)
+
+	// a message after a silence longer than the threshold is a gap
+	stats.SetGapThreshold(0)
+	time.Sleep(10 * time.Millisecond)
+	dummyMessenger.Publish("test/publisher2/node1/$latest", false, "22")
+	stats.VerificationFailed(errors.New("invalid signature"))
+	current = stats.GetStats()
+	assert.Equal(t, 3, current.MessagesReceived)
+	assert.Equal(t, 1, current.Gaps)
+	assert.Equal(t, 1, current.VerificationFailures)
+
+	// publishing resets the statistics
+	var published types.DomainStatsMessage
+	statsAddr := domainstats.MakeDomainStatsAddress(domain, publisher1ID)
+	dummyMessenger.Subscribe(statsAddr, func(address string, message string) error {
+		payload, err := messaging.VerifyJWSMessage(message, &privKey.PublicKey)
+		require.NoError(t, err)
+		return json.Unmarshal([]byte(payload), &published)
+	})
+	err = stats.PublishStats()
+	require.NoError(t, err)
+	assert.Equal(t, statsAddr, published.Address)
+	assert.Equal(t, 1, published.Gaps)
+	// the only message since the reset is the publication of the statistics itself
+	assert.Equal(t, 1, stats.GetStats().MessagesReceived)
+
+	// unsubscribed messages are not counted
+	messenger.Unsubscribe("test/+/+/$latest", handler)
+	messenger.Unsubscribe("test/#", handler)
+	dummyMessenger.Publish("test/publisher2/node1/$latest", false, "23")
+	assert.Equal(t, 1, stats.GetStats().MessagesReceived)
+}
@@ -0,0 +1,118 @@
+package domainstats
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+)
+
+// StatsMessenger is a messenger that counts the messages it receives in the domain statistics
+// before passing them on. Use it in place of the messenger of a consumer.
+// A received message that matches multiple subscriptions is counted once.
+type StatsMessenger struct {
+	messenger    messaging.IMessenger // messenger whose messages are counted
+	stats        *DomainStats         // statistics of received messages
+	handlers     []countingHandler    // counting handlers of subscriptions
+	lastReceived string               // address and message last counted, to detect repeated delivery
+	updateMutex  *sync.Mutex          // mutex for concurrent subscriptions and delivery
+}
+
+// countingHandler is a subscription handler that counts the message before passing it on
+type countingHandler struct {
+	address   string
+	onMessage func(address string, message string) error
+	counting  func(address string, message string) error
+}
+
+// Connect the messenger
+func (statsMessenger *StatsMessenger) Connect(lastWillAddress string, lastWillValue string) error {
+	return statsMessenger.messenger.Connect(lastWillAddress, lastWillValue)
+}
+
+// ConnectionState returns the state of the connection of the wrapped messenger
+func (statsMessenger *StatsMessenger) ConnectionState() messaging.ConnectionState {
+	return statsMessenger.messenger.ConnectionState()
+}
+
+// Disconnect the messenger
+func (statsMessenger *StatsMessenger) Disconnect() {
+	statsMessenger.messenger.Disconnect()
+}
+
+// Publish a message
+func (statsMessenger *StatsMessenger) Publish(address string, retained bool, message string) error {
+	return statsMessenger.messenger.Publish(address, retained, message)
+}
+
+// Request publishes a request and waits for the reply. Both are counted in the statistics.
+func (statsMessenger *StatsMessenger) Request(address string, payload string, timeout time.Duration) (string, error) {
+	return messaging.SendRequest(statsMessenger, address, payload, timeout)
+}
+
+// SetConnectionHandler sets the handler that is notified when the connection is established, lost
+// or closed.
+func (statsMessenger *StatsMessenger) SetConnectionHandler(handler func(connected bool, err error)) {
+	statsMessenger.messenger.SetConnectionHandler(handler)
+}
+
+// Subscribe to messages on the address. Received messages are counted before they are passed to onMessage.
+func (statsMessenger *StatsMessenger) Subscribe(
+	address string, onMessage func(address string, message string) error) {
+
+	counting := func(msgAddress string, message string) error {
+		statsMessenger.updateMutex.Lock()
+		isRepeated := statsMessenger.lastReceived == msgAddress+"\n"+message
+		statsMessenger.lastReceived = msgAddress + "\n" + message
+		statsMessenger.updateMutex.Unlock()
+		if !isRepeated {
+			statsMessenger.stats.RecordReceived(msgAddress)
+		}
+		return onMessage(msgAddress, message)
+	}
+	statsMessenger.updateMutex.Lock()
+	statsMessenger.handlers = append(statsMessenger.handlers,
+		countingHandler{address: address, onMessage: onMessage, counting: counting})
+	statsMessenger.updateMutex.Unlock()
+	statsMessenger.messenger.Subscribe(address, counting)
+}
+
+// Unsubscribe from a previously subscribed address
+// If onMessage is nil then all subscriptions with the address will be removed
+func (statsMessenger *StatsMessenger) Unsubscribe(
+	address string, onMessage func(address string, message string) error) {
+
+	statsMessenger.updateMutex.Lock()
+	remaining := make([]countingHandler, 0, len(statsMessenger.handlers))
+	var counting func(address string, message string) error
+	for _, handler := range statsMessenger.handlers {
+		isMatch := handler.address == address && (onMessage == nil ||
+			(counting == nil && reflect.ValueOf(handler.onMessage).Pointer() == reflect.ValueOf(onMessage).Pointer()))
+		if isMatch {
+			counting = handler.counting
+		} else {
+			remaining = append(remaining, handler)
+		}
+	}
+	statsMessenger.handlers = remaining
+	statsMessenger.updateMutex.Unlock()
+
+	if onMessage == nil {
+		statsMessenger.messenger.Unsubscribe(address, nil)
+	} else if counting != nil {
+		statsMessenger.messenger.Unsubscribe(address, counting)
+	}
+}
+
+// NewStatsMessenger creates a messenger that counts the messages received with the given messenger
+// in the domain statistics.
+func NewStatsMessenger(messenger messaging.IMessenger, stats *DomainStats) *StatsMessenger {
+	statsMessenger := &StatsMessenger{
+		messenger:   messenger,
+		stats:       stats,
+		handlers:    make([]countingHandler, 0),
+		updateMutex: &sync.Mutex{},
+	}
+	return statsMessenger
+}
@@ -0,0 +1,290 @@
+// Package eventlog with an append-only log of significant events observed on the domain
+package eventlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// SummaryCheckInterval is the interval in seconds to check if the daily summary is due
+const SummaryCheckInterval = 60
+
+// DateFormat of the daily summary
+const DateFormat = "2006-01-02"
+
+// DomainEventLog records significant events of the domain, like publishers that appear or disappear,
+// nodes that are added, moved or removed and publisher identities that change. Events are appended to a
+// log file, one JSON event per line, and a summary of the number of events is published daily.
+// Attach the event log to a publisher with AddIntegration.
+type DomainEventLog struct {
+	domain        string                         // the domain to observe
+	publisherID   string                         // the publisher that publishes the summary
+	filename      string                         // the append-only log file
+	logFile       *os.File                       // the opened log file
+	messageSigner *messaging.MessageSigner       // subscription to domain messages
+	identities    map[string]string              // signature of known publisher identities by address
+	lostStatus    map[string]bool                // publishers whose last status is disconnected or lost
+	nodes         map[string]*types.NodeLocation // location of known nodes by address, nil without location
+	counts        map[types.DomainEventType]int  // number of events by type since the last summary
+	summaryDate   string                         // day of the summary being collected
+	stopChannel   chan bool                      // stop the summary loop
+	updateMutex   *sync.Mutex                    // mutex for async handling of events
+}
+
+// GetCounts returns a copy of the number of events by type that are collected for the next summary
+func (eventLog *DomainEventLog) GetCounts() map[types.DomainEventType]int {
+	eventLog.updateMutex.Lock()
+	defer eventLog.updateMutex.Unlock()
+	counts := make(map[types.DomainEventType]int)
+	for eventType, count := range eventLog.counts {
+		counts[eventType] = count
+	}
+	return counts
+}
+
+// PublishSummary publishes the summary of events collected since the last summary and resets the counts
+func (eventLog *DomainEventLog) PublishSummary() error {
+	eventLog.updateMutex.Lock()
+	counts := eventLog.counts
+	date := eventLog.summaryDate
+	eventLog.counts = make(map[types.DomainEventType]int)
+	eventLog.summaryDate = lib.Now().Format(DateFormat)
+	eventLog.updateMutex.Unlock()
+
+	addr := MakeEventSummaryAddress(eventLog.domain, eventLog.publisherID)
+	return PublishEventSummary(addr, date, counts, eventLog.messageSigner)
+}
+
+// RecordEvent appends an event to the log file and counts it for the daily summary
+//  address is the address of the publisher or node the event applies to
+//  details is optional information on the event
+func (eventLog *DomainEventLog) RecordEvent(eventType types.DomainEventType, address string, details string) error {
+	event := types.DomainEvent{
+		Address:   address,
+		Details:   details,
+		EventType: eventType,
+		Timestamp: lib.Now().Format(types.TimeFormat),
+	}
+	logrus.Infof("RecordEvent: %s %s", eventType, address)
+	jsonEvent, _ := json.Marshal(event)
+
+	eventLog.updateMutex.Lock()
+	defer eventLog.updateMutex.Unlock()
+	eventLog.counts[eventType]++
+	if eventLog.logFile == nil {
+		return nil
+	}
+	_, err := eventLog.logFile.WriteString(string(jsonEvent) + "\n")
+	if err != nil {
+		return lib.MakeErrorf("RecordEvent: Failed writing event to %s: %s", eventLog.filename, err)
+	}
+	return nil
+}
+
+// Start opening the log file and listening for domain events
+// The log file is created if it doesn't exist.
+func (eventLog *DomainEventLog) Start() error {
+	eventLog.updateMutex.Lock()
+	if eventLog.stopChannel != nil {
+		eventLog.updateMutex.Unlock()
+		return nil
+	}
+	if eventLog.filename != "" {
+		logFile, err := os.OpenFile(eventLog.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			eventLog.updateMutex.Unlock()
+			return lib.MakeErrorf("DomainEventLog.Start: Unable to open log file %s: %s", eventLog.filename, err)
+		}
+		eventLog.logFile = logFile
+	}
+	eventLog.stopChannel = make(chan bool)
+	eventLog.updateMutex.Unlock()
+
+	eventLog.messageSigner.Subscribe(eventLog.makeAddress("+", types.MessageTypeIdentity), eventLog.handleIdentity)
+	eventLog.messageSigner.Subscribe(eventLog.makeAddress("+", types.MessageTypeStatus), eventLog.handleStatus)
+	eventLog.messageSigner.Subscribe(eventLog.makeAddress("+/+", types.MessageTypeNodeDiscovery), eventLog.handleNode)
+	go eventLog.summaryLoop(eventLog.stopChannel)
+	return nil
+}
+
+// Stop listening for domain events and close the log file
+func (eventLog *DomainEventLog) Stop() {
+	eventLog.messageSigner.Unsubscribe(eventLog.makeAddress("+", types.MessageTypeIdentity), eventLog.handleIdentity)
+	eventLog.messageSigner.Unsubscribe(eventLog.makeAddress("+", types.MessageTypeStatus), eventLog.handleStatus)
+	eventLog.messageSigner.Unsubscribe(eventLog.makeAddress("+/+", types.MessageTypeNodeDiscovery), eventLog.handleNode)
+
+	eventLog.updateMutex.Lock()
+	defer eventLog.updateMutex.Unlock()
+	if eventLog.stopChannel != nil {
+		close(eventLog.stopChannel)
+		eventLog.stopChannel = nil
+	}
+	if eventLog.logFile != nil {
+		eventLog.logFile.Close()
+		eventLog.logFile = nil
+	}
+}
+
+// handleIdentity records publishers that appear and identities that change
+// The identity message is verified with the publisher key from the domain identities, so only
+// identities that are accepted by the domain are recorded.
+func (eventLog *DomainEventLog) handleIdentity(address string, message string) error {
+	var identity types.PublisherIdentityMessage
+	if message == "" {
+		return nil
+	}
+	_, err := eventLog.messageSigner.VerifyPublication(address, message, &identity)
+	if err == nil && identity.Address != address {
+		err = fmt.Errorf("message address '%s' differs", identity.Address)
+	}
+	if err != nil {
+		return lib.MakeErrorf("handleIdentity: Invalid identity message on '%s': %s", address, err)
+	}
+	publisherAddress := lib.MakeBaseAddress(address)
+
+	eventLog.updateMutex.Lock()
+	signature, isKnown := eventLog.identities[publisherAddress]
+	eventLog.identities[publisherAddress] = identity.IdentitySignature
+	eventLog.updateMutex.Unlock()
+
+	if !isKnown {
+		return eventLog.RecordEvent(types.DomainEventPublisherAppeared, publisherAddress, "")
+	} else if signature != identity.IdentitySignature {
+		return eventLog.RecordEvent(types.DomainEventIdentityChanged, publisherAddress,
+			fmt.Sprintf("issuer=%s validUntil=%s", identity.IssuerID, identity.ValidUntil))
+	}
+	return nil
+}
+
+// handleNode records nodes that are added, removed or moved. A node is removed when its discovery
+// is replaced with an empty message. A node is moved when the location in its discovery changes.
+func (eventLog *DomainEventLog) handleNode(address string, message string) error {
+	var node types.NodeDiscoveryMessage
+	var location *types.NodeLocation
+	nodeAddress := lib.MakeBaseAddress(address)
+	if message != "" {
+		_, err := eventLog.messageSigner.VerifyPublication(address, message, &node)
+		if err == nil && node.Address != address {
+			err = fmt.Errorf("message address '%s' differs", node.Address)
+		}
+		if err != nil {
+			return lib.MakeErrorf("handleNode: Invalid node discovery message on '%s': %s", address, err)
+		}
+		location = types.GetNodeLocation(&node)
+	}
+	eventLog.updateMutex.Lock()
+	previous, isKnown := eventLog.nodes[nodeAddress]
+	if message == "" {
+		delete(eventLog.nodes, nodeAddress)
+	} else {
+		eventLog.nodes[nodeAddress] = location
+	}
+	eventLog.updateMutex.Unlock()
+
+	if message == "" && isKnown {
+		return eventLog.RecordEvent(types.DomainEventNodeRemoved, nodeAddress, "")
+	} else if message != "" && !isKnown {
+		return eventLog.RecordEvent(types.DomainEventNodeAdded, nodeAddress, "")
+	} else if location != nil && (previous == nil || *previous != *location) {
+		return eventLog.RecordEvent(types.DomainEventNodeMoved, nodeAddress,
+			types.FormatLatLon(location.Latitude, location.Longitude))
+	}
+	return nil
+}
+
+// handleStatus records publishers that disconnect, lose their connection or reconnect
+func (eventLog *DomainEventLog) handleStatus(address string, message string) error {
+	var statusMessage types.PublisherStatusMessage
+	if message == "" {
+		return nil
+	}
+	isSigned, err := eventLog.messageSigner.VerifyPublication(address, message, &statusMessage)
+	if err != nil && !isSigned {
+		// the last will of older publishers is the plain status
+		var payload string
+		payload, err = eventLog.messageSigner.VerifyRawPublication(address, message)
+		statusMessage = types.PublisherStatusMessage{Address: address,
+			Status: types.PublisherRunState(strings.Trim(payload, "\""))}
+	}
+	if err == nil && statusMessage.Address != address {
+		err = fmt.Errorf("message address '%s' differs", statusMessage.Address)
+	}
+	if err != nil {
+		return lib.MakeErrorf("handleStatus: Invalid status message on '%s': %s", address, err)
+	}
+	publisherAddress := lib.MakeBaseAddress(address)
+	isLost := statusMessage.Status == types.PublisherRunStateLost ||
+		statusMessage.Status == types.PublisherRunStateDisconnected
+
+	eventLog.updateMutex.Lock()
+	wasLost, isKnown := eventLog.lostStatus[publisherAddress]
+	eventLog.lostStatus[publisherAddress] = isLost
+	eventLog.updateMutex.Unlock()
+
+	if isLost && (!isKnown || !wasLost) {
+		return eventLog.RecordEvent(types.DomainEventPublisherDisappeared, publisherAddress,
+			strings.TrimSpace(string(statusMessage.Status)+" "+statusMessage.Reason))
+	} else if !isLost && wasLost && statusMessage.Status == types.PublisherRunStateConnected {
+		return eventLog.RecordEvent(types.DomainEventPublisherAppeared, publisherAddress, string(statusMessage.Status))
+	}
+	return nil
+}
+
+// makeAddress returns the subscription address of a message type in the domain
+func (eventLog *DomainEventLog) makeAddress(path string, messageType string) string {
+	return fmt.Sprintf("%s/%s/%s", eventLog.domain, path, messageType)
+}
+
+// summaryLoop publishes the summary when the day changes, until stopped
+func (eventLog *DomainEventLog) summaryLoop(stopChannel chan bool) {
+	ticker := time.NewTicker(SummaryCheckInterval * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChannel:
+			return
+		case <-ticker.C:
+			eventLog.updateMutex.Lock()
+			isDue := eventLog.summaryDate != lib.Now().Format(DateFormat)
+			eventLog.updateMutex.Unlock()
+			if isDue {
+				err := eventLog.PublishSummary()
+				if err != nil {
+					logrus.Errorf("DomainEventLog.summaryLoop: %s", err)
+				}
+			}
+		}
+	}
+}
+
+// NewDomainEventLog creates a new instance of the domain event log.
+// Use Start() to start recording.
+//  domain is the domain to observe
+//  publisherID is the publisher that publishes the daily summary
+//  filename is the log file to append the events to. Use "" to only publish the summary.
+func NewDomainEventLog(domain string, publisherID string, filename string,
+	messageSigner *messaging.MessageSigner) *DomainEventLog {
+
+	eventLog := &DomainEventLog{
+		domain:        domain,
+		publisherID:   publisherID,
+		filename:      filename,
+		messageSigner: messageSigner,
+		identities:    make(map[string]string),
+		lostStatus:    make(map[string]bool),
+		nodes:         make(map[string]*types.NodeLocation),
+		counts:        make(map[types.DomainEventType]int),
+		summaryDate:   lib.Now().Format(DateFormat),
+		updateMutex:   &sync.Mutex{},
+	}
+	return eventLog
+}
@@ -0,0 +1,127 @@
+package eventlog_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/eventlog"
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const domain = "test"
+const publisher1ID = "publisher1"
+const publisher2ID = "publisher2"
+
+func TestDomainEventLog(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "eventlog")
+	defer os.RemoveAll(tempFolder)
+	logFilename := path.Join(tempFolder, "events.log")
+
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	eventLog := eventlog.NewDomainEventLog(domain, publisher1ID, logFilename, signer)
+	err := eventLog.Start()
+	require.NoError(t, err)
+
+	// a new publisher appears and its identity changes
+	identityAddr := identities.MakePublisherIdentityAddress(domain, publisher2ID)
+	ident := types.PublisherIdentityMessage{Address: identityAddr, PublisherID: publisher2ID, IdentitySignature: "sig1"}
+	signer.PublishObject(identityAddr, true, &ident, nil)
+	signer.PublishObject(identityAddr, true, &ident, nil)
+	ident.IdentitySignature = "sig2"
+	signer.PublishObject(identityAddr, true, &ident, nil)
+
+	// a node is added, updated, moved and removed
+	nodeAddr := nodes.MakeNodeDiscoveryAddress(domain, publisher2ID, "node1")
+	node := types.NodeDiscoveryMessage{Address: nodeAddr}
+	signer.PublishObject(nodeAddr, true, &node, nil)
+	signer.PublishObject(nodeAddr, true, &node, nil)
+	node.Location = &types.NodeLocation{Latitude: 52.37, Longitude: 4.89}
+	signer.PublishObject(nodeAddr, true, &node, nil)
+	signer.PublishObject(nodeAddr, true, &node, nil)
+	messenger.Publish(nodeAddr, true, "")
+
+	// the publisher connection is lost and restored
+	statusAddr := identities.MakePublisherStatusAddress(domain, publisher2ID)
+	messenger.Publish(statusAddr, false, string(types.PublisherRunStateLost))
+	status := types.PublisherStatusMessage{Address: statusAddr, Status: types.PublisherRunStateConnected}
+	signer.PublishObject(statusAddr, true, &status, nil)
+
+	counts := eventLog.GetCounts()
+	assert.Equal(t, 2, counts[types.DomainEventPublisherAppeared])
+	assert.Equal(t, 1, counts[types.DomainEventIdentityChanged])
+	assert.Equal(t, 1, counts[types.DomainEventNodeAdded])
+	assert.Equal(t, 1, counts[types.DomainEventNodeRemoved])
+	assert.Equal(t, 1, counts[types.DomainEventNodeMoved])
+	assert.Equal(t, 1, counts[types.DomainEventPublisherDisappeared])
+
+	// publishing the summary resets the counts
+	summaryAddr := eventlog.MakeEventSummaryAddress(domain, publisher1ID)
+	err = eventLog.PublishSummary()
+	assert.NoError(t, err)
+	payload, err := messaging.VerifyJWSMessage(messenger.FindLastPublication(summaryAddr), &privKey.PublicKey)
+	require.NoError(t, err)
+	var summary types.DomainEventSummaryMessage
+	err = json.Unmarshal([]byte(payload), &summary)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.Counts[types.DomainEventNodeAdded])
+	assert.Equal(t, 0, len(eventLog.GetCounts()))
+	eventLog.Stop()
+
+	// each event is appended to the log
+	logData, err := ioutil.ReadFile(logFilename)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(logData)), "\n")
+	assert.Equal(t, 7, len(lines))
+	var event types.DomainEvent
+	err = json.Unmarshal([]byte(lines[0]), &event)
+	assert.NoError(t, err)
+	assert.Equal(t, types.DomainEventPublisherAppeared, event.EventType)
+
+}
+
+// messages that fail signature verification are not recorded
+func TestDomainEventLogForgedMessages(t *testing.T) {
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	eventLog := eventlog.NewDomainEventLog(domain, publisher1ID, "", signer)
+	err := eventLog.Start()
+	require.NoError(t, err)
+	defer eventLog.Stop()
+
+	// messages signed with another key than the publisher's key
+	forger := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), getPubKey)
+	identityAddr := identities.MakePublisherIdentityAddress(domain, publisher2ID)
+	ident := types.PublisherIdentityMessage{Address: identityAddr, PublisherID: publisher2ID, IdentitySignature: "sig1"}
+	forger.PublishObject(identityAddr, true, &ident, nil)
+	nodeAddr := nodes.MakeNodeDiscoveryAddress(domain, publisher2ID, "node1")
+	node := types.NodeDiscoveryMessage{Address: nodeAddr}
+	forger.PublishObject(nodeAddr, true, &node, nil)
+	statusAddr := identities.MakePublisherStatusAddress(domain, publisher2ID)
+	status := types.PublisherStatusMessage{Address: statusAddr, Status: types.PublisherRunStateLost}
+	forger.PublishObject(statusAddr, true, &status, nil)
+
+	// a message for another publisher signed by a known publisher
+	node.Address = nodes.MakeNodeDiscoveryAddress(domain, publisher1ID, "node1")
+	signer.PublishObject(nodeAddr, true, &node, nil)
+
+	assert.Equal(t, 0, len(eventLog.GetCounts()))
+}
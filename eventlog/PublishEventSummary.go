@@ -0,0 +1,33 @@
+package eventlog
+
+import (
+	"fmt"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MakeEventSummaryAddress returns the address of the event summary: domain/publisherID/$eventSummary
+func MakeEventSummaryAddress(domain string, publisherID string) string {
+	address := fmt.Sprintf("%s/%s/%s", domain, publisherID, types.MessageTypeEventSummary)
+	return address
+}
+
+// PublishEventSummary publishes the retained summary of domain events of a day
+//  date is the day of the summary, YYYY-MM-DD
+//  counts contains the number of events by type
+func PublishEventSummary(address string, date string, counts map[types.DomainEventType]int,
+	messageSigner *messaging.MessageSigner) error {
+
+	logrus.Infof("PublishEventSummary: publish summary of %s to %s", date, address)
+	message := &types.DomainEventSummaryMessage{
+		Address:   address,
+		Counts:    counts,
+		Date:      date,
+		Timestamp: lib.Now().Format(types.TimeFormat),
+	}
+	err := messageSigner.PublishObject(address, true, message, nil)
+	return err
+}
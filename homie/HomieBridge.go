@@ -0,0 +1,342 @@
+// Package homie with a bridge that mirrors registered nodes to the Homie 4.0 MQTT convention
+package homie
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// HomieVersion is the version of the Homie convention implemented by the bridge
+const HomieVersion = "4.0"
+
+// DefaultBaseTopic is the Homie root topic
+const DefaultBaseTopic = "homie"
+
+// HomieSender is the sender passed to input handlers for set commands received through Homie
+const HomieSender = "homie"
+
+// Homie device states
+const (
+	HomieStateDisconnected = "disconnected"
+	HomieStateInit         = "init"
+	HomieStateReady        = "ready"
+)
+
+// HomieConfig with the configuration of the Homie bridge
+type HomieConfig struct {
+	BaseTopic string `yaml:"baseTopic"` // Homie root topic. Default is DefaultBaseTopic
+	DeviceID  string `yaml:"deviceId"`  // Homie device ID. Default is the publisher ID
+}
+
+// HomieDevice provides the nodes, inputs and outputs that are mirrored as the Homie device, eg a
+// publisher.
+type HomieDevice interface {
+	// GetNodes returns the registered nodes
+	GetNodes() []*types.NodeDiscoveryMessage
+	// GetNodeByHWID returns a registered node, nil if not found
+	GetNodeByHWID(nodeHWID string) *types.NodeDiscoveryMessage
+	// GetInputsByNodeHWID returns the registered inputs of a node
+	GetInputsByNodeHWID(nodeHWID string) []*types.InputDiscoveryMessage
+	// GetOutputsByNodeHWID returns the registered outputs of a node
+	GetOutputsByNodeHWID(nodeHWID string) []*types.OutputDiscoveryMessage
+	// GetOutputValueByID returns the latest value of an output, nil if it has no value
+	GetOutputValueByID(outputID string) *types.OutputValue
+	// NotifyInputHandler passes a set command to the handler of an input
+	NotifyInputHandler(inputID string, sender string, value string)
+}
+
+// homie IDs only contain lowercase letters, digits and hyphens
+var invalidIDChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// HomieBridge mirrors the registered nodes, outputs and inputs of a publisher to the Homie convention.
+// The publisher is the Homie device, registered nodes are Homie nodes and outputs and inputs are Homie
+// properties. Inputs are settable properties. Set commands received on a property are passed to the
+// input handler. Homie messages are not signed as Homie controllers don't support signatures.
+// Attach the bridge to a publisher with AddIntegration to update the device as the publisher publishes.
+type HomieBridge struct {
+	baseTopic   string               // Homie root topic
+	device      HomieDevice          // nodes, inputs and outputs to mirror
+	deviceID    string               // Homie device ID
+	messenger   messaging.IMessenger // messenger to publish Homie messages
+	setInputs   map[string]string    // inputID by set topic
+	isRunning   bool                 // the bridge is started
+	updateMutex *sync.Mutex          // mutex for concurrent updates
+}
+
+// homieProperty is a Homie property of an output and/or input
+type homieProperty struct {
+	id     string
+	output *types.OutputDiscoveryMessage
+	input  *types.InputDiscoveryMessage
+}
+
+// DiscoveryPublished publishes the Homie device after the publisher published updated nodes, inputs or
+// outputs
+func (bridge *HomieBridge) DiscoveryPublished() {
+	err := bridge.PublishDevice()
+	if err != nil {
+		logrus.Errorf("HomieBridge.DiscoveryPublished: %s", err)
+	}
+}
+
+// OutputValuePublished publishes the output value to its Homie property after the publisher published it
+func (bridge *HomieBridge) OutputValuePublished(output *types.OutputDiscoveryMessage, value *types.OutputValue,
+	history outputs.OutputHistory) {
+	err := bridge.PublishOutputValue(output, value.Value)
+	if err != nil {
+		logrus.Errorf("HomieBridge.OutputValuePublished: %s", err)
+	}
+}
+
+// PublishDevice publishes the Homie description of the device with its nodes and properties, and the
+// latest output values. Use this after nodes, inputs or outputs are added, changed or removed.
+func (bridge *HomieBridge) PublishDevice() error {
+	deviceTopic := bridge.makeTopic()
+	setInputs := make(map[string]string)
+	var err error
+	publish := func(topic string, value string) {
+		err = firstError(err, bridge.messenger.Publish(topic, true, value))
+	}
+
+	nodeIDs := make([]string, 0)
+	for _, node := range bridge.device.GetNodes() {
+		nodeID := MakeHomieID(node.NodeID)
+		nodeTopic := bridge.makeTopic(nodeID)
+		properties := bridge.getProperties(node.HWID)
+		if nodeID == "" || len(properties) == 0 || node.LocalOnly {
+			// homie nodes require at least one property
+			continue
+		}
+		nodeIDs = append(nodeIDs, nodeID)
+		nodeName := node.Attr[types.NodeAttrName]
+		if nodeName == "" {
+			nodeName = node.NodeID
+		}
+		publish(nodeTopic+"/$name", nodeName)
+		publish(nodeTopic+"/$type", node.Attr[types.NodeAttrType])
+
+		propertyIDs := make([]string, 0, len(properties))
+		for _, property := range properties {
+			propertyIDs = append(propertyIDs, property.id)
+			propertyTopic := nodeTopic + "/" + property.id
+			dataType, format, unit := bridge.getPropertyFormat(property)
+			publish(propertyTopic+"/$name", property.id)
+			publish(propertyTopic+"/$datatype", dataType)
+			if format != "" {
+				publish(propertyTopic+"/$format", format)
+			}
+			if unit != "" {
+				publish(propertyTopic+"/$unit", unit)
+			}
+			publish(propertyTopic+"/$settable", fmt.Sprint(property.input != nil))
+			if property.input != nil {
+				setInputs[propertyTopic+"/set"] = property.input.InputID
+			}
+			if property.output != nil {
+				latest := bridge.device.GetOutputValueByID(property.output.OutputID)
+				if latest != nil {
+					publish(propertyTopic, latest.Value)
+				}
+			}
+		}
+		publish(nodeTopic+"/$properties", strings.Join(propertyIDs, ","))
+	}
+	publish(deviceTopic+"/$homie", HomieVersion)
+	publish(deviceTopic+"/$name", bridge.deviceID)
+	publish(deviceTopic+"/$nodes", strings.Join(nodeIDs, ","))
+
+	bridge.updateMutex.Lock()
+	bridge.setInputs = setInputs
+	bridge.updateMutex.Unlock()
+	if err != nil {
+		return lib.MakeErrorf("HomieBridge.PublishDevice: Failed publishing device %s: %s", deviceTopic, err)
+	}
+	return nil
+}
+
+// PublishOutputValue publishes the value of an output to its Homie property
+func (bridge *HomieBridge) PublishOutputValue(output *types.OutputDiscoveryMessage, value string) error {
+	node := bridge.device.GetNodeByHWID(output.NodeHWID)
+	if node == nil {
+		return lib.MakeErrorf("HomieBridge.PublishOutputValue: Node of output %s not found", output.OutputID)
+	} else if node.LocalOnly || output.LocalOnly {
+		return nil
+	}
+	propertyTopic := bridge.makeTopic(MakeHomieID(node.NodeID), makePropertyID(string(output.OutputType), output.Instance))
+	return bridge.messenger.Publish(propertyTopic, true, value)
+}
+
+// Start publishing the Homie device and listening for set commands of settable properties
+func (bridge *HomieBridge) Start() error {
+	bridge.updateMutex.Lock()
+	if bridge.isRunning {
+		bridge.updateMutex.Unlock()
+		return nil
+	}
+	bridge.isRunning = true
+	bridge.updateMutex.Unlock()
+
+	stateTopic := bridge.makeTopic("$state")
+	bridge.messenger.Publish(stateTopic, true, HomieStateInit)
+	err := bridge.PublishDevice()
+	bridge.messenger.Subscribe(bridge.makeTopic("+", "+", "set"), bridge.handleSet)
+	bridge.messenger.Publish(stateTopic, true, HomieStateReady)
+	return err
+}
+
+// Stop listening for set commands and publish the disconnected state
+func (bridge *HomieBridge) Stop() {
+	bridge.updateMutex.Lock()
+	if !bridge.isRunning {
+		bridge.updateMutex.Unlock()
+		return
+	}
+	bridge.isRunning = false
+	bridge.updateMutex.Unlock()
+
+	bridge.messenger.Unsubscribe(bridge.makeTopic("+", "+", "set"), bridge.handleSet)
+	bridge.messenger.Publish(bridge.makeTopic("$state"), true, HomieStateDisconnected)
+}
+
+// getProperties returns the properties of a node sorted by ID. Outputs and inputs with the same
+// type and instance share a property. Local-only inputs and outputs are excluded.
+func (bridge *HomieBridge) getProperties(nodeHWID string) []*homieProperty {
+	propertyMap := make(map[string]*homieProperty)
+	getProperty := func(propertyID string) *homieProperty {
+		property := propertyMap[propertyID]
+		if property == nil {
+			property = &homieProperty{id: propertyID}
+			propertyMap[propertyID] = property
+		}
+		return property
+	}
+	for _, output := range bridge.device.GetOutputsByNodeHWID(nodeHWID) {
+		if output.LocalOnly {
+			continue
+		}
+		getProperty(makePropertyID(string(output.OutputType), output.Instance)).output = output
+	}
+	for _, input := range bridge.device.GetInputsByNodeHWID(nodeHWID) {
+		if input.LocalOnly {
+			continue
+		}
+		getProperty(makePropertyID(string(input.InputType), input.Instance)).input = input
+	}
+	properties := make([]*homieProperty, 0, len(propertyMap))
+	for _, property := range propertyMap {
+		properties = append(properties, property)
+	}
+	sort.Slice(properties, func(i, j int) bool { return properties[i].id < properties[j].id })
+	return properties
+}
+
+// getPropertyFormat returns the Homie datatype, format and unit of a property
+func (bridge *HomieBridge) getPropertyFormat(property *homieProperty) (dataType string, format string, unit string) {
+	var valueType types.DataType
+	var enumValues []string
+	if property.output != nil {
+		valueType, enumValues, unit = property.output.DataType, property.output.EnumValues, string(property.output.Unit)
+	} else {
+		valueType, enumValues, unit = property.input.DataType, property.input.EnumValues, string(property.input.Unit)
+	}
+	switch valueType {
+	case types.DataTypeBool:
+		dataType = "boolean"
+	case types.DataTypeInt:
+		dataType = "integer"
+	case types.DataTypeNumber:
+		dataType = "float"
+	case types.DataTypeDate:
+		dataType = "datetime"
+	case types.DataTypeEnum:
+		dataType = "enum"
+		format = strings.Join(enumValues, ",")
+	default:
+		dataType = "string"
+	}
+	return dataType, format, unit
+}
+
+// handleSet passes a Homie set command to the handler of the input
+func (bridge *HomieBridge) handleSet(address string, message string) error {
+	bridge.updateMutex.Lock()
+	inputID, found := bridge.setInputs[address]
+	isRunning := bridge.isRunning
+	bridge.updateMutex.Unlock()
+	if !isRunning {
+		return nil
+	} else if !found {
+		logrus.Warningf("HomieBridge.handleSet: No input for set command on %s", address)
+		return nil
+	}
+	logrus.Infof("HomieBridge.handleSet: Set input %s to '%s'", inputID, message)
+	bridge.device.NotifyInputHandler(inputID, HomieSender, message)
+	return nil
+}
+
+// ValuesPublished does nothing as output values are published to Homie as they are published
+func (bridge *HomieBridge) ValuesPublished() {
+}
+
+// makeTopic returns the topic of the device with the given sub topics
+func (bridge *HomieBridge) makeTopic(subTopics ...string) string {
+	topic := bridge.baseTopic + "/" + bridge.deviceID
+	for _, subTopic := range subTopics {
+		topic += "/" + subTopic
+	}
+	return topic
+}
+
+// firstError returns the first of two errors that is not nil
+func firstError(err1 error, err2 error) error {
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// makePropertyID returns the Homie property ID of an input or output type and instance
+func makePropertyID(ioType string, instance string) string {
+	return MakeHomieID(ioType + "-" + instance)
+}
+
+// MakeHomieID converts an ID to a valid Homie ID containing lowercase letters, digits and hyphens
+// Invalid characters are replaced by a hyphen.
+func MakeHomieID(id string) string {
+	homieID := invalidIDChars.ReplaceAllString(strings.ToLower(id), "-")
+	return strings.Trim(homieID, "-")
+}
+
+// NewHomieBridge creates a bridge that mirrors the registered nodes, inputs and outputs to Homie
+// Use Start() to publish the device and listen for set commands, or attach the bridge to the publisher
+// with AddIntegration.
+//  deviceID is the Homie device ID, usually the publisher ID
+//  device provides the nodes, inputs and outputs to mirror, usually the publisher
+func NewHomieBridge(config HomieConfig, deviceID string, messenger messaging.IMessenger,
+	device HomieDevice) *HomieBridge {
+
+	if config.BaseTopic == "" {
+		config.BaseTopic = DefaultBaseTopic
+	}
+	if config.DeviceID != "" {
+		deviceID = config.DeviceID
+	}
+	bridge := &HomieBridge{
+		baseTopic:   config.BaseTopic,
+		device:      device,
+		deviceID:    MakeHomieID(deviceID),
+		messenger:   messenger,
+		setInputs:   make(map[string]string),
+		updateMutex: &sync.Mutex{},
+	}
+	return bridge
+}
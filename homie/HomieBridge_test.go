@@ -0,0 +1,104 @@
+package homie_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/homie"
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const domain = "test"
+const publisher1ID = "Publisher1"
+const node1ID = "node1"
+
+var msgConfig = &messaging.MessengerConfig{Domain: domain}
+
+// testDevice provides the registered nodes, inputs and outputs to the bridge
+type testDevice struct {
+	regNodes   *nodes.RegisteredNodes
+	regInputs  *inputs.RegisteredInputs
+	regOutputs *outputs.RegisteredOutputs
+	regValues  *outputs.RegisteredOutputValues
+}
+
+func (device *testDevice) GetNodes() []*types.NodeDiscoveryMessage {
+	return device.regNodes.GetAllNodes()
+}
+func (device *testDevice) GetNodeByHWID(nodeHWID string) *types.NodeDiscoveryMessage {
+	return device.regNodes.GetNodeByHWID(nodeHWID)
+}
+func (device *testDevice) GetInputsByNodeHWID(nodeHWID string) []*types.InputDiscoveryMessage {
+	return device.regInputs.GetInputsByNodeHWID(nodeHWID)
+}
+func (device *testDevice) GetOutputsByNodeHWID(nodeHWID string) []*types.OutputDiscoveryMessage {
+	return device.regOutputs.GetOutputsByNodeHWID(nodeHWID)
+}
+func (device *testDevice) GetOutputValueByID(outputID string) *types.OutputValue {
+	return device.regValues.GetOutputValueByID(outputID)
+}
+func (device *testDevice) NotifyInputHandler(inputID string, sender string, value string) {
+	device.regInputs.NotifyInputHandler(inputID, sender, value)
+}
+
+func TestMakeHomieID(t *testing.T) {
+	assert.Equal(t, "publisher1", homie.MakeHomieID("Publisher1"))
+	assert.Equal(t, "switch-0", homie.MakeHomieID("switch/0"))
+	assert.Equal(t, "my-node", homie.MakeHomieID("_my node_"))
+}
+
+func TestHomieBridge(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	regNodes := nodes.NewRegisteredNodes(domain, publisher1ID)
+	regInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+	regOutputs := outputs.NewRegisteredOutputs(domain, publisher1ID)
+	regValues := outputs.NewRegisteredOutputValues(domain, publisher1ID)
+	bridge := homie.NewHomieBridge(homie.HomieConfig{}, publisher1ID, messenger,
+		&testDevice{regNodes: regNodes, regInputs: regInputs, regOutputs: regOutputs, regValues: regValues})
+
+	regNodes.CreateNode(node1ID, types.NodeTypeUnknown)
+	output := regOutputs.CreateOutput(node1ID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+	output.DataType = types.DataTypeBool
+	regOutputs.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	regValues.UpdateOutputValue(output.OutputID, "false")
+	var setValue string
+	regInputs.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			assert.Equal(t, homie.HomieSender, sender)
+			setValue = value
+		})
+
+	err := bridge.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, homie.HomieVersion, messenger.FindLastPublication("homie/publisher1/$homie"))
+	assert.Equal(t, homie.HomieStateReady, messenger.FindLastPublication("homie/publisher1/$state"))
+	assert.Equal(t, node1ID, messenger.FindLastPublication("homie/publisher1/$nodes"))
+	assert.Equal(t, "switch-0,temperature-0", messenger.FindLastPublication("homie/publisher1/node1/$properties"))
+	assert.Equal(t, "boolean", messenger.FindLastPublication("homie/publisher1/node1/switch-0/$datatype"))
+	assert.Equal(t, "true", messenger.FindLastPublication("homie/publisher1/node1/switch-0/$settable"))
+	assert.Equal(t, "false", messenger.FindLastPublication("homie/publisher1/node1/temperature-0/$settable"))
+	assert.Equal(t, "false", messenger.FindLastPublication("homie/publisher1/node1/switch-0"))
+
+	// value updates
+	err = bridge.PublishOutputValue(output, "true")
+	assert.NoError(t, err)
+	assert.Equal(t, "true", messenger.FindLastPublication("homie/publisher1/node1/switch-0"))
+	bridge.OutputValuePublished(output, &types.OutputValue{Value: "false"}, nil)
+	assert.Equal(t, "false", messenger.FindLastPublication("homie/publisher1/node1/switch-0"))
+
+	// set commands are passed to the input handler
+	messenger.OnReceive("homie/publisher1/node1/switch-0/set", "true")
+	assert.Equal(t, "true", setValue)
+	setValue = ""
+	messenger.OnReceive("homie/publisher1/node1/temperature-0/set", "20")
+	assert.Equal(t, "", setValue, "Not expecting a set of a non-settable property")
+
+	bridge.Stop()
+	assert.Equal(t, homie.HomieStateDisconnected, messenger.FindLastPublication("homie/publisher1/$state"))
+	messenger.OnReceive("homie/publisher1/node1/switch-0/set", "false")
+	assert.Equal(t, "", setValue, "Not expecting a set after stop")
+}
@@ -0,0 +1,246 @@
+// Package identities with X.509 certificate based publisher identities
+package identities
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// CertificateRenewalWarning is the time before a certificate expires that a warning is logged
+const CertificateRenewalWarning = 30 * 24 * time.Hour
+
+// CreateCACertificate creates a self-signed certificate of a certificate authority that issues
+// publisher certificates, eg for sites that don't have a PKI yet or for testing.
+func CreateCACertificate(commonName string, caKey *ecdsa.PrivateKey, validUntil time.Time) (*x509.Certificate, error) {
+	template := &x509.Certificate{
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		NotAfter:              validUntil,
+		NotBefore:             time.Now().Add(-time.Minute),
+		SerialNumber:          newSerialNumber(),
+		Subject:               pkix.Name{CommonName: commonName},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, lib.MakeErrorf("CreateCACertificate: Unable to create certificate for '%s': %s", commonName, err)
+	}
+	return x509.ParseCertificate(certDER)
+}
+
+// CertificateToPem converts a certificate to PEM format
+func CertificateToPem(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+// IssueIdentityCertificate issues an X.509 certificate for the public key of a publisher identity
+// signed by a certificate authority. The certificate common name is the publisher ID, its
+// organizational unit the domain, and it expires when the identity expires.
+// Returns the certificate in PEM format.
+func IssueIdentityCertificate(ident *types.PublisherIdentityMessage,
+	caCert *x509.Certificate, caKey *ecdsa.PrivateKey) (certPem string, err error) {
+
+	publicKey := messaging.PublicKeyFromPem(ident.PublicKey)
+	validUntil, err := time.Parse(types.TimeFormat, ident.ValidUntil)
+	if publicKey == nil || err != nil {
+		return "", lib.MakeErrorf("IssueIdentityCertificate: Identity '%s' has no valid key or expiry", ident.Address)
+	}
+	template := &x509.Certificate{
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		NotAfter:     validUntil,
+		NotBefore:    time.Now().Add(-time.Minute),
+		SerialNumber: newSerialNumber(),
+		Subject: pkix.Name{
+			CommonName:         ident.PublisherID,
+			Organization:       []string{ident.Organization},
+			OrganizationalUnit: []string{ident.Domain},
+		},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, publicKey, caKey)
+	if err != nil {
+		return "", lib.MakeErrorf("IssueIdentityCertificate: Unable to issue certificate for '%s': %s",
+			ident.Address, err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})), nil
+}
+
+// LoadCABundle loads the PEM encoded certificates of the certificate authorities that issue
+// publisher certificates
+func LoadCABundle(filename string) (*x509.CertPool, error) {
+	bundlePem, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, lib.MakeErrorf("LoadCABundle: Unable to read CA bundle %s: %s", filename, err)
+	}
+	caBundle := x509.NewCertPool()
+	if !caBundle.AppendCertsFromPEM(bundlePem) {
+		return nil, lib.MakeErrorf("LoadCABundle: No certificates found in CA bundle %s", filename)
+	}
+	return caBundle, nil
+}
+
+// ParseCertificate parses a PEM encoded X.509 certificate
+func ParseCertificate(certPem string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPem))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, lib.MakeErrorf("ParseCertificate: Certificate is not in PEM format")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// VerifyIdentityCertificate verifies the X.509 certificate of a publisher identity against the
+// CA bundle. Verification fails when:
+//  - the identity has no certificate, or its domain/publisherID doesn't match the received address
+//  - the certificate isn't issued by a CA in the bundle
+//  - the certificate is expired or not yet valid
+//  - the certificate common name isn't the publisher ID
+//  - the certificate organizational units don't include the identity domain
+//  - the certificate key isn't the identity public key
+func VerifyIdentityCertificate(rxAddress string, ident *types.PublisherIdentityMessage,
+	caBundle *x509.CertPool) error {
+
+	if ident.Certificate == "" || ident.Address != rxAddress ||
+		rxAddress != MakePublisherIdentityAddress(ident.Domain, ident.PublisherID) {
+		return lib.MakeErrorf("VerifyIdentityCertificate: Identity '%s' has no certificate or an invalid address",
+			rxAddress)
+	}
+	cert, err := ParseCertificate(ident.Certificate)
+	if err != nil {
+		return lib.MakeErrorf("VerifyIdentityCertificate: Invalid certificate of '%s': %s", rxAddress, err)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     caBundle,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return lib.MakeErrorf("VerifyIdentityCertificate: Certificate of '%s' fails to verify: %s", rxAddress, err)
+	}
+	if cert.Subject.CommonName != ident.PublisherID {
+		return lib.MakeErrorf("VerifyIdentityCertificate: Certificate of '%s' is issued to '%s'",
+			rxAddress, cert.Subject.CommonName)
+	} else if !isCertificateDomain(cert, ident.Domain) {
+		return lib.MakeErrorf("VerifyIdentityCertificate: Certificate of '%s' is issued for domains %v",
+			rxAddress, cert.Subject.OrganizationalUnit)
+	}
+	certKey, isECDSA := cert.PublicKey.(*ecdsa.PublicKey)
+	identKey := messaging.PublicKeyFromPem(ident.PublicKey)
+	if !isECDSA || identKey == nil || !isSameKey(certKey, identKey) {
+		return lib.MakeErrorf("VerifyIdentityCertificate: Certificate key of '%s' doesn't match the identity", rxAddress)
+	}
+	return nil
+}
+
+// LoadIdentityCertificate replaces the identity with one based on an X.509 certificate and its
+// ECDSA private key, eg issued by the site's PKI. The certificate is included in the published
+// identity so consumers can verify it against their CA bundle. The identity expires with the
+// certificate.
+//  certFile and keyFile are the PEM encoded certificate and private key files
+// Returns an error if the certificate isn't issued to this publisher, is expired or doesn't
+// match the key. The existing identity remains unchanged in that case.
+func (regIdentity *RegisteredIdentity) LoadIdentityCertificate(certFile string, keyFile string) error {
+	certPem, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return lib.MakeErrorf("LoadIdentityCertificate: Unable to read certificate %s: %s", certFile, err)
+	}
+	cert, err := ParseCertificate(string(certPem))
+	if err != nil {
+		return lib.MakeErrorf("LoadIdentityCertificate: Invalid certificate %s: %s", certFile, err)
+	}
+	privKey, err := loadPrivateKey(keyFile)
+	if err != nil {
+		return err
+	}
+	certKey, isECDSA := cert.PublicKey.(*ecdsa.PublicKey)
+	if !isECDSA || !isSameKey(certKey, &privKey.PublicKey) {
+		return lib.MakeErrorf("LoadIdentityCertificate: Certificate %s doesn't match key %s", certFile, keyFile)
+	} else if cert.Subject.CommonName != regIdentity.publisherID {
+		return lib.MakeErrorf("LoadIdentityCertificate: Certificate %s is issued to '%s' instead of '%s'",
+			certFile, cert.Subject.CommonName, regIdentity.publisherID)
+	} else if !isCertificateDomain(cert, regIdentity.domain) {
+		return lib.MakeErrorf("LoadIdentityCertificate: Certificate %s is issued for domains %v instead of '%s'",
+			certFile, cert.Subject.OrganizationalUnit, regIdentity.domain)
+	}
+	now := time.Now()
+	if now.After(cert.NotAfter) || now.Before(cert.NotBefore) {
+		return lib.MakeErrorf("LoadIdentityCertificate: Certificate %s is only valid from %s until %s",
+			certFile, cert.NotBefore.Format(types.TimeFormat), cert.NotAfter.Format(types.TimeFormat))
+	} else if cert.NotAfter.Sub(now) < CertificateRenewalWarning {
+		logrus.Warningf("LoadIdentityCertificate: Certificate %s expires on %s. Renew it in time.",
+			certFile, cert.NotAfter.Format(types.TimeFormat))
+	}
+
+	organization := ""
+	if len(cert.Subject.Organization) > 0 {
+		organization = cert.Subject.Organization[0]
+	}
+	publicIdentity := types.PublisherIdentityMessage{
+		Address:      MakePublisherIdentityAddress(regIdentity.domain, regIdentity.publisherID),
+		Certificate:  string(certPem),
+		Domain:       regIdentity.domain,
+		IssuerID:     regIdentity.publisherID, // the certificate is the proof of issuance
+		Location:     "local",
+		Organization: organization,
+		PublicKey:    messaging.PublicKeyToPem(&privKey.PublicKey),
+		PublisherID:  regIdentity.publisherID,
+		Timestamp:    now.Format(types.TimeFormat),
+		ValidUntil:   cert.NotAfter.Format(types.TimeFormat),
+	}
+	messaging.SignIdentity(&publicIdentity, privKey)
+	regIdentity.fullIdentity = &types.PublisherFullIdentity{
+		PublisherIdentityMessage: publicIdentity,
+		PrivateKey:               messaging.PrivateKeyToPem(privKey),
+	}
+	regIdentity.privateKey = privKey
+	regIdentity.updated = true
+	logrus.Infof("LoadIdentityCertificate: Identity of %s loaded from certificate %s issued by '%s'",
+		regIdentity.publisherID, certFile, cert.Issuer.CommonName)
+	return nil
+}
+
+// loadPrivateKey loads an ECDSA private key in SEC1 or PKCS#8 PEM format
+func loadPrivateKey(keyFile string) (*ecdsa.PrivateKey, error) {
+	keyPem, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, lib.MakeErrorf("loadPrivateKey: Unable to read key %s: %s", keyFile, err)
+	}
+	block, _ := pem.Decode(keyPem)
+	if block == nil {
+		return nil, lib.MakeErrorf("loadPrivateKey: Key %s is not in PEM format", keyFile)
+	}
+	privKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		genericKey, err2 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if ecKey, isECDSA := genericKey.(*ecdsa.PrivateKey); err2 == nil && isECDSA {
+			return ecKey, nil
+		}
+		return nil, lib.MakeErrorf("loadPrivateKey: Key %s is not an ECDSA private key: %s", keyFile, err)
+	}
+	return privKey, nil
+}
+
+// newSerialNumber returns a random certificate serial number
+func newSerialNumber() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
+
+// isCertificateDomain returns true if the organizational units of the certificate subject include
+// the domain, see IssueIdentityCertificate
+func isCertificateDomain(cert *x509.Certificate, domain string) bool {
+	for _, unit := range cert.Subject.OrganizationalUnit {
+		if unit == domain {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package identities_test
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityCertificate(t *testing.T) {
+	const domain = "test"
+	const publisherID = "pub1"
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+
+	caKeys := messaging.CreateAsymKeys()
+	caCert, err := identities.CreateCACertificate("site CA", caKeys, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	caBundle := x509.NewCertPool()
+	caBundle.AddCert(caCert)
+	bundleFile := path.Join(folder, "ca.pem")
+	ioutil.WriteFile(bundleFile, []byte(identities.CertificateToPem(caCert)), 0600)
+	loadedBundle, err := identities.LoadCABundle(bundleFile)
+	require.NoError(t, err)
+	require.NotNil(t, loadedBundle)
+
+	// the site PKI issues a certificate for the publisher key
+	ident, identKeys := identities.CreateIdentity(domain, publisherID)
+	certPem, err := identities.IssueIdentityCertificate(&ident.PublisherIdentityMessage, caCert, caKeys)
+	require.NoError(t, err)
+	certFile := path.Join(folder, "pub1-cert.pem")
+	keyFile := path.Join(folder, "pub1-key.pem")
+	ioutil.WriteFile(certFile, []byte(certPem), 0600)
+	ioutil.WriteFile(keyFile, []byte(messaging.PrivateKeyToPem(identKeys)), 0600)
+
+	regIdentity := identities.NewRegisteredIdentity(domain, publisherID, "")
+	err = regIdentity.LoadIdentityCertificate(certFile, keyFile)
+	require.NoError(t, err)
+	fullIdent, privKey := regIdentity.GetFullIdentity()
+	assert.Equal(t, identKeys.D, privKey.D)
+	assert.Equal(t, certPem, fullIdent.Certificate)
+	err = identities.VerifyIdentityCertificate(fullIdent.Address, &fullIdent.PublisherIdentityMessage, caBundle)
+	assert.NoError(t, err)
+
+	// consumers with the CA bundle trust the identity
+	collection := identities.NewDomainPublisherIdentities()
+	collection.SetCABundle(loadedBundle)
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer := messaging.NewMessageSigner(messenger, privKey, collection.GetPublisherKey)
+	receiver := identities.NewReceivePublisherIdentities(domain, collection, signer)
+	receiver.Start()
+	defer receiver.Stop()
+	signer.PublishObject(fullIdent.Address, false, fullIdent.PublisherIdentityMessage, nil)
+	require.NotNil(t, collection.GetPublisherByAddress(fullIdent.Address))
+	assert.True(t, collection.IsTrusted(fullIdent.Address))
+
+	// error case - a certificate of another CA doesn't verify
+	otherKeys := messaging.CreateAsymKeys()
+	otherCA, _ := identities.CreateCACertificate("other CA", otherKeys, time.Now().Add(time.Hour))
+	otherBundle := x509.NewCertPool()
+	otherBundle.AddCert(otherCA)
+	err = identities.VerifyIdentityCertificate(fullIdent.Address, &fullIdent.PublisherIdentityMessage, otherBundle)
+	assert.Error(t, err)
+
+	// error case - certificate of another publisher
+	regIdentity2 := identities.NewRegisteredIdentity(domain, "pub2", "")
+	err = regIdentity2.LoadIdentityCertificate(certFile, keyFile)
+	assert.Error(t, err)
+
+	// error case - certificate for the publisher in another domain
+	otherDomainIdent := ident.PublisherIdentityMessage
+	otherDomainIdent.Domain = "otherdomain"
+	otherDomainPem, err := identities.IssueIdentityCertificate(&otherDomainIdent, caCert, caKeys)
+	require.NoError(t, err)
+	otherDomainFile := path.Join(folder, "otherdomain-cert.pem")
+	ioutil.WriteFile(otherDomainFile, []byte(otherDomainPem), 0600)
+	err = regIdentity.LoadIdentityCertificate(otherDomainFile, keyFile)
+	assert.Error(t, err)
+	otherDomainIdent = fullIdent.PublisherIdentityMessage
+	otherDomainIdent.Certificate = otherDomainPem
+	err = identities.VerifyIdentityCertificate(otherDomainIdent.Address, &otherDomainIdent, caBundle)
+	assert.Error(t, err)
+
+	// error case - certificate doesn't match the key
+	otherKeyFile := path.Join(folder, "other-key.pem")
+	ioutil.WriteFile(otherKeyFile, []byte(messaging.PrivateKeyToPem(otherKeys)), 0600)
+	err = regIdentity.LoadIdentityCertificate(certFile, otherKeyFile)
+	assert.Error(t, err)
+
+	// error case - expired certificate
+	ident.ValidUntil = time.Now().Add(-time.Second).Format(types.TimeFormat)
+	expiredPem, err := identities.IssueIdentityCertificate(&ident.PublisherIdentityMessage, caCert, caKeys)
+	require.NoError(t, err)
+	ioutil.WriteFile(certFile, []byte(expiredPem), 0600)
+	err = regIdentity.LoadIdentityCertificate(certFile, keyFile)
+	assert.Error(t, err)
+	expiredIdent := fullIdent.PublisherIdentityMessage
+	expiredIdent.Certificate = expiredPem
+	err = identities.VerifyIdentityCertificate(expiredIdent.Address, &expiredIdent, caBundle)
+	assert.Error(t, err)
+
+	// error case - missing files
+	_, err = identities.LoadCABundle(path.Join(folder, "notafile.pem"))
+	assert.Error(t, err)
+	err = regIdentity.LoadIdentityCertificate(path.Join(folder, "notafile.pem"), keyFile)
+	assert.Error(t, err)
+}
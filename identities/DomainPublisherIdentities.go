@@ -3,10 +3,11 @@ package identities
 
 import (
 	"crypto/ecdsa"
+	"crypto/x509"
 	"encoding/json"
-	"io/ioutil"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
@@ -16,18 +17,53 @@ import (
 
 // const DSSAddress = ""
 
+// cachedIdentity is the saved identity with the time it was last received
+type cachedIdentity struct {
+	*types.PublisherIdentityMessage
+	Cached  string `json:"cached"`            // time the identity was last received
+	Trusted bool   `json:"trusted,omitempty"` // the identity chains to a trust anchor
+}
+
 // DomainPublisherIdentities with discovered and verified identities of publishers
 type DomainPublisherIdentities struct {
 	c              lib.DomainCollection //
+	caBundle       *x509.CertPool       // optional CA certificates that issue publisher certificates
+	clockSkew      time.Duration        // tolerance of the clock of publishers when checking expiry
 	publicKeyCache map[string]*ecdsa.PublicKey
+	cached         map[string]time.Time        // time identities were last received, by address
+	cacheTTL       time.Duration               // time saved identities remain valid after last received
+	revokedKeys    map[string]string           // identity address of revoked public keys, by PEM key
+	trustAnchors   map[string]*ecdsa.PublicKey // public keys of trusted issuers, eg the DSS or a CA, by issuer ID
+	trusted        map[string]bool             // identities that chain to a trust anchor, by address
 }
 
 // AddIdentity adds a new public identity and generate its public key in the cache
-// If the identity already exists, it will be replaced
+// If the identity already exists, it will be replaced. The identity is not trusted.
 func (pubIdentities *DomainPublisherIdentities) AddIdentity(identity *types.PublisherIdentityMessage) {
+	pubIdentities.addIdentity(identity, lib.Now(), false)
+}
+
+// AddVerifiedIdentity adds a public identity whose issuer chain has been verified
+//  trusted indicates the identity chains to a trust anchor, see GetIssuerKey
+func (pubIdentities *DomainPublisherIdentities) AddVerifiedIdentity(
+	identity *types.PublisherIdentityMessage, trusted bool) {
+	pubIdentities.addIdentity(identity, lib.Now(), trusted)
+}
+
+// addIdentity adds an identity that was last received at the given time
+func (pubIdentities *DomainPublisherIdentities) addIdentity(
+	identity *types.PublisherIdentityMessage, cached time.Time, trusted bool) {
 	pubIdentities.c.Update(identity.Address, identity)
 	pubKey := messaging.PublicKeyFromPem(identity.PublicKey)
+	pubIdentities.c.UpdateMutex.Lock()
 	pubIdentities.publicKeyCache[identity.Address] = pubKey
+	pubIdentities.cached[identity.Address] = cached
+	if trusted {
+		pubIdentities.trusted[identity.Address] = true
+	} else {
+		delete(pubIdentities.trusted, identity.Address)
+	}
+	pubIdentities.c.UpdateMutex.Unlock()
 }
 
 // GetAllPublishers returns a list of discovered publishers
@@ -71,7 +107,9 @@ func (pubIdentities *DomainPublisherIdentities) GetPublisherKey(publisherAddress
 	}
 	identityAddress := MakePublisherIdentityAddress(segments[0], segments[1])
 	// first try using the public key cache
+	pubIdentities.c.UpdateMutex.RLock()
 	pubKey := pubIdentities.publicKeyCache[identityAddress]
+	pubIdentities.c.UpdateMutex.RUnlock()
 	// if pubKey == nil {
 	// 	// if the public key isn't cached yet, try generating it from identity PEM record
 	// 	idMsg := domainIdentities.c.GetByAddress(identityAddress)
@@ -83,11 +121,13 @@ func (pubIdentities *DomainPublisherIdentities) GetPublisherKey(publisherAddress
 }
 
 // LoadIdentities loads previously save identities from file
-// Existing identities are retained but replaced if contained in the file
+// Existing identities are retained but replaced if contained in the file. Identities that are
+// expired or not received within the cache TTL are ignored. Files without the time the identity
+// was received use the identity timestamp.
 func (pubIdentities *DomainPublisherIdentities) LoadIdentities(filename string) error {
-	identList := make([]*types.PublisherIdentityMessage, 0)
+	identList := make([]*cachedIdentity, 0)
 
-	jsonNodes, err := ioutil.ReadFile(filename)
+	jsonNodes, err := lib.ReadFileWithRecovery(filename, lib.ValidateJSON)
 	if err != nil {
 		return lib.MakeErrorf("LoadIdentities: Unable to open file %s: %s", filename, err)
 	}
@@ -95,22 +135,100 @@ func (pubIdentities *DomainPublisherIdentities) LoadIdentities(filename string)
 	if err != nil {
 		return lib.MakeErrorf("LoadIdentities: Error parsing JSON node file %s: %v", filename, err)
 	}
-	logrus.Infof("LoadIdentities: Identities loaded successfully from %s", filename)
+	pubIdentities.c.UpdateMutex.RLock()
+	cacheTTL := pubIdentities.cacheTTL
+	clockSkew := pubIdentities.clockSkew
+	pubIdentities.c.UpdateMutex.RUnlock()
+	expiredCount := 0
 	for _, ident := range identList {
-		pubIdentities.AddIdentity(ident)
+		if ident.PublisherIdentityMessage == nil {
+			continue
+		}
+		cachedStr := ident.Cached
+		if cachedStr == "" {
+			cachedStr = ident.Timestamp
+		}
+		cached, err := time.Parse(types.TimeFormat, cachedStr)
+		if err != nil || time.Since(cached) > cacheTTL || IsIdentityExpired(ident.PublisherIdentityMessage, clockSkew) {
+			expiredCount++
+			continue
+		}
+		pubIdentities.addIdentity(ident.PublisherIdentityMessage, cached, ident.Trusted)
 	}
+	logrus.Infof("LoadIdentities: %d identities loaded successfully from %s. %d expired identities ignored",
+		len(identList)-expiredCount, filename, expiredCount)
 	return nil
 }
 
+// IsRevoked returns true if the public key of the identity is revoked
+func (pubIdentities *DomainPublisherIdentities) IsRevoked(identity *types.PublisherIdentityMessage) bool {
+	pubIdentities.c.UpdateMutex.RLock()
+	defer pubIdentities.c.UpdateMutex.RUnlock()
+	_, isRevoked := pubIdentities.revokedKeys[strings.TrimSpace(identity.PublicKey)]
+	return isRevoked
+}
+
+// RevokeIdentity distrusts a revoked public key of a publisher. If the publisher identity has the
+// revoked key it is removed, so messages signed with the key fail verification, and identities that
+// it issued are no longer trusted. Identities with the revoked key are not accepted afterwards. A
+// new identity of the publisher with another key is accepted, eg when the DSS has renewed it.
+// Returns true if the key wasn't revoked yet.
+func (pubIdentities *DomainPublisherIdentities) RevokeIdentity(revoked *types.RevokedIdentity) bool {
+	revokedKey := strings.TrimSpace(revoked.PublicKey)
+	pubIdentities.c.UpdateMutex.Lock()
+	_, wasRevoked := pubIdentities.revokedKeys[revokedKey]
+	pubIdentities.revokedKeys[revokedKey] = revoked.Address
+	pubIdentities.c.UpdateMutex.Unlock()
+
+	identity := pubIdentities.GetPublisherByAddress(revoked.Address)
+	if identity == nil || strings.TrimSpace(identity.PublicKey) != revokedKey {
+		return !wasRevoked
+	}
+	logrus.Warningf("RevokeIdentity: Identity %s is revoked: %s", revoked.Address, revoked.Reason)
+	pubIdentities.RemoveIdentity(revoked.Address)
+	for _, issued := range pubIdentities.GetAllPublishers() {
+		if issued.Domain == identity.Domain && issued.IssuerID == identity.PublisherID &&
+			issued.PublisherID != identity.PublisherID {
+			pubIdentities.c.UpdateMutex.Lock()
+			delete(pubIdentities.trusted, issued.Address)
+			pubIdentities.c.UpdateMutex.Unlock()
+		}
+	}
+	return !wasRevoked
+}
+
+// RemoveIdentity removes a publisher identity and its public key, eg when the publisher has retired
+// If the identity doesn't exist, this is ignored.
+func (pubIdentities *DomainPublisherIdentities) RemoveIdentity(address string) {
+	pubIdentities.c.Remove(address)
+	pubIdentities.c.UpdateMutex.Lock()
+	delete(pubIdentities.publicKeyCache, address)
+	delete(pubIdentities.cached, address)
+	delete(pubIdentities.trusted, address)
+	pubIdentities.c.UpdateMutex.Unlock()
+}
+
 // SaveIdentities saves previously added identities to file and resets the update count
+// The time each identity was last received is included to expire them when loading.
 func (pubIdentities *DomainPublisherIdentities) SaveIdentities(filename string) error {
 	pubIdentities.c.ResetUpdateCount()
-	collection := pubIdentities.GetAllPublishers()
+	identList := pubIdentities.GetAllPublishers()
+	collection := make([]*cachedIdentity, 0, len(identList))
+	pubIdentities.c.UpdateMutex.RLock()
+	for _, ident := range identList {
+		cached := pubIdentities.cached[ident.Address]
+		collection = append(collection, &cachedIdentity{
+			PublisherIdentityMessage: ident,
+			Cached:                   cached.Format(types.TimeFormat),
+			Trusted:                  pubIdentities.trusted[ident.Address],
+		})
+	}
+	pubIdentities.c.UpdateMutex.RUnlock()
 	jsonText, err := json.MarshalIndent(collection, "", "  ")
 	if err != nil {
 		return lib.MakeErrorf("SaveIdentities: Error Marshalling JSON collection '%s': %v", filename, err)
 	}
-	err = ioutil.WriteFile(filename, jsonText, 0664)
+	err = lib.WriteFileAtomic(filename, jsonText, 0664)
 	if err != nil {
 		return lib.MakeErrorf("SaveIdentities: Error saving collection to JSON file %s: %v", filename, err)
 	}
@@ -118,27 +236,45 @@ func (pubIdentities *DomainPublisherIdentities) SaveIdentities(filename string)
 	return nil
 }
 
+// GetClockSkew returns the tolerance of the clock of publishers when checking the identity expiry
+func (pubIdentities *DomainPublisherIdentities) GetClockSkew() time.Duration {
+	pubIdentities.c.UpdateMutex.RLock()
+	defer pubIdentities.c.UpdateMutex.RUnlock()
+	return pubIdentities.clockSkew
+}
+
+// SetCacheTTL sets the time that saved identities remain valid after they were last received.
+// Default is lib.DefaultCacheTTL.
+func (pubIdentities *DomainPublisherIdentities) SetCacheTTL(cacheTTL time.Duration) {
+	pubIdentities.c.UpdateMutex.Lock()
+	defer pubIdentities.c.UpdateMutex.Unlock()
+	pubIdentities.cacheTTL = cacheTTL
+}
+
+// SetClockSkew sets the tolerance of the clock of publishers when checking the identity expiry.
+// Default is lib.DefaultClockSkew. Use 0 to not tolerate any skew.
+func (pubIdentities *DomainPublisherIdentities) SetClockSkew(skew time.Duration) {
+	pubIdentities.c.UpdateMutex.Lock()
+	defer pubIdentities.c.UpdateMutex.Unlock()
+	pubIdentities.clockSkew = skew
+}
+
 // UpdateCount returns the nr of updates to identities since the last SaveIdentities call
 func (pubIdentities *DomainPublisherIdentities) UpdateCount() int {
 	return pubIdentities.c.UpdateCount()
 }
 
-// VerifyPublisherIdentity verifies the integrity of the given identity record
+// VerifyIssuedIdentity verifies the integrity of an identity record issued by any issuer, eg a CA
 // Verification fails when:
 //  - domain/publisherID doesn't match the received address
 //  - the issuer ID or its public key is missing
-//  - the issuer is either the DSS or the publisher itself (self-signed)
 //  - identity is expired
-//  - a newer identity is already received
-//  - the identity signature doesn't verify against the signing key (if provided)
+//  - the identity signature doesn't verify against the issuer key, or its own key if self-signed
 //
-// The signing key is only available if the identity of the issuer is known;
-//  When a secured domain is joined, the issuer is the DSS whose identity must be received first.
-//  When no secured domain is joined, the identity is self signed. Protection is
-//   based on message bus ACLs. Only publishers can self sign their own identity.
-//  When the issuer is a CA, the CA public key must be known
-func VerifyPublisherIdentity(rxAddress string, ident *types.PublisherIdentityMessage,
-	dssSigningKey *ecdsa.PublicKey) error {
+// Use GetIssuerKey to obtain the issuer key by following the chain of issuers to a trust anchor.
+//  clockSkew is the tolerance of the issuer clock when checking the expiry, see GetClockSkew
+func VerifyIssuedIdentity(rxAddress string, ident *types.PublisherIdentityMessage,
+	issuerKey *ecdsa.PublicKey, clockSkew time.Duration) error {
 
 	var signingKey *ecdsa.PublicKey
 
@@ -160,29 +296,20 @@ func VerifyPublisherIdentity(rxAddress string, ident *types.PublisherIdentityMes
 			ident.Domain, ident.PublisherID, ident.Address)
 		return err
 	}
-	// only DSS or publisher itself are allowed to issue identity
-	// TODO: support CA
-	if ident.IssuerID != ident.PublisherID &&
-		ident.IssuerID != types.DSSPublisherID {
-
-		err := lib.MakeErrorf("VerifyPublisherIdentity: identity issuer %s of domain/publisher %s/%s must "+
-			"be the DSS or self-signed", ident.IssuerID, ident.Domain, ident.PublisherID)
-		return err
-	}
 
 	// identity must not be expired
-	expired := IsIdentityExpired(ident)
+	expired := IsIdentityExpired(ident, clockSkew)
 	if expired {
 		err := lib.MakeErrorf("VerifyIdentity: Identity '%s' is expired", rxAddress)
 		return err
 	}
-	if ident.IssuerID == types.DSSPublisherID {
-		signingKey = dssSigningKey
-	} else {
+	if ident.IssuerID == ident.PublisherID {
 		signingKey = messaging.PublicKeyFromPem(ident.PublicKey)
+	} else {
+		signingKey = issuerKey
 	}
 
-	// Self signed or DSS signed identity
+	// Self signed or issuer signed identity
 	err := messaging.VerifyIdentitySignature(ident, signingKey)
 	if err != nil {
 		return lib.MakeErrorf("VerifyIdentity: Verification of %s message failed. "+
@@ -192,11 +319,46 @@ func VerifyPublisherIdentity(rxAddress string, ident *types.PublisherIdentityMes
 	return nil
 }
 
+// VerifyPublisherIdentity verifies the integrity of the given identity record
+// Verification fails when:
+//  - domain/publisherID doesn't match the received address
+//  - the issuer ID or its public key is missing
+//  - the issuer is either the DSS or the publisher itself (self-signed)
+//  - identity is expired
+//  - a newer identity is already received
+//  - the identity signature doesn't verify against the signing key (if provided)
+//
+// The signing key is only available if the identity of the issuer is known;
+//  When a secured domain is joined, the issuer is the DSS whose identity must be received first.
+//  When no secured domain is joined, the identity is self signed. Protection is
+//   based on message bus ACLs. Only publishers can self sign their own identity.
+//  When the issuer is a CA, use VerifyIssuedIdentity with the CA public key.
+//  clockSkew is the tolerance of the issuer clock when checking the expiry, see GetClockSkew
+func VerifyPublisherIdentity(rxAddress string, ident *types.PublisherIdentityMessage,
+	dssSigningKey *ecdsa.PublicKey, clockSkew time.Duration) error {
+
+	// only DSS or publisher itself are allowed to issue identity
+	if ident.IssuerID != ident.PublisherID &&
+		ident.IssuerID != types.DSSPublisherID {
+
+		err := lib.MakeErrorf("VerifyPublisherIdentity: identity issuer %s of domain/publisher %s/%s must "+
+			"be the DSS or self-signed", ident.IssuerID, ident.Domain, ident.PublisherID)
+		return err
+	}
+	return VerifyIssuedIdentity(rxAddress, ident, dssSigningKey, clockSkew)
+}
+
 // NewDomainPublisherIdentities creates a new list of discovered publishers
 func NewDomainPublisherIdentities() *DomainPublisherIdentities {
 	domainIdentities := &DomainPublisherIdentities{
 		c:              lib.NewDomainCollection(reflect.TypeOf(&types.InputDiscoveryMessage{}), nil),
 		publicKeyCache: make(map[string]*ecdsa.PublicKey),
+		cached:         make(map[string]time.Time),
+		cacheTTL:       lib.DefaultCacheTTL * time.Second,
+		clockSkew:      lib.DefaultClockSkew,
+		revokedKeys:    make(map[string]string),
+		trustAnchors:   make(map[string]*ecdsa.PublicKey),
+		trusted:        make(map[string]bool),
 	}
 	domainIdentities.c.GetPublicKey = domainIdentities.GetPublisherKey
 	return domainIdentities
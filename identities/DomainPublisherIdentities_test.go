@@ -2,11 +2,15 @@ package identities_test
 
 import (
 	"crypto/ecdsa"
+	"encoding/json"
 	"io/ioutil"
 	"os"
+	"path"
 	"testing"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/nodes"
 	"github.com/iotdomain/iotdomain-go/types"
@@ -77,6 +81,45 @@ func TestLoadDomainIdentities(t *testing.T) {
 	os.Remove(filename)
 }
 
+func TestIdentityCacheTTL(t *testing.T) {
+	const domain = "test"
+	cacheFolder, err := ioutil.TempDir("", "identities")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheFolder)
+	filename := path.Join(cacheFolder, "cache.json")
+
+	collection := identities.NewDomainPublisherIdentities()
+	pubIdent1, _ := identities.CreateIdentity(domain, "pub1")
+	collection.AddIdentity(&pubIdent1.PublisherIdentityMessage)
+	err = collection.SaveIdentities(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 0, collection.UpdateCount())
+
+	// identities received within the TTL are loaded
+	collection2 := identities.NewDomainPublisherIdentities()
+	err = collection2.LoadIdentities(filename)
+	require.NoError(t, err)
+	assert.NotNil(t, collection2.GetPublisherKey(pubIdent1.Address))
+
+	// identities received before the TTL are ignored
+	time.Sleep(time.Millisecond * 10)
+	collection3 := identities.NewDomainPublisherIdentities()
+	collection3.SetCacheTTL(time.Millisecond)
+	err = collection3.LoadIdentities(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(collection3.GetAllPublishers()))
+	assert.Nil(t, collection3.GetPublisherKey(pubIdent1.Address))
+
+	// saved identities without cache time use the identity timestamp
+	legacy, _ := json.Marshal([]*types.PublisherIdentityMessage{&pubIdent1.PublisherIdentityMessage})
+	err = ioutil.WriteFile(filename, legacy, 0600)
+	require.NoError(t, err)
+	collection4 := identities.NewDomainPublisherIdentities()
+	err = collection4.LoadIdentities(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(collection4.GetAllPublishers()))
+}
+
 func TestDiscoverDomainPublishers(t *testing.T) {
 	const Source1ID = "source1"
 	const domain = "test"
@@ -138,14 +181,14 @@ func TestDiscoverDomainPublishers(t *testing.T) {
 	// error case - modified identity
 	pub2Ident.Location = "modified location"
 	err = identities.VerifyPublisherIdentity(pub2Ident.PublisherIdentityMessage.Address,
-		&pub2Ident.PublisherIdentityMessage, &dssKeys.PublicKey)
+		&pub2Ident.PublisherIdentityMessage, &dssKeys.PublicKey, lib.DefaultClockSkew)
 	assert.Errorf(t, err, "modified identity (signed by DSS) should not verify")
 
 	// error case - identity not signed by dss or self
 	pub2Ident.IssuerID = "someoneelse"
 	messaging.SignIdentity(&pub2Ident.PublisherIdentityMessage, dssKeys)
 	err = identities.VerifyPublisherIdentity(pub2Ident.PublisherIdentityMessage.Address,
-		&pub2Ident.PublisherIdentityMessage, &dssKeys.PublicKey)
+		&pub2Ident.PublisherIdentityMessage, &dssKeys.PublicKey, lib.DefaultClockSkew)
 	assert.Errorf(t, err, "Identity not signed by DSS or self must fail")
 
 	// error case - address too short
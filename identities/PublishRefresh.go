@@ -0,0 +1,23 @@
+package identities
+
+import (
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PublishRefresh publishes a request to all publishers of the domain to republish their discovery.
+//  sender is the address of the requesting publisher
+func PublishRefresh(domain string, sender string, signer *messaging.MessageSigner) error {
+	addr := MakeRefreshAddress(domain)
+	logrus.Infof("PublishRefresh: request refresh of domain %s", domain)
+
+	message := &types.RefreshMessage{
+		Address:   addr,
+		Sender:    sender,
+		Timestamp: lib.Now().Format(types.TimeFormat),
+	}
+	err := signer.PublishObject(addr, false, message, nil)
+	return err
+}
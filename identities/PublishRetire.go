@@ -0,0 +1,32 @@
+package identities
+
+import (
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MakeRetireAddress creates the address of the retirement notice of a publisher or node from the
+// address of its identity or node discovery: domain/publisherId[/nodeId]/$retire
+func MakeRetireAddress(retiredAddress string) string {
+	return lib.MakeBaseAddress(retiredAddress) + "/" + types.MessageTypeRetire
+}
+
+// PublishRetire publishes a signed notice that a publisher or node is retired
+//  retiredAddress is the address of the retired publisher identity or node discovery
+//  successor is the optional ID of the publisher that takes over, "" if none
+//  sender is the address of the retiring publisher
+func PublishRetire(retiredAddress string, successor string, sender string, signer *messaging.MessageSigner) error {
+	addr := MakeRetireAddress(retiredAddress)
+	logrus.Infof("PublishRetire: publish retirement of %s", retiredAddress)
+
+	message := &types.RetireMessage{
+		Address:   addr,
+		Retired:   retiredAddress,
+		Sender:    sender,
+		Successor: successor,
+		Timestamp: lib.Now().Format(types.TimeFormat),
+	}
+	return signer.PublishObject(addr, false, message, nil)
+}
@@ -0,0 +1,33 @@
+package identities
+
+import (
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MakeRevocationAddress creates the address of the revocation list of the DSS of a domain:
+// domain/$dss/$revoked
+func MakeRevocationAddress(domain string) string {
+	return domain + "/" + types.DSSPublisherID + "/" + types.MessageTypeRevoked
+}
+
+// PublishRevocationList publishes the retained list of revoked identities of a domain. Intended
+// for use by the DSS. The list replaces the previously published list and must include all
+// identities that are revoked and not yet expired.
+//  revoked are the revoked identities
+//  sender is the identity address of the DSS
+func PublishRevocationList(domain string, revoked []types.RevokedIdentity, sender string,
+	signer *messaging.MessageSigner) error {
+	addr := MakeRevocationAddress(domain)
+	logrus.Infof("PublishRevocationList: publish %d revoked identities of domain %s", len(revoked), domain)
+
+	message := &types.RevocationListMessage{
+		Address:   addr,
+		Revoked:   revoked,
+		Sender:    sender,
+		Timestamp: lib.Now().Format(types.TimeFormat),
+	}
+	return signer.PublishObject(addr, true, message, nil)
+}
@@ -0,0 +1,31 @@
+package identities
+
+import (
+	"fmt"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MakeSigningPolicyAddress returns the address of the signing policy of a publisher:
+// domain/publisherId/$signing
+func MakeSigningPolicyAddress(domain string, publisherID string) string {
+	return fmt.Sprintf("%s/%s/%s", domain, publisherID, types.MessageTypeSigningPolicy)
+}
+
+// PublishSigningPolicy publishes the retained signing policy of a publisher with the message types
+// it publishes without signature. The policy itself is always signed.
+//  sender is the identity address of the publisher
+func PublishSigningPolicy(domain string, publisherID string, sender string, signer *messaging.MessageSigner) error {
+	addr := MakeSigningPolicyAddress(domain, publisherID)
+	message := &types.SigningPolicyMessage{
+		Address:       addr,
+		Sender:        sender,
+		Timestamp:     lib.Now().Format(types.TimeFormat),
+		UnsignedTypes: signer.GetUnsignedMessageTypes(),
+	}
+	logrus.Infof("PublishSigningPolicy: publish signing policy of %s. Unsigned types: %v", sender, message.UnsignedTypes)
+	return signer.PublishObject(addr, true, message, nil)
+}
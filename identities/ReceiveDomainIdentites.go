@@ -2,19 +2,33 @@
 package identities
 
 import (
+	"sync"
+
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
 )
 
+// IdentityHandler callback when a publisher identity with a new or changed public key is received
+type IdentityHandler func(identity *types.PublisherIdentityMessage)
+
 // ReceiveDomainPublisherIdentities listens for publisher identities on the domain.
 // The domain identities are used to verify the signature of messages from a publisher
 // In secured domains the domain identity must be signed by the DSS.
 type ReceiveDomainPublisherIdentities struct {
 	domainIdentities *DomainPublisherIdentities
+	handler          IdentityHandler          // handler to pass identities with a new key to
 	messageSigner    *messaging.MessageSigner // subscription to command
 	dssAddress       string                   // the DSS address for this domain
+	updateMutex      *sync.Mutex              // mutex for async setting of the handler
+}
+
+// SetIdentityHandler set the handler for received identities with a new or changed public key
+func (rxIdentity *ReceiveDomainPublisherIdentities) SetIdentityHandler(handler IdentityHandler) {
+	rxIdentity.updateMutex.Lock()
+	defer rxIdentity.updateMutex.Unlock()
+	rxIdentity.handler = handler
 }
 
 // Start listening for updates to the registered identity
@@ -35,8 +49,13 @@ func (rxIdentity *ReceiveDomainPublisherIdentities) Stop() {
 // ReceiveDomainIdentity handles receiving published identities of the domain.
 // This:
 // - verifies if the sender signature is valid
-// - verifies that the identity is signed by the DSS when in a secure domain
+// - verifies that the identity key isn't revoked by the DSS
+// - verifies that the identity is signed by its issuer, eg the DSS or a CA, or is self-signed
+// - verifies that the issuer chains to a trust anchor when trust anchors are configured
+// - verifies that a trusted identity isn't replaced by an untrusted identity
+// - verifies the identity certificate against the CA bundle, if both are present
 // - passes the update to the domain identity collection
+// - passes identities with a new or changed public key to the identity handler
 func (rxIdentity *ReceiveDomainPublisherIdentities) ReceiveDomainIdentity(address string, rawMessage string) error {
 	var newIdentity types.PublisherIdentityMessage
 
@@ -54,25 +73,41 @@ func (rxIdentity *ReceiveDomainPublisherIdentities) ReceiveDomainIdentity(addres
 		return lib.MakeErrorf("ReceiveDomainIdentity: Identity message on '%s' isn't signed but must be. Message discarded.", address)
 	}
 
-	// Determine the key to verify the identity with
-	if newIdentity.IssuerID == newIdentity.PublisherID {
-		// self signed identity
-		issuerKey := messaging.PublicKeyFromPem(newIdentity.PublicKey)
-		err = VerifyPublisherIdentity(address, &newIdentity, issuerKey)
-	} else if newIdentity.IssuerID == types.DSSPublisherID {
-		// DSS signed identity. DSS Must be known.
-		issuerAddress := newIdentity.Domain + "/" + newIdentity.IssuerID
-		issuerKey := rxIdentity.domainIdentities.GetPublisherKey(issuerAddress)
-		err = VerifyPublisherIdentity(address, &newIdentity, issuerKey)
-	} else {
-		// TODO: assume a CA signed identity. Not yet supported
-		err = lib.MakeErrorf("Unknown Issuer %s for domain %s", newIdentity.IssuerID, newIdentity.Domain)
+	// identities with a revoked key are no longer accepted
+	if rxIdentity.domainIdentities.IsRevoked(&newIdentity) {
+		return lib.MakeErrorf("ReceiveDomainIdentity: The key of identity '%s' is revoked. Message discarded.", address)
+	}
+	// Determine the key to verify the identity with by following the issuer chain to a trust anchor
+	issuerKey, trusted, err := rxIdentity.domainIdentities.GetIssuerKey(&newIdentity)
+	if err == nil {
+		err = VerifyIssuedIdentity(address, &newIdentity, issuerKey, rxIdentity.domainIdentities.GetClockSkew())
+	}
+	// identities with a certificate issued by a CA of the bundle are trusted
+	caBundle := rxIdentity.domainIdentities.GetCABundle()
+	if err == nil && newIdentity.Certificate != "" && caBundle != nil {
+		err = VerifyIdentityCertificate(address, &newIdentity, caBundle)
+		trusted = err == nil
 	}
 	if err != nil {
-		return lib.MakeErrorf("ReceiveDomainIdentity: Publisher identity signature verification failed for %s", address)
+		return lib.MakeErrorf("ReceiveDomainIdentity: Publisher identity signature verification failed for %s: %s",
+			address, err)
+	}
+	// a trusted identity is not replaced by an untrusted one, eg a self-signed identity with another key
+	if !trusted && rxIdentity.domainIdentities.IsTrusted(newIdentity.Address) {
+		return lib.MakeErrorf("ReceiveDomainIdentity: Identity '%s' is trusted and can't be replaced "+
+			"by an untrusted identity. Message discarded.", address)
 	}
 
-	rxIdentity.domainIdentities.AddIdentity(&newIdentity)
+	existing := rxIdentity.domainIdentities.GetPublisherByAddress(newIdentity.Address)
+	isNewKey := existing == nil || existing.PublicKey != newIdentity.PublicKey
+	rxIdentity.domainIdentities.AddVerifiedIdentity(&newIdentity, trusted)
+
+	rxIdentity.updateMutex.Lock()
+	handler := rxIdentity.handler
+	rxIdentity.updateMutex.Unlock()
+	if isNewKey && handler != nil {
+		handler(&newIdentity)
+	}
 	return nil
 }
 
@@ -85,6 +120,7 @@ func NewReceivePublisherIdentities(domain string, domainIdentities *DomainPublis
 		dssAddress:       MakePublisherIdentityAddress(domain, types.DSSPublisherID),
 		domainIdentities: domainIdentities,
 		messageSigner:    messageSigner,
+		updateMutex:      &sync.Mutex{},
 	}
 	return rxIdent
 }
@@ -0,0 +1,97 @@
+// Package identities with handling of the domain refresh command
+package identities
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// RefreshMinInterval is the minimum interval in seconds between handling refresh requests.
+// Requests that arrive sooner are ignored to protect against flooding.
+const RefreshMinInterval = 10
+
+// RefreshHandler callback when a refresh of the domain discovery is requested
+type RefreshHandler func(message *types.RefreshMessage)
+
+// ReceiveRefresh listens for requests to republish the discovery of this publisher
+// In secured domains the request must be signed by a known publisher.
+type ReceiveRefresh struct {
+	domain        string                   // the domain of this publisher
+	handler       RefreshHandler           // handler to pass the request to
+	lastRefresh   time.Time                // time of the last handled request
+	messageSigner *messaging.MessageSigner // subscription to command
+	updateMutex   *sync.Mutex              // mutex for async handling of requests
+}
+
+// SetRefreshHandler set the handler for refresh requests
+func (rxRefresh *ReceiveRefresh) SetRefreshHandler(handler RefreshHandler) {
+	rxRefresh.updateMutex.Lock()
+	defer rxRefresh.updateMutex.Unlock()
+	rxRefresh.handler = handler
+}
+
+// Start listening for refresh requests
+func (rxRefresh *ReceiveRefresh) Start() {
+	addr := MakeRefreshAddress(rxRefresh.domain)
+	rxRefresh.messageSigner.Subscribe(addr, rxRefresh.decodeRefresh)
+}
+
+// Stop listening for refresh requests
+func (rxRefresh *ReceiveRefresh) Stop() {
+	addr := MakeRefreshAddress(rxRefresh.domain)
+	rxRefresh.messageSigner.Unsubscribe(addr, rxRefresh.decodeRefresh)
+}
+
+// decodeRefresh verifies the signature of an incoming refresh request and passes it to the handler.
+func (rxRefresh *ReceiveRefresh) decodeRefresh(address string, message string) error {
+	var refreshMessage types.RefreshMessage
+
+	isSigned, err := rxRefresh.messageSigner.VerifySignedMessage(message, &refreshMessage)
+	if err != nil {
+		return lib.MakeErrorf("decodeRefresh: Invalid refresh request on '%s': %s. Message discarded.", address, err)
+	} else if !isSigned && rxRefresh.messageSigner.SignMessages() {
+		return lib.MakeErrorf("decodeRefresh: Refresh request on '%s' isn't signed but must be. Message discarded.", address)
+	}
+
+	rxRefresh.updateMutex.Lock()
+	handler := rxRefresh.handler
+	tooSoon := time.Since(rxRefresh.lastRefresh) < RefreshMinInterval*time.Second
+	if !tooSoon {
+		rxRefresh.lastRefresh = time.Now()
+	}
+	rxRefresh.updateMutex.Unlock()
+
+	if tooSoon {
+		logrus.Infof("decodeRefresh: Ignored refresh request from %s. Last refresh was less than %d seconds ago.",
+			refreshMessage.Sender, RefreshMinInterval)
+		return nil
+	}
+	logrus.Infof("decodeRefresh: Refresh requested by %s", refreshMessage.Sender)
+	if handler != nil {
+		handler(&refreshMessage)
+	}
+	return nil
+}
+
+// MakeRefreshAddress creates the address of the domain refresh request: domain/$refresh
+func MakeRefreshAddress(domain string) string {
+	address := fmt.Sprintf("%s/%s", domain, types.MessageTypeRefresh)
+	return address
+}
+
+// NewReceiveRefresh returns a new instance of handling of the domain refresh request
+func NewReceiveRefresh(domain string, handler RefreshHandler, messageSigner *messaging.MessageSigner) *ReceiveRefresh {
+	rxRefresh := &ReceiveRefresh{
+		domain:        domain,
+		handler:       handler,
+		messageSigner: messageSigner,
+		updateMutex:   &sync.Mutex{},
+	}
+	return rxRefresh
+}
@@ -0,0 +1,47 @@
+package identities_test
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiveRefresh(t *testing.T) {
+	const domain = "test"
+	const sender = "test/pub1"
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+
+	var refreshCount = 0
+	var refreshSender = ""
+	rxRefresh := identities.NewReceiveRefresh(domain, func(message *types.RefreshMessage) {
+		refreshCount++
+		refreshSender = message.Sender
+	}, signer)
+	rxRefresh.Start()
+
+	err := identities.PublishRefresh(domain, sender, signer)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, refreshCount)
+	assert.Equal(t, sender, refreshSender)
+
+	// a second request within the minimum interval is ignored
+	err = identities.PublishRefresh(domain, sender, signer)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, refreshCount)
+
+	// unsigned requests are not accepted when signing is required
+	addr := identities.MakeRefreshAddress(domain)
+	messenger.OnReceive(addr, `{"address":"test/$refresh","sender":"test/pub2"}`)
+	assert.Equal(t, 1, refreshCount)
+
+	rxRefresh.Stop()
+}
@@ -16,6 +16,15 @@ type ReceiveRegisteredIdentityUpdate struct {
 	publisherID        string                   // the registered publisher for the inputs
 	messageSigner      *messaging.MessageSigner // subscription to command
 	registeredIdentity *RegisteredIdentity      // the identity to update
+	onUpdate           IdentityUpdateHandler    // optional handler invoked after the identity is updated
+}
+
+// IdentityUpdateHandler is invoked after the registered identity is updated
+type IdentityUpdateHandler func(identity *types.PublisherFullIdentity)
+
+// SetIdentityUpdateHandler sets the handler that is invoked after the identity is updated
+func (rxIdentity *ReceiveRegisteredIdentityUpdate) SetIdentityUpdateHandler(handler IdentityUpdateHandler) {
+	rxIdentity.onUpdate = handler
 }
 
 // Start listening for updates to the registered identity
@@ -36,10 +45,14 @@ func (rxIdentity *ReceiveRegisteredIdentityUpdate) Stop() {
 // - checks the sender is the DSS
 // - verifies if the sender (dss) signature is valid
 // - passes the update to the adapter's callback set in Start()
-func (rxIdentity *ReceiveRegisteredIdentityUpdate) ReceiveIdentityUpdate(address string, rawMessage string) error {
+func (rxIdentity *ReceiveRegisteredIdentityUpdate) ReceiveIdentityUpdate(address string, rawMessage string) (err error) {
 	var newIdentity types.PublisherFullIdentity
+	var isEncrypted, isSigned bool
+	defer func() {
+		rxIdentity.messageSigner.AuditCommand(address, newIdentity.Sender, isEncrypted, isSigned, err)
+	}()
 
-	isEncrypted, isSigned, err := rxIdentity.messageSigner.DecodeMessage(
+	isEncrypted, isSigned, err = rxIdentity.messageSigner.DecodeCommand(
 		rawMessage, &newIdentity)
 
 	if err != nil {
@@ -59,6 +72,9 @@ func (rxIdentity *ReceiveRegisteredIdentityUpdate) ReceiveIdentityUpdate(address
 		rxIdentity.registeredIdentity.UpdateIdentity(&newIdentity)
 		rxIdentity.registeredIdentity.SaveIdentity()
 	}
+	if rxIdentity.onUpdate != nil {
+		rxIdentity.onUpdate(&newIdentity)
+	}
 	return err
 }
 
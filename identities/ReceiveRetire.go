@@ -0,0 +1,84 @@
+// Package identities with handling of retirement notices of domain publishers
+package identities
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// RetireHandler callback when a publisher or node of the domain is retired
+type RetireHandler func(message *types.RetireMessage)
+
+// ReceiveRetire listens for retirement notices of publishers and nodes in the domain
+// A notice is only accepted from the retiring publisher itself. In secured domains the notice must
+// be signed.
+type ReceiveRetire struct {
+	handler       RetireHandler            // handler to pass the notice to
+	messageSigner *messaging.MessageSigner // subscription to notices
+	updateMutex   *sync.Mutex              // mutex for async handling of notices
+}
+
+// SetRetireHandler set the handler for retirement notices
+func (rxRetire *ReceiveRetire) SetRetireHandler(handler RetireHandler) {
+	rxRetire.updateMutex.Lock()
+	defer rxRetire.updateMutex.Unlock()
+	rxRetire.handler = handler
+}
+
+// Start listening for retirement notices of publishers and nodes
+func (rxRetire *ReceiveRetire) Start() {
+	rxRetire.messageSigner.Subscribe("+/+/"+types.MessageTypeRetire, rxRetire.decodeRetire)
+	rxRetire.messageSigner.Subscribe("+/+/+/"+types.MessageTypeRetire, rxRetire.decodeRetire)
+}
+
+// Stop listening for retirement notices
+func (rxRetire *ReceiveRetire) Stop() {
+	rxRetire.messageSigner.Unsubscribe("+/+/"+types.MessageTypeRetire, rxRetire.decodeRetire)
+	rxRetire.messageSigner.Unsubscribe("+/+/+/"+types.MessageTypeRetire, rxRetire.decodeRetire)
+}
+
+// decodeRetire verifies the signature and sender of an incoming retirement notice and passes it to
+// the handler.
+func (rxRetire *ReceiveRetire) decodeRetire(address string, message string) error {
+	var retireMessage types.RetireMessage
+
+	isSigned, err := rxRetire.messageSigner.VerifySignedMessage(message, &retireMessage)
+	if err != nil {
+		return lib.MakeErrorf("decodeRetire: Invalid retirement notice on '%s': %s. Message discarded.", address, err)
+	} else if !isSigned && rxRetire.messageSigner.SignMessages() {
+		return lib.MakeErrorf("decodeRetire: Retirement notice on '%s' isn't signed but must be. Message discarded.", address)
+	}
+	// only the publisher itself can retire its nodes: domain/publisherId/...
+	segments := strings.Split(address, "/")
+	publisherAddress := segments[0] + "/" + segments[1]
+	if lib.MakeBaseAddress(retireMessage.Sender) != publisherAddress ||
+		MakeRetireAddress(retireMessage.Retired) != address {
+		return lib.MakeErrorf("decodeRetire: Retirement notice on '%s' from '%s' is not from the retiring publisher. Message discarded.",
+			address, retireMessage.Sender)
+	}
+
+	rxRetire.updateMutex.Lock()
+	handler := rxRetire.handler
+	rxRetire.updateMutex.Unlock()
+
+	logrus.Warningf("decodeRetire: %s is retired. Successor is '%s'", retireMessage.Retired, retireMessage.Successor)
+	if handler != nil {
+		handler(&retireMessage)
+	}
+	return nil
+}
+
+// NewReceiveRetire returns a new instance of handling of retirement notices
+func NewReceiveRetire(handler RetireHandler, messageSigner *messaging.MessageSigner) *ReceiveRetire {
+	rxRetire := &ReceiveRetire{
+		handler:       handler,
+		messageSigner: messageSigner,
+		updateMutex:   &sync.Mutex{},
+	}
+	return rxRetire
+}
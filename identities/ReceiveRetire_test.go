@@ -0,0 +1,52 @@
+package identities_test
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiveRetire(t *testing.T) {
+	const sender = "test/pub1/$identity"
+	const nodeAddr = "test/pub1/node1/$node"
+	const identityAddr = "test/pub1/$identity"
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+
+	retired := make([]*types.RetireMessage, 0)
+	rxRetire := identities.NewReceiveRetire(func(message *types.RetireMessage) {
+		retired = append(retired, message)
+	}, signer)
+	rxRetire.Start()
+
+	assert.Equal(t, "test/pub1/node1/$retire", identities.MakeRetireAddress(nodeAddr))
+	err := identities.PublishRetire(nodeAddr, "", sender, signer)
+	assert.NoError(t, err)
+	err = identities.PublishRetire(identityAddr, "pub2", sender, signer)
+	assert.NoError(t, err)
+	require.Len(t, retired, 2)
+	assert.Equal(t, nodeAddr, retired[0].Retired)
+	assert.Equal(t, identityAddr, retired[1].Retired)
+	assert.Equal(t, "pub2", retired[1].Successor)
+
+	// another publisher can't retire the nodes of a publisher
+	err = identities.PublishRetire(nodeAddr, "", "test/pub2", signer)
+	assert.NoError(t, err)
+	assert.Len(t, retired, 2)
+
+	// unsigned notices are not accepted when signing is required
+	messenger.OnReceive(identities.MakeRetireAddress(identityAddr),
+		`{"address":"test/pub1/$retire","retired":"test/pub1/$identity","sender":"test/pub1/$identity"}`)
+	assert.Len(t, retired, 2)
+
+	rxRetire.Stop()
+}
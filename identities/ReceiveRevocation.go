@@ -0,0 +1,91 @@
+// Package identities with handling of the identity revocation list of the DSS
+package identities
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// RevocationHandler callback when an identity of the domain is revoked
+type RevocationHandler func(revoked *types.RevokedIdentity)
+
+// ReceiveRevocation listens for the revocation lists of the DSS of each domain and distrusts the
+// revoked identities. A list is only accepted when it is signed by the DSS of its domain.
+type ReceiveRevocation struct {
+	domainIdentities *DomainPublisherIdentities // identities to revoke
+	handler          RevocationHandler          // handler to pass newly revoked identities to
+	messageSigner    *messaging.MessageSigner   // subscription to revocation lists
+	updateMutex      *sync.Mutex                // mutex for async handling of revocations
+}
+
+// SetRevocationHandler set the handler for newly revoked identities
+func (rxRevocation *ReceiveRevocation) SetRevocationHandler(handler RevocationHandler) {
+	rxRevocation.updateMutex.Lock()
+	defer rxRevocation.updateMutex.Unlock()
+	rxRevocation.handler = handler
+}
+
+// Start listening for revocation lists
+func (rxRevocation *ReceiveRevocation) Start() {
+	rxRevocation.messageSigner.Subscribe(MakeRevocationAddress("+"), rxRevocation.decodeRevocationList)
+}
+
+// Stop listening for revocation lists
+func (rxRevocation *ReceiveRevocation) Stop() {
+	rxRevocation.messageSigner.Unsubscribe(MakeRevocationAddress("+"), rxRevocation.decodeRevocationList)
+}
+
+// decodeRevocationList verifies the signature and sender of a revocation list, revokes the listed
+// identities of its domain and passes the newly revoked identities to the handler.
+func (rxRevocation *ReceiveRevocation) decodeRevocationList(address string, message string) error {
+	var revocationList types.RevocationListMessage
+
+	isSigned, err := rxRevocation.messageSigner.VerifySignedMessage(message, &revocationList)
+	if err != nil {
+		return lib.MakeErrorf("decodeRevocationList: Invalid revocation list on '%s': %s. Message discarded.", address, err)
+	} else if !isSigned {
+		return lib.MakeErrorf("decodeRevocationList: Revocation list on '%s' isn't signed. Message discarded.", address)
+	}
+	// only the DSS of the domain can revoke its identities
+	domain := strings.Split(address, "/")[0]
+	dssAddress := MakePublisherIdentityAddress(domain, types.DSSPublisherID)
+	if revocationList.Sender != dssAddress {
+		return lib.MakeErrorf("decodeRevocationList: Sender is %s instead of the DSS %s. Message discarded.",
+			revocationList.Sender, dssAddress)
+	}
+
+	rxRevocation.updateMutex.Lock()
+	handler := rxRevocation.handler
+	rxRevocation.updateMutex.Unlock()
+
+	for i := range revocationList.Revoked {
+		revoked := &revocationList.Revoked[i]
+		if !strings.HasPrefix(revoked.Address, domain+"/") || revoked.PublicKey == "" {
+			return lib.MakeErrorf("decodeRevocationList: Invalid revocation of '%s' by %s", revoked.Address, dssAddress)
+		}
+	}
+	for i := range revocationList.Revoked {
+		revoked := &revocationList.Revoked[i]
+		isNew := rxRevocation.domainIdentities.RevokeIdentity(revoked)
+		if isNew && handler != nil {
+			handler(revoked)
+		}
+	}
+	return nil
+}
+
+// NewReceiveRevocation returns a new instance of handling of the revocation lists of the DSS
+func NewReceiveRevocation(domainIdentities *DomainPublisherIdentities, handler RevocationHandler,
+	messageSigner *messaging.MessageSigner) *ReceiveRevocation {
+	rxRevocation := &ReceiveRevocation{
+		domainIdentities: domainIdentities,
+		handler:          handler,
+		messageSigner:    messageSigner,
+		updateMutex:      &sync.Mutex{},
+	}
+	return rxRevocation
+}
@@ -0,0 +1,93 @@
+package identities_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiveRevocation(t *testing.T) {
+	const domain = "test"
+	collection := identities.NewDomainPublisherIdentities()
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	dssIdent, dssKeys := identities.CreateIdentity(domain, types.DSSPublisherID)
+	collection.AddIdentity(&dssIdent.PublisherIdentityMessage)
+	dssSigner := messaging.NewMessageSigner(messenger, dssKeys, collection.GetPublisherKey)
+
+	revoked := make([]*types.RevokedIdentity, 0)
+	rxRevocation := identities.NewReceiveRevocation(collection, func(revokedIdent *types.RevokedIdentity) {
+		revoked = append(revoked, revokedIdent)
+	}, dssSigner)
+	rxRevocation.Start()
+	defer rxRevocation.Stop()
+	receiver := identities.NewReceivePublisherIdentities(domain, collection, dssSigner)
+	receiver.Start()
+	defer receiver.Stop()
+
+	// pub1 is issued by the DSS and issues pub2
+	pub1Ident, pub1Keys := identities.CreateIdentity(domain, "pub1")
+	pub1Ident.IssuerID = types.DSSPublisherID
+	messaging.SignIdentity(&pub1Ident.PublisherIdentityMessage, dssKeys)
+	pub1Signer := messaging.NewMessageSigner(messenger, pub1Keys, collection.GetPublisherKey)
+	pub1Signer.PublishObject(pub1Ident.Address, false, pub1Ident.PublisherIdentityMessage, nil)
+	require.NotNil(t, collection.GetPublisherByAddress(pub1Ident.Address))
+	collection.AddTrustAnchor(types.DSSPublisherID, messaging.PublicKeyToPem(&dssKeys.PublicKey))
+	pub2Ident, pub2Keys := identities.CreateIdentity(domain, "pub2")
+	pub2Ident.IssuerID = "pub1"
+	messaging.SignIdentity(&pub2Ident.PublisherIdentityMessage, pub1Keys)
+	collection.AddVerifiedIdentity(&pub2Ident.PublisherIdentityMessage, true)
+	assert.True(t, collection.IsTrusted(pub2Ident.Address))
+
+	// the revoked identity is removed and its messages no longer verify
+	revokedPub1 := types.RevokedIdentity{Address: pub1Ident.Address, PublicKey: pub1Ident.PublicKey, Reason: "compromised"}
+	assert.Equal(t, "test/$dss/$revoked", identities.MakeRevocationAddress(domain))
+	err := identities.PublishRevocationList(domain, []types.RevokedIdentity{revokedPub1}, dssIdent.Address, dssSigner)
+	require.NoError(t, err)
+	require.Len(t, revoked, 1)
+	assert.Equal(t, pub1Ident.Address, revoked[0].Address)
+	assert.Nil(t, collection.GetPublisherByAddress(pub1Ident.Address))
+	assert.Nil(t, collection.GetPublisherKey(pub1Ident.Address))
+	assert.True(t, collection.IsRevoked(&pub1Ident.PublisherIdentityMessage))
+	assert.False(t, collection.IsTrusted(pub2Ident.Address))
+	signedMessage, _ := pub1Signer.SignObject(&types.RetireMessage{Address: "test/pub1/$retire", Sender: pub1Ident.Address})
+	_, err = dssSigner.VerifySignedMessage(signedMessage, &types.RetireMessage{})
+	assert.Error(t, err)
+
+	// the revoked identity is not accepted again, a renewed identity is
+	pub1Signer.PublishObject(pub1Ident.Address, false, pub1Ident.PublisherIdentityMessage, nil)
+	assert.Nil(t, collection.GetPublisherByAddress(pub1Ident.Address))
+	renewedIdent, _ := identities.CreateIdentity(domain, "pub1")
+	renewedIdent.IssuerID = types.DSSPublisherID
+	messaging.SignIdentity(&renewedIdent.PublisherIdentityMessage, dssKeys)
+	dssSigner.PublishObject(renewedIdent.Address, false, renewedIdent.PublisherIdentityMessage, nil)
+	assert.NotNil(t, collection.GetPublisherByAddress(renewedIdent.Address))
+
+	// a republished list doesn't revoke the renewed identity or notify again
+	err = identities.PublishRevocationList(domain, []types.RevokedIdentity{revokedPub1}, dssIdent.Address, dssSigner)
+	require.NoError(t, err)
+	assert.Len(t, revoked, 1)
+	assert.NotNil(t, collection.GetPublisherByAddress(renewedIdent.Address))
+
+	// error case - only the DSS can revoke identities
+	revokedPub2 := types.RevokedIdentity{Address: pub2Ident.Address, PublicKey: pub2Ident.PublicKey}
+	collection.AddIdentity(&pub2Ident.PublisherIdentityMessage)
+	pub2Signer := messaging.NewMessageSigner(messenger, pub2Keys, collection.GetPublisherKey)
+	identities.PublishRevocationList(domain, []types.RevokedIdentity{revokedPub2}, pub2Ident.Address, pub2Signer)
+	assert.Len(t, revoked, 1)
+	assert.NotNil(t, collection.GetPublisherByAddress(pub2Ident.Address))
+
+	// error case - the DSS can't revoke identities of another domain
+	otherRevoked := types.RevokedIdentity{Address: "other/pub2/$identity", PublicKey: pub2Ident.PublicKey}
+	identities.PublishRevocationList(domain, []types.RevokedIdentity{otherRevoked}, dssIdent.Address, dssSigner)
+	assert.Len(t, revoked, 1)
+	assert.False(t, collection.IsRevoked(&pub2Ident.PublisherIdentityMessage))
+
+	// error case - unsigned lists are not accepted
+	messenger.OnReceive(identities.MakeRevocationAddress(domain),
+		`{"address":"test/$dss/$revoked","revoked":[{"address":"test/pub2/$identity","publicKey":"key"}],"sender":"test/$dss/$identity"}`)
+	assert.Len(t, revoked, 1)
+}
@@ -0,0 +1,65 @@
+// Package identities with handling of the signing policies of domain publishers
+package identities
+
+import (
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ReceiveSigningPolicy listens for the signing policies of publishers in the domain and passes them
+// to the message signer, which accepts unsigned messages of the announced types. A policy is only
+// accepted when it is signed by the publisher itself.
+type ReceiveSigningPolicy struct {
+	messageSigner *messaging.MessageSigner // subscription to policies and verification of messages
+}
+
+// Start listening for signing policies
+func (rxPolicy *ReceiveSigningPolicy) Start() {
+	rxPolicy.messageSigner.Subscribe(MakeSigningPolicyAddress("+", "+"), rxPolicy.decodeSigningPolicy)
+}
+
+// Stop listening for signing policies
+func (rxPolicy *ReceiveSigningPolicy) Stop() {
+	rxPolicy.messageSigner.Unsubscribe(MakeSigningPolicyAddress("+", "+"), rxPolicy.decodeSigningPolicy)
+}
+
+// decodeSigningPolicy verifies the signature and sender of a signing policy and records it.
+// A signed policy without unsigned message types removes the policy. An empty message, which clears
+// the retained policy, is ignored as anyone can publish it. The policy of a retiring publisher is
+// removed with its signed retirement notice instead.
+func (rxPolicy *ReceiveSigningPolicy) decodeSigningPolicy(address string, message string) error {
+	var policyMessage types.SigningPolicyMessage
+
+	segments := strings.Split(address, "/")
+	publisherAddress := segments[0] + "/" + segments[1]
+	if message == "" {
+		logrus.Infof("decodeSigningPolicy: Ignoring cleared signing policy on '%s'", address)
+		return nil
+	}
+	isSigned, err := rxPolicy.messageSigner.VerifySignedMessage(message, &policyMessage)
+	if err != nil {
+		return lib.MakeErrorf("decodeSigningPolicy: Invalid signing policy on '%s': %s. Message discarded.", address, err)
+	} else if !isSigned {
+		return lib.MakeErrorf("decodeSigningPolicy: Signing policy on '%s' isn't signed. Message discarded.", address)
+	}
+	// only the publisher itself can set its policy
+	if lib.MakeBaseAddress(policyMessage.Sender) != publisherAddress {
+		return lib.MakeErrorf("decodeSigningPolicy: Signing policy on '%s' is sent by '%s'. Message discarded.",
+			address, policyMessage.Sender)
+	}
+	logrus.Infof("decodeSigningPolicy: %s publishes %v unsigned", publisherAddress, policyMessage.UnsignedTypes)
+	rxPolicy.messageSigner.SetPublisherSigningPolicy(publisherAddress, policyMessage.UnsignedTypes)
+	return nil
+}
+
+// NewReceiveSigningPolicy returns a new instance of handling of publisher signing policies
+func NewReceiveSigningPolicy(messageSigner *messaging.MessageSigner) *ReceiveSigningPolicy {
+	rxPolicy := &ReceiveSigningPolicy{
+		messageSigner: messageSigner,
+	}
+	return rxPolicy
+}
@@ -0,0 +1,51 @@
+package identities_test
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiveSigningPolicy(t *testing.T) {
+	const rawAddr = "test/pub1/node1/temperature/0/$raw"
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	rxPolicy := identities.NewReceiveSigningPolicy(signer)
+	rxPolicy.Start()
+	defer rxPolicy.Stop()
+
+	// the policy of pub1 allows unsigned raw values
+	assert.Equal(t, "test/pub1/$signing", identities.MakeSigningPolicyAddress("test", "pub1"))
+	err := signer.SetUnsignedMessageTypes([]string{types.MessageTypeRaw})
+	require.NoError(t, err)
+	err = identities.PublishSigningPolicy("test", "pub1", "test/pub1/$identity", signer)
+	require.NoError(t, err)
+	assert.True(t, signer.IsUnsignedAllowed(rawAddr))
+
+	// another publisher can't set the policy of pub1
+	signer.SetUnsignedMessageTypes(nil)
+	identities.PublishSigningPolicy("test", "pub1", "test/pub2/$identity", signer)
+	assert.True(t, signer.IsUnsignedAllowed(rawAddr))
+
+	// unsigned policies are not accepted
+	messenger.OnReceive(identities.MakeSigningPolicyAddress("test", "pub1"),
+		`{"address":"test/pub1/$signing","sender":"test/pub1/$identity","unsignedTypes":[]}`)
+	assert.True(t, signer.IsUnsignedAllowed(rawAddr))
+
+	// clearing the retained policy doesn't remove it
+	messenger.OnReceive(identities.MakeSigningPolicyAddress("test", "pub1"), "")
+	assert.True(t, signer.IsUnsignedAllowed(rawAddr))
+
+	// a signed policy without unsigned types removes it
+	identities.PublishSigningPolicy("test", "pub1", "test/pub1/$identity", signer)
+	assert.False(t, signer.IsUnsignedAllowed(rawAddr))
+}
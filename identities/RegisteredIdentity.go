@@ -4,9 +4,6 @@ import (
 	"crypto/ecdsa"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"strings"
 	"time"
 
 	"github.com/iotdomain/iotdomain-go/lib"
@@ -65,7 +62,7 @@ func (regIdentity *RegisteredIdentity) LoadIdentity() (
 		return regIdentity.fullIdentity, regIdentity.privateKey, err
 	}
 
-	identityJSON, err := ioutil.ReadFile(regIdentity.filename)
+	identityJSON, err := lib.ReadFileWithRecovery(regIdentity.filename, lib.ValidateJSON)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -95,15 +92,12 @@ func (regIdentity *RegisteredIdentity) SaveIdentity() error {
 		return lib.MakeErrorf("SaveIdentity: Missing filename")
 	}
 
-	// save the identity as JSON. Remove the existing file first as they are read-only
+	// save the identity as JSON. The file is read-only and replaced by the new file.
 	identityJSON, _ := json.MarshalIndent(regIdentity.fullIdentity, " ", " ")
-	// move the identity before deleting
-	os.Rename(regIdentity.filename, regIdentity.filename+".old")
-	err := ioutil.WriteFile(regIdentity.filename, identityJSON, 0400)
+	err := lib.WriteFileAtomic(regIdentity.filename, identityJSON, 0400)
 	if err != nil {
 		return lib.MakeErrorf("SaveIdentity: Unable to save the publisher's identity at %s: %s", regIdentity.filename, err)
 	}
-	os.Remove(regIdentity.filename + ".old")
 	return err
 }
 
@@ -136,8 +130,8 @@ func (regIdentity *RegisteredIdentity) UpdateIdentity(fullIdentity *types.Publis
 func CreateIdentity(domain string, publisherID string) (
 	fullIdentity *types.PublisherFullIdentity, signingPrivKey *ecdsa.PrivateKey) {
 	// Create a new one and sign it.
-	timestampStr := time.Now().Format(types.TimeFormat)
-	validUntil := time.Now().Add(validDuration)
+	timestampStr := lib.Now().Format(types.TimeFormat)
+	validUntil := lib.Now().Add(validDuration)
 	validUntilStr := validUntil.Format(types.TimeFormat)
 
 	// generate private/public key for signing and store the public key in the publisher identity in PEM format
@@ -172,10 +166,11 @@ func CreateIdentity(domain string, publisherID string) (
 }
 
 // IsIdentityExpired tests if the given identity is expired
-func IsIdentityExpired(identity *types.PublisherIdentityMessage) bool {
-	timestampStr := time.Now().Format(types.TimeFormat)
-	nowIsGreater := strings.Compare(timestampStr, identity.ValidUntil)
-	return (nowIsGreater > 0)
+// The identity expires when its validity has passed by more than the clock skew tolerance of the
+// issuer. Identities without a valid expiry time are expired.
+//  clockSkew is the tolerance, eg lib.DefaultClockSkew, 0 to not tolerate any skew
+func IsIdentityExpired(identity *types.PublisherIdentityMessage, clockSkew time.Duration) bool {
+	return lib.IsTimestampExpired(identity.ValidUntil, clockSkew)
 }
 
 // MakePublisherIdentityAddress generates the address of a publisher:
@@ -208,8 +203,8 @@ func VerifyFullIdentity(ident *types.PublisherFullIdentity, domain string,
 		return lib.MakeErrorf("Identity publisher %s/%s doesn't match the given publisher %s/%s",
 			ident.Domain, ident.PublisherID, domain, publisherID)
 	}
-	// the public identity must verify
-	err := VerifyPublisherIdentity(ident.Address, &ident.PublisherIdentityMessage, dssSigningKey)
+	// the public identity must verify. Its expiry is checked against the own clock without tolerance.
+	err := VerifyPublisherIdentity(ident.Address, &ident.PublisherIdentityMessage, dssSigningKey, 0)
 	if err != nil {
 		return err
 	}
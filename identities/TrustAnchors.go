@@ -0,0 +1,143 @@
+// Package identities with trust anchors for verifying the issuer chain of publisher identities
+package identities
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// AddTrustAnchor adds the public key of a trusted issuer of identities, eg the DSS or a CA.
+// Identities issued by a trust anchor, or by an issuer whose identity chains to a trust anchor,
+// are trusted. When trust anchors are added, identities issued by other publishers must chain to a
+// trust anchor to be accepted.
+//  issuerID is the ID used in the identity IssuerID field, eg $dss
+//  pemKey is the issuer's ECDSA public key in PEM format
+// Returns an error if the key is not a valid ECDSA public key
+func (pubIdentities *DomainPublisherIdentities) AddTrustAnchor(issuerID string, pemKey string) error {
+	blockPub, _ := pem.Decode([]byte(pemKey))
+	if blockPub == nil {
+		return lib.MakeErrorf("AddTrustAnchor: Public key of issuer '%s' is not in PEM format", issuerID)
+	}
+	genericPublicKey, err := x509.ParsePKIXPublicKey(blockPub.Bytes)
+	if err != nil {
+		return lib.MakeErrorf("AddTrustAnchor: Invalid public key of issuer '%s': %s", issuerID, err)
+	}
+	publicKey, ok := genericPublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return lib.MakeErrorf("AddTrustAnchor: Public key of issuer '%s' is not an ECDSA key", issuerID)
+	}
+	pubIdentities.c.UpdateMutex.Lock()
+	defer pubIdentities.c.UpdateMutex.Unlock()
+	pubIdentities.trustAnchors[issuerID] = publicKey
+	return nil
+}
+
+// GetCABundle returns the CA certificates that issue publisher certificates, nil if not set
+func (pubIdentities *DomainPublisherIdentities) GetCABundle() *x509.CertPool {
+	pubIdentities.c.UpdateMutex.RLock()
+	defer pubIdentities.c.UpdateMutex.RUnlock()
+	return pubIdentities.caBundle
+}
+
+// GetIssuerKey returns the key to verify the signature of an identity with by following the chain
+// of issuers up to a trust anchor. The identity is trusted if:
+//  - its issuer is a trust anchor, or
+//  - it is self-signed with the key of the trust anchor of its publisher ID, eg the DSS, or
+//  - its issuer's identity is known and trusted
+// Self-signed identities are accepted but not trusted, unless they claim to be a trust anchor
+// with a different key. Without trust anchors, only the DSS can issue identities of other
+// publishers. When trust anchors are configured, identities of other issuers are accepted if their
+// issuer's identity is known and trusted.
+// Returns an error if the identity is not accepted.
+func (pubIdentities *DomainPublisherIdentities) GetIssuerKey(ident *types.PublisherIdentityMessage) (
+	issuerKey *ecdsa.PublicKey, trusted bool, err error) {
+
+	pubIdentities.c.UpdateMutex.RLock()
+	defer pubIdentities.c.UpdateMutex.RUnlock()
+
+	issuerID := ident.IssuerID
+	if issuerID == "" {
+		return nil, false, lib.MakeErrorf("GetIssuerKey: Identity '%s' has no issuer", ident.Address)
+	}
+	anchorKey := pubIdentities.trustAnchors[issuerID]
+	if issuerID == ident.PublisherID {
+		ownKey := messaging.PublicKeyFromPem(ident.PublicKey)
+		trusted = anchorKey != nil && ownKey != nil && isSameKey(anchorKey, ownKey)
+		if anchorKey != nil && !trusted {
+			return nil, false, lib.MakeErrorf("GetIssuerKey: Self-signed identity '%s' doesn't match "+
+				"the key of its trust anchor", ident.Address)
+		}
+		return ownKey, trusted, nil
+	} else if anchorKey != nil {
+		return anchorKey, true, nil
+	} else if len(pubIdentities.trustAnchors) == 0 && issuerID != types.DSSPublisherID {
+		// without trust anchors any publisher could otherwise issue an identity for another
+		return nil, false, lib.MakeErrorf("GetIssuerKey: Unknown issuer '%s' of identity '%s'",
+			issuerID, ident.Address)
+	}
+	// the issuer is a known publisher of the domain, eg the DSS. Its trust was determined when its
+	// identity was received, so the chain is followed one issuer at a time.
+	issuerAddress := MakePublisherIdentityAddress(ident.Domain, issuerID)
+	issuerKey = pubIdentities.publicKeyCache[issuerAddress]
+	trusted = pubIdentities.trusted[issuerAddress]
+	if issuerKey == nil {
+		return nil, false, lib.MakeErrorf("GetIssuerKey: Unknown issuer '%s' of identity '%s'",
+			issuerID, ident.Address)
+	} else if !trusted && len(pubIdentities.trustAnchors) > 0 {
+		return nil, false, lib.MakeErrorf("GetIssuerKey: Issuer '%s' of identity '%s' doesn't chain "+
+			"to a trust anchor", issuerID, ident.Address)
+	}
+	return issuerKey, trusted, nil
+}
+
+// HasTrustAnchors returns true if trust anchors are configured
+func (pubIdentities *DomainPublisherIdentities) HasTrustAnchors() bool {
+	pubIdentities.c.UpdateMutex.RLock()
+	defer pubIdentities.c.UpdateMutex.RUnlock()
+	return len(pubIdentities.trustAnchors) > 0
+}
+
+// IsTrusted returns true if the identity of a publisher chains to a trust anchor
+// publisherAddress must start with domain/publisherId
+func (pubIdentities *DomainPublisherIdentities) IsTrusted(publisherAddress string) bool {
+	segments := strings.Split(publisherAddress, "/")
+	if len(segments) < 2 {
+		return false
+	}
+	identityAddress := MakePublisherIdentityAddress(segments[0], segments[1])
+	pubIdentities.c.UpdateMutex.RLock()
+	defer pubIdentities.c.UpdateMutex.RUnlock()
+	return pubIdentities.trusted[identityAddress]
+}
+
+// LoadTrustAnchor loads the PEM public key of a trusted issuer from file
+// See also AddTrustAnchor
+func (pubIdentities *DomainPublisherIdentities) LoadTrustAnchor(issuerID string, filename string) error {
+	pemKey, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return lib.MakeErrorf("LoadTrustAnchor: Unable to read key of issuer '%s' from %s: %s",
+			issuerID, filename, err)
+	}
+	return pubIdentities.AddTrustAnchor(issuerID, string(pemKey))
+}
+
+// SetCABundle sets the CA certificates that issue publisher certificates. Identities with a
+// certificate that verifies against the bundle are trusted. Identities with a certificate that
+// doesn't verify are rejected. See also LoadCABundle.
+func (pubIdentities *DomainPublisherIdentities) SetCABundle(caBundle *x509.CertPool) {
+	pubIdentities.c.UpdateMutex.Lock()
+	defer pubIdentities.c.UpdateMutex.Unlock()
+	pubIdentities.caBundle = caBundle
+}
+
+// isSameKey returns true if the two public keys are identical
+func isSameKey(key1 *ecdsa.PublicKey, key2 *ecdsa.PublicKey) bool {
+	return key1.X.Cmp(key2.X) == 0 && key1.Y.Cmp(key2.Y) == 0
+}
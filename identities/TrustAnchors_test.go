@@ -0,0 +1,161 @@
+package identities_test
+
+import (
+	"crypto/ecdsa"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustAnchors(t *testing.T) {
+	const domain = "test"
+	const caID = "ca1"
+	collection := identities.NewDomainPublisherIdentities()
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), collection.GetPublisherKey)
+	receiver := identities.NewReceivePublisherIdentities(domain, collection, signer)
+	receiver.Start()
+	defer receiver.Stop()
+
+	caKeys := messaging.CreateAsymKeys()
+	err := collection.AddTrustAnchor(caID, messaging.PublicKeyToPem(&caKeys.PublicKey))
+	require.NoError(t, err)
+	assert.True(t, collection.HasTrustAnchors())
+	err = collection.AddTrustAnchor("bad", "not a key")
+	assert.Error(t, err)
+
+	// an identity issued by the CA is trusted
+	pub1Ident, pub1Keys := identities.CreateIdentity(domain, "pub1")
+	pub1Ident.IssuerID = caID
+	messaging.SignIdentity(&pub1Ident.PublisherIdentityMessage, caKeys)
+	signer1 := messaging.NewMessageSigner(messenger, pub1Keys, collection.GetPublisherKey)
+	signer1.PublishObject(pub1Ident.Address, false, pub1Ident.PublisherIdentityMessage, nil)
+	require.NotNil(t, collection.GetPublisherByAddress(pub1Ident.Address))
+	assert.True(t, collection.IsTrusted(pub1Ident.Address))
+
+	// an identity issued by a trusted publisher is trusted
+	pub2Ident, pub2Keys := identities.CreateIdentity(domain, "pub2")
+	pub2Ident.IssuerID = "pub1"
+	messaging.SignIdentity(&pub2Ident.PublisherIdentityMessage, pub1Keys)
+	signer2 := messaging.NewMessageSigner(messenger, pub2Keys, collection.GetPublisherKey)
+	signer2.PublishObject(pub2Ident.Address, false, pub2Ident.PublisherIdentityMessage, nil)
+	assert.True(t, collection.IsTrusted(domain+"/pub2"))
+
+	// a self-signed identity is accepted but not trusted
+	pub3Ident, pub3Keys := identities.CreateIdentity(domain, "pub3")
+	signer3 := messaging.NewMessageSigner(messenger, pub3Keys, collection.GetPublisherKey)
+	signer3.PublishObject(pub3Ident.Address, false, pub3Ident.PublisherIdentityMessage, nil)
+	require.NotNil(t, collection.GetPublisherByAddress(pub3Ident.Address))
+	assert.False(t, collection.IsTrusted(pub3Ident.Address))
+
+	// error case - an identity issued by an untrusted publisher is rejected
+	pub4Ident, pub4Keys := identities.CreateIdentity(domain, "pub4")
+	pub4Ident.IssuerID = "pub3"
+	messaging.SignIdentity(&pub4Ident.PublisherIdentityMessage, pub3Keys)
+	signer4 := messaging.NewMessageSigner(messenger, pub4Keys, collection.GetPublisherKey)
+	signer4.PublishObject(pub4Ident.Address, false, pub4Ident.PublisherIdentityMessage, nil)
+	assert.Nil(t, collection.GetPublisherByAddress(pub4Ident.Address))
+
+	// error case - an identity that claims to be issued by the CA with another key is rejected
+	pub5Ident, pub5Keys := identities.CreateIdentity(domain, "pub5")
+	pub5Ident.IssuerID = caID
+	messaging.SignIdentity(&pub5Ident.PublisherIdentityMessage, pub5Keys)
+	signer5 := messaging.NewMessageSigner(messenger, pub5Keys, collection.GetPublisherKey)
+	signer5.PublishObject(pub5Ident.Address, false, pub5Ident.PublisherIdentityMessage, nil)
+	assert.Nil(t, collection.GetPublisherByAddress(pub5Ident.Address))
+
+	// error case - a self-signed identity doesn't replace a trusted identity
+	fakePub1, fakePub1Keys := identities.CreateIdentity(domain, "pub1")
+	fakePub1Signer := messaging.NewMessageSigner(messenger, fakePub1Keys, collection.GetPublisherKey)
+	fakePub1Signer.PublishObject(fakePub1.Address, false, fakePub1.PublisherIdentityMessage, nil)
+	assert.True(t, isSameKey(&pub1Keys.PublicKey, collection.GetPublisherKey(pub1Ident.Address)))
+	assert.True(t, collection.IsTrusted(pub1Ident.Address))
+
+	// error case - a self-signed identity of a trust anchor with another key is rejected
+	dssKeys := messaging.CreateAsymKeys()
+	err = collection.AddTrustAnchor(types.DSSPublisherID, messaging.PublicKeyToPem(&dssKeys.PublicKey))
+	require.NoError(t, err)
+	fakeDss, fakeDssKeys := identities.CreateIdentity(domain, types.DSSPublisherID)
+	fakeSigner := messaging.NewMessageSigner(messenger, fakeDssKeys, collection.GetPublisherKey)
+	fakeSigner.PublishObject(fakeDss.Address, false, fakeDss.PublisherIdentityMessage, nil)
+	assert.Nil(t, collection.GetDSSIdentity(domain))
+
+	// the trust is retained when saved and loaded
+	filename := path.Join(os.TempDir(), "test-trustanchors.json")
+	defer os.Remove(filename)
+	err = collection.SaveIdentities(filename)
+	require.NoError(t, err)
+	collection2 := identities.NewDomainPublisherIdentities()
+	err = collection2.LoadIdentities(filename)
+	require.NoError(t, err)
+	assert.True(t, collection2.IsTrusted(pub1Ident.Address))
+	assert.False(t, collection2.IsTrusted(pub3Ident.Address))
+	collection2.RemoveIdentity(pub1Ident.Address)
+	assert.False(t, collection2.IsTrusted(pub1Ident.Address))
+}
+
+func TestIssuersWithoutTrustAnchors(t *testing.T) {
+	const domain = "test"
+	collection := identities.NewDomainPublisherIdentities()
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), collection.GetPublisherKey)
+	receiver := identities.NewReceivePublisherIdentities(domain, collection, signer)
+	receiver.Start()
+	defer receiver.Stop()
+
+	// a self-signed identity is accepted
+	pub1Ident, pub1Keys := identities.CreateIdentity(domain, "pub1")
+	signer1 := messaging.NewMessageSigner(messenger, pub1Keys, collection.GetPublisherKey)
+	signer1.PublishObject(pub1Ident.Address, false, pub1Ident.PublisherIdentityMessage, nil)
+	require.NotNil(t, collection.GetPublisherByAddress(pub1Ident.Address))
+
+	// error case - a known publisher other than the DSS can't issue identities
+	pub2Ident, pub2Keys := identities.CreateIdentity(domain, "pub2")
+	signer2 := messaging.NewMessageSigner(messenger, pub2Keys, collection.GetPublisherKey)
+	signer2.PublishObject(pub2Ident.Address, false, pub2Ident.PublisherIdentityMessage, nil)
+	fakePub1 := pub1Ident.PublisherIdentityMessage
+	fakePub1.IssuerID = "pub2"
+	fakePub1.PublicKey = pub2Ident.PublicKey
+	messaging.SignIdentity(&fakePub1, pub2Keys)
+	signer2.PublishObject(fakePub1.Address, false, fakePub1, nil)
+	assert.True(t, isSameKey(&pub1Keys.PublicKey, collection.GetPublisherKey(pub1Ident.Address)))
+}
+
+func TestStrictCommands(t *testing.T) {
+	const domain = "test"
+	collection := identities.NewDomainPublisherIdentities()
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	pub1Ident, pub1Keys := identities.CreateIdentity(domain, "pub1")
+	collection.AddVerifiedIdentity(&pub1Ident.PublisherIdentityMessage, false)
+	signer := messaging.NewMessageSigner(messenger, pub1Keys, collection.GetPublisherKey)
+	command := &types.SetInputMessage{Address: domain + "/pub2/node1/switch/0/$set", Sender: pub1Ident.Address}
+	signedMessage, err := signer.SignObject(command)
+	require.NoError(t, err)
+	rawMessage, err := messaging.EncryptMessage(signedMessage, &pub1Keys.PublicKey)
+	require.NoError(t, err)
+
+	// without strict mode the verified sender is accepted
+	var received types.SetInputMessage
+	_, isSigned, err := signer.DecodeCommand(rawMessage, &received)
+	assert.True(t, isSigned)
+	assert.NoError(t, err)
+
+	// in strict mode the self-signed sender is rejected until trusted
+	signer.SetTrustedSenderCheck(collection.IsTrusted)
+	_, _, err = signer.DecodeCommand(rawMessage, &received)
+	assert.Error(t, err)
+	collection.AddVerifiedIdentity(&pub1Ident.PublisherIdentityMessage, true)
+	_, _, err = signer.DecodeCommand(rawMessage, &received)
+	assert.NoError(t, err)
+}
+
+// isSameKey returns true if the two public keys are identical
+func isSameKey(key1 *ecdsa.PublicKey, key2 *ecdsa.PublicKey) bool {
+	return key2 != nil && key1.X.Cmp(key2.X) == 0 && key1.Y.Cmp(key2.Y) == 0
+}
@@ -0,0 +1,234 @@
+// Package influx with an exporter of output values to InfluxDB v2
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Exporter defaults
+const (
+	DefaultBatchSize     = 100 // max nr of lines to write in a single request
+	DefaultFlushInterval = 10  // max seconds before pending lines are written
+	DefaultMaxPending    = 10000
+	writeTimeoutSec      = 10
+)
+
+// InfluxConfig with the InfluxDB v2 server to export output values to
+type InfluxConfig struct {
+	URL           string `yaml:"url"`           // server URL, eg http://localhost:8086
+	Org           string `yaml:"org"`           // organization of the bucket
+	Bucket        string `yaml:"bucket"`        // bucket to write to
+	Token         string `yaml:"token"`         // authorization token with write access to the bucket
+	Measurement   string `yaml:"measurement"`   // measurement name. Default is DefaultMeasurement
+	BatchSize     int    `yaml:"batchSize"`     // max nr of lines per write. Default is DefaultBatchSize
+	FlushInterval int    `yaml:"flushInterval"` // max seconds between writes. Default is DefaultFlushInterval
+	MaxPending    int    `yaml:"maxPending"`    // max nr of lines kept while the server is unreachable. Default is DefaultMaxPending
+	Backfill      bool   `yaml:"backfill"`      // write the history of an output on its first value update
+}
+
+// InfluxExporter writes output values to an InfluxDB v2 bucket using the line protocol.
+// Lines are written in batches. Lines that fail to write are retried with the next write until
+// the max nr of pending lines is reached, after which the oldest lines are dropped.
+// Attach the exporter to a publisher with AddIntegration to export the values as they are published.
+type InfluxExporter struct {
+	config      InfluxConfig    // server configuration
+	client      *http.Client    // client for writing to the server
+	backfilled  map[string]bool // outputs whose history is written
+	pending     []string        // lines waiting to be written
+	lastFlush   time.Time       // time of the last write
+	updateMutex *sync.Mutex     // mutex for concurrent writing of values
+}
+
+// DiscoveryPublished does nothing as only output values are exported
+func (exporter *InfluxExporter) DiscoveryPublished() {
+}
+
+// Flush writes the pending lines to the server
+func (exporter *InfluxExporter) Flush() error {
+	exporter.updateMutex.Lock()
+	defer exporter.updateMutex.Unlock()
+	return exporter.flush()
+}
+
+// FlushIfDue writes the pending lines to the server if the flush interval has passed
+func (exporter *InfluxExporter) FlushIfDue() error {
+	exporter.updateMutex.Lock()
+	defer exporter.updateMutex.Unlock()
+	interval := time.Duration(exporter.config.FlushInterval) * time.Second
+	if len(exporter.pending) == 0 || time.Since(exporter.lastFlush) < interval {
+		return nil
+	}
+	return exporter.flush()
+}
+
+// OutputValuePublished adds the output value to be written after the publisher published it
+//  history is the published history, used for backfill
+func (exporter *InfluxExporter) OutputValuePublished(output *types.OutputDiscoveryMessage, value *types.OutputValue,
+	history outputs.OutputHistory) {
+	err := exporter.WriteOutputValue(output, value, history)
+	if err != nil {
+		logrus.Errorf("InfluxExporter.OutputValuePublished: %s", err)
+	}
+}
+
+// PendingCount returns the number of lines waiting to be written
+func (exporter *InfluxExporter) PendingCount() int {
+	exporter.updateMutex.Lock()
+	defer exporter.updateMutex.Unlock()
+	return len(exporter.pending)
+}
+
+// Start does nothing as values are written when they are published
+func (exporter *InfluxExporter) Start() error {
+	return nil
+}
+
+// Stop writes the pending lines to the server
+func (exporter *InfluxExporter) Stop() {
+	err := exporter.Flush()
+	if err != nil {
+		logrus.Errorf("InfluxExporter.Stop: %s", err)
+	}
+}
+
+// ValuesPublished writes the pending lines if the flush interval has passed, after the publisher
+// published output values
+func (exporter *InfluxExporter) ValuesPublished() {
+	err := exporter.FlushIfDue()
+	if err != nil {
+		logrus.Errorf("InfluxExporter.ValuesPublished: %s", err)
+	}
+}
+
+// WriteHistory adds the history of an output to be written to the server, oldest value first
+func (exporter *InfluxExporter) WriteHistory(output *types.OutputDiscoveryMessage, history []types.OutputValue) error {
+	exporter.updateMutex.Lock()
+	defer exporter.updateMutex.Unlock()
+	exporter.backfilled[output.Address] = true
+	for i := len(history) - 1; i >= 0; i-- {
+		exporter.addLine(MakeLine(exporter.config.Measurement, output, &history[i]))
+	}
+	return exporter.flushIfFull()
+}
+
+// WriteOutputValue adds an output value to be written to the server.
+// If backfill is enabled, the history is written on the first value of an output.
+//  history is the output history, newest value first, used for backfill. nil to ignore.
+func (exporter *InfluxExporter) WriteOutputValue(
+	output *types.OutputDiscoveryMessage, value *types.OutputValue, history []types.OutputValue) error {
+
+	exporter.updateMutex.Lock()
+	isBackfill := exporter.config.Backfill && history != nil && !exporter.backfilled[output.Address]
+	exporter.updateMutex.Unlock()
+	if isBackfill {
+		return exporter.WriteHistory(output, history)
+	}
+
+	exporter.updateMutex.Lock()
+	defer exporter.updateMutex.Unlock()
+	exporter.addLine(MakeLine(exporter.config.Measurement, output, value))
+	return exporter.flushIfFull()
+}
+
+// addLine adds a line to the pending lines, dropping the oldest if the max is reached
+// For internal use only. Use within locked section.
+func (exporter *InfluxExporter) addLine(line string) {
+	exporter.pending = append(exporter.pending, line)
+	if len(exporter.pending) > exporter.config.MaxPending {
+		dropCount := len(exporter.pending) - exporter.config.MaxPending
+		logrus.Warningf("InfluxExporter.addLine: Too many pending lines. Dropping %d oldest lines", dropCount)
+		exporter.pending = exporter.pending[dropCount:]
+	}
+}
+
+// flush writes the pending lines in batches
+// For internal use only. Use within locked section.
+func (exporter *InfluxExporter) flush() error {
+	exporter.lastFlush = time.Now()
+	for len(exporter.pending) > 0 {
+		batchSize := exporter.config.BatchSize
+		if batchSize > len(exporter.pending) {
+			batchSize = len(exporter.pending)
+		}
+		err := exporter.write(exporter.pending[:batchSize])
+		if err != nil {
+			return err
+		}
+		exporter.pending = exporter.pending[batchSize:]
+	}
+	return nil
+}
+
+// flushIfFull writes the pending lines if a full batch is waiting
+// For internal use only. Use within locked section.
+func (exporter *InfluxExporter) flushIfFull() error {
+	if len(exporter.pending) < exporter.config.BatchSize {
+		return nil
+	}
+	return exporter.flush()
+}
+
+// write posts the lines to the InfluxDB v2 write API
+func (exporter *InfluxExporter) write(lines []string) error {
+	query := url.Values{}
+	query.Set("org", exporter.config.Org)
+	query.Set("bucket", exporter.config.Bucket)
+	query.Set("precision", "s")
+	writeURL := fmt.Sprintf("%s/api/v2/write?%s", strings.TrimSuffix(exporter.config.URL, "/"), query.Encode())
+	body := strings.Join(lines, "\n")
+
+	request, err := http.NewRequest(http.MethodPost, writeURL, bytes.NewBufferString(body))
+	if err != nil {
+		return lib.MakeErrorf("InfluxExporter.write: Invalid request to %s: %s", writeURL, err)
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if exporter.config.Token != "" {
+		request.Header.Set("Authorization", "Token "+exporter.config.Token)
+	}
+	response, err := exporter.client.Do(request)
+	if err != nil {
+		return lib.MakeErrorf("InfluxExporter.write: Failed writing %d lines to %s: %s", len(lines), exporter.config.URL, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(response.Body)
+		return lib.MakeErrorf("InfluxExporter.write: Server %s rejected %d lines: %s %s",
+			exporter.config.URL, len(lines), response.Status, msg)
+	}
+	logrus.Debugf("InfluxExporter.write: Wrote %d lines to %s", len(lines), exporter.config.URL)
+	return nil
+}
+
+// NewInfluxExporter creates a new exporter of output values to InfluxDB v2
+func NewInfluxExporter(config InfluxConfig) *InfluxExporter {
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultFlushInterval
+	}
+	if config.MaxPending <= 0 {
+		config.MaxPending = DefaultMaxPending
+	}
+	exporter := &InfluxExporter{
+		config:      config,
+		client:      &http.Client{Timeout: writeTimeoutSec * time.Second},
+		backfilled:  make(map[string]bool),
+		pending:     make([]string, 0),
+		lastFlush:   time.Now(),
+		updateMutex: &sync.Mutex{},
+	}
+	return exporter
+}
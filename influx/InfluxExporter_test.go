@@ -0,0 +1,86 @@
+package influx_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/influx"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfluxExporter(t *testing.T) {
+	var receivedLines = make([]string, 0)
+	var rxMutex = sync.Mutex{}
+	var authorization = ""
+	var failWrites = false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rxMutex.Lock()
+		defer rxMutex.Unlock()
+		assert.Equal(t, "/api/v2/write", r.URL.Path)
+		assert.Equal(t, "bucket1", r.URL.Query().Get("bucket"))
+		authorization = r.Header.Get("Authorization")
+		if failWrites {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		receivedLines = append(receivedLines, strings.Split(string(body), "\n")...)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	exporter := influx.NewInfluxExporter(influx.InfluxConfig{
+		URL: server.URL, Org: "org1", Bucket: "bucket1", Token: "token1", BatchSize: 2, Backfill: true,
+	})
+	output := outputs.NewOutput(domain, publisher1ID, node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	history := []types.OutputValue{
+		{Value: "3", EpochTime: 1600000003},
+		{Value: "2", EpochTime: 1600000002},
+		{Value: "1", EpochTime: 1600000001},
+	}
+	// the first value backfills the history
+	err := exporter.WriteOutputValue(output, &history[0], history)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exporter.PendingCount())
+	assert.Equal(t, 3, len(receivedLines))
+	assert.True(t, strings.HasSuffix(receivedLines[0], "value=1 1600000001"), "oldest value first")
+	assert.Equal(t, "Token token1", authorization)
+
+	// the next value waits for a full batch
+	err = exporter.WriteOutputValue(output, &types.OutputValue{Value: "4", EpochTime: 1600000004}, history)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, exporter.PendingCount())
+	err = exporter.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(receivedLines))
+
+	// failed writes are retried
+	rxMutex.Lock()
+	failWrites = true
+	rxMutex.Unlock()
+	exporter.WriteOutputValue(output, &types.OutputValue{Value: "5", EpochTime: 1600000005}, nil)
+	err = exporter.Flush()
+	assert.Error(t, err)
+	assert.Equal(t, 1, exporter.PendingCount())
+	rxMutex.Lock()
+	failWrites = false
+	rxMutex.Unlock()
+	err = exporter.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exporter.PendingCount())
+	assert.Equal(t, 5, len(receivedLines))
+
+	// published values are written and pending lines are written when the publisher stops
+	exporter.OutputValuePublished(output, &types.OutputValue{Value: "6", EpochTime: 1600000006}, history)
+	assert.Equal(t, 1, exporter.PendingCount())
+	exporter.Stop()
+	assert.Equal(t, 0, exporter.PendingCount())
+	assert.Equal(t, 6, len(receivedLines))
+}
@@ -0,0 +1,68 @@
+// Package influx with conversion of output values to the InfluxDB line protocol
+package influx
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// DefaultMeasurement is the measurement name used for output values
+const DefaultMeasurement = "output"
+
+// tag keys, tag values and measurement names escape commas, spaces and equal signs
+var tagEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// string field values escape double quotes and backslashes
+var fieldEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// MakeLine converts an output value to a line in the InfluxDB line protocol.
+// The line is tagged with the domain, publisher, node, output type and instance from the output address.
+// Numeric values are written to the 'value' field, boolean values to the 'state' field and other values
+// as string to the 'text' field, as InfluxDB doesn't allow different field types in the same field.
+// The timestamp has a precision of seconds.
+//  measurement is the measurement to write to, "" for DefaultMeasurement
+func MakeLine(measurement string, output *types.OutputDiscoveryMessage, value *types.OutputValue) string {
+	if measurement == "" {
+		measurement = DefaultMeasurement
+	}
+	// address: domain/publisherID/nodeID/outputType/instance/$messageType
+	segments := strings.Split(output.Address, "/")
+	for len(segments) < 5 {
+		segments = append(segments, "")
+	}
+	tags := [][2]string{
+		{"domain", segments[0]},
+		{"publisher", segments[1]},
+		{"node", segments[2]},
+		{"type", segments[3]},
+		{"instance", segments[4]},
+	}
+	if output.Unit != "" {
+		tags = append(tags, [2]string{"unit", string(output.Unit)})
+	}
+	line := tagEscaper.Replace(measurement)
+	for _, tag := range tags {
+		// empty tag values are not allowed
+		if tag[1] != "" {
+			line += "," + tag[0] + "=" + tagEscaper.Replace(tag[1])
+		}
+	}
+	line += " " + makeField(value.Value)
+	line += fmt.Sprintf(" %d", value.EpochTime)
+	return line
+}
+
+// makeField returns the field of a value
+func makeField(value string) string {
+	if floatValue, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(floatValue) && !math.IsInf(floatValue, 0) {
+		return "value=" + strconv.FormatFloat(floatValue, 'f', -1, 64)
+	}
+	if boolValue, err := strconv.ParseBool(value); err == nil {
+		return "state=" + strconv.FormatBool(boolValue)
+	}
+	return `text="` + fieldEscaper.Replace(value) + `"`
+}
@@ -0,0 +1,32 @@
+package influx_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/influx"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const domain = "test"
+const publisher1ID = "publisher1"
+const node1ID = "node 1"
+
+func TestMakeLine(t *testing.T) {
+	output := outputs.NewOutput(domain, publisher1ID, node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	output.Unit = types.UnitCelcius
+	value := types.OutputValue{Value: "21.50", EpochTime: 1600000000}
+
+	line := influx.MakeLine("", output, &value)
+	assert.Equal(t, `output,domain=test,publisher=publisher1,node=node\ 1,type=temperature,instance=0,unit=C value=21.5 1600000000`, line)
+
+	value.Value = "true"
+	line = influx.MakeLine("sensors", output, &value)
+	assert.Contains(t, line, "sensors,domain=test")
+	assert.Contains(t, line, " state=true ")
+
+	value.Value = `say "hello"`
+	line = influx.MakeLine("", output, &value)
+	assert.Contains(t, line, ` text="say \"hello\"" `)
+}
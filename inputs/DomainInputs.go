@@ -4,6 +4,7 @@ package inputs
 import (
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
@@ -39,6 +40,21 @@ func (domainInputs *DomainInputs) GetNodeInputs(nodeAddress string) []*types.Inp
 	return inputList
 }
 
+// GetInputsByPattern returns the inputs whose address matches a pattern with '+' wildcards
+//  pattern is the input address pattern, eg domain/+/+/switch/+ for all switch inputs
+func (domainInputs *DomainInputs) GetInputsByPattern(pattern string) []*types.InputDiscoveryMessage {
+	var inputList = make([]*types.InputDiscoveryMessage, 0)
+	domainInputs.c.GetByPattern(pattern, &inputList)
+	return inputList
+}
+
+// GetInputsByType returns the inputs of the given type
+func (domainInputs *DomainInputs) GetInputsByType(inputType types.InputType) []*types.InputDiscoveryMessage {
+	var inputList = make([]*types.InputDiscoveryMessage, 0)
+	domainInputs.c.GetByType(string(inputType), &inputList)
+	return inputList
+}
+
 // GetInputByAddress returns an input by its address
 // inputAddr must contain the full input address, eg <zone>/<publisherId>/<nodeId>/"$input"/<type>/<instance>
 // Returns nil if address has no known input
@@ -56,6 +72,13 @@ func (domainInputs *DomainInputs) RemoveInput(inputAddress string) {
 	domainInputs.c.Remove(inputAddress)
 }
 
+// StartBulkLoad defers building the lookup indexes of the discovered inputs until the burst of
+// retained discovery messages on subscribing subsides, see lib.DomainCollection.StartBulkLoad
+//  quietPeriod is the time without discovery messages after which the indexes are built
+func (domainInputs *DomainInputs) StartBulkLoad(quietPeriod time.Duration) {
+	domainInputs.c.StartBulkLoad(quietPeriod)
+}
+
 // Subscribe to inputs from a domain publisher
 func (domainInputs *DomainInputs) Subscribe(domain string, publisherID string) {
 	// subscription address for all inputs domain/publisher/node/type/instance/$input
@@ -88,8 +111,14 @@ func MakeInputDiscoveryAddress(domain string, publisherID string, nodeID string,
 // NewDomainInputs creates a new instance for handling of discovered domain inputs
 func NewDomainInputs(messageSigner *messaging.MessageSigner) *DomainInputs {
 
+	domainCollection := lib.NewDomainCollection(
+		reflect.TypeOf(&types.InputDiscoveryMessage{}), messageSigner.GetPublicKey)
+	domainCollection.ReceiveHook = messageSigner.ApplyReceiveHooks
+	domainCollection.GetItemType = func(item interface{}) string {
+		return string(item.(*types.InputDiscoveryMessage).InputType)
+	}
 	inputs := DomainInputs{
-		c:             lib.NewDomainCollection(reflect.TypeOf(&types.InputDiscoveryMessage{}), messageSigner.GetPublicKey),
+		c:             domainCollection,
 		messageSigner: messageSigner,
 	}
 	return &inputs
@@ -0,0 +1,259 @@
+// Package inputs with inputs that are fed by multiple sources with failover
+package inputs
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// FailoverInputSource is the source name of failover inputs
+const FailoverInputSource = "failover"
+
+// FailoverSource is a source of a failover input, eg an http poll, a file or a manual override
+type FailoverSource struct {
+	Name     string // name of the source, reported in the input's activeSource attribute
+	Priority int    // priority of the source, the healthy source with the lowest priority is active
+	MaxAge   int    // seconds after the last value that the source is considered failed, 0 to not expire
+}
+
+// failoverSourceState with the health of a source
+type failoverSourceState struct {
+	FailoverSource
+	failed   bool      // the source is reported as failed until it receives a new value
+	received time.Time // time the last value was received, zero if none
+	sender   string    // sender of the last value
+	value    string    // last value
+}
+
+// failoverInput is an input fed by multiple sources
+type failoverInput struct {
+	activeSource string                 // name of the active source, "" if no source is healthy
+	sources      []*failoverSourceState // sources ordered by priority
+}
+
+// FailoverInputs with inputs that are fed by multiple sources with a priority. Only values of the
+// active source, the healthy source with the lowest priority, are passed to the input handler. A
+// source is healthy if it has received a value within its max age and is not reported as failed.
+// When the active source changes, the last value of the new active source is passed to the handler
+// and the input is republished with the name of the active source in its activeSource attribute.
+//
+// The sources are regular inputs, eg from http, file or set commands, whose handler is obtained
+// with AddSource. A manual override is a set command input with the lowest priority and a max age
+// after which the override expires.
+type FailoverInputs struct {
+	inputs           map[string]*failoverInput // failover inputs by inputID
+	registeredInputs *RegisteredInputs         // inputs of this publisher
+	updateMutex      *sync.Mutex               // mutex for async updating of sources
+}
+
+// AddSource adds a source to a failover input and returns the handler to use for the input that
+// provides the source values. A source with the same name is replaced.
+// Returns nil if the failover input doesn't exist.
+func (failover *FailoverInputs) AddSource(inputID string, source FailoverSource) func(
+	input *types.InputDiscoveryMessage, sender string, value string) {
+
+	failover.updateMutex.Lock()
+	defer failover.updateMutex.Unlock()
+	fi := failover.inputs[inputID]
+	if fi == nil {
+		logrus.Errorf("AddSource: failover input %s not found", inputID)
+		return nil
+	}
+	sources := make([]*failoverSourceState, 0, len(fi.sources)+1)
+	for _, state := range fi.sources {
+		if state.Name != source.Name {
+			sources = append(sources, state)
+		}
+	}
+	sources = append(sources, &failoverSourceState{FailoverSource: source})
+	sort.SliceStable(sources, func(i, j int) bool { return sources[i].Priority < sources[j].Priority })
+	fi.sources = sources
+
+	return func(input *types.InputDiscoveryMessage, sender string, value string) {
+		failover.receiveSourceValue(inputID, source.Name, sender, value)
+	}
+}
+
+// CheckSources fails over inputs whose active source has expired. Invoke this periodically.
+func (failover *FailoverInputs) CheckSources() {
+	failover.updateMutex.Lock()
+	inputIDs := make([]string, 0, len(failover.inputs))
+	for inputID := range failover.inputs {
+		inputIDs = append(inputIDs, inputID)
+	}
+	failover.updateMutex.Unlock()
+	for _, inputID := range inputIDs {
+		failover.updateActiveSource(inputID)
+	}
+}
+
+// CreateInput creates a new input that is fed by the sources added with AddSource. The handler
+// receives the values of the active source.
+// If an input of the given nodeID, type and instance already exists it will be replaced.
+func (failover *FailoverInputs) CreateInput(
+	nodeHWID string, inputType types.InputType, instance string,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) *types.InputDiscoveryMessage {
+
+	input := failover.registeredInputs.CreateInputWithSource(
+		nodeHWID, inputType, instance, FailoverInputSource, handler)
+	input.Attr[types.NodeAttrActiveSource] = ""
+	failover.registeredInputs.UpdateInput(input)
+
+	failover.updateMutex.Lock()
+	defer failover.updateMutex.Unlock()
+	failover.inputs[input.InputID] = &failoverInput{sources: make([]*failoverSourceState, 0)}
+	return input
+}
+
+// DeleteInput deletes the failover input. Its sources are ignored afterwards.
+func (failover *FailoverInputs) DeleteInput(inputID string) {
+	failover.updateMutex.Lock()
+	defer failover.updateMutex.Unlock()
+	if _, found := failover.inputs[inputID]; !found {
+		logrus.Errorf("DeleteInput: failover input %s not found", inputID)
+		return
+	}
+	delete(failover.inputs, inputID)
+	failover.registeredInputs.DeleteInput(inputID)
+}
+
+// HasInput returns true if the input with the given ID is a failover input
+func (failover *FailoverInputs) HasInput(inputID string) bool {
+	failover.updateMutex.Lock()
+	defer failover.updateMutex.Unlock()
+	_, found := failover.inputs[inputID]
+	return found
+}
+
+// GetActiveSource returns the name of the active source of a failover input, or "" if no source is healthy
+func (failover *FailoverInputs) GetActiveSource(inputID string) string {
+	failover.updateMutex.Lock()
+	defer failover.updateMutex.Unlock()
+	fi := failover.inputs[inputID]
+	if fi == nil {
+		return ""
+	}
+	return fi.activeSource
+}
+
+// SetSourceFailed reports a source of a failover input as failed, eg when its upstream returns an
+// error or an invalid value. The source is healthy again when it receives a new value.
+// Returns an error if the input or source doesn't exist.
+func (failover *FailoverInputs) SetSourceFailed(inputID string, sourceName string) error {
+	failover.updateMutex.Lock()
+	state := failover.getSource(inputID, sourceName)
+	if state != nil {
+		state.failed = true
+	}
+	failover.updateMutex.Unlock()
+	if state == nil {
+		return lib.MakeErrorf("SetSourceFailed: Unknown source '%s' of input '%s'", sourceName, inputID)
+	}
+	failover.updateActiveSource(inputID)
+	return nil
+}
+
+// getSource returns the source of a failover input, or nil if not found. Use within a locked section.
+func (failover *FailoverInputs) getSource(inputID string, sourceName string) *failoverSourceState {
+	fi := failover.inputs[inputID]
+	if fi == nil {
+		return nil
+	}
+	for _, state := range fi.sources {
+		if state.Name == sourceName {
+			return state
+		}
+	}
+	return nil
+}
+
+// receiveSourceValue records the value of a source and passes it to the input handler if the
+// source is active
+func (failover *FailoverInputs) receiveSourceValue(inputID string, sourceName string, sender string, value string) {
+	failover.updateMutex.Lock()
+	state := failover.getSource(inputID, sourceName)
+	if state != nil {
+		state.failed = false
+		state.received = lib.Now()
+		state.sender = sender
+		state.value = value
+	}
+	failover.updateMutex.Unlock()
+	if state == nil {
+		logrus.Warningf("receiveSourceValue: Value of unknown source '%s' of input '%s' is ignored", sourceName, inputID)
+		return
+	}
+	// a change of the active source passes its last value, which is this value
+	if !failover.updateActiveSource(inputID) && failover.GetActiveSource(inputID) == sourceName {
+		failover.registeredInputs.NotifyInputHandler(inputID, sender, value)
+	}
+}
+
+// updateActiveSource selects the healthy source with the lowest priority as the active source.
+// If the active source changes then the input is republished and the last value of the new active
+// source is passed to the handler.
+// Returns true if the active source has changed.
+func (failover *FailoverInputs) updateActiveSource(inputID string) bool {
+	now := lib.Now()
+	failover.updateMutex.Lock()
+	fi := failover.inputs[inputID]
+	if fi == nil {
+		failover.updateMutex.Unlock()
+		return false
+	}
+	var active *failoverSourceState
+	for _, state := range fi.sources {
+		isExpired := state.MaxAge > 0 && now.Sub(state.received) > time.Duration(state.MaxAge)*time.Second
+		if !state.failed && !state.received.IsZero() && !isExpired {
+			active = state
+			break
+		}
+	}
+	activeName := ""
+	if active != nil {
+		activeName = active.Name
+	}
+	if activeName == fi.activeSource {
+		failover.updateMutex.Unlock()
+		return false
+	}
+	logrus.Warningf("updateActiveSource: Input %s fails over from source '%s' to '%s'",
+		inputID, fi.activeSource, activeName)
+	fi.activeSource = activeName
+	var sender, value string
+	if active != nil {
+		sender, value = active.sender, active.value
+	}
+	failover.updateMutex.Unlock()
+
+	input := failover.registeredInputs.GetInputByID(inputID)
+	if input != nil {
+		newInput := *input
+		newInput.Attr = make(types.NodeAttrMap)
+		for key, attrValue := range input.Attr {
+			newInput.Attr[key] = attrValue
+		}
+		newInput.Attr[types.NodeAttrActiveSource] = activeName
+		failover.registeredInputs.UpdateInput(&newInput)
+	}
+	if active != nil {
+		failover.registeredInputs.NotifyInputHandler(inputID, sender, value)
+	}
+	return true
+}
+
+// NewFailoverInputs creates a new instance of inputs with failover sources
+// Inputs must be created through CreateInput
+func NewFailoverInputs(registeredInputs *RegisteredInputs) *FailoverInputs {
+	failover := &FailoverInputs{
+		inputs:           make(map[string]*failoverInput),
+		registeredInputs: registeredInputs,
+		updateMutex:      &sync.Mutex{},
+	}
+	return failover
+}
@@ -0,0 +1,65 @@
+package inputs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailoverInput(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "pub1"
+	const node1ID = "node1"
+	received := make([]string, 0)
+	handler := func(input *types.InputDiscoveryMessage, sender string, value string) {
+		received = append(received, value)
+	}
+	regInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+	failover := inputs.NewFailoverInputs(regInputs)
+	input := failover.CreateInput(node1ID, types.InputTypeValue, types.DefaultInputInstance, handler)
+	assert.Equal(t, inputs.FailoverInputSource, input.Source)
+	assert.Nil(t, failover.AddSource("unknown", inputs.FailoverSource{Name: "http"}))
+
+	manual := failover.AddSource(input.InputID, inputs.FailoverSource{Name: "manual", Priority: 0, MaxAge: 1})
+	http := failover.AddSource(input.InputID, inputs.FailoverSource{Name: "http", Priority: 1})
+	file := failover.AddSource(input.InputID, inputs.FailoverSource{Name: "file", Priority: 2})
+	require.NotNil(t, http)
+	assert.Equal(t, "", failover.GetActiveSource(input.InputID))
+
+	// the first healthy source becomes active
+	file(nil, "", "file1")
+	assert.Equal(t, "file", failover.GetActiveSource(input.InputID))
+	assert.Equal(t, []string{"file1"}, received)
+	assert.Equal(t, "file", regInputs.GetInputByID(input.InputID).Attr[types.NodeAttrActiveSource])
+
+	// a source with a lower priority takes over, values of other sources are ignored
+	http(nil, "", "http1")
+	file(nil, "", "file2")
+	assert.Equal(t, "http", failover.GetActiveSource(input.InputID))
+	assert.Equal(t, []string{"file1", "http1"}, received)
+
+	// a failed source fails over with the last value of the next source
+	err := failover.SetSourceFailed(input.InputID, "http")
+	assert.NoError(t, err)
+	assert.Equal(t, "file", failover.GetActiveSource(input.InputID))
+	assert.Equal(t, []string{"file1", "http1", "file2"}, received)
+	err = failover.SetSourceFailed(input.InputID, "unknown")
+	assert.Error(t, err)
+
+	// a manual override expires after its max age
+	manual(nil, "", "manual1")
+	assert.Equal(t, "manual", failover.GetActiveSource(input.InputID))
+	time.Sleep(1100 * time.Millisecond)
+	failover.CheckSources()
+	assert.Equal(t, "file", failover.GetActiveSource(input.InputID))
+	assert.Equal(t, []string{"file1", "http1", "file2", "manual1", "file2"}, received)
+
+	failover.DeleteInput(input.InputID)
+	assert.Nil(t, regInputs.GetInputByID(input.InputID))
+	file(nil, "", "file3")
+	assert.Equal(t, 5, len(received))
+}
@@ -0,0 +1,155 @@
+// Package inputs with transformation of received input values
+package inputs
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// Transformation operations of an input value
+const (
+	TransformJSON     = "json"     // extract a field using a path, eg: main.temp or list[0].name
+	TransformMultiply = "multiply" // multiply a numeric value by the argument
+	TransformOffset   = "offset"   // add the argument to a numeric value
+	TransformRegex    = "regex"    // extract the first submatch, or the match if the expression has no groups
+	TransformTemplate = "template" // format the value with a Go template, eg: {{.}} C
+)
+
+// TransformStep is a single operation of an input transformation pipeline
+type TransformStep struct {
+	Op  string `json:"op"`  // TransformJSON, TransformMultiply, TransformOffset, TransformRegex or TransformTemplate
+	Arg string `json:"arg"` // argument of the operation
+}
+
+// InputTransform is a pipeline of transformation steps that is applied to a received input value
+// before it is passed to the input handler. Each step takes the result of the previous step.
+type InputTransform []TransformStep
+
+// Apply the transformation steps to the value and return the result
+func (transform InputTransform) Apply(value string) (string, error) {
+	var err error
+	for _, step := range transform {
+		value, err = step.apply(value)
+		if err != nil {
+			return value, err
+		}
+	}
+	return value, nil
+}
+
+// String returns the JSON encoded transformation, as used in the node configuration
+func (transform InputTransform) String() string {
+	encoded, _ := json.Marshal(transform)
+	return string(encoded)
+}
+
+// apply the transformation step to the value
+func (step TransformStep) apply(value string) (string, error) {
+	switch step.Op {
+	case TransformJSON:
+		return extractJSONPath(value, step.Arg)
+	case TransformMultiply, TransformOffset:
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return value, lib.MakeErrorf("InputTransform: Value '%s' is not a number", value)
+		}
+		arg, err := strconv.ParseFloat(step.Arg, 64)
+		if err != nil {
+			return value, lib.MakeErrorf("InputTransform: Argument '%s' of %s is not a number", step.Arg, step.Op)
+		}
+		if step.Op == TransformMultiply {
+			number *= arg
+		} else {
+			number += arg
+		}
+		return strconv.FormatFloat(number, 'f', -1, 64), nil
+	case TransformRegex:
+		expression, err := regexp.Compile(step.Arg)
+		if err != nil {
+			return value, lib.MakeErrorf("InputTransform: Invalid expression '%s': %s", step.Arg, err)
+		}
+		match := expression.FindStringSubmatch(value)
+		if match == nil {
+			return value, lib.MakeErrorf("InputTransform: Value doesn't match expression '%s'", step.Arg)
+		} else if len(match) > 1 {
+			return match[1], nil
+		}
+		return match[0], nil
+	case TransformTemplate:
+		tmpl, err := template.New("input").Parse(step.Arg)
+		if err != nil {
+			return value, lib.MakeErrorf("InputTransform: Invalid template '%s': %s", step.Arg, err)
+		}
+		result := bytes.Buffer{}
+		err = tmpl.Execute(&result, value)
+		if err != nil {
+			return value, lib.MakeErrorf("InputTransform: Template '%s' failed: %s", step.Arg, err)
+		}
+		return result.String(), nil
+	}
+	return value, lib.MakeErrorf("InputTransform: Unknown operation '%s'", step.Op)
+}
+
+// extractJSONPath returns the field of a JSON document at the given path. Path segments are
+// separated by dots. Array elements are selected by index, eg: list[0].name or list.0.name.
+// Strings are returned without quotes, other values as JSON.
+func extractJSONPath(document string, jsonPath string) (string, error) {
+	var field interface{}
+	err := json.Unmarshal([]byte(document), &field)
+	if err != nil {
+		return document, lib.MakeErrorf("InputTransform: Value is not JSON: %s", err)
+	}
+	segments := strings.Split(strings.NewReplacer("[", ".", "]", "").Replace(jsonPath), ".")
+	for _, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		switch node := field.(type) {
+		case map[string]interface{}:
+			field = node[segment]
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return document, lib.MakeErrorf("InputTransform: Invalid index '%s' in path '%s'", segment, jsonPath)
+			}
+			field = node[index]
+		default:
+			field = nil
+		}
+		if field == nil {
+			return document, lib.MakeErrorf("InputTransform: Path '%s' not found", jsonPath)
+		}
+	}
+	if text, isString := field.(string); isString {
+		return text, nil
+	}
+	encoded, _ := json.Marshal(field)
+	return string(encoded), nil
+}
+
+// MakeInputTransformAttr returns the node configuration attribute that holds the transformation of
+// an input: transform/{inputType}/{instance}
+func MakeInputTransformAttr(inputType types.InputType, instance string) types.NodeAttr {
+	return types.NodeAttr("transform/" + string(inputType) + "/" + instance)
+}
+
+// ParseInputTransform parses a JSON encoded transformation, eg:
+//  [{"op":"json","arg":"main.temp"},{"op":"offset","arg":"-273.15"}]
+func ParseInputTransform(encoded string) (InputTransform, error) {
+	transform := InputTransform{}
+	if strings.TrimSpace(encoded) == "" {
+		return transform, nil
+	}
+	err := json.Unmarshal([]byte(encoded), &transform)
+	if err != nil {
+		return transform, lib.MakeErrorf("ParseInputTransform: Invalid transformation '%s': %s", encoded, err)
+	}
+	return transform, nil
+}
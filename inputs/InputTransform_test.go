@@ -0,0 +1,61 @@
+package inputs_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInputTransform(t *testing.T) {
+	const response = `{"main":{"temp":293.15},"weather":[{"description":"clear sky"}]}`
+
+	transform, err := inputs.ParseInputTransform(
+		`[{"op":"json","arg":"main.temp"},{"op":"offset","arg":"-273.15"},{"op":"multiply","arg":"2"}]`)
+	require.NoError(t, err)
+	value, err := transform.Apply(response)
+	require.NoError(t, err)
+	assert.Equal(t, "40", value)
+
+	transform = inputs.InputTransform{
+		{Op: inputs.TransformJSON, Arg: "weather[0].description"},
+		{Op: inputs.TransformRegex, Arg: "^(\\w+) sky$"},
+		{Op: inputs.TransformTemplate, Arg: "sky is {{.}}"},
+	}
+	value, err = transform.Apply(response)
+	require.NoError(t, err)
+	assert.Equal(t, "sky is clear", value)
+
+	// encoding roundtrip
+	parsed, err := inputs.ParseInputTransform(transform.String())
+	require.NoError(t, err)
+	assert.Equal(t, transform, parsed)
+
+	// an empty transformation leaves the value as-is
+	transform, err = inputs.ParseInputTransform("")
+	require.NoError(t, err)
+	value, err = transform.Apply(response)
+	assert.NoError(t, err)
+	assert.Equal(t, response, value)
+}
+
+func TestInputTransformErrors(t *testing.T) {
+	_, err := inputs.ParseInputTransform("not json")
+	assert.Error(t, err)
+	for _, step := range []inputs.TransformStep{
+		{Op: inputs.TransformJSON, Arg: "main.missing"},
+		{Op: inputs.TransformJSON, Arg: "list[3]"},
+		{Op: inputs.TransformMultiply, Arg: "two"},
+		{Op: inputs.TransformOffset, Arg: "1"},
+		{Op: inputs.TransformRegex, Arg: "("},
+		{Op: inputs.TransformRegex, Arg: "^nomatch$"},
+		{Op: inputs.TransformTemplate, Arg: "{{.Missing"},
+		{Op: "unknown"},
+	} {
+		_, err = inputs.InputTransform{step}.Apply(`{"main":{},"list":[1]}`)
+		assert.Error(t, err, "step %s %s", step.Op, step.Arg)
+	}
+	_, err = inputs.InputTransform{{Op: inputs.TransformJSON, Arg: "main"}}.Apply("not json")
+	assert.Error(t, err)
+}
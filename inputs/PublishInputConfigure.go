@@ -0,0 +1,56 @@
+// Package inputs with command to configure an input of a discovered domain node
+package inputs
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MakeInputConfigureAddress creates the address used to update the configuration of an input
+func MakeInputConfigureAddress(domain string, publisherID string, nodeID string,
+	inputType types.InputType, instance string) string {
+
+	address := fmt.Sprintf("%s/%s/%s/%s/%s/%s",
+		domain, publisherID, nodeID, inputType, instance, types.MessageTypeConfigure)
+	return address
+}
+
+// PublishInputConfigure sends a command to update the configuration of a remote input, eg the URL
+// or poll interval of an HTTP input. The signed message is encrypted with the given encryption key.
+// If no key is given then the key of the destination publisher is looked up.
+// Returns an error if the address is invalid or the command can't be encrypted.
+//  destinationAddress is the address of the input, eg its discovery address
+func PublishInputConfigure(
+	destinationAddress string, attr types.NodeAttrMap, sender string,
+	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) error {
+	return PublishInputConfigureWithID(destinationAddress, attr, lib.MakeMessageID(), sender, messageSigner, encryptionKey)
+}
+
+// PublishInputConfigureWithID sends an input configuration command with a message ID that requests the
+// receiving publisher to publish a $ack acknowledgement of the command. See also PublishInputConfigure.
+func PublishInputConfigureWithID(
+	destinationAddress string, attr types.NodeAttrMap, messageID string, sender string,
+	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) error {
+
+	logrus.Infof("PublishInputConfigure: publishing encrypted configuration to %s", destinationAddress)
+	destinationAddr, err := types.ParseAddress(destinationAddress)
+	// a full address is required: domain/publisherID/nodeID/type/instance/$configure
+	if err != nil || destinationAddr.IOType() == "" {
+		return lib.MakeErrorf("PublishInputConfigure: Destination address '%s' is incomplete", destinationAddress)
+	}
+	configAddr := destinationAddr.WithMessageType(types.MessageTypeConfigure).String()
+
+	var configureMessage = types.InputConfigureMessage{
+		Address:   configAddr,
+		Attr:      attr,
+		MessageID: messageID,
+		Sender:    sender,
+		Timestamp: lib.Now().Format(types.TimeFormat),
+	}
+	return messageSigner.PublishCommand(configAddr, &configureMessage, encryptionKey)
+}
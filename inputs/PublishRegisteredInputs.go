@@ -14,7 +14,7 @@ func PublishRegisteredInputs(
 
 	// publish updated registered inputs
 	for _, input := range inputs {
-		logrus.Infof("PublishRegisteredInputs: publish input discovery: %s", input.Address)
+		logrus.Infof("PublishRegisteredInputs: publish input discovery: %s, reasons: %v", input.Address, input.ChangeReasons)
 		// no encryption as this is for everyone to see
 		messageSigner.PublishObject(input.Address, true, input, nil)
 	}
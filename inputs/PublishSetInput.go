@@ -4,9 +4,9 @@ import (
 	"crypto/ecdsa"
 	"errors"
 	"fmt"
-	"strings"
 	"time"
 
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
@@ -14,35 +14,66 @@ import (
 
 // PublishSetInput sends a message to set the input value of a remote destination. The destination
 // is the full remote input address including domain and publisherID.
+//  The message carries a new message ID so the receiver can ignore duplicate deliveries.
 //  The message is signed by this publisher's key and encrypted with the destination public key.
 //  The sender is included in the message and used to verify this publisher's message signature.
-//  The messageSigner is used to encrypt the message using the encryption key from the destination publisher
+//  The messageSigner is used to encrypt the message using the encryption key from the destination publisher.
+//  If no encryption key is given then the key of the destination publisher is looked up.
+// Returns an error if the command can't be encrypted while encryption is required.
 func PublishSetInput(
 	destination string, value string, sender string,
 	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) error {
+	return PublishSetInputWithID(destination, value, lib.MakeMessageID(), sender, messageSigner, encryptionKey)
+}
+
+// PublishScheduledSetInput sends a message to set the input value of a remote destination at the
+// given time. The receiving publisher queues the command until its time has come, including across
+// restarts. The acknowledgement of the command is published when it is queued.
+// See also PublishSetInputWithID.
+func PublishScheduledSetInput(
+	destination string, value string, executeAt time.Time, messageID string, sender string,
+	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) error {
+	return publishSetInput(destination, value, executeAt.Format(types.TimeFormat), messageID, sender,
+		messageSigner, encryptionKey)
+}
+
+// PublishSetInputWithID sends a message to set the input value of a remote destination, with a message
+// ID that requests the receiving publisher to publish a $ack acknowledgement of the command.
+// See also PublishSetInput.
+func PublishSetInputWithID(
+	destination string, value string, messageID string, sender string,
+	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) error {
+	return publishSetInput(destination, value, "", messageID, sender, messageSigner, encryptionKey)
+}
+
+// publishSetInput sends a message to set the input value of a remote destination
+//  executeAt is the time to apply the command in TimeFormat, or "" to apply it immediately
+func publishSetInput(
+	destination string, value string, executeAt string, messageID string, sender string,
+	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) error {
 
 	// logger.Infof("PublishSetInput: publishing encrypted input %s to %s", value, remoteNodeInputAddress)
 	// encryptionKey := setInputs.getPublisherKey(remoteNodeInputAddress)
 	// Check that address is one of our inputs
-	segments := strings.Split(destination, "/")
+	destinationAddr, err := types.ParseAddress(destination)
 	// a full address is required
-	if len(segments) < 6 {
+	if err != nil || destinationAddr.IOType() == "" {
 		errText := fmt.Sprintf("PublishSetInput: Can't publish SetInput message as the destination address '%s' is incomplete", destination)
 		logrus.Error(errText)
 		return errors.New(errText)
 	}
 	// zone/pub/node/inputtype/instance/$set
-	segments[5] = types.MessageTypeSetInput
-	inputAddr := strings.Join(segments, "/")
+	inputAddr := destinationAddr.WithMessageType(types.MessageTypeSetInput).String()
 
 	// Encecode the SetMessage
-	timeStampStr := time.Now().Format("2006-01-02T15:04:05.000-0700")
+	timeStampStr := lib.Now().Format("2006-01-02T15:04:05.000-0700")
 	var setMessage = types.SetInputMessage{
 		Address:   inputAddr,
+		ExecuteAt: executeAt,
+		MessageID: messageID,
 		Sender:    sender,
 		Timestamp: timeStampStr,
 		Value:     value,
 	}
-	// setInputs.messageSigner.PublishObject(inputAddr, false, &setMessage, encryptionKey)
-	return messageSigner.PublishObject(inputAddr, false, &setMessage, encryptionKey)
+	return messageSigner.PublishCommand(inputAddr, &setMessage, encryptionKey)
 }
@@ -0,0 +1,299 @@
+// Package inputs with inputs from periodically running external commands
+package inputs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultExecTimeout is the default time a command is allowed to run, in seconds
+const DefaultExecTimeout = 10
+
+// DefaultExecMaxOutput is the default max nr of bytes of command output passed to the handler
+const DefaultExecMaxOutput = 64 * 1024
+
+// ExecInputOptions with the settings for running the command of an exec input
+type ExecInputOptions struct {
+	Dir          string   // working directory of the command. Default is the current directory
+	Env          []string // environment of the command as key=value. Only PATH is passed by default
+	InheritEnv   bool     // pass the environment of the publisher to the command in addition to Env
+	MaxOutput    int      // max nr of bytes of output. Default (0) is DefaultExecMaxOutput
+	PollInterval int      // interval in seconds to run the command
+	Timeout      int      // seconds after which the command is killed. Default (0) is DefaultExecTimeout
+}
+
+// ReceiveFromExec with inputs that periodically run a command and pass its output to the input handler.
+// Commands are run directly without a shell so the command line can't be used to inject other commands.
+type ReceiveFromExec struct {
+	cancel           context.CancelFunc          // cancels running commands on stop, nil when not running
+	commands         map[string][]string         // command and arguments of each input by inputID
+	isBusy           map[string]bool             // input command is running
+	options          map[string]ExecInputOptions // run options of each input by inputID
+	pollDelay        map[string]int              // seconds until the next run of each input
+	registeredInputs *RegisteredInputs           // inputs of this publisher
+	updateMutex      *sync.Mutex                 // mutex for async updating of inputs
+}
+
+// CreateExecInput creates a new input that periodically runs a command and passes its output to the
+// handler. The command runs with the default options and the given interval in seconds.
+// If an input of the given nodeID, type and instance already exists it will be replaced.
+// This returns an error if the command line is invalid.
+func (rxFromExec *ReceiveFromExec) CreateExecInput(
+	nodeHWID string, inputType types.InputType, instance string, cmdLine string, pollInterval int,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) (*types.InputDiscoveryMessage, error) {
+
+	options := ExecInputOptions{PollInterval: pollInterval}
+	return rxFromExec.CreateExecInputWithOptions(nodeHWID, inputType, instance, cmdLine, options, handler)
+}
+
+// CreateExecInputWithOptions creates a new input that periodically runs a command using the given
+// options for its environment, working directory, timeout and output size.
+// If an input of the given nodeID, type and instance already exists it will be replaced.
+// This returns an error if the command line is invalid.
+func (rxFromExec *ReceiveFromExec) CreateExecInputWithOptions(
+	nodeHWID string, inputType types.InputType, instance string, cmdLine string, options ExecInputOptions,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) (*types.InputDiscoveryMessage, error) {
+
+	command, err := SplitCommandLine(cmdLine)
+	if err != nil {
+		return nil, err
+	}
+	inputID := MakeInputHWID(nodeHWID, inputType, instance)
+	input := rxFromExec.registeredInputs.CreateInputWithSource(nodeHWID, inputType, instance, cmdLine, handler)
+	input.Attr[types.NodeAttrPollInterval] = strconv.Itoa(options.PollInterval)
+	rxFromExec.registeredInputs.UpdateInput(input)
+
+	rxFromExec.updateMutex.Lock()
+	defer rxFromExec.updateMutex.Unlock()
+	rxFromExec.commands[inputID] = command
+	rxFromExec.options[inputID] = options
+	delete(rxFromExec.pollDelay, inputID)
+	return input, nil
+}
+
+// DeleteInput deletes the input and stops running its command
+func (rxFromExec *ReceiveFromExec) DeleteInput(inputID string) {
+	rxFromExec.updateMutex.Lock()
+	defer rxFromExec.updateMutex.Unlock()
+	if _, found := rxFromExec.commands[inputID]; !found {
+		logrus.Errorf("DeleteInput: input %s not found", inputID)
+		return
+	}
+	delete(rxFromExec.commands, inputID)
+	delete(rxFromExec.options, inputID)
+	delete(rxFromExec.pollDelay, inputID)
+	rxFromExec.registeredInputs.DeleteInput(inputID)
+}
+
+// HasInput returns true if the input with the given ID runs a command
+func (rxFromExec *ReceiveFromExec) HasInput(inputID string) bool {
+	rxFromExec.updateMutex.Lock()
+	defer rxFromExec.updateMutex.Unlock()
+	_, found := rxFromExec.commands[inputID]
+	return found
+}
+
+// Start running the input commands at their interval
+func (rxFromExec *ReceiveFromExec) Start() {
+	rxFromExec.updateMutex.Lock()
+	defer rxFromExec.updateMutex.Unlock()
+	if rxFromExec.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rxFromExec.cancel = cancel
+	go rxFromExec.pollLoop(ctx)
+}
+
+// Stop running the input commands. Running commands are killed.
+func (rxFromExec *ReceiveFromExec) Stop() {
+	rxFromExec.updateMutex.Lock()
+	defer rxFromExec.updateMutex.Unlock()
+	if rxFromExec.cancel != nil {
+		rxFromExec.cancel()
+		rxFromExec.cancel = nil
+	}
+}
+
+// pollInputs runs the commands whose interval has passed. Called each second.
+// A command is not started again while its previous run hasn't finished.
+func (rxFromExec *ReceiveFromExec) pollInputs(ctx context.Context) {
+	rxFromExec.updateMutex.Lock()
+	defer rxFromExec.updateMutex.Unlock()
+	for inputID, command := range rxFromExec.commands {
+		options := rxFromExec.options[inputID]
+		pollDelay := rxFromExec.pollDelay[inputID]
+		if pollDelay <= 0 && !rxFromExec.isBusy[inputID] {
+			pollDelay = options.PollInterval
+			rxFromExec.isBusy[inputID] = true
+			go rxFromExec.runAndNotify(ctx, inputID, command, options)
+		}
+		rxFromExec.pollDelay[inputID] = pollDelay - 1
+	}
+}
+
+// pollLoop runs the input commands at their interval until the context is cancelled
+func (rxFromExec *ReceiveFromExec) pollLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	rxFromExec.pollInputs(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rxFromExec.pollInputs(ctx)
+		}
+	}
+}
+
+// runAndNotify runs the command of an input and passes its output to the input handler
+func (rxFromExec *ReceiveFromExec) runAndNotify(
+	ctx context.Context, inputID string, command []string, options ExecInputOptions) {
+
+	output, err := RunExecCommand(ctx, command, options)
+	rxFromExec.updateMutex.Lock()
+	delete(rxFromExec.isBusy, inputID)
+	rxFromExec.updateMutex.Unlock()
+	if err != nil {
+		logrus.Warningf("ReceiveFromExec.runAndNotify: Input %s: %s", inputID, err)
+		return
+	} else if output != "" {
+		rxFromExec.registeredInputs.NotifyInputHandler(inputID, "", output)
+	}
+}
+
+// RunExecCommand runs a command with the given options and returns its output without trailing
+// whitespace. The command is killed when its timeout has passed or the context is cancelled.
+// This returns an error if the command fails or exits with a non-zero status.
+func RunExecCommand(ctx context.Context, command []string, options ExecInputOptions) (string, error) {
+	if len(command) == 0 {
+		return "", lib.MakeErrorf("RunExecCommand: Missing command")
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExecTimeout
+	}
+	maxOutput := options.MaxOutput
+	if maxOutput <= 0 {
+		maxOutput = DefaultExecMaxOutput
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = options.Dir
+	if options.InheritEnv {
+		cmd.Env = append(os.Environ(), options.Env...)
+	} else {
+		cmd.Env = append([]string{"PATH=" + os.Getenv("PATH")}, options.Env...)
+	}
+	stdout := &limitedBuffer{limit: maxOutput}
+	stderr := &limitedBuffer{limit: 1024}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", lib.MakeErrorf("RunExecCommand: '%s' timed out after %d seconds", command[0], timeout)
+	} else if err != nil {
+		return "", lib.MakeErrorf("RunExecCommand: '%s' failed: %s %s",
+			command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(stdout.String(), " \t\r\n"), nil
+}
+
+// SplitCommandLine splits a command line into the command and its arguments. Arguments are separated
+// by whitespace. Single or double quotes group an argument that contains whitespace, and a backslash
+// escapes the next character outside single quotes.
+func SplitCommandLine(cmdLine string) ([]string, error) {
+	args := make([]string, 0)
+	arg := strings.Builder{}
+	inArg := false
+	quote := rune(0)
+	isEscaped := false
+	for _, c := range cmdLine {
+		if isEscaped {
+			arg.WriteRune(c)
+			isEscaped = false
+		} else if c == '\\' && quote != '\'' {
+			isEscaped = true
+			inArg = true
+		} else if quote != 0 {
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		} else if c == '\'' || c == '"' {
+			quote = c
+			inArg = true
+		} else if c == ' ' || c == '\t' || c == '\n' {
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		} else {
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 || isEscaped {
+		return nil, lib.MakeErrorf("SplitCommandLine: Unterminated quote or escape in '%s'", cmdLine)
+	} else if inArg {
+		args = append(args, arg.String())
+	}
+	if len(args) == 0 {
+		return nil, lib.MakeErrorf("SplitCommandLine: Missing command")
+	}
+	return args, nil
+}
+
+// limitedBuffer is a buffer that discards writes beyond its limit
+type limitedBuffer struct {
+	buffer bytes.Buffer
+	limit  int
+}
+
+// String returns the buffered content
+func (lb *limitedBuffer) String() string {
+	return lb.buffer.String()
+}
+
+// Write the part of p that fits within the limit. All of p is reported as written so the command
+// isn't interrupted by a write error.
+func (lb *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := lb.limit - lb.buffer.Len()
+	if remaining > 0 {
+		if len(p) > remaining {
+			lb.buffer.Write(p[:remaining])
+		} else {
+			lb.buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// NewReceiveFromExec creates a new instance of command based inputs
+// Inputs must be created through CreateExecInput
+func NewReceiveFromExec(registeredInputs *RegisteredInputs) *ReceiveFromExec {
+	rxFromExec := &ReceiveFromExec{
+		commands:         make(map[string][]string),
+		isBusy:           make(map[string]bool),
+		options:          make(map[string]ExecInputOptions),
+		pollDelay:        make(map[string]int),
+		registeredInputs: registeredInputs,
+		updateMutex:      &sync.Mutex{},
+	}
+	return rxFromExec
+}
@@ -0,0 +1,75 @@
+package inputs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateInputFromExec(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "pub1"
+	const node1ID = "node1"
+	received := make(chan string, 10)
+	handler := func(input *types.InputDiscoveryMessage, sender string, value string) {
+		received <- value
+	}
+	regInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+	i := inputs.NewReceiveFromExec(regInputs)
+	input, err := i.CreateExecInput(node1ID, types.InputTypeValue, "1", `echo "hello  world"`, 10, handler)
+	require.NoError(t, err)
+	assert.Equal(t, `echo "hello  world"`, input.Source)
+	_, err = i.CreateExecInput(node1ID, types.InputTypeValue, "2", `echo "unterminated`, 10, handler)
+	assert.Error(t, err)
+
+	i.Start()
+	select {
+	case value := <-received:
+		assert.Equal(t, "hello  world", value)
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "command output not received")
+	}
+	i.Stop()
+	i.DeleteInput(input.InputID)
+	assert.Nil(t, regInputs.GetInputByID(input.InputID))
+}
+
+func TestRunExecCommand(t *testing.T) {
+	ctx := context.Background()
+	// only PATH and the given variables are passed to the command
+	options := inputs.ExecInputOptions{Env: []string{"GREETING=hi"}}
+	output, err := inputs.RunExecCommand(ctx, []string{"sh", "-c", "echo $GREETING $HOME"}, options)
+	require.NoError(t, err)
+	assert.Equal(t, "hi", output)
+
+	// output is limited
+	options = inputs.ExecInputOptions{MaxOutput: 4}
+	output, err = inputs.RunExecCommand(ctx, []string{"echo", "1234567"}, options)
+	require.NoError(t, err)
+	assert.Equal(t, "1234", output)
+
+	// failures and timeouts are errors
+	_, err = inputs.RunExecCommand(ctx, []string{"sh", "-c", "exit 1"}, options)
+	assert.Error(t, err)
+	options = inputs.ExecInputOptions{Timeout: 1}
+	_, err = inputs.RunExecCommand(ctx, []string{"sleep", "5"}, options)
+	assert.Error(t, err)
+	_, err = inputs.RunExecCommand(ctx, []string{}, options)
+	assert.Error(t, err)
+}
+
+func TestSplitCommandLine(t *testing.T) {
+	args, err := inputs.SplitCommandLine(`smartctl -A  "/dev/disk 1" 'a\b' c\ d`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"smartctl", "-A", "/dev/disk 1", `a\b`, "c d"}, args)
+	args, err = inputs.SplitCommandLine(`echo ""`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"echo", ""}, args)
+	_, err = inputs.SplitCommandLine("  ")
+	assert.Error(t, err)
+}
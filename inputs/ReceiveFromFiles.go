@@ -2,6 +2,10 @@
 package inputs
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
 	"os/user"
 	"path/filepath"
 	"strings"
@@ -14,9 +18,17 @@ import (
 
 // ReceiveFromFiles receives updates from file watchers of inputs that listens for changes in files
 // This supports multiple watchers for the same file.
+//
+// The source of an input can be a glob pattern, eg /var/log/app/*.log, in which case the folder
+// of the pattern is watched. Tailed inputs receive the lines that are appended to the files instead
+// of the file path. Tailing follows rotated files, tail -F style: a file that is recreated or
+// truncated is read from the start.
 type ReceiveFromFiles struct {
 	isRunning        bool
 	registeredInputs *RegisteredInputs // registered inputs of this publisher
+	sources          map[string]string // file path or glob pattern by inputID
+	tailInputs       map[string]bool   // inputs that receive appended lines by inputID
+	tailOffsets      map[string]int64  // read offset of tailed files by path
 	updateMutex      *sync.Mutex       // mutex for async updating of inputs
 	watcher          *fsnotify.Watcher // the watcher to monitor files
 }
@@ -38,7 +50,7 @@ func (iffile *ReceiveFromFiles) CreateInput(
 		return existingInput
 	}
 	// use full path as this is what the watcher uses in file change notification
-	fullPath := iffile.watchFile(path)
+	fullPath := iffile.watchFile(path, false)
 
 	if fullPath == "" {
 		inputID := MakeInputHWID(nodeHWID, inputType, instance)
@@ -47,10 +59,50 @@ func (iffile *ReceiveFromFiles) CreateInput(
 	}
 	input := iffile.registeredInputs.CreateInputWithSource(
 		nodeHWID, inputType, instance, fullPath, handler)
+	iffile.sources[input.InputID] = fullPath
 
 	return input
 }
 
+// CreateTailInput creates an input that receives the lines that are appended to a file, like
+// tail -F. The handler is invoked for each new line with the file path as sender. Content that
+// exists when creating the input is skipped. Rotated and truncated files are read from the start.
+//  pattern is the file path or a glob pattern of files in a single folder, eg /var/log/app/*.log
+// If the input already exists, the existing input is returned.
+func (iffile *ReceiveFromFiles) CreateTailInput(
+	nodeHWID string, inputType types.InputType, instance string,
+	pattern string, handler func(input *types.InputDiscoveryMessage, sender string, line string)) *types.InputDiscoveryMessage {
+
+	iffile.updateMutex.Lock()
+	defer iffile.updateMutex.Unlock()
+
+	existingInput := iffile.registeredInputs.GetInputByNodeHWID(nodeHWID, inputType, instance)
+	if existingInput != nil {
+		logrus.Errorf("CreateTailInput: Input %s already exists. Ignored.", existingInput.InputID)
+		return existingInput
+	}
+	fullPattern := iffile.watchFile(pattern, true)
+	if fullPattern == "" {
+		inputID := MakeInputHWID(nodeHWID, inputType, instance)
+		logrus.Errorf("CreateTailInput: Source '%s' for input '%s' is invalid. Ignored.", pattern, inputID)
+		return nil
+	}
+	// only new lines are delivered
+	matches, _ := filepath.Glob(fullPattern)
+	for _, filePath := range matches {
+		if _, isTailed := iffile.tailOffsets[filePath]; !isTailed {
+			if info, err := os.Stat(filePath); err == nil {
+				iffile.tailOffsets[filePath] = info.Size()
+			}
+		}
+	}
+	input := iffile.registeredInputs.CreateInputWithSource(
+		nodeHWID, inputType, instance, fullPattern, handler)
+	iffile.sources[input.InputID] = fullPattern
+	iffile.tailInputs[input.InputID] = true
+	return input
+}
+
 // DeleteInput deletes the input and unsubscribes from the file watcher
 func (iffile *ReceiveFromFiles) DeleteInput(nodeHWID string, inputType types.InputType, instance string) {
 	iffile.updateMutex.Lock()
@@ -66,18 +118,35 @@ func (iffile *ReceiveFromFiles) DeleteInput(nodeHWID string, inputType types.Inp
 	if fullPath == "" {
 		// not file path, just delete the input
 		logrus.Errorf("DeleteInput: input %s does not have a source path", inputID)
+		iffile.updateMutex.Lock()
+		delete(iffile.sources, inputID)
+		delete(iffile.tailInputs, inputID)
+		iffile.updateMutex.Unlock()
 		iffile.registeredInputs.DeleteInput(inputID)
 		return
 	}
 
 	// now we have a valid path, remove it from the watcher
-	err := iffile.watcher.Remove(fullPath)
+	iffile.updateMutex.Lock()
+	watchPath := makeWatchPath(fullPath, iffile.tailInputs[inputID])
+	delete(iffile.sources, inputID)
+	delete(iffile.tailInputs, inputID)
+	iffile.updateMutex.Unlock()
+	err := iffile.watcher.Remove(watchPath)
 	if err != nil {
-		logrus.Errorf("DeleteInput: error removing full path %s from file watcher: %s", fullPath, err)
+		logrus.Errorf("DeleteInput: error removing full path %s from file watcher: %s", watchPath, err)
 	}
 	iffile.registeredInputs.DeleteInput(inputID)
 }
 
+// HasInput returns true if the input with the given ID receives file changes
+func (iffile *ReceiveFromFiles) HasInput(inputID string) bool {
+	iffile.updateMutex.Lock()
+	defer iffile.updateMutex.Unlock()
+	_, found := iffile.sources[inputID]
+	return found
+}
+
 // Start listening for file changes
 func (iffile *ReceiveFromFiles) Start() {
 	iffile.isRunning = true
@@ -90,20 +159,89 @@ func (iffile *ReceiveFromFiles) Stop() {
 }
 
 // Invoked by a file watcher when a file changes
-// This looks up the corresponding input(s) and notifies their subscriber
-func (iffile *ReceiveFromFiles) onFileWatcherEvent(fullPath string) {
-	sourceInputs := iffile.registeredInputs.GetInputsWithSource(fullPath)
-	for _, input := range sourceInputs {
-		inputID := MakeInputHWID(input.NodeHWID, input.InputType, input.Instance)
-		iffile.registeredInputs.NotifyInputHandler(inputID, "", fullPath)
+// This looks up the corresponding input(s) and notifies their subscriber. Inputs that tail the file
+// are notified of each appended line.
+func (iffile *ReceiveFromFiles) onFileWatcherEvent(event fsnotify.Event) {
+	fullPath := event.Name
+	isWritten := event.Op&fsnotify.Write == fsnotify.Write
+	isCreated := event.Op&fsnotify.Create == fsnotify.Create
+	pathInputIDs := make([]string, 0)
+	tailInputIDs := make([]string, 0)
+
+	iffile.updateMutex.Lock()
+	for inputID, source := range iffile.sources {
+		if isMatch, _ := filepath.Match(source, fullPath); !isMatch {
+			continue
+		} else if iffile.tailInputs[inputID] {
+			tailInputIDs = append(tailInputIDs, inputID)
+		} else {
+			pathInputIDs = append(pathInputIDs, inputID)
+		}
+	}
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		delete(iffile.tailOffsets, fullPath)
+	}
+	iffile.updateMutex.Unlock()
+
+	if isWritten {
+		for _, inputID := range pathInputIDs {
+			iffile.registeredInputs.NotifyInputHandler(inputID, "", fullPath)
+		}
+	}
+	if (isWritten || isCreated) && len(tailInputIDs) > 0 {
+		for _, line := range iffile.readNewLines(fullPath, isCreated) {
+			for _, inputID := range tailInputIDs {
+				iffile.registeredInputs.NotifyInputHandler(inputID, fullPath, line)
+			}
+		}
 	}
 }
 
+// readNewLines returns the complete lines that are appended to a file since the previous read.
+// A partial last line is read when it is completed.
+//  fromStart reads the file from the start, eg when it is recreated after rotation
+func (iffile *ReceiveFromFiles) readNewLines(fullPath string, fromStart bool) []string {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		logrus.Warningf("readNewLines: Unable to open file %s: %s", fullPath, err)
+		return nil
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil
+	}
+	iffile.updateMutex.Lock()
+	defer iffile.updateMutex.Unlock()
+	offset := iffile.tailOffsets[fullPath]
+	if fromStart || info.Size() < offset {
+		// the file is rotated or truncated
+		offset = 0
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(file, info.Size()-offset))
+	end := bytes.LastIndexByte(data, '\n')
+	if err != nil || end < 0 {
+		iffile.tailOffsets[fullPath] = offset
+		return nil
+	}
+	iffile.tailOffsets[fullPath] = offset + int64(end) + 1
+	lines := strings.Split(string(data[:end]), "\n")
+	for index, line := range lines {
+		lines[index] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
 // SubscribeToFile subscribes to changes in file
 // If filename starts with ~ then the current user home directory is used
 // If filename contains . or .. then it will be cleaned
+// Glob patterns and tailed files are watched through their folder, so new and rotated files are noticed.
 // Returns the actual pathname or "" if the path is invalid
-func (iffile *ReceiveFromFiles) watchFile(fileName string) string {
+func (iffile *ReceiveFromFiles) watchFile(fileName string, isTail bool) string {
 	newPath := fileName
 	var err error
 	// Replace ~ prefix with the user home directory
@@ -114,7 +252,10 @@ func (iffile *ReceiveFromFiles) watchFile(fileName string) string {
 	} else if strings.HasPrefix(fileName, ".") {
 		newPath, err = filepath.Abs(fileName)
 	}
-	err = iffile.watcher.Add(newPath)
+	watchPath := makeWatchPath(newPath, isTail)
+	if _, err = filepath.Match(newPath, ""); err == nil {
+		err = iffile.watcher.Add(watchPath)
+	}
 	if err != nil {
 		logrus.Errorf("SubscribeToFile: File ignored. Unable to watch file %s: %s", newPath, err)
 		return ""
@@ -131,10 +272,7 @@ func (iffile *ReceiveFromFiles) watcherLoop() {
 				return
 			}
 			logrus.Infof("watcherLoop:event: %s", event)
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				logrus.Infof("watcherLoop:modified file %s", event.Name)
-				iffile.onFileWatcherEvent(event.Name)
-			}
+			iffile.onFileWatcherEvent(event)
 		case err, ok := <-iffile.watcher.Errors:
 			if !ok {
 				return
@@ -149,8 +287,20 @@ func NewReceiveFromFiles(regInputs *RegisteredInputs) *ReceiveFromFiles {
 	watcher, _ := fsnotify.NewWatcher()
 	fil := &ReceiveFromFiles{
 		registeredInputs: regInputs,
+		sources:          make(map[string]string),
+		tailInputs:       make(map[string]bool),
+		tailOffsets:      make(map[string]int64),
 		updateMutex:      &sync.Mutex{},
 		watcher:          watcher,
 	}
 	return fil
 }
+
+// makeWatchPath returns the path to add to the file watcher for the source of an input. Glob
+// patterns and tailed files are watched through their folder.
+func makeWatchPath(source string, isTail bool) string {
+	if isTail || strings.ContainsAny(source, "*?[") {
+		return filepath.Dir(source)
+	}
+	return source
+}
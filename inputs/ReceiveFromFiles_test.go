@@ -2,12 +2,16 @@ package inputs_test
 
 import (
 	"io/ioutil"
+	"os"
+	"path"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/iotdomain/iotdomain-go/inputs"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestReceiveFromFile(t *testing.T) {
@@ -75,3 +79,56 @@ func TestReceiveFromFile(t *testing.T) {
 	iff.Stop()
 
 }
+
+func TestReceiveFromFileTail(t *testing.T) {
+	const node1_HWID = "node1"
+	const inputType = types.InputTypeValue
+	logFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(logFolder)
+	logFile := path.Join(logFolder, "app.log")
+	err = ioutil.WriteFile(logFile, []byte("existing line\n"), 0644)
+	require.NoError(t, err)
+
+	lines := make([]string, 0)
+	linesMutex := sync.Mutex{}
+	handler := func(input *types.InputDiscoveryMessage, sender string, line string) {
+		linesMutex.Lock()
+		defer linesMutex.Unlock()
+		lines = append(lines, line)
+	}
+	getLines := func() []string {
+		linesMutex.Lock()
+		defer linesMutex.Unlock()
+		return append([]string(nil), lines...)
+	}
+	regInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+	iff := inputs.NewReceiveFromFiles(regInputs)
+	iff.Start()
+	defer iff.Stop()
+	input := iff.CreateTailInput(node1_HWID, inputType, types.DefaultInputInstance, path.Join(logFolder, "*.log"), handler)
+	require.NotNil(t, input)
+
+	// only appended complete lines are delivered
+	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	file.WriteString("line 1\nline 2\npartial")
+	file.Close()
+	time.Sleep(time.Millisecond * 300)
+	assert.Equal(t, []string{"line 1", "line 2"}, getLines())
+
+	// a rotated file is read from the start
+	os.Rename(logFile, logFile+".1")
+	ioutil.WriteFile(logFile, []byte("rotated\n"), 0644)
+	time.Sleep(time.Millisecond * 300)
+	assert.Equal(t, []string{"line 1", "line 2", "rotated"}, getLines())
+
+	// other files matching the pattern are tailed as well
+	ioutil.WriteFile(path.Join(logFolder, "other.log"), []byte("other\n"), 0644)
+	ioutil.WriteFile(path.Join(logFolder, "ignored.txt"), []byte("ignored\n"), 0644)
+	time.Sleep(time.Millisecond * 300)
+	assert.Equal(t, []string{"line 1", "line 2", "rotated", "other"}, getLines())
+
+	iff.DeleteInput(node1_HWID, inputType, types.DefaultInputInstance)
+	assert.Nil(t, regInputs.GetInputByID(input.InputID))
+}
@@ -2,6 +2,8 @@
 package inputs
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -10,19 +12,40 @@ import (
 	"sync"
 	"time"
 
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
 )
 
+// DefaultHTTPTimeout is the default timeout of polling an HTTP input, in seconds
+const DefaultHTTPTimeout = 30
+
+// HTTPInputOptions with the request settings of an HTTP input
+type HTTPInputOptions struct {
+	AcceptStatus []int             // status codes besides 2xx whose response is passed to the handler, eg 404
+	BearerToken  string            // token for bearer authentication
+	CACert       string            // path of the PEM file with CA certificates to verify the server. Default uses the system CAs
+	ClientCert   string            // path of the PEM file with the TLS client certificate
+	ClientKey    string            // path of the PEM file with the TLS client key
+	Headers      map[string]string // additional request headers
+	Login        string            // login name for basic authentication
+	MaxRedirects int               // max nr of redirects to follow, -1 to not follow. Default (0) follows up to 10
+	Password     string            // password for basic authentication
+	PollInterval int               // interval in seconds to poll the URL
+	Timeout      int               // request timeout in seconds. Default (0) is DefaultHTTPTimeout
+}
+
 // ReceiveFromHTTP with inputs to periodically poll HTTP
 // Only a single handler per URL can be used.
 type ReceiveFromHTTP struct {
-	isRunning        bool              // flag, polling is active
-	pollDelay        map[string]int    // seconds until next poll for each input
-	pollInterval     int               // default poll interval
-	registeredInputs *RegisteredInputs // inputs of this publisher
-	subscriptions    map[string]string // http subscriptions of inputs [inputID]source
-	updateMutex      *sync.Mutex       // mutex for async updating of inputs
+	clients          map[string]*http.Client     // http client of each input by inputID
+	isRunning        bool                        // flag, polling is active
+	options          map[string]HTTPInputOptions // request options of each input by inputID
+	pollDelay        map[string]int              // seconds until next poll for each input
+	pollInterval     int                         // default poll interval
+	registeredInputs *RegisteredInputs           // inputs of this publisher
+	subscriptions    map[string]string           // http subscriptions of inputs [inputID]source
+	updateMutex      *sync.Mutex                 // mutex for async updating of inputs
 }
 
 // CreateHTTPInput creates a new input that periodically polls a URL address. If a login and password
@@ -34,19 +57,43 @@ func (rxFromHttp *ReceiveFromHTTP) CreateHTTPInput(
 	url string, login string, password string, pollInterval int,
 	handler func(input *types.InputDiscoveryMessage, sender string, path string)) *types.InputDiscoveryMessage {
 
+	options := HTTPInputOptions{Login: login, Password: password, PollInterval: pollInterval}
+	input, _ := rxFromHttp.CreateHTTPInputWithOptions(nodeHWID, inputType, instance, url, options, handler)
+	return input
+}
+
+// CreateHTTPInputWithOptions creates a new input that periodically polls a URL address using the
+// given request options for authentication, headers, TLS client certificates and redirects.
+// If an input of the given nodeID, type and instance already exists it will be replaced.
+// This returns an error if the TLS certificates cannot be loaded.
+func (rxFromHttp *ReceiveFromHTTP) CreateHTTPInputWithOptions(
+	nodeHWID string, inputType types.InputType, instance string, url string, options HTTPInputOptions,
+	handler func(input *types.InputDiscoveryMessage, sender string, path string)) (*types.InputDiscoveryMessage, error) {
+
+	client, err := NewHTTPInputClient(options)
+	if err != nil {
+		return nil, err
+	}
 	inputID := MakeInputHWID(nodeHWID, inputType, instance)
 	// create the input then add it to the list of addresses to poll
 	input := rxFromHttp.registeredInputs.CreateInputWithSource(nodeHWID, inputType, instance, url, handler)
 	input.Attr[types.NodeAttrURL] = url
-	input.Attr[types.NodeAttrPollInterval] = strconv.Itoa(pollInterval)
-	input.Attr[types.NodeAttrLoginName] = login
-	input.Attr[types.NodeAttrPassword] = password
+	input.Attr[types.NodeAttrPollInterval] = strconv.Itoa(options.PollInterval)
+	input.Attr[types.NodeAttrLoginName] = options.Login
+	input.Attr[types.NodeAttrPassword] = options.Password
+	// the URL and poll interval can be changed with an input configure command
+	input.Config[types.NodeAttrURL] = types.ConfigAttr{
+		DataType: types.DataTypeString, Default: url, Description: "URL to poll"}
+	input.Config[types.NodeAttrPollInterval] = types.ConfigAttr{
+		DataType: types.DataTypeInt, Default: strconv.Itoa(options.PollInterval), Description: "Poll interval in seconds", Min: 1}
 	rxFromHttp.registeredInputs.UpdateInput(input)
 
 	rxFromHttp.updateMutex.Lock()
 	defer rxFromHttp.updateMutex.Unlock()
+	rxFromHttp.clients[inputID] = client
+	rxFromHttp.options[inputID] = options
 	rxFromHttp.subscriptions[inputID] = url
-	return input
+	return input, nil
 }
 
 // Start polling inputs for changes
@@ -69,34 +116,53 @@ func (rxFromHttp *ReceiveFromHTTP) DeleteInput(inputID string) {
 		logrus.Errorf("DeleteInput: input %s not found", inputID)
 		return
 	}
+	delete(rxFromHttp.clients, inputID)
+	delete(rxFromHttp.options, inputID)
 	delete(rxFromHttp.subscriptions, inputID)
 	rxFromHttp.registeredInputs.DeleteInput(inputID)
 }
 
+// HasInput returns true if the input with the given ID polls an http address
+func (rxFromHttp *ReceiveFromHTTP) HasInput(inputID string) bool {
+	rxFromHttp.updateMutex.Lock()
+	defer rxFromHttp.updateMutex.Unlock()
+	_, found := rxFromHttp.clients[inputID]
+	return found
+}
+
 // Send a request to the URL and read the response
-// This supports basic authentication
+// This supports basic and bearer authentication and additional headers
 func (rxFromHttp *ReceiveFromHTTP) readInput(input *types.InputDiscoveryMessage) (string, error) {
 	var err error
-	url := input.Source
+	url := input.Attr[types.NodeAttrURL]
+	if url == "" {
+		url = input.Source
+	}
 
 	logrus.Debugf("InputFromHTTP.readInput: Reading from URL %s", url)
 	startTime := time.Now()
-	var req *http.Request
-	var resp *http.Response
+	rxFromHttp.updateMutex.Lock()
+	client := rxFromHttp.clients[input.InputID]
+	options := rxFromHttp.options[input.InputID]
+	rxFromHttp.updateMutex.Unlock()
+	if client == nil {
+		client = &http.Client{Timeout: DefaultHTTPTimeout * time.Second}
+	}
 	var loginName = input.Attr[types.NodeAttrLoginName]
 	var password = input.Attr[types.NodeAttrPassword]
 
-	if loginName == "" {
-		// No auth
-		resp, err = http.Get(url)
-	} else {
-		// basic auth
-		client := &http.Client{}
-		req, err = http.NewRequest("GET", url, nil)
-		if err == nil {
+	req, err := http.NewRequest("GET", url, nil)
+	var resp *http.Response
+	if err == nil {
+		for name, value := range options.Headers {
+			req.Header.Set(name, value)
+		}
+		if options.BearerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+options.BearerToken)
+		} else if loginName != "" {
 			req.SetBasicAuth(loginName, password)
-			resp, err = client.Do(req)
 		}
+		resp, err = client.Do(req)
 	}
 	// handle failure to load the image
 	if err != nil {
@@ -105,7 +171,7 @@ func (rxFromHttp *ReceiveFromHTTP) readInput(input *types.InputDiscoveryMessage)
 	}
 	defer resp.Body.Close()
 	// was it a good response?
-	if resp.StatusCode > 299 {
+	if resp.StatusCode > 299 && !isAcceptedStatus(resp.StatusCode, options.AcceptStatus) {
 		msg := fmt.Sprintf("InputFromHTTP.readInput: Failed opening URL %s: %s", url, resp.Status)
 		err := errors.New(msg)
 		return "", err
@@ -164,6 +230,8 @@ func (rxFromHttp *ReceiveFromHTTP) pollLoop() {
 func NewReceiveFromHTTP(registeredInputs *RegisteredInputs) *ReceiveFromHTTP {
 
 	httpInput := &ReceiveFromHTTP{
+		clients:          make(map[string]*http.Client),
+		options:          make(map[string]HTTPInputOptions),
 		pollDelay:        make(map[string]int),
 		pollInterval:     3600,
 		registeredInputs: registeredInputs,
@@ -172,3 +240,54 @@ func NewReceiveFromHTTP(registeredInputs *RegisteredInputs) *ReceiveFromHTTP {
 	}
 	return httpInput
 }
+
+// NewHTTPInputClient creates the http client for polling an input with the given options
+// This returns an error if the TLS certificates cannot be loaded.
+func NewHTTPInputClient(options HTTPInputOptions) (*http.Client, error) {
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	if options.MaxRedirects != 0 {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) > options.MaxRedirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		}
+	}
+	if options.CACert == "" && options.ClientCert == "" {
+		return client, nil
+	}
+	tlsConfig := &tls.Config{}
+	if options.CACert != "" {
+		caPEM, err := ioutil.ReadFile(options.CACert)
+		if err != nil {
+			return nil, lib.MakeErrorf("NewHTTPInputClient: Unable to read CA certificate: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, lib.MakeErrorf("NewHTTPInputClient: No certificates found in %s", options.CACert)
+		}
+	}
+	if options.ClientCert != "" {
+		clientCert, err := tls.LoadX509KeyPair(options.ClientCert, options.ClientKey)
+		if err != nil {
+			return nil, lib.MakeErrorf("NewHTTPInputClient: Unable to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	client.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}
+	return client, nil
+}
+
+// isAcceptedStatus returns true if the status code is in the list of accepted codes
+func isAcceptedStatus(statusCode int, acceptStatus []int) bool {
+	for _, accepted := range acceptStatus {
+		if accepted == statusCode {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,18 @@
 package inputs_test
 
 import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/iotdomain/iotdomain-go/inputs"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateInputFromHttp(t *testing.T) {
@@ -54,3 +60,80 @@ func TestCreateInputFromHttp(t *testing.T) {
 
 	i.Stop()
 }
+
+func TestCreateInputFromHttpWithOptions(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "pub1"
+	const node1ID = "node1"
+	const token = "secret-token"
+	received := make(chan string, 10)
+	handler := func(input *types.InputDiscoveryMessage, sender string, value string) {
+		received <- value
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/data", http.StatusFound)
+		} else if r.Header.Get("Authorization") != "Bearer "+token || r.Header.Get("X-Api-Key") != "key1" {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("not found"))
+		} else {
+			w.Write([]byte("data"))
+		}
+	}))
+	defer server.Close()
+	caFile, err := ioutil.TempFile("", "iotdomain-ca")
+	require.NoError(t, err)
+	defer os.Remove(caFile.Name())
+	pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	caFile.Close()
+
+	regInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+	i := inputs.NewReceiveFromHTTP(regInputs)
+	options := inputs.HTTPInputOptions{
+		AcceptStatus: []int{http.StatusNotFound},
+		BearerToken:  token,
+		CACert:       caFile.Name(),
+		Headers:      map[string]string{"X-Api-Key": "key1"},
+		PollInterval: 10,
+		Timeout:      5,
+	}
+	_, err = i.CreateHTTPInputWithOptions(node1ID, types.InputTypeValue, "1", server.URL+"/data", options, handler)
+	require.NoError(t, err)
+	_, err = i.CreateHTTPInputWithOptions(node1ID, types.InputTypeValue, "2", server.URL+"/missing", options, handler)
+	require.NoError(t, err)
+	i.Start()
+	defer i.Stop()
+	results := make([]string, 0)
+	for len(results) < 2 {
+		select {
+		case value := <-received:
+			results = append(results, value)
+		case <-time.After(3 * time.Second):
+			require.Fail(t, "No input received")
+		}
+	}
+	assert.ElementsMatch(t, []string{"data", "not found"}, results)
+
+	// redirects are followed unless disabled
+	client, err := inputs.NewHTTPInputClient(options)
+	require.NoError(t, err)
+	resp, err := client.Get(server.URL + "/redirect")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "/data", resp.Request.URL.Path)
+	options.MaxRedirects = -1
+	client, _ = inputs.NewHTTPInputClient(options)
+	resp, err = client.Get(server.URL + "/redirect")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+	// invalid certificates
+	_, err = i.CreateHTTPInputWithOptions(node1ID, types.InputTypeValue, "3", server.URL,
+		inputs.HTTPInputOptions{CACert: "/doesnt/exist.pem"}, handler)
+	assert.Error(t, err)
+	_, err = inputs.NewHTTPInputClient(inputs.HTTPInputOptions{ClientCert: caFile.Name(), ClientKey: caFile.Name()})
+	assert.Error(t, err)
+}
@@ -2,22 +2,52 @@
 package inputs
 
 import (
+	"math"
+	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/square/go-jose.v2"
 )
 
+// DefaultRemoteOutputBufferSize is the default max nr of messages of an output that are buffered
+// while the publisher of the output is offline
+const DefaultRemoteOutputBufferSize = 10
+
+// RemoteOutputOptions with the settings of an input that receives the output of another publisher
+type RemoteOutputOptions struct {
+	BufferSize    int            // max nr of messages buffered while the publisher is offline. Default (0) is DefaultRemoteOutputBufferSize
+	DataType      types.DataType // convert received values to this data type, eg DataTypeNumber. Default passes values as received
+	RequireSigned bool           // only accept values that are signed by the publisher of the output
+	// StatusHandler is invoked when the run state of the remote node changes, eg NodeRunStateLost
+	StatusHandler func(input *types.InputDiscoveryMessage, runState string)
+}
+
+// remoteOutput with the state of a subscribed output of another publisher
+type remoteOutput struct {
+	buffer        []string // messages received while the publisher is offline, oldest first
+	bufferSize    int      // max nr of buffered messages
+	nodeAddress   string   // discovery address of the node of the output, "" if unknown
+	publisherAddr string   // address of the publisher of the output: domain/publisherID, "" if unknown
+	runState      string   // last known run state of the remote node, "" if unknown
+}
+
 // ReceiveFromOutputs subscribe to domain outputs to use as input
 type ReceiveFromOutputs struct {
-	errorHandler     func(url string, err error) // invoke when error handling input message
-	isRunning        bool                        // flag, subscriptions are active
-	messageSigner    *messaging.MessageSigner    // subscription and publication messenger
-	registeredInputs *RegisteredInputs           // registered inputs of this publisher
-	senderTimestamp  map[string]string           // most recent timestamp of received commands by sender
-	updateMutex      *sync.Mutex                 // mutex for async updating of inputs
+	errorHandler     func(url string, err error)        // invoke when error handling input message
+	isRunning        bool                               // flag, subscriptions are active
+	messageSigner    *messaging.MessageSigner           // subscription and publication messenger
+	nodeRunStates    map[string]string                  // run state of remote nodes by discovery address
+	options          map[string]RemoteOutputOptions     // options of each input by inputID
+	publisherStates  map[string]types.PublisherRunState // status of remote publishers by publisher address
+	registeredInputs *RegisteredInputs                  // registered inputs of this publisher
+	remoteOutputs    map[string]*remoteOutput           // subscribed outputs by output address
+	senderTimestamp  map[string]string                  // most recent timestamp of received commands by sender
+	updateMutex      *sync.Mutex                        // mutex for async updating of inputs
 }
 
 // CreateInput adds a subscription to an output to use as input
@@ -29,56 +59,403 @@ func (ifout *ReceiveFromOutputs) CreateInput(
 	outputAddress string,
 	handler func(input *types.InputDiscoveryMessage, sender string, payload string)) *types.InputDiscoveryMessage {
 
+	return ifout.CreateInputWithOptions(nodeID, inputType, instance, outputAddress, RemoteOutputOptions{}, handler)
+}
+
+// CreateInputWithOptions adds a subscription to an output of another publisher to use as input.
+// Besides the output, the status of its publisher and the discovery of its node are subscribed to.
+// Messages received while the publisher is lost or disconnected, or while its identity is unknown,
+// are buffered and passed to the handler once the publisher is connected again.
+// Values that are redelivered after the messenger reconnects and resubscribes are ignored.
+// If the given output address is already subscribed to, its handler will be replaced
+//  The given outputAddress is one of $raw or $latest output address
+//  The options control the signature verification, value conversion and buffering
+//  The handler is provided with the address, the sender and the received output value.
+func (ifout *ReceiveFromOutputs) CreateInputWithOptions(
+	nodeID string, inputType types.InputType, instance string,
+	outputAddress string, options RemoteOutputOptions,
+	handler func(input *types.InputDiscoveryMessage, sender string, payload string)) *types.InputDiscoveryMessage {
+
 	input := ifout.registeredInputs.CreateInputWithSource(nodeID, inputType, instance, outputAddress, handler)
+	if options.DataType != "" {
+		input.DataType = options.DataType
+	}
+	if options.BufferSize <= 0 {
+		options.BufferSize = DefaultRemoteOutputBufferSize
+	}
 
 	ifout.updateMutex.Lock()
-	defer ifout.updateMutex.Unlock()
+	ifout.options[input.InputID] = options
+	remote := ifout.remoteOutputs[outputAddress]
+	isNewOutput := remote == nil
+	isNewPublisher := false
+	isNewNode := false
+	if isNewOutput {
+		remote = newRemoteOutput(outputAddress)
+		isNewPublisher = remote.publisherAddr != "" && !ifout.isPublisherSubscribed(remote.publisherAddr)
+		isNewNode = remote.nodeAddress != "" && !ifout.isNodeSubscribed(remote.nodeAddress)
+		ifout.remoteOutputs[outputAddress] = remote
+	}
+	remote.bufferSize = options.BufferSize
+	ifout.updateMutex.Unlock()
 
-	ifout.messageSigner.Subscribe(outputAddress, ifout.onReceiveOutput)
+	// subscribe outside the lock as the messenger can deliver retained messages right away
+	if isNewPublisher {
+		ifout.messageSigner.Subscribe(remote.publisherAddr+"/"+types.MessageTypeStatus, ifout.onReceiveStatus)
+		ifout.messageSigner.Subscribe(remote.publisherAddr+"/"+types.MessageTypeIdentity, ifout.onReceiveIdentity)
+	}
+	if isNewNode {
+		ifout.messageSigner.Subscribe(remote.nodeAddress, ifout.onReceiveNode)
+	}
+	if isNewOutput {
+		ifout.messageSigner.Subscribe(outputAddress, ifout.onReceiveOutput)
+	}
 	return input
 }
 
 // DeleteInput by address
+// The subscriptions to the output, its publisher and node are removed when no other input uses them.
 func (ifout *ReceiveFromOutputs) DeleteInput(inputID string) {
+	ifout.updateMutex.Lock()
+	input := ifout.registeredInputs.GetInputByID(inputID)
+	if input == nil {
+		ifout.updateMutex.Unlock()
+		return
+	}
+	ifout.registeredInputs.DeleteInput(inputID)
+	delete(ifout.options, inputID)
+	remote := ifout.remoteOutputs[input.Source]
+	isOutputUnused := len(ifout.registeredInputs.GetInputsWithSource(input.Source)) == 0
+	isPublisherUnused := false
+	isNodeUnused := false
+	if isOutputUnused {
+		delete(ifout.remoteOutputs, input.Source)
+		delete(ifout.senderTimestamp, input.Source)
+		if remote != nil {
+			isPublisherUnused = remote.publisherAddr != "" && !ifout.isPublisherSubscribed(remote.publisherAddr)
+			isNodeUnused = remote.nodeAddress != "" && !ifout.isNodeSubscribed(remote.nodeAddress)
+		}
+		if isPublisherUnused {
+			delete(ifout.publisherStates, remote.publisherAddr)
+		}
+		if isNodeUnused {
+			delete(ifout.nodeRunStates, remote.nodeAddress)
+		}
+	}
+	ifout.updateMutex.Unlock()
+
+	if isOutputUnused {
+		ifout.messageSigner.Unsubscribe(input.Source, ifout.onReceiveOutput)
+	}
+	if isPublisherUnused {
+		ifout.messageSigner.Unsubscribe(remote.publisherAddr+"/"+types.MessageTypeStatus, ifout.onReceiveStatus)
+		ifout.messageSigner.Unsubscribe(remote.publisherAddr+"/"+types.MessageTypeIdentity, ifout.onReceiveIdentity)
+	}
+	if isNodeUnused {
+		ifout.messageSigner.Unsubscribe(remote.nodeAddress, ifout.onReceiveNode)
+	}
+}
+
+// HasInput returns true if the input with the given ID is fed by an output
+func (ifout *ReceiveFromOutputs) HasInput(inputID string) bool {
 	ifout.updateMutex.Lock()
 	defer ifout.updateMutex.Unlock()
+	_, found := ifout.options[inputID]
+	return found
+}
 
+// GetRemoteStatus returns the run state of the remote node whose output is used by the input,
+// eg NodeRunStateReady, or NodeRunStateLost when its publisher is offline.
+// Returns "" if the input doesn't exist or the run state is not yet known.
+func (ifout *ReceiveFromOutputs) GetRemoteStatus(inputID string) string {
 	input := ifout.registeredInputs.GetInputByID(inputID)
-	if input != nil {
-		ifout.messageSigner.Unsubscribe(input.Source, ifout.onReceiveOutput)
-		ifout.registeredInputs.DeleteInput(inputID)
+	if input == nil {
+		return ""
+	}
+	ifout.updateMutex.Lock()
+	defer ifout.updateMutex.Unlock()
+	remote := ifout.remoteOutputs[input.Source]
+	if remote == nil {
+		return ""
+	}
+	return remote.runState
+}
+
+// isOffline returns true if messages of the output can't be used until its publisher is connected,
+// either because its publisher is offline or because a signed message can't be verified yet.
+// The caller must hold the lock.
+func (ifout *ReceiveFromOutputs) isOffline(address string, remote *remoteOutput, message string) bool {
+	switch ifout.publisherStates[remote.publisherAddr] {
+	case types.PublisherRunStateDisconnected, types.PublisherRunStateFailed, types.PublisherRunStateLost:
+		return true
+	}
+	// the signature can be verified once the identity of the publisher is received
+	getPublicKey := ifout.messageSigner.GetPublicKey
+	return getPublicKey != nil && getPublicKey(address) == nil && isSignedMessage(message)
+}
+
+// isNodeSubscribed returns true if an output of the node at the discovery address is subscribed to.
+// The caller must hold the lock.
+func (ifout *ReceiveFromOutputs) isNodeSubscribed(nodeAddress string) bool {
+	for _, remote := range ifout.remoteOutputs {
+		if remote.nodeAddress == nodeAddress {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublisherSubscribed returns true if an output of the publisher is subscribed to.
+// The caller must hold the lock.
+func (ifout *ReceiveFromOutputs) isPublisherSubscribed(publisherAddr string) bool {
+	for _, remote := range ifout.remoteOutputs {
+		if remote.publisherAddr == publisherAddr {
+			return true
+		}
+	}
+	return false
+}
+
+// flushBuffers passes the buffered messages of the publisher's outputs to the inputs, if the
+// messages can be used.
+func (ifout *ReceiveFromOutputs) flushBuffers(publisherAddr string) {
+	ifout.updateMutex.Lock()
+	buffers := make(map[string][]string)
+	for address, remote := range ifout.remoteOutputs {
+		if remote.publisherAddr == publisherAddr && len(remote.buffer) > 0 &&
+			!ifout.isOffline(address, remote, remote.buffer[0]) {
+			buffers[address] = remote.buffer
+			remote.buffer = nil
+		}
+	}
+	ifout.updateMutex.Unlock()
+
+	for address, buffer := range buffers {
+		logrus.Infof("flushBuffers: Passing %d buffered messages of output %s", len(buffer), address)
+		for _, message := range buffer {
+			err := ifout.receiveOutput(address, message)
+			if err != nil {
+				logrus.Warning(err)
+			}
+		}
+	}
+}
+
+// onReceiveIdentity passes messages that were buffered until the identity of the publisher is known
+func (ifout *ReceiveFromOutputs) onReceiveIdentity(address string, message string) error {
+	ifout.flushBuffers(lib.MakeBaseAddress(address))
+	return nil
+}
+
+// onReceiveNode updates the run state of a remote node from its discovery
+func (ifout *ReceiveFromOutputs) onReceiveNode(address string, message string) error {
+	node := types.NodeDiscoveryMessage{}
+	_, err := ifout.messageSigner.VerifyPublication(address, message, &node)
+	if err != nil {
+		return lib.MakeErrorf("onReceiveNode: Discovery of node %s failed to verify: %s", address, err)
 	}
+	ifout.updateMutex.Lock()
+	ifout.nodeRunStates[address] = node.Status[types.NodeStatusRunState]
+	ifout.updateMutex.Unlock()
+	ifout.updateRunStates()
+	return nil
 }
 
-// onReceiveOutput verifies the message sender (for 'latest' outputs)
+// onReceiveOutput buffers the output message while its publisher is offline, or passes the
+// buffered and received messages to the inputs
 func (ifout *ReceiveFromOutputs) onReceiveOutput(address string, message string) error {
+	var buffered []string
+	ifout.updateMutex.Lock()
+	remote := ifout.remoteOutputs[address]
+	if remote != nil {
+		if ifout.isOffline(address, remote, message) {
+			remote.buffer = append(remote.buffer, message)
+			if len(remote.buffer) > remote.bufferSize {
+				remote.buffer = remote.buffer[len(remote.buffer)-remote.bufferSize:]
+			}
+			ifout.updateMutex.Unlock()
+			logrus.Infof("onReceiveOutput: Publisher of output %s is offline. Message is buffered.", address)
+			return nil
+		}
+		buffered = remote.buffer
+		remote.buffer = nil
+	}
+	ifout.updateMutex.Unlock()
+
+	for _, bufferedMessage := range buffered {
+		err := ifout.receiveOutput(address, bufferedMessage)
+		if err != nil {
+			logrus.Warning(err)
+		}
+	}
+	return ifout.receiveOutput(address, message)
+}
+
+// onReceiveStatus updates the status of a remote publisher. The last will of a publisher can be
+// the plain status.
+func (ifout *ReceiveFromOutputs) onReceiveStatus(address string, message string) error {
+	if message == "" {
+		return nil
+	}
+	statusMessage := types.PublisherStatusMessage{}
+	_, err := messaging.VerifySenderJWSSignature(message, &statusMessage, nil)
+	if err != nil {
+		statusMessage.Status = types.PublisherRunState(strings.Trim(message, "\""))
+	}
+	publisherAddr := lib.MakeBaseAddress(address)
+	ifout.updateMutex.Lock()
+	ifout.publisherStates[publisherAddr] = statusMessage.Status
+	ifout.updateMutex.Unlock()
+
+	ifout.updateRunStates()
+	ifout.flushBuffers(publisherAddr)
+	return nil
+}
+
+// receiveOutput verifies the message sender (for 'latest' outputs) and passes the value to the inputs
+func (ifout *ReceiveFromOutputs) receiveOutput(address string, message string) error {
 	var value string
+	isSigned := false
 	if strings.HasSuffix(address, types.MessageTypeRaw) {
-		value = message
+		payload, err := ifout.messageSigner.VerifyRawPublication(address, message)
+		if err != nil {
+			return lib.MakeErrorf("onReceiveOutput: Raw output on address %s failed to verify: %s", address, err)
+		}
+		isSigned = isSignedMessage(message)
+		value = payload
 	} else if strings.HasSuffix(address, types.MessageTypeLatest) {
 		latestMessage := types.OutputLatestMessage{}
-		isSigned, err := ifout.messageSigner.VerifySignedMessage(message, &latestMessage)
+		var err error
+		isSigned, err = ifout.messageSigner.VerifyPublication(address, message, &latestMessage)
 		if err != nil {
 			return lib.MakeErrorf("onReceiveOutput: Sender of output on address %s failed to verify: %s", address, err)
 		}
 		// Verify this is the most recent message to protect against replay attacks
+		ifout.updateMutex.Lock()
 		prevTimestamp := ifout.senderTimestamp[address]
-		if prevTimestamp > latestMessage.Timestamp {
+		if prevTimestamp != "" && lib.CompareTimestamps(prevTimestamp, latestMessage.Timestamp) > 0 {
+			ifout.updateMutex.Unlock()
 			return lib.MakeErrorf("onReceiveOutput: earlier timestamp of output %s. Message discarded.", address)
+		} else if prevTimestamp == latestMessage.Timestamp {
+			// the retained value is delivered again after the messenger resubscribes
+			ifout.updateMutex.Unlock()
+			return nil
 		}
 		ifout.senderTimestamp[address] = latestMessage.Timestamp
-		_ = isSigned
+		ifout.updateMutex.Unlock()
 		value = latestMessage.Value
 	}
 
 	// Find inputs that subscribe to this output
 	inputs := ifout.registeredInputs.GetInputsWithSource(address)
 	for _, input := range inputs {
-		ifout.registeredInputs.NotifyInputHandler(input.InputID, address, value)
+		ifout.updateMutex.Lock()
+		options := ifout.options[input.InputID]
+		ifout.updateMutex.Unlock()
+		if options.RequireSigned && !isSigned {
+			logrus.Warningf("onReceiveOutput: Output %s isn't signed. Input %s requires a signed value.", address, input.InputID)
+			continue
+		}
+		inputValue, err := ConvertValue(value, options.DataType)
+		if err != nil {
+			logrus.Warningf("onReceiveOutput: Value of output %s is discarded by input %s: %s", address, input.InputID, err)
+			continue
+		}
+		ifout.registeredInputs.NotifyInputHandler(input.InputID, address, inputValue)
 	}
 	return nil
 }
 
+// updateRunStates determines the run state of the remote nodes and notifies the status handler
+// of the inputs whose remote node run state has changed
+func (ifout *ReceiveFromOutputs) updateRunStates() {
+	type statusChange struct {
+		handler  func(input *types.InputDiscoveryMessage, runState string)
+		input    *types.InputDiscoveryMessage
+		runState string
+	}
+	changes := make([]statusChange, 0)
+	ifout.updateMutex.Lock()
+	for address, remote := range ifout.remoteOutputs {
+		runState := ifout.nodeRunStates[remote.nodeAddress]
+		switch ifout.publisherStates[remote.publisherAddr] {
+		case types.PublisherRunStateDisconnected, types.PublisherRunStateFailed, types.PublisherRunStateLost:
+			runState = types.NodeRunStateLost
+		case types.PublisherRunStateConnected:
+			if runState == "" {
+				runState = types.NodeRunStateReady
+			}
+		}
+		if runState == remote.runState {
+			continue
+		}
+		remote.runState = runState
+		for _, input := range ifout.registeredInputs.GetInputsWithSource(address) {
+			handler := ifout.options[input.InputID].StatusHandler
+			if handler != nil {
+				changes = append(changes, statusChange{handler: handler, input: input, runState: runState})
+			}
+		}
+	}
+	ifout.updateMutex.Unlock()
+
+	for _, change := range changes {
+		change.handler(change.input, change.runState)
+	}
+}
+
+// ConvertValue converts a value to the given data type. Booleans are converted to true or false and
+// numbers to their shortest representation. Values of other data types are returned as is.
+// Returns an error if the value can't be converted.
+func ConvertValue(value string, dataType types.DataType) (string, error) {
+	trimmed := strings.TrimSpace(value)
+	switch dataType {
+	case types.DataTypeBool:
+		switch strings.ToLower(trimmed) {
+		case "on", "yes":
+			return "true", nil
+		case "off", "no":
+			return "false", nil
+		}
+		boolValue, err := strconv.ParseBool(trimmed)
+		if err != nil {
+			return value, lib.MakeErrorf("ConvertValue: Value '%s' is not a boolean", value)
+		}
+		return strconv.FormatBool(boolValue), nil
+	case types.DataTypeInt:
+		number, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil {
+			return value, lib.MakeErrorf("ConvertValue: Value '%s' is not a number", value)
+		}
+		return strconv.FormatInt(int64(math.Round(number)), 10), nil
+	case types.DataTypeNumber:
+		number, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil {
+			return value, lib.MakeErrorf("ConvertValue: Value '%s' is not a number", value)
+		}
+		return strconv.FormatFloat(number, 'f', -1, 64), nil
+	}
+	return value, nil
+}
+
+// isSignedMessage returns true if the message is a JWS signed message
+func isSignedMessage(message string) bool {
+	_, err := jose.ParseSigned(message)
+	return err == nil
+}
+
+// newRemoteOutput returns the state of an output with the address of its publisher and node.
+// Output addresses are: domain/publisherID/nodeID/outputType/instance/messageType
+func newRemoteOutput(outputAddress string) *remoteOutput {
+	remote := &remoteOutput{}
+	segments := strings.Split(outputAddress, "/")
+	if len(segments) >= 6 && !strings.ContainsAny(outputAddress, "+#") {
+		remote.publisherAddr = segments[0] + "/" + segments[1]
+		remote.nodeAddress = strings.Join(segments[:3], "/") + "/" + types.MessageTypeNodeDiscovery
+	}
+	return remote
+}
+
 // NewReceiveFromOutputs creates a input list with subscriptions to outputs to use as input
 func NewReceiveFromOutputs(
 	messageSigner *messaging.MessageSigner,
@@ -87,7 +464,11 @@ func NewReceiveFromOutputs(
 
 	ifo := ReceiveFromOutputs{
 		messageSigner:    messageSigner,
+		nodeRunStates:    make(map[string]string),
+		options:          make(map[string]RemoteOutputOptions),
+		publisherStates:  make(map[string]types.PublisherRunState),
 		registeredInputs: registeredInputs,
+		remoteOutputs:    make(map[string]*remoteOutput),
 		senderTimestamp:  make(map[string]string),
 		updateMutex:      &sync.Mutex{}, // mutex for async updating of inputs
 	}
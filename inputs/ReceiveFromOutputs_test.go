@@ -10,6 +10,7 @@ import (
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/square/go-jose/json"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestReceiveFromOutputs(t *testing.T) {
@@ -79,3 +80,114 @@ func TestReceiveFromOutputs(t *testing.T) {
 	i.DeleteInput(input1.InputID)
 
 }
+
+func TestRemoteOutputOptions(t *testing.T) {
+	const outputAddr = "test/pub2/node1/temperature/0/" + types.MessageTypeLatest
+	const statusAddr = "test/pub2/" + types.MessageTypeStatus
+	const identityAddr = "test/pub2/" + types.MessageTypeIdentity
+	const nodeAddr = "test/pub2/node1/" + types.MessageTypeNodeDiscovery
+	var privKey = messaging.CreateAsymKeys()
+	var publicKey = &privKey.PublicKey
+	received := make([]string, 0)
+	runStates := make([]string, 0)
+
+	msgr := messaging.NewDummyMessenger(nil)
+	signer := messaging.NewMessageSigner(msgr, privKey, func(addr string) *ecdsa.PublicKey {
+		return publicKey
+	})
+	regInputs := inputs.NewRegisteredInputs("test", "pub1")
+	i := inputs.NewReceiveFromOutputs(signer, regInputs)
+	options := inputs.RemoteOutputOptions{
+		DataType:      types.DataTypeNumber,
+		RequireSigned: true,
+		StatusHandler: func(input *types.InputDiscoveryMessage, runState string) {
+			runStates = append(runStates, runState)
+		},
+	}
+	input := i.CreateInputWithOptions("node1", types.InputTypeTemperature, "0", outputAddr, options,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received = append(received, value)
+		})
+	require.NotNil(t, input)
+	assert.Equal(t, types.DataTypeNumber, input.DataType)
+	publishLatest := func(value string, signed bool, age time.Duration) {
+		latest := types.OutputLatestMessage{
+			Address:   outputAddr,
+			Value:     value,
+			Timestamp: time.Now().Add(-age).Format(types.TimeFormat),
+		}
+		payload, _ := json.Marshal(&latest)
+		if signed {
+			signer.PublishSigned(outputAddr, false, string(payload))
+		} else {
+			msgr.Publish(outputAddr, false, string(payload))
+		}
+	}
+
+	// unsigned values are rejected and values are converted to the data type
+	publishLatest("20", false, 3*time.Second)
+	assert.Empty(t, received)
+	publishLatest("21.50", true, 2*time.Second)
+	assert.Equal(t, []string{"21.5"}, received)
+	publishLatest("not a number", true, time.Second)
+	assert.Equal(t, []string{"21.5"}, received)
+
+	// a value that is redelivered after resubscribing is ignored
+	signer.Resubscribe()
+	msgr.Publish(outputAddr, false, msgr.FindLastPublication(outputAddr))
+	assert.Equal(t, 1, len(received))
+
+	// values are buffered while the publisher is lost
+	msgr.Publish(statusAddr, true, `"lost"`)
+	assert.Equal(t, []string{types.NodeRunStateLost}, runStates)
+	assert.Equal(t, types.NodeRunStateLost, i.GetRemoteStatus(input.InputID))
+	publishLatest("22", true, 0)
+	assert.Equal(t, 1, len(received))
+	status, _ := json.Marshal(&types.PublisherStatusMessage{Address: statusAddr, Status: types.PublisherRunStateConnected})
+	signer.PublishSigned(statusAddr, true, string(status))
+	assert.Equal(t, []string{"21.5", "22"}, received)
+	assert.Equal(t, types.NodeRunStateReady, i.GetRemoteStatus(input.InputID))
+
+	// the run state of the remote node is passed to the status handler
+	node, _ := json.Marshal(&types.NodeDiscoveryMessage{Address: nodeAddr,
+		Status: types.NodeStatusMap{types.NodeStatusRunState: types.NodeRunStateSleeping}})
+	signer.PublishSigned(nodeAddr, true, string(node))
+	assert.Equal(t, []string{types.NodeRunStateLost, types.NodeRunStateReady, types.NodeRunStateSleeping}, runStates)
+
+	// signed values are buffered until the identity of the publisher is known
+	publicKey = nil
+	time.Sleep(time.Millisecond)
+	publishLatest("23", true, 0)
+	assert.Equal(t, 2, len(received))
+	publicKey = &privKey.PublicKey
+	msgr.Publish(identityAddr, true, "{}")
+	assert.Equal(t, []string{"21.5", "22", "23"}, received)
+
+	// deleting the input removes the subscriptions
+	i.DeleteInput(input.InputID)
+	assert.Empty(t, signer.GetSubscriptions())
+	assert.Equal(t, "", i.GetRemoteStatus(input.InputID))
+}
+
+func TestConvertValue(t *testing.T) {
+	value, err := inputs.ConvertValue("on", types.DataTypeBool)
+	assert.NoError(t, err)
+	assert.Equal(t, "true", value)
+	value, err = inputs.ConvertValue("0", types.DataTypeBool)
+	assert.NoError(t, err)
+	assert.Equal(t, "false", value)
+	value, err = inputs.ConvertValue(" 21.6 ", types.DataTypeInt)
+	assert.NoError(t, err)
+	assert.Equal(t, "22", value)
+	value, err = inputs.ConvertValue("2.50", types.DataTypeNumber)
+	assert.NoError(t, err)
+	assert.Equal(t, "2.5", value)
+	value, err = inputs.ConvertValue(" text ", types.DataTypeString)
+	assert.NoError(t, err)
+	assert.Equal(t, " text ", value)
+
+	_, err = inputs.ConvertValue("maybe", types.DataTypeBool)
+	assert.Error(t, err)
+	_, err = inputs.ConvertValue("many", types.DataTypeInt)
+	assert.Error(t, err)
+}
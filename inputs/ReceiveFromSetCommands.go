@@ -4,8 +4,8 @@ package inputs
 import (
 	"errors"
 	"fmt"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
@@ -18,12 +18,17 @@ import (
 // the sender public key. Last it translates from the publishing address to the input ID
 // before passing the request to the handler associated with the input.
 type ReceiveFromSetCommands struct {
-	domain           string // the domain of this publisher
-	publisherID      string // the registered publisher for the inputs
+	clockSkew        time.Duration // tolerance of the clock of the sender
+	domain           string        // the domain of this publisher
+	publisherID      string        // the registered publisher for the inputs
 	isRunning        bool
+	deduplicator     *lib.MessageDeduplicator // ignore duplicate deliveries of commands
 	messageSigner    *messaging.MessageSigner // subscription and publication messenger
 	senderTimestamp  map[string]string        // most recent timestamp of received commands by sender
 	registeredInputs *RegisteredInputs        // registered inputs of this publisher
+	// set commands to apply at a later time, ordered by execution time
+	scheduledCommands []*types.SetInputMessage
+	scheduleFile      string // file to persist the scheduled commands, "" to not persist
 	// subscriptions of registered inputs
 	subscriptions map[string]string // SetInput subscriptions of inputs [setAddr]setAddr
 	updateMutex   *sync.Mutex       // mutex for async handling of inputs
@@ -54,25 +59,65 @@ func (ifset *ReceiveFromSetCommands) DeleteInput(inputID string) {
 	ifset.registeredInputs.DeleteInput(inputID)
 }
 
+// SetClockSkew sets the tolerance of the clock of senders when validating the command timestamp.
+// Default is lib.DefaultClockSkew. Use 0 to not tolerate any skew.
+func (ifset *ReceiveFromSetCommands) SetClockSkew(skew time.Duration) {
+	ifset.clockSkew = skew
+}
+
+// SetDedupWindow sets the time that duplicate set commands are ignored
+func (ifset *ReceiveFromSetCommands) SetDedupWindow(window time.Duration) {
+	ifset.deduplicator.SetWindow(window)
+}
+
+// SetNodeID changes the node ID in the address of the node's inputs and updates the
+// subscriptions to their set command.
+func (ifset *ReceiveFromSetCommands) SetNodeID(nodeHWID string, newNodeID string) {
+	ifset.updateMutex.Lock()
+	defer ifset.updateMutex.Unlock()
+
+	// only inputs with a set command subscription are resubscribed
+	subscribedInputs := make([]*types.InputDiscoveryMessage, 0)
+	inputList := ifset.registeredInputs.GetInputsByNodeHWID(nodeHWID)
+	for _, input := range inputList {
+		if ifset.unsubscribeFromSetCommand(input.InputID) {
+			subscribedInputs = append(subscribedInputs, input)
+		}
+	}
+	ifset.registeredInputs.SetNodeID(nodeHWID, newNodeID)
+	for _, input := range subscribedInputs {
+		ifset.subscribeToSetCommand(input)
+	}
+}
+
 // decodeSetCommand decrypts and verifies the signature of an incoming set command.
 // If successful this passes the set command to the setInputHandler callback
-func (ifset *ReceiveFromSetCommands) decodeSetCommand(address string, message string) error {
+func (ifset *ReceiveFromSetCommands) decodeSetCommand(address string, message string) (err error) {
 	var setMessage types.SetInputMessage
+	var isEncrypted, isSigned bool
+	var ackErr error
+	// commands that are acknowledged with an error are audited as rejected
+	defer func() {
+		rejectErr := err
+		if rejectErr == nil {
+			rejectErr = ackErr
+		}
+		ifset.messageSigner.AuditCommand(address, setMessage.Sender, isEncrypted, isSigned, rejectErr)
+	}()
 
 	// Check that address is one of our inputs
-	segments := strings.Split(address, "/")
+	setAddr, err := types.ParseAddress(address)
 	// a full address is required
-	if len(segments) < 6 {
+	if err != nil || setAddr.IOType() == "" {
 		errText := fmt.Sprintf("decodeSetCommand: Destination address '%s' is incomplete.", address)
 		return errors.New(errText)
 	}
 	// domain/pub/node/inputtype/instance/$input
-	segments[5] = types.MessageTypeInputDiscovery
-	inputAddr := strings.Join(segments, "/")
+	inputAddr := setAddr.WithMessageType(types.MessageTypeInputDiscovery).String()
 
-	isEncrypted, isSigned, err := ifset.messageSigner.DecodeMessage(message, &setMessage)
+	isEncrypted, isSigned, err = ifset.messageSigner.DecodeCommand(message, &setMessage)
 
-	if !isEncrypted {
+	if !isEncrypted && ifset.messageSigner.EncryptCommands() {
 		return lib.MakeErrorf("decodeSetCommand: Set command '%s' is not encrypted. Message discarded.", address)
 	} else if !isSigned {
 		return lib.MakeErrorf("decodeSetCommand: Set command '%s' is not signed. Message discarded.", address)
@@ -81,29 +126,67 @@ func (ifset *ReceiveFromSetCommands) decodeSetCommand(address string, message st
 	}
 
 	// Verify this is the most recent message to protect against replay attacks
+	ackSender := ifset.domain + "/" + ifset.publisherID
 	prevTimestamp := ifset.senderTimestamp[setMessage.Sender]
-	if prevTimestamp > setMessage.Timestamp {
+	// a command from the future would block the sender's next commands
+	if lib.IsTimestampInFuture(setMessage.Timestamp, ifset.clockSkew) {
+		errText := fmt.Sprintf("decodeSetCommand: timestamp %s of message to input %s from sender %s is in the"+
+			" future beyond the clock skew tolerance. Message discarded.", setMessage.Timestamp, address, setMessage.Sender)
+		logrus.Warning(errText)
+		lib.PublishAck(address, setMessage.MessageID, ackSender, errors.New("timestamp in the future"), ifset.messageSigner)
+		return errors.New(errText)
+	}
+	if prevTimestamp != "" && lib.CompareTimestamps(prevTimestamp, setMessage.Timestamp) > 0 {
 		errText := fmt.Sprintf("decodeSetCommand: earlier timestamp of message to input %s from sender %s."+
 			" Message discarded.", address, setMessage.Sender)
 		logrus.Warning(errText)
+		lib.PublishAck(address, setMessage.MessageID, ackSender, errors.New("earlier timestamp"), ifset.messageSigner)
 		return errors.New(errText)
 	}
 	ifset.senderTimestamp[setMessage.Sender] = setMessage.Timestamp
+	// a redelivered command is acknowledged again but not passed to the handler
+	if ifset.deduplicator.IsDuplicate(setMessage.Sender, setMessage.MessageID) {
+		logrus.Infof("decodeSetCommand: Ignored duplicate command %s to input %s from sender %s",
+			setMessage.MessageID, address, setMessage.Sender)
+		lib.PublishAck(address, setMessage.MessageID, ackSender, nil, ifset.messageSigner)
+		return nil
+	}
 	logrus.Infof("decodeSetCommand successful for input %s. isEncrypted=%t, isSigned=%t",
 		address, isEncrypted, isSigned)
 
 	// the handler is responsible for authorization
 	inputID := ifset.registeredInputs.addressMap[inputAddr]
-	ifset.registeredInputs.NotifyInputHandler(inputID, setMessage.Sender, setMessage.Value)
+	input := ifset.registeredInputs.GetInputByID(inputID)
+	if input == nil {
+		ackErr = errors.New("unknown input")
+	} else if ifset.registeredInputs.IsNodeDisabled(input.NodeHWID) {
+		ackErr = errors.New("the node of the input is disabled")
+	} else if ifset.registeredInputs.ValidateValue(inputID, setMessage.Value) != nil {
+		ackErr = errors.New("invalid enum value")
+	}
+	// commands with an execution time in the future are applied when their time has come
+	if ackErr == nil && setMessage.ExecuteAt != "" {
+		executeAt, err := time.Parse(types.TimeFormat, setMessage.ExecuteAt)
+		if err != nil {
+			ackErr = errors.New("invalid executeAt time")
+		} else if lib.Now().Before(executeAt) {
+			logrus.Infof("decodeSetCommand: Command to input %s is scheduled at %s", address, setMessage.ExecuteAt)
+			ifset.scheduleSetCommand(&setMessage)
+			lib.PublishAck(address, setMessage.MessageID, ackSender, nil, ifset.messageSigner)
+			return nil
+		}
+	}
+	if ackErr == nil {
+		ifset.registeredInputs.NotifyInputHandler(inputID, setMessage.Sender, setMessage.Value)
+	}
+	lib.PublishAck(address, setMessage.MessageID, ackSender, ackErr, ifset.messageSigner)
 	return nil
 }
 
 // subscribeToSetCommand to receive set input commands for the given node, type and instance
 func (ifset *ReceiveFromSetCommands) subscribeToSetCommand(input *types.InputDiscoveryMessage) {
 	// change message type $input to $set to make the set address from the input address
-	segments := strings.Split(input.Address, "/")
-	segments[5] = types.MessageTypeSetInput
-	setAddr := strings.Join(segments, "/")
+	setAddr := makeSetInputAddress(input)
 
 	// prevent double subscription
 	_, hasSubscription := ifset.subscriptions[input.Address]
@@ -114,18 +197,18 @@ func (ifset *ReceiveFromSetCommands) subscribeToSetCommand(input *types.InputDis
 }
 
 // unsubscribeFromSetCommand removes previous subscription
-func (ifset *ReceiveFromSetCommands) unsubscribeFromSetCommand(inputID string) {
+// This returns true if the input had a subscription
+func (ifset *ReceiveFromSetCommands) unsubscribeFromSetCommand(inputID string) bool {
 	// change message type $input to $set to make the set address from the input address
 	input := ifset.registeredInputs.GetInputByID(inputID)
-	segments := strings.Split(input.Address, "/")
-	segments[5] = types.MessageTypeSetInput
-	setAddr := strings.Join(segments, "/")
+	setAddr := makeSetInputAddress(input)
 
 	_, hasSubscription := ifset.subscriptions[setAddr]
 	if hasSubscription {
 		delete(ifset.subscriptions, setAddr)
 		ifset.messageSigner.Unsubscribe(setAddr, ifset.decodeSetCommand)
 	}
+	return hasSubscription
 }
 
 // MakeSetInputAddress creates the address used to update a node input value
@@ -138,6 +221,12 @@ func MakeSetInputAddress(domain string, publisherID string, nodeID string,
 	return address
 }
 
+// makeSetInputAddress returns the address of set commands of an input using its discovery address
+func makeSetInputAddress(input *types.InputDiscoveryMessage) string {
+	inputAddr, _ := types.ParseAddress(input.Address)
+	return inputAddr.WithMessageType(types.MessageTypeSetInput).String()
+}
+
 // NewReceiveFromSetCommands returns a new instance of handling of set input commands.
 // The private key is used to decrypt set commands. Without it, decryption is disabled.
 func NewReceiveFromSetCommands(
@@ -147,6 +236,8 @@ func NewReceiveFromSetCommands(
 	registeredInputs *RegisteredInputs) *ReceiveFromSetCommands {
 
 	recvsetin := &ReceiveFromSetCommands{
+		clockSkew:        lib.DefaultClockSkew,
+		deduplicator:     lib.NewMessageDeduplicator(),
 		domain:           domain,
 		messageSigner:    messageSigner,
 		publisherID:      publisherID,
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
@@ -114,3 +115,108 @@ func TestPublishSetInput(t *testing.T) {
 	assert.NotEqual(t, "content old", rxMsg, "Older message should not be accepted")
 
 }
+
+func TestSetCommandClockSkew(t *testing.T) {
+	const input1Type = types.InputTypeSwitch
+	var setInput1Addr = inputs.MakeSetInputAddress(domain, publisher1ID, node1ID, input1Type, types.DefaultInputInstance)
+	var input1Addr = inputs.MakeInputDiscoveryAddress(domain, publisher1ID, node1ID, input1Type, types.DefaultInputInstance)
+	var senderAddr = fmt.Sprintf("%s/publisher1/node2/$node", domain)
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	lib.SetClock(lib.NewManualClock(now))
+	defer lib.SetClock(nil)
+	received := make([]string, 0)
+
+	msgr := messaging.NewDummyMessenger(nil)
+	signer := messaging.NewMessageSigner(msgr, privKey, getPublisherKey)
+	registeredInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+	receiver := inputs.NewReceiveFromSetCommands(domain, publisher1ID, signer, registeredInputs)
+	receiver.CreateInput(node1ID, input1Type, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received = append(received, value)
+		})
+	publish := func(value string, timestamp time.Time) {
+		setMsg := types.SetInputMessage{Address: input1Addr, Value: value, Sender: senderAddr,
+			Timestamp: timestamp.Format(types.TimeFormat)}
+		signer.PublishObject(setInput1Addr, false, setMsg, &privKey.PublicKey)
+	}
+
+	// commands from a sender whose clock is ahead within the tolerance are accepted
+	publish("1", now.Add(time.Minute))
+	// commands too far in the future are rejected
+	publish("2", now.Add(lib.DefaultClockSkew+time.Minute))
+	// timestamps are compared by time, not by text, so other time zones are accepted
+	publish("3", now.Add(2*time.Minute).In(time.FixedZone("PDT", -7*3600)))
+	assert.Equal(t, []string{"1", "3"}, received)
+	// the tolerance is set per receiver
+	receiver.SetClockSkew(0)
+	publish("4", now.Add(3*time.Minute))
+	assert.Equal(t, []string{"1", "3"}, received)
+}
+
+func TestDuplicateSetCommand(t *testing.T) {
+	const input1Type = types.InputTypeSwitch
+	var setInput1Addr = inputs.MakeSetInputAddress(domain, publisher1ID, node1ID, input1Type, types.DefaultInputInstance)
+	var senderAddr = fmt.Sprintf("%s/publisher2", domain)
+	var received = 0
+
+	msgr := messaging.NewDummyMessenger(nil)
+	signer := messaging.NewMessageSigner(msgr, privKey, getPublisherKey)
+	registeredInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+	receiver := inputs.NewReceiveFromSetCommands(domain, publisher1ID, signer, registeredInputs)
+	receiver.CreateInput(node1ID, input1Type, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received++
+		})
+
+	// a redelivered command is ignored
+	err := inputs.PublishSetInput(setInput1Addr, "toggle", senderAddr, signer, &privKey.PublicKey)
+	assert.NoError(t, err)
+	msgr.OnReceive(setInput1Addr, msgr.FindLastPublication(setInput1Addr))
+	assert.Equal(t, 1, received)
+
+	// a new command with the same value is handled
+	err = inputs.PublishSetInput(setInput1Addr, "toggle", senderAddr, signer, &privKey.PublicKey)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, received)
+
+	// after the window the command is handled again
+	receiver.SetDedupWindow(0)
+	msgr.OnReceive(setInput1Addr, msgr.FindLastPublication(setInput1Addr))
+	assert.Equal(t, 3, received)
+}
+
+func TestEnumSetCommand(t *testing.T) {
+	const input1Type = types.InputTypeSwitch
+	var setInput1Addr = inputs.MakeSetInputAddress(domain, publisher1ID, node1ID, input1Type, types.DefaultInputInstance)
+	var senderAddr = fmt.Sprintf("%s/publisher2", domain)
+	var received = ""
+
+	msgr := messaging.NewDummyMessenger(nil)
+	signer := messaging.NewMessageSigner(msgr, privKey, getPublisherKey)
+	registeredInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+	receiver := inputs.NewReceiveFromSetCommands(domain, publisher1ID, signer, registeredInputs)
+	input := receiver.CreateInput(node1ID, input1Type, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received = value
+		})
+	ok := registeredInputs.SetEnumValues(input.InputID, []string{"heat", "cool", "auto", "off"})
+	assert.True(t, ok)
+	input = registeredInputs.GetInputByID(input.InputID)
+	assert.Equal(t, types.DataTypeEnum, input.DataType)
+	assert.Len(t, input.EnumValues, 4)
+
+	// only enum values are accepted
+	inputs.PublishSetInput(setInput1Addr, "cool", senderAddr, signer, &privKey.PublicKey)
+	assert.Equal(t, "cool", received)
+	inputs.PublishSetInput(setInput1Addr, "dry", senderAddr, signer, &privKey.PublicKey)
+	assert.Equal(t, "cool", received)
+	assert.Error(t, registeredInputs.ValidateValue(input.InputID, "dry"))
+
+	// without enum values any value is accepted
+	registeredInputs.SetEnumValues(input.InputID, nil)
+	assert.Equal(t, types.DataTypeString, registeredInputs.GetInputByID(input.InputID).DataType)
+	inputs.PublishSetInput(setInput1Addr, "dry", senderAddr, signer, &privKey.PublicKey)
+	assert.Equal(t, "dry", received)
+	ok = registeredInputs.SetEnumValues("notaninput", []string{"on"})
+	assert.False(t, ok)
+}
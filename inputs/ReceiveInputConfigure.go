@@ -0,0 +1,141 @@
+// Package inputs with handling of input configuration commands
+package inputs
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// InputConfigureHandler application handler when a command to update an input's configuration is
+// received. The handler is only invoked if the input is confirmed to exist.
+type InputConfigureHandler func(input *types.InputDiscoveryMessage, params types.NodeAttrMap)
+
+// ReceiveInputConfigure with handling of configure commands aimed at inputs managed by this publisher.
+// This decrypts incoming messages, determines the sender and verifies the signature with the
+// sender public key.
+type ReceiveInputConfigure struct {
+	deduplicator          *lib.MessageDeduplicator // ignore duplicate deliveries of commands
+	domain                string                   // the domain of this publisher
+	inputConfigureHandler InputConfigureHandler    // optional handler to pass the command to
+	messageSigner         *messaging.MessageSigner // subscription and publication messenger
+	publisherID           string                   // the registered publisher for the inputs
+	registeredInputs      *RegisteredInputs        // registered inputs of this publisher
+	updateMutex           *sync.Mutex              // mutex for async handling of commands
+}
+
+// SetConfigureInputHandler sets the handler for updating input configuration. The handler determines
+// which configuration updates are applied. Use nil to apply the updates without handler.
+func (inputConfigure *ReceiveInputConfigure) SetConfigureInputHandler(handler InputConfigureHandler) {
+	inputConfigure.updateMutex.Lock()
+	defer inputConfigure.updateMutex.Unlock()
+	inputConfigure.inputConfigureHandler = handler
+}
+
+// SetDedupWindow sets the time that duplicate configure commands are ignored
+func (inputConfigure *ReceiveInputConfigure) SetDedupWindow(window time.Duration) {
+	inputConfigure.deduplicator.SetWindow(window)
+}
+
+// Start listening for input configure commands
+func (inputConfigure *ReceiveInputConfigure) Start() {
+	addr := MakeInputConfigureAddress(inputConfigure.domain, inputConfigure.publisherID, "+", "+", "+")
+	inputConfigure.messageSigner.Subscribe(addr, inputConfigure.receiveConfigureCommand)
+}
+
+// Stop listening for input configure commands
+func (inputConfigure *ReceiveInputConfigure) Stop() {
+	addr := MakeInputConfigureAddress(inputConfigure.domain, inputConfigure.publisherID, "+", "+", "+")
+	inputConfigure.messageSigner.Unsubscribe(addr, inputConfigure.receiveConfigureCommand)
+}
+
+// receiveConfigureCommand handles an incoming configuration command for one of our inputs. This:
+// - check if the message is encrypted
+// - check if the signature is valid
+// - check if the input is valid and its node is enabled
+// - if a configuration handler is set, let it apply the configuration, otherwise apply it
+func (inputConfigure *ReceiveInputConfigure) receiveConfigureCommand(address string, message string) (err error) {
+	var configureMessage types.InputConfigureMessage
+	var isEncrypted, isSigned bool
+	var ackErr error
+	// commands that are acknowledged with an error are audited as rejected
+	defer func() {
+		rejectErr := err
+		if rejectErr == nil {
+			rejectErr = ackErr
+		}
+		inputConfigure.messageSigner.AuditCommand(address, configureMessage.Sender, isEncrypted, isSigned, rejectErr)
+	}()
+
+	configAddr, err := types.ParseAddress(address)
+	if err != nil || configAddr.IOType() == "" {
+		return lib.MakeErrorf("receiveConfigureCommand: Destination address '%s' is incomplete.", address)
+	}
+	// domain/pub/node/inputtype/instance/$input
+	inputAddr := configAddr.WithMessageType(types.MessageTypeInputDiscovery).String()
+
+	isEncrypted, isSigned, err = inputConfigure.messageSigner.DecodeCommand(message, &configureMessage)
+	if !isEncrypted && inputConfigure.messageSigner.EncryptCommands() {
+		return lib.MakeErrorf("receiveConfigureCommand: Configuration update of '%s' is not encrypted. Message discarded.", address)
+	} else if !isSigned {
+		return lib.MakeErrorf("receiveConfigureCommand: Configuration update of '%s' is not signed. Message discarded.", address)
+	} else if err != nil {
+		return lib.MakeErrorf("receiveConfigureCommand: Message to %s. Error %s'. Message discarded.", address, err)
+	}
+
+	ackSender := inputConfigure.domain + "/" + inputConfigure.publisherID
+	// a redelivered command is acknowledged again but not applied
+	if inputConfigure.deduplicator.IsDuplicate(configureMessage.Sender, configureMessage.MessageID) {
+		logrus.Infof("receiveConfigureCommand: Ignored duplicate command %s to %s from sender %s",
+			configureMessage.MessageID, address, configureMessage.Sender)
+		lib.PublishAck(address, configureMessage.MessageID, ackSender, nil, inputConfigure.messageSigner)
+		return nil
+	}
+	input := inputConfigure.registeredInputs.GetInputByAddress(inputAddr)
+	if input == nil {
+		ackErr = errors.New("unknown input")
+	} else if inputConfigure.registeredInputs.IsNodeDisabled(input.NodeHWID) {
+		ackErr = errors.New("the node of the input is disabled")
+	} else {
+		logrus.Infof("receiveConfigureCommand: configure command on address %s. isEncrypted=%t, isSigned=%t",
+			address, isEncrypted, isSigned)
+		inputConfigure.ConfigureInput(input, configureMessage.Attr)
+	}
+	lib.PublishAck(address, configureMessage.MessageID, ackSender, ackErr, inputConfigure.messageSigner)
+	return nil
+}
+
+// ConfigureInput passes a configuration update to the handler or applies it if no handler is set,
+// in the same way as a received configure command.
+func (inputConfigure *ReceiveInputConfigure) ConfigureInput(input *types.InputDiscoveryMessage, params types.NodeAttrMap) {
+	inputConfigure.updateMutex.Lock()
+	handler := inputConfigure.inputConfigureHandler
+	inputConfigure.updateMutex.Unlock()
+	if handler != nil {
+		handler(input, params)
+	} else {
+		inputConfigure.registeredInputs.UpdateInputConfigValues(input.InputID, params)
+	}
+}
+
+// NewReceiveInputConfigure returns a new instance of handling of input configuration commands
+func NewReceiveInputConfigure(
+	domain string,
+	publisherID string,
+	messageSigner *messaging.MessageSigner,
+	registeredInputs *RegisteredInputs) *ReceiveInputConfigure {
+	rxConfigure := &ReceiveInputConfigure{
+		deduplicator:     lib.NewMessageDeduplicator(),
+		domain:           domain,
+		messageSigner:    messageSigner,
+		publisherID:      publisherID,
+		registeredInputs: registeredInputs,
+		updateMutex:      &sync.Mutex{},
+	}
+	return rxConfigure
+}
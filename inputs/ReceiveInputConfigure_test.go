@@ -0,0 +1,70 @@
+package inputs_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiveInputConfigure(t *testing.T) {
+	const url1 = "http://localhost/input1"
+	const url2 = "http://localhost/input2"
+	sender := domain + "/publisher2"
+	msgr := messaging.NewDummyMessenger(nil)
+	signer := messaging.NewMessageSigner(msgr, privKey, getPublisherKey)
+	registeredInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+	rxHTTP := inputs.NewReceiveFromHTTP(registeredInputs)
+	input, err := rxHTTP.CreateHTTPInputWithOptions(node1ID, types.InputTypeImage, types.DefaultInputInstance,
+		url1, inputs.HTTPInputOptions{PollInterval: 60}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, url1, input.Config[types.NodeAttrURL].Default)
+
+	rxConfigure := inputs.NewReceiveInputConfigure(domain, publisher1ID, signer, registeredInputs)
+	rxConfigure.Start()
+	defer rxConfigure.Stop()
+	configAddr := inputs.MakeInputConfigureAddress(domain, publisher1ID, node1ID, types.InputTypeImage, types.DefaultInputInstance)
+	assert.Equal(t, domain+"/"+publisher1ID+"/"+node1ID+"/image/0/$configure", configAddr)
+
+	// the discovery address of the input is accepted as destination. Non-configuration attributes are ignored.
+	attr := types.NodeAttrMap{types.NodeAttrURL: url2, types.NodeAttrPollInterval: "10", types.NodeAttrLoginName: "user"}
+	err = inputs.PublishInputConfigure(input.Address, attr, sender, signer, &privKey.PublicKey)
+	require.NoError(t, err)
+	input2 := registeredInputs.GetInputByID(input.InputID)
+	require.NotNil(t, input2)
+	assert.Equal(t, url2, input2.Attr[types.NodeAttrURL])
+	assert.Equal(t, "10", input2.Attr[types.NodeAttrPollInterval])
+	assert.Empty(t, input2.Attr[types.NodeAttrLoginName])
+
+	// the handler decides which configuration is applied
+	var handled types.NodeAttrMap
+	rxConfigure.SetConfigureInputHandler(func(input *types.InputDiscoveryMessage, params types.NodeAttrMap) {
+		handled = params
+	})
+	err = inputs.PublishInputConfigure(configAddr, types.NodeAttrMap{types.NodeAttrURL: url1}, sender, signer, &privKey.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, url1, handled[types.NodeAttrURL])
+	assert.Equal(t, url2, registeredInputs.GetInputByID(input.InputID).Attr[types.NodeAttrURL])
+	rxConfigure.SetConfigureInputHandler(nil)
+
+	// add a configuration attribute
+	err = registeredInputs.UpdateInputConfig(input.InputID, types.NodeAttrLoginName, &types.ConfigAttr{DataType: types.DataTypeString})
+	assert.NoError(t, err)
+	inputs.PublishInputConfigure(configAddr, attr, sender, signer, &privKey.PublicKey)
+	assert.Equal(t, "user", registeredInputs.GetInputByID(input.InputID).Attr[types.NodeAttrLoginName])
+
+	// error case - unencrypted commands and unknown inputs aren't applied
+	signer.PublishObject(configAddr, false, types.InputConfigureMessage{
+		Address: configAddr, Attr: types.NodeAttrMap{types.NodeAttrURL: url1}, Sender: sender}, nil)
+	assert.Equal(t, url2, registeredInputs.GetInputByID(input.InputID).Attr[types.NodeAttrURL])
+	unknownAddr := inputs.MakeInputConfigureAddress(domain, publisher1ID, "node2", types.InputTypeImage, types.DefaultInputInstance)
+	err = inputs.PublishInputConfigure(unknownAddr, attr, sender, signer, &privKey.PublicKey)
+	assert.NoError(t, err)
+	err = inputs.PublishInputConfigure(domain+"/"+publisher1ID, attr, sender, signer, &privKey.PublicKey)
+	assert.Error(t, err)
+	err = registeredInputs.UpdateInputConfig("notaninput", types.NodeAttrURL, &types.ConfigAttr{})
+	assert.Error(t, err)
+}
@@ -2,11 +2,12 @@
 package inputs
 
 import (
+	"reflect"
 	"sync"
-	"time"
 
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
 )
 
 // // InputSubscription with handler of subscriber to input updates
@@ -23,11 +24,19 @@ type RegisteredInputs struct {
 	domain            string                                  // the domain of this publisher
 	publisherID       string                                  // the registered publisher for the inputs
 	addressMap        map[string]string                       // lookup inputID by publication address
+	disabledNodes     map[string]bool                         // hardware IDs of nodes whose inputs ignore commands
+	dryRun            bool                                    // commands are logged instead of passed to the handlers
 	inputsByHWID      map[string]*types.InputDiscoveryMessage // lookup input by inputHWID
+	nodeIndex         lib.RegistryIndex                       // inputHWIDs by node HWID
+	publishedHashes   map[string]string                       // content hash of the last published input by inputHWID
+	sourceIndex       lib.RegistryIndex                       // inputHWIDs by input source
+	typeIndex         lib.RegistryIndex                       // inputHWIDs by input type
 	updatedInputHWIDs map[string]string                       // inputHWIDs of inputs that have been rediscovered/updated
-	updateMutex       *sync.Mutex                             // mutex for async handling of inputs
+	updateMutex       *sync.RWMutex                           // mutex for async handling of inputs
 	// notification handlers by inputID
 	handlers map[string]func(input *types.InputDiscoveryMessage, sender string, value string)
+	// fallback handlers of inputs without their own handler, by node, input type and instance
+	routedHandlers map[string]func(input *types.InputDiscoveryMessage, sender string, value string)
 }
 
 // CreateInput creates and registers a new input with optional handler for input trigger
@@ -50,7 +59,7 @@ func (regInputs *RegisteredInputs) CreateInputWithSource(
 	input := NewInput(regInputs.domain, regInputs.publisherID, nodeHWID, inputType, instance)
 	input.Source = source
 
-	regInputs.updateInput(input, handler)
+	regInputs.updateInput(input, handler, types.ChangeReasonCreated)
 	return input
 }
 
@@ -62,8 +71,12 @@ func (regInputs *RegisteredInputs) DeleteInput(inputHWID string) {
 	defer regInputs.updateMutex.Unlock()
 
 	// inputAddr := MakeInputDiscoveryAddress(regInputs.domain, regInputs.publisherID, nodeID, inputType, instance)
+	if input := regInputs.inputsByHWID[inputHWID]; input != nil {
+		regInputs.removeFromIndexes(input)
+	}
 	delete(regInputs.inputsByHWID, inputHWID)
 	delete(regInputs.handlers, inputHWID)
+	delete(regInputs.publishedHashes, inputHWID)
 	if regInputs.updatedInputHWIDs == nil {
 		regInputs.updatedInputHWIDs = make(map[string]string)
 	}
@@ -73,8 +86,8 @@ func (regInputs *RegisteredInputs) DeleteInput(inputHWID string) {
 
 // GetAllInputs returns the list of inputs
 func (regInputs *RegisteredInputs) GetAllInputs() []*types.InputDiscoveryMessage {
-	regInputs.updateMutex.Lock()
-	defer regInputs.updateMutex.Unlock()
+	regInputs.updateMutex.RLock()
+	defer regInputs.updateMutex.RUnlock()
 
 	var inputList = make([]*types.InputDiscoveryMessage, 0)
 	for _, output := range regInputs.inputsByHWID {
@@ -86,8 +99,8 @@ func (regInputs *RegisteredInputs) GetAllInputs() []*types.InputDiscoveryMessage
 // GetInputByAddress returns an input by its publication address
 // Returns nil if address has no known input
 func (regInputs *RegisteredInputs) GetInputByAddress(inputAddr string) *types.InputDiscoveryMessage {
-	regInputs.updateMutex.Lock()
-	defer regInputs.updateMutex.Unlock()
+	regInputs.updateMutex.RLock()
+	defer regInputs.updateMutex.RUnlock()
 	inputID := regInputs.addressMap[inputAddr]
 	input := regInputs.inputsByHWID[inputID]
 	return input
@@ -102,24 +115,25 @@ func (regInputs *RegisteredInputs) GetInputByNodeHWID(
 	return regInputs.GetInputByID(inputID)
 }
 
-// GetInputsByNodeHWID returns a list of all inputs that are part of the owning node
+// GetInputsByNodeHWID returns a list of all inputs that are part of the owning node, ordered by input ID
 func (regInputs *RegisteredInputs) GetInputsByNodeHWID(nodeHWID string) []*types.InputDiscoveryMessage {
-	inputList := make([]*types.InputDiscoveryMessage, 0)
-	regInputs.updateMutex.Lock()
-	defer regInputs.updateMutex.Unlock()
-	for _, input := range regInputs.inputsByHWID {
-		if input.NodeHWID == nodeHWID {
-			inputList = append(inputList, input)
-		}
-	}
-	return inputList
+	regInputs.updateMutex.RLock()
+	defer regInputs.updateMutex.RUnlock()
+	return regInputs.getIndexedInputs(regInputs.nodeIndex, nodeHWID)
+}
+
+// GetInputsByType returns a list of all inputs of a given type, ordered by input ID
+func (regInputs *RegisteredInputs) GetInputsByType(inputType types.InputType) []*types.InputDiscoveryMessage {
+	regInputs.updateMutex.RLock()
+	defer regInputs.updateMutex.RUnlock()
+	return regInputs.getIndexedInputs(regInputs.typeIndex, string(inputType))
 }
 
 // GetInputByID returns an input by its input ID (nodeHWID.type.instance)
 // Returns nil if there is no known input
 func (regInputs *RegisteredInputs) GetInputByID(inputID string) *types.InputDiscoveryMessage {
-	regInputs.updateMutex.Lock()
-	defer regInputs.updateMutex.Unlock()
+	regInputs.updateMutex.RLock()
+	defer regInputs.updateMutex.RUnlock()
 	var input = regInputs.inputsByHWID[inputID]
 	return input
 }
@@ -128,9 +142,17 @@ func (regInputs *RegisteredInputs) GetInputByID(inputID string) *types.InputDisc
 // The source is used for inputs that are files, http poll addresses or other outputs. It is not
 // used with set input commands.
 func (regInputs *RegisteredInputs) GetInputsWithSource(source string) []*types.InputDiscoveryMessage {
-	inputList := make([]*types.InputDiscoveryMessage, 0)
-	for _, input := range regInputs.inputsByHWID {
-		if input.Source == source {
+	regInputs.updateMutex.RLock()
+	defer regInputs.updateMutex.RUnlock()
+	return regInputs.getIndexedInputs(regInputs.sourceIndex, source)
+}
+
+// getIndexedInputs returns the inputs with the given key in a secondary index
+// For internal use only. Use within locked section.
+func (regInputs *RegisteredInputs) getIndexedInputs(index lib.RegistryIndex, key string) []*types.InputDiscoveryMessage {
+	inputList := make([]*types.InputDiscoveryMessage, 0, index.Count(key))
+	for _, inputID := range index.IDs(key) {
+		if input := regInputs.inputsByHWID[inputID]; input != nil {
 			inputList = append(inputList, input)
 		}
 	}
@@ -138,6 +160,8 @@ func (regInputs *RegisteredInputs) GetInputsWithSource(source string) []*types.I
 }
 
 // GetUpdatedInputs returns the list of registered inputs that have been updated
+// Inputs whose content is unchanged since their last publication are skipped unless their
+// republication is forced.
 // clear the update on return
 func (regInputs *RegisteredInputs) GetUpdatedInputs(clearUpdates bool) []*types.InputDiscoveryMessage {
 	var updateList []*types.InputDiscoveryMessage = make([]*types.InputDiscoveryMessage, 0)
@@ -147,8 +171,18 @@ func (regInputs *RegisteredInputs) GetUpdatedInputs(clearUpdates bool) []*types.
 	if regInputs.updatedInputHWIDs != nil {
 		for _, inputID := range regInputs.updatedInputHWIDs {
 			input := regInputs.inputsByHWID[inputID]
-			if input != nil {
-				updateList = append(updateList, input)
+			if input == nil {
+				continue
+			}
+			// inputs that are updated without change are only republished when forced
+			hash := MakeInputHash(input)
+			if regInputs.publishedHashes[inputID] == hash &&
+				!types.HasChangeReason(input.ChangeReasons, types.ChangeReasonForced) {
+				continue
+			}
+			updateList = append(updateList, input)
+			if clearUpdates {
+				regInputs.publishedHashes[inputID] = hash
 			}
 		}
 		if clearUpdates {
@@ -161,15 +195,145 @@ func (regInputs *RegisteredInputs) GetUpdatedInputs(clearUpdates bool) []*types.
 // NotifyInputHandler passes a set input command to the input's handler to execute the request.
 // The sender is the identity address of the publisher and can be used for authorization. It is
 // empty for local inputs such as file watcher and http polling.
+// Inputs without their own handler use the most specific handler set with SetInputHandler.
 func (regInputs *RegisteredInputs) NotifyInputHandler(inputID string, sender string, value string) {
 
-	handler := regInputs.handlers[inputID]
 	input := regInputs.GetInputByID(inputID)
+	if input != nil && regInputs.IsNodeDisabled(input.NodeHWID) {
+		logrus.Infof("NotifyInputHandler: Input %s of disabled node is ignored", inputID)
+		return
+	} else if regInputs.IsDryRun() {
+		logrus.Infof("NotifyInputHandler: Dry run. Input %s is not set to '%s' from sender '%s'", inputID, value, sender)
+		return
+	}
+	handler := regInputs.getHandler(inputID, input)
 	if handler != nil {
 		handler(input, sender, value)
 	}
 }
 
+// SetInputHandler sets the handler of set commands for inputs that have no handler of their own.
+// An empty node hardware ID, input type or instance matches any. Handlers are tried from specific
+// to generic: node, type and instance; node and type; node; type; and last the default handler
+// with all parameters empty. Use nil to remove the handler.
+func (regInputs *RegisteredInputs) SetInputHandler(nodeHWID string, inputType types.InputType, instance string,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) {
+
+	key := MakeInputHWID(nodeHWID, inputType, instance)
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	if handler == nil {
+		delete(regInputs.routedHandlers, key)
+	} else {
+		regInputs.routedHandlers[key] = handler
+	}
+}
+
+// getHandler returns the handler of an input, or the most specific handler that is set with
+// SetInputHandler if the input has no handler of its own. Returns nil if no handler is found.
+func (regInputs *RegisteredInputs) getHandler(inputID string,
+	input *types.InputDiscoveryMessage) func(input *types.InputDiscoveryMessage, sender string, value string) {
+
+	regInputs.updateMutex.RLock()
+	defer regInputs.updateMutex.RUnlock()
+	handler := regInputs.handlers[inputID]
+	if handler != nil || input == nil {
+		return handler
+	}
+	keys := []string{
+		MakeInputHWID(input.NodeHWID, input.InputType, input.Instance),
+		MakeInputHWID(input.NodeHWID, input.InputType, ""),
+		MakeInputHWID(input.NodeHWID, "", ""),
+		MakeInputHWID("", input.InputType, ""),
+		MakeInputHWID("", "", ""),
+	}
+	for _, key := range keys {
+		if handler = regInputs.routedHandlers[key]; handler != nil {
+			return handler
+		}
+	}
+	return nil
+}
+
+// IsDryRun returns true if commands are logged instead of passed to the input handlers
+func (regInputs *RegisteredInputs) IsDryRun() bool {
+	regInputs.updateMutex.RLock()
+	defer regInputs.updateMutex.RUnlock()
+	return regInputs.dryRun
+}
+
+// IsNodeDisabled returns true if the inputs of the node are disabled
+func (regInputs *RegisteredInputs) IsNodeDisabled(nodeHWID string) bool {
+	regInputs.updateMutex.RLock()
+	defer regInputs.updateMutex.RUnlock()
+	return regInputs.disabledNodes[nodeHWID]
+}
+
+// SetDryRun sets whether commands are logged instead of passed to the input handlers, eg to test an
+// adapter without affecting its devices
+func (regInputs *RegisteredInputs) SetDryRun(dryRun bool) {
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	regInputs.dryRun = dryRun
+}
+
+// SetNodeDisabled disables or enables the inputs of a node. Inputs of disabled nodes ignore commands.
+func (regInputs *RegisteredInputs) SetNodeDisabled(nodeHWID string, disabled bool) {
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	if disabled {
+		regInputs.disabledNodes[nodeHWID] = true
+	} else {
+		delete(regInputs.disabledNodes, nodeHWID)
+	}
+}
+
+// SetEnumValues sets the valid values of an input, eg "heat", "cool", "auto", "off" for a hvac
+// mode. The input data type becomes enum and set commands with other values are rejected. Use nil
+// to remove the enum values, after which an enum data type becomes string.
+// Returns false if the input doesn't exist.
+func (regInputs *RegisteredInputs) SetEnumValues(inputID string, enumValues []string) bool {
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	existingInput := regInputs.inputsByHWID[inputID]
+	if existingInput == nil {
+		return false
+	}
+	dataType := types.DataTypeEnum
+	if len(enumValues) == 0 {
+		enumValues = nil
+		dataType = existingInput.DataType
+		if dataType == types.DataTypeEnum {
+			dataType = types.DataTypeString
+		}
+	}
+	if existingInput.DataType != dataType || !reflect.DeepEqual(existingInput.EnumValues, enumValues) {
+		newInput := cloneInput(existingInput)
+		newInput.DataType = dataType
+		newInput.EnumValues = append([]string(nil), enumValues...)
+		regInputs.updateInput(newInput, nil, types.ChangeReasonAttr)
+	}
+	return true
+}
+
+// SetLocalOnly sets whether an input is for internal use only. Local-only inputs are not published.
+// An input that is no longer local-only is republished.
+// Returns false if the input doesn't exist.
+func (regInputs *RegisteredInputs) SetLocalOnly(inputID string, localOnly bool) bool {
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	existingInput := regInputs.inputsByHWID[inputID]
+	if existingInput == nil {
+		return false
+	}
+	if existingInput.LocalOnly != localOnly {
+		newInput := cloneInput(existingInput)
+		newInput.LocalOnly = localOnly
+		regInputs.updateInput(newInput, nil, types.ChangeReasonForced)
+	}
+	return true
+}
+
 // SetNodeID changes the publication address of all inputs that belong to the device hardware address
 func (regInputs *RegisteredInputs) SetNodeID(nodeHWID string, newNodeID string) {
 	inputList := regInputs.GetInputsByNodeHWID(nodeHWID)
@@ -179,11 +343,52 @@ func (regInputs *RegisteredInputs) SetNodeID(nodeHWID string, newNodeID string)
 		input.Address = newAddress
 		// input.NodeID = newNodeID
 		regInputs.updateMutex.Lock()
-		regInputs.updateInput(input, nil)
+		regInputs.updateInput(input, nil, types.ChangeReasonAttr)
 		regInputs.updateMutex.Unlock()
 	}
 }
 
+// UpdateInputConfig adds or replaces a configuration attribute of an input, so its value can be
+// changed with an input configure command.
+// Returns an error if the input doesn't exist.
+func (regInputs *RegisteredInputs) UpdateInputConfig(inputID string, attrName types.NodeAttr, configAttr *types.ConfigAttr) error {
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	existingInput := regInputs.inputsByHWID[inputID]
+	if existingInput == nil || configAttr == nil || attrName == "" {
+		return lib.MakeErrorf("UpdateInputConfig: input '%s' does not exist or missing configuration", inputID)
+	}
+	newInput := cloneInput(existingInput)
+	newInput.Config[attrName] = *configAttr
+	regInputs.updateInput(newInput, nil, types.ChangeReasonConfig)
+	return nil
+}
+
+// UpdateInputConfigValues updates the configuration values of an input. Attributes that are not a
+// configuration of the input are ignored. The input is only republished if a value changes.
+// returns true when the input has changed, false if the input doesn't exist or values haven't changed
+func (regInputs *RegisteredInputs) UpdateInputConfigValues(inputID string, params types.NodeAttrMap) (changed bool) {
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	existingInput := regInputs.inputsByHWID[inputID]
+	if existingInput == nil {
+		return false
+	}
+	newInput := cloneInput(existingInput)
+	for key, newValue := range params {
+		if _, configExists := existingInput.Config[key]; !configExists {
+			logrus.Warningf("UpdateInputConfigValues: Input '%s', attribute '%s' is not a configuration", inputID, key)
+		} else if oldValue, attrExists := existingInput.Attr[key]; !attrExists || oldValue != newValue {
+			newInput.Attr[key] = newValue
+			changed = true
+		}
+	}
+	if changed {
+		regInputs.updateInput(newInput, nil, types.ChangeReasonConfig)
+	}
+	return changed
+}
+
 // UpdateInput replaces an existing input with the provided input.
 // The input must already exist and be created using 'CreateInput', otherwise it returns an error
 func (regInputs *RegisteredInputs) UpdateInput(input *types.InputDiscoveryMessage) error {
@@ -194,19 +399,49 @@ func (regInputs *RegisteredInputs) UpdateInput(input *types.InputDiscoveryMessag
 	if existingInput == nil {
 		return lib.MakeErrorf("UpdateInput: input '%s' does not exist", input.InputID)
 	}
-	regInputs.updateInput(input, nil)
+	regInputs.updateInput(input, nil, types.ChangeReasonAttr)
+	return nil
+}
+
+// RepublishInput marks an existing input as updated without change, so it is republished with
+// the next publication of updates, eg to refresh the discovery.
+func (regInputs *RegisteredInputs) RepublishInput(input *types.InputDiscoveryMessage) error {
+
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	existingInput := regInputs.inputsByHWID[input.InputID]
+	if existingInput == nil {
+		return lib.MakeErrorf("RepublishInput: input '%s' does not exist", input.InputID)
+	}
+	regInputs.updateInput(input, nil, types.ChangeReasonForced)
 	return nil
 }
 
 // updateInput replaces an existing input or adds the provided input.
 // If the input doesn't exist it will be added. The input is also added to the updatedInputs map
 // The handler for this input will be stored if provided. Use nil to retain the existing handler.
+// The reason is added to the reasons of a pending update of the input, if any.
 // Use within a locked section.
 func (regInputs *RegisteredInputs) updateInput(input *types.InputDiscoveryMessage,
-	handler func(input *types.InputDiscoveryMessage, sender string, value string)) {
+	handler func(input *types.InputDiscoveryMessage, sender string, value string), reason types.ChangeReason) {
 
+	var pendingReasons []types.ChangeReason
+	if _, isPending := regInputs.updatedInputHWIDs[input.InputID]; isPending {
+		if pendingInput := regInputs.inputsByHWID[input.InputID]; pendingInput != nil {
+			pendingReasons = pendingInput.ChangeReasons
+		}
+	}
+	input.ChangeReasons = types.AddChangeReason(pendingReasons, reason)
+	if existing := regInputs.inputsByHWID[input.InputID]; existing != nil {
+		regInputs.removeFromIndexes(existing)
+	}
 	regInputs.inputsByHWID[input.InputID] = input
 	regInputs.addressMap[input.Address] = input.InputID
+	regInputs.nodeIndex.Add(input.NodeHWID, input.InputID)
+	regInputs.typeIndex.Add(string(input.InputType), input.InputID)
+	if input.Source != "" {
+		regInputs.sourceIndex.Add(input.Source, input.InputID)
+	}
 	if handler != nil {
 		regInputs.handlers[input.InputID] = handler
 	}
@@ -214,10 +449,54 @@ func (regInputs *RegisteredInputs) updateInput(input *types.InputDiscoveryMessag
 	if regInputs.updatedInputHWIDs == nil {
 		regInputs.updatedInputHWIDs = make(map[string]string)
 	}
-	input.Timestamp = time.Now().Format(types.TimeFormat)
+	input.Timestamp = lib.Now().Format(types.TimeFormat)
 	regInputs.updatedInputHWIDs[input.InputID] = input.InputID
 }
 
+// removeFromIndexes removes an input from the secondary indexes
+// For internal use only. Use within locked section.
+func (regInputs *RegisteredInputs) removeFromIndexes(input *types.InputDiscoveryMessage) {
+	regInputs.nodeIndex.Remove(input.NodeHWID, input.InputID)
+	regInputs.typeIndex.Remove(string(input.InputType), input.InputID)
+	regInputs.sourceIndex.Remove(input.Source, input.InputID)
+}
+
+// cloneInput returns a copy of an input with its own attribute and configuration maps
+func cloneInput(input *types.InputDiscoveryMessage) *types.InputDiscoveryMessage {
+	newInput := *input
+	newInput.Attr = make(types.NodeAttrMap)
+	for key, value := range input.Attr {
+		newInput.Attr[key] = value
+	}
+	newInput.Config = make(types.ConfigAttrMap)
+	for key, value := range input.Config {
+		newInput.Config[key] = value
+	}
+	return &newInput
+}
+
+// ValidateValue returns an error if the value is not valid for the input, eg it isn't one of the
+// enum values of an enum input. Values of unknown inputs are not validated.
+func (regInputs *RegisteredInputs) ValidateValue(inputID string, value string) error {
+	regInputs.updateMutex.RLock()
+	defer regInputs.updateMutex.RUnlock()
+	input := regInputs.inputsByHWID[inputID]
+	if input != nil && input.DataType == types.DataTypeEnum && !types.IsValidEnumValue(input.EnumValues, value) {
+		return lib.MakeErrorf("ValidateValue: Value '%s' is not one of the enum values %v of input %s",
+			value, input.EnumValues, inputID)
+	}
+	return nil
+}
+
+// MakeInputHash returns the hash of the content of an input. The timestamp and change reasons are
+// excluded as they change with each update.
+func MakeInputHash(input *types.InputDiscoveryMessage) string {
+	content := *input
+	content.ChangeReasons = nil
+	content.Timestamp = ""
+	return lib.MakeContentHash(&content)
+}
+
 // MakeInputHWID creates the internal ID to identify the input of the owning node using its HWID
 func MakeInputHWID(nodeHWID string, inputType types.InputType, instance string) string {
 	inputID := nodeHWID + "." + string(inputType) + "." + instance
@@ -236,7 +515,7 @@ func NewInput(
 		Address:   address,
 		Attr:      make(types.NodeAttrMap),
 		Config:    make(types.ConfigAttrMap),
-		Timestamp: time.Now().Format(types.TimeFormat),
+		Timestamp: lib.Now().Format(types.TimeFormat),
 		// internal use only
 		InputID:     inputHWID,
 		NodeHWID:    nodeHWID,
@@ -251,12 +530,18 @@ func NewInput(
 func NewRegisteredInputs(domain string, publisherID string) *RegisteredInputs {
 
 	regInputs := &RegisteredInputs{
-		domain:       domain,
-		publisherID:  publisherID,
-		addressMap:   make(map[string]string),
-		inputsByHWID: make(map[string]*types.InputDiscoveryMessage),
-		handlers:     make(map[string]func(input *types.InputDiscoveryMessage, sender string, newValue string)),
-		updateMutex:  &sync.Mutex{},
+		domain:          domain,
+		publisherID:     publisherID,
+		addressMap:      make(map[string]string),
+		disabledNodes:   make(map[string]bool),
+		inputsByHWID:    make(map[string]*types.InputDiscoveryMessage),
+		nodeIndex:       make(lib.RegistryIndex),
+		publishedHashes: make(map[string]string),
+		sourceIndex:     make(lib.RegistryIndex),
+		typeIndex:       make(lib.RegistryIndex),
+		handlers:        make(map[string]func(input *types.InputDiscoveryMessage, sender string, newValue string)),
+		routedHandlers:  make(map[string]func(input *types.InputDiscoveryMessage, sender string, newValue string)),
+		updateMutex:     &sync.RWMutex{},
 	}
 	return regInputs
 }
@@ -60,6 +60,28 @@ func TestNewRegisteredInput(t *testing.T) {
 
 }
 
+func TestInputIndexes(t *testing.T) {
+	collection := inputs.NewRegisteredInputs(domain, publisher1ID)
+	for count := 0; count < 100; count++ {
+		collection.CreateInput(fmt.Sprintf("node%d", count%10), types.InputTypeSwitch, fmt.Sprint(count), nil)
+	}
+	input := collection.CreateInputWithSource(node1ID, types.InputTypeImage, "0", "http://camera1/image.jpg", nil)
+	assert.Equal(t, 10, len(collection.GetInputsByNodeHWID("node0")))
+	assert.Equal(t, 100, len(collection.GetInputsByType(types.InputTypeSwitch)))
+	assert.Equal(t, []*types.InputDiscoveryMessage{input}, collection.GetInputsWithSource("http://camera1/image.jpg"))
+
+	// a replaced input with a new source is only found by its new source
+	collection.CreateInputWithSource(node1ID, types.InputTypeImage, "0", "http://camera2/image.jpg", nil)
+	assert.Empty(t, collection.GetInputsWithSource("http://camera1/image.jpg"))
+	assert.Equal(t, 1, len(collection.GetInputsWithSource("http://camera2/image.jpg")))
+
+	// deleted inputs are removed from the indexes
+	collection.DeleteInput(input.InputID)
+	assert.Empty(t, collection.GetInputsWithSource("http://camera2/image.jpg"))
+	assert.Empty(t, collection.GetInputsByType(types.InputTypeImage))
+	assert.Equal(t, 10, len(collection.GetInputsByNodeHWID(node1ID)))
+}
+
 func TestUpdateInput(t *testing.T) {
 	collection := inputs.NewRegisteredInputs(domain, publisher1ID)
 
@@ -89,6 +111,29 @@ func TestUpdateInput(t *testing.T) {
 	assert.Equal(t, "hello", input1b.Source, "Updating input not successful")
 }
 
+func TestUpdateUnchangedInput(t *testing.T) {
+	collection := inputs.NewRegisteredInputs(domain, publisher1ID)
+	input1 := collection.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance, nil)
+	updated := collection.GetUpdatedInputs(true)
+	require.Len(t, updated, 1)
+
+	// an update without change is not published
+	collection.UpdateInput(input1)
+	updated = collection.GetUpdatedInputs(true)
+	assert.Len(t, updated, 0, "Unchanged input should not be published")
+
+	// a forced republication is
+	collection.RepublishInput(input1)
+	updated = collection.GetUpdatedInputs(true)
+	assert.Len(t, updated, 1, "Forced republication should be published")
+
+	input1b := *input1
+	input1b.Source = "hello"
+	collection.UpdateInput(&input1b)
+	updated = collection.GetUpdatedInputs(true)
+	assert.Len(t, updated, 1, "Changed input should be published")
+}
+
 func TestChangeNodeID(t *testing.T) {
 	const newNodeId = "bob"
 	collection := inputs.NewRegisteredInputs(domain, publisher1ID)
@@ -104,6 +149,44 @@ func TestChangeNodeID(t *testing.T) {
 	assert.Equal(t, newInputAddr, input1c.Address, "Input doesn't have the new NodeID")
 }
 
+func TestRoutedInputHandlers(t *testing.T) {
+	collection := inputs.NewRegisteredInputs(domain, publisher1ID)
+	handled := make(map[string]string)
+	makeHandler := func(name string) func(input *types.InputDiscoveryMessage, sender string, value string) {
+		return func(input *types.InputDiscoveryMessage, sender string, value string) {
+			handled[input.InputID] = name
+		}
+	}
+	own := collection.CreateInput(node1ID, types.InputTypeSwitch, "own", makeHandler("own"))
+	exact := collection.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance, nil)
+	nodeType := collection.CreateInput(node1ID, types.InputTypeSwitch, "1", nil)
+	node := collection.CreateInput(node1ID, types.InputTypeChannel, types.DefaultInputInstance, nil)
+	inputType := collection.CreateInput(node2ID, types.InputTypeSwitch, types.DefaultInputInstance, nil)
+	other := collection.CreateInput(node2ID, types.InputTypeChannel, types.DefaultInputInstance, nil)
+	allInputs := []*types.InputDiscoveryMessage{own, exact, nodeType, node, inputType, other}
+
+	// without default handler inputs without handler are not handled
+	collection.SetInputHandler(node1ID, types.InputTypeSwitch, types.DefaultInputInstance, makeHandler("exact"))
+	collection.SetInputHandler(node1ID, types.InputTypeSwitch, "", makeHandler("nodeType"))
+	collection.SetInputHandler(node1ID, "", "", makeHandler("node"))
+	collection.SetInputHandler("", types.InputTypeSwitch, "", makeHandler("type"))
+	for _, input := range allInputs {
+		collection.NotifyInputHandler(input.InputID, "", "on")
+	}
+	assert.Equal(t, map[string]string{
+		own.InputID: "own", exact.InputID: "exact", nodeType.InputID: "nodeType",
+		node.InputID: "node", inputType.InputID: "type",
+	}, handled)
+
+	// the default handler handles the remaining inputs
+	collection.SetInputHandler("", "", "", makeHandler("default"))
+	collection.SetInputHandler(node1ID, "", "", nil)
+	collection.NotifyInputHandler(node.InputID, "", "on")
+	collection.NotifyInputHandler(other.InputID, "", "on")
+	assert.Equal(t, "default", handled[node.InputID])
+	assert.Equal(t, "default", handled[other.InputID])
+}
+
 func TestPublish(t *testing.T) {
 
 	var privKey = messaging.CreateAsymKeys()
@@ -123,3 +206,19 @@ func TestPublish(t *testing.T) {
 
 	inputs.PublishRegisteredInputs(allInputs, signer)
 }
+
+func TestDryRunInputs(t *testing.T) {
+	collection := inputs.NewRegisteredInputs(domain, publisher1ID)
+	values := make([]string, 0)
+	input := collection.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			values = append(values, value)
+		})
+	collection.SetDryRun(true)
+	assert.True(t, collection.IsDryRun())
+	collection.NotifyInputHandler(input.InputID, "", "on")
+	assert.Empty(t, values)
+	collection.SetDryRun(false)
+	collection.NotifyInputHandler(input.InputID, "", "off")
+	assert.Equal(t, []string{"off"}, values)
+}
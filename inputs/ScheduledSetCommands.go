@@ -0,0 +1,150 @@
+// Package inputs with set commands that are applied at a later time
+package inputs
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// CancelScheduledCommand removes a scheduled set command so it is not applied
+// Returns false if no command with the message ID is scheduled.
+func (ifset *ReceiveFromSetCommands) CancelScheduledCommand(messageID string) bool {
+	ifset.updateMutex.Lock()
+	defer ifset.updateMutex.Unlock()
+	for i, command := range ifset.scheduledCommands {
+		if command.MessageID == messageID {
+			ifset.scheduledCommands = append(ifset.scheduledCommands[:i], ifset.scheduledCommands[i+1:]...)
+			ifset.saveScheduledCommands()
+			return true
+		}
+	}
+	return false
+}
+
+// ExecuteScheduledCommands passes the scheduled set commands whose time has come to the handler of
+// their input. Invoke this periodically.
+func (ifset *ReceiveFromSetCommands) ExecuteScheduledCommands() {
+	now := lib.Now()
+	dueList := make([]*types.SetInputMessage, 0)
+	ifset.updateMutex.Lock()
+	pending := make([]*types.SetInputMessage, 0, len(ifset.scheduledCommands))
+	for _, command := range ifset.scheduledCommands {
+		executeAt, _ := time.Parse(types.TimeFormat, command.ExecuteAt)
+		if !now.Before(executeAt) {
+			dueList = append(dueList, command)
+		} else {
+			pending = append(pending, command)
+		}
+	}
+	if len(dueList) > 0 {
+		ifset.scheduledCommands = pending
+		ifset.saveScheduledCommands()
+	}
+	ifset.updateMutex.Unlock()
+
+	for _, command := range dueList {
+		// domain/pub/node/inputtype/instance/$set
+		setAddr, err := types.ParseAddress(command.Address)
+		if err != nil || setAddr.IOType() == "" {
+			continue
+		}
+		input := ifset.registeredInputs.GetInputByAddress(setAddr.WithMessageType(types.MessageTypeInputDiscovery).String())
+		if input == nil {
+			logrus.Warningf("ExecuteScheduledCommands: Input of command %s to %s no longer exists. Command discarded.",
+				command.MessageID, command.Address)
+			continue
+		}
+		logrus.Infof("ExecuteScheduledCommands: Applying command %s to input %s scheduled at %s",
+			command.MessageID, command.Address, command.ExecuteAt)
+		ifset.registeredInputs.NotifyInputHandler(input.InputID, command.Sender, command.Value)
+	}
+}
+
+// GetScheduledCommands returns a copy of the set commands that are scheduled, earliest first
+func (ifset *ReceiveFromSetCommands) GetScheduledCommands() []types.SetInputMessage {
+	ifset.updateMutex.Lock()
+	defer ifset.updateMutex.Unlock()
+	commands := make([]types.SetInputMessage, 0, len(ifset.scheduledCommands))
+	for _, command := range ifset.scheduledCommands {
+		commands = append(commands, *command)
+	}
+	return commands
+}
+
+// LoadScheduledCommands loads the scheduled set commands from file and persists changes to the
+// schedule in this file, so scheduled commands are applied after a restart. Commands whose time has
+// passed while stopped are applied with the next ExecuteScheduledCommands.
+// A missing file is not an error.
+func (ifset *ReceiveFromSetCommands) LoadScheduledCommands(filename string) error {
+	ifset.updateMutex.Lock()
+	defer ifset.updateMutex.Unlock()
+	ifset.scheduleFile = filename
+
+	commandList := make([]*types.SetInputMessage, 0)
+	jsonText, err := lib.ReadFileWithRecovery(filename, lib.ValidateJSON)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return lib.MakeErrorf("LoadScheduledCommands: Unable to open file %s: %s", filename, err)
+	}
+	err = json.Unmarshal(jsonText, &commandList)
+	if err != nil {
+		return lib.MakeErrorf("LoadScheduledCommands: Error parsing JSON schedule file %s: %s", filename, err)
+	}
+	for _, command := range commandList {
+		ifset.addScheduledCommand(command)
+	}
+	logrus.Infof("LoadScheduledCommands: Loaded %d scheduled commands from %s", len(commandList), filename)
+	return nil
+}
+
+// addScheduledCommand inserts a command in the schedule ordered by execution time. Commands with
+// the same time are applied in the order they were received.
+// This must be called with the update mutex locked.
+func (ifset *ReceiveFromSetCommands) addScheduledCommand(command *types.SetInputMessage) {
+	executeAt, _ := time.Parse(types.TimeFormat, command.ExecuteAt)
+	i := sort.Search(len(ifset.scheduledCommands), func(i int) bool {
+		other, _ := time.Parse(types.TimeFormat, ifset.scheduledCommands[i].ExecuteAt)
+		return other.After(executeAt)
+	})
+	ifset.scheduledCommands = append(ifset.scheduledCommands, nil)
+	copy(ifset.scheduledCommands[i+1:], ifset.scheduledCommands[i:])
+	ifset.scheduledCommands[i] = command
+}
+
+// saveScheduledCommands saves the scheduled commands to the schedule file, replacing the previous
+// file atomically. This does nothing if the schedule isn't persisted.
+// This must be called with the update mutex locked.
+func (ifset *ReceiveFromSetCommands) saveScheduledCommands() error {
+	if ifset.scheduleFile == "" {
+		return nil
+	}
+	jsonText, err := json.MarshalIndent(ifset.scheduledCommands, "", "  ")
+	if err != nil {
+		return lib.MakeErrorf("saveScheduledCommands: Error marshalling schedule: %s", err)
+	}
+	err = os.MkdirAll(path.Dir(ifset.scheduleFile), 0750)
+	if err == nil {
+		err = lib.WriteFileAtomic(ifset.scheduleFile, jsonText, 0600)
+	}
+	if err != nil {
+		err = lib.MakeErrorf("saveScheduledCommands: Error saving schedule to %s: %s", ifset.scheduleFile, err)
+		logrus.Error(err)
+	}
+	return err
+}
+
+// scheduleSetCommand queues a set command to be applied at its execution time and persists the schedule
+func (ifset *ReceiveFromSetCommands) scheduleSetCommand(command *types.SetInputMessage) {
+	ifset.updateMutex.Lock()
+	defer ifset.updateMutex.Unlock()
+	ifset.addScheduledCommand(command)
+	ifset.saveScheduledCommands()
+}
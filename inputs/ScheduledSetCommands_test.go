@@ -0,0 +1,77 @@
+package inputs_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduledSetCommand(t *testing.T) {
+	const input1Type = types.InputTypeSwitch
+	var setInput1Addr = inputs.MakeSetInputAddress(domain, publisher1ID, node1ID, input1Type, types.DefaultInputInstance)
+	var senderAddr = fmt.Sprintf("%s/publisher2", domain)
+	received := ""
+
+	scheduleFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(scheduleFolder)
+	scheduleFile := path.Join(scheduleFolder, "schedule.json")
+
+	msgr := messaging.NewDummyMessenger(nil)
+	signer := messaging.NewMessageSigner(msgr, privKey, getPublisherKey)
+	registeredInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+	receiver := inputs.NewReceiveFromSetCommands(domain, publisher1ID, signer, registeredInputs)
+	handler := func(input *types.InputDiscoveryMessage, sender string, value string) {
+		received = value
+	}
+	receiver.CreateInput(node1ID, input1Type, types.DefaultInputInstance, handler)
+	err = receiver.LoadScheduledCommands(scheduleFile)
+	require.NoError(t, err)
+
+	// a command with an execution time in the past is applied immediately
+	err = inputs.PublishScheduledSetInput(setInput1Addr, "on", time.Now().Add(-time.Second), "msg1",
+		senderAddr, signer, &privKey.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, "on", received)
+
+	// a command with an execution time in the future is scheduled and persisted
+	executeAt := time.Now().Add(100 * time.Millisecond)
+	err = inputs.PublishScheduledSetInput(setInput1Addr, "off", executeAt.Add(time.Hour), "msg2",
+		senderAddr, signer, &privKey.PublicKey)
+	require.NoError(t, err)
+	err = inputs.PublishScheduledSetInput(setInput1Addr, "off", executeAt, "msg3",
+		senderAddr, signer, &privKey.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, "on", received)
+	scheduled := receiver.GetScheduledCommands()
+	require.Len(t, scheduled, 2)
+	assert.Equal(t, "msg3", scheduled[0].MessageID, "Expected the earliest command first")
+	receiver.ExecuteScheduledCommands()
+	assert.Equal(t, "on", received)
+
+	// the schedule is restored after a restart
+	receiver2 := inputs.NewReceiveFromSetCommands(domain, publisher1ID, signer, registeredInputs)
+	err = receiver2.LoadScheduledCommands(scheduleFile)
+	require.NoError(t, err)
+	assert.Len(t, receiver2.GetScheduledCommands(), 2)
+
+	// a command is applied when its time has come
+	time.Sleep(150 * time.Millisecond)
+	receiver2.ExecuteScheduledCommands()
+	assert.Equal(t, "off", received)
+	assert.Len(t, receiver2.GetScheduledCommands(), 1)
+
+	// a scheduled command can be cancelled
+	assert.True(t, receiver2.CancelScheduledCommand("msg2"))
+	assert.False(t, receiver2.CancelScheduledCommand("msg2"))
+	assert.Len(t, receiver2.GetScheduledCommands(), 0)
+}
@@ -0,0 +1,26 @@
+// Package perftest with the opt-in of tests that enforce a performance budget
+package perftest
+
+import (
+	"os"
+	"testing"
+)
+
+// EnvPerfBudget is the environment variable that enables the performance budget tests, eg
+// IOTDOMAIN_PERF_BUDGET=1 go test ./...
+// The budgets depend on the machine so they are not enforced by default, to keep the tests
+// reliable on loaded CI machines.
+const EnvPerfBudget = "IOTDOMAIN_PERF_BUDGET"
+
+// SkipUnlessEnabled skips a performance budget test unless it is enabled with EnvPerfBudget.
+// The test is also skipped in short mode and when the race detector is enabled, as the race
+// detector slows down the code under test several times.
+func SkipUnlessEnabled(t *testing.T) {
+	if os.Getenv(EnvPerfBudget) == "" {
+		t.Skipf("The performance budget is only enforced with %s set", EnvPerfBudget)
+	} else if testing.Short() {
+		t.Skip("The performance budget is not enforced in short mode")
+	} else if raceEnabled {
+		t.Skip("The performance budget is not enforced with the race detector")
+	}
+}
@@ -0,0 +1,345 @@
+// Package inventory with a SQL database backed store of the domain publishers, nodes and outputs
+package inventory
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultDriver is the database/sql driver name of the SQLite driver, eg github.com/mattn/go-sqlite3
+const DefaultDriver = "sqlite3"
+
+// Inventory tables
+const (
+	TablePublishers   = "publishers"    // publisher identities
+	TableNodes        = "nodes"         // node discovery
+	TableOutputs      = "outputs"       // output discovery
+	TableOutputValues = "output_values" // latest output values
+)
+
+// createTables defines the inventory tables. Addresses are without the message type suffix and the
+// json column holds the received message.
+var createTables = []string{
+	"CREATE TABLE IF NOT EXISTS " + TablePublishers + " (" +
+		"address TEXT PRIMARY KEY, domain TEXT, publisher_id TEXT, issuer_id TEXT, " +
+		"valid_until TEXT, timestamp TEXT, json TEXT)",
+	"CREATE TABLE IF NOT EXISTS " + TableNodes + " (" +
+		"address TEXT PRIMARY KEY, publisher_id TEXT, node_id TEXT, hw_id TEXT, " +
+		"node_type TEXT, name TEXT, timestamp TEXT, json TEXT)",
+	"CREATE TABLE IF NOT EXISTS " + TableOutputs + " (" +
+		"address TEXT PRIMARY KEY, publisher_id TEXT, node_id TEXT, output_type TEXT, " +
+		"instance TEXT, data_type TEXT, unit TEXT, timestamp TEXT, json TEXT)",
+	"CREATE TABLE IF NOT EXISTS " + TableOutputValues + " (" +
+		"address TEXT PRIMARY KEY, value TEXT, unit TEXT, timestamp TEXT)",
+}
+
+// InventoryConfig with the database of the inventory
+// The application must import the database/sql driver, for example:
+//  import _ "github.com/mattn/go-sqlite3"
+type InventoryConfig struct {
+	Driver     string `yaml:"driver"`     // registered database/sql driver. Default is DefaultDriver
+	DataSource string `yaml:"dataSource"` // database file or data source name, eg ~/.cache/iotdomain/inventory.db
+}
+
+// InventoryStore keeps the publishers, nodes, outputs and latest output values of a domain in a
+// SQLite database. Intended for consumer applications that query large domains, instead of holding
+// everything in memory. The store is updated incrementally from discovery messages received on
+// the message bus, and can be queried with SQL. Attach it to a publisher with AddIntegration.
+type InventoryStore struct {
+	db            *sql.DB                  // database with the inventory tables
+	domain        string                   // domain to keep the inventory of
+	messageSigner *messaging.MessageSigner // subscription to signed discovery messages
+	isRunning     bool                     // the store is receiving updates
+	updateMutex   *sync.Mutex              // mutex for concurrent start and stop
+}
+
+// Close stops receiving updates and closes the database
+func (store *InventoryStore) Close() error {
+	store.Stop()
+	return store.db.Close()
+}
+
+// DB returns the inventory database for direct access
+func (store *InventoryStore) DB() *sql.DB {
+	return store.db
+}
+
+// Query runs a SQL query on the inventory tables
+// The tables are TablePublishers, TableNodes, TableOutputs and TableOutputValues. The address
+// column joins output values to their output.
+func (store *InventoryStore) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return store.db.Query(query, args...)
+}
+
+// QueryNodes returns the nodes that match the SQL condition on the nodes table
+//  condition is the WHERE clause, eg "node_type = ?". Use "" for all nodes.
+func (store *InventoryStore) QueryNodes(condition string, args ...interface{}) ([]*types.NodeDiscoveryMessage, error) {
+	nodeList := make([]*types.NodeDiscoveryMessage, 0)
+	err := store.queryJSON(TableNodes, condition, args, func(jsonText string) error {
+		node := &types.NodeDiscoveryMessage{}
+		err := json.Unmarshal([]byte(jsonText), node)
+		nodeList = append(nodeList, node)
+		return err
+	})
+	return nodeList, err
+}
+
+// QueryOutputs returns the outputs that match the SQL condition on the outputs table
+//  condition is the WHERE clause, eg "output_type = ?". Use "" for all outputs.
+func (store *InventoryStore) QueryOutputs(condition string, args ...interface{}) ([]*types.OutputDiscoveryMessage, error) {
+	outputList := make([]*types.OutputDiscoveryMessage, 0)
+	err := store.queryJSON(TableOutputs, condition, args, func(jsonText string) error {
+		output := &types.OutputDiscoveryMessage{}
+		err := json.Unmarshal([]byte(jsonText), output)
+		outputList = append(outputList, output)
+		return err
+	})
+	return outputList, err
+}
+
+// QueryPublishers returns the publisher identities that match the SQL condition on the publishers table
+//  condition is the WHERE clause, eg "issuer_id = ?". Use "" for all publishers.
+func (store *InventoryStore) QueryPublishers(condition string, args ...interface{}) ([]*types.PublisherIdentityMessage, error) {
+	identList := make([]*types.PublisherIdentityMessage, 0)
+	err := store.queryJSON(TablePublishers, condition, args, func(jsonText string) error {
+		ident := &types.PublisherIdentityMessage{}
+		err := json.Unmarshal([]byte(jsonText), ident)
+		identList = append(identList, ident)
+		return err
+	})
+	return identList, err
+}
+
+// Start creates the inventory tables if needed and starts receiving updates from the message bus
+func (store *InventoryStore) Start() error {
+	store.updateMutex.Lock()
+	defer store.updateMutex.Unlock()
+	if store.isRunning {
+		return nil
+	}
+	for _, statement := range createTables {
+		_, err := store.db.Exec(statement)
+		if err != nil {
+			return lib.MakeErrorf("InventoryStore.Start: Unable to create table: %s", err)
+		}
+	}
+	store.isRunning = true
+	for address, handler := range store.subscriptions() {
+		store.messageSigner.Subscribe(address, handler)
+	}
+	return nil
+}
+
+// Stop receiving updates from the message bus
+func (store *InventoryStore) Stop() {
+	store.updateMutex.Lock()
+	defer store.updateMutex.Unlock()
+	if !store.isRunning {
+		return
+	}
+	store.isRunning = false
+	for address, handler := range store.subscriptions() {
+		store.messageSigner.Unsubscribe(address, handler)
+	}
+}
+
+// handleIdentity stores a received publisher identity after verifying it
+// The message is verified with the publisher key of the domain identities and the identity with its issuer key.
+func (store *InventoryStore) handleIdentity(address string, message string) error {
+	if message == "" {
+		return store.remove(TablePublishers, address)
+	}
+	var ident types.PublisherIdentityMessage
+	_, err := store.messageSigner.VerifyPublication(address, message, &ident)
+	if err == nil && ident.Address != address {
+		err = fmt.Errorf("message address '%s' differs", ident.Address)
+	}
+	if err == nil {
+		issuerKey := messaging.PublicKeyFromPem(ident.PublicKey)
+		if ident.IssuerID != ident.PublisherID {
+			issuerKey = store.messageSigner.GetPublicKey(ident.Domain + "/" + ident.IssuerID)
+		}
+		err = identities.VerifyPublisherIdentity(address, &ident, issuerKey, lib.DefaultClockSkew)
+	}
+	if err != nil {
+		return lib.MakeErrorf("InventoryStore.handleIdentity: Invalid identity on %s: %s", address, err)
+	}
+	return store.replace(TablePublishers, address, &ident,
+		ident.Domain, ident.PublisherID, ident.IssuerID, ident.ValidUntil, ident.Timestamp)
+}
+
+// handleLatest stores a received latest output value
+func (store *InventoryStore) handleLatest(address string, message string) error {
+	if message == "" {
+		return store.remove(TableOutputValues, address)
+	}
+	var latest types.OutputLatestMessage
+	_, err := store.messageSigner.VerifySignedMessage(message, &latest)
+	if err != nil {
+		return lib.MakeErrorf("InventoryStore.handleLatest: Invalid value on %s: %s", address, err)
+	}
+	_, err = store.db.Exec("INSERT OR REPLACE INTO "+TableOutputValues+
+		" (address, value, unit, timestamp) VALUES (?, ?, ?, ?)",
+		lib.MakeBaseAddress(address), latest.Value, string(latest.Unit), latest.Timestamp)
+	if err != nil {
+		return lib.MakeErrorf("InventoryStore.handleLatest: Unable to store value of %s: %s", address, err)
+	}
+	return nil
+}
+
+// handleNode stores a received node discovery
+func (store *InventoryStore) handleNode(address string, message string) error {
+	if message == "" {
+		return store.remove(TableNodes, address)
+	}
+	var node types.NodeDiscoveryMessage
+	_, err := store.messageSigner.VerifySignedMessage(message, &node)
+	if err != nil {
+		return lib.MakeErrorf("InventoryStore.handleNode: Invalid node on %s: %s", address, err)
+	}
+	publisherID, nodeID := "", ""
+	if segments := strings.Split(address, "/"); len(segments) >= 3 {
+		publisherID, nodeID = segments[1], segments[2]
+	}
+	return store.replace(TableNodes, address, &node,
+		publisherID, nodeID, node.HWID, node.Attr[types.NodeAttrType], node.Attr[types.NodeAttrName], node.Timestamp)
+}
+
+// handleOutput stores a received output discovery
+func (store *InventoryStore) handleOutput(address string, message string) error {
+	if message == "" {
+		return store.remove(TableOutputs, address)
+	}
+	var output types.OutputDiscoveryMessage
+	_, err := store.messageSigner.VerifySignedMessage(message, &output)
+	if err != nil {
+		return lib.MakeErrorf("InventoryStore.handleOutput: Invalid output on %s: %s", address, err)
+	}
+	publisherID, nodeID, outputType, instance := "", "", "", ""
+	if segments := strings.Split(address, "/"); len(segments) >= 5 {
+		publisherID, nodeID, outputType, instance = segments[1], segments[2], segments[3], segments[4]
+	}
+	return store.replace(TableOutputs, address, &output,
+		publisherID, nodeID, outputType, instance, string(output.DataType), string(output.Unit), output.Timestamp)
+}
+
+// queryJSON runs a query for the json column of a table and passes each result to the handler
+func (store *InventoryStore) queryJSON(table string, condition string, args []interface{},
+	handler func(jsonText string) error) error {
+
+	query := "SELECT json FROM " + table
+	if condition != "" {
+		query += " WHERE " + condition
+	}
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return lib.MakeErrorf("InventoryStore.queryJSON: Query on %s failed: %s", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var jsonText string
+		err = rows.Scan(&jsonText)
+		if err == nil {
+			err = handler(jsonText)
+		}
+		if err != nil {
+			return lib.MakeErrorf("InventoryStore.queryJSON: Invalid record in %s: %s", table, err)
+		}
+	}
+	return rows.Err()
+}
+
+// remove deletes the record with the address from a table, for example when a retained discovery
+// message is cleared
+func (store *InventoryStore) remove(table string, address string) error {
+	_, err := store.db.Exec("DELETE FROM "+table+" WHERE address = ?", lib.MakeBaseAddress(address))
+	if err != nil {
+		return lib.MakeErrorf("InventoryStore.remove: Unable to remove %s from %s: %s", address, table, err)
+	}
+	logrus.Infof("InventoryStore.remove: Removed %s from %s", address, table)
+	return nil
+}
+
+// replace inserts or replaces the record of a discovery message
+//  columns are the values of the table columns between the address and json columns
+func (store *InventoryStore) replace(table string, address string,
+	object interface{}, columns ...interface{}) error {
+
+	jsonText, err := json.Marshal(object)
+	if err != nil {
+		return lib.MakeErrorf("InventoryStore.replace: Unable to marshal %s: %s", address, err)
+	}
+	var statement string
+	switch table {
+	case TablePublishers:
+		statement = "INSERT OR REPLACE INTO " + TablePublishers +
+			" (address, domain, publisher_id, issuer_id, valid_until, timestamp, json) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	case TableNodes:
+		statement = "INSERT OR REPLACE INTO " + TableNodes +
+			" (address, publisher_id, node_id, hw_id, node_type, name, timestamp, json) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	case TableOutputs:
+		statement = "INSERT OR REPLACE INTO " + TableOutputs +
+			" (address, publisher_id, node_id, output_type, instance, data_type, unit, timestamp, json)" +
+			" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	}
+	args := append([]interface{}{lib.MakeBaseAddress(address)}, columns...)
+	args = append(args, string(jsonText))
+	_, err = store.db.Exec(statement, args...)
+	if err != nil {
+		return lib.MakeErrorf("InventoryStore.replace: Unable to store %s in %s: %s", address, table, err)
+	}
+	return nil
+}
+
+// subscriptions returns the discovery addresses of the domain with their handler
+func (store *InventoryStore) subscriptions() map[string]func(address string, message string) error {
+	outputAddr := outputs.MakeOutputDiscoveryAddress(store.domain, "+", "+", "+", "+")
+	return map[string]func(address string, message string) error{
+		identities.MakePublisherIdentityAddress(store.domain, "+"): store.handleIdentity,
+		nodes.MakeNodeDiscoveryAddress(store.domain, "+", "+"):     store.handleNode,
+		outputAddr: store.handleOutput,
+		outputs.ReplaceMessageType(outputAddr, types.MessageTypeLatest): store.handleLatest,
+	}
+}
+
+// NewInventoryStore creates an inventory store using an opened database. Use Start to create the
+// tables and receive updates.
+//  db is the opened database. The statements use the SQLite dialect.
+//	domain is the domain to keep the inventory of
+//	messageSigner receives and verifies the discovery messages
+func NewInventoryStore(db *sql.DB, domain string, messageSigner *messaging.MessageSigner) *InventoryStore {
+	store := &InventoryStore{
+		db:            db,
+		domain:        domain,
+		messageSigner: messageSigner,
+		updateMutex:   &sync.Mutex{},
+	}
+	return store
+}
+
+// OpenInventoryStore opens the database of the configuration and creates an inventory store.
+// This returns an error if the database driver isn't registered by the application.
+func OpenInventoryStore(config InventoryConfig, domain string,
+	messageSigner *messaging.MessageSigner) (*InventoryStore, error) {
+
+	driver := config.Driver
+	if driver == "" {
+		driver = DefaultDriver
+	}
+	db, err := sql.Open(driver, config.DataSource)
+	if err != nil {
+		return nil, lib.MakeErrorf("OpenInventoryStore: Unable to open inventory %s: %s", config.DataSource, err)
+	}
+	return NewInventoryStore(db, domain, messageSigner), nil
+}
@@ -0,0 +1,203 @@
+package inventory_test
+
+import (
+	"crypto/ecdsa"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/inventory"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const domain = "test"
+const publisherID = "pub1"
+
+// fakeDriver is a minimal in-memory SQL driver that supports the statements used by the store:
+// CREATE TABLE, INSERT OR REPLACE, DELETE with an address condition and SELECT with an optional
+// single column condition.
+type fakeDriver struct {
+	tables      map[string]map[string]map[string]driver.Value // rows by address by table
+	updateMutex sync.Mutex
+}
+
+type fakeConn struct{ driver *fakeDriver }
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+var insertExpr = regexp.MustCompile(`^INSERT OR REPLACE INTO (\w+) \(([^)]+)\)`)
+var deleteExpr = regexp.MustCompile(`^DELETE FROM (\w+) WHERE address = \?`)
+var selectExpr = regexp.MustCompile(`^SELECT (.+) FROM (\w+)(?: WHERE (\w+) = \?)?$`)
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) { return &fakeConn{driver: d}, nil }
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+func (s *fakeStmt) Close() error              { return nil }
+func (s *fakeStmt) NumInput() int             { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	d := s.conn.driver
+	d.updateMutex.Lock()
+	defer d.updateMutex.Unlock()
+	if strings.HasPrefix(s.query, "CREATE TABLE") {
+		return driver.RowsAffected(0), nil
+	} else if match := insertExpr.FindStringSubmatch(s.query); match != nil {
+		row := make(map[string]driver.Value)
+		for i, column := range strings.Split(match[2], ", ") {
+			row[column] = args[i]
+		}
+		if d.tables[match[1]] == nil {
+			d.tables[match[1]] = make(map[string]map[string]driver.Value)
+		}
+		d.tables[match[1]][args[0].(string)] = row
+		return driver.RowsAffected(1), nil
+	} else if match := deleteExpr.FindStringSubmatch(s.query); match != nil {
+		delete(d.tables[match[1]], args[0].(string))
+		return driver.RowsAffected(1), nil
+	}
+	return nil, errors.New("unsupported statement: " + s.query)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	d := s.conn.driver
+	d.updateMutex.Lock()
+	defer d.updateMutex.Unlock()
+	match := selectExpr.FindStringSubmatch(s.query)
+	if match == nil {
+		return nil, errors.New("unsupported query: " + s.query)
+	}
+	result := &fakeRows{columns: strings.Split(match[1], ", ")}
+	for _, row := range d.tables[match[2]] {
+		if match[3] != "" && row[match[3]] != args[0] {
+			continue
+		}
+		values := make([]driver.Value, 0)
+		for _, column := range result.columns {
+			values = append(values, row[column])
+		}
+		result.rows = append(result.rows, values)
+	}
+	return result, nil
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var fake = &fakeDriver{tables: make(map[string]map[string]map[string]driver.Value)}
+
+func init() {
+	sql.Register("fakesql", fake)
+}
+
+func TestInventoryStore(t *testing.T) {
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+
+	store, err := inventory.OpenInventoryStore(
+		inventory.InventoryConfig{Driver: "fakesql", DataSource: "inventory.db"}, domain, signer)
+	require.NoError(t, err)
+	err = store.Start()
+	require.NoError(t, err)
+
+	// discovery messages update the inventory
+	ident, _ := identities.CreateIdentity(domain, publisherID)
+	signer.PublishObject(ident.Address, true, &ident.PublisherIdentityMessage, nil)
+	node := nodes.NewNode(domain, publisherID, "node1", types.NodeTypeMultisensor)
+	signer.PublishObject(node.Address, true, node, nil)
+	output := outputs.NewOutput(domain, publisherID, "node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	outputAddr := output.Address
+	signer.PublishObject(outputAddr, true, output, nil)
+	latestAddr := outputs.ReplaceMessageType(outputAddr, types.MessageTypeLatest)
+	signer.PublishObject(latestAddr, true, &types.OutputLatestMessage{Address: latestAddr, Value: "21"}, nil)
+
+	// an identity that isn't signed with the known publisher key is not stored
+	ident2, privKey2 := identities.CreateIdentity(domain, "publisher2")
+	forger := messaging.NewMessageSigner(messenger, privKey2, getPubKey)
+	forger.PublishObject(ident2.Address, true, &ident2.PublisherIdentityMessage, nil)
+
+	publishers, err := store.QueryPublishers("")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(publishers))
+	assert.Equal(t, publisherID, publishers[0].PublisherID)
+
+	nodeList, err := store.QueryNodes("node_type = ?", string(types.NodeTypeMultisensor))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(nodeList))
+	assert.Equal(t, node.Address, nodeList[0].Address)
+	nodeList, err = store.QueryNodes("node_type = ?", string(types.NodeTypeGateway))
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(nodeList))
+
+	outputList, err := store.QueryOutputs("output_type = ?", string(types.OutputTypeTemperature))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(outputList))
+
+	rows, err := store.Query("SELECT value FROM "+inventory.TableOutputValues+" WHERE address = ?",
+		strings.TrimSuffix(outputAddr, "/"+types.MessageTypeOutputDiscovery))
+	require.NoError(t, err)
+	require.True(t, rows.Next())
+	var value string
+	rows.Scan(&value)
+	rows.Close()
+	assert.Equal(t, "21", value)
+
+	// clearing a retained discovery removes it from the inventory
+	messenger.Publish(node.Address, true, "")
+	nodeList, err = store.QueryNodes("")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(nodeList))
+
+	// invalid messages are ignored
+	messenger.Publish(node.Address, true, "not a signed message")
+	nodeList, _ = store.QueryNodes("")
+	assert.Equal(t, 0, len(nodeList))
+
+	// no updates after stop
+	store.Stop()
+	signer.PublishObject(node.Address, true, node, nil)
+	nodeList, _ = store.QueryNodes("")
+	assert.Equal(t, 0, len(nodeList))
+
+	err = store.Close()
+	assert.NoError(t, err)
+	assert.NotNil(t, store.DB())
+}
+
+func TestOpenInventoryStoreNoDriver(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, nil, nil)
+	_, err := inventory.OpenInventoryStore(inventory.InventoryConfig{DataSource: "inventory.db"}, domain, signer)
+	assert.Error(t, err)
+}
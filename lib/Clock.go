@@ -0,0 +1,233 @@
+// Package lib with the time source and validation of remote timestamps
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// DefaultClockSkew is the default tolerance of the difference between the clock of this publisher
+// and the clocks of remote publishers. Gateways without a real time clock can drift considerably.
+const DefaultClockSkew = 5 * time.Minute
+
+// Clock is the source of the current time. Replace the clock with SetClock to control time in tests.
+type Clock interface {
+	// NewTicker returns a ticker that ticks each interval of the clock
+	NewTicker(interval time.Duration) *Ticker
+	// Now returns the current time
+	Now() time.Time
+}
+
+// Ticker delivers the ticks of a clock on channel C, like a time.Ticker
+type Ticker struct {
+	C    <-chan time.Time // channel on which the ticks are delivered
+	stop func()
+}
+
+// Stop the ticker. No more ticks are delivered after Stop returns.
+func (ticker *Ticker) Stop() {
+	ticker.stop()
+}
+
+// SystemClock is the clock that uses the system time
+type SystemClock struct{}
+
+// NewTicker returns a ticker of the system time
+func (clock SystemClock) NewTicker(interval time.Duration) *Ticker {
+	timeTicker := time.NewTicker(interval)
+	return &Ticker{C: timeTicker.C, stop: timeTicker.Stop}
+}
+
+// Now returns the current system time
+func (clock SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock is a clock whose time only changes when it is set or advanced, for use in tests
+type ManualClock struct {
+	now         time.Time
+	tickers     []*manualTicker
+	updateMutex *sync.Mutex
+}
+
+// manualTicker is a ticker of the manual clock
+type manualTicker struct {
+	c        chan time.Time
+	interval time.Duration
+	next     time.Time // time of the next tick
+	stopped  chan bool // closed when the ticker is stopped
+}
+
+// Add advances the time of the clock with the given duration. Tickers that are due receive a
+// single tick, like a time.Ticker drops the ticks a slow receiver misses. Add returns after the
+// ticks are received, so the next Add returns after the receivers have handled them.
+func (clock *ManualClock) Add(duration time.Duration) {
+	clock.updateMutex.Lock()
+	now := clock.now.Add(duration)
+	clock.updateMutex.Unlock()
+	clock.Set(now)
+}
+
+// NewTicker returns a ticker that ticks when the clock is advanced past its interval
+func (clock *ManualClock) NewTicker(interval time.Duration) *Ticker {
+	clock.updateMutex.Lock()
+	defer clock.updateMutex.Unlock()
+	ticker := &manualTicker{
+		c:        make(chan time.Time),
+		interval: interval,
+		next:     clock.now.Add(interval),
+		stopped:  make(chan bool),
+	}
+	clock.tickers = append(clock.tickers, ticker)
+	stopOnce := &sync.Once{}
+	stop := func() {
+		stopOnce.Do(func() {
+			clock.removeTicker(ticker)
+			close(ticker.stopped)
+		})
+	}
+	return &Ticker{C: ticker.c, stop: stop}
+}
+
+// Now returns the time of the clock
+func (clock *ManualClock) Now() time.Time {
+	clock.updateMutex.Lock()
+	defer clock.updateMutex.Unlock()
+	return clock.now
+}
+
+// Set the time of the clock. Tickers that are due receive a single tick, see Add.
+func (clock *ManualClock) Set(now time.Time) {
+	clock.updateMutex.Lock()
+	clock.now = now
+	dueTickers := make([]*manualTicker, 0)
+	for _, ticker := range clock.tickers {
+		if !ticker.next.After(now) {
+			dueTickers = append(dueTickers, ticker)
+			for !ticker.next.After(now) {
+				ticker.next = ticker.next.Add(ticker.interval)
+			}
+		}
+	}
+	clock.updateMutex.Unlock()
+	for _, ticker := range dueTickers {
+		select {
+		case ticker.c <- now:
+		case <-ticker.stopped:
+		}
+	}
+}
+
+// removeTicker removes a stopped ticker from the clock
+func (clock *ManualClock) removeTicker(ticker *manualTicker) {
+	clock.updateMutex.Lock()
+	defer clock.updateMutex.Unlock()
+	for i, t := range clock.tickers {
+		if t == ticker {
+			clock.tickers = append(clock.tickers[:i], clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+// NewManualClock returns a clock that starts at the given time
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{
+		now:         now,
+		tickers:     make([]*manualTicker, 0),
+		updateMutex: &sync.Mutex{},
+	}
+}
+
+// the clock used by Now and the timestamp validation. The clock is process wide, unlike the clock
+// skew tolerance, as it is read by the registries, collections and helpers of all packages, which
+// don't know the publisher they serve. A publisher has no reason to run on a different time than
+// the rest of the process; replacing the clock is intended for tests.
+var clock Clock = SystemClock{}
+var clockMutex = &sync.RWMutex{}
+
+// NewTicker returns a ticker of the clock that is set with SetClock.
+// Use this instead of time.NewTicker for periodic work that tests drive with a ManualClock.
+func NewTicker(interval time.Duration) *Ticker {
+	clockMutex.RLock()
+	currentClock := clock
+	clockMutex.RUnlock()
+	return currentClock.NewTicker(interval)
+}
+
+// Now returns the current time of the clock that is set with SetClock.
+// Use this instead of time.Now for timestamps of messages.
+func Now() time.Time {
+	clockMutex.RLock()
+	currentClock := clock
+	clockMutex.RUnlock()
+	return currentClock.Now()
+}
+
+// SetClock replaces the process wide clock that is used for timestamps, expiry and retention
+//  newClock is the clock to use, or nil to use the SystemClock
+func SetClock(newClock Clock) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+	if newClock == nil {
+		newClock = SystemClock{}
+	}
+	clock = newClock
+}
+
+// ParseTimestamp parses a message timestamp in the types.TimeFormat format. Timestamps in the
+// RFC3339 format are also accepted.
+func ParseTimestamp(timestamp string) (time.Time, error) {
+	parsed, err := time.Parse(types.TimeFormat, timestamp)
+	if err != nil {
+		parsed, err = time.Parse(time.RFC3339Nano, timestamp)
+	}
+	if err != nil {
+		return parsed, fmt.Errorf("ParseTimestamp: Invalid timestamp '%s'", timestamp)
+	}
+	return parsed, nil
+}
+
+// CompareTimestamps compares the times of two message timestamps, which can be in different time zones.
+// Returns -1 if timestamp1 is before timestamp2, 0 if they are equal and 1 if it is after.
+// Timestamps that can't be parsed are compared as text.
+func CompareTimestamps(timestamp1 string, timestamp2 string) int {
+	time1, err1 := ParseTimestamp(timestamp1)
+	time2, err2 := ParseTimestamp(timestamp2)
+	if err1 != nil || err2 != nil {
+		return strings.Compare(timestamp1, timestamp2)
+	} else if time1.Before(time2) {
+		return -1
+	} else if time1.After(time2) {
+		return 1
+	}
+	return 0
+}
+
+// IsTimestampExpired returns true if the time of a remote timestamp, for example the end of a
+// validity period, has passed by more than the clock skew tolerance.
+// Invalid timestamps are considered expired.
+//  skew is the tolerance of the remote clock, eg DefaultClockSkew, 0 to not tolerate any skew
+func IsTimestampExpired(timestamp string, skew time.Duration) bool {
+	expires, err := ParseTimestamp(timestamp)
+	if err != nil {
+		return true
+	}
+	return Now().After(expires.Add(skew))
+}
+
+// IsTimestampInFuture returns true if a remote timestamp, for example the time a command is
+// sent, lies further in the future than the clock skew tolerance.
+// Invalid timestamps are not in the future.
+//  skew is the tolerance of the remote clock, eg DefaultClockSkew, 0 to not tolerate any skew
+func IsTimestampInFuture(timestamp string, skew time.Duration) bool {
+	sent, err := ParseTimestamp(timestamp)
+	if err != nil {
+		return false
+	}
+	return sent.After(Now().Add(skew))
+}
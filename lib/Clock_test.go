@@ -0,0 +1,78 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClock(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := lib.NewManualClock(now)
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+	assert.True(t, now.Equal(lib.Now()))
+	clock.Add(time.Minute)
+	assert.True(t, now.Add(time.Minute).Equal(lib.Now()))
+
+	// tickers of the manual clock tick once when the clock passes their interval
+	ticker := lib.NewTicker(time.Second)
+	ticks := make(chan time.Time, 10)
+	go func() {
+		for tick := range ticker.C {
+			ticks <- tick
+		}
+	}()
+	clock.Add(500 * time.Millisecond)
+	clock.Add(500 * time.Millisecond)
+	assert.True(t, now.Add(time.Minute+time.Second).Equal(<-ticks))
+	clock.Add(10 * time.Second)
+	assert.True(t, now.Add(time.Minute+11*time.Second).Equal(<-ticks))
+	ticker.Stop()
+	clock.Add(time.Second)
+	assert.Equal(t, 0, len(ticks))
+
+	// the system clock is used after resetting the clock
+	lib.SetClock(nil)
+	assert.WithinDuration(t, time.Now(), lib.Now(), time.Second)
+}
+
+func TestRemoteTimestamps(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	lib.SetClock(lib.NewManualClock(now))
+	defer lib.SetClock(nil)
+	format := func(timestamp time.Time) string {
+		return timestamp.Format(types.TimeFormat)
+	}
+
+	// timestamps in both formats are parsed
+	parsed, err := lib.ParseTimestamp(format(now))
+	require.NoError(t, err)
+	assert.True(t, now.Equal(parsed))
+	parsed, err = lib.ParseTimestamp(now.Format(time.RFC3339))
+	require.NoError(t, err)
+	assert.True(t, now.Equal(parsed))
+	_, err = lib.ParseTimestamp("yesterday")
+	assert.Error(t, err)
+
+	// timestamps in different time zones are compared by time
+	pdt := time.FixedZone("PDT", -7*3600)
+	assert.Equal(t, -1, lib.CompareTimestamps(format(now), format(now.Add(time.Minute).In(pdt))))
+	assert.Equal(t, 0, lib.CompareTimestamps(format(now), format(now.In(pdt))))
+	assert.Equal(t, 1, lib.CompareTimestamps(format(now.Add(time.Minute).In(pdt)), format(now)))
+
+	// the clock skew tolerance applies to expiry and future timestamps
+	skew := lib.DefaultClockSkew
+	assert.False(t, lib.IsTimestampExpired(format(now.Add(-time.Minute)), skew))
+	assert.True(t, lib.IsTimestampExpired(format(now.Add(-skew-time.Minute)), skew))
+	assert.True(t, lib.IsTimestampExpired("", skew))
+	assert.False(t, lib.IsTimestampInFuture(format(now.Add(time.Minute)), skew))
+	assert.True(t, lib.IsTimestampInFuture(format(now.Add(skew+time.Minute)), skew))
+	assert.False(t, lib.IsTimestampInFuture("", skew))
+	assert.True(t, lib.IsTimestampExpired(format(now.Add(-time.Minute)), 0))
+	assert.True(t, lib.IsTimestampInFuture(format(now.Add(time.Minute)), 0))
+}
@@ -0,0 +1,149 @@
+// Package lib with watching of configuration files for changes
+package lib
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConfigWatcherDelay is the time to wait for writing of a changed file to complete before
+// invoking the handler, in milliseconds
+const ConfigWatcherDelay = 200
+
+// ConfigWatcher detects changes to configuration files in a folder, for example when edited by an
+// operator. The folder is watched instead of the files, as editors often replace a file instead of
+// writing to it. The handler is only invoked if the content of a file has changed.
+type ConfigWatcher struct {
+	configFolder string                       // folder containing the files
+	contentHash  map[string][sha256.Size]byte // hash of the last known content by filename
+	handler      func(filename string)        // invoked with the filename of a changed file
+	pending      map[string]*time.Timer       // delayed handling of changed files
+	watcher      *fsnotify.Watcher            // the folder watcher, nil when not running
+	updateMutex  *sync.Mutex                  // mutex for async handling of file events
+}
+
+// Refresh records the current content of a file as known, so it isn't reported as changed.
+// Intended for files that are saved by the application itself.
+func (cw *ConfigWatcher) Refresh(filename string) {
+	hash, _ := cw.readHash(filename)
+	cw.updateMutex.Lock()
+	defer cw.updateMutex.Unlock()
+	if _, isWatched := cw.contentHash[filename]; isWatched {
+		cw.contentHash[filename] = hash
+	}
+}
+
+// Start watching the configuration folder
+func (cw *ConfigWatcher) Start() error {
+	cw.updateMutex.Lock()
+	defer cw.updateMutex.Unlock()
+	if cw.watcher != nil {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(cw.configFolder)
+	}
+	if err != nil {
+		return MakeErrorf("ConfigWatcher.Start: Unable to watch folder %s: %s", cw.configFolder, err)
+	}
+	for filename := range cw.contentHash {
+		cw.contentHash[filename], _ = cw.readHash(filename)
+	}
+	cw.watcher = watcher
+	go cw.watcherLoop(watcher)
+	return nil
+}
+
+// Stop watching the configuration folder
+func (cw *ConfigWatcher) Stop() {
+	cw.updateMutex.Lock()
+	defer cw.updateMutex.Unlock()
+	if cw.watcher != nil {
+		cw.watcher.Close()
+		cw.watcher = nil
+	}
+	for filename, timer := range cw.pending {
+		timer.Stop()
+		delete(cw.pending, filename)
+	}
+}
+
+// handleChange invokes the handler if the content of the file has changed
+func (cw *ConfigWatcher) handleChange(filename string) {
+	hash, err := cw.readHash(filename)
+	cw.updateMutex.Lock()
+	delete(cw.pending, filename)
+	isChanged := err == nil && cw.watcher != nil && cw.contentHash[filename] != hash
+	if isChanged {
+		cw.contentHash[filename] = hash
+	}
+	cw.updateMutex.Unlock()
+	if isChanged {
+		log.Infof("ConfigWatcher.handleChange: Configuration file %s has changed", filename)
+		cw.handler(filename)
+	}
+}
+
+// readHash returns the hash of the content of a file in the configuration folder
+func (cw *ConfigWatcher) readHash(filename string) (hash [sha256.Size]byte, err error) {
+	content, err := ioutil.ReadFile(path.Join(cw.configFolder, filename))
+	if err != nil {
+		return hash, err
+	}
+	return sha256.Sum256(content), nil
+}
+
+// watcherLoop handles events of the watched files until the watcher is closed. Changes are handled
+// after a delay so multiple events of a single save result in a single invocation of the handler.
+func (cw *ConfigWatcher) watcherLoop(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			filename := path.Base(event.Name)
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			cw.updateMutex.Lock()
+			_, isWatched := cw.contentHash[filename]
+			if isWatched && cw.pending[filename] == nil {
+				cw.pending[filename] = time.AfterFunc(ConfigWatcherDelay*time.Millisecond, func() {
+					cw.handleChange(filename)
+				})
+			}
+			cw.updateMutex.Unlock()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warningf("ConfigWatcher.watcherLoop: %s", err)
+		}
+	}
+}
+
+// NewConfigWatcher creates a watcher of configuration files
+//  configFolder is the folder containing the files
+//  filenames are the names of the files in the folder to watch
+//  handler is invoked with the name of a file whose content has changed
+func NewConfigWatcher(configFolder string, filenames []string, handler func(filename string)) *ConfigWatcher {
+	cw := &ConfigWatcher{
+		configFolder: configFolder,
+		contentHash:  make(map[string][sha256.Size]byte),
+		handler:      handler,
+		pending:      make(map[string]*time.Timer),
+		updateMutex:  &sync.Mutex{},
+	}
+	for _, filename := range filenames {
+		cw.contentHash[filename] = [sha256.Size]byte{}
+	}
+	return cw
+}
@@ -0,0 +1,75 @@
+package lib_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	configFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+	const watchedFile = "app.yaml"
+	watchedPath := path.Join(configFolder, watchedFile)
+	ioutil.WriteFile(watchedPath, []byte("a: 1"), 0600)
+
+	changes := make([]string, 0)
+	mutex := sync.Mutex{}
+	getChanges := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(changes)
+	}
+	cw := lib.NewConfigWatcher(configFolder, []string{watchedFile}, func(filename string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		changes = append(changes, filename)
+	})
+	err = cw.Start()
+	require.NoError(t, err)
+	delay := time.Millisecond * (lib.ConfigWatcherDelay + 300)
+
+	// a change in content invokes the handler once
+	ioutil.WriteFile(watchedPath, []byte("a: 2"), 0600)
+	time.Sleep(delay)
+	require.Equal(t, 1, getChanges())
+	assert.Equal(t, watchedFile, changes[0])
+
+	// writing the same content or other files is ignored
+	ioutil.WriteFile(watchedPath, []byte("a: 2"), 0600)
+	ioutil.WriteFile(path.Join(configFolder, "other.yaml"), []byte("b: 1"), 0600)
+	time.Sleep(delay)
+	assert.Equal(t, 1, getChanges())
+
+	// files saved by the application itself are ignored after a refresh
+	ioutil.WriteFile(watchedPath, []byte("a: 3"), 0600)
+	cw.Refresh(watchedFile)
+	time.Sleep(delay)
+	assert.Equal(t, 1, getChanges())
+
+	// replacing the file is detected
+	ioutil.WriteFile(watchedPath+".tmp", []byte("a: 4"), 0600)
+	os.Rename(watchedPath+".tmp", watchedPath)
+	time.Sleep(delay)
+	assert.Equal(t, 2, getChanges())
+
+	// no changes after stop
+	cw.Stop()
+	ioutil.WriteFile(watchedPath, []byte("a: 5"), 0600)
+	time.Sleep(delay)
+	assert.Equal(t, 2, getChanges())
+}
+
+func TestConfigWatcherBadFolder(t *testing.T) {
+	cw := lib.NewConfigWatcher("/notafolder", []string{"app.yaml"}, func(filename string) {})
+	err := cw.Start()
+	assert.Error(t, err)
+}
@@ -0,0 +1,16 @@
+// Package lib with hashing of the content of published entities
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// MakeContentHash returns the hex encoded sha256 hash of the JSON serialization of the content.
+// Intended to detect whether an entity has changed since it was last published.
+func MakeContentHash(content interface{}) string {
+	jsonText, _ := json.Marshal(content)
+	hash := sha256.Sum256(jsonText)
+	return hex.EncodeToString(hash[:])
+}
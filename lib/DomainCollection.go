@@ -4,8 +4,10 @@ package lib
 import (
 	"crypto/ecdsa"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/messaging"
 )
@@ -15,10 +17,41 @@ import (
 type DomainCollection struct {
 	DiscoMap map[string]interface{} // discovered by addres
 	// MessageSigner *messaging.MessageSigner // subscription to discovery messages
-	GetPublicKey func(string) *ecdsa.PublicKey // get the public key for signature verification
-	UpdateMutex  *sync.Mutex                   // mutex for async updating
-	ItemPtr      reflect.Type                  // pointer type of item in map
-	updateCount  int                           // nr of updates to this collection
+	GetItemType  func(interface{}) string        // get the type of an item for the type index, nil to not index types
+	GetPublicKey func(string) *ecdsa.PublicKey   // get the public key for signature verification
+	UpdateMutex  *sync.RWMutex                   // mutex for async updating
+	ItemPtr      reflect.Type                    // pointer type of item in map
+	ReceiveHook  func(string, interface{}) error // accept or veto a verified discovery, nil to accept all
+	bulkDeadline time.Time                       // time bulk loading ends regardless of updates
+	bulkQuiet    time.Duration                   // time without updates after which bulk loading ends
+	bulkTimer    *time.Timer                     // timer that ends bulk loading, nil when not bulk loading
+	segmentIndex map[string]map[string]bool      // base addresses by segment index and value, eg "1/publisher1"
+	typeIndex    map[string]map[string]bool      // base addresses by item type
+	updateCount  int                             // nr of updates to this collection
+}
+
+// DefaultBulkLoadQuiet is the default time in milliseconds without updates after which the burst of
+// retained discovery messages on subscribing is considered received
+const DefaultBulkLoadQuiet = 500
+
+// MaxBulkLoadPeriod is the max time in seconds that bulk loading defers building the indexes
+const MaxBulkLoadPeriod = 60
+
+// EndBulkLoad ends bulk loading and builds the indexes of the collection. This is invoked
+// automatically when no updates are received within the quiet period of StartBulkLoad.
+func (dc *DomainCollection) EndBulkLoad() {
+	dc.UpdateMutex.Lock()
+	defer dc.UpdateMutex.Unlock()
+	if dc.bulkTimer == nil {
+		return
+	}
+	dc.bulkTimer.Stop()
+	dc.bulkTimer = nil
+	dc.segmentIndex = make(map[string]map[string]bool)
+	dc.typeIndex = make(map[string]map[string]bool)
+	for base, item := range dc.DiscoMap {
+		dc.addToIndex(base, item)
+	}
 }
 
 // Get returns an item by node address and optionally ioType and instance
@@ -30,8 +63,8 @@ func (dc *DomainCollection) Get(nodeAddress string, ioType string, instance stri
 		base = base + "/" + ioType + "/" + instance
 	}
 
-	dc.UpdateMutex.Lock()
-	defer dc.UpdateMutex.Unlock()
+	dc.UpdateMutex.RLock()
+	defer dc.UpdateMutex.RUnlock()
 	item := dc.DiscoMap[base]
 	return item
 }
@@ -41,8 +74,8 @@ func (dc *DomainCollection) Get(nodeAddress string, ioType string, instance stri
 func (dc *DomainCollection) GetByAddress(address string) interface{} {
 	base := MakeBaseAddress(address)
 
-	dc.UpdateMutex.Lock()
-	defer dc.UpdateMutex.Unlock()
+	dc.UpdateMutex.RLock()
+	defer dc.UpdateMutex.RUnlock()
 	item := dc.DiscoMap[base]
 	return item
 }
@@ -56,8 +89,8 @@ func (dc *DomainCollection) GetByAddressPrefix(addressPrefix string, resultSlice
 
 	// todo: check that resultSlicePtr is of the right type
 
-	dc.UpdateMutex.Lock()
-	defer dc.UpdateMutex.Unlock()
+	dc.UpdateMutex.RLock()
+	defer dc.UpdateMutex.RUnlock()
 	base := MakeBaseAddress(addressPrefix)
 	itemListVal := reflect.ValueOf(resultSlicePtr).Elem()
 
@@ -70,14 +103,78 @@ func (dc *DomainCollection) GetByAddressPrefix(addressPrefix string, resultSlice
 	}
 }
 
+// GetByPattern fills the given slice (pointer) with all items whose base address matches the
+// pattern. The pattern is a base address where segments can be the '+' wildcard, eg
+// domain/+/+/temperature/+ for all temperature inputs or outputs of the domain. A trailing message
+// type is ignored. Candidates are taken from the address segment index, so the more segments
+// are given the fewer items are compared.
+// The result is stored in resultSlicePtr which must be a pointer to a slice
+//   that contains pointers to items, eg: []*Item
+func (dc *DomainCollection) GetByPattern(pattern string, resultSlicePtr interface{}) {
+	patternSegments := strings.Split(MakeBaseAddress(pattern), "/")
+	itemListVal := reflect.ValueOf(resultSlicePtr).Elem()
+
+	dc.UpdateMutex.RLock()
+	defer dc.UpdateMutex.RUnlock()
+	// use the smallest set of addresses with a matching segment as candidates
+	var candidates map[string]bool
+	for index, segment := range patternSegments {
+		if segment == "+" || dc.bulkTimer != nil {
+			continue
+		}
+		addresses := dc.segmentIndex[segmentKey(index, segment)]
+		if candidates == nil || len(addresses) < len(candidates) {
+			candidates = addresses
+		}
+		if len(candidates) == 0 {
+			return
+		}
+	}
+	if candidates == nil {
+		// only wildcards or the indexes are not yet built
+		candidates = make(map[string]bool, len(dc.DiscoMap))
+		for addr := range dc.DiscoMap {
+			candidates[addr] = true
+		}
+	}
+	for addr := range candidates {
+		if matchPattern(strings.Split(addr, "/"), patternSegments) {
+			itemListVal.Set(reflect.Append(itemListVal, reflect.ValueOf(dc.DiscoMap[addr])))
+		}
+	}
+}
+
+// GetByType fills the given slice (pointer) with all items of the given type.
+// This requires that GetItemType is set, otherwise the result remains empty.
+// The result is stored in resultSlicePtr which must be a pointer to a slice
+//   that contains pointers to items, eg: []*Item
+func (dc *DomainCollection) GetByType(itemType string, resultSlicePtr interface{}) {
+	itemListVal := reflect.ValueOf(resultSlicePtr).Elem()
+
+	dc.UpdateMutex.RLock()
+	defer dc.UpdateMutex.RUnlock()
+	if dc.bulkTimer != nil {
+		// the type index is not yet built
+		for _, item := range dc.DiscoMap {
+			if dc.GetItemType != nil && dc.GetItemType(item) == itemType {
+				itemListVal.Set(reflect.Append(itemListVal, reflect.ValueOf(item)))
+			}
+		}
+		return
+	}
+	for addr := range dc.typeIndex[itemType] {
+		itemListVal.Set(reflect.Append(itemListVal, reflect.ValueOf(dc.DiscoMap[addr])))
+	}
+}
+
 // GetAll populates the given list with the objects in this collection
 // resultSlicePtr is a pointer to a slice to store the result. This reduces the magic
 // somewhat.
 // This magic goo is brewed by a genius named Martin Tournoij:
 //  https://stackoverflow.com/questions/37939388/how-can-i-add-elements-to-slice-reflection
 func (dc *DomainCollection) GetAll(resultSlicePtr interface{}) {
-	dc.UpdateMutex.Lock()
-	defer dc.UpdateMutex.Unlock()
+	dc.UpdateMutex.RLock()
+	defer dc.UpdateMutex.RUnlock()
 
 	// Oh the magic! create a slice instance of the item type
 	// models := reflect.New(reflect.SliceOf(dc.ItemPtr)).Interface()
@@ -111,12 +208,18 @@ func (dc *DomainCollection) HandleDiscovery(
 	if err != nil {
 		return MakeErrorf("HandleDiscovery: Failed verifying signature on address %s: %s", address, err)
 	}
+	if dc.ReceiveHook != nil {
+		if err = dc.ReceiveHook(address, newItem); err != nil {
+			return err
+		}
+	}
 	segments := strings.Split(address, "/")
 	if len(segments) > 2 {
 		setObjectField(newItem, "PublisherID", segments[1])
 		setObjectField(newItem, "NodeID", segments[2])
 	}
 	if len(segments) > 4 {
+		setObjectField(newItem, "InputType", segments[3])
 		setObjectField(newItem, "OutputType", segments[3])
 		setObjectField(newItem, "Instance", segments[4])
 	}
@@ -125,12 +228,22 @@ func (dc *DomainCollection) HandleDiscovery(
 	return nil
 }
 
+// IsBulkLoading returns true if the collection defers building its indexes, see StartBulkLoad
+func (dc *DomainCollection) IsBulkLoading() bool {
+	dc.UpdateMutex.RLock()
+	defer dc.UpdateMutex.RUnlock()
+	return dc.bulkTimer != nil
+}
+
 // Remove removes an object using its address.
 // If the object doesn't exist, this is ignored.
 func (dc *DomainCollection) Remove(address string) {
 	base := MakeBaseAddress(address)
 	dc.UpdateMutex.Lock()
 	defer dc.UpdateMutex.Unlock()
+	if dc.bulkTimer == nil {
+		dc.removeFromIndex(base)
+	}
 	delete(dc.DiscoMap, base)
 	dc.updateCount++
 }
@@ -150,10 +263,35 @@ func (dc *DomainCollection) Update(address string, objectPtr interface{}) {
 	dc.UpdateMutex.Lock()
 	defer dc.UpdateMutex.Unlock()
 	base := MakeBaseAddress(address)
+	if dc.bulkTimer != nil {
+		// indexes are built when the burst of updates subsides
+		dc.DiscoMap[base] = objectPtr
+		dc.updateCount++
+		dc.extendBulkLoad()
+		return
+	}
+	dc.removeFromIndex(base)
 	dc.DiscoMap[base] = objectPtr
+	dc.addToIndex(base, objectPtr)
 	dc.updateCount++
 }
 
+// StartBulkLoad defers building the indexes while a burst of updates is received, eg the retained
+// discovery messages when subscribing to a large domain. Bulk loading ends when no updates are
+// received within the quiet period, or after MaxBulkLoadPeriod, after which the indexes are built
+// once. Lookups by pattern and type remain available while bulk loading, by scanning all items.
+//  quietPeriod is the time without updates after which bulk loading ends
+func (dc *DomainCollection) StartBulkLoad(quietPeriod time.Duration) {
+	dc.UpdateMutex.Lock()
+	defer dc.UpdateMutex.Unlock()
+	if dc.bulkTimer != nil {
+		dc.bulkTimer.Stop()
+	}
+	dc.bulkQuiet = quietPeriod
+	dc.bulkDeadline = time.Now().Add(MaxBulkLoadPeriod * time.Second)
+	dc.bulkTimer = time.AfterFunc(quietPeriod, dc.EndBulkLoad)
+}
+
 // UpdateCount returns the nr of updates taken place.
 func (dc *DomainCollection) UpdateCount() int {
 	return dc.updateCount
@@ -174,6 +312,76 @@ func MakeBaseAddress(address string) string {
 	return baseAddr
 }
 
+// extendBulkLoad restarts the quiet period of bulk loading, up to the bulk load deadline
+// This must be called with the update lock held.
+func (dc *DomainCollection) extendBulkLoad() {
+	remaining := time.Until(dc.bulkDeadline)
+	if remaining > dc.bulkQuiet {
+		remaining = dc.bulkQuiet
+	}
+	dc.bulkTimer.Reset(remaining)
+}
+
+// addToIndex adds the base address of an item to the segment and type indexes
+// This must be called with the update lock held.
+func (dc *DomainCollection) addToIndex(base string, item interface{}) {
+	for index, segment := range strings.Split(base, "/") {
+		key := segmentKey(index, segment)
+		if dc.segmentIndex[key] == nil {
+			dc.segmentIndex[key] = make(map[string]bool)
+		}
+		dc.segmentIndex[key][base] = true
+	}
+	if dc.GetItemType != nil {
+		itemType := dc.GetItemType(item)
+		if dc.typeIndex[itemType] == nil {
+			dc.typeIndex[itemType] = make(map[string]bool)
+		}
+		dc.typeIndex[itemType][base] = true
+	}
+}
+
+// removeFromIndex removes the base address of an existing item from the segment and type indexes
+// This must be called with the update lock held.
+func (dc *DomainCollection) removeFromIndex(base string) {
+	item, found := dc.DiscoMap[base]
+	if !found {
+		return
+	}
+	for index, segment := range strings.Split(base, "/") {
+		key := segmentKey(index, segment)
+		delete(dc.segmentIndex[key], base)
+		if len(dc.segmentIndex[key]) == 0 {
+			delete(dc.segmentIndex, key)
+		}
+	}
+	if dc.GetItemType != nil {
+		itemType := dc.GetItemType(item)
+		delete(dc.typeIndex[itemType], base)
+		if len(dc.typeIndex[itemType]) == 0 {
+			delete(dc.typeIndex, itemType)
+		}
+	}
+}
+
+// matchPattern returns true if the address segments match the pattern segments with '+' wildcards
+func matchPattern(addrSegments []string, patternSegments []string) bool {
+	if len(addrSegments) != len(patternSegments) {
+		return false
+	}
+	for index, segment := range patternSegments {
+		if segment != "+" && segment != addrSegments[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// segmentKey returns the key of an address segment in the segment index
+func segmentKey(index int, segment string) string {
+	return strconv.Itoa(index) + "/" + segment
+}
+
 func setObjectField(object interface{}, fieldName string, value string) {
 	valueType := reflect.ValueOf(object).Elem()
 	field := valueType.FieldByName(fieldName)
@@ -191,7 +399,9 @@ func NewDomainCollection(itemPtr reflect.Type, getPublicKey func(string) *ecdsa.
 		DiscoMap:     make(map[string]interface{}),
 		GetPublicKey: getPublicKey,
 		ItemPtr:      itemPtr,
-		UpdateMutex:  &sync.Mutex{},
+		UpdateMutex:  &sync.RWMutex{},
+		segmentIndex: make(map[string]map[string]bool),
+		typeIndex:    make(map[string]map[string]bool),
 	}
 	return domainCollection
 }
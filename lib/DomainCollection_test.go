@@ -1,8 +1,10 @@
 package lib_test
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
@@ -65,6 +67,48 @@ func TestCreateCollection(t *testing.T) {
 	item1b = c.GetByAddress(item1Addr)
 	require.Nil(t, item1b, "Item still there after remove")
 }
+func TestFilteredCollection(t *testing.T) {
+	signer := messaging.NewMessageSigner(messaging.NewDummyMessenger(nil), nil, nil)
+	c := lib.NewDomainCollection(reflect.TypeOf(&ItemType{}), signer.GetPublicKey)
+	c.GetItemType = func(item interface{}) string {
+		return item.(*ItemType).Name
+	}
+	c.Update("domain/pub1/node1/switch/0/$input", &ItemType{Name: "switch"})
+	c.Update("domain/pub1/node2/switch/0/$input", &ItemType{Name: "switch"})
+	c.Update("domain/pub2/node1/dimmer/0/$input", &ItemType{Name: "dimmer"})
+	c.Update("domain/pub2/node1", &ItemType{Name: "node"})
+
+	itemList := make([]*ItemType, 0)
+	c.GetByType("switch", &itemList)
+	assert.Equal(t, 2, len(itemList))
+	itemList = make([]*ItemType, 0)
+	c.GetByPattern("domain/+/node1/+/+/$input", &itemList)
+	assert.Equal(t, 2, len(itemList))
+	itemList = make([]*ItemType, 0)
+	c.GetByPattern("domain/+/+/switch/0", &itemList)
+	assert.Equal(t, 2, len(itemList))
+	itemList = make([]*ItemType, 0)
+	c.GetByPattern("+/+/+", &itemList)
+	require.Equal(t, 1, len(itemList))
+	assert.Equal(t, "node", itemList[0].Name)
+
+	// replacing and removing items updates the indexes
+	c.Update("domain/pub1/node2/switch/0", &ItemType{Name: "dimmer"})
+	c.Remove("domain/pub1/node1/switch/0/$input")
+	itemList = make([]*ItemType, 0)
+	c.GetByType("switch", &itemList)
+	assert.Equal(t, 0, len(itemList))
+	itemList = make([]*ItemType, 0)
+	c.GetByType("dimmer", &itemList)
+	assert.Equal(t, 2, len(itemList))
+	itemList = make([]*ItemType, 0)
+	c.GetByPattern("domain/pub1/node1/+/+", &itemList)
+	assert.Equal(t, 0, len(itemList))
+	itemList = make([]*ItemType, 0)
+	c.GetByPattern("other/+/+/+/+", &itemList)
+	assert.Equal(t, 0, len(itemList))
+}
+
 func TestDiscovery(t *testing.T) {
 	const itemAddr = "test/pub1/node1/type/instance"
 	errCount := 0
@@ -103,3 +147,46 @@ func TestMakeError(t *testing.T) {
 	testError := lib.MakeErrorf("This is a test error")
 	assert.Error(t, testError, "Expected to see an error")
 }
+
+func TestBulkLoad(t *testing.T) {
+	const itemCount = 1000
+	c := lib.NewDomainCollection(reflect.TypeOf(&ItemType{}), nil)
+	c.GetItemType = func(item interface{}) string {
+		return item.(*ItemType).Name
+	}
+	c.StartBulkLoad(100 * time.Millisecond)
+	assert.True(t, c.IsBulkLoading())
+	for i := 0; i < itemCount; i++ {
+		address := fmt.Sprintf("domain/pub%d/node%d/$node", i%10, i)
+		c.Update(address, &ItemType{Address: address, Name: fmt.Sprintf("type%d", i%2)})
+	}
+	c.Remove("domain/pub0/node0/$node")
+
+	// lookups remain available while the indexes are not built
+	var items []*ItemType
+	c.GetByPattern("domain/pub1/+", &items)
+	assert.Len(t, items, itemCount/10)
+	items = nil
+	c.GetByType("type0", &items)
+	assert.Len(t, items, itemCount/2-1)
+
+	// the indexes are built when the updates subside
+	time.Sleep(300 * time.Millisecond)
+	require.False(t, c.IsBulkLoading())
+	items = nil
+	c.GetByPattern("domain/pub1/+", &items)
+	assert.Len(t, items, itemCount/10)
+	items = nil
+	c.GetByType("type1", &items)
+	assert.Len(t, items, itemCount/2)
+	items = nil
+	c.GetByPattern("domain/pub0/node0", &items)
+	assert.Empty(t, items)
+
+	// ending explicitly is ignored when not bulk loading
+	c.EndBulkLoad()
+	c.Update("domain/pub0/node0/$node", &ItemType{Name: "type0"})
+	items = nil
+	c.GetByType("type0", &items)
+	assert.Len(t, items, itemCount/2)
+}
@@ -0,0 +1,67 @@
+// Package lib with detection of duplicate command messages
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// DefaultDedupWindow is the default time in seconds that received message IDs are remembered
+const DefaultDedupWindow = 60
+
+// MessageDeduplicator detects duplicate deliveries of messages with a message ID, for example
+// commands that are redelivered by the broker with QoS 1.
+type MessageDeduplicator struct {
+	received    map[string]time.Time // time messages were first received by sender and message ID
+	window      time.Duration        // time to remember received messages
+	updateMutex *sync.Mutex
+}
+
+// IsDuplicate returns true if a message with the same sender and message ID was received within the
+// window, and records the message otherwise. Messages without ID are never duplicates.
+func (dedup *MessageDeduplicator) IsDuplicate(sender string, messageID string) bool {
+	if messageID == "" {
+		return false
+	}
+	now := Now()
+	key := sender + "\n" + messageID
+	dedup.updateMutex.Lock()
+	defer dedup.updateMutex.Unlock()
+	// forget messages that are past the window
+	for receivedKey, received := range dedup.received {
+		if now.Sub(received) > dedup.window {
+			delete(dedup.received, receivedKey)
+		}
+	}
+	if _, found := dedup.received[key]; found {
+		return true
+	}
+	dedup.received[key] = now
+	return false
+}
+
+// SetWindow sets the time that received message IDs are remembered
+func (dedup *MessageDeduplicator) SetWindow(window time.Duration) {
+	dedup.updateMutex.Lock()
+	defer dedup.updateMutex.Unlock()
+	dedup.window = window
+}
+
+// MakeMessageID returns a new random message ID for command messages
+func MakeMessageID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// NewMessageDeduplicator creates a deduplicator of messages with the DefaultDedupWindow
+func NewMessageDeduplicator() *MessageDeduplicator {
+	dedup := &MessageDeduplicator{
+		received:    make(map[string]time.Time),
+		window:      DefaultDedupWindow * time.Second,
+		updateMutex: &sync.Mutex{},
+	}
+	return dedup
+}
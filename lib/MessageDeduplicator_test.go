@@ -0,0 +1,30 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageDeduplicator(t *testing.T) {
+	dedup := lib.NewMessageDeduplicator()
+	id1 := lib.MakeMessageID()
+	id2 := lib.MakeMessageID()
+	assert.NotEqual(t, id1, id2)
+
+	assert.False(t, dedup.IsDuplicate("sender1", id1))
+	assert.True(t, dedup.IsDuplicate("sender1", id1))
+	// the same ID from another sender is not a duplicate
+	assert.False(t, dedup.IsDuplicate("sender2", id1))
+	assert.False(t, dedup.IsDuplicate("sender1", id2))
+	// messages without ID are never duplicates
+	assert.False(t, dedup.IsDuplicate("sender1", ""))
+	assert.False(t, dedup.IsDuplicate("sender1", ""))
+
+	// messages are forgotten after the window
+	dedup.SetWindow(time.Millisecond)
+	time.Sleep(time.Millisecond * 2)
+	assert.False(t, dedup.IsDuplicate("sender1", id1))
+}
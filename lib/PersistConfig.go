@@ -2,9 +2,10 @@
 package lib
 
 import (
-	"io/ioutil"
+	"fmt"
 	"os"
 	"path"
+	"regexp"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -26,6 +27,10 @@ var DefaultConfigFolder = path.Join(UserHomeDir, ".config", "iotdomain")
 // DefaultCacheFolder for caching discovered nodes and other publishers
 var DefaultCacheFolder = path.Join(UserHomeDir, ".cache", "iotdomain")
 
+// DefaultCacheTTL is the default time in seconds that cached publishers and nodes remain valid
+// after they were last received
+const DefaultCacheTTL = 7 * 24 * 3600
+
 // LoadAppConfig loads the application configuration from a configuration file
 //
 // configFolder contains the location for the configuration files.
@@ -47,8 +52,27 @@ func LoadMessengerConfig(configFolder string, messengerConfig interface{}) error
 	return err
 }
 
+// MaxConfigIncludeDepth is the maximum nesting of configuration files that include other files
+const MaxConfigIncludeDepth = 10
+
+// envVarExpression matches environment variable expressions ${NAME} and ${NAME:-default}
+// An expression is escaped by doubling the '$', eg $${NAME}.
+var envVarExpression = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// configIncludes holds the include directive of a configuration file
+type configIncludes struct {
+	Include interface{} `yaml:"include"` // filename or list of filenames to include
+}
+
 // LoadYamlConfig parses the content of a yaml configuration file into the target object
-// It performs template substitution of expressions {publisher} and {hostname}
+// It performs template substitution of expressions {publisher} and {hostname} and interpolates
+// environment variables, see InterpolateEnv.
+//
+// A configuration file can include other configuration files with the 'include' directive, for
+// example a messenger configuration that is shared by the publishers on a host:
+//   include: shared-messenger.yaml
+// or a list of files. Included files are relative to the config folder and are loaded first, so
+// the including file overrides their settings.
 //
 // altConfigFolder contains the location for the configuration files.
 //   Use "" for default, which is <userhome>/.config/iotdomain
@@ -60,23 +84,67 @@ func LoadYamlConfig(configFolder string, filename string, publisherID string, ta
 	if configFolder == "" {
 		configFolder = DefaultConfigFolder
 	}
-	fullPath := path.Join(configFolder, filename)
-
-	rawConfig, err := ioutil.ReadFile(fullPath)
-	if err != nil {
-		log.Warningf("LoadYamlConfig: Unable to open configuration file %s: %s", filename, err)
-		return err
-	}
 	submap := make(map[string]string, 0)
 	submap["hostname"], _ = os.Hostname()
 	if publisherID != "" {
 		submap["publisher"] = publisherID
 	}
+	return loadYamlConfig(configFolder, filename, submap, target, []string{})
+}
+
+// loadYamlConfig loads a configuration file and the files it includes into the target object
+//  includedBy are the files that include this file, to detect circular includes
+func loadYamlConfig(configFolder string, filename string, submap map[string]string,
+	target interface{}, includedBy []string) error {
+
+	fullPath := filename
+	if !path.IsAbs(filename) {
+		fullPath = path.Join(configFolder, filename)
+	}
+	for _, includingFile := range includedBy {
+		if includingFile == fullPath {
+			return MakeErrorf("LoadYamlConfig: Configuration file %s includes itself", filename)
+		}
+	}
+	if len(includedBy) > MaxConfigIncludeDepth {
+		return MakeErrorf("LoadYamlConfig: Includes of configuration file %s are nested too deep", filename)
+	}
+
+	rawConfig, err := ReadFileWithRecovery(fullPath, ValidateYaml)
+	if err != nil {
+		log.Warningf("LoadYamlConfig: Unable to open configuration file %s: %s", filename, err)
+		return err
+	}
 
 	substituted := string(rawConfig)
 	for key, val := range submap {
 		substituted = strings.ReplaceAll(substituted, "{"+key+"}", val)
 	}
+	substituted, err = InterpolateEnv(substituted)
+	if err != nil {
+		return MakeErrorf("LoadYamlConfig: Configuration file %s: %s", filename, err)
+	}
+
+	// included files are loaded first so this file overrides their settings
+	includes := configIncludes{}
+	err = yaml.Unmarshal([]byte(substituted), &includes)
+	if err == nil {
+		includeList := make([]string, 0)
+		switch include := includes.Include.(type) {
+		case string:
+			includeList = append(includeList, include)
+		case []interface{}:
+			for _, item := range include {
+				includeList = append(includeList, fmt.Sprint(item))
+			}
+		}
+		for _, includeFile := range includeList {
+			err = loadYamlConfig(configFolder, includeFile, submap, target, append(includedBy, fullPath))
+			if err != nil {
+				return err
+			}
+		}
+	}
 
 	err = yaml.Unmarshal([]byte(substituted), target)
 	if err != nil {
@@ -85,3 +153,53 @@ func LoadYamlConfig(configFolder string, filename string, publisherID string, ta
 	}
 	return nil
 }
+
+// InterpolateEnv replaces the environment variable expressions ${NAME} in a configuration with the
+// value of the environment variable, eg to keep the broker password out of the configuration file.
+// Use ${NAME:-default} for a default value if the variable is not set, and $${NAME} for a
+// literal ${NAME}.
+// Returns an error if a variable without default is not set.
+func InterpolateEnv(config string) (string, error) {
+	var err error
+	interpolated := envVarExpression.ReplaceAllStringFunc(config, func(expression string) string {
+		if strings.HasPrefix(expression, "$$") {
+			return expression[1:]
+		}
+		match := envVarExpression.FindStringSubmatch(expression)
+		value, found := os.LookupEnv(match[1])
+		if !found && match[2] != "" {
+			value = match[3]
+		} else if !found && err == nil {
+			err = fmt.Errorf("environment variable '%s' is not set", match[1])
+		}
+		return value
+	})
+	return interpolated, err
+}
+
+// SaveYamlConfig saves an object as yaml configuration file. The configuration folder is created if
+// it doesn't exist.
+//
+// configFolder contains the location for the configuration files.
+//   Use "" for default, which is <userhome>/.config/iotdomain
+// filename has the name of the file to save
+// source is the object to save. This must have a yaml encoding set for the fields
+// returns error or nil on success
+func SaveYamlConfig(configFolder string, filename string, source interface{}) error {
+	if configFolder == "" {
+		configFolder = DefaultConfigFolder
+	}
+	fullPath := path.Join(configFolder, filename)
+	rawConfig, err := yaml.Marshal(source)
+	if err != nil {
+		return MakeErrorf("SaveYamlConfig: Unable to marshal configuration file %s: %s", filename, err)
+	}
+	err = os.MkdirAll(configFolder, 0750)
+	if err == nil {
+		err = WriteFileAtomic(fullPath, rawConfig, 0600)
+	}
+	if err != nil {
+		return MakeErrorf("SaveYamlConfig: Unable to save configuration file %s: %s", fullPath, err)
+	}
+	return nil
+}
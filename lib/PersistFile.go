@@ -0,0 +1,121 @@
+// Package lib with crash safe writing and reading of persistence files
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// BackupFileSuffix is appended to the name of a file to get the name of its previous version
+const BackupFileSuffix = ".bak"
+
+// tempFileSuffix is the suffix of the temporary file that is written before it replaces the file
+const tempFileSuffix = ".tmp"
+
+// WriteFileAtomic writes data to a file such that a power loss mid-write cannot leave a partial file.
+// The data is written to a temporary file in the same folder which is synced to disk before it
+// replaces the file.
+// The previous version of the file is kept as <filename>.bak, for use by ReadFileWithRecovery.
+//  perm is the permission of the file, eg 0600
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	file, err := ioutil.TempFile(path.Dir(filename), path.Base(filename)+".*"+tempFileSuffix)
+	if err != nil {
+		return err
+	}
+	tmpFilename := file.Name()
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFilename, perm)
+	}
+	if err != nil {
+		os.Remove(tmpFilename)
+		return err
+	}
+	// keep the previous version. A hard link keeps the file in place until it is replaced.
+	backupFilename := filename + BackupFileSuffix
+	if _, statErr := os.Stat(filename); statErr == nil {
+		os.Remove(backupFilename)
+		if os.Link(filename, backupFilename) != nil {
+			os.Rename(filename, backupFilename)
+		}
+	}
+	err = os.Rename(tmpFilename, filename)
+	if err != nil {
+		os.Remove(tmpFilename)
+		return err
+	}
+	// the rename is only durable once the folder is synced
+	if folder, err := os.Open(path.Dir(filename)); err == nil {
+		folder.Sync()
+		folder.Close()
+	}
+	return nil
+}
+
+// ReadFileWithRecovery reads a file that is written with WriteFileAtomic. If the content of the file
+// is invalid, or the file is missing because a write was interrupted before the temporary file
+// replaced it, the previous version of the file is read instead. A file that is missing for
+// another reason, eg it was never written or it was removed, is not recovered.
+//  validate is optional and returns an error if the content is invalid, eg ValidateJSON
+// Returns the content of the file or of its previous version, or the error of the file if the
+// previous version can't be used either.
+func ReadFileWithRecovery(filename string, validate func(data []byte) error) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err == nil && validate != nil {
+		err = validate(data)
+	}
+	if err == nil {
+		return data, nil
+	} else if os.IsNotExist(err) && !isWriteInterrupted(filename) {
+		return data, err
+	}
+	backupData, backupErr := ioutil.ReadFile(filename + BackupFileSuffix)
+	if backupErr == nil && validate != nil {
+		backupErr = validate(backupData)
+	}
+	if backupErr != nil {
+		return data, err
+	}
+	log.Warningf("ReadFileWithRecovery: Unable to use file %s: %s. Using its previous version.", filename, err)
+	return backupData, nil
+}
+
+// isWriteInterrupted returns true if a temporary file of WriteFileAtomic remains in the folder of the
+// file, which means a write was interrupted before the temporary file replaced the file.
+func isWriteInterrupted(filename string) bool {
+	prefix := path.Base(filename) + "."
+	files, _ := ioutil.ReadDir(path.Dir(filename))
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), prefix) && strings.HasSuffix(file.Name(), tempFileSuffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateJSON returns an error if data is not valid JSON
+func ValidateJSON(data []byte) error {
+	if !json.Valid(data) {
+		return errors.New("invalid JSON content")
+	}
+	return nil
+}
+
+// ValidateYaml returns an error if data is not valid YAML
+func ValidateYaml(data []byte) error {
+	var content interface{}
+	return yaml.Unmarshal(data, &content)
+}
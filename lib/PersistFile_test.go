@@ -0,0 +1,95 @@
+package lib_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	filename := path.Join(folder, "state.json")
+
+	err = lib.WriteFileAtomic(filename, []byte(`{"version":1}`), 0600)
+	require.NoError(t, err)
+	_, err = os.Stat(filename + lib.BackupFileSuffix)
+	assert.True(t, os.IsNotExist(err), "no previous version")
+
+	// the previous version is kept and no temporary file remains
+	err = lib.WriteFileAtomic(filename, []byte(`{"version":2}`), 0600)
+	require.NoError(t, err)
+	data, err := lib.ReadFileWithRecovery(filename, lib.ValidateJSON)
+	require.NoError(t, err)
+	assert.Equal(t, `{"version":2}`, string(data))
+	backup, err := ioutil.ReadFile(filename + lib.BackupFileSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, `{"version":1}`, string(backup))
+	files, err := ioutil.ReadDir(folder)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(files), "only the file and its previous version remain")
+
+	// read-only files are replaced
+	err = lib.WriteFileAtomic(filename, []byte(`{"version":3}`), 0400)
+	require.NoError(t, err)
+	err = lib.WriteFileAtomic(filename, []byte(`{"version":4}`), 0400)
+	require.NoError(t, err)
+
+	// writing into a missing folder fails
+	err = lib.WriteFileAtomic(path.Join(folder, "nofolder", "state.json"), []byte("{}"), 0600)
+	assert.Error(t, err)
+}
+
+func TestReadFileWithRecovery(t *testing.T) {
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	filename := path.Join(folder, "state.yaml")
+
+	// a missing file without previous version is reported as not existing
+	_, err = lib.ReadFileWithRecovery(filename, nil)
+	assert.True(t, os.IsNotExist(err))
+
+	err = lib.WriteFileAtomic(filename, []byte("version: 1\n"), 0600)
+	require.NoError(t, err)
+	err = lib.WriteFileAtomic(filename, []byte("version: 2\n"), 0600)
+	require.NoError(t, err)
+
+	// a corrupt file falls back to the previous version
+	err = ioutil.WriteFile(filename, []byte("version: [2"), 0600)
+	require.NoError(t, err)
+	data, err := lib.ReadFileWithRecovery(filename, lib.ValidateYaml)
+	require.NoError(t, err)
+	assert.Equal(t, "version: 1\n", string(data))
+
+	// without validation the content isn't checked
+	data, err = lib.ReadFileWithRecovery(filename, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "version: [2", string(data))
+
+	// a removed file is not recovered
+	os.Remove(filename)
+	_, err = lib.ReadFileWithRecovery(filename, lib.ValidateYaml)
+	assert.True(t, os.IsNotExist(err))
+
+	// a file that is missing after a power loss between renames falls back to the previous version
+	err = ioutil.WriteFile(filename+".123456.tmp", []byte("version: 3\n"), 0600)
+	require.NoError(t, err)
+	data, err = lib.ReadFileWithRecovery(filename, lib.ValidateYaml)
+	require.NoError(t, err)
+	assert.Equal(t, "version: 1\n", string(data))
+
+	// the error of the file is returned if the previous version is invalid too
+	err = ioutil.WriteFile(filename, []byte("{"), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filename+lib.BackupFileSuffix, []byte("{"), 0600)
+	require.NoError(t, err)
+	_, err = lib.ReadFileWithRecovery(filename, lib.ValidateJSON)
+	assert.Error(t, err)
+}
@@ -2,10 +2,14 @@
 package lib_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
 
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const PublisherID = "publisher1"
@@ -46,3 +50,48 @@ func TestMessengerConfig(t *testing.T) {
 	assert.NoError(t, err, "Failed loading app config")
 	assert.Equal(t, "localhost", messengerConfig.Server, "Messenger does not contain server address")
 }
+
+// TestConfigIncludes loads a configuration with environment variables and included files
+func TestConfigIncludes(t *testing.T) {
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	os.Setenv("IOTDOMAIN_TEST_SERVER", "broker.local")
+	defer os.Unsetenv("IOTDOMAIN_TEST_SERVER")
+	os.Unsetenv("IOTDOMAIN_TEST_PORT")
+	writeConfig := func(filename string, config string) {
+		err := ioutil.WriteFile(path.Join(folder, filename), []byte(config), 0600)
+		require.NoError(t, err)
+	}
+	writeConfig("shared.yaml", "server: ${IOTDOMAIN_TEST_SERVER}\nport: ${IOTDOMAIN_TEST_PORT:-8883}\n")
+	writeConfig("messenger.yaml", "include: shared.yaml\n")
+	writeConfig("publisher1.yaml", "include: [shared.yaml]\ncstring: $${NOT_INTERPOLATED}\ncnumber: 5\n")
+
+	messengerConfig := MessengerConfig{}
+	err = lib.LoadMessengerConfig(folder, &messengerConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "broker.local", messengerConfig.Server)
+	assert.Equal(t, uint16(8883), messengerConfig.Port)
+	appConfig := TestConfig{}
+	err = lib.LoadAppConfig(folder, PublisherID, &appConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "${NOT_INTERPOLATED}", appConfig.ConfigString)
+
+	// the including file overrides included settings
+	writeConfig("messenger.yaml", "include: shared.yaml\nport: 1883\n")
+	err = lib.LoadMessengerConfig(folder, &messengerConfig)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(1883), messengerConfig.Port)
+
+	// variables without default must be set
+	writeConfig("messenger.yaml", "server: ${IOTDOMAIN_TEST_UNSET}\n")
+	err = lib.LoadMessengerConfig(folder, &messengerConfig)
+	assert.Error(t, err)
+	// circular and missing includes are errors
+	writeConfig("messenger.yaml", "include: messenger.yaml\n")
+	err = lib.LoadMessengerConfig(folder, &messengerConfig)
+	assert.Error(t, err)
+	writeConfig("messenger.yaml", "include: missing.yaml\n")
+	err = lib.LoadMessengerConfig(folder, &messengerConfig)
+	assert.Error(t, err)
+}
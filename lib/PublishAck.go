@@ -0,0 +1,47 @@
+// Package lib with acknowledgement of commands
+package lib
+
+import (
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MakeAckAddress returns the address of the acknowledgement of a command by replacing the message
+// type of the command address with $ack, eg domain/publisher/node/type/instance/$ack
+func MakeAckAddress(commandAddress string) string {
+	segments := strings.Split(commandAddress, "/")
+	segments[len(segments)-1] = types.MessageTypeAck
+	return strings.Join(segments, "/")
+}
+
+// PublishAck publishes the signed acknowledgement of a command. Commands without message ID are not
+// acknowledged.
+//  commandAddress is the address the command was received on
+//  messageID is the message ID of the command
+//  sender is the address of the acknowledging publisher
+//  cmdErr is the reason the command is rejected, or nil if it is accepted
+func PublishAck(commandAddress string, messageID string, sender string, cmdErr error,
+	messageSigner *messaging.MessageSigner) {
+
+	if messageID == "" {
+		return
+	}
+	ackMessage := types.AckMessage{
+		Address:   MakeAckAddress(commandAddress),
+		MessageID: messageID,
+		Sender:    sender,
+		Status:    types.AckStatusAccepted,
+		Timestamp: Now().Format(types.TimeFormat),
+	}
+	if cmdErr != nil {
+		ackMessage.Status = types.AckStatusRejected
+		ackMessage.Error = cmdErr.Error()
+	}
+	err := messageSigner.PublishObject(ackMessage.Address, false, &ackMessage, nil)
+	if err != nil {
+		logrus.Warningf("PublishAck: Failed publishing acknowledgement of %s: %s", commandAddress, err)
+	}
+}
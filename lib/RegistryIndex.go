@@ -0,0 +1,44 @@
+// Package lib with secondary indexes of registries with many items
+package lib
+
+import "sort"
+
+// RegistryIndex is a secondary index of a registry that maps a key, eg the hardware ID of a node,
+// to the IDs of the items with that key. It lets registries with thousands of items find the items
+// of a key without iterating all items.
+// The index is not thread-safe and must be used within the locked section of its registry.
+type RegistryIndex map[string]map[string]bool
+
+// Add the ID of an item with the given key
+func (index RegistryIndex) Add(key string, id string) {
+	ids := index[key]
+	if ids == nil {
+		ids = make(map[string]bool)
+		index[key] = ids
+	}
+	ids[id] = true
+}
+
+// Count returns the nr of items with the given key
+func (index RegistryIndex) Count(key string) int {
+	return len(index[key])
+}
+
+// IDs returns the sorted IDs of the items with the given key
+func (index RegistryIndex) IDs(key string) []string {
+	ids := make([]string, 0, len(index[key]))
+	for id := range index[key] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Remove the ID of an item with the given key. Keys without items are removed.
+func (index RegistryIndex) Remove(key string, id string) {
+	ids := index[key]
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(index, key)
+	}
+}
@@ -0,0 +1,25 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryIndex(t *testing.T) {
+	index := make(lib.RegistryIndex)
+	index.Add("node1", "node1.switch.1")
+	index.Add("node1", "node1.switch.0")
+	index.Add("node1", "node1.switch.0")
+	index.Add("node2", "node2.switch.0")
+	assert.Equal(t, []string{"node1.switch.0", "node1.switch.1"}, index.IDs("node1"))
+	assert.Equal(t, 2, index.Count("node1"))
+	assert.Empty(t, index.IDs("node3"))
+
+	// keys without items are removed
+	index.Remove("node2", "node2.switch.0")
+	index.Remove("node3", "node3.switch.0")
+	assert.Equal(t, 0, index.Count("node2"))
+	assert.Equal(t, 1, len(index))
+}
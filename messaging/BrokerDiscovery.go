@@ -0,0 +1,95 @@
+// Package messaging - discovery of the message bus broker on the local network using mDNS/DNS-SD
+package messaging
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/discovery/mdns"
+	"github.com/sirupsen/logrus"
+)
+
+// Broker discovery defaults
+const (
+	MqttServiceType         = "_mqtt._tcp"     // DNS-SD service type of MQTT brokers
+	MdnsIPv4Address         = mdns.IPv4Address // mDNS IPv4 multicast address
+	MdnsIPv6Address         = mdns.IPv6Address // mDNS IPv6 link-local multicast address
+	DefaultDiscoveryTimeout = 3                // default discovery timeout in seconds
+)
+
+// DiscoveredBroker describes a broker that is discovered on the local network, with the host, port
+// and addresses of the service instance that advertises the broker
+type DiscoveredBroker mdns.ServiceInstance
+
+// Server returns the address to connect to the broker. This is the first IP address, or the host
+// name if no address is known.
+func (broker *DiscoveredBroker) Server() string {
+	if len(broker.Addresses) > 0 {
+		return broker.Addresses[0]
+	}
+	return strings.TrimSuffix(broker.Host, ".")
+}
+
+// DiscoverBrokers discovers brokers of the given service type on the local network. The query is
+// multicast with IPv4 and with IPv6 on each multicast capable interface. Brokers are returned in
+// order of discovery.
+//  service is the DNS-SD service type, eg MqttServiceType
+//  timeout is the time to wait for responses
+func DiscoverBrokers(service string, timeout time.Duration) ([]DiscoveredBroker, error) {
+	instances, err := mdns.Browse(service, timeout)
+	return toBrokers(instances), err
+}
+
+// QueryBrokers sends a DNS-SD query for the given service type to an mDNS address and collects
+// the brokers from the responses until the timeout expires.
+//  mdnsAddress is the multicast address of mDNS, or the address of a specific responder
+//  service is the DNS-SD service type, eg MqttServiceType
+//  timeout is the time to wait for responses
+func QueryBrokers(mdnsAddress string, service string, timeout time.Duration) ([]DiscoveredBroker, error) {
+	instances, err := mdns.Query(mdnsAddress, service, timeout)
+	return toBrokers(instances), err
+}
+
+// discoverBroker returns the server and port of the first broker discovered with mDNS. The given
+// server and port are returned if no broker is found.
+func discoverBroker(config *MessengerConfig, server string, port uint16) (string, uint16) {
+	service := config.DiscoveryService
+	if service == "" {
+		service = MqttServiceType
+	}
+	timeout := time.Duration(config.DiscoveryTimeout) * time.Second
+	if timeout == 0 {
+		timeout = DefaultDiscoveryTimeout * time.Second
+	}
+	brokers, err := DiscoverBrokers(service, timeout)
+	if err != nil || len(brokers) == 0 {
+		logrus.Warningf("discoverBroker: No broker of type %s found (%v). Using configured server %s", service, err, server)
+		return server, port
+	}
+	broker := brokers[0]
+	logrus.Infof("discoverBroker: Discovered broker %s on %s:%d", broker.Instance, broker.Server(), broker.Port)
+	// the configured port takes precedence, as the advertised port is not always the TLS port
+	if config.Port == 0 && broker.Port != 0 {
+		port = broker.Port
+	}
+	return broker.Server(), port
+}
+
+// makeBrokerURL returns the URL of the broker for the given scheme, server and port
+// IPv6 addresses are placed in brackets and the zone of link-local addresses is escaped.
+func makeBrokerURL(scheme string, server string, port uint16) string {
+	hostPort := net.JoinHostPort(server, strconv.Itoa(int(port)))
+	return fmt.Sprintf("%s://%s/", scheme, strings.Replace(hostPort, "%", "%25", 1))
+}
+
+// toBrokers converts the discovered service instances to brokers
+func toBrokers(instances []mdns.ServiceInstance) []DiscoveredBroker {
+	brokers := make([]DiscoveredBroker, 0, len(instances))
+	for _, instance := range instances {
+		brokers = append(brokers, DiscoveredBroker(instance))
+	}
+	return brokers
+}
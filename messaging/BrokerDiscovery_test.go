@@ -0,0 +1,96 @@
+package messaging_test
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// appendName appends a DNS name in label format
+func appendName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// appendRecord appends a resource record with the given name, type and data
+func appendRecord(msg []byte, name []byte, rrType uint16, data []byte) []byte {
+	msg = append(msg, name...)
+	header := make([]byte, 10)
+	binary.BigEndian.PutUint16(header[0:], rrType)
+	binary.BigEndian.PutUint16(header[2:], 1)
+	binary.BigEndian.PutUint32(header[4:], 120)
+	binary.BigEndian.PutUint16(header[8:], uint16(len(data)))
+	msg = append(msg, header...)
+	return append(msg, data...)
+}
+
+// makeBrokerResponse creates an mDNS response that advertises a broker
+func makeBrokerResponse() []byte {
+	msg := make([]byte, 12)
+	msg[2] = 0x84 // response, authoritative
+	binary.BigEndian.PutUint16(msg[6:], 1)
+	binary.BigEndian.PutUint16(msg[10:], 3)
+	// PTR record with the instance name, using a pointer to the service name
+	serviceOffset := len(msg)
+	msg = appendRecord(msg, appendName(nil, "_mqtt._tcp.local."), 12,
+		append([]byte{9}, append([]byte("mosquitto"), 0xC0, byte(serviceOffset))...))
+	// SRV record of the instance with port and target host
+	srvData := []byte{0, 0, 0, 0, 0x07, 0x5B} // priority, weight, port 1883
+	srvData = appendName(srvData, "broker.local.")
+	msg = appendRecord(msg, appendName(nil, "mosquitto._mqtt._tcp.local."), 33, srvData)
+	// TXT record of the instance
+	txtData := append([]byte{7}, "txtvers"...)
+	txtData = append(txtData, append([]byte{8}, "md=Pi 4B"...)...)
+	msg = appendRecord(msg, appendName(nil, "mosquitto._mqtt._tcp.local."), 16, txtData)
+	// A record of the target host
+	msg = appendRecord(msg, appendName(nil, "broker.local."), 1, []byte{192, 168, 0, 10})
+	return msg
+}
+
+func TestQueryBrokers(t *testing.T) {
+	// fake responder that answers queries for the mqtt service
+	responder, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer responder.Close()
+	go func() {
+		buffer := make([]byte, 1500)
+		n, source, err := responder.ReadFromUDP(buffer)
+		if err == nil && strings.Contains(string(buffer[:n]), "_mqtt") {
+			responder.WriteToUDP([]byte("invalid"), source)
+			responder.WriteToUDP(makeBrokerResponse(), source)
+		}
+	}()
+
+	brokers, err := messaging.QueryBrokers(responder.LocalAddr().String(), messaging.MqttServiceType, time.Millisecond*300)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(brokers))
+	assert.Equal(t, "mosquitto._mqtt._tcp.local.", brokers[0].Instance)
+	assert.Equal(t, "broker.local.", brokers[0].Host)
+	assert.Equal(t, uint16(1883), brokers[0].Port)
+	assert.Equal(t, "192.168.0.10", brokers[0].Server())
+	assert.Equal(t, map[string]string{"txtvers": "", "md": "Pi 4B"}, brokers[0].Text)
+
+	// no responder
+	brokers, err = messaging.QueryBrokers(responder.LocalAddr().String(), messaging.MqttServiceType, time.Millisecond*100)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(brokers))
+
+	_, err = messaging.QueryBrokers("not an address", messaging.MqttServiceType, time.Millisecond)
+	assert.Error(t, err)
+}
+
+func TestDiscoveredBrokerServer(t *testing.T) {
+	broker := messaging.DiscoveredBroker{Host: "broker.local."}
+	assert.Equal(t, "broker.local", broker.Server())
+	broker.Addresses = []string{"fe80::1%eth0"}
+	assert.Equal(t, "fe80::1%eth0", broker.Server())
+}
@@ -0,0 +1,58 @@
+// Package messaging - failover between the brokers of a high availability broker cluster
+package messaging
+
+import (
+	"net"
+	"strconv"
+)
+
+// BrokerURLs returns the URLs of the brokers to connect to, in order of preference. The server is
+// the primary broker, followed by the brokers in the Servers list of the configuration.
+// Duplicate brokers are removed.
+//  server is the configured or discovered broker host, "" if only the Servers list is used
+//  port is the port of brokers that don't include a port
+func BrokerURLs(config *MessengerConfig, server string, port uint16) []string {
+	brokerURLs := make([]string, 0, len(config.Servers)+1)
+	isAdded := make(map[string]bool)
+	addBroker := func(host string, port uint16) {
+		brokerURL := makeBrokerURL("tls", host, port)
+		if host != "" && !isAdded[brokerURL] {
+			isAdded[brokerURL] = true
+			brokerURLs = append(brokerURLs, brokerURL)
+		}
+	}
+	addBroker(server, port)
+	for _, endpoint := range config.Servers {
+		host, portText, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			// the endpoint has no port
+			addBroker(endpoint, port)
+		} else if brokerPort, err := strconv.ParseUint(portText, 10, 16); err == nil {
+			addBroker(host, uint16(brokerPort))
+		}
+	}
+	return brokerURLs
+}
+
+// BrokerFailoverOrder returns the indexes of the brokers in the order they are tried when
+// connecting.
+// Without round-robin the brokers are always tried in order of preference, so the primary broker
+// is used when it is available. With round-robin a failover starts with the broker after the
+// broker that was last connected, which spreads the clients of a cluster over its brokers.
+//  brokerCount is the number of brokers
+//  lastBroker is the index of the broker that was last connected
+//  failover is true when the connection to the last broker is lost, false on the initial connect
+func BrokerFailoverOrder(config *MessengerConfig, brokerCount int, lastBroker int, failover bool) []int {
+	start := 0
+	if config.RoundRobin && brokerCount > 0 {
+		start = lastBroker % brokerCount
+		if failover {
+			start = (lastBroker + 1) % brokerCount
+		}
+	}
+	order := make([]int, brokerCount)
+	for i := range order {
+		order[i] = (start + i) % brokerCount
+	}
+	return order
+}
@@ -0,0 +1,41 @@
+package messaging_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerURLs(t *testing.T) {
+	config := &messaging.MessengerConfig{
+		Server:  "broker1",
+		Servers: []string{"broker2:1883", "broker1", "[fe80::1%eth0]:8884", "broker3"},
+	}
+	brokerURLs := messaging.BrokerURLs(config, config.Server, 8883)
+	assert.Equal(t, []string{
+		"tls://broker1:8883/",
+		"tls://broker2:1883/",
+		"tls://[fe80::1%25eth0]:8884/",
+		"tls://broker3:8883/",
+	}, brokerURLs)
+
+	// without server only the list is used
+	config = &messaging.MessengerConfig{Servers: []string{"broker2"}}
+	assert.Equal(t, []string{"tls://broker2:8883/"}, messaging.BrokerURLs(config, "", 8883))
+	assert.Empty(t, messaging.BrokerURLs(&messaging.MessengerConfig{}, "", 8883))
+}
+
+func TestBrokerFailoverOrder(t *testing.T) {
+	// ordered failover always prefers the primary broker
+	config := &messaging.MessengerConfig{}
+	assert.Equal(t, []int{0, 1, 2}, messaging.BrokerFailoverOrder(config, 3, 0, false))
+	assert.Equal(t, []int{0, 1, 2}, messaging.BrokerFailoverOrder(config, 3, 1, true))
+
+	// round-robin starts with the next broker on failover
+	config.RoundRobin = true
+	assert.Equal(t, []int{0, 1, 2}, messaging.BrokerFailoverOrder(config, 3, 0, false))
+	assert.Equal(t, []int{1, 2, 0}, messaging.BrokerFailoverOrder(config, 3, 0, true))
+	assert.Equal(t, []int{0, 1, 2}, messaging.BrokerFailoverOrder(config, 3, 2, true))
+	assert.Empty(t, messaging.BrokerFailoverOrder(config, 0, 0, true))
+}
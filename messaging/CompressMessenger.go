@@ -0,0 +1,200 @@
+// Package messaging - Compression of large message payloads
+package messaging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ContentEncodingGzip is the content encoding of gzip compressed messages
+const ContentEncodingGzip = "gzip"
+
+// DefaultMaxDecompressedSize is the default max size in bytes of a decompressed received message
+const DefaultMaxDecompressedSize = 4 * 1024 * 1024
+
+// compressedPrefix is the start of the JSON encoded envelope of compressed messages
+const compressedPrefix = `{"contentEncoding":`
+
+// CompressedMessage is the envelope of a compressed message
+type CompressedMessage struct {
+	ContentEncoding string `json:"contentEncoding"` // encoding of the payload, ContentEncodingGzip
+	Payload         []byte `json:"payload"`         // the compressed message, base64 encoded in JSON
+}
+
+// compressSubscription maps a subscription to the subscription that decompresses its messages
+type compressSubscription struct {
+	address           string
+	handler           func(address string, message string) error
+	decompressHandler func(address string, message string) error
+}
+
+// CompressMessenger compresses publications that are larger than a threshold, eg history and
+// batch discovery messages, and decompresses received messages. Compressed messages are published
+// in a CompressedMessage envelope that holds the content encoding. The complete message is
+// compressed, so signatures and encryption are not affected.
+//
+// Decompression of received messages is optional, so consumers can receive compressed messages
+// regardless of their own threshold. Decompressed messages are limited in size, as they are not
+// yet authenticated and a small compressed message can otherwise exhaust memory. Uncompressed
+// messages are passed as-is.
+type CompressMessenger struct {
+	maxSize       int                    // max size in bytes of decompressed messages, 0 to not decompress
+	messenger     IMessenger             // messenger of the message bus
+	subscriptions []compressSubscription // subscriptions that decompress messages
+	threshold     int                    // min message size in bytes to compress, 0 to not compress
+	updateMutex   *sync.Mutex            // mutex for concurrent subscriptions
+}
+
+// Connect the messenger
+func (compressor *CompressMessenger) Connect(lastWillAddress string, lastWillValue string) error {
+	return compressor.messenger.Connect(lastWillAddress, lastWillValue)
+}
+
+// ConnectionState returns the state of the connection of the wrapped messenger
+func (compressor *CompressMessenger) ConnectionState() ConnectionState {
+	return compressor.messenger.ConnectionState()
+}
+
+// Disconnect the messenger
+func (compressor *CompressMessenger) Disconnect() {
+	compressor.messenger.Disconnect()
+}
+
+// Publish a message, compressed if it is larger than the threshold and compression reduces its size
+func (compressor *CompressMessenger) Publish(address string, retained bool, message string) error {
+	if compressor.threshold > 0 && len(message) > compressor.threshold {
+		compressed, err := CompressMessage(message)
+		if err != nil {
+			logrus.Warningf("CompressMessenger.Publish: Unable to compress message on %s: %s", address, err)
+		} else if len(compressed) < len(message) {
+			message = compressed
+		}
+	}
+	return compressor.messenger.Publish(address, retained, message)
+}
+
+// Request publishes a request and waits for the reply. Large requests and replies are compressed.
+func (compressor *CompressMessenger) Request(address string, payload string, timeout time.Duration) (string, error) {
+	return SendRequest(compressor, address, payload, timeout)
+}
+
+// SetConnectionHandler sets the handler of connection changes
+func (compressor *CompressMessenger) SetConnectionHandler(handler func(connected bool, err error)) {
+	compressor.messenger.SetConnectionHandler(handler)
+}
+
+// Subscribe to an address and decompress the received messages if decompression is enabled
+func (compressor *CompressMessenger) Subscribe(address string, onMessage func(address string, message string) error) {
+	subscription := compressSubscription{
+		address: address,
+		handler: onMessage,
+		decompressHandler: func(rxAddress string, message string) error {
+			if compressor.maxSize <= 0 {
+				return onMessage(rxAddress, message)
+			}
+			decompressed, err := DecompressMessage(message, compressor.maxSize)
+			if err != nil {
+				logrus.Warningf("CompressMessenger.Subscribe: Ignored message on %s: %s", rxAddress, err)
+				return err
+			}
+			return onMessage(rxAddress, decompressed)
+		},
+	}
+	compressor.updateMutex.Lock()
+	compressor.subscriptions = append(compressor.subscriptions, subscription)
+	compressor.updateMutex.Unlock()
+	compressor.messenger.Subscribe(address, subscription.decompressHandler)
+}
+
+// Unsubscribe from an address
+// If onMessage is nil then all subscriptions with the address are removed
+func (compressor *CompressMessenger) Unsubscribe(address string, onMessage func(address string, message string) error) {
+	compressor.updateMutex.Lock()
+	remaining := make([]compressSubscription, 0, len(compressor.subscriptions))
+	removed := make([]compressSubscription, 0)
+	for _, sub := range compressor.subscriptions {
+		isMatch := sub.address == address && (onMessage == nil || isSameHandler(sub.handler, onMessage))
+		if isMatch && (onMessage == nil || len(removed) == 0) {
+			removed = append(removed, sub)
+		} else {
+			remaining = append(remaining, sub)
+		}
+	}
+	compressor.subscriptions = remaining
+	compressor.updateMutex.Unlock()
+	if onMessage == nil {
+		compressor.messenger.Unsubscribe(address, nil)
+		return
+	}
+	for _, sub := range removed {
+		compressor.messenger.Unsubscribe(sub.address, sub.decompressHandler)
+	}
+}
+
+// CompressMessage compresses a message with gzip and returns its JSON encoded envelope
+func CompressMessage(message string) (string, error) {
+	buffer := bytes.Buffer{}
+	writer := gzip.NewWriter(&buffer)
+	_, err := writer.Write([]byte(message))
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return "", err
+	}
+	envelope, err := json.Marshal(CompressedMessage{ContentEncoding: ContentEncodingGzip, Payload: buffer.Bytes()})
+	return string(envelope), err
+}
+
+// DecompressMessage returns the decompressed message of a compressed message envelope.
+// Messages that are not compressed are returned as-is.
+//  maxSize is the max size in bytes of the decompressed message
+// Returns an error if the envelope is invalid, its content encoding is not supported or the
+// decompressed message is larger than maxSize.
+func DecompressMessage(message string, maxSize int) (string, error) {
+	if !strings.HasPrefix(message, compressedPrefix) {
+		return message, nil
+	}
+	envelope := CompressedMessage{}
+	err := json.Unmarshal([]byte(message), &envelope)
+	if err != nil {
+		return "", fmt.Errorf("DecompressMessage: Invalid compressed message envelope: %s", err)
+	} else if envelope.ContentEncoding != ContentEncodingGzip {
+		return "", fmt.Errorf("DecompressMessage: Unsupported content encoding '%s'", envelope.ContentEncoding)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(envelope.Payload))
+	if err != nil {
+		return "", fmt.Errorf("DecompressMessage: Invalid gzip payload: %s", err)
+	}
+	// read one byte more than allowed to detect messages that are too large
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return "", fmt.Errorf("DecompressMessage: Invalid gzip payload: %s", err)
+	} else if len(decompressed) > maxSize {
+		return "", fmt.Errorf("DecompressMessage: Decompressed message exceeds %d bytes", maxSize)
+	}
+	return string(decompressed), nil
+}
+
+// NewCompressMessenger wraps a messenger with compression of large messages
+//  messenger is the messenger of the message bus
+//  threshold is the min size in bytes of messages to compress, 0 to only decompress received messages
+//  maxDecompressedSize is the max size in bytes of decompressed received messages, 0 to not decompress
+func NewCompressMessenger(messenger IMessenger, threshold int, maxDecompressedSize int) *CompressMessenger {
+	return &CompressMessenger{
+		maxSize:       maxDecompressedSize,
+		messenger:     messenger,
+		subscriptions: make([]compressSubscription, 0),
+		threshold:     threshold,
+		updateMutex:   &sync.Mutex{},
+	}
+}
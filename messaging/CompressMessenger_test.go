@@ -0,0 +1,83 @@
+package messaging_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressMessenger(t *testing.T) {
+	const historyAddr = "domain1/pub1/node1/temperature/0/$history"
+	const latestAddr = "domain1/pub1/node1/temperature/0/$latest"
+	dummy := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	compressor := messaging.NewCompressMessenger(dummy, 1000, messaging.DefaultMaxDecompressedSize)
+	rxMessages := make([]string, 0)
+	handler := func(address string, message string) error {
+		rxMessages = append(rxMessages, message)
+		return nil
+	}
+	compressor.Subscribe("domain1/+/+/+/+/+", handler)
+
+	// large messages are compressed, small messages are not
+	history := `{"address":"` + historyAddr + `","history":[` + strings.Repeat(`{"value":"20.5"},`, 1000) + `]}`
+	compressor.Publish(historyAddr, true, history)
+	compressor.Publish(latestAddr, true, `{"value":"20.5"}`)
+	published := dummy.FindLastPublication(historyAddr)
+	assert.True(t, strings.HasPrefix(published, `{"contentEncoding":"gzip"`))
+	assert.Less(t, len(published), len(history)/10)
+	assert.Equal(t, `{"value":"20.5"}`, dummy.FindLastPublication(latestAddr))
+	require.Equal(t, 2, len(rxMessages))
+	assert.Equal(t, history, rxMessages[0])
+	assert.Equal(t, `{"value":"20.5"}`, rxMessages[1])
+
+	// unsupported encodings are rejected
+	dummy.Publish(historyAddr, false, `{"contentEncoding":"zstd","payload":""}`)
+	assert.Equal(t, 2, len(rxMessages))
+
+	compressor.Unsubscribe("domain1/+/+/+/+/+", handler)
+	compressor.Publish(latestAddr, true, `{"value":"21.5"}`)
+	assert.Equal(t, 2, len(rxMessages))
+
+	// decompression is opt-in
+	dummy2 := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	compressOnly := messaging.NewCompressMessenger(dummy2, 1000, 0)
+	compressOnly.Subscribe("domain1/+/+/+/+/+", handler)
+	compressOnly.Publish(historyAddr, true, history)
+	require.Equal(t, 3, len(rxMessages))
+	assert.True(t, strings.HasPrefix(rxMessages[2], `{"contentEncoding":"gzip"`))
+
+	// configured messengers compress and decompress messages
+	messenger := messaging.NewMessenger(&messaging.MessengerConfig{CompressThreshold: 100, Decompress: true})
+	_, isCompressor := messenger.(*messaging.CompressMessenger)
+	assert.True(t, isCompressor)
+	messenger = messaging.NewMessenger(&messaging.MessengerConfig{})
+	_, isCompressor = messenger.(*messaging.CompressMessenger)
+	assert.False(t, isCompressor)
+}
+
+func TestDecompressMessage(t *testing.T) {
+	compressed, err := messaging.CompressMessage("hello world")
+	require.NoError(t, err)
+	message, err := messaging.DecompressMessage(compressed, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", message)
+	message, err = messaging.DecompressMessage("hello world", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", message)
+	_, err = messaging.DecompressMessage(`{"contentEncoding":"gzip","payload":"aGVsbG8="}`, 100)
+	assert.Error(t, err)
+	_, err = messaging.DecompressMessage(`{"contentEncoding":`, 100)
+	assert.Error(t, err)
+
+	// error case - a small message that decompresses into a large message is rejected
+	bomb, err := messaging.CompressMessage(strings.Repeat("0", 10*1024*1024))
+	require.NoError(t, err)
+	assert.Less(t, len(bomb), 100*1024)
+	_, err = messaging.DecompressMessage(bomb, messaging.DefaultMaxDecompressedSize)
+	assert.Error(t, err)
+	_, err = messaging.DecompressMessage(compressed, len("hello world"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,97 @@
+// Package messaging with decoding of received messages using pooled buffers
+package messaging
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// MaxPooledBufferSize is the max capacity of decoding buffers that are returned to the pool. Larger
+// buffers, eg of images, are left to the garbage collector so the pool doesn't hold on to them.
+const MaxPooledBufferSize = 64 * 1024
+
+// decodeBufferPool holds the buffers for decoding received messages, see decodeJSON
+var decodeBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// compactES256 is a compact serialized JWS message with the ES256 header as created by
+// createES256Signature, eg a discovery message of a publisher using this library
+type compactES256 struct {
+	signingInput string // the signed header.payload
+	signature    string // base64url encoded R and S of the signature
+}
+
+// decodePayload decodes the JSON payload of the message into object. The payload is base64 decoded
+// as a stream into a pooled buffer, so no intermediate copies of the message are allocated.
+func (jws *compactES256) decodePayload(object interface{}) error {
+	buffer := getDecodeBuffer()
+	defer putDecodeBuffer(buffer)
+	encoded := strings.NewReader(jws.signingInput[len(es256Header)+1:])
+	if _, err := buffer.ReadFrom(base64.NewDecoder(base64.RawURLEncoding, encoded)); err != nil {
+		return err
+	}
+	return json.Unmarshal(buffer.Bytes(), object)
+}
+
+// verify returns true if the signature of the message verifies with the P-256 public key
+func (jws *compactES256) verify(publicKey *ecdsa.PublicKey) bool {
+	const signatureSize = 64 // R and S of 32 bytes each
+	if publicKey.Curve != elliptic.P256() ||
+		len(jws.signature) != base64.RawURLEncoding.EncodedLen(signatureSize) {
+		return false
+	}
+	var signature [signatureSize]byte
+	if _, err := base64.RawURLEncoding.Decode(signature[:], []byte(jws.signature)); err != nil {
+		return false
+	}
+	hashed := sha256.Sum256([]byte(jws.signingInput))
+	r := new(big.Int).SetBytes(signature[:signatureSize/2])
+	s := new(big.Int).SetBytes(signature[signatureSize/2:])
+	return ecdsa.Verify(publicKey, hashed[:], r, s)
+}
+
+// decodeJSON unmarshals an unsigned JSON message into object using a pooled buffer
+func decodeJSON(rawMessage string, object interface{}) error {
+	buffer := getDecodeBuffer()
+	defer putDecodeBuffer(buffer)
+	buffer.WriteString(rawMessage)
+	return json.Unmarshal(buffer.Bytes(), object)
+}
+
+// getDecodeBuffer returns an empty buffer from the pool
+func getDecodeBuffer() *bytes.Buffer {
+	buffer := decodeBufferPool.Get().(*bytes.Buffer)
+	buffer.Reset()
+	return buffer
+}
+
+// parseCompactES256 splits a compact serialized JWS message with the ES256 header. Messages with
+// other headers or serializations are not recognized and are left to the generic JOSE parser.
+// Returns false if the message is not a compact ES256 message.
+func parseCompactES256(rawMessage string) (jws compactES256, ok bool) {
+	if !strings.HasPrefix(rawMessage, es256Header+".") {
+		return jws, false
+	}
+	lastDot := strings.LastIndexByte(rawMessage, '.')
+	if lastDot <= len(es256Header) || strings.Count(rawMessage, ".") != 2 {
+		return jws, false
+	}
+	jws.signingInput = rawMessage[:lastDot]
+	jws.signature = rawMessage[lastDot+1:]
+	return jws, true
+}
+
+// putDecodeBuffer returns a buffer to the pool unless it has grown too large
+func putDecodeBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() <= MaxPooledBufferSize {
+		decodeBufferPool.Put(buffer)
+	}
+}
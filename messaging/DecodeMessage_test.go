@@ -0,0 +1,68 @@
+package messaging_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestDecodeCompactMessage(t *testing.T) {
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	payload, _ := json.Marshal(testObject)
+	message, err := messaging.CreateJWSSignature(string(payload), privKey)
+	require.NoError(t, err)
+
+	var received TestObjectWithSender
+	isSigned, err := messaging.VerifySenderJWSSignature(message, &received, getPubKey)
+	assert.NoError(t, err)
+	assert.True(t, isSigned)
+	assert.Equal(t, testObject, received)
+
+	// messages signed with the generic JOSE signer also verify
+	joseSigner, _ := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: privKey},
+		&jose.SignerOptions{ExtraHeaders: map[jose.HeaderKey]interface{}{"kid": "key1"}})
+	signed, _ := joseSigner.Sign(payload)
+	joseMessage, _ := signed.CompactSerialize()
+	received = TestObjectWithSender{}
+	_, err = messaging.VerifySenderJWSSignature(joseMessage, &received, getPubKey)
+	assert.NoError(t, err)
+	assert.Equal(t, testObject, received)
+
+	// large payloads are decoded but their buffer is not kept in the pool
+	largeObject := testObject
+	largeObject.Field1 = strings.Repeat("x", messaging.MaxPooledBufferSize)
+	payload, _ = json.Marshal(largeObject)
+	message2, _ := messaging.CreateJWSSignature(string(payload), privKey)
+	received = TestObjectWithSender{}
+	_, err = messaging.VerifySenderJWSSignature(message2, &received, getPubKey)
+	assert.NoError(t, err)
+	assert.Equal(t, largeObject.Field1, received.Field1)
+
+	// unsigned messages are decoded as plain JSON
+	received = TestObjectWithSender{}
+	isSigned, err = messaging.VerifySenderJWSSignature(string(payload), &received, getPubKey)
+	assert.NoError(t, err)
+	assert.False(t, isSigned)
+
+	// tampered messages don't verify
+	parts := strings.Split(message, ".")
+	tampered := parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2]))
+	_, err = messaging.VerifySenderJWSSignature(tampered, &received, getPubKey)
+	assert.Error(t, err)
+	otherPayload, _ := json.Marshal(testObject2)
+	otherMessage, _ := messaging.CreateJWSSignature(string(otherPayload), privKey)
+	tampered = parts[0] + "." + strings.Split(otherMessage, ".")[1] + "." + parts[2]
+	_, err = messaging.VerifySenderJWSSignature(tampered, &TestObjectNoSender{}, getPubKey)
+	assert.Error(t, err)
+	_, err = messaging.VerifySenderJWSSignature(parts[0]+"."+parts[1]+".short", &received, getPubKey)
+	assert.Error(t, err)
+}
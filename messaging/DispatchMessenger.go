@@ -0,0 +1,206 @@
+// Package messaging - Dispatching of received messages to a pool of workers
+package messaging
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultReceiveQueueSize is the default nr of received messages each worker can queue
+const DefaultReceiveQueueSize = 100
+
+// dispatchItem is a received message waiting for its handler
+type dispatchItem struct {
+	address string
+	handler func(address string, message string) error
+	message string
+}
+
+// dispatchSubscription maps a subscription to the subscription that dispatches its messages
+type dispatchSubscription struct {
+	address         string
+	handler         func(address string, message string) error
+	dispatchHandler func(address string, message string) error
+}
+
+// DispatchMessenger passes received messages to a pool of workers, so a slow handler doesn't
+// delay the messages of other addresses, eg a slow input handler delaying configuration.
+// Messages of the same address are always handled by the same worker, in the order they are
+// received. When the queue of a worker is full, receiving waits until the worker catches up.
+//
+// The workers run while the messenger is connected. Messages received while disconnected are
+// handled directly. Replies to requests are not dispatched to the workers, so a handler can make
+// a request and wait for its reply.
+type DispatchMessenger struct {
+	dispatchGroup *sync.WaitGroup        // messages that are being queued
+	messenger     IMessenger             // messenger of the message bus
+	queues        []chan dispatchItem    // queue of each worker, nil while not running
+	queueSize     int                    // nr of messages each worker can queue
+	subscriptions []dispatchSubscription // subscriptions that dispatch messages
+	updateMutex   *sync.RWMutex          // mutex for concurrent subscriptions and dispatching
+	workerCount   int                    // nr of workers
+	workerGroup   *sync.WaitGroup        // workers that are running
+}
+
+// Connect the messenger and start the workers
+func (dispatcher *DispatchMessenger) Connect(lastWillAddress string, lastWillValue string) error {
+	dispatcher.startWorkers()
+	return dispatcher.messenger.Connect(lastWillAddress, lastWillValue)
+}
+
+// ConnectionState returns the state of the connection of the wrapped messenger
+func (dispatcher *DispatchMessenger) ConnectionState() ConnectionState {
+	return dispatcher.messenger.ConnectionState()
+}
+
+// Disconnect the messenger and stop the workers after they handled the queued messages
+// Messages that wait for space in a queue are queued and handled by the worker before it stops,
+// so messages of an address are handled in the order they are received.
+func (dispatcher *DispatchMessenger) Disconnect() {
+	dispatcher.messenger.Disconnect()
+	dispatcher.updateMutex.Lock()
+	queues := dispatcher.queues
+	dispatcher.queues = nil
+	dispatcher.updateMutex.Unlock()
+	// the queues are closed when no more messages are being queued
+	dispatcher.dispatchGroup.Wait()
+	for _, queue := range queues {
+		close(queue)
+	}
+	dispatcher.workerGroup.Wait()
+}
+
+// Publish a message
+func (dispatcher *DispatchMessenger) Publish(address string, retained bool, message string) error {
+	return dispatcher.messenger.Publish(address, retained, message)
+}
+
+// Request publishes a request and waits for the reply, see SendRequest
+func (dispatcher *DispatchMessenger) Request(address string, payload string, timeout time.Duration) (string, error) {
+	return SendRequest(dispatcher, address, payload, timeout)
+}
+
+// SetConnectionHandler sets the handler of connection changes
+func (dispatcher *DispatchMessenger) SetConnectionHandler(handler func(connected bool, err error)) {
+	dispatcher.messenger.SetConnectionHandler(handler)
+}
+
+// Subscribe to an address and pass the received messages to the worker of the received address
+func (dispatcher *DispatchMessenger) Subscribe(address string, onMessage func(address string, message string) error) {
+	subscription := dispatchSubscription{
+		address: address,
+		handler: onMessage,
+		dispatchHandler: func(rxAddress string, message string) error {
+			return dispatcher.dispatch(dispatchItem{address: rxAddress, handler: onMessage, message: message})
+		},
+	}
+	dispatcher.updateMutex.Lock()
+	dispatcher.subscriptions = append(dispatcher.subscriptions, subscription)
+	dispatcher.updateMutex.Unlock()
+	dispatcher.messenger.Subscribe(address, subscription.dispatchHandler)
+}
+
+// Unsubscribe from an address
+// If onMessage is nil then all subscriptions with the address are removed
+func (dispatcher *DispatchMessenger) Unsubscribe(address string, onMessage func(address string, message string) error) {
+	dispatcher.updateMutex.Lock()
+	remaining := make([]dispatchSubscription, 0, len(dispatcher.subscriptions))
+	removed := make([]dispatchSubscription, 0)
+	for _, sub := range dispatcher.subscriptions {
+		isMatch := sub.address == address && (onMessage == nil || isSameHandler(sub.handler, onMessage))
+		if isMatch && (onMessage == nil || len(removed) == 0) {
+			removed = append(removed, sub)
+		} else {
+			remaining = append(remaining, sub)
+		}
+	}
+	dispatcher.subscriptions = remaining
+	dispatcher.updateMutex.Unlock()
+	if onMessage == nil {
+		dispatcher.messenger.Unsubscribe(address, nil)
+		return
+	}
+	for _, sub := range removed {
+		dispatcher.messenger.Unsubscribe(sub.address, sub.dispatchHandler)
+	}
+}
+
+// dispatch queues a received message with the worker of its address, or handles it directly if
+// the workers are not running. Replies to requests are handled directly, as a request made from a
+// handler would otherwise wait for a reply that is queued with its own worker. The lock is not
+// held while waiting for space in the queue, so handlers can subscribe and unsubscribe while
+// receiving waits for their worker.
+func (dispatcher *DispatchMessenger) dispatch(item dispatchItem) error {
+	if strings.Contains(item.address, "/"+types.MessageTypeReply+"/") {
+		return item.handler(item.address, item.message)
+	}
+	dispatcher.updateMutex.RLock()
+	if len(dispatcher.queues) == 0 {
+		dispatcher.updateMutex.RUnlock()
+		return item.handler(item.address, item.message)
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(item.address))
+	queue := dispatcher.queues[hash.Sum32()%uint32(len(dispatcher.queues))]
+	// the queue isn't closed until the message is queued, see Disconnect
+	dispatcher.dispatchGroup.Add(1)
+	dispatcher.updateMutex.RUnlock()
+
+	queue <- item
+	dispatcher.dispatchGroup.Done()
+	return nil
+}
+
+// startWorkers starts the workers if they are not running
+func (dispatcher *DispatchMessenger) startWorkers() {
+	dispatcher.updateMutex.Lock()
+	defer dispatcher.updateMutex.Unlock()
+	if dispatcher.queues != nil {
+		return
+	}
+	dispatcher.queues = make([]chan dispatchItem, dispatcher.workerCount)
+	for i := range dispatcher.queues {
+		dispatcher.queues[i] = make(chan dispatchItem, dispatcher.queueSize)
+		dispatcher.workerGroup.Add(1)
+		go dispatcher.work(dispatcher.queues[i])
+	}
+}
+
+// work handles the messages in the queue until the queue is closed
+func (dispatcher *DispatchMessenger) work(queue chan dispatchItem) {
+	defer dispatcher.workerGroup.Done()
+	for item := range queue {
+		err := item.handler(item.address, item.message)
+		if err != nil {
+			logrus.Debugf("DispatchMessenger.work: Handling message on %s failed: %s", item.address, err)
+		}
+	}
+}
+
+// NewDispatchMessenger wraps a messenger with a pool of workers that handle received messages.
+// The workers are started on Connect.
+//  messenger is the messenger of the message bus
+//  workerCount is the nr of workers, 1 or more
+//  queueSize is the nr of messages each worker can queue. Default (0) is DefaultReceiveQueueSize
+func NewDispatchMessenger(messenger IMessenger, workerCount int, queueSize int) *DispatchMessenger {
+	if workerCount < 1 {
+		workerCount = 1
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultReceiveQueueSize
+	}
+	return &DispatchMessenger{
+		dispatchGroup: &sync.WaitGroup{},
+		messenger:     messenger,
+		queueSize:     queueSize,
+		subscriptions: make([]dispatchSubscription, 0),
+		updateMutex:   &sync.RWMutex{},
+		workerCount:   workerCount,
+		workerGroup:   &sync.WaitGroup{},
+	}
+}
@@ -0,0 +1,147 @@
+package messaging_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchMessenger(t *testing.T) {
+	const inputAddr = "domain1/pub1/node1/switch/0/$set"
+	const configureAddr = "domain1/pub1/node2/$configure" // handled by another worker than inputAddr
+	dummy := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	dispatcher := messaging.NewDispatchMessenger(dummy, 4, 10)
+	blockInput := make(chan bool)
+	rxConfigure := make(chan string, 10)
+	rxMutex := sync.Mutex{}
+	rxInputs := make([]string, 0)
+	inputHandler := func(address string, message string) error {
+		<-blockInput
+		rxMutex.Lock()
+		rxInputs = append(rxInputs, message)
+		rxMutex.Unlock()
+		return nil
+	}
+	configureHandler := func(address string, message string) error {
+		rxConfigure <- message
+		return nil
+	}
+	dispatcher.Subscribe(inputAddr, inputHandler)
+	dispatcher.Subscribe(configureAddr, configureHandler)
+	err := dispatcher.Connect("", "")
+	require.NoError(t, err)
+
+	// a blocked input handler doesn't delay configuration
+	dispatcher.Publish(inputAddr, false, "1")
+	dispatcher.Publish(inputAddr, false, "2")
+	dispatcher.Publish(configureAddr, false, "config1")
+	select {
+	case message := <-rxConfigure:
+		assert.Equal(t, "config1", message)
+	case <-time.After(time.Second):
+		assert.Fail(t, "configure message was blocked by the input handler")
+	}
+
+	// messages of an address are handled in order
+	dispatcher.Publish(inputAddr, false, "3")
+	close(blockInput)
+	dispatcher.Disconnect()
+	assert.Equal(t, []string{"1", "2", "3"}, rxInputs)
+
+	// without workers messages are handled directly
+	dispatcher.Publish(configureAddr, false, "config2")
+	assert.Equal(t, "config2", <-rxConfigure)
+	dispatcher.Unsubscribe(configureAddr, configureHandler)
+	dispatcher.Publish(configureAddr, false, "config3")
+	assert.Equal(t, 0, len(rxConfigure))
+
+	// configured messengers dispatch received messages
+	messenger := messaging.NewMessenger(&messaging.MessengerConfig{ReceiveWorkers: 2})
+	_, isDispatcher := messenger.(*messaging.DispatchMessenger)
+	assert.True(t, isDispatcher)
+}
+
+// handlers can subscribe while receiving waits for a full queue and can make requests
+func TestDispatchFromHandler(t *testing.T) {
+	const inputAddr = "domain1/pub1/node1/switch/0/$set"
+	const queryAddr = "domain1/pub2/node1/$query"
+	dummy := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	dispatcher := messaging.NewDispatchMessenger(dummy, 1, 1)
+	messaging.ServeRequests(dummy, queryAddr, func(address string, payload string) (string, error) {
+		return "reply-" + payload, nil
+	})
+	blockInput := make(chan bool)
+	rxInputs := make(chan string, 10)
+	inputHandler := func(address string, message string) error {
+		<-blockInput
+		dispatcher.Subscribe(inputAddr+message, func(address string, message string) error { return nil })
+		reply, err := dispatcher.Request(queryAddr, message, time.Second)
+		if err == nil {
+			rxInputs <- reply
+		}
+		return err
+	}
+	dispatcher.Subscribe(inputAddr, inputHandler)
+	err := dispatcher.Connect("", "")
+	require.NoError(t, err)
+
+	// the third message waits for space in the queue of the only worker
+	dispatcher.Publish(inputAddr, false, "1")
+	dispatcher.Publish(inputAddr, false, "2")
+	go dispatcher.Publish(inputAddr, false, "3")
+	time.Sleep(time.Millisecond * 10)
+	close(blockInput)
+	for _, expected := range []string{"reply-1", "reply-2", "reply-3"} {
+		select {
+		case reply := <-rxInputs:
+			assert.Equal(t, expected, reply)
+		case <-time.After(time.Second * 3):
+			assert.Fail(t, "handler is blocked", expected)
+		}
+	}
+	dispatcher.Disconnect()
+}
+
+// messages that wait for a full queue on disconnect are handled after the queued messages
+func TestDispatchDisconnectInOrder(t *testing.T) {
+	const inputAddr = "domain1/pub1/node1/switch/0/$set"
+	dummy := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	dispatcher := messaging.NewDispatchMessenger(dummy, 1, 1)
+	blockInput := make(chan bool)
+	rxMutex := sync.Mutex{}
+	rxInputs := make([]string, 0)
+	dispatcher.Subscribe(inputAddr, func(address string, message string) error {
+		<-blockInput
+		rxMutex.Lock()
+		rxInputs = append(rxInputs, message)
+		rxMutex.Unlock()
+		return nil
+	})
+	err := dispatcher.Connect("", "")
+	require.NoError(t, err)
+
+	// the third message waits for space in the queue of the only worker
+	dispatcher.Publish(inputAddr, false, "1")
+	dispatcher.Publish(inputAddr, false, "2")
+	go dispatcher.Publish(inputAddr, false, "3")
+	time.Sleep(time.Millisecond * 10)
+	disconnected := make(chan bool)
+	go func() {
+		dispatcher.Disconnect()
+		close(disconnected)
+	}()
+	time.Sleep(time.Millisecond * 10)
+	close(blockInput)
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second * 3):
+		assert.Fail(t, "disconnect is blocked")
+	}
+	rxMutex.Lock()
+	assert.Equal(t, []string{"1", "2", "3"}, rxInputs)
+	rxMutex.Unlock()
+}
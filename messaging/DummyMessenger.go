@@ -2,9 +2,9 @@
 package messaging
 
 import (
-	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
@@ -12,10 +12,14 @@ import (
 
 // DummyMessenger that implements IMessenger
 type DummyMessenger struct {
-	publications  map[string]string
-	config        *MessengerConfig // for domain configuration
-	subscriptions []Subscription
-	publishMutex  *sync.Mutex // mutex for concurrent publishing of messages
+	publications      map[string]string
+	config            *MessengerConfig                // for domain configuration
+	connectionHandler func(connected bool, err error) // notify of connection changes
+	connectionState   ConnectionState                 // simulated state of the connection
+	lastWillAddress   string                          // last will address provided on connect
+	lastWillValue     string                          // last will payload provided on connect
+	subscriptions     []Subscription
+	publishMutex      *sync.Mutex // mutex for concurrent publishing of messages
 }
 
 // Subscription to messages
@@ -26,11 +30,23 @@ type Subscription struct {
 
 // Connect the messenger
 func (messenger *DummyMessenger) Connect(lastWillAddress string, lastWillValue string) error {
+	messenger.publishMutex.Lock()
+	messenger.lastWillAddress, messenger.lastWillValue = LastWill(messenger.config, lastWillAddress, lastWillValue)
+	messenger.publishMutex.Unlock()
+	messenger.OnConnectionChange(true, nil)
 	return nil
 }
 
+// ConnectionState returns the simulated state of the connection
+func (messenger *DummyMessenger) ConnectionState() ConnectionState {
+	messenger.publishMutex.Lock()
+	defer messenger.publishMutex.Unlock()
+	return messenger.connectionState
+}
+
 // Disconnect gracefully disconnects the messenger
 func (messenger *DummyMessenger) Disconnect() {
+	messenger.OnConnectionChange(false, nil)
 }
 
 // FindLastPublication with the given address
@@ -51,11 +67,44 @@ func (messenger *DummyMessenger) GetDomain() string {
 	return domain
 }
 
+// GetLastWill returns the last will address and payload set on connect
+func (messenger *DummyMessenger) GetLastWill() (address string, value string) {
+	messenger.publishMutex.Lock()
+	defer messenger.publishMutex.Unlock()
+	return messenger.lastWillAddress, messenger.lastWillValue
+}
+
 // NrPublications returns the number of received publications
 func (messenger *DummyMessenger) NrPublications() int {
 	return len(messenger.publications)
 }
 
+// OnConnectionChange function to simulate a change in connection, eg a connection loss or reconnect
+// A connection loss with an error is reconnecting, without error it is disconnected.
+func (messenger *DummyMessenger) OnConnectionChange(connected bool, err error) {
+	messenger.publishMutex.Lock()
+	handler := messenger.connectionHandler
+	if connected {
+		messenger.connectionState = ConnectionStateConnected
+	} else if err != nil {
+		messenger.connectionState = ConnectionStateConnecting
+	} else {
+		messenger.connectionState = ConnectionStateDisconnected
+	}
+	messenger.publishMutex.Unlock()
+
+	if messenger.config != nil {
+		if connected && messenger.config.OnConnect != nil {
+			messenger.config.OnConnect()
+		} else if !connected && messenger.config.OnDisconnect != nil {
+			messenger.config.OnDisconnect(err)
+		}
+	}
+	if handler != nil {
+		handler(connected, err)
+	}
+}
+
 // OnReceive function to simulate a received message
 func (messenger *DummyMessenger) OnReceive(address string, message string) {
 	messenger.publishMutex.Lock()
@@ -84,6 +133,28 @@ func (messenger *DummyMessenger) Publish(address string, retained bool, message
 	return nil
 }
 
+// PublishLastWill simulates an unexpected disconnect in which the broker publishes the last will
+func (messenger *DummyMessenger) PublishLastWill(err error) {
+	address, value := messenger.GetLastWill()
+	if address != "" {
+		retained := messenger.config != nil && messenger.config.LastWillRetained
+		messenger.Publish(address, retained, value)
+	}
+	messenger.OnConnectionChange(false, err)
+}
+
+// Request publishes a request and waits for the reply, see SendRequest
+func (messenger *DummyMessenger) Request(address string, payload string, timeout time.Duration) (string, error) {
+	return SendRequest(messenger, address, payload, timeout)
+}
+
+// SetConnectionHandler sets the handler that is notified when the connection changes
+func (messenger *DummyMessenger) SetConnectionHandler(handler func(connected bool, err error)) {
+	messenger.publishMutex.Lock()
+	defer messenger.publishMutex.Unlock()
+	messenger.connectionHandler = handler
+}
+
 // Subscribe to a message by address
 func (messenger *DummyMessenger) Subscribe(
 	address string, onMessage func(address string, message string) error) {
@@ -96,25 +167,21 @@ func (messenger *DummyMessenger) Subscribe(
 }
 
 // Unsubscribe an address and handler
+// If onMessage is nil then all subscriptions with the address are removed
 func (messenger *DummyMessenger) Unsubscribe(
 	address string, onMessage func(address string, message string) error) {
 	messenger.publishMutex.Lock()
-	// https://stackoverflow.com/questions/9643205/how-do-i-compare-two-functions-for-pointer-equality-in-the-latest-go-weekly
-	var onMessageID = onMessage
-	onMessageStr := fmt.Sprintf("%v", &onMessageID)
-	for i, sub := range messenger.subscriptions {
-		// can't compare addresses directly so convert to string
-		var handlerID = sub.handler
-		handlerStr := fmt.Sprintf("%v", &handlerID)
-		if sub.address == address && (onMessage == nil || handlerStr == onMessageStr) {
-			// shift remainder left one index
-			copy(messenger.subscriptions[i:], messenger.subscriptions[i+1:])
-			messenger.subscriptions = messenger.subscriptions[:len(messenger.subscriptions)-1]
-			if onMessage != nil {
-				break
-			}
+	remaining := make([]Subscription, 0, len(messenger.subscriptions))
+	removed := false
+	for _, sub := range messenger.subscriptions {
+		isMatch := sub.address == address && (onMessage == nil || isSameHandler(sub.handler, onMessage))
+		if isMatch && (onMessage == nil || !removed) {
+			removed = true
+		} else {
+			remaining = append(remaining, sub)
 		}
 	}
+	messenger.subscriptions = remaining
 	messenger.publishMutex.Unlock()
 }
 
@@ -131,9 +198,10 @@ func (messenger *DummyMessenger) matchAddress(address string, subscription strin
 	// Match the segments accepting wildcards. Rather crude but only intended for testing.
 	match = true
 	for index, addrSegment := range addressSegments {
-		// if index >= len(subscriptionSegments) {
-		// 	return false
-		// }
+		// no match if the address is longer than a subscription without '#'
+		if index >= len(subscriptionSegments) {
+			return false
+		}
 		subscriptionSegment := subscriptionSegments[index]
 
 		if subscriptionSegment == "#" {
@@ -152,10 +220,11 @@ func (messenger *DummyMessenger) matchAddress(address string, subscription strin
 // NewDummyMessenger provides a messenger for messages that go no.where...
 func NewDummyMessenger(config *MessengerConfig) *DummyMessenger {
 	var messenger = &DummyMessenger{
-		config:        config,
-		publications:  make(map[string]string, 0),
-		subscriptions: make([]Subscription, 0),
-		publishMutex:  &sync.Mutex{},
+		config:          config,
+		connectionState: ConnectionStateDisconnected,
+		publications:    make(map[string]string, 0),
+		subscriptions:   make([]Subscription, 0),
+		publishMutex:    &sync.Mutex{},
 	}
 	return messenger
 }
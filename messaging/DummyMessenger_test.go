@@ -26,12 +26,46 @@ type DummyMessage struct {
 // TestConnect to dummy
 func TestDummyConnect(t *testing.T) {
 	messenger := messaging.NewDummyMessenger(&dummyConfig)
+	assert.Equal(t, messaging.ConnectionStateDisconnected, messenger.ConnectionState())
 	err := messenger.Connect("", "")
 
 	domain := messenger.GetDomain()
 	assert.Equal(t, types.LocalDomainID, domain)
 
 	assert.NoError(t, err, "Connection failed")
+	assert.Equal(t, messaging.ConnectionStateConnected, messenger.ConnectionState())
+	messenger.OnConnectionChange(false, errors.New("connection lost"))
+	assert.Equal(t, messaging.ConnectionStateConnecting, messenger.ConnectionState())
+	messenger.Disconnect()
+	assert.Equal(t, messaging.ConnectionStateDisconnected, messenger.ConnectionState())
+}
+
+// TestDummyLastWill tests the last will with configuration overrides
+func TestDummyLastWill(t *testing.T) {
+	const willAddr = "domain1/pub1/$status"
+	config := messaging.MessengerConfig{}
+	messenger := messaging.NewDummyMessenger(&config)
+	err := messenger.Connect(willAddr, "lost")
+	assert.NoError(t, err)
+	addr, value := messenger.GetLastWill()
+	assert.Equal(t, willAddr, addr)
+	assert.Equal(t, "lost", value)
+
+	var received = ""
+	messenger.Subscribe(willAddr, func(address string, message string) error {
+		received = message
+		return nil
+	})
+	messenger.PublishLastWill(errors.New("connection lost"))
+	assert.Equal(t, "lost", received)
+
+	// configuration overrides the last will
+	config.LastWillAddress = "domain1/pub1/$offline"
+	config.LastWillValue = "offline"
+	messenger.Connect(willAddr, "lost")
+	addr, value = messenger.GetLastWill()
+	assert.Equal(t, config.LastWillAddress, addr)
+	assert.Equal(t, config.LastWillValue, value)
 	messenger.Disconnect()
 }
 
@@ -98,3 +132,29 @@ func TestDummyPublishSubscribe(t *testing.T) {
 
 	assert.Equal(t, "bob", receivedMessage.Name, "Did not receive published message")
 }
+
+func TestDummyUnsubscribeHandler(t *testing.T) {
+	const dummy1Addr = "domain1/pub1/test"
+	var count1, count2 int
+	messenger := messaging.NewDummyMessenger(&dummyConfig)
+	handler1 := func(addr string, message string) error {
+		count1++
+		return nil
+	}
+	handler2 := func(addr string, message string) error {
+		count2++
+		return nil
+	}
+	messenger.Subscribe(dummy1Addr, handler1)
+	messenger.Subscribe(dummy1Addr, handler2)
+
+	// only the subscription of the given handler is removed
+	messenger.Unsubscribe(dummy1Addr, handler2)
+	messenger.Publish(dummy1Addr, false, "message")
+	assert.Equal(t, 1, count1)
+	assert.Equal(t, 0, count2)
+
+	messenger.Unsubscribe(dummy1Addr, nil)
+	messenger.Publish(dummy1Addr, false, "message")
+	assert.Equal(t, 1, count1)
+}
@@ -1,6 +1,8 @@
 // Package messaging - Interface of messengers for publishers and subscribers
 package messaging
 
+import "time"
+
 // MessengerConfig with configuration of a messenger
 type MessengerConfig struct {
 	ClientID  string `yaml:"clientid,omitempty"`  // optional connect ID, must be unique. Default is generated.
@@ -8,13 +10,88 @@ type MessengerConfig struct {
 	Login     string `yaml:"login"`               // messenger login name
 	Port      uint16 `yaml:"port,omitempty"`      // optional port, default is 8883 for TLS
 	Password  string `yaml:"credentials"`         // messenger login credentials
-	PubQos    byte   `yaml:"pubqos,omitempty"`    // publishing QOS 0-2. Default=0
-	Server    string `yaml:"server"`              // Message bus server/broker hostname or ip address, required
+	PubQos    byte   `yaml:"pubqos,omitempty"`    // publishing QOS 0-2 of addresses without message type. Default=0
+	Server    string `yaml:"server"`              // Message bus server/broker hostname or ip address, required unless servers is set
 	Signing   bool   `yaml:"signing,omitempty"`   // Message signing to be used by all publishers.
-	SubQos    byte   `yaml:"subqos,omitempty"`    // Subscription QOS 0-2. Default=0
+	SubQos    byte   `yaml:"subqos,omitempty"`    // Subscription QOS 0-2 of addresses without message type. Default=0
 	Messenger string `yaml:"messenger,omitempty"` // Messenger client type: "DummyMessenger" (default) or "MQTTMessenger"
+
+	// Compression of large messages, see CompressMessenger. All consumers must decompress messages.
+	CompressThreshold   int  `yaml:"compressThreshold,omitempty"`   // compress messages larger than this nr of bytes with gzip. Default 0 is disabled
+	Decompress          bool `yaml:"decompress,omitempty"`          // decompress received compressed messages. Default is disabled
+	MaxDecompressedSize int  `yaml:"maxDecompressedSize,omitempty"` // max size in bytes of decompressed messages, larger messages are rejected. Default is DefaultMaxDecompressedSize
+
+	// Pool of workers that handle received messages, see DispatchMessenger
+	ReceiveWorkers   int `yaml:"receiveWorkers,omitempty"`   // nr of workers. Default 0 handles messages on the messenger receive goroutine
+	ReceiveQueueSize int `yaml:"receiveQueueSize,omitempty"` // nr of received messages each worker can queue. Default is DefaultReceiveQueueSize
+
+	// QoS 0-2 by message type, eg $raw: 0, see PublishQoS. Default is DefaultQoS, or DefaultMessageQoS for other message types
+	QoS map[string]byte `yaml:"qos,omitempty"`
+
+	// Reconnect policy. The delay between attempts doubles with each attempt up to the maximum delay.
+	ReconnectDelay       uint    `yaml:"reconnectDelay,omitempty"`       // initial reconnect delay in seconds. Default is DefaultReconnectDelay
+	ReconnectMaxDelay    uint    `yaml:"reconnectMaxDelay,omitempty"`    // maximum reconnect delay in seconds. Default is DefaultReconnectMaxDelay
+	ReconnectJitter      float64 `yaml:"reconnectJitter,omitempty"`      // random delay variation as fraction 0-1. Default is DefaultReconnectJitter, negative to disable
+	ReconnectMaxAttempts int     `yaml:"reconnectMaxAttempts,omitempty"` // give up connecting after nr of attempts. Default 0 is unlimited
+
+	// Failover between the brokers of a cluster, see BrokerFailoverOrder. The server is the primary broker.
+	Servers    []string `yaml:"servers,omitempty"`    // additional brokers as host or host:port, in order of preference
+	RoundRobin bool     `yaml:"roundRobin,omitempty"` // on failover try the next broker first instead of the primary broker. Default is false
+
+	// TLS connection to the broker, see MakeTLSConfig. Files are relative to the config folder.
+	CACertFile         string   `yaml:"caCertFile,omitempty"`         // PEM CA bundle that signs the broker certificate. Default is DefaultCACertFile if it exists, otherwise the system CAs
+	ClientCertFile     string   `yaml:"clientCertFile,omitempty"`     // PEM client certificate for mutual TLS. Default is no client certificate
+	ClientKeyFile      string   `yaml:"clientKeyFile,omitempty"`      // PEM private key of the client certificate
+	InsecureSkipVerify bool     `yaml:"insecureSkipVerify,omitempty"` // don't verify the broker certificate. This is insecure and intended for testing only
+	ALPN               []string `yaml:"alpn,omitempty"`               // optional application protocols to negotiate, eg x-amzn-mqtt-ca on port 443
+	ServerName         string   `yaml:"serverName,omitempty"`         // optional SNI and certificate host name. Default is the broker host name
+
+	// Broker discovery on the local network using mDNS/DNS-SD. The configured server is used when no broker is found.
+	Discovery        bool   `yaml:"discovery,omitempty"`        // discover the broker before connecting. Default is false
+	DiscoveryService string `yaml:"discoveryService,omitempty"` // DNS-SD service type of the broker. Default is MqttServiceType
+	DiscoveryTimeout uint   `yaml:"discoveryTimeout,omitempty"` // time to wait for responses in seconds. Default is DefaultDiscoveryTimeout
+
+	// Compatibility with the iotconnect v1 address scheme during migration, see V1CompatMessenger
+	ConsumeV1 bool `yaml:"consumeV1,omitempty"` // also receive messages published with v1 addresses. Default is false
+	EmitV1    bool `yaml:"emitV1,omitempty"`    // also publish messages with v1 addresses. Default is false
+
+	// Address rewrite rules for migration and renames, see RewriteMessenger
+	Rewrite []RewriteRule `yaml:"rewrite,omitempty"` // optional rules, the first matching rule applies. Default is no rewriting
+
+	// Last will & testament. These override the last will provided by the publisher on Connect
+	LastWillAddress  string `yaml:"lastWillAddress,omitempty"`  // optional last will address override
+	LastWillValue    string `yaml:"lastWillValue,omitempty"`    // optional last will payload override
+	LastWillRetained bool   `yaml:"lastWillRetained,omitempty"` // retain the last will publication. Default is false
+
+	OnConnect    func()          `yaml:"-"` // optional callback when the connection is (re)established
+	OnDisconnect func(err error) `yaml:"-"` // optional callback when the connection is lost or closed. err is nil on Disconnect
 }
 
+// LastWill returns the last will address and payload to use on connect.
+// The last will from the configuration takes precedence over the given address and value.
+func LastWill(config *MessengerConfig, lastWillAddress string, lastWillValue string) (address string, value string) {
+	if config == nil {
+		return lastWillAddress, lastWillValue
+	}
+	if config.LastWillAddress != "" {
+		lastWillAddress = config.LastWillAddress
+	}
+	if config.LastWillValue != "" {
+		lastWillValue = config.LastWillValue
+	}
+	return lastWillAddress, lastWillValue
+}
+
+// ConnectionState of a messenger with the message bus
+type ConnectionState string
+
+// Connection states of a messenger
+const (
+	ConnectionStateConnected    ConnectionState = "connected"    // connected to the message bus
+	ConnectionStateConnecting   ConnectionState = "connecting"   // connecting, or reconnecting after the connection was lost
+	ConnectionStateDisconnected ConnectionState = "disconnected" // not connected, before Connect, after Disconnect or after giving up
+)
+
 // IMessenger interface for messenger implementations
 type IMessenger interface {
 
@@ -28,6 +105,10 @@ type IMessenger interface {
 	// lastWillValue payload to use with the last will publication
 	Connect(lastWillAddress string, lastWillValue string) error
 
+	// ConnectionState returns the current state of the connection with the message bus.
+	// Publications while not connected are lost.
+	ConnectionState() ConnectionState
+
 	// Gracefully disconnect the messenger and unsubscribe to all subscribed messages.
 	// This will prevent the LWT publication so publishers must publish a graceful disconnect
 	// message.
@@ -40,12 +121,26 @@ type IMessenger interface {
 	//  message is a serialized message to send
 	Publish(address string, retained bool, message string) error
 
+	// Request publishes a request and waits for the reply, for synchronous queries to other
+	// publishers. The request carries a correlation ID and the address to reply to. The responder
+	// serves requests with ServeRequests. See SendRequest.
+	//  address the responder serves requests on
+	//  payload of the request
+	//  timeout to wait for the reply
+	// Returns the payload of the reply or an error if no reply is received before the timeout
+	Request(address string, payload string, timeout time.Duration) (string, error)
+
 	// Subscribe to a message. The subscriber must handle message decryption and signing verification.
 	//  address to subscribe to with support for wildcards '+' and '#'. Non MQTT busses must convert to equivalent
 	//  onMessage callback is invoked when a message on this address is received
 	// Multiple subscriptions for the same address is supported.
 	Subscribe(address string, onMessage func(address string, message string) error)
 
+	// SetConnectionHandler sets the handler that is notified when the connection is established, lost
+	// or closed. connected is true when the connection is (re)established. err holds the reason of
+	// an unintended disconnect.
+	SetConnectionHandler(handler func(connected bool, err error))
+
 	// Unsubscribe from a previously subscribed address.
 	// If onMessage is nil then all subscriptions with the address will be removed
 	Unsubscribe(address string, onMessage func(address string, message string) error)
@@ -0,0 +1,98 @@
+// Package messaging with hooks that inspect, modify or veto published and received messages
+package messaging
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PublishHook inspects, modifies or vetoes a message before it is signed and published, eg for
+// filtering, enrichment, auditing or metrics.
+//  address is the address the message is published on
+//  object is the message object, or the payload string of messages that are published as text
+// Returns the object to publish, which is the given object or a modified copy of the same type,
+// and false to veto the publication.
+type PublishHook func(address string, object interface{}) (newObject interface{}, publish bool)
+
+// ReceiveHook inspects, modifies or vetoes a received message after its signature is verified and
+// before it is passed to its handler.
+//  address is the address the message is received on, or the address field of the message when
+//  the address isn't known to the signer
+//  object is a pointer to the decoded message, or the payload string of messages received as text
+// Returns false to veto the message, in which case it is rejected as if verification failed.
+type ReceiveHook func(address string, object interface{}) (accept bool)
+
+// AddPublishHook adds a hook that is invoked before a message is signed and published. Hooks are
+// invoked in the order they are added, each with the object returned by the previous hook.
+func (signer *MessageSigner) AddPublishHook(hook PublishHook) {
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	signer.publishHooks = append(signer.publishHooks, hook)
+}
+
+// AddReceiveHook adds a hook that is invoked after a received message is verified. Hooks are
+// invoked in the order they are added until a hook vetoes the message.
+func (signer *MessageSigner) AddReceiveHook(hook ReceiveHook) {
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	signer.receiveHooks = append(signer.receiveHooks, hook)
+}
+
+// ApplyPublishHooks passes a message that is about to be published through the publish hooks.
+// Returns the object to publish, and false if a hook vetoed the publication.
+func (signer *MessageSigner) ApplyPublishHooks(address string, object interface{}) (interface{}, bool) {
+	signer.updateMutex.Lock()
+	hooks := signer.publishHooks
+	signer.updateMutex.Unlock()
+	for _, hook := range hooks {
+		newObject, publish := hook(address, object)
+		if !publish {
+			logrus.Infof("ApplyPublishHooks: Publication on '%s' is vetoed by a publish hook", address)
+			return object, false
+		}
+		object = newObject
+	}
+	return object, true
+}
+
+// ApplyReceiveHooks passes a verified message through the receive hooks. Intended for receivers
+// that verify messages without the signer, eg the domain collections of discovered nodes.
+// Returns an error if a hook vetoed the message.
+func (signer *MessageSigner) ApplyReceiveHooks(address string, object interface{}) error {
+	signer.updateMutex.Lock()
+	hooks := signer.receiveHooks
+	signer.updateMutex.Unlock()
+	for _, hook := range hooks {
+		if !hook(address, object) {
+			return fmt.Errorf("ApplyReceiveHooks: Message on '%s' is vetoed by a receive hook", address)
+		}
+	}
+	return nil
+}
+
+// applyPublishHooksToPayload passes a text payload through the publish hooks
+// Returns the payload to publish, and false if a hook vetoed the publication or returned no text.
+func (signer *MessageSigner) applyPublishHooksToPayload(address string, payload string) (string, bool) {
+	object, publish := signer.ApplyPublishHooks(address, payload)
+	newPayload, isText := object.(string)
+	if publish && !isText {
+		logrus.Errorf("applyPublishHooksToPayload: Publish hook replaced the text payload on '%s' with a %T", address, object)
+	}
+	return newPayload, publish && isText
+}
+
+// getMessageAddress returns the address field of a decoded message, or "" if it has none
+//  object is a pointer to the message struct
+func getMessageAddress(object interface{}) string {
+	reflObject := reflect.ValueOf(object)
+	if reflObject.Kind() != reflect.Ptr || reflObject.Elem().Kind() != reflect.Struct {
+		return ""
+	}
+	field := reflObject.Elem().FieldByName("Address")
+	if !field.IsValid() || field.Kind() != reflect.String {
+		return ""
+	}
+	return field.String()
+}
@@ -0,0 +1,83 @@
+package messaging_test
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageHooks(t *testing.T) {
+	const rawAddr = "test/pub1/node1/temperature/0/$raw"
+	const nodeAddr = "test/pub1/node1/$node"
+	const node2Addr = "test/pub1/node2/$node"
+	privKey := messaging.CreateAsymKeys()
+	messenger := messaging.NewDummyMessenger(nil)
+	getPublicKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	signer := messaging.NewMessageSigner(messenger, privKey, getPublicKey)
+
+	// publish hooks modify and veto publications in the order they are added
+	hookCount := 0
+	signer.AddPublishHook(func(address string, object interface{}) (interface{}, bool) {
+		hookCount++
+		if node, isNode := object.(*types.NodeDiscoveryMessage); isNode {
+			enriched := *node
+			enriched.Attr = types.NodeAttrMap{types.NodeAttrDescription: "kitchen"}
+			return &enriched, true
+		}
+		return object, true
+	})
+	signer.AddPublishHook(func(address string, object interface{}) (interface{}, bool) {
+		return object, address != node2Addr && object != "vetoed"
+	})
+	err := signer.PublishObject(nodeAddr, false, &types.NodeDiscoveryMessage{Address: nodeAddr}, nil)
+	require.NoError(t, err)
+	err = signer.PublishObject(node2Addr, false, &types.NodeDiscoveryMessage{Address: node2Addr}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, hookCount)
+	assert.Equal(t, "", messenger.FindLastPublication(node2Addr))
+	var node types.NodeDiscoveryMessage
+	_, err = signer.VerifySignedMessage(messenger.FindLastPublication(nodeAddr), &node)
+	require.NoError(t, err)
+	assert.Equal(t, "kitchen", node.Attr[types.NodeAttrDescription])
+
+	signer.PublishSigned(rawAddr, false, "vetoed")
+	assert.Equal(t, "", messenger.FindLastPublication(rawAddr))
+	signer.PublishSigned(rawAddr, false, "20.5")
+	payload, err := signer.VerifyRawPublication(rawAddr, messenger.FindLastPublication(rawAddr))
+	require.NoError(t, err)
+	assert.Equal(t, "20.5", payload)
+
+	// publications vetoed in a batch are skipped
+	err = signer.PublishObjects([]messaging.Publication{
+		{Address: node2Addr, Object: &types.NodeDiscoveryMessage{Address: node2Addr}},
+		{Address: nodeAddr, Object: &types.NodeDiscoveryMessage{Address: nodeAddr}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "", messenger.FindLastPublication(node2Addr))
+
+	// receive hooks veto verified messages
+	received := make([]string, 0)
+	signer.AddReceiveHook(func(address string, object interface{}) bool {
+		received = append(received, address)
+		return object != "21.5"
+	})
+	message := messenger.FindLastPublication(nodeAddr)
+	_, err = signer.VerifyPublication(nodeAddr, message, &node)
+	assert.NoError(t, err)
+	_, _, err = signer.DecodeMessage(message, &node)
+	assert.NoError(t, err)
+	_, err = signer.VerifyRawPublication(rawAddr, "21.5")
+	assert.Error(t, err)
+	assert.Equal(t, []string{nodeAddr, nodeAddr, rawAddr}, received)
+
+	// the signature is verified before receive hooks are invoked
+	_, err = signer.VerifySignedMessage("invalid", &node)
+	assert.Error(t, err)
+	assert.Equal(t, 3, len(received))
+}
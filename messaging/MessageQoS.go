@@ -0,0 +1,55 @@
+// Package messaging - quality of service of publications and subscriptions by message type
+package messaging
+
+import (
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// DefaultMessageQoS is the QoS of message types that have no QoS in DefaultQoS or the configuration,
+// eg discovery and commands, so they are delivered at least once.
+const DefaultMessageQoS = 1
+
+// DefaultQoS is the QoS by message type of frequently published output values. These are
+// published with QoS 0 as a lost value is replaced by the next one, which saves traffic on metered
+// connections.
+var DefaultQoS = map[string]byte{
+	types.MessageTypeDomainStats: 0,
+	types.MessageTypeEvent:       0,
+	types.MessageTypeForecast:    0,
+	types.MessageTypeHistory:     0,
+	types.MessageTypeLatest:      0,
+	types.MessageTypeRaw:         0,
+}
+
+// PublishQoS returns the QoS to publish on an address. The QoS is determined by the message type of
+// the address: the QoS in the configuration, the QoS in DefaultQoS, or DefaultMessageQoS.
+// Addresses without message type use the PubQos of the configuration.
+func PublishQoS(config *MessengerConfig, address string) byte {
+	return messageQoS(config, address, config.PubQos)
+}
+
+// SubscribeQoS returns the QoS to subscribe to an address, which is determined as in PublishQoS.
+// Addresses without message type, eg with a '#' wildcard, use the SubQos of the configuration.
+func SubscribeQoS(config *MessengerConfig, address string) byte {
+	return messageQoS(config, address, config.SubQos)
+}
+
+// messageQoS returns the QoS of the message type of the address
+//  defaultQoS is used when the address has no message type
+func messageQoS(config *MessengerConfig, address string, defaultQoS byte) byte {
+	messageType := address[strings.LastIndex(address, "/")+1:]
+	qos := byte(DefaultMessageQoS)
+	if configQoS, found := config.QoS[messageType]; found {
+		qos = configQoS
+	} else if typeQoS, found := DefaultQoS[messageType]; found {
+		qos = typeQoS
+	} else if !strings.HasPrefix(messageType, "$") {
+		qos = defaultQoS
+	}
+	if qos > 2 {
+		qos = 2
+	}
+	return qos
+}
@@ -0,0 +1,37 @@
+package messaging_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageQoS(t *testing.T) {
+	const rawAddr = "test/pub1/node1/temperature/0/" + types.MessageTypeRaw
+	const nodeAddr = "test/pub1/node1/" + types.MessageTypeNodeDiscovery
+	const setAddr = "test/pub1/node1/switch/0/" + types.MessageTypeSetInput
+	const customAddr = "homie/node1/switch/set"
+
+	// defaults: values with QoS 0, discovery and commands with QoS 1
+	config := &messaging.MessengerConfig{}
+	assert.Equal(t, byte(0), messaging.PublishQoS(config, rawAddr))
+	assert.Equal(t, byte(1), messaging.PublishQoS(config, nodeAddr))
+	assert.Equal(t, byte(1), messaging.PublishQoS(config, setAddr))
+	assert.Equal(t, byte(0), messaging.PublishQoS(config, customAddr))
+	assert.Equal(t, byte(1), messaging.SubscribeQoS(config, "test/+/+/+/+/"+types.MessageTypeSetInput))
+	assert.Equal(t, byte(0), messaging.SubscribeQoS(config, "test/#"))
+
+	// configured QoS takes precedence
+	config = &messaging.MessengerConfig{
+		PubQos: 1,
+		SubQos: 2,
+		QoS:    map[string]byte{types.MessageTypeRaw: 1, types.MessageTypeNodeDiscovery: 0, types.MessageTypeSetInput: 5},
+	}
+	assert.Equal(t, byte(1), messaging.PublishQoS(config, rawAddr))
+	assert.Equal(t, byte(0), messaging.PublishQoS(config, nodeAddr))
+	assert.Equal(t, byte(2), messaging.PublishQoS(config, setAddr))
+	assert.Equal(t, byte(1), messaging.PublishQoS(config, customAddr))
+	assert.Equal(t, byte(2), messaging.SubscribeQoS(config, "test/#"))
+}
@@ -2,7 +2,9 @@
 package messaging
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/asn1"
@@ -11,6 +13,9 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"runtime"
+	"sort"
+	"sync"
 
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
@@ -21,9 +26,79 @@ import (
 type MessageSigner struct {
 	// GetPublicKey when available is used in mess to verify signature
 	GetPublicKey func(address string) *ecdsa.PublicKey // must be a variable
-	messenger    IMessenger
-	signMessages bool              // flag, sign outgoing messages. Default is true. Disable for testing
-	privateKey   *ecdsa.PrivateKey // private key for signing and decryption
+	// optional handler that records the outcome of received commands
+	auditHandler  func(address string, sender string, isEncrypted bool, isSigned bool, rejectErr error)
+	encryptCmds   bool                     // flag, commands must be encrypted. Default is true
+	errorHandler  func(err error)          // optional handler of decoding and verification errors
+	isTrusted     func(sender string) bool // optional check that command senders are trusted, strict mode
+	messenger     IMessenger
+	policies      map[string]map[string]bool // message types that publishers publish unsigned, by publisher address
+	signMessages  bool                       // flag, sign outgoing messages. Default is true. Disable for testing
+	privateKey    *ecdsa.PrivateKey          // private key for signing and decryption
+	publishHooks  []PublishHook              // hooks invoked before messages are signed and published
+	receiveHooks  []ReceiveHook              // hooks invoked after received messages are verified
+	subscriptions []signerSubscription       // active subscriptions for listing and resubscribing
+	unsignedTypes map[string]bool            // message types that are published unsigned, see SetUnsignedMessageTypes
+	updateMutex   *sync.Mutex                // mutex for concurrent updates of subscriptions
+}
+
+// Publication is a message object to publish with PublishObjects
+type Publication struct {
+	Address  string      // address to publish the message on
+	Object   interface{} // message object to marshal to JSON and sign
+	Retained bool        // publish the message as retained
+}
+
+// signerSubscription is an active subscription made through the signer
+type signerSubscription struct {
+	address string
+	handler func(address string, message string) error
+}
+
+// AuditCommand passes the outcome of a received command to the audit handler, if set.
+//  address is the address the command is received on
+//  sender is the sender of the command, "" if the command can't be decoded
+//  rejectErr is the reason the command is rejected, nil if the command is accepted
+func (signer *MessageSigner) AuditCommand(address string, sender string, isEncrypted bool, isSigned bool, rejectErr error) {
+	signer.updateMutex.Lock()
+	handler := signer.auditHandler
+	signer.updateMutex.Unlock()
+	if handler != nil {
+		handler(address, sender, isEncrypted, isSigned, rejectErr)
+	}
+}
+
+// DecryptMulti decrypts a message that is encrypted for multiple recipients, including this signer.
+// This returns an error if the message isn't encrypted for this signer's key.
+func (signer *MessageSigner) DecryptMulti(serialized string) (message string, err error) {
+	return DecryptMultiMessage(serialized, signer.privateKey)
+}
+
+// DecodeCommand decrypts and verifies a command message like DecodeMessage. In strict mode,
+// commands must be encrypted and signed by a trusted sender, see SetTrustedSenderCheck.
+func (signer *MessageSigner) DecodeCommand(rawMessage string, object interface{}) (isEncrypted bool, isSigned bool, err error) {
+	isEncrypted, isSigned, err = signer.decodeMessage(rawMessage, object)
+	if err != nil {
+		return isEncrypted, isSigned, err
+	}
+	signer.updateMutex.Lock()
+	isTrusted := signer.isTrusted
+	signer.updateMutex.Unlock()
+	if isTrusted != nil {
+		sender := getMessageSender(object)
+		if !isSigned {
+			err = fmt.Errorf("DecodeCommand: Command from '%s' isn't signed. Strict mode requires a trusted sender", sender)
+		} else if !isEncrypted {
+			err = fmt.Errorf("DecodeCommand: Command from '%s' isn't encrypted. Strict mode requires encryption", sender)
+		} else if !isTrusted(sender) {
+			err = fmt.Errorf("DecodeCommand: Sender '%s' of command isn't trusted", sender)
+		}
+		signer.reportError(err)
+	}
+	if err == nil {
+		err = signer.ApplyReceiveHooks(getMessageAddress(object), object)
+	}
+	return isEncrypted, isSigned, err
 }
 
 // DecodeMessage decrypts the message and verifies the sender signature .
@@ -31,11 +106,28 @@ type MessageSigner struct {
 // Sender field is missing then the 'address' field is used as sender.
 // object must hold the expected message type to decode the json message containging the sender info
 func (signer *MessageSigner) DecodeMessage(rawMessage string, object interface{}) (isEncrypted bool, isSigned bool, err error) {
+	isEncrypted, isSigned, err = signer.decodeMessage(rawMessage, object)
+	if err == nil {
+		err = signer.ApplyReceiveHooks(getMessageAddress(object), object)
+	}
+	return isEncrypted, isSigned, err
+}
+
+// decodeMessage decrypts the message and verifies the sender signature without receive hooks
+func (signer *MessageSigner) decodeMessage(rawMessage string, object interface{}) (isEncrypted bool, isSigned bool, err error) {
 	dmessage, isEncrypted, err := DecryptMessage(rawMessage, signer.privateKey)
 	isSigned, err = VerifySenderJWSSignature(dmessage, object, signer.GetPublicKey)
+	signer.reportError(err)
 	return isEncrypted, isSigned, err
 }
 
+// EncryptCommands returns whether commands must be encrypted on sending and receiving
+func (signer *MessageSigner) EncryptCommands() bool {
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	return signer.encryptCmds
+}
+
 // SignMessages returns whether messages MUST be signed on sending or receiving
 func (signer *MessageSigner) SignMessages() bool {
 	return signer.signMessages
@@ -46,56 +138,290 @@ func (signer *MessageSigner) SignMessages() bool {
 // Sender field is missing then the 'address' field contains the publisher.
 //  or 'address' field
 func (signer *MessageSigner) VerifySignedMessage(rawMessage string, object interface{}) (isSigned bool, err error) {
+	isSigned, err = signer.verifySignedMessage(rawMessage, object)
+	if err == nil {
+		err = signer.ApplyReceiveHooks(getMessageAddress(object), object)
+	}
+	return isSigned, err
+}
+
+// verifySignedMessage parses and verifies the message signature without receive hooks
+func (signer *MessageSigner) verifySignedMessage(rawMessage string, object interface{}) (isSigned bool, err error) {
 	isSigned, err = VerifySenderJWSSignature(rawMessage, object, signer.GetPublicKey)
+	signer.reportError(err)
 	return isSigned, err
 }
 
+// PublishCommand signs a command and publishes it encrypted for the publisher of the destination
+// address. If no encryption key is given then the key of the destination publisher is obtained
+// with GetPublicKey, eg from the domain identities.
+// Returns an error if no key is found and commands must be encrypted, see SetEncryptCommands.
+func (signer *MessageSigner) PublishCommand(address string, object interface{}, encryptionKey *ecdsa.PublicKey) error {
+	if encryptionKey == nil && signer.GetPublicKey != nil {
+		encryptionKey = signer.GetPublicKey(address)
+	}
+	if encryptionKey == nil && signer.EncryptCommands() {
+		return fmt.Errorf("PublishCommand: No public key found to encrypt command to '%s'. Command not sent", address)
+	}
+	return signer.PublishObject(address, false, object, encryptionKey)
+}
+
 // PublishObject encapsulates the message object in a payload, signs the message, and sends it.
 //  If an encryption key is provided then the signed message will be encrypted.
 //  The object to publish will be marshalled to JSON and signed by this publisher
 func (signer *MessageSigner) PublishObject(address string, retained bool, object interface{}, encryptionKey *ecdsa.PublicKey) error {
-	// payload, err := json.Marshal(object)
-	payload, err := json.MarshalIndent(object, " ", " ")
-	if err != nil || object == nil {
+	object, publish := signer.ApplyPublishHooks(address, object)
+	if !publish {
+		return nil
+	}
+	message, err := signer.encodeObject(object, signer.isSignedPublication(address))
+	if err != nil {
 		errText := fmt.Sprintf("Publisher.publishMessage: Error marshalling message for address %s: %s", address, err)
 		return errors.New(errText)
 	}
+	// first sign, then encrypt as per RFC
 	if encryptionKey != nil {
-		err = signer.PublishEncrypted(address, retained, string(payload), encryptionKey)
-	} else {
-		err = signer.PublishSigned(address, retained, string(payload))
+		message, err = EncryptMessage(message, encryptionKey)
+		if err != nil {
+			return err
+		}
+	}
+	return signer.messenger.Publish(address, retained, message)
+}
+
+// PublishObjects signs a batch of message objects concurrently and publishes them in order.
+// Intended for publishing many messages at once, eg the values of high-rate outputs.
+// All publications are attempted. Returns the first error that occurred.
+// Publications that are vetoed by a publish hook are skipped.
+func (signer *MessageSigner) PublishObjects(publications []Publication) error {
+	objects := make([]interface{}, 0, len(publications))
+	signed := make([]bool, 0, len(publications))
+	published := make([]Publication, 0, len(publications))
+	for _, publication := range publications {
+		object, publish := signer.ApplyPublishHooks(publication.Address, publication.Object)
+		if publish {
+			objects = append(objects, object)
+			signed = append(signed, signer.isSignedPublication(publication.Address))
+			published = append(published, publication)
+		}
+	}
+	publications = published
+	messages, err := signer.encodeObjects(objects, signed)
+	if err != nil {
+		return err
+	}
+	for i, publication := range publications {
+		err2 := signer.messenger.Publish(publication.Address, publication.Retained, messages[i])
+		if err == nil {
+			err = err2
+		}
 	}
 	return err
 }
 
+// SignObject marshals the object to JSON and signs it if signing is enabled.
+// Intended for messages that are published by the message bus on behalf of the publisher, like the last will.
+func (signer *MessageSigner) SignObject(object interface{}) (message string, err error) {
+	message, err = signer.encodeObject(object, signer.signMessages)
+	if err != nil {
+		errText := fmt.Sprintf("MessageSigner.SignObject: Error marshalling message: %s", err)
+		return "", errors.New(errText)
+	}
+	return message, err
+}
+
+// SignObjects marshals a batch of message objects to JSON and signs them if signing is enabled.
+// The objects are signed concurrently using all CPU cores.
+// Returns the messages in the order of the objects, or an error if an object can't be marshalled.
+func (signer *MessageSigner) SignObjects(objects []interface{}) (messages []string, err error) {
+	signed := make([]bool, len(objects))
+	for i := range signed {
+		signed[i] = signer.signMessages
+	}
+	return signer.encodeObjects(objects, signed)
+}
+
+// encodeObjects marshals a batch of message objects to JSON concurrently and signs those that are
+// flagged to be signed
+func (signer *MessageSigner) encodeObjects(objects []interface{}, signed []bool) (messages []string, err error) {
+	messages = make([]string, len(objects))
+	errs := make([]error, len(objects))
+	workers := runtime.NumCPU()
+	if workers > len(objects) {
+		workers = len(objects)
+	}
+	waitGroup := sync.WaitGroup{}
+	waitGroup.Add(workers)
+	for worker := 0; worker < workers; worker++ {
+		go func(first int) {
+			for i := first; i < len(objects); i += workers {
+				messages[i], errs[i] = signer.encodeObject(objects[i], signed[i])
+			}
+			waitGroup.Done()
+		}(worker)
+	}
+	waitGroup.Wait()
+	for _, err = range errs {
+		if err != nil {
+			return nil, fmt.Errorf("MessageSigner.SignObjects: Error marshalling message: %s", err)
+		}
+	}
+	return messages, nil
+}
+
+// SetAuditHandler sets the handler that records the outcome of received commands, eg the audit
+// log. Use nil to remove the handler.
+func (signer *MessageSigner) SetAuditHandler(
+	handler func(address string, sender string, isEncrypted bool, isSigned bool, rejectErr error)) {
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	signer.auditHandler = handler
+}
+
+// SetEncryptCommands sets whether commands must be encrypted. Commands are only sent unencrypted
+// if this is disabled and the key of the destination publisher is unknown. Receivers of
+// commands accept unencrypted commands if this is disabled and strict mode is not enabled.
+// The default is enabled.
+func (signer *MessageSigner) SetEncryptCommands(encrypt bool) {
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	signer.encryptCmds = encrypt
+}
+
+// SetErrorHandler sets the handler that is invoked when a received message fails decryption or
+// signature verification. Use nil to remove the handler.
+func (signer *MessageSigner) SetErrorHandler(handler func(err error)) {
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	signer.errorHandler = handler
+}
+
+// SetTrustedSenderCheck enables strict mode for commands, which rejects commands that aren't
+// encrypted or whose sender doesn't pass the check, eg self-signed publishers that don't chain to
+// a trust anchor. Strict mode also rejects unsigned publications that the signing policy of
+// their publisher doesn't allow, see VerifyPublication.
+// Use nil to accept commands from any sender whose signature verifies.
+func (signer *MessageSigner) SetTrustedSenderCheck(isTrusted func(sender string) bool) {
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	signer.isTrusted = isTrusted
+}
+
 // SetSignMessages enables or disables message signing. Intended for testing.
 func (signer *MessageSigner) SetSignMessages(sign bool) {
 	signer.signMessages = sign
 }
 
+// reportError passes a decoding or verification error to the error handler, if set
+func (signer *MessageSigner) reportError(err error) {
+	if err == nil {
+		return
+	}
+	signer.updateMutex.Lock()
+	handler := signer.errorHandler
+	signer.updateMutex.Unlock()
+	if handler != nil {
+		handler(err)
+	}
+}
+
 // Subscribe to messages on the given address
+// The subscription is tracked so it can be listed with GetSubscriptions and restored with Resubscribe.
 func (signer *MessageSigner) Subscribe(
 	address string,
 	handler func(address string, message string) error) {
+	signer.updateMutex.Lock()
+	signer.subscriptions = append(signer.subscriptions, signerSubscription{address: address, handler: handler})
+	signer.updateMutex.Unlock()
 	signer.messenger.Subscribe(address, handler)
 }
 
 // Unsubscribe to messages on the given address
+// If handler is nil then all subscriptions with the address are removed
 func (signer *MessageSigner) Unsubscribe(
 	address string,
 	handler func(address string, message string) error) {
+	signer.updateMutex.Lock()
+	remaining := make([]signerSubscription, 0, len(signer.subscriptions))
+	removed := false
+	for _, subscription := range signer.subscriptions {
+		isMatch := subscription.address == address &&
+			(handler == nil || isSameHandler(subscription.handler, handler))
+		if isMatch && (handler == nil || !removed) {
+			removed = true
+		} else {
+			remaining = append(remaining, subscription)
+		}
+	}
+	signer.subscriptions = remaining
+	signer.updateMutex.Unlock()
 	signer.messenger.Unsubscribe(address, handler)
 }
 
+// GetSubscriptions returns the addresses of the active subscriptions, sorted by address.
+// An address is included once for each subscription.
+func (signer *MessageSigner) GetSubscriptions() []string {
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	addresses := make([]string, 0, len(signer.subscriptions))
+	for _, subscription := range signer.subscriptions {
+		addresses = append(addresses, subscription.address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// Resubscribe restores all active subscriptions with the messenger. Use this when the messenger
+// connection is reestablished or the publisher identity has changed.
+func (signer *MessageSigner) Resubscribe() {
+	signer.updateMutex.Lock()
+	subscriptions := signer.subscriptions
+	signer.updateMutex.Unlock()
+
+	logrus.Infof("MessageSigner.Resubscribe: %d subscriptions", len(subscriptions))
+	unsubscribed := make(map[string]bool)
+	for _, subscription := range subscriptions {
+		if !unsubscribed[subscription.address] {
+			signer.messenger.Unsubscribe(subscription.address, nil)
+			unsubscribed[subscription.address] = true
+		}
+	}
+	for _, subscription := range subscriptions {
+		signer.messenger.Subscribe(subscription.address, subscription.handler)
+	}
+}
+
+// UnsubscribeAll removes all active subscriptions
+func (signer *MessageSigner) UnsubscribeAll() {
+	signer.updateMutex.Lock()
+	subscriptions := signer.subscriptions
+	signer.subscriptions = make([]signerSubscription, 0)
+	signer.updateMutex.Unlock()
+	for _, subscription := range subscriptions {
+		signer.messenger.Unsubscribe(subscription.address, nil)
+	}
+}
+
+// isSameHandler returns true if both handlers refer to the same function
+// Method values of the same method, and closures of the same function literal, are considered the same.
+func isSameHandler(handler1 func(address string, message string) error,
+	handler2 func(address string, message string) error) bool {
+	return reflect.ValueOf(handler1).Pointer() == reflect.ValueOf(handler2).Pointer()
+}
+
 // PublishEncrypted sign and encrypts the payload and publish the resulting message on the given address
 // Signing only happens if the publisher's signingMethod is set to SigningMethodJWS
 func (signer *MessageSigner) PublishEncrypted(
 	address string, retained bool, payload string, publicKey *ecdsa.PublicKey) error {
 	var err error
+	payload, publish := signer.applyPublishHooksToPayload(address, payload)
+	if !publish {
+		return nil
+	}
 	message := payload
 	// first sign, then encrypt as per RFC
-	if signer.signMessages {
-		message, _ = CreateJWSSignature(string(payload), signer.privateKey)
+	if signer.isSignedPublication(address) {
+		message, _ = createES256Signature([]byte(payload), signer.privateKey)
 	}
 	emessage, err := EncryptMessage(message, publicKey)
 	err = signer.messenger.Publish(address, retained, emessage)
@@ -107,12 +433,16 @@ func (signer *MessageSigner) PublishEncrypted(
 func (signer *MessageSigner) PublishSigned(
 	address string, retained bool, payload string) error {
 	var err error
+	payload, publish := signer.applyPublishHooksToPayload(address, payload)
+	if !publish {
+		return nil
+	}
 
 	// default is unsigned
 	message := payload
 
-	if signer.signMessages {
-		message, err = CreateJWSSignature(string(payload), signer.privateKey)
+	if signer.isSignedPublication(address) {
+		message, err = createES256Signature([]byte(payload), signer.privateKey)
 		if err != nil {
 			logrus.Errorf("Publisher.publishMessage: Error signing message for address %s: %s", address, err)
 		}
@@ -128,18 +458,69 @@ func NewMessageSigner(messenger IMessenger, signingKey *ecdsa.PrivateKey,
 ) *MessageSigner {
 
 	signer := &MessageSigner{
-		GetPublicKey: getPublicKey,
-		messenger:    messenger,
-		signMessages: true,
-		privateKey:   signingKey, // private key for signing
+		GetPublicKey:  getPublicKey,
+		encryptCmds:   true,
+		messenger:     messenger,
+		policies:      make(map[string]map[string]bool),
+		signMessages:  true,
+		unsignedTypes: make(map[string]bool),
+		privateKey:    signingKey, // private key for signing
+		subscriptions: make([]signerSubscription, 0),
+		updateMutex:   &sync.Mutex{},
 	}
 	return signer
 }
 
+// encodeObject marshals the object to indented JSON and signs it if requested. The JSON is
+// encoded in a pooled buffer to reduce allocations of high-rate publications.
+func (signer *MessageSigner) encodeObject(object interface{}, sign bool) (message string, err error) {
+	if object == nil {
+		return "", errors.New("object is nil")
+	}
+	buffer := encodeBufferPool.Get().(*bytes.Buffer)
+	buffer.Reset()
+	defer encodeBufferPool.Put(buffer)
+	encoder := json.NewEncoder(buffer)
+	encoder.SetIndent(" ", " ")
+	err = encoder.Encode(object)
+	if err != nil {
+		return "", err
+	}
+	// the encoder terminates the JSON with a newline, unlike json.MarshalIndent
+	payload := bytes.TrimSuffix(buffer.Bytes(), []byte("\n"))
+	if !sign {
+		return string(payload), nil
+	}
+	return createES256Signature(payload, signer.privateKey)
+}
+
 /*
  *  Helper Functions for signing and verification
  */
 
+// encodeBufferPool holds the buffers for encoding messages to JSON, see encodeObject
+var encodeBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// es256Header is the base64url encoded protected header of ES256 signed JWS messages
+var es256Header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256"}`))
+
+// getMessageSender returns the sender of a decoded message from its 'Sender' field, or its
+// 'Address' field if it has no sender. Returns "" if neither is present.
+//  object is a pointer to the message struct
+func getMessageSender(object interface{}) string {
+	reflObject := reflect.ValueOf(object).Elem()
+	reflSender := reflObject.FieldByName("Sender")
+	if !reflSender.IsValid() {
+		reflSender = reflObject.FieldByName("Address")
+		if !reflSender.IsValid() {
+			return ""
+		}
+	}
+	return reflSender.String()
+}
+
 // CreateEcdsaSignature creates a ECDSA256 signature from the payload using the provided private key
 // This returns a base64url encoded signature
 func CreateEcdsaSignature(payload []byte, privateKey *ecdsa.PrivateKey) string {
@@ -166,11 +547,48 @@ func SignIdentity(publicIdent *types.PublisherIdentityMessage, privKey *ecdsa.Pr
 
 // CreateJWSSignature signs the payload using JSE ES256 and return the JSE compact serialized message
 func CreateJWSSignature(payload string, privateKey *ecdsa.PrivateKey) (string, error) {
+	return createES256Signature([]byte(payload), privateKey)
+}
+
+// createES256Signature signs the payload using JWS ES256 and returns the compact serialized
+// message. For P-256 keys the serialization is done directly into a single buffer with the
+// precomputed header, as this is much faster than the generic JOSE signer.
+func createES256Signature(payload []byte, privateKey *ecdsa.PrivateKey) (string, error) {
+	if privateKey == nil || privateKey.Curve != elliptic.P256() {
+		return createJoseSignature(payload, privateKey)
+	}
+	const signatureSize = 64 // R and S of 32 bytes each
+	payloadLen := base64.RawURLEncoding.EncodedLen(len(payload))
+	signatureLen := base64.RawURLEncoding.EncodedLen(signatureSize)
+	serialized := make([]byte, len(es256Header)+1+payloadLen+1+signatureLen)
+
+	// the signing input is the header and payload: header.payload
+	n := copy(serialized, es256Header)
+	serialized[n] = '.'
+	base64.RawURLEncoding.Encode(serialized[n+1:], payload)
+	n += 1 + payloadLen
+	hashed := sha256.Sum256(serialized[:n])
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	var signature [signatureSize]byte
+	rBytes := r.Bytes()
+	sBytes := s.Bytes()
+	copy(signature[signatureSize/2-len(rBytes):], rBytes)
+	copy(signature[signatureSize-len(sBytes):], sBytes)
+	serialized[n] = '.'
+	base64.RawURLEncoding.Encode(serialized[n+1:], signature[:])
+	return string(serialized), nil
+}
+
+// createJoseSignature signs the payload with the generic JOSE signer, eg for keys of other curves
+func createJoseSignature(payload []byte, privateKey *ecdsa.PrivateKey) (string, error) {
 	joseSigner, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: privateKey}, nil)
 	if err != nil {
 		return "", err
 	}
-	signedObject, err := joseSigner.Sign([]byte(payload))
+	signedObject, err := joseSigner.Sign(payload)
 	if err != nil {
 		return "", err
 	}
@@ -192,6 +610,41 @@ func DecryptMessage(serialized string, privateKey *ecdsa.PrivateKey) (message st
 	return message, false, err
 }
 
+// DecryptMultiMessage deserializes and decrypts a JWE message encrypted for multiple recipients
+// This returns the decrypted message, or an error if the private key isn't one of the recipients.
+func DecryptMultiMessage(serialized string, privateKey *ecdsa.PrivateKey) (message string, err error) {
+	decrypter, err := jose.ParseEncrypted(serialized)
+	if err != nil {
+		return "", err
+	}
+	_, _, dmessage, err := decrypter.DecryptMulti(privateKey)
+	return string(dmessage), err
+}
+
+// EncryptMessageMulti encrypts the message for multiple recipients and serializes it using
+// the JWE full serialization. Each recipient can decrypt the message with its own private key.
+func EncryptMessageMulti(message string, publicKeys []*ecdsa.PublicKey) (serialized string, err error) {
+	recipients := make([]jose.Recipient, 0)
+	for _, publicKey := range publicKeys {
+		if publicKey != nil {
+			recipients = append(recipients, jose.Recipient{Algorithm: jose.ECDH_ES_A128KW, Key: publicKey})
+		}
+	}
+	if len(recipients) == 0 {
+		return "", errors.New("EncryptMessageMulti: no recipients")
+	}
+	encrypter, err := jose.NewMultiEncrypter(jose.A128CBC_HS256, recipients, nil)
+	if err != nil {
+		return "", err
+	}
+	jwe, err := encrypter.Encrypt([]byte(message))
+	if err != nil {
+		return "", err
+	}
+	serialized = jwe.FullSerialize()
+	return serialized, nil
+}
+
 // EncryptMessage encrypts and serializes the message using JWE
 func EncryptMessage(message string, publicKey *ecdsa.PublicKey) (serialized string, err error) {
 	var jwe *jose.JSONWebEncryption
@@ -289,14 +742,21 @@ func VerifyJWSMessage(message string, publicKey *ecdsa.PublicKey) (payload strin
 // This returns a flag if the message was signed and if so, an error if the verification failed
 func VerifySenderJWSSignature(rawMessage string, object interface{}, getPublicKey func(address string) *ecdsa.PublicKey) (isSigned bool, err error) {
 
-	jwsSignature, err := jose.ParseSigned(rawMessage)
-	if err != nil {
-		// message is (probably) not signed, try to unmarshal it directly
-		err = json.Unmarshal([]byte(rawMessage), object)
-		return false, err
+	// messages signed by this library are decoded without the generic JOSE parser
+	var jwsSignature *jose.JSONWebSignature
+	compactJWS, isCompact := parseCompactES256(rawMessage)
+	if isCompact {
+		err = compactJWS.decodePayload(object)
+	} else {
+		jwsSignature, err = jose.ParseSigned(rawMessage)
+		if err != nil {
+			// message is (probably) not signed, try to unmarshal it directly
+			err = decodeJSON(rawMessage, object)
+			return false, err
+		}
+		payload := jwsSignature.UnsafePayloadWithoutVerification()
+		err = json.Unmarshal(payload, object)
 	}
-	payload := jwsSignature.UnsafePayloadWithoutVerification()
-	err = json.Unmarshal([]byte(payload), object)
 	if err != nil {
 		// message doesn't have a json payload
 		errTxt := fmt.Sprintf("VerifySenderSignature: Signature okay but message unmarshal failed: %s", err)
@@ -304,15 +764,11 @@ func VerifySenderJWSSignature(rawMessage string, object interface{}, getPublicKe
 	}
 	// determine who the sender is
 	reflObject := reflect.ValueOf(object).Elem()
-	reflSender := reflObject.FieldByName("Sender")
-	if !reflSender.IsValid() {
-		reflSender = reflObject.FieldByName("Address")
-		if !reflSender.IsValid() {
-			err = errors.New("VerifySenderJWSSignature: object doesn't have a Sender or Address field")
-			return true, err
-		}
+	if !reflObject.FieldByName("Sender").IsValid() && !reflObject.FieldByName("Address").IsValid() {
+		err = errors.New("VerifySenderJWSSignature: object doesn't have a Sender or Address field")
+		return true, err
 	}
-	sender := reflSender.String()
+	sender := getMessageSender(object)
 	if sender == "" {
 		err := errors.New("VerifySenderJWSSignature: Missing sender or address information in message")
 		return true, err
@@ -327,7 +783,13 @@ func VerifySenderJWSSignature(rawMessage string, object interface{}, getPublicKe
 		return true, err
 	}
 
-	_, err = jwsSignature.Verify(publicKey)
+	if isCompact {
+		if !compactJWS.verify(publicKey) {
+			err = errors.New("signature does not verify")
+		}
+	} else {
+		_, err = jwsSignature.Verify(publicKey)
+	}
 	if err != nil {
 		msg := fmt.Sprintf("VerifySenderJWSSignature: message signature from %s fails to verify with its public key", sender)
 		err := errors.New(msg)
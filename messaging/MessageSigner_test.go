@@ -4,13 +4,16 @@ import (
 	"crypto/ecdsa"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"log"
 	"testing"
 	"time"
 
+	"github.com/iotdomain/iotdomain-go/internal/perftest"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/square/go-jose.v2"
 )
 
@@ -128,6 +131,17 @@ func TestSigningPerformance(t *testing.T) {
 	duration = time.Since(start).Seconds()
 	log.Printf("10K CreateJWSSignature signatures generated in %.2f seconds", duration)
 
+	// marshal and sign a batch of objects concurrently
+	signer := messaging.NewMessageSigner(messaging.NewDummyMessenger(nil), privKey, nil)
+	objects := make([]interface{}, 10000)
+	for count := range objects {
+		objects[count] = testObject
+	}
+	start = time.Now()
+	signer.SignObjects(objects)
+	duration = time.Since(start).Seconds()
+	log.Printf("10K SignObjects signatures generated in %.2f seconds", duration)
+
 	// verify sig of base64URL encoded payload
 	payload1base64 := base64.URLEncoding.EncodeToString(payload1)
 	sig := messaging.CreateEcdsaSignature([]byte(payload1base64), privKey)
@@ -151,6 +165,60 @@ func TestSigningPerformance(t *testing.T) {
 	log.Printf("10K VerifyJWSMessage signatures verified in %.2f seconds", duration)
 }
 
+func TestSignObjects(t *testing.T) {
+	privKey := messaging.CreateAsymKeys()
+	messenger := messaging.NewDummyMessenger(nil)
+	signer := messaging.NewMessageSigner(messenger, privKey, nil)
+	expected, _ := json.MarshalIndent(testObject2, " ", " ")
+
+	// the signed message is a standard JWS with the indented JSON payload
+	message, err := signer.SignObject(testObject2)
+	require.NoError(t, err)
+	payload, err := messaging.VerifyJWSMessage(message, &privKey.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), payload)
+	jws, err := jose.ParseSigned(message)
+	require.NoError(t, err)
+	assert.Equal(t, "ES256", jws.Signatures[0].Protected.Algorithm)
+
+	// batches are signed in order
+	objects := make([]interface{}, 0)
+	publications := make([]messaging.Publication, 0)
+	for count := 0; count < 25; count++ {
+		object := TestObjectNoSender{Address: "test/publisher1/node1", Field2: count}
+		objects = append(objects, object)
+		publications = append(publications, messaging.Publication{
+			Address: fmt.Sprintf("test/publisher1/node%d", count), Object: object, Retained: true})
+	}
+	messages, err := signer.SignObjects(objects)
+	require.NoError(t, err)
+	require.Len(t, messages, len(objects))
+	for count, message := range messages {
+		var received TestObjectNoSender
+		payload, err = messaging.VerifyJWSMessage(message, &privKey.PublicKey)
+		require.NoError(t, err)
+		json.Unmarshal([]byte(payload), &received)
+		assert.Equal(t, count, received.Field2)
+	}
+	err = signer.PublishObjects(publications)
+	assert.NoError(t, err)
+	assert.Equal(t, 25, messenger.NrPublications())
+	var received TestObjectNoSender
+	messaging.VerifySenderJWSSignature(messenger.FindLastPublication("test/publisher1/node24"), &received, nil)
+	assert.Equal(t, 24, received.Field2)
+
+	// unsigned messages are the plain JSON
+	signer.SetSignMessages(false)
+	message, err = signer.SignObject(testObject2)
+	assert.Equal(t, string(expected), message)
+
+	// error case - objects that can't be marshalled
+	_, err = signer.SignObjects([]interface{}{testObject, make(chan int)})
+	assert.Error(t, err)
+	err = signer.PublishObjects([]messaging.Publication{{Address: "test/publisher1", Object: nil}})
+	assert.Error(t, err)
+}
+
 // Test the sender verification
 func TestVerifySender(t *testing.T) {
 	privKey := messaging.CreateAsymKeys()
@@ -296,6 +364,59 @@ func TestSigner(t *testing.T) {
 	signer.Unsubscribe("test/+/#", nil)
 }
 
+func TestPublishCommand(t *testing.T) {
+	var isEncrypted bool
+	var decodeErr error
+	var rxCount = 0
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	privKey := messaging.CreateAsymKeys()
+	var knownKey *ecdsa.PublicKey
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return knownKey
+	}
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	signer.Subscribe("test/+/#", func(address string, rawMessage string) error {
+		obj := TestObjectWithSender{}
+		isEncrypted, _, decodeErr = signer.DecodeCommand(rawMessage, &obj)
+		rxCount++
+		return nil
+	})
+	obj := TestObjectWithSender{Field1: "command", Sender: "test/bob"}
+	assert.True(t, signer.EncryptCommands())
+
+	// error case - the destination key is unknown
+	err := signer.PublishCommand("test/bob/node1/$configure", obj, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 0, rxCount)
+
+	// the destination key is looked up
+	knownKey = &privKey.PublicKey
+	err = signer.PublishCommand("test/bob/node1/$configure", obj, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rxCount)
+	assert.True(t, isEncrypted)
+
+	// without encryption the command is sent signed only if the key is unknown
+	knownKey = nil
+	signer.SetEncryptCommands(false)
+	err = signer.PublishCommand("test/bob/node1/$configure", obj, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rxCount)
+	assert.False(t, isEncrypted)
+
+	// strict mode rejects unencrypted commands
+	knownKey = &privKey.PublicKey
+	signer.SetTrustedSenderCheck(func(sender string) bool { return true })
+	err = signer.PublishObject("test/bob/node1/$configure", false, obj, nil)
+	assert.NoError(t, err)
+	assert.False(t, isEncrypted)
+	assert.Error(t, decodeErr)
+	err = signer.PublishCommand("test/bob/node1/$configure", obj, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, decodeErr)
+	signer.Unsubscribe("test/+/#", nil)
+}
+
 func TestSignIdentity(t *testing.T) {
 	dssKeys := messaging.CreateAsymKeys()
 	newIdent := types.PublisherFullIdentity{}
@@ -311,3 +432,122 @@ func TestSignIdentity(t *testing.T) {
 	err := messaging.VerifyIdentitySignature(&newIdent.PublisherIdentityMessage, &dssKeys.PublicKey)
 	assert.Nil(t, err)
 }
+
+func TestEncryptMulti(t *testing.T) {
+	reader1 := messaging.CreateAsymKeys()
+	reader2 := messaging.CreateAsymKeys()
+	other := messaging.CreateAsymKeys()
+	const text = "the secret"
+
+	serialized, err := messaging.EncryptMessageMulti(text, []*ecdsa.PublicKey{&reader1.PublicKey, &reader2.PublicKey})
+	assert.NoError(t, err)
+
+	message, err := messaging.DecryptMultiMessage(serialized, reader1)
+	assert.NoError(t, err)
+	assert.Equal(t, text, message)
+	signer := messaging.NewMessageSigner(nil, reader2, nil)
+	message, err = signer.DecryptMulti(serialized)
+	assert.NoError(t, err)
+	assert.Equal(t, text, message)
+
+	_, err = messaging.DecryptMultiMessage(serialized, other)
+	assert.Error(t, err)
+	_, err = messaging.EncryptMessageMulti(text, nil)
+	assert.Error(t, err)
+}
+
+func TestSubscriptions(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(nil)
+	privKey := messaging.CreateAsymKeys()
+	signer := messaging.NewMessageSigner(messenger, privKey, nil)
+	received := 0
+	handler := func(address string, message string) error {
+		received++
+		return nil
+	}
+	signer.Subscribe("test/+/$identity", handler)
+	signer.Subscribe("test/publisher1/$status", handler)
+	assert.Equal(t, []string{"test/+/$identity", "test/publisher1/$status"}, signer.GetSubscriptions())
+
+	// resubscribe doesn't duplicate subscriptions
+	signer.Resubscribe()
+	messenger.OnReceive("test/publisher1/$identity", "hello")
+	assert.Equal(t, 1, received)
+
+	signer.Unsubscribe("test/+/$identity", nil)
+	assert.Equal(t, []string{"test/publisher1/$status"}, signer.GetSubscriptions())
+	messenger.OnReceive("test/publisher1/$identity", "hello")
+	assert.Equal(t, 1, received)
+
+	signer.UnsubscribeAll()
+	assert.Empty(t, signer.GetSubscriptions())
+	messenger.OnReceive("test/publisher1/$status", "hello")
+	assert.Equal(t, 1, received)
+}
+
+// Performance budget of signing and marshalling on a CI host, see the README. The budget is
+// enforced by TestSigningPerformanceBudget unless tests run in short mode.
+const signObjectBudget = 250 * time.Microsecond
+const verifySignedMessageBudget = 500 * time.Microsecond
+const marshalLatestBudget = 5 * time.Microsecond
+
+func TestSigningPerformanceBudget(t *testing.T) {
+	perftest.SkipUnlessEnabled(t)
+	result := testing.Benchmark(BenchmarkSignObject)
+	assert.LessOrEqualf(t, result.NsPerOp(), int64(signObjectBudget),
+		"SignObject exceeds the budget of %s: %s", signObjectBudget, result)
+	result = testing.Benchmark(BenchmarkVerifySignedMessage)
+	assert.LessOrEqualf(t, result.NsPerOp(), int64(verifySignedMessageBudget),
+		"VerifySignedMessage exceeds the budget of %s: %s", verifySignedMessageBudget, result)
+	result = testing.Benchmark(BenchmarkMarshalLatest)
+	assert.LessOrEqualf(t, result.NsPerOp(), int64(marshalLatestBudget),
+		"Marshalling $latest exceeds the budget of %s: %s", marshalLatestBudget, result)
+}
+
+// BenchmarkSignObject measures marshalling and signing of an output value, the main cost of publishing
+func BenchmarkSignObject(b *testing.B) {
+	privKey := messaging.CreateAsymKeys()
+	signer := messaging.NewMessageSigner(messaging.NewDummyMessenger(nil), privKey, nil)
+	latest := &types.OutputLatestMessage{
+		Address:   "test/publisher1/node1/temperature/0/$latest",
+		Sequence:  1,
+		Timestamp: time.Now().Format(types.TimeFormat),
+		Unit:      types.UnitCelcius,
+		Value:     "21.5",
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for count := 0; count < b.N; count++ {
+		signer.SignObject(latest)
+	}
+}
+
+// BenchmarkVerifySignedMessage measures verification and unmarshalling of a received signed message
+func BenchmarkVerifySignedMessage(b *testing.B) {
+	privKey := messaging.CreateAsymKeys()
+	signer := messaging.NewMessageSigner(messaging.NewDummyMessenger(nil), privKey,
+		func(address string) *ecdsa.PublicKey { return &privKey.PublicKey })
+	message, err := signer.SignObject(testObject)
+	require.NoError(b, err)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for count := 0; count < b.N; count++ {
+		var received TestObjectWithSender
+		signer.VerifySignedMessage(message, &received)
+	}
+}
+
+// BenchmarkMarshalLatest measures marshalling of an output value without signing
+func BenchmarkMarshalLatest(b *testing.B) {
+	latest := &types.OutputLatestMessage{
+		Address:   "test/publisher1/node1/temperature/0/$latest",
+		Sequence:  1,
+		Timestamp: time.Now().Format(types.TimeFormat),
+		Unit:      types.UnitCelcius,
+		Value:     "21.5",
+	}
+	b.ReportAllocs()
+	for count := 0; count < b.N; count++ {
+		json.Marshal(latest)
+	}
+}
@@ -2,11 +2,8 @@
 package messaging
 
 import (
-	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"sync"
 	"time"
@@ -24,13 +21,16 @@ const TLSPort = 8883
 
 // MqttMessenger that implements IMessenger
 type MqttMessenger struct {
-	config              *MessengerConfig    // connect information
-	isRunning           bool                // listen for messages while running
-	pahoClient          pahomqtt.Client     // Paho MQTT Client
-	subscriptions       []TopicSubscription // list of TopicSubscription for re-subscribing after reconnect
-	tlsVerifyServerCert bool                // verify the server certificate, this requires a Root CA signed cert
-	tlsCACertFile       string              // path to CA certificate
-	updateMutex         *sync.Mutex         // mutex for async updating of subscriptions
+	brokerIndex       int                             // index of the broker that is or was last connected
+	brokerURLs        []string                        // brokers in order of preference
+	clientOptions     *pahomqtt.ClientOptions         // options of the client of each broker
+	config            *MessengerConfig                // connect information
+	connectionHandler func(connected bool, err error) // notify of connection changes
+	connectionState   ConnectionState                 // current state of the connection
+	isRunning         bool                            // listen for messages while running
+	pahoClient        pahomqtt.Client                 // Paho MQTT Client
+	subscriptions     []TopicSubscription             // list of TopicSubscription for re-subscribing after reconnect
+	updateMutex       *sync.Mutex                     // mutex for async updating of subscriptions
 }
 
 // TopicSubscription holds subscriptions to restore after disconnect
@@ -47,6 +47,7 @@ type TopicSubscription struct {
 // @param lastWillTopic optional last will and testament address for publishing device state on accidental disconnect.
 //                       Use "" to ignore LWT feature.
 // @param lastWillValue to use as the last will
+// The last will address and value from the messenger configuration take precedence.
 func (messenger *MqttMessenger) Connect(lastWillAddress string, lastWillValue string) error {
 	config := messenger.config
 
@@ -68,14 +69,22 @@ func (messenger *MqttMessenger) Connect(lastWillAddress string, lastWillValue st
 		port = TLSPort
 	}
 
-	brokerURL := fmt.Sprintf("tls://%s:%d/", config.Server, port) // tcp://host:1883 ws://host:1883 tls://host:8883, tcps://awshost:8883/mqtt
-	// brokerURL := fmt.Sprintf("tls://mqtt.eclipse.org:8883/")
+	server := config.Server
+	if config.Discovery {
+		server, port = discoverBroker(config, server, port)
+	}
+
+	brokerURLs := BrokerURLs(config, server, port) // tcp://host:1883 ws://host:1883 tls://host:8883, tcps://awshost:8883/mqtt
+	if len(brokerURLs) == 0 {
+		err := errors.New("MqttMessenger.Connect: No broker server is configured")
+		logrus.Error(err)
+		return err
+	}
 	opts := pahomqtt.NewClientOptions()
-	opts.AddBroker(brokerURL)
 	opts.SetClientID(config.ClientID)
-	opts.SetAutoReconnect(true)
+	// reconnect is handled by the messenger using the reconnect policy from the config
+	opts.SetAutoReconnect(false)
 	opts.SetConnectTimeout(10 * time.Second)
-	opts.SetMaxReconnectInterval(60 * time.Second) // max wait 1 minute for a reconnect
 	// Do not use MQTT persistence as not all brokers support it, and it causes problems on the broker if the client ID is
 	// randomly generated. CleanSession disables persistence.
 	opts.SetCleanSession(true)
@@ -84,66 +93,168 @@ func (messenger *MqttMessenger) Connect(lastWillAddress string, lastWillValue st
 
 	opts.SetOnConnectHandler(func(client pahomqtt.Client) {
 		logrus.Warningf("MqttMessenger.onConnect: Connected to server at %s. Connected=%v. ClientId=%s",
-			brokerURL, client.IsConnected(), config.ClientID)
+			messenger.getBrokerURL(), client.IsConnected(), config.ClientID)
 		// Subscribe to addresss already registered by the app on connect or reconnect
 		messenger.resubscribe()
+		messenger.notifyConnectionChange(true, nil)
 	})
 	opts.SetConnectionLostHandler(func(client pahomqtt.Client, err error) {
 		log.Warningf("MqttMessenger.onConnectionLost: Disconnected from server %s. Error %s, ClientId=%s",
-			brokerURL, err, config.ClientID)
+			messenger.getBrokerURL(), err, config.ClientID)
+		messenger.notifyConnectionChange(false, err)
+		go messenger.reconnect()
 	})
+	lastWillAddress, lastWillValue = LastWill(config, lastWillAddress, lastWillValue)
 	if lastWillAddress != "" {
-		opts.SetWill(lastWillAddress, lastWillValue, 1, false)
+		opts.SetWill(lastWillAddress, lastWillValue, 1, config.LastWillRetained)
 	}
-	// Use TLS if a CA certificate is given
-	var rootCA *x509.CertPool
-	if messenger.tlsCACertFile != "" {
-		rootCA = x509.NewCertPool()
-		caFile, err := ioutil.ReadFile(messenger.tlsCACertFile)
-		if err != nil {
-			logrus.Errorf("MqttMessenger.Connect: Unable to read CA certificate chain: %s", err)
-		}
-		rootCA.AppendCertsFromPEM([]byte(caFile))
+	tlsConfig, err := MakeTLSConfig(config)
+	if err != nil {
+		logrus.Errorf("MqttMessenger.Connect: %s", err)
+		return err
 	}
-	opts.SetTLSConfig(&tls.Config{
-		InsecureSkipVerify: !messenger.tlsVerifyServerCert,
-		RootCAs:            rootCA, // include the zcas cert in the host root ca set
-		// https://opium.io/blog/mqtt-in-go/
-		ServerName: "", // hostname on the server certificate. How to get this?
-	})
-
-	logrus.Infof("MqttMessenger.Connect: Connecting to MQTT server: %s with clientID %s"+
-		" AutoReconnect and CleanSession are set.",
-		brokerURL, config.ClientID)
+	opts.SetTLSConfig(tlsConfig)
 
-	// FIXME: PahoMqtt disconnects when sending a lot of messages, like on startup of some adapters.
-	messenger.pahoClient = pahomqtt.NewClient(opts)
+	logrus.Infof("MqttMessenger.Connect: Connecting to MQTT servers: %v with clientID %s"+
+		" Reconnect and CleanSession are set.",
+		brokerURLs, config.ClientID)
 
 	// start listening for messages
+	messenger.updateMutex.Lock()
+	messenger.brokerIndex = 0
+	messenger.brokerURLs = brokerURLs
+	messenger.clientOptions = opts
 	messenger.isRunning = true
+	messenger.connectionState = ConnectionStateConnecting
+	messenger.updateMutex.Unlock()
 	//go messenger.messageChanLoop()
 
 	// Auto reconnect doesn't work for initial attempt: https://github.com/eclipse/paho.mqtt.golang/issues/77
-	retryDelaySec := 1
-	for {
-		token := messenger.pahoClient.Connect()
-		token.Wait()
+	err = messenger.connectWithRetry(false)
+	if err != nil {
+		messenger.setConnectionState(ConnectionStateDisconnected)
+	}
+	return err
+}
+
+// connectWithRetry connects to one of the brokers and retries using the reconnect policy until
+// connected, the maximum number of attempts is reached, or the messenger is disconnected. Each
+// attempt tries the brokers in failover order. The subscriptions are restored on connect.
+//  failover is true when the connection to the current broker is lost
+func (messenger *MqttMessenger) connectWithRetry(failover bool) error {
+	config := messenger.config
+	for attempt := 0; ; attempt++ {
+		messenger.updateMutex.Lock()
+		brokerURLs := messenger.brokerURLs
+		order := BrokerFailoverOrder(config, len(brokerURLs), messenger.brokerIndex, failover)
+		messenger.updateMutex.Unlock()
+
+		var err error
+		for _, brokerIndex := range order {
+			err = messenger.connectToBroker(brokerIndex)
+			if err == nil {
+				return nil
+			} else if !messenger.isConnecting() {
+				return err
+			}
+			logrus.Errorf("MqttMessenger.connectWithRetry: Connecting to broker on %s failed: %s",
+				brokerURLs[brokerIndex], err)
+		}
+		if config.ReconnectMaxAttempts > 0 && attempt+1 >= config.ReconnectMaxAttempts {
+			logrus.Errorf("MqttMessenger.connectWithRetry: Connecting to brokers %v failed. Giving up after %d attempts.",
+				brokerURLs, attempt+1)
+			return err
+		}
+		retryDelay := ReconnectDelay(config, attempt)
+		logrus.Errorf("MqttMessenger.connectWithRetry: Connecting to brokers %v failed. retrying in %s.",
+			brokerURLs, retryDelay)
+		time.Sleep(retryDelay)
+	}
+}
+
+// connectToBroker creates the client of a broker and connects it
+//  brokerIndex is the index of the broker in the broker URLs
+func (messenger *MqttMessenger) connectToBroker(brokerIndex int) error {
+	messenger.updateMutex.Lock()
+	if !messenger.isRunning {
+		messenger.updateMutex.Unlock()
+		return errors.New("MqttMessenger.connectToBroker: messenger is disconnected")
+	}
+	opts := *messenger.clientOptions
+	opts.Servers = nil
+	opts.AddBroker(messenger.brokerURLs[brokerIndex])
+	// FIXME: PahoMqtt disconnects when sending a lot of messages, like on startup of some adapters.
+	client := pahomqtt.NewClient(&opts)
+	messenger.brokerIndex = brokerIndex
+	messenger.pahoClient = client
+	messenger.updateMutex.Unlock()
+
+	token := client.Connect()
+	token.Wait()
+	err := token.Error()
+	if err == nil {
 		// Wait to give connection time to settle. Sending a lot of messages causes the connection to fail. Bug?
 		time.Sleep(1000 * time.Millisecond)
-		err := token.Error()
-		if err == nil {
-			break
-		}
+	}
+	return err
+}
 
-		logrus.Errorf("MqttMessenger.Connect: Connecting to broker on %s failed: %s. retrying in %d seconds.",
-			brokerURL, token.Error(), retryDelaySec)
-		time.Sleep(time.Duration(retryDelaySec) * time.Second)
-		// slowly increment wait time
-		if retryDelaySec < 120 {
-			retryDelaySec++
-		}
+// ConnectionState returns the current state of the connection with the broker
+func (messenger *MqttMessenger) ConnectionState() ConnectionState {
+	messenger.updateMutex.Lock()
+	defer messenger.updateMutex.Unlock()
+	return messenger.connectionState
+}
+
+// getBrokerURL returns the URL of the broker that is or was last connected
+func (messenger *MqttMessenger) getBrokerURL() string {
+	messenger.updateMutex.Lock()
+	defer messenger.updateMutex.Unlock()
+	if messenger.brokerIndex >= len(messenger.brokerURLs) {
+		return ""
+	}
+	return messenger.brokerURLs[messenger.brokerIndex]
+}
+
+// isConnecting returns true if the messenger is connecting, false after Disconnect
+func (messenger *MqttMessenger) isConnecting() bool {
+	messenger.updateMutex.Lock()
+	defer messenger.updateMutex.Unlock()
+	return messenger.isRunning
+}
+
+// notifyConnectionChange invokes the configured callbacks and connection handler
+// The connection state is updated before the handlers are invoked. A lost connection is reconnecting
+// while the messenger is running.
+func (messenger *MqttMessenger) notifyConnectionChange(connected bool, err error) {
+	messenger.updateMutex.Lock()
+	handler := messenger.connectionHandler
+	if connected {
+		messenger.connectionState = ConnectionStateConnected
+	} else if messenger.isRunning {
+		messenger.connectionState = ConnectionStateConnecting
+	} else {
+		messenger.connectionState = ConnectionStateDisconnected
+	}
+	messenger.updateMutex.Unlock()
+
+	if connected && messenger.config.OnConnect != nil {
+		messenger.config.OnConnect()
+	} else if !connected && messenger.config.OnDisconnect != nil {
+		messenger.config.OnDisconnect(err)
+	}
+	if handler != nil {
+		handler(connected, err)
+	}
+}
+
+// reconnect after the connection was lost, failing over to the other brokers if configured
+func (messenger *MqttMessenger) reconnect() {
+	err := messenger.connectWithRetry(true)
+	if err != nil {
+		logrus.Errorf("MqttMessenger.reconnect: Reconnect failed: %s", err)
+		messenger.setConnectionState(ConnectionStateDisconnected)
 	}
-	return nil
 }
 
 // Disconnect from the MQTT broker and unsubscribe from all addresss and set
@@ -151,6 +262,7 @@ func (messenger *MqttMessenger) Connect(lastWillAddress string, lastWillValue st
 func (messenger *MqttMessenger) Disconnect() {
 	messenger.updateMutex.Lock()
 	messenger.isRunning = false
+	messenger.connectionState = ConnectionStateDisconnected
 	messenger.updateMutex.Unlock()
 
 	if messenger.pahoClient != nil {
@@ -158,10 +270,13 @@ func (messenger *MqttMessenger) Disconnect() {
 		//messenger.publish("$state", "disconnected")
 		time.Sleep(time.Second / 10) // Disconnect doesn't seem to wait for all messages. A small delay ahead helps
 		messenger.pahoClient.Disconnect(10 * ConnectionTimeoutSec * 1000)
+		messenger.updateMutex.Lock()
 		messenger.pahoClient = nil
+		messenger.updateMutex.Unlock()
 
 		messenger.subscriptions = nil
 		//close(messenger.messageChannel)     // end the message handler loop
+		messenger.notifyConnectionChange(false, nil)
 	}
 }
 
@@ -176,9 +291,10 @@ func (messenger *MqttMessenger) Publish(address string, retained bool, message s
 		logrus.Warnf("MqttMessenger.Publish: Unable to publish. No connection with server.")
 		return errors.New("no connection with server")
 	}
+	qos := PublishQoS(messenger.config, address)
 	logrus.Debugf("MqttMessenger.Publish []byte: address=%s, qos=%d, retained=%v",
-		address, messenger.config.PubQos, retained)
-	token := messenger.pahoClient.Publish(address, messenger.config.PubQos, retained, message)
+		address, qos, retained)
+	token := messenger.pahoClient.Publish(address, qos, retained, message)
 
 	err = token.Error()
 	if err != nil {
@@ -197,7 +313,7 @@ func (messenger *MqttMessenger) PublishRaw(address string, retained bool, messag
 	}
 	// publication := Publication{Message: message}
 	// payload, err := json.Marshal(publication)
-	token := messenger.pahoClient.Publish(address, messenger.config.PubQos, retained, []byte(message))
+	token := messenger.pahoClient.Publish(address, PublishQoS(messenger.config, address), retained, []byte(message))
 
 	err := token.Error()
 	if err != nil {
@@ -226,7 +342,6 @@ func (subscription *TopicSubscription) onMessage(c pahomqtt.Client, msg pahomqtt
 // subscribe to addresss after establishing connection
 // The application can already subscribe to addresss before the connection is established. If connection is lost then
 // this will re-subscribe to those addresss as PahoMqtt drops the subscriptions after disconnect.
-//
 func (messenger *MqttMessenger) resubscribe() {
 	// prevent simultaneous access to subscriptions
 	messenger.updateMutex.Lock()
@@ -240,7 +355,7 @@ func (messenger *MqttMessenger) resubscribe() {
 		logrus.Infof("MqttMessenger.resubscribe: address %s", subscription.address)
 		// create a new variable to hold the subscription in the closure
 		newSubscr := subscription
-		token := messenger.pahoClient.Subscribe(newSubscr.address, messenger.config.PubQos, newSubscr.onMessage)
+		token := messenger.pahoClient.Subscribe(newSubscr.address, SubscribeQoS(messenger.config, newSubscr.address), newSubscr.onMessage)
 		//token := messenger.pahoClient.Subscribe(newSubscr.address, newSubscr.qos, func (c pahomqtt.Client, msg pahomqtt.Message) {
 		//logrus.Infof("mqtt.resubscribe.onMessage: address %s, subscription %s", msg.Topic(), newSubscr.address)
 		//newSubscr.onMessage(c, msg)
@@ -250,6 +365,25 @@ func (messenger *MqttMessenger) resubscribe() {
 	logrus.Infof("MqttMessenger.resubscribe complete")
 }
 
+// setConnectionState sets the state of the connection without notifying the connection handler
+func (messenger *MqttMessenger) setConnectionState(state ConnectionState) {
+	messenger.updateMutex.Lock()
+	defer messenger.updateMutex.Unlock()
+	messenger.connectionState = state
+}
+
+// Request publishes a request and waits for the reply, see SendRequest
+func (messenger *MqttMessenger) Request(address string, payload string, timeout time.Duration) (string, error) {
+	return SendRequest(messenger, address, payload, timeout)
+}
+
+// SetConnectionHandler sets the handler that is notified when the connection is established, lost or closed
+func (messenger *MqttMessenger) SetConnectionHandler(handler func(connected bool, err error)) {
+	messenger.updateMutex.Lock()
+	defer messenger.updateMutex.Unlock()
+	messenger.connectionHandler = handler
+}
+
 // Subscribe to a address
 // Subscribers are automatically resubscribed after the connection is restored
 // If no connection exists, then subscriptions are stored until a connection is established.
@@ -268,10 +402,10 @@ func (messenger *MqttMessenger) Subscribe(
 	defer messenger.updateMutex.Unlock()
 	messenger.subscriptions = append(messenger.subscriptions, subscription)
 
-	logrus.Infof("MqttMessenger.Subscribe: address %s, qos %d", address, messenger.config.SubQos)
+	logrus.Infof("MqttMessenger.Subscribe: address %s, qos %d", address, SubscribeQoS(messenger.config, address))
 	//messenger.pahoClient.Subscribe(address, qos, addressSubscription.onMessage) //func(c pahomqtt.Client, msg pahomqtt.Message) {
 	if messenger.pahoClient != nil {
-		messenger.pahoClient.Subscribe(address, messenger.config.SubQos, subscription.onMessage) //func(c pahomqtt.Client, msg pahomqtt.Message) {
+		messenger.pahoClient.Subscribe(address, SubscribeQoS(messenger.config, address), subscription.onMessage) //func(c pahomqtt.Client, msg pahomqtt.Message) {
 	}
 	// return nil
 }
@@ -280,37 +414,35 @@ func (messenger *MqttMessenger) Subscribe(
 // if handler is nil then only the address needs to match
 func (messenger *MqttMessenger) Unsubscribe(
 	address string, onMessage func(address string, message string) error) {
-	// messenger.publishMutex.Lock()
-	var onMessageID = onMessage
-	// onMessageStr := fmt.Sprintf("%v", &onMessage)
-	for i, sub := range messenger.subscriptions {
-		// can't compare addresses directly so convert to string
-		// handlerStr := fmt.Sprintf("%v", &sub.handler)
-		var handlerID = sub.handler
-		// if sub.address == address && handlerStr == onMessageStr {
-		if sub.address == address && (onMessage == nil || &onMessageID == &handlerID) {
-			// shift remainder left one index
-			if i < len(messenger.subscriptions) {
-				copy(messenger.subscriptions[i:], messenger.subscriptions[i+1:])
-			}
-			messenger.subscriptions = messenger.subscriptions[:len(messenger.subscriptions)-1]
-			if onMessage != nil {
-				break
-			}
+	messenger.updateMutex.Lock()
+	defer messenger.updateMutex.Unlock()
+	remaining := make([]TopicSubscription, 0, len(messenger.subscriptions))
+	removed := false
+	isAddressInUse := false
+	for _, sub := range messenger.subscriptions {
+		isMatch := sub.address == address && (onMessage == nil || isSameHandler(sub.handler, onMessage))
+		if isMatch && (onMessage == nil || !removed) {
+			removed = true
+		} else {
+			remaining = append(remaining, sub)
+			isAddressInUse = isAddressInUse || sub.address == address
 		}
 	}
-	// messenger.publishMutex.Unlock()
+	messenger.subscriptions = remaining
+	// the broker subscription is removed with the last subscription of the address
+	if removed && !isAddressInUse && messenger.pahoClient != nil && messenger.pahoClient.IsConnected() {
+		messenger.pahoClient.Unsubscribe(address)
+	}
 }
 
 // NewMqttMessenger creates a new MQTT messenger instance
 func NewMqttMessenger(config *MessengerConfig) *MqttMessenger {
 	messenger := &MqttMessenger{
-		config:     config,
-		pahoClient: nil,
+		config:          config,
+		connectionState: ConnectionStateDisconnected,
+		pahoClient:      nil,
 		//messageChannel: make(chan *IncomingMessage),
-		tlsCACertFile:       "/etc/mosquitto/certs/zcas_ca.crt",
-		tlsVerifyServerCert: true,
-		updateMutex:         &sync.Mutex{},
+		updateMutex: &sync.Mutex{},
 	}
 	return messenger
 }
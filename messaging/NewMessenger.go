@@ -4,6 +4,10 @@ package messaging
 // Create a messenger instance using configuration setting:
 //    "DummyMessenger" (default)
 //    MQTTMessenger, requires server, login and credentials properties set
+// The messenger is wrapped in a DispatchMessenger when receive workers are configured, in a
+// CompressMessenger when compression or decompression of large messages is enabled, in a
+// V1CompatMessenger when consuming or emitting the v1 address scheme, and in a RewriteMessenger
+// when address rewrite rules are configured.
 //
 // config holds the messenger configuration. If no server is given, 'localhost' will be used.
 func NewMessenger(messengerConfig *MessengerConfig) IMessenger {
@@ -17,5 +21,24 @@ func NewMessenger(messengerConfig *MessengerConfig) IMessenger {
 	} else {
 		m = NewDummyMessenger(messengerConfig)
 	}
+	if messengerConfig.ReceiveWorkers > 0 {
+		m = NewDispatchMessenger(m, messengerConfig.ReceiveWorkers, messengerConfig.ReceiveQueueSize)
+	}
+	if messengerConfig.CompressThreshold > 0 || messengerConfig.Decompress {
+		maxDecompressedSize := 0
+		if messengerConfig.Decompress {
+			maxDecompressedSize = messengerConfig.MaxDecompressedSize
+			if maxDecompressedSize <= 0 {
+				maxDecompressedSize = DefaultMaxDecompressedSize
+			}
+		}
+		m = NewCompressMessenger(m, messengerConfig.CompressThreshold, maxDecompressedSize)
+	}
+	if messengerConfig.ConsumeV1 || messengerConfig.EmitV1 {
+		m = NewV1CompatMessenger(m, messengerConfig.ConsumeV1, messengerConfig.EmitV1)
+	}
+	if len(messengerConfig.Rewrite) > 0 {
+		m = NewRewriteMessenger(m, messengerConfig.Rewrite)
+	}
 	return m
 }
@@ -0,0 +1,51 @@
+// Package messaging - reconnect policy with exponential backoff and jitter
+package messaging
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Reconnect policy defaults
+const (
+	DefaultReconnectDelay    = 1   // initial reconnect delay in seconds
+	DefaultReconnectMaxDelay = 120 // maximum reconnect delay in seconds
+	DefaultReconnectJitter   = 0.2 // random variation of the delay as fraction of the delay
+)
+
+// ReconnectDelay returns the delay before the next reconnect attempt using the reconnect policy
+// from the configuration. The delay doubles with each attempt until the maximum delay is reached.
+// A random jitter is added to avoid that all clients reconnect at the same time after a broker restart.
+//  attempt is the number of failed attempts so far, starting at 0
+func ReconnectDelay(config *MessengerConfig, attempt int) time.Duration {
+	initialDelay := time.Duration(config.ReconnectDelay) * time.Second
+	if initialDelay == 0 {
+		initialDelay = DefaultReconnectDelay * time.Second
+	}
+	maxDelay := time.Duration(config.ReconnectMaxDelay) * time.Second
+	if maxDelay == 0 {
+		maxDelay = DefaultReconnectMaxDelay * time.Second
+	}
+	jitter := config.ReconnectJitter
+	if jitter == 0 {
+		jitter = DefaultReconnectJitter
+	} else if jitter < 0 {
+		jitter = 0
+	} else if jitter > 1 {
+		jitter = 1
+	}
+
+	delay := initialDelay
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	// vary the delay between -jitter and +jitter
+	if jitter > 0 {
+		variation := (rand.Float64()*2 - 1) * jitter * float64(delay)
+		delay += time.Duration(variation)
+	}
+	return delay
+}
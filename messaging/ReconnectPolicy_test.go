@@ -0,0 +1,50 @@
+package messaging_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	// defaults with jitter disabled
+	config := &messaging.MessengerConfig{ReconnectJitter: -1}
+	assert.Equal(t, time.Second, messaging.ReconnectDelay(config, 0))
+	assert.Equal(t, 2*time.Second, messaging.ReconnectDelay(config, 1))
+	assert.Equal(t, 8*time.Second, messaging.ReconnectDelay(config, 3))
+	assert.Equal(t, messaging.DefaultReconnectMaxDelay*time.Second, messaging.ReconnectDelay(config, 100))
+
+	config = &messaging.MessengerConfig{ReconnectDelay: 5, ReconnectMaxDelay: 30, ReconnectJitter: -1}
+	assert.Equal(t, 5*time.Second, messaging.ReconnectDelay(config, 0))
+	assert.Equal(t, 20*time.Second, messaging.ReconnectDelay(config, 2))
+	assert.Equal(t, 30*time.Second, messaging.ReconnectDelay(config, 3))
+
+	// jitter stays within bounds
+	config = &messaging.MessengerConfig{ReconnectDelay: 10, ReconnectJitter: 0.5}
+	for i := 0; i < 100; i++ {
+		delay := messaging.ReconnectDelay(config, 0)
+		assert.True(t, delay >= 5*time.Second && delay <= 15*time.Second, "delay %s out of range", delay)
+	}
+}
+
+func TestDummyConnectionHandler(t *testing.T) {
+	var connectCount = 0
+	var disconnectCount = 0
+	var lastConnected = false
+	config := &messaging.MessengerConfig{
+		OnConnect:    func() { connectCount++ },
+		OnDisconnect: func(err error) { disconnectCount++ },
+	}
+	messenger := messaging.NewDummyMessenger(config)
+	messenger.SetConnectionHandler(func(connected bool, err error) {
+		lastConnected = connected
+	})
+	messenger.Connect("", "")
+	assert.Equal(t, 1, connectCount)
+	assert.True(t, lastConnected)
+	messenger.Disconnect()
+	assert.Equal(t, 1, disconnectCount)
+	assert.False(t, lastConnected)
+}
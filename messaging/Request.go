@@ -0,0 +1,165 @@
+// Package messaging with request/response exchanges over the message bus
+package messaging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// RequestMessage is the envelope of a request sent with IMessenger.Request. The responder publishes
+// the reply on the ReplyTo address with the same correlation ID.
+type RequestMessage struct {
+	CorrelationID string `json:"correlationId"`    // unique ID of the request
+	Payload       string `json:"payload"`          // payload of the request
+	ReplyTo       string `json:"replyTo"`          // address to publish the reply on
+	Sender        string `json:"sender,omitempty"` // address of the requesting publisher of signed requests
+}
+
+// ReplyMessage is the envelope of the reply to a request
+type ReplyMessage struct {
+	CorrelationID string `json:"correlationId"`    // ID of the request this is a reply to
+	Error         string `json:"error,omitempty"`  // reason the request failed, or "" on success
+	Payload       string `json:"payload"`          // payload of the reply
+	Sender        string `json:"sender,omitempty"` // address of the replying publisher of signed replies
+}
+
+// RequestHandler handles a request received with ServeRequests.
+// Returns the payload of the reply, or an error that is passed to the requester.
+type RequestHandler func(address string, payload string) (reply string, err error)
+
+// requestCodec encodes and decodes the request and reply envelopes, as plain JSON or signed by a
+// MessageSigner
+type requestCodec struct {
+	encodeRequest func(address string, request *RequestMessage) (string, error)
+	decodeRequest func(address string, message string, request *RequestMessage) error
+	encodeReply   func(request *RequestMessage, reply *ReplyMessage) (string, error)
+	decodeReply   func(address string, message string, reply *ReplyMessage) error
+}
+
+// jsonCodec encodes the request and reply envelopes as plain JSON
+var jsonCodec = requestCodec{
+	encodeRequest: func(address string, request *RequestMessage) (string, error) {
+		message, err := json.Marshal(request)
+		return string(message), err
+	},
+	decodeRequest: func(address string, message string, request *RequestMessage) error {
+		return json.Unmarshal([]byte(message), request)
+	},
+	encodeReply: func(request *RequestMessage, reply *ReplyMessage) (string, error) {
+		message, err := json.Marshal(reply)
+		return string(message), err
+	},
+	decodeReply: func(address string, message string, reply *ReplyMessage) error {
+		return json.Unmarshal([]byte(message), reply)
+	},
+}
+
+// MakeReplyAddress returns the address to reply to a request on: {address}/$reply/{correlationID}
+func MakeReplyAddress(address string, correlationID string) string {
+	return address + "/" + types.MessageTypeReply + "/" + correlationID
+}
+
+// SendRequest publishes a request and waits for the reply. The reply is received on a reply address
+// that is unique to the request. This implements IMessenger.Request. Messengers that wrap another
+// messenger pass themselves, so the request and reply pass through their Publish and Subscribe.
+//  address the responder serves requests on, see ServeRequests
+//  payload of the request
+//  timeout to wait for the reply
+// Returns the payload of the reply, or an error if the request can't be published, the responder
+// returns an error, or no reply is received before the timeout.
+func SendRequest(messenger IMessenger, address string, payload string, timeout time.Duration) (string, error) {
+	return sendRequest(messenger, address, payload, timeout, jsonCodec)
+}
+
+// sendRequest publishes a request and waits for the reply using the codec for the envelopes
+func sendRequest(messenger IMessenger, address string, payload string, timeout time.Duration,
+	codec requestCodec) (string, error) {
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	correlationID := hex.EncodeToString(id)
+	replyTo := MakeReplyAddress(address, correlationID)
+	replyChannel := make(chan *ReplyMessage, 1)
+	onReply := func(replyAddress string, message string) error {
+		reply := &ReplyMessage{}
+		err := codec.decodeReply(address, message, reply)
+		if err != nil || reply.CorrelationID != correlationID {
+			return fmt.Errorf("SendRequest: Invalid reply on %s", replyAddress)
+		}
+		select {
+		case replyChannel <- reply:
+		default:
+			// only the first reply is used
+		}
+		return nil
+	}
+	// subscribe before publishing so the reply isn't missed
+	messenger.Subscribe(replyTo, onReply)
+	defer messenger.Unsubscribe(replyTo, onReply)
+
+	request, err := codec.encodeRequest(address,
+		&RequestMessage{CorrelationID: correlationID, Payload: payload, ReplyTo: replyTo})
+	if err == nil {
+		err = messenger.Publish(address, false, request)
+	}
+	if err != nil {
+		return "", fmt.Errorf("SendRequest: Unable to publish request to %s: %s", address, err)
+	}
+	select {
+	case reply := <-replyChannel:
+		if reply.Error != "" {
+			return reply.Payload, fmt.Errorf("SendRequest: Request to %s failed: %s", address, reply.Error)
+		}
+		return reply.Payload, nil
+	case <-time.After(timeout):
+		return "", fmt.Errorf("SendRequest: No reply received from %s within %s", address, timeout)
+	}
+}
+
+// ServeRequests subscribes to requests on an address and publishes the result of the handler as
+// the reply. Requests whose reply address isn't the reply address of the request are ignored, so
+// a requester can't have replies published on other addresses.
+// Use Unsubscribe with the address and a nil handler to stop serving requests.
+//  address to receive requests on, eg domain/publisherID/nodeID/$query
+//  handler returns the payload of the reply to a request
+func ServeRequests(messenger IMessenger, address string, handler RequestHandler) {
+	serveRequests(messenger.Subscribe, messenger.Publish, address, handler, jsonCodec)
+}
+
+// serveRequests subscribes to requests on an address using the codec for the envelopes
+func serveRequests(subscribe func(address string, onMessage func(address string, message string) error),
+	publish func(address string, retained bool, message string) error,
+	address string, handler RequestHandler, codec requestCodec) {
+
+	subscribe(address, func(requestAddress string, message string) error {
+		request := RequestMessage{}
+		err := codec.decodeRequest(requestAddress, message, &request)
+		if err != nil {
+			return fmt.Errorf("ServeRequests: Invalid request on %s: %s", requestAddress, err)
+		} else if request.CorrelationID == "" {
+			return fmt.Errorf("ServeRequests: Invalid request on %s", requestAddress)
+		} else if request.ReplyTo != MakeReplyAddress(requestAddress, request.CorrelationID) {
+			// replies are only published on the reply address of the request
+			return fmt.Errorf("ServeRequests: Request on %s has invalid reply address %s", requestAddress, request.ReplyTo)
+		}
+		reply := ReplyMessage{CorrelationID: request.CorrelationID}
+		reply.Payload, err = handler(requestAddress, request.Payload)
+		if err != nil {
+			reply.Error = err.Error()
+		}
+		replyMessage, err := codec.encodeReply(&request, &reply)
+		if err == nil {
+			err = publish(request.ReplyTo, false, replyMessage)
+		}
+		if err != nil {
+			logrus.Warningf("ServeRequests: Unable to publish reply to %s: %s", request.ReplyTo, err)
+		}
+		return err
+	})
+}
@@ -0,0 +1,122 @@
+package messaging_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const requestAddress = "test/publisher1/node1/$query"
+
+func TestRequest(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(nil)
+	messaging.ServeRequests(messenger, requestAddress, func(address string, payload string) (string, error) {
+		if payload == "fail" {
+			return "", errors.New("query failed")
+		}
+		return strings.ToUpper(payload), nil
+	})
+
+	reply, err := messenger.Request(requestAddress, "hello", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO", reply)
+
+	// errors of the responder are returned to the requester
+	_, err = messenger.Request(requestAddress, "fail", time.Second)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "query failed")
+
+	// the reply subscription is removed after the reply
+	publications := messenger.NrPublications()
+	messenger.Publish(messaging.MakeReplyAddress(requestAddress, "old"), false, "{}")
+	assert.Equal(t, publications+1, messenger.NrPublications())
+
+	// without responder the request times out
+	_, err = messenger.Request("test/publisher2/node1/$query", "hello", 10*time.Millisecond)
+	assert.Error(t, err)
+
+	// requests that reply on another address are not served
+	replyCount := 0
+	messenger.Subscribe("test/publisher2/#", func(address string, message string) error {
+		replyCount++
+		return nil
+	})
+	request, _ := json.Marshal(&messaging.RequestMessage{
+		CorrelationID: "1", Payload: "hello", ReplyTo: "test/publisher2/node1/$setInput"})
+	messenger.Publish(requestAddress, false, string(request))
+	messenger.Publish(requestAddress, false, "not a request")
+	assert.Equal(t, 0, replyCount)
+
+	// requests and replies pass through wrapping messengers
+	compressor := messaging.NewCompressMessenger(messenger, 10, messaging.DefaultMaxDecompressedSize)
+	messaging.ServeRequests(compressor, "test/publisher1/node2/$query", func(address string, payload string) (string, error) {
+		return strings.Repeat(payload, 100), nil
+	})
+	reply, err = compressor.Request("test/publisher1/node2/$query", "long", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("long", 100), reply)
+}
+
+func TestSignedRequest(t *testing.T) {
+	const requestAddress4 = "test/publisher4/node1/$query" // publisher without known key
+	messenger := messaging.NewDummyMessenger(nil)
+	keys := map[string]*ecdsa.PrivateKey{
+		"test/publisher1": messaging.CreateAsymKeys(),
+		"test/publisher2": messaging.CreateAsymKeys(),
+		"test/publisher3": messaging.CreateAsymKeys(),
+	}
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		segments := strings.Split(address, "/")
+		if key := keys[segments[0]+"/"+segments[1]]; key != nil {
+			return &key.PublicKey
+		}
+		return nil
+	}
+	responder := messaging.NewMessageSigner(messenger, keys["test/publisher1"], getPubKey)
+	requester := messaging.NewMessageSigner(messenger, keys["test/publisher2"], getPubKey)
+	responder.ServeRequests(requestAddress, "test/publisher1/$identity", func(address string, payload string) (string, error) {
+		return strings.ToUpper(payload), nil
+	})
+	reply, err := requester.Request(requestAddress, "test/publisher2/$identity", "hello", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO", reply)
+
+	// the request is encrypted for the responder
+	request := messenger.FindLastPublication(requestAddress)
+	assert.NotContains(t, request, "correlationId")
+	_, isEncrypted, err := messaging.DecryptMessage(request, keys["test/publisher1"])
+	assert.NoError(t, err)
+	assert.True(t, isEncrypted)
+
+	// unsigned requests are not served
+	_, err = messaging.SendRequest(messenger, requestAddress, "hello", 10*time.Millisecond)
+	assert.Error(t, err)
+
+	// replies from another publisher than the publisher of the address are rejected
+	forger := messaging.NewMessageSigner(messenger, keys["test/publisher3"], getPubKey)
+	forger.ServeRequests(requestAddress4, "test/publisher3/$identity", func(address string, payload string) (string, error) {
+		return "forged", nil
+	})
+	forgedReplies := 0
+	messenger.Subscribe(requestAddress4+"/$reply/+", func(address string, message string) error {
+		forgedReplies++
+		return nil
+	})
+	_, err = requester.Request(requestAddress4, "test/publisher2/$identity", "hello", 10*time.Millisecond)
+	assert.Error(t, err)
+	assert.Equal(t, 1, forgedReplies)
+
+	// in strict mode requests must be from a trusted sender
+	responder.SetTrustedSenderCheck(func(sender string) bool {
+		return false
+	})
+	_, err = requester.Request(requestAddress, "test/publisher2/$identity", "hello", 10*time.Millisecond)
+	assert.Error(t, err)
+}
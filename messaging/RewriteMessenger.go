@@ -0,0 +1,199 @@
+// Package messaging - Rewriting of addresses for migration and renames
+package messaging
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Directions of address rewrite rules
+const (
+	RewriteBoth     = ""    // rewrite publications and subscriptions
+	RewriteInbound  = "in"  // rewrite subscriptions only
+	RewriteOutbound = "out" // rewrite publications only
+)
+
+// RewriteRule rewrites addresses that match a regular expression, eg to rename a domain or publisher
+//  match: ^olddomain/(.*)$  replace: newdomain/$1
+type RewriteRule struct {
+	Match     string `yaml:"match"`               // regular expression of addresses to rewrite
+	Replace   string `yaml:"replace"`             // replacement address. $1, $2 refer to submatches
+	Direction string `yaml:"direction,omitempty"` // RewriteInbound, RewriteOutbound or RewriteBoth. Default is both
+	Exclusive bool   `yaml:"exclusive,omitempty"` // only use the rewritten address. Default also uses the original address
+}
+
+// compiledRewriteRule is a rule with its compiled expression
+type compiledRewriteRule struct {
+	RewriteRule
+	expression *regexp.Regexp
+}
+
+// rewriteSubscription maps a subscription to its subscription on the rewritten address
+type rewriteSubscription struct {
+	address          string
+	handler          func(address string, message string) error
+	rewrittenAddress string
+	rewriteHandler   func(address string, message string) error
+}
+
+// RewriteMessenger rewrites addresses of publications and subscriptions so domain renames or
+// publisher ID changes can be rolled out gradually without breaking existing consumers.
+//
+// Outbound rules publish on the rewritten address and, unless exclusive, also on the original
+// address. Inbound rules subscribe to the rewritten address and, unless exclusive, also to the
+// original address. Messages received on a rewritten subscription are delivered with the address
+// of the original subscription, with its wildcards filled in from the received address.
+// Message payloads are passed as-is, as signed messages cannot be changed.
+type RewriteMessenger struct {
+	messenger     IMessenger            // messenger of the message bus
+	rules         []compiledRewriteRule // rewrite rules, the first matching rule applies
+	subscriptions []rewriteSubscription // subscriptions on rewritten addresses
+	updateMutex   *sync.Mutex           // mutex for concurrent subscriptions
+}
+
+// Connect the messenger
+func (rewriter *RewriteMessenger) Connect(lastWillAddress string, lastWillValue string) error {
+	return rewriter.messenger.Connect(lastWillAddress, lastWillValue)
+}
+
+// ConnectionState returns the state of the connection of the wrapped messenger
+func (rewriter *RewriteMessenger) ConnectionState() ConnectionState {
+	return rewriter.messenger.ConnectionState()
+}
+
+// Disconnect the messenger
+func (rewriter *RewriteMessenger) Disconnect() {
+	rewriter.messenger.Disconnect()
+}
+
+// Publish a message on the address rewritten by the outbound rules
+func (rewriter *RewriteMessenger) Publish(address string, retained bool, message string) error {
+	rewritten, exclusive := rewriter.Rewrite(address, RewriteOutbound)
+	if rewritten == address {
+		return rewriter.messenger.Publish(address, retained, message)
+	}
+	err := rewriter.messenger.Publish(rewritten, retained, message)
+	if err == nil && !exclusive {
+		err = rewriter.messenger.Publish(address, retained, message)
+	}
+	return err
+}
+
+// Rewrite returns the address rewritten by the first matching rule of the given direction
+// This returns the address as-is if no rule matches.
+//  direction is RewriteInbound or RewriteOutbound
+func (rewriter *RewriteMessenger) Rewrite(address string, direction string) (rewritten string, exclusive bool) {
+	for _, rule := range rewriter.rules {
+		if rule.Direction != RewriteBoth && rule.Direction != direction {
+			continue
+		}
+		if rule.expression.MatchString(address) {
+			return rule.expression.ReplaceAllString(address, rule.Replace), rule.Exclusive
+		}
+	}
+	return address, false
+}
+
+// Request publishes a request and waits for the reply. The rewrite rules apply to both.
+func (rewriter *RewriteMessenger) Request(address string, payload string, timeout time.Duration) (string, error) {
+	return SendRequest(rewriter, address, payload, timeout)
+}
+
+// SetConnectionHandler sets the handler of connection changes
+func (rewriter *RewriteMessenger) SetConnectionHandler(handler func(connected bool, err error)) {
+	rewriter.messenger.SetConnectionHandler(handler)
+}
+
+// Subscribe to the address rewritten by the inbound rules
+func (rewriter *RewriteMessenger) Subscribe(address string, onMessage func(address string, message string) error) {
+	rewritten, exclusive := rewriter.Rewrite(address, RewriteInbound)
+	if rewritten == address {
+		rewriter.messenger.Subscribe(address, onMessage)
+		return
+	}
+	subscription := rewriteSubscription{
+		address:          address,
+		handler:          onMessage,
+		rewrittenAddress: rewritten,
+		rewriteHandler: func(receivedAddress string, message string) error {
+			localAddress := fillWildcards(address, rewritten, receivedAddress)
+			logrus.Debugf("RewriteMessenger.Subscribe: Received message on %s as %s", receivedAddress, localAddress)
+			return onMessage(localAddress, message)
+		},
+	}
+	rewriter.updateMutex.Lock()
+	rewriter.subscriptions = append(rewriter.subscriptions, subscription)
+	rewriter.updateMutex.Unlock()
+	rewriter.messenger.Subscribe(rewritten, subscription.rewriteHandler)
+	if !exclusive {
+		rewriter.messenger.Subscribe(address, onMessage)
+	}
+}
+
+// Unsubscribe from an address and its rewritten address
+// If onMessage is nil then all subscriptions with the address are removed
+func (rewriter *RewriteMessenger) Unsubscribe(address string, onMessage func(address string, message string) error) {
+	rewriter.messenger.Unsubscribe(address, onMessage)
+	rewriter.updateMutex.Lock()
+	remaining := make([]rewriteSubscription, 0, len(rewriter.subscriptions))
+	removed := make([]rewriteSubscription, 0)
+	for _, sub := range rewriter.subscriptions {
+		isMatch := sub.address == address && (onMessage == nil || isSameHandler(sub.handler, onMessage))
+		if isMatch && (onMessage == nil || len(removed) == 0) {
+			removed = append(removed, sub)
+		} else {
+			remaining = append(remaining, sub)
+		}
+	}
+	rewriter.subscriptions = remaining
+	rewriter.updateMutex.Unlock()
+	for _, sub := range removed {
+		rewriter.messenger.Unsubscribe(sub.rewrittenAddress, sub.rewriteHandler)
+	}
+}
+
+// fillWildcards returns the subscription address with its wildcards replaced by the segments of the
+// received address at the same position in the rewritten subscription. If the rewritten subscription
+// has a different number of segments then the received address is returned.
+func fillWildcards(subscription string, rewrittenSubscription string, receivedAddress string) string {
+	subSegments := strings.Split(subscription, "/")
+	rewrittenSegments := strings.Split(rewrittenSubscription, "/")
+	receivedSegments := strings.Split(receivedAddress, "/")
+	if len(subSegments) != len(rewrittenSegments) {
+		return receivedAddress
+	}
+	for index, segment := range subSegments {
+		if segment == "#" && index < len(receivedSegments) {
+			return strings.Join(append(subSegments[:index], receivedSegments[index:]...), "/")
+		} else if segment == "+" && index < len(receivedSegments) {
+			subSegments[index] = receivedSegments[index]
+		}
+	}
+	return strings.Join(subSegments, "/")
+}
+
+// NewRewriteMessenger wraps a messenger with address rewrite rules
+// Rules with an invalid expression are ignored.
+//  messenger is the messenger of the message bus
+//  rules are the rewrite rules, the first matching rule applies
+func NewRewriteMessenger(messenger IMessenger, rules []RewriteRule) *RewriteMessenger {
+	rewriter := &RewriteMessenger{
+		messenger:     messenger,
+		rules:         make([]compiledRewriteRule, 0),
+		subscriptions: make([]rewriteSubscription, 0),
+		updateMutex:   &sync.Mutex{},
+	}
+	for _, rule := range rules {
+		expression, err := regexp.Compile(rule.Match)
+		if err != nil {
+			logrus.Errorf("NewRewriteMessenger: Ignored rule with invalid expression '%s': %s", rule.Match, err)
+			continue
+		}
+		rewriter.rules = append(rewriter.rules, compiledRewriteRule{RewriteRule: rule, expression: expression})
+	}
+	return rewriter
+}
@@ -0,0 +1,67 @@
+package messaging_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteOutbound(t *testing.T) {
+	dummy := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	rewriter := messaging.NewRewriteMessenger(dummy, []messaging.RewriteRule{
+		{Match: "^newdomain/(.*)$", Replace: "olddomain/$1", Direction: messaging.RewriteOutbound},
+		{Match: "^domain1/pub1/(.*)$", Replace: "domain1/pub2/$1", Exclusive: true},
+		{Match: "(invalid", Replace: ""},
+	})
+	// the original and rewritten address are published
+	rewriter.Publish("newdomain/pub1/node1/$node", true, "node1")
+	assert.Equal(t, "node1", dummy.FindLastPublication("newdomain/pub1/node1/$node"))
+	assert.Equal(t, "node1", dummy.FindLastPublication("olddomain/pub1/node1/$node"))
+
+	// exclusive rules only publish the rewritten address
+	rewriter.Publish("domain1/pub1/node1/$node", true, "node2")
+	assert.Equal(t, "node2", dummy.FindLastPublication("domain1/pub2/node1/$node"))
+	assert.Empty(t, dummy.FindLastPublication("domain1/pub1/node1/$node"))
+
+	// other addresses are not rewritten
+	addr, _ := rewriter.Rewrite("domain2/pub1/node1/$node", messaging.RewriteOutbound)
+	assert.Equal(t, "domain2/pub1/node1/$node", addr)
+	// outbound rules don't apply to subscriptions
+	addr, _ = rewriter.Rewrite("newdomain/pub1/node1/$node", messaging.RewriteInbound)
+	assert.Equal(t, "newdomain/pub1/node1/$node", addr)
+}
+
+func TestRewriteInbound(t *testing.T) {
+	dummy := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	rewriter := messaging.NewRewriteMessenger(dummy, []messaging.RewriteRule{
+		{Match: "^newdomain/(.*)$", Replace: "olddomain/$1", Direction: messaging.RewriteInbound},
+	})
+	rxAddresses := make([]string, 0)
+	handler := func(address string, message string) error {
+		rxAddresses = append(rxAddresses, address)
+		return nil
+	}
+	rewriter.Subscribe("newdomain/+/+/$node", handler)
+
+	// messages of not yet migrated publishers are delivered with the new address
+	dummy.Publish("olddomain/pub1/node1/$node", false, "node1")
+	require.Equal(t, 1, len(rxAddresses))
+	assert.Equal(t, "newdomain/pub1/node1/$node", rxAddresses[0])
+	// messages of migrated publishers are still received
+	dummy.Publish("newdomain/pub2/node1/$node", false, "node1")
+	require.Equal(t, 2, len(rxAddresses))
+	assert.Equal(t, "newdomain/pub2/node1/$node", rxAddresses[1])
+
+	rewriter.Unsubscribe("newdomain/+/+/$node", handler)
+	dummy.Publish("olddomain/pub1/node1/$node", false, "node1")
+	dummy.Publish("newdomain/pub2/node1/$node", false, "node1")
+	assert.Equal(t, 2, len(rxAddresses))
+
+	// configured rules create a rewriting messenger
+	messenger := messaging.NewMessenger(&messaging.MessengerConfig{
+		Rewrite: []messaging.RewriteRule{{Match: "^a/(.*)$", Replace: "b/$1"}}})
+	_, isRewriter := messenger.(*messaging.RewriteMessenger)
+	assert.True(t, isRewriter)
+}
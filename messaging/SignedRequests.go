@@ -0,0 +1,95 @@
+// Package messaging with signed request/response exchanges
+package messaging
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Request signs a request and publishes it on the address, then waits for the signed reply. See
+// SendRequest. The request is encrypted for the publisher of the address when its key is known.
+// The reply must be signed by the publisher of the address. In strict mode, see
+// SetTrustedSenderCheck, the request and reply must also be encrypted.
+//  address the other publisher serves requests on
+//  sender is the address of the requesting publisher, eg its identity address
+//  payload of the request
+//  timeout to wait for the reply
+func (signer *MessageSigner) Request(address string, sender string, payload string, timeout time.Duration) (string, error) {
+	return sendRequest(signer.messenger, address, payload, timeout, signer.makeRequestCodec(sender))
+}
+
+// ServeRequests serves signed requests on an address and publishes the signed reply. See
+// ServeRequests. Requests are decoded like commands, so in strict mode they must be encrypted and
+// signed by a trusted sender. The reply is encrypted for the requester when its key is known.
+// Use Unsubscribe with the address and a nil handler to stop serving requests.
+//  address to receive requests on, eg domain/publisherID/nodeID/$query
+//  sender is the address of the replying publisher, eg its identity address
+//  handler returns the payload of the reply to a request
+func (signer *MessageSigner) ServeRequests(address string, sender string, handler RequestHandler) {
+	serveRequests(signer.Subscribe, signer.messenger.Publish, address, handler, signer.makeRequestCodec(sender))
+}
+
+// encodeEnvelope signs a request or reply envelope and encrypts it for the publisher of the
+// address if its key is known. In strict mode the envelope must be encrypted.
+func (signer *MessageSigner) encodeEnvelope(address string, envelope interface{}) (string, error) {
+	message, err := signer.encodeObject(envelope, signer.SignMessages())
+	if err != nil {
+		return "", err
+	}
+	var publicKey *ecdsa.PublicKey
+	if signer.GetPublicKey != nil && address != "" {
+		publicKey = signer.GetPublicKey(address)
+	}
+	if publicKey != nil {
+		return EncryptMessage(message, publicKey)
+	} else if signer.isStrict() {
+		return "", fmt.Errorf("No public key found to encrypt the message to '%s'. Strict mode requires encryption", address)
+	}
+	return message, nil
+}
+
+// makeRequestCodec returns the codec that signs and verifies the request and reply envelopes
+//  sender is the address of this publisher, eg its identity address
+func (signer *MessageSigner) makeRequestCodec(sender string) requestCodec {
+	return requestCodec{
+		encodeRequest: func(address string, request *RequestMessage) (string, error) {
+			request.Sender = sender
+			return signer.encodeEnvelope(address, request)
+		},
+		decodeRequest: func(address string, message string, request *RequestMessage) error {
+			_, isSigned, err := signer.DecodeCommand(message, request)
+			if err == nil && !isSigned && signer.SignMessages() {
+				err = fmt.Errorf("Request from '%s' isn't signed", request.Sender)
+			}
+			return err
+		},
+		encodeReply: func(request *RequestMessage, reply *ReplyMessage) (string, error) {
+			reply.Sender = sender
+			return signer.encodeEnvelope(request.Sender, reply)
+		},
+		decodeReply: func(address string, message string, reply *ReplyMessage) error {
+			isEncrypted, isSigned, err := signer.decodeMessage(message, reply)
+			if err != nil {
+				return err
+			} else if !isSigned && signer.SignMessages() {
+				return fmt.Errorf("Reply to request on '%s' isn't signed", address)
+			} else if !isEncrypted && signer.isStrict() {
+				return fmt.Errorf("Reply to request on '%s' isn't encrypted. Strict mode requires encryption", address)
+			} else if isSigned && !isSamePublisher(address, reply.Sender) {
+				// only the publisher of the address can reply
+				return fmt.Errorf("Reply to request on '%s' is from another publisher '%s'", address, reply.Sender)
+			}
+			return nil
+		},
+	}
+}
+
+// isSamePublisher returns true if both addresses start with the same domain/publisherID
+func isSamePublisher(address1 string, address2 string) bool {
+	segments1 := strings.SplitN(address1, "/", 3)
+	segments2 := strings.SplitN(address2, "/", 3)
+	return len(segments1) >= 2 && len(segments2) >= 2 &&
+		segments1[0] == segments2[0] && segments1[1] == segments2[1]
+}
@@ -0,0 +1,165 @@
+// Package messaging with the policy of message types that are published without signature
+package messaging
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// UnsignableMessageTypes are the message types that can be published without signature, eg for
+// high-frequency output values. Discovery, identity and command messages are always signed.
+var UnsignableMessageTypes = []string{
+	types.MessageTypeEvent, types.MessageTypeForecast, types.MessageTypeHistory,
+	types.MessageTypeLatest, types.MessageTypeRaw,
+}
+
+// GetUnsignedMessageTypes returns the message types that are published without signature
+func (signer *MessageSigner) GetUnsignedMessageTypes() []string {
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	messageTypes := make([]string, 0, len(signer.unsignedTypes))
+	for _, messageType := range UnsignableMessageTypes {
+		if signer.unsignedTypes[messageType] {
+			messageTypes = append(messageTypes, messageType)
+		}
+	}
+	return messageTypes
+}
+
+// IsUnsignedAllowed returns true if the publisher of the address has announced in its signing
+// policy that it publishes messages of the address message type without signature
+//  address is the address of the received message, eg domain/publisherId/nodeId/type/instance/$raw
+func (signer *MessageSigner) IsUnsignedAllowed(address string) bool {
+	segments := strings.Split(address, "/")
+	if len(segments) < 3 {
+		return false
+	}
+	publisherAddress := segments[0] + "/" + segments[1]
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	return signer.policies[publisherAddress][segments[len(segments)-1]]
+}
+
+// SetPublisherSigningPolicy records the signing policy received from a publisher. Only the message
+// types in UnsignableMessageTypes are accepted without signature.
+//  publisherAddress is the address of the publisher: domain/publisherId
+//  unsignedTypes are the message types the publisher publishes without signature, nil to remove
+func (signer *MessageSigner) SetPublisherSigningPolicy(publisherAddress string, unsignedTypes []string) {
+	policy := make(map[string]bool)
+	for _, messageType := range unsignedTypes {
+		if isUnsignable(messageType) {
+			policy[messageType] = true
+		}
+	}
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	if len(policy) == 0 {
+		delete(signer.policies, publisherAddress)
+	} else {
+		signer.policies[publisherAddress] = policy
+	}
+}
+
+// SetUnsignedMessageTypes sets the message types that are published without signature, eg $raw
+// for high-frequency output values. All other messages are signed when signing is enabled.
+// Consumers learn this policy from the signing policy message of the publisher.
+// Returns an error if a message type must always be signed, see UnsignableMessageTypes.
+func (signer *MessageSigner) SetUnsignedMessageTypes(messageTypes []string) error {
+	unsignedTypes := make(map[string]bool)
+	for _, messageType := range messageTypes {
+		if !isUnsignable(messageType) {
+			return fmt.Errorf("SetUnsignedMessageTypes: Message type '%s' must be signed", messageType)
+		}
+		unsignedTypes[messageType] = true
+	}
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	signer.unsignedTypes = unsignedTypes
+	return nil
+}
+
+// VerifyPublication parses and verifies the signature of a published message like
+// VerifySignedMessage. In strict mode, see SetTrustedSenderCheck, unsigned messages are only
+// accepted if the signing policy of their publisher allows them for the message type.
+//  address is the address the message is received on
+func (signer *MessageSigner) VerifyPublication(address string, rawMessage string, object interface{}) (isSigned bool, err error) {
+	isSigned, err = signer.verifySignedMessage(rawMessage, object)
+	if err == nil && !isSigned && signer.isStrict() && !signer.IsUnsignedAllowed(address) {
+		err = fmt.Errorf("VerifyPublication: Message on '%s' isn't signed while its publisher's signing policy requires it", address)
+		signer.reportError(err)
+	}
+	if err == nil {
+		err = signer.ApplyReceiveHooks(address, object)
+	}
+	return isSigned, err
+}
+
+// VerifyRawPublication verifies the signature of a raw value message, which has a plain text
+// payload, and returns the payload. The message is signed by the publisher of the address.
+// In strict mode unsigned raw values are only accepted if the signing policy of their publisher
+// allows them.
+func (signer *MessageSigner) VerifyRawPublication(address string, rawMessage string) (payload string, err error) {
+	payload, err = signer.verifyRawPublication(address, rawMessage)
+	if err == nil {
+		err = signer.ApplyReceiveHooks(address, payload)
+	}
+	return payload, err
+}
+
+// verifyRawPublication verifies the signature of a raw value message without receive hooks
+func (signer *MessageSigner) verifyRawPublication(address string, rawMessage string) (payload string, err error) {
+	jwsSignature, err := jose.ParseSigned(rawMessage)
+	if err != nil {
+		// the message is not signed
+		if signer.isStrict() && !signer.IsUnsignedAllowed(address) {
+			err = fmt.Errorf("VerifyRawPublication: Message on '%s' isn't signed while its publisher's signing policy requires it", address)
+			signer.reportError(err)
+			return "", err
+		}
+		return rawMessage, nil
+	}
+	if signer.GetPublicKey == nil {
+		return string(jwsSignature.UnsafePayloadWithoutVerification()), nil
+	}
+	publicKey := signer.GetPublicKey(address)
+	if publicKey == nil {
+		err = fmt.Errorf("VerifyRawPublication: No public key available for the publisher of '%s'", address)
+	} else {
+		var payloadB []byte
+		payloadB, err = jwsSignature.Verify(publicKey)
+		payload = string(payloadB)
+	}
+	signer.reportError(err)
+	return payload, err
+}
+
+// isStrict returns true if strict mode is enabled, see SetTrustedSenderCheck
+func (signer *MessageSigner) isStrict() bool {
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	return signer.isTrusted != nil
+}
+
+// isSignedPublication returns true if a message published on the address must be signed
+func (signer *MessageSigner) isSignedPublication(address string) bool {
+	if !signer.signMessages {
+		return false
+	}
+	messageType := address[strings.LastIndex(address, "/")+1:]
+	signer.updateMutex.Lock()
+	defer signer.updateMutex.Unlock()
+	return !signer.unsignedTypes[messageType]
+}
+
+// isUnsignable returns true if the message type can be published without signature
+func isUnsignable(messageType string) bool {
+	for _, unsignable := range UnsignableMessageTypes {
+		if messageType == unsignable {
+			return true
+		}
+	}
+	return false
+}
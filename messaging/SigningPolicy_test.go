@@ -0,0 +1,69 @@
+package messaging_test
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningPolicy(t *testing.T) {
+	const rawAddr = "test/pub1/node1/temperature/0/$raw"
+	const latestAddr = "test/pub1/node1/temperature/0/$latest"
+	const nodeAddr = "test/pub1/node1/$node"
+	privKey := messaging.CreateAsymKeys()
+	messenger := messaging.NewDummyMessenger(nil)
+	getPublicKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	signer := messaging.NewMessageSigner(messenger, privKey, getPublicKey)
+	consumer := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), getPublicKey)
+	consumer.SetTrustedSenderCheck(func(sender string) bool { return true })
+
+	// raw values are published unsigned, discovery remains signed
+	err := signer.SetUnsignedMessageTypes([]string{types.MessageTypeRaw})
+	require.NoError(t, err)
+	assert.Equal(t, []string{types.MessageTypeRaw}, signer.GetUnsignedMessageTypes())
+	signer.PublishSigned(rawAddr, false, "20.5")
+	assert.Equal(t, "20.5", messenger.FindLastPublication(rawAddr))
+	signer.PublishObject(nodeAddr, false, &types.NodeDiscoveryMessage{Address: nodeAddr}, nil)
+	assert.NotContains(t, messenger.FindLastPublication(nodeAddr), "{")
+
+	// a strict consumer rejects unsigned values until the policy of the publisher allows them
+	_, err = consumer.VerifyRawPublication(rawAddr, "20.5")
+	assert.Error(t, err)
+	consumer.SetPublisherSigningPolicy("test/pub1", []string{types.MessageTypeRaw, types.MessageTypeNodeDiscovery})
+	assert.True(t, consumer.IsUnsignedAllowed(rawAddr))
+	assert.False(t, consumer.IsUnsignedAllowed(nodeAddr))
+	payload, err := consumer.VerifyRawPublication(rawAddr, "20.5")
+	assert.NoError(t, err)
+	assert.Equal(t, "20.5", payload)
+	var latest types.OutputLatestMessage
+	_, err = consumer.VerifyPublication(latestAddr, `{"address":"`+latestAddr+`","value":"20.5"}`, &latest)
+	assert.Error(t, err)
+
+	// signed values are verified, also when the policy allows them unsigned
+	signer.SetUnsignedMessageTypes(nil)
+	signer.PublishSigned(rawAddr, false, "21.5")
+	payload, err = consumer.VerifyRawPublication(rawAddr, messenger.FindLastPublication(rawAddr))
+	assert.NoError(t, err)
+	assert.Equal(t, "21.5", payload)
+	otherKey := messaging.CreateAsymKeys()
+	otherSigner := messaging.NewMessageSigner(messenger, otherKey, getPublicKey)
+	otherSigner.PublishSigned(rawAddr, false, "22.5")
+	_, err = consumer.VerifyRawPublication(rawAddr, messenger.FindLastPublication(rawAddr))
+	assert.Error(t, err)
+
+	// removing the policy rejects unsigned values again
+	consumer.SetPublisherSigningPolicy("test/pub1", nil)
+	assert.False(t, consumer.IsUnsignedAllowed(rawAddr))
+
+	// error case - discovery, identity and commands must be signed
+	err = signer.SetUnsignedMessageTypes([]string{types.MessageTypeRaw, types.MessageTypeNodeDiscovery})
+	assert.Error(t, err)
+	err = signer.SetUnsignedMessageTypes([]string{types.MessageTypeSetInput})
+	assert.Error(t, err)
+}
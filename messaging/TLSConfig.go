@@ -0,0 +1,72 @@
+// Package messaging - TLS configuration of the connection to the message bus broker
+package messaging
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultCACertFile is the CA certificate of the broker that is used if no CA bundle is configured
+const DefaultCACertFile = "/etc/mosquitto/certs/zcas_ca.crt"
+
+// MakeTLSConfig returns the TLS configuration for connecting to the broker using the TLS settings
+// of the messenger configuration.
+//  The broker certificate is verified with the configured CA bundle. Without CA bundle the
+//  DefaultCACertFile is used if it exists, otherwise the system CAs.
+//  A client certificate is presented to brokers that require mutual TLS when the client
+//  certificate and key files are configured.
+// Returns an error if a configured file can't be loaded.
+func MakeTLSConfig(config *MessengerConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		NextProtos:         config.ALPN,
+		ServerName:         config.ServerName,
+	}
+	if config.InsecureSkipVerify {
+		logrus.Warningf("MakeTLSConfig: WARNING: Verification of the broker certificate is disabled. " +
+			"The connection is vulnerable to man-in-the-middle attacks. Do not use insecureSkipVerify in production!")
+	}
+
+	caCertFile := config.CACertFile
+	if caCertFile == "" {
+		if _, err := os.Stat(DefaultCACertFile); err == nil {
+			caCertFile = DefaultCACertFile
+		}
+	}
+	if caCertFile != "" {
+		caPEM, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("MakeTLSConfig: Unable to read CA certificates: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("MakeTLSConfig: No PEM certificates found in CA file %s", caCertFile)
+		}
+	}
+
+	if config.ClientCertFile != "" || config.ClientKeyFile != "" {
+		clientCert, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("MakeTLSConfig: Unable to load client certificate %s with key %s: %s",
+				config.ClientCertFile, config.ClientKeyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	return tlsConfig, nil
+}
+
+// ResolveTLSFiles makes the relative TLS file paths in the configuration relative to the given
+// folder, eg the folder the configuration is loaded from
+func ResolveTLSFiles(config *MessengerConfig, folder string) {
+	for _, filePath := range []*string{&config.CACertFile, &config.ClientCertFile, &config.ClientKeyFile} {
+		if *filePath != "" && !path.IsAbs(*filePath) {
+			*filePath = path.Join(folder, *filePath)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package messaging_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCert writes a self-signed certificate and its key as PEM files in the folder
+func writeTestCert(t *testing.T, folder string) (certFile string, keyFile string) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "broker.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &privKey.PublicKey, privKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(privKey)
+	require.NoError(t, err)
+	certFile = path.Join(folder, "client.crt")
+	keyFile = path.Join(folder, "client.key")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	require.NoError(t, err)
+	return certFile, keyFile
+}
+
+func TestMakeTLSConfig(t *testing.T) {
+	folder, err := ioutil.TempDir("", "tlsconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	certFile, keyFile := writeTestCert(t, folder)
+
+	// mutual TLS with a custom CA, ALPN and SNI
+	config := &messaging.MessengerConfig{
+		CACertFile:     certFile,
+		ClientCertFile: certFile,
+		ClientKeyFile:  keyFile,
+		ALPN:           []string{"x-amzn-mqtt-ca"},
+		ServerName:     "broker.test",
+	}
+	tlsConfig, err := messaging.MakeTLSConfig(config)
+	require.NoError(t, err)
+	assert.NotNil(t, tlsConfig.RootCAs)
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.Equal(t, []string{"x-amzn-mqtt-ca"}, tlsConfig.NextProtos)
+	assert.Equal(t, "broker.test", tlsConfig.ServerName)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+
+	tlsConfig, err = messaging.MakeTLSConfig(&messaging.MessengerConfig{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	assert.True(t, tlsConfig.InsecureSkipVerify)
+	assert.Empty(t, tlsConfig.Certificates)
+
+	// configured files must be valid
+	_, err = messaging.MakeTLSConfig(&messaging.MessengerConfig{CACertFile: path.Join(folder, "missing.crt")})
+	assert.Error(t, err)
+	_, err = messaging.MakeTLSConfig(&messaging.MessengerConfig{CACertFile: keyFile})
+	assert.Error(t, err)
+	_, err = messaging.MakeTLSConfig(&messaging.MessengerConfig{ClientCertFile: certFile})
+	assert.Error(t, err)
+}
+
+func TestResolveTLSFiles(t *testing.T) {
+	config := &messaging.MessengerConfig{
+		CACertFile:     "/etc/ssl/ca.crt",
+		ClientCertFile: "certs/client.crt",
+	}
+	messaging.ResolveTLSFiles(config, "/home/user/.config/iotdomain")
+	assert.Equal(t, "/etc/ssl/ca.crt", config.CACertFile)
+	assert.Equal(t, "/home/user/.config/iotdomain/certs/client.crt", config.ClientCertFile)
+	assert.Equal(t, "", config.ClientKeyFile)
+}
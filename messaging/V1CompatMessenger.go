@@ -0,0 +1,231 @@
+// Package messaging - Compatibility with the iotconnect v1 address scheme
+package messaging
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// V1MessageTypes maps the message types of the iotconnect v1 address scheme that were renamed
+var V1MessageTypes = map[string]string{
+	"$alias": "$setNodeId",
+	"$set":   "$setInput",
+}
+
+// v1Subscription maps a subscription to the v1 subscription that delivers translated messages
+type v1Subscription struct {
+	address   string
+	handler   func(address string, message string) error
+	v1Address string
+	v1Handler func(address string, message string) error
+}
+
+// V1CompatMessenger lets publishers and consumers interoperate with iotconnect v1 publishers and
+// consumers during migration. In the v1 address scheme the message type follows the node,
+// eg zone/publisher/node/$output/type/instance, while the current scheme places it last,
+// eg domain/publisher/node/type/instance/$output.
+//
+// When consuming v1, subscriptions also subscribe to the v1 address. Received v1 messages are
+// delivered with the current address and, if unsigned, with the address in the payload translated.
+// When emitting v1, publications are also published on the v1 address. Signed messages are
+// passed as-is as the signature covers the payload.
+type V1CompatMessenger struct {
+	consumeV1     bool              // subscribe to v1 addresses
+	emitV1        bool              // also publish on v1 addresses
+	emitted       map[string]string // last message emitted by v1 address, to ignore own emissions
+	messenger     IMessenger        // messenger of the message bus
+	subscriptions []v1Subscription  // v1 subscriptions
+	updateMutex   *sync.Mutex       // mutex for concurrent subscriptions and publications
+}
+
+// Connect the messenger
+func (compat *V1CompatMessenger) Connect(lastWillAddress string, lastWillValue string) error {
+	return compat.messenger.Connect(lastWillAddress, lastWillValue)
+}
+
+// ConnectionState returns the state of the connection of the wrapped messenger
+func (compat *V1CompatMessenger) ConnectionState() ConnectionState {
+	return compat.messenger.ConnectionState()
+}
+
+// Disconnect the messenger
+func (compat *V1CompatMessenger) Disconnect() {
+	compat.messenger.Disconnect()
+}
+
+// Publish a message on the address and, when emitting v1, on its v1 address
+func (compat *V1CompatMessenger) Publish(address string, retained bool, message string) error {
+	err := compat.messenger.Publish(address, retained, message)
+	if err != nil || !compat.emitV1 {
+		return err
+	}
+	v1Address := AddressToV1(address)
+	if v1Address == address {
+		return nil
+	}
+	v1Message := translateMessageAddress(message, v1Address)
+	compat.updateMutex.Lock()
+	compat.emitted[v1Address] = v1Message
+	compat.updateMutex.Unlock()
+	return compat.messenger.Publish(v1Address, retained, v1Message)
+}
+
+// Request publishes a request and waits for the reply, see SendRequest
+func (compat *V1CompatMessenger) Request(address string, payload string, timeout time.Duration) (string, error) {
+	return SendRequest(compat, address, payload, timeout)
+}
+
+// SetConnectionHandler sets the handler of connection changes
+func (compat *V1CompatMessenger) SetConnectionHandler(handler func(connected bool, err error)) {
+	compat.messenger.SetConnectionHandler(handler)
+}
+
+// Subscribe to an address and, when consuming v1, to its v1 address
+func (compat *V1CompatMessenger) Subscribe(address string, onMessage func(address string, message string) error) {
+	compat.messenger.Subscribe(address, onMessage)
+	if !compat.consumeV1 {
+		return
+	}
+	v1Address := AddressToV1(address)
+	if v1Address == address {
+		return
+	}
+	subscription := v1Subscription{
+		address:   address,
+		handler:   onMessage,
+		v1Address: v1Address,
+		v1Handler: func(v1Addr string, message string) error {
+			return compat.handleV1Message(v1Addr, message, onMessage)
+		},
+	}
+	compat.updateMutex.Lock()
+	compat.subscriptions = append(compat.subscriptions, subscription)
+	compat.updateMutex.Unlock()
+	compat.messenger.Subscribe(v1Address, subscription.v1Handler)
+}
+
+// Unsubscribe from an address and its v1 address
+// If onMessage is nil then all subscriptions with the address are removed
+func (compat *V1CompatMessenger) Unsubscribe(address string, onMessage func(address string, message string) error) {
+	compat.messenger.Unsubscribe(address, onMessage)
+	compat.updateMutex.Lock()
+	remaining := make([]v1Subscription, 0, len(compat.subscriptions))
+	removed := make([]v1Subscription, 0)
+	for _, sub := range compat.subscriptions {
+		isMatch := sub.address == address && (onMessage == nil || isSameHandler(sub.handler, onMessage))
+		if isMatch && (onMessage == nil || len(removed) == 0) {
+			removed = append(removed, sub)
+		} else {
+			remaining = append(remaining, sub)
+		}
+	}
+	compat.subscriptions = remaining
+	compat.updateMutex.Unlock()
+	for _, sub := range removed {
+		compat.messenger.Unsubscribe(sub.v1Address, sub.v1Handler)
+	}
+}
+
+// handleV1Message passes a message received on a v1 address to the subscriber using the current
+// address scheme. Messages emitted by this messenger are ignored.
+func (compat *V1CompatMessenger) handleV1Message(v1Address string, message string,
+	onMessage func(address string, message string) error) error {
+
+	compat.updateMutex.Lock()
+	emitted, isEmitted := compat.emitted[v1Address]
+	compat.updateMutex.Unlock()
+	if isEmitted && emitted == message {
+		return nil
+	}
+	address := AddressFromV1(v1Address)
+	logrus.Debugf("V1CompatMessenger.handleV1Message: Received v1 message on %s as %s", v1Address, address)
+	return onMessage(address, translateMessageAddress(message, address))
+}
+
+// AddressFromV1 converts an address in the iotconnect v1 scheme to the current scheme
+//  zone/publisher/node/$messageType/type/instance becomes zone/publisher/node/type/instance/$messageType
+// Renamed message types are replaced. Addresses of other forms are returned as-is.
+func AddressFromV1(v1Address string) string {
+	segments := strings.Split(v1Address, "/")
+	if len(segments) < 4 || !strings.HasPrefix(segments[3], "$") {
+		return v1Address
+	}
+	messageType := segments[3]
+	if renamed, found := V1MessageTypes[messageType]; found {
+		messageType = renamed
+	}
+	if len(segments) == 6 {
+		segments = []string{segments[0], segments[1], segments[2], segments[4], segments[5], messageType}
+	} else {
+		segments[3] = messageType
+	}
+	return strings.Join(segments, "/")
+}
+
+// AddressToV1 converts an address in the current scheme to the iotconnect v1 scheme
+//  domain/publisher/node/type/instance/$messageType becomes domain/publisher/node/$messageType/type/instance
+// Renamed message types are replaced. Addresses of other forms are returned as-is.
+func AddressToV1(address string) string {
+	segments := strings.Split(address, "/")
+	var messageType string
+	if len(segments) == 6 && strings.HasPrefix(segments[5], "$") {
+		messageType = segments[5]
+	} else if len(segments) == 4 && strings.HasPrefix(segments[3], "$") {
+		messageType = segments[3]
+	} else {
+		return address
+	}
+	for v1Type, currentType := range V1MessageTypes {
+		if currentType == messageType {
+			messageType = v1Type
+		}
+	}
+	if len(segments) == 6 {
+		segments = []string{segments[0], segments[1], segments[2], messageType, segments[3], segments[4]}
+	} else {
+		segments[3] = messageType
+	}
+	return strings.Join(segments, "/")
+}
+
+// translateMessageAddress replaces the address in an unsigned JSON message. Signed and encrypted
+// messages are returned as-is.
+func translateMessageAddress(message string, address string) string {
+	if !strings.HasPrefix(strings.TrimSpace(message), "{") {
+		return message
+	}
+	var object map[string]interface{}
+	err := json.Unmarshal([]byte(message), &object)
+	if err != nil {
+		return message
+	}
+	if _, hasAddress := object["address"]; !hasAddress {
+		return message
+	}
+	object["address"] = address
+	translated, err := json.Marshal(object)
+	if err != nil {
+		return message
+	}
+	return string(translated)
+}
+
+// NewV1CompatMessenger wraps a messenger for interoperability with the iotconnect v1 address scheme
+//  messenger is the messenger of the message bus
+//  consumeV1 also subscribes to v1 addresses
+//  emitV1 also publishes on v1 addresses
+func NewV1CompatMessenger(messenger IMessenger, consumeV1 bool, emitV1 bool) *V1CompatMessenger {
+	compat := &V1CompatMessenger{
+		consumeV1:     consumeV1,
+		emitV1:        emitV1,
+		emitted:       make(map[string]string),
+		messenger:     messenger,
+		subscriptions: make([]v1Subscription, 0),
+		updateMutex:   &sync.Mutex{},
+	}
+	return compat
+}
@@ -0,0 +1,83 @@
+package messaging_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestV1AddressConversion(t *testing.T) {
+	v1Addr := messaging.AddressToV1("domain1/pub1/node1/temperature/0/$output")
+	assert.Equal(t, "domain1/pub1/node1/$output/temperature/0", v1Addr)
+	assert.Equal(t, "domain1/pub1/node1/temperature/0/$output", messaging.AddressFromV1(v1Addr))
+
+	v1Addr = messaging.AddressToV1("domain1/pub1/node1/switch/0/$setInput")
+	assert.Equal(t, "domain1/pub1/node1/$set/switch/0", v1Addr)
+	assert.Equal(t, "domain1/pub1/node1/switch/0/$setInput", messaging.AddressFromV1(v1Addr))
+
+	assert.Equal(t, "domain1/pub1/node1/$alias", messaging.AddressToV1("domain1/pub1/node1/$setNodeId"))
+	assert.Equal(t, "domain1/pub1/node1/$node", messaging.AddressToV1("domain1/pub1/node1/$node"))
+	assert.Equal(t, "domain1/+/+/$latest/+/+", messaging.AddressToV1("domain1/+/+/+/+/$latest"))
+	assert.Equal(t, "domain1/pub1/$identity", messaging.AddressFromV1("domain1/pub1/$identity"))
+}
+
+func TestV1CompatConsume(t *testing.T) {
+	dummy := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	compat := messaging.NewV1CompatMessenger(dummy, true, false)
+	var rxAddress string
+	var rxMessage string
+	handler := func(address string, message string) error {
+		rxAddress = address
+		rxMessage = message
+		return nil
+	}
+	compat.Subscribe("domain1/+/+/+/+/$latest", handler)
+
+	// a v1 publisher message is received with the current address
+	v1Addr := "domain1/pub1/node1/$latest/temperature/0"
+	dummy.Publish(v1Addr, false, `{"address":"`+v1Addr+`","value":"21"}`)
+	assert.Equal(t, "domain1/pub1/node1/temperature/0/$latest", rxAddress)
+	var latest map[string]string
+	err := json.Unmarshal([]byte(rxMessage), &latest)
+	require.NoError(t, err)
+	assert.Equal(t, rxAddress, latest["address"])
+	assert.Equal(t, "21", latest["value"])
+
+	// current publications are still received
+	compat.Publish("domain1/pub2/node1/humidity/0/$latest", false, "signed")
+	assert.Equal(t, "domain1/pub2/node1/humidity/0/$latest", rxAddress)
+	assert.Equal(t, "signed", rxMessage)
+
+	compat.Unsubscribe("domain1/+/+/+/+/$latest", handler)
+	rxAddress = ""
+	dummy.Publish(v1Addr, false, "{}")
+	assert.Equal(t, "", rxAddress)
+}
+
+func TestV1CompatEmit(t *testing.T) {
+	config := &messaging.MessengerConfig{ConsumeV1: true, EmitV1: true}
+	compat := messaging.NewMessenger(config)
+	_, isCompat := compat.(*messaging.V1CompatMessenger)
+	require.True(t, isCompat)
+	dummy := messaging.NewDummyMessenger(config)
+	compat = messaging.NewV1CompatMessenger(dummy, true, true)
+
+	rxCount := 0
+	compat.Subscribe("domain1/pub1/node1/temperature/0/$output", func(address string, message string) error {
+		rxCount++
+		return nil
+	})
+	addr := "domain1/pub1/node1/temperature/0/$output"
+	compat.Publish(addr, true, `{"address":"`+addr+`"}`)
+	// own v1 emissions are not received twice
+	assert.Equal(t, 1, rxCount)
+
+	v1Message := dummy.FindLastPublication("domain1/pub1/node1/$output/temperature/0")
+	require.NotEmpty(t, v1Message)
+	var output map[string]string
+	json.Unmarshal([]byte(v1Message), &output)
+	assert.Equal(t, "domain1/pub1/node1/$output/temperature/0", output["address"])
+}
@@ -8,6 +8,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"math/big"
+	"sync"
 )
 
 // ECDSASignature ...
@@ -47,16 +48,44 @@ func PrivateKeyToPem(privateKey *ecdsa.PrivateKey) string {
 	return string(pemEncoded)
 }
 
+// maxCachedPublicKeys is the max nr of parsed public keys that are cached. The cache is cleared
+// when it is full.
+const maxCachedPublicKeys = 1000
+
+// publicKeyCache holds the parsed public keys of known senders by their PEM encoding, as parsing
+// is costly compared to a lookup
+var publicKeyCache = struct {
+	sync.RWMutex
+	keys map[string]*ecdsa.PublicKey
+}{keys: make(map[string]*ecdsa.PublicKey)}
+
 // PublicKeyFromPem converts a ascii encoded public key into a ECDSA public key
+// Parsed keys are cached. Returns nil if the encoded pem source isn't a valid ECDSA public key.
 func PublicKeyFromPem(pemEncodedPub string) *ecdsa.PublicKey {
 	if pemEncodedPub == "" {
 		return nil
 	}
+	publicKeyCache.RLock()
+	publicKey := publicKeyCache.keys[pemEncodedPub]
+	publicKeyCache.RUnlock()
+	if publicKey != nil {
+		return publicKey
+	}
 	blockPub, _ := pem.Decode([]byte(pemEncodedPub))
-	x509EncodedPub := blockPub.Bytes
-	genericPublicKey, _ := x509.ParsePKIXPublicKey(x509EncodedPub)
-	publicKey := genericPublicKey.(*ecdsa.PublicKey)
-
+	if blockPub == nil {
+		return nil
+	}
+	genericPublicKey, _ := x509.ParsePKIXPublicKey(blockPub.Bytes)
+	publicKey, _ = genericPublicKey.(*ecdsa.PublicKey)
+	if publicKey == nil {
+		return nil
+	}
+	publicKeyCache.Lock()
+	if len(publicKeyCache.keys) >= maxCachedPublicKeys {
+		publicKeyCache.keys = make(map[string]*ecdsa.PublicKey)
+	}
+	publicKeyCache.keys[pemEncodedPub] = publicKey
+	publicKeyCache.Unlock()
 	return publicKey
 }
 
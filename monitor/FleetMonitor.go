@@ -0,0 +1,327 @@
+// Package monitor with a fleet monitor that reports the health of the publishers of a domain
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultOfflineThreshold is the default time in seconds after which a publisher that isn't seen is
+// reported silent. Publishers republish their identity with their retained refresh interval.
+const DefaultOfflineThreshold = 3600
+
+// DefaultReportInterval is the default interval in seconds of publishing the fleet health report
+const DefaultReportInterval = 60
+
+// FleetMonitorConfig with the configuration of the fleet monitor
+type FleetMonitorConfig struct {
+	ListenAddress    string         `yaml:"listenAddress"`    // optional listen address of the HTTP report, eg localhost:9679. Default is disabled
+	OfflineThreshold int            `yaml:"offlineThreshold"` // seconds after which a publisher that isn't seen is silent. Default (0) is DefaultOfflineThreshold, negative to never be silent
+	ReportInterval   int            `yaml:"reportInterval"`   // seconds between publishing the report. Default (0) is DefaultReportInterval, negative to not publish
+	Thresholds       map[string]int `yaml:"thresholds"`       // offline threshold by publisher ID, overrides the default. Negative to never be silent
+}
+
+// publisherState is the last seen state of a publisher
+type publisherState struct {
+	lastSeen     time.Time               // time the identity or status was last received
+	offlineNodes []string                // nodes reported offline in the last status
+	reason       string                  // reason of the last status
+	status       types.PublisherRunState // last received run state
+}
+
+// FleetMonitor tracks the publishers of a domain from their $identity and $status messages and
+// reports their health. A publisher is offline when its status is disconnected, failed or lost, and
+// silent when it isn't seen within its offline threshold. The report is published periodically
+// and is available over HTTP for use by operators and health checkers.
+type FleetMonitor struct {
+	config        FleetMonitorConfig               // thresholds and report settings
+	domain        string                           // the domain to monitor
+	healthHandler func(types.FleetPublisherHealth) // optional handler of changes in publisher health
+	health        map[string]types.FleetHealth     // last reported health by publisher address
+	messageSigner *messaging.MessageSigner         // subscription to domain messages and report publication
+	publisherID   string                           // the publisher that publishes the report
+	publishers    map[string]*publisherState       // state of publishers by publisher address
+	server        *http.Server                     // server of the HTTP report, nil if not listening
+	stopChannel   chan bool                        // stop the report loop, nil when not running
+	updateMutex   *sync.Mutex                      // mutex for async handling of messages
+}
+
+// GetReport returns the health report of the publishers that are seen
+func (monitor *FleetMonitor) GetReport() types.FleetHealthMessage {
+	now := lib.Now()
+	monitor.updateMutex.Lock()
+	defer monitor.updateMutex.Unlock()
+	report := types.FleetHealthMessage{
+		Address:    MakeFleetHealthAddress(monitor.domain, monitor.publisherID),
+		Publishers: make([]types.FleetPublisherHealth, 0, len(monitor.publishers)),
+		Timestamp:  now.Format(types.TimeFormat),
+	}
+	for address, state := range monitor.publishers {
+		health := monitor.getHealth(address, state, now)
+		if health.Health == types.FleetHealthOffline || health.Health == types.FleetHealthSilent {
+			report.Offline++
+		} else {
+			report.Online++
+		}
+		report.Publishers = append(report.Publishers, health)
+	}
+	sort.Slice(report.Publishers, func(i, j int) bool {
+		return report.Publishers[i].Address < report.Publishers[j].Address
+	})
+	return report
+}
+
+// PublishReport publishes the health report, retained, and notifies the health handler of
+// publishers whose health has changed since the previous report
+func (monitor *FleetMonitor) PublishReport() error {
+	monitor.checkHealth()
+	report := monitor.GetReport()
+	logrus.Infof("PublishReport: %d publishers online and %d offline", report.Online, report.Offline)
+	return monitor.messageSigner.PublishObject(report.Address, true, &report, nil)
+}
+
+// ServeHTTP responds with the health report in JSON. The status is 503 when a publisher is
+// offline or silent so the endpoint can be used by health checkers.
+func (monitor *FleetMonitor) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report := monitor.GetReport()
+	response.Header().Set("Content-Type", "application/json")
+	if report.Offline > 0 {
+		response.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(response).Encode(report)
+}
+
+// SetHealthHandler sets the handler that is invoked when the health of a publisher changes, for
+// example to raise an alert. Changes are detected when messages are received and on each report.
+func (monitor *FleetMonitor) SetHealthHandler(handler func(health types.FleetPublisherHealth)) {
+	monitor.updateMutex.Lock()
+	defer monitor.updateMutex.Unlock()
+	monitor.healthHandler = handler
+}
+
+// Start listening for the identity and status of the domain publishers, publishing the report on
+// the interval and serving the report over HTTP if a listen address is configured
+func (monitor *FleetMonitor) Start() error {
+	monitor.updateMutex.Lock()
+	if monitor.stopChannel != nil {
+		monitor.updateMutex.Unlock()
+		return nil
+	}
+	if monitor.config.ListenAddress != "" {
+		listener, err := net.Listen("tcp", monitor.config.ListenAddress)
+		if err != nil {
+			monitor.updateMutex.Unlock()
+			return lib.MakeErrorf("FleetMonitor.Start: Unable to listen on %s: %s", monitor.config.ListenAddress, err)
+		}
+		monitor.server = &http.Server{Handler: monitor}
+		go monitor.server.Serve(listener)
+	}
+	monitor.stopChannel = make(chan bool)
+	stopChannel := monitor.stopChannel
+	monitor.updateMutex.Unlock()
+
+	monitor.messageSigner.Subscribe(monitor.makeAddress(types.MessageTypeIdentity), monitor.handleIdentity)
+	monitor.messageSigner.Subscribe(monitor.makeAddress(types.MessageTypeStatus), monitor.handleStatus)
+	if monitor.config.ReportInterval >= 0 {
+		go monitor.reportLoop(stopChannel)
+	}
+	return nil
+}
+
+// Stop listening and publishing the report
+func (monitor *FleetMonitor) Stop() {
+	monitor.messageSigner.Unsubscribe(monitor.makeAddress(types.MessageTypeIdentity), monitor.handleIdentity)
+	monitor.messageSigner.Unsubscribe(monitor.makeAddress(types.MessageTypeStatus), monitor.handleStatus)
+
+	monitor.updateMutex.Lock()
+	defer monitor.updateMutex.Unlock()
+	if monitor.stopChannel != nil {
+		close(monitor.stopChannel)
+		monitor.stopChannel = nil
+	}
+	if monitor.server != nil {
+		monitor.server.Close()
+		monitor.server = nil
+	}
+}
+
+// checkHealth notifies the health handler of publishers whose health has changed
+func (monitor *FleetMonitor) checkHealth() {
+	report := monitor.GetReport()
+	changes := make([]types.FleetPublisherHealth, 0)
+	monitor.updateMutex.Lock()
+	handler := monitor.healthHandler
+	for _, health := range report.Publishers {
+		if monitor.health[health.Address] != health.Health {
+			monitor.health[health.Address] = health.Health
+			changes = append(changes, health)
+		}
+	}
+	monitor.updateMutex.Unlock()
+
+	for _, health := range changes {
+		logrus.Infof("FleetMonitor.checkHealth: Publisher %s is %s", health.Address, health.Health)
+		if handler != nil {
+			handler(health)
+		}
+	}
+}
+
+// getHealth returns the health of a publisher at the given time
+// For internal use only. Use within locked section.
+func (monitor *FleetMonitor) getHealth(address string, state *publisherState, now time.Time) types.FleetPublisherHealth {
+	publisherID := address[strings.LastIndex(address, "/")+1:]
+	health := types.FleetPublisherHealth{
+		Address:      address,
+		Health:       types.FleetHealthOnline,
+		LastSeen:     state.lastSeen.Format(types.TimeFormat),
+		OfflineNodes: state.offlineNodes,
+		PublisherID:  publisherID,
+		Reason:       state.reason,
+		Status:       state.status,
+	}
+	threshold := monitor.config.OfflineThreshold
+	if publisherThreshold := monitor.config.Thresholds[publisherID]; publisherThreshold != 0 {
+		threshold = publisherThreshold
+	}
+	if threshold == 0 {
+		threshold = DefaultOfflineThreshold
+	}
+	switch {
+	case state.status == types.PublisherRunStateDisconnected || state.status == types.PublisherRunStateFailed ||
+		state.status == types.PublisherRunStateLost:
+		health.Health = types.FleetHealthOffline
+	case threshold >= 0 && now.Sub(state.lastSeen) > time.Duration(threshold)*time.Second:
+		health.Health = types.FleetHealthSilent
+	case state.status == types.PublisherRunStateWarning:
+		health.Health = types.FleetHealthWarning
+	}
+	return health
+}
+
+// handleIdentity records the time a publisher is seen
+func (monitor *FleetMonitor) handleIdentity(address string, message string) error {
+	var identity types.PublisherIdentityMessage
+	if message == "" {
+		return nil
+	}
+	_, err := monitor.messageSigner.VerifyPublication(address, message, &identity)
+	if err == nil && identity.Address != address {
+		err = fmt.Errorf("message address '%s' differs", identity.Address)
+	}
+	if err != nil {
+		return lib.MakeErrorf("handleIdentity: Invalid identity message on '%s': %s", address, err)
+	}
+	monitor.updateMutex.Lock()
+	monitor.getPublisherState(lib.MakeBaseAddress(address)).lastSeen = lib.Now()
+	monitor.updateMutex.Unlock()
+	monitor.checkHealth()
+	return nil
+}
+
+// handleStatus records the run state of a publisher and the time it is seen
+func (monitor *FleetMonitor) handleStatus(address string, message string) error {
+	var statusMessage types.PublisherStatusMessage
+	if message == "" {
+		return nil
+	}
+	isSigned, err := monitor.messageSigner.VerifyPublication(address, message, &statusMessage)
+	if err != nil && !isSigned {
+		// the last will of older publishers is the plain status
+		var payload string
+		payload, err = monitor.messageSigner.VerifyRawPublication(address, message)
+		statusMessage = types.PublisherStatusMessage{Address: address,
+			Status: types.PublisherRunState(strings.Trim(payload, "\""))}
+	}
+	if err == nil && statusMessage.Address != address {
+		err = fmt.Errorf("message address '%s' differs", statusMessage.Address)
+	}
+	if err != nil {
+		return lib.MakeErrorf("handleStatus: Invalid status message on '%s': %s", address, err)
+	}
+	monitor.updateMutex.Lock()
+	state := monitor.getPublisherState(lib.MakeBaseAddress(address))
+	state.lastSeen = lib.Now()
+	state.offlineNodes = statusMessage.OfflineNodes
+	state.reason = statusMessage.Reason
+	state.status = statusMessage.Status
+	monitor.updateMutex.Unlock()
+	monitor.checkHealth()
+	return nil
+}
+
+// getPublisherState returns the state of a publisher, creating it if it doesn't exist
+// For internal use only. Use within locked section.
+func (monitor *FleetMonitor) getPublisherState(publisherAddress string) *publisherState {
+	state := monitor.publishers[publisherAddress]
+	if state == nil {
+		state = &publisherState{}
+		monitor.publishers[publisherAddress] = state
+	}
+	return state
+}
+
+// makeAddress returns the subscription address of a publisher message type in the domain
+func (monitor *FleetMonitor) makeAddress(messageType string) string {
+	return fmt.Sprintf("%s/+/%s", monitor.domain, messageType)
+}
+
+// reportLoop publishes the report on the interval until stopped
+func (monitor *FleetMonitor) reportLoop(stopChannel chan bool) {
+	interval := monitor.config.ReportInterval
+	if interval == 0 {
+		interval = DefaultReportInterval
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChannel:
+			return
+		case <-ticker.C:
+			err := monitor.PublishReport()
+			if err != nil {
+				logrus.Errorf("FleetMonitor.reportLoop: %s", err)
+			}
+		}
+	}
+}
+
+// MakeFleetHealthAddress returns the address of the fleet health report of a monitoring publisher
+func MakeFleetHealthAddress(domain string, publisherID string) string {
+	return fmt.Sprintf("%s/%s/%s", domain, publisherID, types.MessageTypeFleetHealth)
+}
+
+// NewFleetMonitor creates a new instance of the fleet monitor. Use Start() to start monitoring.
+//  domain is the domain to monitor
+//  publisherID is the publisher that publishes the report
+//  config with the offline thresholds and report settings
+func NewFleetMonitor(domain string, publisherID string, config FleetMonitorConfig,
+	messageSigner *messaging.MessageSigner) *FleetMonitor {
+
+	monitor := &FleetMonitor{
+		config:        config,
+		domain:        domain,
+		health:        make(map[string]types.FleetHealth),
+		messageSigner: messageSigner,
+		publisherID:   publisherID,
+		publishers:    make(map[string]*publisherState),
+		updateMutex:   &sync.Mutex{},
+	}
+	return monitor
+}
@@ -0,0 +1,137 @@
+package monitor_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/monitor"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const domain = "test"
+const monitorID = "fleetmon"
+const publisher1ID = "publisher1"
+const publisher2ID = "publisher2"
+
+func TestFleetMonitor(t *testing.T) {
+	clock := lib.NewManualClock(time.Date(2020, 6, 1, 12, 0, 0, 0, time.Local))
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	config := monitor.FleetMonitorConfig{
+		OfflineThreshold: 600,
+		ReportInterval:   -1,
+		Thresholds:       map[string]int{publisher2ID: -1},
+	}
+	fleetMonitor := monitor.NewFleetMonitor(domain, monitorID, config, signer)
+	changes := make([]types.FleetPublisherHealth, 0)
+	fleetMonitor.SetHealthHandler(func(health types.FleetPublisherHealth) {
+		changes = append(changes, health)
+	})
+	err := fleetMonitor.Start()
+	require.NoError(t, err)
+
+	// two publishers appear
+	for _, publisherID := range []string{publisher1ID, publisher2ID} {
+		identityAddr := identities.MakePublisherIdentityAddress(domain, publisherID)
+		ident := types.PublisherIdentityMessage{Address: identityAddr, PublisherID: publisherID}
+		signer.PublishObject(identityAddr, true, &ident, nil)
+		statusAddr := identities.MakePublisherStatusAddress(domain, publisherID)
+		status := types.PublisherStatusMessage{Address: statusAddr, Status: types.PublisherRunStateConnected}
+		signer.PublishObject(statusAddr, true, &status, nil)
+	}
+	report := fleetMonitor.GetReport()
+	assert.Equal(t, 2, report.Online)
+	assert.Equal(t, 0, report.Offline)
+	require.Len(t, report.Publishers, 2)
+	assert.Equal(t, publisher1ID, report.Publishers[0].PublisherID)
+	assert.Len(t, changes, 2)
+
+	// publisher1 becomes silent, publisher2 has no threshold
+	clock.Add(time.Hour)
+	report = fleetMonitor.GetReport()
+	assert.Equal(t, types.FleetHealthSilent, report.Publishers[0].Health)
+	assert.Equal(t, types.FleetHealthOnline, report.Publishers[1].Health)
+
+	// the last will of publisher2 marks it offline
+	statusAddr := identities.MakePublisherStatusAddress(domain, publisher2ID)
+	messenger.Publish(statusAddr, true, string(types.PublisherRunStateLost))
+	report = fleetMonitor.GetReport()
+	assert.Equal(t, 0, report.Online)
+	assert.Equal(t, 2, report.Offline)
+	assert.Equal(t, types.FleetHealthOffline, report.Publishers[1].Health)
+	assert.Equal(t, types.PublisherRunStateLost, report.Publishers[1].Status)
+
+	// the report is published with the changes since the last check
+	err = fleetMonitor.PublishReport()
+	assert.NoError(t, err)
+	assert.Len(t, changes, 4)
+	reportAddr := monitor.MakeFleetHealthAddress(domain, monitorID)
+	payload, err := messaging.VerifyJWSMessage(messenger.FindLastPublication(reportAddr), &privKey.PublicKey)
+	require.NoError(t, err)
+	var published types.FleetHealthMessage
+	err = json.Unmarshal([]byte(payload), &published)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, published.Offline)
+
+	// the report over HTTP
+	response := httptest.NewRecorder()
+	fleetMonitor.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	identityAddr := identities.MakePublisherIdentityAddress(domain, publisher1ID)
+	signer.PublishObject(identityAddr, true, &types.PublisherIdentityMessage{Address: identityAddr}, nil)
+	status := types.PublisherStatusMessage{Address: statusAddr, Status: types.PublisherRunStateConnected}
+	signer.PublishObject(statusAddr, true, &status, nil)
+	response = httptest.NewRecorder()
+	fleetMonitor.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+	err = json.Unmarshal(response.Body.Bytes(), &published)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, published.Online)
+	fleetMonitor.Stop()
+}
+
+// identity and status messages that fail signature verification don't affect the fleet health
+func TestFleetMonitorForgedStatus(t *testing.T) {
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	config := monitor.FleetMonitorConfig{ReportInterval: -1}
+	fleetMonitor := monitor.NewFleetMonitor(domain, monitorID, config, signer)
+	err := fleetMonitor.Start()
+	require.NoError(t, err)
+	defer fleetMonitor.Stop()
+
+	statusAddr := identities.MakePublisherStatusAddress(domain, publisher1ID)
+	status := types.PublisherStatusMessage{Address: statusAddr, Status: types.PublisherRunStateConnected}
+	signer.PublishObject(statusAddr, true, &status, nil)
+
+	// a status signed with another key doesn't mark the publisher offline
+	forger := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), getPubKey)
+	status.Status = types.PublisherRunStateDisconnected
+	forger.PublishObject(statusAddr, true, &status, nil)
+	identityAddr := identities.MakePublisherIdentityAddress(domain, publisher2ID)
+	forger.PublishObject(identityAddr, true, &types.PublisherIdentityMessage{Address: identityAddr}, nil)
+
+	report := fleetMonitor.GetReport()
+	assert.Equal(t, 1, report.Online)
+	assert.Equal(t, 0, report.Offline)
+	assert.Len(t, report.Publishers, 1)
+}
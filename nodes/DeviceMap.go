@@ -0,0 +1,226 @@
+// Package nodes with the mapping of stable device identifiers to nodes
+package nodes
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DeviceMapping maps the stable identifiers of a device to the hardware ID of the node that represents it
+type DeviceMapping struct {
+	DeviceIDs []string `json:"deviceIds"` // normalized stable identifiers of the device, eg MAC address and serial number
+	HWID      string   `json:"hwId"`      // hardware ID of the node of the device
+	Updated   string   `json:"updated"`   // time the mapping last changed
+}
+
+// DeviceMap is the registry that maps stable device identifiers, such as a MAC address or serial
+// number, to the hardware ID of the node of the device. Adapters whose hardware IDs change when the
+// hardware re-enumerates, for example a USB port or bus address, use it to keep the node of a device
+// and its node ID after a restart or rediscovery, instead of creating a duplicate node.
+//
+// The mapping rules are:
+//  - device IDs are normalized with NormalizeDeviceID, so 'AA:BB:CC' and 'aa-bb-cc' are the same device
+//  - a device that has any device ID in common with a mapped device is the same device
+//  - the first mapping of a device determines its hardware ID. New device IDs are added to it.
+type DeviceMap struct {
+	filename    string                    // file to persist the mappings, "" to not persist
+	hwIDs       map[string]string         // hardware ID of nodes by normalized device ID
+	mappings    map[string]*DeviceMapping // mappings by hardware ID
+	updateMutex *sync.RWMutex             // mutex for concurrent mapping of devices
+}
+
+// GetDeviceIDs returns the device IDs that are mapped to a node, or nil if the node has no mapping
+func (deviceMap *DeviceMap) GetDeviceIDs(hwID string) []string {
+	deviceMap.updateMutex.RLock()
+	defer deviceMap.updateMutex.RUnlock()
+	mapping := deviceMap.mappings[hwID]
+	if mapping == nil {
+		return nil
+	}
+	return append([]string{}, mapping.DeviceIDs...)
+}
+
+// GetHWID returns the hardware ID of the node of a device
+//  deviceID is a stable identifier of the device. It is normalized before lookup.
+// Returns false if the device ID isn't mapped
+func (deviceMap *DeviceMap) GetHWID(deviceID string) (hwID string, found bool) {
+	deviceMap.updateMutex.RLock()
+	defer deviceMap.updateMutex.RUnlock()
+	hwID, found = deviceMap.hwIDs[NormalizeDeviceID(deviceID)]
+	return hwID, found
+}
+
+// GetMappings returns a copy of the device mappings, ordered by hardware ID
+func (deviceMap *DeviceMap) GetMappings() []DeviceMapping {
+	deviceMap.updateMutex.RLock()
+	defer deviceMap.updateMutex.RUnlock()
+	mappingList := make([]DeviceMapping, 0, len(deviceMap.mappings))
+	for _, mapping := range deviceMap.mappings {
+		mappingCopy := *mapping
+		mappingCopy.DeviceIDs = append([]string{}, mapping.DeviceIDs...)
+		mappingList = append(mappingList, mappingCopy)
+	}
+	sort.Slice(mappingList, func(i, j int) bool {
+		return mappingList[i].HWID < mappingList[j].HWID
+	})
+	return mappingList
+}
+
+// LoadDeviceMap loads the device mappings from file. Changes to the mappings are saved to this
+// file. A file that doesn't exist is not an error.
+func (deviceMap *DeviceMap) LoadDeviceMap(filename string) error {
+	deviceMap.updateMutex.Lock()
+	defer deviceMap.updateMutex.Unlock()
+	deviceMap.filename = filename
+
+	mappingList := make([]*DeviceMapping, 0)
+	jsonText, err := lib.ReadFileWithRecovery(filename, lib.ValidateJSON)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return lib.MakeErrorf("LoadDeviceMap: Unable to open file %s: %s", filename, err)
+	}
+	err = json.Unmarshal(jsonText, &mappingList)
+	if err != nil {
+		return lib.MakeErrorf("LoadDeviceMap: Error parsing JSON device map file %s: %s", filename, err)
+	}
+	for _, mapping := range mappingList {
+		if mapping.HWID == "" {
+			continue
+		}
+		deviceMap.mappings[mapping.HWID] = mapping
+		for _, deviceID := range mapping.DeviceIDs {
+			deviceMap.hwIDs[deviceID] = mapping.HWID
+		}
+	}
+	logrus.Infof("LoadDeviceMap: Loaded %d device mappings from %s", len(mappingList), filename)
+	return nil
+}
+
+// MapDevice maps the stable identifiers of a device to the hardware ID of its node.
+// If any of the device IDs is already mapped, the device is the same device and the hardware ID
+// of the existing mapping is returned. Otherwise the device IDs are mapped to the given hardware ID.
+// Changes are saved if the map was loaded from file.
+//  hwID is the hardware ID the adapter uses for the device, eg its bus address
+//  deviceIDs are the stable identifiers of the device, eg its MAC address and serial number
+// Returns the hardware ID of the node of the device
+func (deviceMap *DeviceMap) MapDevice(hwID string, deviceIDs ...string) string {
+	deviceMap.updateMutex.Lock()
+	defer deviceMap.updateMutex.Unlock()
+
+	normalizedIDs := make([]string, 0, len(deviceIDs))
+	nodeHWID := ""
+	for _, deviceID := range deviceIDs {
+		normalizedID := NormalizeDeviceID(deviceID)
+		if normalizedID == "" {
+			continue
+		}
+		normalizedIDs = append(normalizedIDs, normalizedID)
+		mappedHWID, found := deviceMap.hwIDs[normalizedID]
+		if found && nodeHWID == "" {
+			nodeHWID = mappedHWID
+		} else if found && mappedHWID != nodeHWID {
+			logrus.Warningf("MapDevice: Device ID '%s' is mapped to node %s and to node %s. Using node %s",
+				deviceID, nodeHWID, mappedHWID, nodeHWID)
+		}
+	}
+	if nodeHWID == "" {
+		nodeHWID = hwID
+	} else if nodeHWID != hwID {
+		logrus.Infof("MapDevice: Device with hardware ID %s is mapped to the node of hardware ID %s", hwID, nodeHWID)
+	}
+	if len(normalizedIDs) == 0 {
+		return nodeHWID
+	}
+	mapping := deviceMap.mappings[nodeHWID]
+	if mapping == nil {
+		mapping = &DeviceMapping{HWID: nodeHWID, DeviceIDs: make([]string, 0)}
+		deviceMap.mappings[nodeHWID] = mapping
+	}
+	isChanged := false
+	for _, normalizedID := range normalizedIDs {
+		if _, found := deviceMap.hwIDs[normalizedID]; !found {
+			deviceMap.hwIDs[normalizedID] = nodeHWID
+			mapping.DeviceIDs = append(mapping.DeviceIDs, normalizedID)
+			isChanged = true
+		}
+	}
+	if isChanged {
+		mapping.Updated = lib.Now().Format(types.TimeFormat)
+		deviceMap.saveDeviceMap()
+	}
+	return nodeHWID
+}
+
+// RemoveDevice removes the mapping of the device of a node, eg when the node is purged
+// Returns false if the node has no mapping
+func (deviceMap *DeviceMap) RemoveDevice(hwID string) bool {
+	deviceMap.updateMutex.Lock()
+	defer deviceMap.updateMutex.Unlock()
+	mapping := deviceMap.mappings[hwID]
+	if mapping == nil {
+		return false
+	}
+	for _, deviceID := range mapping.DeviceIDs {
+		delete(deviceMap.hwIDs, deviceID)
+	}
+	delete(deviceMap.mappings, hwID)
+	deviceMap.saveDeviceMap()
+	return true
+}
+
+// saveDeviceMap saves the mappings to file, if a file is set
+// This must be called with the update mutex locked.
+func (deviceMap *DeviceMap) saveDeviceMap() error {
+	if deviceMap.filename == "" {
+		return nil
+	}
+	mappingList := make([]*DeviceMapping, 0, len(deviceMap.mappings))
+	for _, mapping := range deviceMap.mappings {
+		mappingList = append(mappingList, mapping)
+	}
+	sort.Slice(mappingList, func(i, j int) bool {
+		return mappingList[i].HWID < mappingList[j].HWID
+	})
+	jsonText, err := json.MarshalIndent(mappingList, "", "  ")
+	if err != nil {
+		return lib.MakeErrorf("saveDeviceMap: Error marshalling device map: %s", err)
+	}
+	err = os.MkdirAll(path.Dir(deviceMap.filename), 0750)
+	if err == nil {
+		err = lib.WriteFileAtomic(deviceMap.filename, jsonText, 0600)
+	}
+	if err != nil {
+		err = lib.MakeErrorf("saveDeviceMap: Error saving device map to %s: %s", deviceMap.filename, err)
+	}
+	return err
+}
+
+// NormalizeDeviceID returns the normalized form of a device identifier, so the same identifier
+// written differently maps to the same device. Letters are lower case and the separators ':', '-',
+// '.' and spaces are removed, eg "AA:BB:CC:DD:EE:FF" becomes "aabbccddeeff".
+func NormalizeDeviceID(deviceID string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ':' || r == '-' || r == '.' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(deviceID)))
+}
+
+// NewDeviceMap returns a new registry of device mappings. Use LoadDeviceMap to persist the mappings.
+func NewDeviceMap() *DeviceMap {
+	return &DeviceMap{
+		hwIDs:       make(map[string]string),
+		mappings:    make(map[string]*DeviceMapping),
+		updateMutex: &sync.RWMutex{},
+	}
+}
@@ -0,0 +1,63 @@
+package nodes_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceMap(t *testing.T) {
+	folder, err := ioutil.TempDir("", "devicemap")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	filename := path.Join(folder, "publisher1-devices.json")
+	deviceMap := nodes.NewDeviceMap()
+	err = deviceMap.LoadDeviceMap(filename)
+	assert.NoError(t, err)
+
+	// the first mapping of a device determines the hardware ID of its node
+	hwID := deviceMap.MapDevice("usb0", "AA:BB:CC:DD:EE:FF", "SN1234")
+	assert.Equal(t, "usb0", hwID)
+	assert.Equal(t, []string{"aabbccddeeff", "sn1234"}, deviceMap.GetDeviceIDs("usb0"))
+
+	// a re-enumerated device with a known device ID maps to the same node and adds new device IDs
+	hwID = deviceMap.MapDevice("usb1", "aa-bb-cc-dd-ee-ff", "uuid-1")
+	assert.Equal(t, "usb0", hwID)
+	hwID, found := deviceMap.GetHWID("UUID1")
+	assert.True(t, found)
+	assert.Equal(t, "usb0", hwID)
+	assert.Nil(t, deviceMap.GetDeviceIDs("usb1"))
+
+	// devices without device IDs are not mapped
+	assert.Equal(t, "usb2", deviceMap.MapDevice("usb2"))
+	assert.Equal(t, 1, len(deviceMap.GetMappings()))
+
+	// the mappings are kept after a restart
+	deviceMap2 := nodes.NewDeviceMap()
+	err = deviceMap2.LoadDeviceMap(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "usb0", deviceMap2.MapDevice("usb3", "sn1234"))
+	mappings := deviceMap2.GetMappings()
+	require.Equal(t, 1, len(mappings))
+	assert.Equal(t, 3, len(mappings[0].DeviceIDs))
+	assert.NotEmpty(t, mappings[0].Updated)
+
+	// removed devices can be mapped to another node
+	assert.True(t, deviceMap2.RemoveDevice("usb0"))
+	assert.False(t, deviceMap2.RemoveDevice("usb0"))
+	assert.Equal(t, "usb3", deviceMap2.MapDevice("usb3", "sn1234"))
+
+	// invalid files fall back to the previous version, if any, or are rejected
+	ioutil.WriteFile(filename, []byte("not json"), 0600)
+	err = nodes.NewDeviceMap().LoadDeviceMap(filename)
+	assert.NoError(t, err)
+	os.Remove(filename + lib.BackupFileSuffix)
+	err = nodes.NewDeviceMap().LoadDeviceMap(filename)
+	assert.Error(t, err)
+}
@@ -5,8 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"reflect"
+	"sort"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
@@ -14,15 +15,34 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// cachedNode is the saved node with the time it was last received
+type cachedNode struct {
+	*types.NodeDiscoveryMessage
+	Cached string `json:"cached"` // time the node was last received
+}
+
 // DomainNodes manages nodes discovered on the domain
 type DomainNodes struct {
 	c             lib.DomainCollection     //
 	messageSigner *messaging.MessageSigner // subscription to input discovery messages
+	cached        map[string]time.Time     // time nodes were last received, by base address
+	cacheTTL      time.Duration            // time saved nodes remain valid after last received
+	movedHandler  NodeMovedHandler         // optional handler of nodes whose location changes
 }
 
+// NodeMovedHandler is invoked when the location of a discovered node changes
+//  previous is the previous location of the node, nil if it had no location
+type NodeMovedHandler func(node *types.NodeDiscoveryMessage, previous *types.NodeLocation)
+
 // AddNode adds or replaces a discovered node
 func (domainNodes *DomainNodes) AddNode(node *types.NodeDiscoveryMessage) {
+	domainNodes.addNode(node, lib.Now())
+}
+
+// addNode adds a node that was last received at the given time
+func (domainNodes *DomainNodes) addNode(node *types.NodeDiscoveryMessage, cached time.Time) {
 	domainNodes.c.Update(node.Address, node)
+	domainNodes.setCached(node.Address, cached)
 }
 
 // GetAllNodes returns a list of all discovered nodes of the domain
@@ -32,6 +52,45 @@ func (domainNodes *DomainNodes) GetAllNodes() []*types.NodeDiscoveryMessage {
 	return allNodes
 }
 
+// GetNodesByPattern returns the nodes whose address matches a pattern with '+' wildcards
+//  pattern is the node address pattern, eg domain/+/node1 for node1 of any publisher
+func (domainNodes *DomainNodes) GetNodesByPattern(pattern string) []*types.NodeDiscoveryMessage {
+	var nodeList = make([]*types.NodeDiscoveryMessage, 0)
+	domainNodes.c.GetByPattern(pattern, &nodeList)
+	return nodeList
+}
+
+// GetNodesByType returns the nodes of the given type, as defined in the node type attribute
+func (domainNodes *DomainNodes) GetNodesByType(nodeType types.NodeType) []*types.NodeDiscoveryMessage {
+	var nodeList = make([]*types.NodeDiscoveryMessage, 0)
+	domainNodes.c.GetByType(string(nodeType), &nodeList)
+	return nodeList
+}
+
+// GetNodesNear returns the discovered nodes that are located within the radius of a location,
+// nearest first. Nodes without location are ignored.
+//  radius is the distance in meters from the location
+func (domainNodes *DomainNodes) GetNodesNear(latitude float64, longitude float64, radius float64) []*types.NodeDiscoveryMessage {
+	center := &types.NodeLocation{Latitude: latitude, Longitude: longitude}
+	nodeList := make([]*types.NodeDiscoveryMessage, 0)
+	distances := make(map[*types.NodeDiscoveryMessage]float64)
+	for _, node := range domainNodes.GetAllNodes() {
+		location := types.GetNodeLocation(node)
+		if location == nil {
+			continue
+		}
+		distance := center.Distance(location)
+		if distance <= radius {
+			nodeList = append(nodeList, node)
+			distances[node] = distance
+		}
+	}
+	sort.Slice(nodeList, func(i, j int) bool {
+		return distances[nodeList[i]] < distances[nodeList[j]]
+	})
+	return nodeList
+}
+
 // GetPublisherNodes returns a list of all nodes of a publisher
 // publisherAddress contains the domain/publisherID[/$identity]
 func (domainNodes *DomainNodes) GetPublisherNodes(publisherAddress string) []*types.NodeDiscoveryMessage {
@@ -91,11 +150,13 @@ func (domainNodes *DomainNodes) GetNodeConfigValue(
 }
 
 // LoadNodes loads saved discovered nodes from file
-// Existing nodes are retained but replaced if contained in the file
+// Existing nodes are retained but replaced if contained in the file. Nodes that are not received
+// within the cache TTL are ignored. Files without the time the node was received use the node
+// timestamp.
 func (domainNodes *DomainNodes) LoadNodes(filename string) error {
-	nodeList := make([]*types.NodeDiscoveryMessage, 0)
+	nodeList := make([]*cachedNode, 0)
 
-	jsonNodes, err := ioutil.ReadFile(filename)
+	jsonNodes, err := lib.ReadFileWithRecovery(filename, lib.ValidateJSON)
 	if err != nil {
 		return lib.MakeErrorf("LoadNodes: Unable to open file %s: %s", filename, err)
 	}
@@ -103,28 +164,59 @@ func (domainNodes *DomainNodes) LoadNodes(filename string) error {
 	if err != nil {
 		return lib.MakeErrorf("LoadNodes: Error parsing JSON node file %s: %v", filename, err)
 	}
-	logrus.Infof("LoadIdentities: Identities loaded successfully from %s", filename)
+	domainNodes.c.UpdateMutex.RLock()
+	cacheTTL := domainNodes.cacheTTL
+	domainNodes.c.UpdateMutex.RUnlock()
+	expiredCount := 0
 	for _, node := range nodeList {
-		domainNodes.AddNode(node)
+		if node.NodeDiscoveryMessage == nil {
+			continue
+		}
+		cachedStr := node.Cached
+		if cachedStr == "" {
+			cachedStr = node.Timestamp
+		}
+		cached, err := time.Parse(types.TimeFormat, cachedStr)
+		if err != nil || time.Since(cached) > cacheTTL {
+			expiredCount++
+			continue
+		}
+		domainNodes.addNode(node.NodeDiscoveryMessage, cached)
 	}
+	logrus.Infof("LoadNodes: %d nodes loaded successfully from %s. %d expired nodes ignored",
+		len(nodeList)-expiredCount, filename, expiredCount)
 	return nil
-
 }
 
 // RemoveNode removes a node using its address.
 // If the node doesn't exist, this is ignored.
 func (domainNodes *DomainNodes) RemoveNode(address string) {
 	domainNodes.c.Remove(address)
+	domainNodes.c.UpdateMutex.Lock()
+	delete(domainNodes.cached, lib.MakeBaseAddress(address))
+	domainNodes.c.UpdateMutex.Unlock()
 }
 
-// SaveNodes saves previously discovered nodes to file
+// SaveNodes saves previously discovered nodes to file and resets the update count
+// The time each node was last received is included to expire them when loading.
 func (domainNodes *DomainNodes) SaveNodes(filename string) error {
-	collection := domainNodes.GetAllNodes()
+	domainNodes.c.ResetUpdateCount()
+	nodeList := domainNodes.GetAllNodes()
+	collection := make([]*cachedNode, 0, len(nodeList))
+	domainNodes.c.UpdateMutex.RLock()
+	for _, node := range nodeList {
+		cached := domainNodes.cached[lib.MakeBaseAddress(node.Address)]
+		collection = append(collection, &cachedNode{
+			NodeDiscoveryMessage: node,
+			Cached:               cached.Format(types.TimeFormat),
+		})
+	}
+	domainNodes.c.UpdateMutex.RUnlock()
 	jsonText, err := json.MarshalIndent(collection, "", "  ")
 	if err != nil {
 		return lib.MakeErrorf("SaveNodes: Error Marshalling JSON collection '%s': %v", filename, err)
 	}
-	err = ioutil.WriteFile(filename, jsonText, 0664)
+	err = lib.WriteFileAtomic(filename, jsonText, 0664)
 	if err != nil {
 		return lib.MakeErrorf("SaveNodes: Error saving collection to JSON file %s: %v", filename, err)
 	}
@@ -132,6 +224,29 @@ func (domainNodes *DomainNodes) SaveNodes(filename string) error {
 	return nil
 }
 
+// SetCacheTTL sets the time that saved nodes remain valid after they were last received.
+// Default is lib.DefaultCacheTTL.
+func (domainNodes *DomainNodes) SetCacheTTL(cacheTTL time.Duration) {
+	domainNodes.c.UpdateMutex.Lock()
+	defer domainNodes.c.UpdateMutex.Unlock()
+	domainNodes.cacheTTL = cacheTTL
+}
+
+// SetMovedHandler sets the handler that is invoked when the location of a discovered node changes,
+// eg of a vehicle. Nodes that are discovered for the first time have not moved.
+func (domainNodes *DomainNodes) SetMovedHandler(handler NodeMovedHandler) {
+	domainNodes.c.UpdateMutex.Lock()
+	defer domainNodes.c.UpdateMutex.Unlock()
+	domainNodes.movedHandler = handler
+}
+
+// StartBulkLoad defers building the lookup indexes of the discovered nodes until the burst of
+// retained discovery messages on subscribing subsides, see lib.DomainCollection.StartBulkLoad
+//  quietPeriod is the time without discovery messages after which the indexes are built
+func (domainNodes *DomainNodes) StartBulkLoad(quietPeriod time.Duration) {
+	domainNodes.c.StartBulkLoad(quietPeriod)
+}
+
 // Subscribe to nodes discovery of the given domain publisher.
 func (domainNodes *DomainNodes) Subscribe(domain string, publisherID string) {
 	// subscription address  domain/publisher/+/$node
@@ -139,6 +254,11 @@ func (domainNodes *DomainNodes) Subscribe(domain string, publisherID string) {
 	domainNodes.messageSigner.Subscribe(address, domainNodes.handleDiscoverNode)
 }
 
+// UpdateCount returns the nr of updates to nodes since the last SaveNodes call
+func (domainNodes *DomainNodes) UpdateCount() int {
+	return domainNodes.c.UpdateCount()
+}
+
 // Unsubscribe from publisher
 func (domainNodes *DomainNodes) Unsubscribe(domain string, publisherID string) {
 	address := MakeNodeDiscoveryAddress(domain, publisherID, "+")
@@ -146,22 +266,67 @@ func (domainNodes *DomainNodes) Unsubscribe(domain string, publisherID string) {
 }
 
 // handleDiscoverNode adds discovered domain nodes to the collection
+// If the node contains encrypted attributes that this publisher is authorized to read, then
+// these are decrypted and included in the node attributes.
 func (domainNodes *DomainNodes) handleDiscoverNode(address string, message string) error {
 	var discoMsg types.NodeDiscoveryMessage
 
+	previousNode := domainNodes.GetNodeByAddress(address)
 	err := domainNodes.c.HandleDiscovery(address, message, &discoMsg)
+	if err == nil {
+		domainNodes.setCached(address, lib.Now())
+	}
+	if err == nil && discoMsg.EncryptedAttr != "" {
+		decryptedNode, err2 := decryptNodeAttr(&discoMsg, domainNodes.messageSigner.DecryptMulti)
+		if err2 != nil {
+			// not an authorized reader, the private attributes remain unreadable
+			logrus.Debugf("handleDiscoverNode: %s", err2)
+		} else {
+			domainNodes.AddNode(decryptedNode)
+		}
+	}
+	if err == nil && previousNode != nil {
+		domainNodes.notifyMoved(previousNode, domainNodes.GetNodeByAddress(address))
+	}
 	return err
 }
 
+// notifyMoved invokes the moved handler if the location of the node differs from its previous version
+func (domainNodes *DomainNodes) notifyMoved(previousNode *types.NodeDiscoveryMessage, node *types.NodeDiscoveryMessage) {
+	domainNodes.c.UpdateMutex.RLock()
+	handler := domainNodes.movedHandler
+	domainNodes.c.UpdateMutex.RUnlock()
+	previous := types.GetNodeLocation(previousNode)
+	location := types.GetNodeLocation(node)
+	if handler == nil || location == nil || (previous != nil && *previous == *location) {
+		return
+	}
+	logrus.Infof("notifyMoved: Node %s moved to %f,%f", node.Address, location.Latitude, location.Longitude)
+	handler(node, previous)
+}
+
+// setCached records the time the node with the given address was last received
+func (domainNodes *DomainNodes) setCached(address string, cached time.Time) {
+	domainNodes.c.UpdateMutex.Lock()
+	defer domainNodes.c.UpdateMutex.Unlock()
+	domainNodes.cached[lib.MakeBaseAddress(address)] = cached
+}
+
 // NewDomainNodes creates a new instance for domain node management.
 //  messageSigner is used to receive signed node discovery messages
 func NewDomainNodes(messageSigner *messaging.MessageSigner) *DomainNodes {
 	domainCollection := lib.NewDomainCollection(
 		reflect.TypeOf(&types.NodeDiscoveryMessage{}), messageSigner.GetPublicKey)
+	domainCollection.ReceiveHook = messageSigner.ApplyReceiveHooks
+	domainCollection.GetItemType = func(item interface{}) string {
+		return item.(*types.NodeDiscoveryMessage).Attr[types.NodeAttrType]
+	}
 
 	domainNodes := DomainNodes{
 		c:             domainCollection,
 		messageSigner: messageSigner,
+		cached:        make(map[string]time.Time),
+		cacheTTL:      lib.DefaultCacheTTL * time.Second,
 	}
 	return &domainNodes
 }
@@ -3,7 +3,11 @@ package nodes_test
 import (
 	"crypto/ecdsa"
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/nodes"
@@ -46,6 +50,10 @@ func TestNewDomainNodes(t *testing.T) {
 
 	pubNodes := collection.GetPublisherNodes(domain + "/" + publisherID)
 	assert.Equal(t, 1, len(pubNodes))
+	assert.Equal(t, 1, len(collection.GetNodesByType(types.NodeTypeAdapter)))
+	assert.Equal(t, 0, len(collection.GetNodesByType(types.NodeTypeSensor)))
+	assert.Equal(t, 1, len(collection.GetNodesByPattern(domain+"/+/"+nodeID+"/$node")))
+	assert.Equal(t, 0, len(collection.GetNodesByPattern(domain+"/+/node2")))
 
 	//	node attr
 	name := collection.GetNodeAttr(addr, types.NodeAttrName)
@@ -102,3 +110,83 @@ func TestDiscoverDomainNodes(t *testing.T) {
 	assert.Equal(t, 1, len(inList), "Expected 1 discovered node. Got %d", len(inList))
 	collection.Unsubscribe(domain2, "+")
 }
+
+func TestDomainNodesNear(t *testing.T) {
+	const domain = "test"
+	const publisherID = "pub2"
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	collection := nodes.NewDomainNodes(signer)
+	moved := make([]*types.NodeLocation, 0)
+	collection.SetMovedHandler(func(node *types.NodeDiscoveryMessage, previous *types.NodeLocation) {
+		moved = append(moved, types.GetNodeLocation(node))
+	})
+	collection.Subscribe(domain, "+")
+
+	// node1 is in Amsterdam, node2 about 1km away and node3 in Utrecht
+	node1 := nodes.NewNode(domain, publisherID, "node1", types.NodeTypeSensor)
+	node1.Attr[types.NodeAttrLatLon] = "52.370216,4.895168"
+	node2 := nodes.NewNode(domain, publisherID, "node2", types.NodeTypeSensor)
+	node2.Location = &types.NodeLocation{Latitude: 52.379, Longitude: 4.9}
+	node3 := nodes.NewNode(domain, publisherID, "node3", types.NodeTypeSensor)
+	node3.Attr[types.NodeAttrLatLon] = "52.090737,5.121420"
+	node4 := nodes.NewNode(domain, publisherID, "node4", types.NodeTypeSensor)
+	for _, node := range []*types.NodeDiscoveryMessage{node1, node2, node3, node4} {
+		signer.PublishObject(node.Address, true, node, nil)
+	}
+	near := collection.GetNodesNear(52.3702, 4.8952, 2000)
+	require.Len(t, near, 2)
+	assert.Equal(t, node1.Address, near[0].Address)
+	assert.Equal(t, node2.Address, near[1].Address)
+	assert.Len(t, collection.GetNodesNear(52.3702, 4.8952, 50000), 3)
+	assert.Empty(t, moved)
+
+	// node3 moves to Amsterdam
+	node3.Attr[types.NodeAttrLatLon] = "52.37,4.89"
+	signer.PublishObject(node3.Address, true, node3, nil)
+	signer.PublishObject(node3.Address, true, node3, nil)
+	require.Len(t, moved, 1)
+	assert.Equal(t, 52.37, moved[0].Latitude)
+	assert.Len(t, collection.GetNodesNear(52.3702, 4.8952, 2000), 3)
+	collection.Unsubscribe(domain, "+")
+}
+
+func TestDomainNodesCacheTTL(t *testing.T) {
+	const domain = "test"
+	const publisherID = "pub2"
+	cacheFolder, err := ioutil.TempDir("", "nodes")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheFolder)
+	filename := path.Join(cacheFolder, "cache.json")
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer := messaging.NewMessageSigner(messenger, nil, nil)
+
+	collection := nodes.NewDomainNodes(signer)
+	node := nodes.NewNode(domain, publisherID, "node1", types.NodeTypeAdapter)
+	collection.AddNode(node)
+	assert.Equal(t, 1, collection.UpdateCount())
+	err = collection.SaveNodes(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 0, collection.UpdateCount())
+
+	// nodes received within the TTL are loaded
+	collection2 := nodes.NewDomainNodes(signer)
+	err = collection2.LoadNodes(filename)
+	require.NoError(t, err)
+	assert.NotNil(t, collection2.GetNodeByAddress(node.Address))
+
+	// nodes received before the TTL are ignored
+	time.Sleep(time.Millisecond * 10)
+	collection3 := nodes.NewDomainNodes(signer)
+	collection3.SetCacheTTL(time.Millisecond)
+	err = collection3.LoadNodes(filename)
+	require.NoError(t, err)
+	assert.Nil(t, collection3.GetNodeByAddress(node.Address))
+
+	err = collection3.LoadNodes(path.Join(cacheFolder, "notafile.json"))
+	assert.Error(t, err)
+}
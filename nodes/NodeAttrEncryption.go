@@ -0,0 +1,80 @@
+package nodes
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// EncryptNodeAttr returns a copy of the node for publication in which the private attributes are
+// moved from Attr into EncryptedAttr. EncryptedAttr is only readable by the given readers. The
+// remaining attributes stay publicly readable.
+// If there are no readers then the private attributes are omitted altogether.
+// The original node is not modified.
+func EncryptNodeAttr(node *types.NodeDiscoveryMessage,
+	privateAttr []types.NodeAttr, readerKeys []*ecdsa.PublicKey) (*types.NodeDiscoveryMessage, error) {
+
+	newNode := *node
+	newNode.Attr = make(types.NodeAttrMap)
+	newNode.EncryptedAttr = ""
+	for key, value := range node.Attr {
+		newNode.Attr[key] = value
+	}
+	secretAttr := make(types.NodeAttrMap)
+	for _, attrName := range privateAttr {
+		value, exists := newNode.Attr[attrName]
+		if exists {
+			secretAttr[attrName] = value
+			delete(newNode.Attr, attrName)
+		}
+	}
+	if len(secretAttr) == 0 || len(readerKeys) == 0 {
+		return &newNode, nil
+	}
+	jsonAttr, _ := json.Marshal(secretAttr)
+	serialized, err := messaging.EncryptMessageMulti(string(jsonAttr), readerKeys)
+	if err != nil {
+		return &newNode, lib.MakeErrorf("EncryptNodeAttr: Failed encrypting attributes of node %s: %s", node.Address, err)
+	}
+	newNode.EncryptedAttr = serialized
+	return &newNode, nil
+}
+
+// DecryptNodeAttr returns a copy of the node in which the encrypted attributes are decrypted with
+// the given private key and merged into Attr. EncryptedAttr is retained.
+// This returns an error if the node has no encrypted attributes or the key isn't an authorized reader.
+func DecryptNodeAttr(node *types.NodeDiscoveryMessage, privateKey *ecdsa.PrivateKey) (*types.NodeDiscoveryMessage, error) {
+	return decryptNodeAttr(node, func(serialized string) (string, error) {
+		return messaging.DecryptMultiMessage(serialized, privateKey)
+	})
+}
+
+// decryptNodeAttr decrypts the node attributes using the given decrypt function
+func decryptNodeAttr(node *types.NodeDiscoveryMessage,
+	decrypt func(serialized string) (string, error)) (*types.NodeDiscoveryMessage, error) {
+
+	if node.EncryptedAttr == "" {
+		return node, lib.MakeErrorf("DecryptNodeAttr: Node %s has no encrypted attributes", node.Address)
+	}
+	message, err := decrypt(node.EncryptedAttr)
+	if err != nil {
+		return node, lib.MakeErrorf("DecryptNodeAttr: Unable to decrypt attributes of node %s: %s", node.Address, err)
+	}
+	secretAttr := make(types.NodeAttrMap)
+	err = json.Unmarshal([]byte(message), &secretAttr)
+	if err != nil {
+		return node, lib.MakeErrorf("DecryptNodeAttr: Invalid encrypted attributes of node %s: %s", node.Address, err)
+	}
+	newNode := *node
+	newNode.Attr = make(types.NodeAttrMap)
+	for key, value := range node.Attr {
+		newNode.Attr[key] = value
+	}
+	for key, value := range secretAttr {
+		newNode.Attr[key] = value
+	}
+	return &newNode, nil
+}
@@ -0,0 +1,79 @@
+package nodes_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptNodeAttr(t *testing.T) {
+	const domain = "test"
+	const publisherID = "pub1"
+	const nodeID = "node1"
+	readerKey := messaging.CreateAsymKeys()
+	otherKey := messaging.CreateAsymKeys()
+	privateAttr := []types.NodeAttr{types.NodeAttrLocationName}
+
+	node := nodes.NewNode(domain, publisherID, nodeID, types.NodeTypeAdapter)
+	node.Attr[types.NodeAttrName] = "bob"
+	node.Attr[types.NodeAttrLocationName] = "bob's home"
+
+	// encrypted attributes are removed from the public attributes
+	pubNode, err := nodes.EncryptNodeAttr(node, privateAttr, []*ecdsa.PublicKey{&readerKey.PublicKey})
+	require.NoError(t, err)
+	assert.Equal(t, "bob", pubNode.Attr[types.NodeAttrName])
+	assert.Empty(t, pubNode.Attr[types.NodeAttrLocationName])
+	assert.NotEmpty(t, pubNode.EncryptedAttr)
+	// original node remains unchanged
+	assert.Equal(t, "bob's home", node.Attr[types.NodeAttrLocationName])
+
+	// authorized reader can decrypt
+	readNode, err := nodes.DecryptNodeAttr(pubNode, readerKey)
+	require.NoError(t, err)
+	assert.Equal(t, "bob's home", readNode.Attr[types.NodeAttrLocationName])
+	assert.Equal(t, "bob", readNode.Attr[types.NodeAttrName])
+
+	// others can't
+	_, err = nodes.DecryptNodeAttr(pubNode, otherKey)
+	assert.Error(t, err)
+
+	// without readers the private attributes are omitted
+	pubNode, err = nodes.EncryptNodeAttr(node, privateAttr, nil)
+	require.NoError(t, err)
+	assert.Empty(t, pubNode.Attr[types.NodeAttrLocationName])
+	assert.Empty(t, pubNode.EncryptedAttr)
+	_, err = nodes.DecryptNodeAttr(pubNode, readerKey)
+	assert.Error(t, err)
+}
+
+func TestDiscoverEncryptedNodeAttr(t *testing.T) {
+	const domain = "test"
+	const publisherID = "pub2"
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	collection := nodes.NewDomainNodes(signer)
+	collection.Subscribe(domain, "+")
+
+	node := nodes.NewNode(domain, publisherID, "node1", types.NodeTypeAdapter)
+	node.Attr[types.NodeAttrLocationName] = "secret place"
+	pubNode, err := nodes.EncryptNodeAttr(node,
+		[]types.NodeAttr{types.NodeAttrLocationName}, []*ecdsa.PublicKey{&privKey.PublicKey})
+	require.NoError(t, err)
+	nodeAsBytes, _ := json.Marshal(pubNode)
+	messenger.Publish(pubNode.Address, false, string(nodeAsBytes))
+
+	discoNode := collection.GetNodeByAddress(pubNode.Address)
+	require.NotNil(t, discoNode)
+	assert.Equal(t, "secret place", discoNode.Attr[types.NodeAttrLocationName])
+	collection.Unsubscribe(domain, "+")
+}
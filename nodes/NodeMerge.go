@@ -0,0 +1,65 @@
+package nodes
+
+import (
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// NodeMergePolicy determines how a discovered node is merged with an existing node that has the same
+// hardware ID but a different node ID
+type NodeMergePolicy string
+
+// Node merge policies
+const (
+	// NodeMergeKeepExisting keeps the existing node and discards the discovered node
+	NodeMergeKeepExisting NodeMergePolicy = "keep-existing"
+	// NodeMergeReplace replaces the existing node with the discovered node. The existing node is
+	// published as removed.
+	NodeMergeReplace NodeMergePolicy = "replace"
+	// NodeMergeAttrs keeps the existing node ID and configuration values and merges the attributes,
+	// status and configuration of the discovered node into the existing node
+	NodeMergeAttrs NodeMergePolicy = "merge-attrs"
+)
+
+// DefaultNodeMergePolicy is the merge policy used when none is set
+const DefaultNodeMergePolicy = NodeMergeReplace
+
+// NodeConflictHandler is invoked when a discovered node has the same hardware ID as an existing node
+// but a different node ID. It returns the policy to apply to this conflict, or "" to apply the
+// configured policy.
+// The handler must not modify the registered nodes.
+type NodeConflictHandler func(existing *types.NodeDiscoveryMessage, discovered *types.NodeDiscoveryMessage) NodeMergePolicy
+
+// MergeNodeAttrs returns a new node with the node ID of the existing node and the attributes, status
+// and configuration of the discovered node merged into it. Values of the discovered node take precedence,
+// except for configured attribute values of the existing node.
+func MergeNodeAttrs(existing *types.NodeDiscoveryMessage, discovered *types.NodeDiscoveryMessage) *types.NodeDiscoveryMessage {
+	merged := *existing
+	merged.Attr = make(map[types.NodeAttr]string)
+	for key, value := range existing.Attr {
+		merged.Attr[key] = value
+	}
+	for key, value := range discovered.Attr {
+		// keep the configured value of the existing node
+		if _, isConfig := existing.Config[key]; isConfig {
+			if _, hasValue := existing.Attr[key]; hasValue {
+				continue
+			}
+		}
+		merged.Attr[key] = value
+	}
+	merged.Status = make(map[types.NodeStatus]string)
+	for key, value := range existing.Status {
+		merged.Status[key] = value
+	}
+	for key, value := range discovered.Status {
+		merged.Status[key] = value
+	}
+	merged.Config = make(map[types.NodeAttr]types.ConfigAttr)
+	for key, config := range existing.Config {
+		merged.Config[key] = config
+	}
+	for key, config := range discovered.Config {
+		merged.Config[key] = config
+	}
+	return &merged
+}
@@ -0,0 +1,92 @@
+package nodes_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mergeHWID = "device1"
+
+// create a collection with an existing node and a rediscovered node with a different node ID
+func setupMerge() (*nodes.RegisteredNodes, *types.NodeDiscoveryMessage) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode(mergeHWID, types.NodeTypeUnknown)
+	collection.UpdateNodeConfig(mergeHWID, types.NodeAttrName, &types.ConfigAttr{DataType: types.DataTypeString})
+	collection.UpdateNodeAttr(mergeHWID, map[types.NodeAttr]string{
+		types.NodeAttrName: "kitchen", types.NodeAttrManufacturer: "bob"})
+	collection.GetUpdatedNodes(true)
+
+	discovered := nodes.NewNode(domain, publisher1ID, "newID", types.NodeTypeUnknown)
+	discovered.HWID = mergeHWID
+	discovered.Attr[types.NodeAttrName] = "garage"
+	discovered.Attr[types.NodeAttrManufacturer] = "alice"
+	discovered.Attr[types.NodeAttrModel] = "x1"
+	return collection, discovered
+}
+
+func TestMergeReplace(t *testing.T) {
+	collection, discovered := setupMerge()
+	collection.UpdateNodes([]*types.NodeDiscoveryMessage{discovered})
+
+	assert.Equal(t, 1, len(collection.GetAllNodes()), "Expected no duplicate node")
+	node := collection.GetNodeByHWID(mergeHWID)
+	require.NotNil(t, node)
+	assert.Equal(t, "newID", node.NodeID)
+	assert.Nil(t, collection.GetNodeByNodeID(mergeHWID))
+	// the old address is published as removed
+	updated := collection.GetUpdatedNodes(true)
+	assert.Equal(t, 2, len(updated))
+}
+
+func TestMergeKeepExisting(t *testing.T) {
+	collection, discovered := setupMerge()
+	collection.SetMergePolicy(nodes.NodeMergeKeepExisting, nil)
+	collection.UpdateNodes([]*types.NodeDiscoveryMessage{discovered})
+
+	assert.Equal(t, 1, len(collection.GetAllNodes()))
+	node := collection.GetNodeByHWID(mergeHWID)
+	require.NotNil(t, node)
+	assert.Equal(t, mergeHWID, node.NodeID)
+	assert.Equal(t, "bob", node.Attr[types.NodeAttrManufacturer])
+	assert.Equal(t, 0, len(collection.GetUpdatedNodes(true)))
+}
+
+func TestMergeAttrs(t *testing.T) {
+	collection, discovered := setupMerge()
+	collection.SetMergePolicy(nodes.NodeMergeAttrs, nil)
+	collection.UpdateNodes([]*types.NodeDiscoveryMessage{discovered})
+
+	assert.Equal(t, 1, len(collection.GetAllNodes()))
+	node := collection.GetNodeByHWID(mergeHWID)
+	require.NotNil(t, node)
+	assert.Equal(t, mergeHWID, node.NodeID)
+	// configured values are kept, other attributes are updated
+	assert.Equal(t, "kitchen", node.Attr[types.NodeAttrName])
+	assert.Equal(t, "alice", node.Attr[types.NodeAttrManufacturer])
+	assert.Equal(t, "x1", node.Attr[types.NodeAttrModel])
+}
+
+func TestMergeConflictHandler(t *testing.T) {
+	collection, discovered := setupMerge()
+	var conflicts = 0
+	collection.SetMergePolicy(nodes.NodeMergeReplace,
+		func(existing *types.NodeDiscoveryMessage, discovered *types.NodeDiscoveryMessage) nodes.NodeMergePolicy {
+			conflicts++
+			assert.Equal(t, mergeHWID, existing.NodeID)
+			assert.Equal(t, "newID", discovered.NodeID)
+			return nodes.NodeMergeKeepExisting
+		})
+	collection.UpdateNodes([]*types.NodeDiscoveryMessage{discovered})
+	assert.Equal(t, 1, conflicts)
+	node := collection.GetNodeByHWID(mergeHWID)
+	require.NotNil(t, node)
+	assert.Equal(t, mergeHWID, node.NodeID)
+
+	// no conflict if the node ID is the same
+	collection.UpdateNodes([]*types.NodeDiscoveryMessage{node})
+	assert.Equal(t, 1, conflicts)
+}
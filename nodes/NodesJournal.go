@@ -0,0 +1,244 @@
+// Package nodes with incremental persistence of registered nodes
+package nodes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// NodesJournalSuffix is appended to the name of the nodes file to get the name of its journal
+const NodesJournalSuffix = ".journal"
+
+// NodesJournalMinEntries is the number of journal entries below which the journal is not compacted
+const NodesJournalMinEntries = 100
+
+// nodesJournalEntry is a line in the journal of a nodes file. The first line of a journal is a header
+// with the hash of the nodes file it applies to. The other lines contain a changed node, or no node
+// if the node is removed from the file.
+type nodesJournalEntry struct {
+	Base string                      `json:"base,omitempty"` // header with the hash of the nodes file
+	HWID string                      `json:"hwId,omitempty"` // hardware ID of the changed node
+	Node *types.NodeDiscoveryMessage `json:"node,omitempty"` // the changed node, nil when removed
+}
+
+// SaveChangedNodes saves the nodes that have changed since they were last saved or loaded.
+// Instead of rewriting the nodes file, the changes are appended to its journal, <filename>.journal.
+// The journal is compacted into the nodes file when it holds more entries than there are nodes,
+// with a minimum of NodesJournalMinEntries. If the nodes file was not loaded or saved before, all
+// nodes are saved with SaveNodes.
+func (regNodes *RegisteredNodes) SaveChangedNodes(filename string) error {
+	regNodes.saveMutex.Lock()
+	defer regNodes.saveMutex.Unlock()
+	if regNodes.savedHash == "" || regNodes.savedFilename != filename {
+		return regNodes.compactNodes(filename)
+	}
+	// nodes are marshalled under the lock as they can be updated once it is released
+	regNodes.updateMutex.Lock()
+	changes := regNodes.takeChanges()
+	changesText, err := marshalChanges(changes)
+	nodeCount := len(regNodes.deviceMap) + len(regNodes.deletedMap)
+	regNodes.updateMutex.Unlock()
+	if err != nil {
+		regNodes.markChanged(changes)
+		return lib.MakeErrorf("SaveChangedNodes: Error marshalling changes of %s: %s", filename, err)
+	} else if len(changes) == 0 {
+		return nil
+	}
+	err = regNodes.appendJournal(filename+NodesJournalSuffix, changes, changesText)
+	if err == nil && regNodes.journalEntries > nodeCount && regNodes.journalEntries > NodesJournalMinEntries {
+		err = regNodes.compactNodes(filename)
+	}
+	return err
+}
+
+// appendJournal appends the marshalled changes to the journal of the nodes file. A new journal
+// starts with a header that ties it to the saved nodes file.
+// Changes that fail to save remain marked as changed.
+//  changes are the journal entries that are appended
+//  changesText holds the marshalled changes, one per line
+//  Use with the save mutex locked.
+func (regNodes *RegisteredNodes) appendJournal(journalFilename string, changes []nodesJournalEntry, changesText []byte) error {
+	// each batch starts on a new line so a line torn by a power failure doesn't corrupt the next entry
+	buffer := bytes.NewBufferString("\n")
+	entryCount := len(changes)
+	if regNodes.journalEntries == 0 {
+		header, _ := marshalChanges([]nodesJournalEntry{{Base: regNodes.savedHash}})
+		buffer.Write(header)
+		entryCount++
+	}
+	buffer.Write(changesText)
+	file, err := os.OpenFile(journalFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err == nil {
+		_, err = file.Write(buffer.Bytes())
+		if err == nil {
+			err = file.Sync()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		regNodes.markChanged(changes)
+		return lib.MakeErrorf("SaveChangedNodes: Error appending to journal %s: %s", journalFilename, err)
+	}
+	regNodes.journalEntries += entryCount
+	return nil
+}
+
+// compactNodes saves all nodes to the nodes file and removes its journal. The file is written with
+// lib.WriteFileAtomic, so an interrupted save leaves the previous file intact. A journal that
+// remains after an interrupted removal no longer matches the file and is ignored when loading.
+//  Use with the save mutex locked.
+func (regNodes *RegisteredNodes) compactNodes(filename string) error {
+	regNodes.updateMutex.Lock()
+	changes := regNodes.takeChanges()
+	collection := make([]*types.NodeDiscoveryMessage, 0, len(regNodes.deviceMap)+len(regNodes.deletedMap))
+	for _, node := range regNodes.deviceMap {
+		if !node.LocalOnly {
+			collection = append(collection, node)
+		}
+	}
+	for _, node := range regNodes.deletedMap {
+		if !node.LocalOnly {
+			collection = append(collection, node)
+		}
+	}
+	// nodes are marshalled under the lock as they can be updated once it is released
+	jsonText, err := json.MarshalIndent(collection, "", "  ")
+	regNodes.updateMutex.Unlock()
+	if err != nil {
+		regNodes.markChanged(changes)
+		return lib.MakeErrorf("SaveNodes: Error Marshalling JSON collection '%s': %v", filename, err)
+	}
+	err = lib.WriteFileAtomic(filename, jsonText, 0664)
+	if err != nil {
+		regNodes.markChanged(changes)
+		return lib.MakeErrorf("SaveNodes: Error saving collection to JSON file %s: %v", filename, err)
+	}
+	regNodes.savedFilename = filename
+	regNodes.savedHash = makeFileHash(jsonText)
+	regNodes.journalEntries = 0
+	err = os.Remove(filename + NodesJournalSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		logrus.Warningf("SaveNodes: Unable to remove journal of %s: %s", filename, err)
+	}
+	return nil
+}
+
+// marshalChanges returns the JSON of the journal entries, one entry per line
+func marshalChanges(changes []nodesJournalEntry) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	for _, change := range changes {
+		jsonText, err := json.Marshal(change)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(jsonText)
+		buffer.WriteByte('\n')
+	}
+	return buffer.Bytes(), nil
+}
+
+// markChanged marks the nodes of journal entries as changed, so they are saved again
+func (regNodes *RegisteredNodes) markChanged(changes []nodesJournalEntry) {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+	for _, change := range changes {
+		if change.HWID != "" {
+			regNodes.changedNodes[change.HWID] = true
+		}
+	}
+}
+
+// takeChanges returns the journal entries of the nodes that have changed since they were last
+// saved and clears their changed state. Local-only and purged nodes are saved as removed.
+//  Use within a locked section.
+func (regNodes *RegisteredNodes) takeChanges() []nodesJournalEntry {
+	changes := make([]nodesJournalEntry, 0, len(regNodes.changedNodes))
+	for hwID := range regNodes.changedNodes {
+		node := regNodes.deviceMap[hwID]
+		if node == nil {
+			node = regNodes.deletedMap[hwID]
+		}
+		if node != nil && node.LocalOnly {
+			node = nil
+		}
+		changes = append(changes, nodesJournalEntry{HWID: hwID, Node: node})
+	}
+	regNodes.changedNodes = make(map[string]bool)
+	return changes
+}
+
+// makeFileHash returns the hash of the content of a file
+func makeFileHash(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+// replayJournal applies the journal of a nodes file to the list of nodes loaded from the file.
+// Entries that can't be parsed, eg a line torn by a power failure, are skipped. Entries that follow
+// a header of another nodes file, because the file was replaced or compacted, are ignored.
+// Returns the updated list of nodes, the number of entries in the journal and the number of
+// entries that are applied.
+func replayJournal(journalFilename string, fileHash string, nodeList []*types.NodeDiscoveryMessage) (
+	replayed []*types.NodeDiscoveryMessage, entryCount int, appliedCount int) {
+
+	journalText, err := ioutil.ReadFile(journalFilename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Warningf("LoadNodes: Unable to read journal %s: %s", journalFilename, err)
+		}
+		return nodeList, 0, 0
+	}
+	hwIDs := make([]string, 0, len(nodeList))
+	nodesByHWID := make(map[string]*types.NodeDiscoveryMessage)
+	for _, node := range nodeList {
+		if node == nil {
+			continue
+		}
+		if _, found := nodesByHWID[node.HWID]; !found {
+			hwIDs = append(hwIDs, node.HWID)
+		}
+		nodesByHWID[node.HWID] = node
+	}
+	isMatching := false
+	for _, line := range bytes.Split(journalText, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		entry := nodesJournalEntry{}
+		err = json.Unmarshal(line, &entry)
+		if err != nil || (entry.Base == "" && entry.HWID == "") {
+			logrus.Warningf("LoadNodes: Skipping invalid entry in journal %s", journalFilename)
+			continue
+		}
+		entryCount++
+		if entry.Base != "" {
+			isMatching = entry.Base == fileHash
+			continue
+		} else if !isMatching {
+			continue
+		}
+		appliedCount++
+		if _, found := nodesByHWID[entry.HWID]; !found {
+			hwIDs = append(hwIDs, entry.HWID)
+		}
+		nodesByHWID[entry.HWID] = entry.Node
+	}
+	replayed = make([]*types.NodeDiscoveryMessage, 0, len(hwIDs))
+	for _, hwID := range hwIDs {
+		if node := nodesByHWID[hwID]; node != nil {
+			replayed = append(replayed, node)
+		}
+	}
+	logrus.Infof("LoadNodes: Replayed %d of %d entries from journal %s", appliedCount, entryCount, journalFilename)
+	return replayed, entryCount, appliedCount
+}
@@ -0,0 +1,172 @@
+package nodes_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveChangedNodes(t *testing.T) {
+	const node2ID = "node2"
+	const node3ID = "node3"
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	filename := path.Join(folder, "nodes.json")
+	journalFilename := filename + nodes.NodesJournalSuffix
+
+	// the first save writes the nodes file
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode(node1ID, types.NodeTypeUnknown)
+	collection.CreateNode(node2ID, types.NodeTypeUnknown)
+	err = collection.SaveChangedNodes(filename)
+	require.NoError(t, err)
+	assert.FileExists(t, filename)
+	_, err = os.Stat(journalFilename)
+	assert.True(t, os.IsNotExist(err))
+	fileInfo, _ := os.Stat(filename)
+
+	// changes are appended to the journal, the nodes file is unchanged
+	collection.UpdateNodeAttr(node1ID, map[types.NodeAttr]string{types.NodeAttrName: "bob"})
+	collection.CreateNode(node3ID, types.NodeTypeUnknown)
+	collection.DeleteNode(node2ID)
+	err = collection.SaveChangedNodes(filename)
+	require.NoError(t, err)
+	journal, err := ioutil.ReadFile(journalFilename)
+	require.NoError(t, err)
+	assert.Equal(t, 4, strings.Count(strings.TrimSpace(string(journal)), "\n")+1, "header and 3 changes")
+	fileInfo2, _ := os.Stat(filename)
+	assert.Equal(t, fileInfo.ModTime(), fileInfo2.ModTime())
+
+	// without changes nothing is saved
+	err = collection.SaveChangedNodes(filename)
+	require.NoError(t, err)
+	journal2, _ := ioutil.ReadFile(journalFilename)
+	assert.Equal(t, journal, journal2)
+
+	// loading replays the journal
+	collection2 := nodes.NewRegisteredNodes(domain, publisher1ID)
+	err = collection2.LoadNodes(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", collection2.GetNodeAttr(node1ID, types.NodeAttrName))
+	assert.NotNil(t, collection2.GetNodeByHWID(node3ID))
+	assert.Nil(t, collection2.GetNodeByHWID(node2ID))
+	assert.True(t, collection2.IsDeleted(node2ID))
+
+	// loaded nodes are not saved again
+	err = collection2.SaveChangedNodes(filename)
+	require.NoError(t, err)
+	journal2, _ = ioutil.ReadFile(journalFilename)
+	assert.Equal(t, journal, journal2)
+
+	// purged and local-only nodes are removed
+	collection2.PurgeDeletedNodes(0)
+	collection2.SetLocalOnly(node3ID, true)
+	err = collection2.SaveChangedNodes(filename)
+	require.NoError(t, err)
+	collection3 := nodes.NewRegisteredNodes(domain, publisher1ID)
+	err = collection3.LoadNodes(filename)
+	require.NoError(t, err)
+	assert.Nil(t, collection3.GetNodeByHWID(node3ID))
+	assert.False(t, collection3.IsDeleted(node2ID))
+	assert.NotNil(t, collection3.GetNodeByHWID(node1ID))
+
+	// saving all nodes compacts the journal
+	err = collection2.SaveNodes(filename)
+	require.NoError(t, err)
+	_, err = os.Stat(journalFilename)
+	assert.True(t, os.IsNotExist(err))
+	collection3 = nodes.NewRegisteredNodes(domain, publisher1ID)
+	err = collection3.LoadNodes(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", collection3.GetNodeAttr(node1ID, types.NodeAttrName))
+	assert.Nil(t, collection3.GetNodeByHWID(node3ID))
+}
+
+func TestCompactNodesJournal(t *testing.T) {
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	filename := path.Join(folder, "nodes.json")
+	journalFilename := filename + nodes.NodesJournalSuffix
+
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode(node1ID, types.NodeTypeUnknown)
+	err = collection.SaveChangedNodes(filename)
+	require.NoError(t, err)
+
+	// the journal is compacted when it grows larger than the minimum, including its header
+	for i := 1; i < nodes.NodesJournalMinEntries; i++ {
+		collection.UpdateNodeStatus(node1ID, map[types.NodeStatus]string{
+			types.NodeStatusLastSeen: time.Duration(i).String()})
+		err = collection.SaveChangedNodes(filename)
+		require.NoError(t, err)
+		assert.FileExists(t, journalFilename)
+	}
+	collection.UpdateNodeStatus(node1ID, map[types.NodeStatus]string{types.NodeStatusLastSeen: "last"})
+	err = collection.SaveChangedNodes(filename)
+	require.NoError(t, err)
+	_, err = os.Stat(journalFilename)
+	assert.True(t, os.IsNotExist(err))
+
+	collection2 := nodes.NewRegisteredNodes(domain, publisher1ID)
+	err = collection2.LoadNodes(filename)
+	require.NoError(t, err)
+	node := collection2.GetNodeByHWID(node1ID)
+	require.NotNil(t, node)
+	assert.Equal(t, "last", node.Status[types.NodeStatusLastSeen])
+}
+
+func TestNodesJournalRecovery(t *testing.T) {
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	filename := path.Join(folder, "nodes.json")
+	journalFilename := filename + nodes.NodesJournalSuffix
+
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode(node1ID, types.NodeTypeUnknown)
+	err = collection.SaveChangedNodes(filename)
+	require.NoError(t, err)
+	collection.UpdateNodeAttr(node1ID, map[types.NodeAttr]string{types.NodeAttrName: "bob"})
+	err = collection.SaveChangedNodes(filename)
+	require.NoError(t, err)
+
+	// an entry torn by a power failure is skipped
+	journalFile, err := os.OpenFile(journalFilename, os.O_APPEND|os.O_WRONLY, 0664)
+	require.NoError(t, err)
+	journalFile.WriteString(`{"hwId":"node1","node":{"addr`)
+	journalFile.Close()
+	collection.UpdateNodeAttr(node1ID, map[types.NodeAttr]string{types.NodeAttrName: "alice"})
+	err = collection.SaveChangedNodes(filename)
+	require.NoError(t, err)
+	collection2 := nodes.NewRegisteredNodes(domain, publisher1ID)
+	err = collection2.LoadNodes(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", collection2.GetNodeAttr(node1ID, types.NodeAttrName))
+
+	// the journal of a replaced nodes file is ignored and removed
+	otherNodes := nodes.NewRegisteredNodes(domain, publisher1ID)
+	otherNodes.CreateNode("node5", types.NodeTypeUnknown)
+	otherFilename := path.Join(folder, "other.json")
+	err = otherNodes.SaveNodes(otherFilename)
+	require.NoError(t, err)
+	otherText, _ := ioutil.ReadFile(otherFilename)
+	err = ioutil.WriteFile(filename, otherText, 0664)
+	require.NoError(t, err)
+	collection3 := nodes.NewRegisteredNodes(domain, publisher1ID)
+	err = collection3.LoadNodes(filename)
+	require.NoError(t, err)
+	assert.Nil(t, collection3.GetNodeByHWID(node1ID))
+	assert.NotNil(t, collection3.GetNodeByHWID("node5"))
+	_, err = os.Stat(journalFilename)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,193 @@
+package nodes
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PseudonymLength is the number of hex characters of a pseudonym
+const PseudonymLength = 16
+
+// PseudonymSecretSize is the number of random bytes of a generated pseudonym secret
+const PseudonymSecretSize = 32
+
+// Pseudonyms generates stable pseudonyms for node IDs and attribute values and keeps the mapping
+// to the original values locally. Pseudonyms are a keyed hash of the original value. The same
+// value and secret always result in the same pseudonym.
+type Pseudonyms struct {
+	secret      []byte            // secret key for hashing
+	mapping     map[string]string // original values by pseudonym
+	updated     bool              // the mapping has new entries
+	updateMutex *sync.Mutex       // mutex for async updating of the mapping
+}
+
+// GetMapping returns a copy of the mapping of pseudonyms to their original value
+func (pseudonyms *Pseudonyms) GetMapping() map[string]string {
+	pseudonyms.updateMutex.Lock()
+	defer pseudonyms.updateMutex.Unlock()
+	mapping := make(map[string]string)
+	for key, value := range pseudonyms.mapping {
+		mapping[key] = value
+	}
+	return mapping
+}
+
+// IsUpdated returns true if new pseudonyms were added since the last time updates were cleared
+func (pseudonyms *Pseudonyms) IsUpdated(clearUpdates bool) bool {
+	pseudonyms.updateMutex.Lock()
+	defer pseudonyms.updateMutex.Unlock()
+	updated := pseudonyms.updated
+	if clearUpdates {
+		pseudonyms.updated = false
+	}
+	return updated
+}
+
+// Pseudonym returns the pseudonym of the given value and adds it to the mapping
+// An empty value remains empty.
+func (pseudonyms *Pseudonyms) Pseudonym(value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, pseudonyms.secret)
+	mac.Write([]byte(value))
+	pseudonym := hex.EncodeToString(mac.Sum(nil))[:PseudonymLength]
+
+	pseudonyms.updateMutex.Lock()
+	defer pseudonyms.updateMutex.Unlock()
+	if _, exists := pseudonyms.mapping[pseudonym]; !exists {
+		pseudonyms.mapping[pseudonym] = value
+		pseudonyms.updated = true
+	}
+	return pseudonym
+}
+
+// PseudonymizeNode returns a copy of the node for publication with the hardware ID and the
+// given attributes replaced by their pseudonym. The original node is not modified.
+func (pseudonyms *Pseudonyms) PseudonymizeNode(
+	node *types.NodeDiscoveryMessage, attrNames []types.NodeAttr) *types.NodeDiscoveryMessage {
+
+	newNode := *node
+	newNode.HWID = pseudonyms.Pseudonym(node.HWID)
+	newNode.Attr = make(types.NodeAttrMap)
+	for key, value := range node.Attr {
+		newNode.Attr[key] = value
+	}
+	for _, attrName := range attrNames {
+		value, exists := newNode.Attr[attrName]
+		if exists {
+			newNode.Attr[attrName] = pseudonyms.Pseudonym(value)
+		}
+	}
+	return &newNode
+}
+
+// Resolve returns the original value of a pseudonym
+// This returns false if the pseudonym is not known
+func (pseudonyms *Pseudonyms) Resolve(pseudonym string) (value string, exists bool) {
+	pseudonyms.updateMutex.Lock()
+	defer pseudonyms.updateMutex.Unlock()
+	value, exists = pseudonyms.mapping[pseudonym]
+	return value, exists
+}
+
+// DecryptPseudonyms decrypts the pseudonym mapping from a received pseudonyms message
+// This returns an error if the private key is not of an authorized consumer
+func DecryptPseudonyms(message *types.PseudonymsMessage, privateKey *ecdsa.PrivateKey) (map[string]string, error) {
+	mapping := make(map[string]string)
+	jsonMapping, err := messaging.DecryptMultiMessage(message.Mapping, privateKey)
+	if err != nil {
+		return mapping, lib.MakeErrorf("DecryptPseudonyms: Unable to decrypt mapping from %s: %s", message.Address, err)
+	}
+	err = json.Unmarshal([]byte(jsonMapping), &mapping)
+	if err != nil {
+		return mapping, lib.MakeErrorf("DecryptPseudonyms: Invalid mapping from %s: %s", message.Address, err)
+	}
+	return mapping, nil
+}
+
+// LoadPseudonymSecret loads the secret for generating pseudonyms from file. A random secret is
+// generated and saved if the file doesn't exist. The secret is independent of the identity keys, so
+// pseudonyms don't change when the identity is renewed and can't be derived from a leaked key.
+//  filename is the file that holds the hex encoded secret
+// Returns the secret, or a random secret that isn't saved with an error if the file can't be used.
+func LoadPseudonymSecret(filename string) ([]byte, error) {
+	hexSecret, err := lib.ReadFileWithRecovery(filename, validatePseudonymSecret)
+	if err == nil {
+		secret, _ := hex.DecodeString(string(hexSecret))
+		return secret, nil
+	}
+	secret := make([]byte, PseudonymSecretSize)
+	_, randErr := rand.Read(secret)
+	if randErr != nil {
+		return secret, lib.MakeErrorf("LoadPseudonymSecret: Unable to generate a secret: %s", randErr)
+	} else if !os.IsNotExist(err) {
+		return secret, lib.MakeErrorf("LoadPseudonymSecret: Invalid secret in %s: %s", filename, err)
+	}
+	logrus.Infof("LoadPseudonymSecret: Generating a new pseudonym secret in %s", filename)
+	err = lib.WriteFileAtomic(filename, []byte(hex.EncodeToString(secret)), 0400)
+	if err != nil {
+		return secret, lib.MakeErrorf("LoadPseudonymSecret: Unable to save the secret to %s: %s", filename, err)
+	}
+	return secret, nil
+}
+
+// MakePseudonymsAddress returns the address for publishing the pseudonym mapping of a publisher
+func MakePseudonymsAddress(domain string, publisherID string) string {
+	return fmt.Sprintf("%s/%s/%s", domain, publisherID, types.MessageTypePseudonyms)
+}
+
+// PublishPseudonyms publishes the pseudonym mapping encrypted for the given consumers.
+// The mapping is not published if there are no consumers.
+func PublishPseudonyms(address string, mapping map[string]string,
+	readerKeys []*ecdsa.PublicKey, messageSigner *messaging.MessageSigner) error {
+
+	if len(readerKeys) == 0 {
+		return nil
+	}
+	jsonMapping, _ := json.Marshal(mapping)
+	serialized, err := messaging.EncryptMessageMulti(string(jsonMapping), readerKeys)
+	if err != nil {
+		return lib.MakeErrorf("PublishPseudonyms: Failed encrypting mapping: %s", err)
+	}
+	logrus.Infof("PublishPseudonyms: publish %d pseudonyms on %s", len(mapping), address)
+	message := &types.PseudonymsMessage{
+		Address:   address,
+		Mapping:   serialized,
+		Timestamp: lib.Now().Format(types.TimeFormat),
+	}
+	return messageSigner.PublishObject(address, true, message, nil)
+}
+
+// validatePseudonymSecret returns an error if the content of a secret file isn't a hex encoded secret
+func validatePseudonymSecret(data []byte) error {
+	secret, err := hex.DecodeString(string(data))
+	if err != nil || len(secret) < PseudonymSecretSize {
+		return errors.New("not a hex encoded secret")
+	}
+	return nil
+}
+
+// NewPseudonyms creates a pseudonym generator using the given secret
+//  secret is the key for generating the pseudonyms. Use the same secret to get stable pseudonyms.
+func NewPseudonyms(secret []byte) *Pseudonyms {
+	pseudonyms := &Pseudonyms{
+		secret:      secret,
+		mapping:     make(map[string]string),
+		updateMutex: &sync.Mutex{},
+	}
+	return pseudonyms
+}
@@ -0,0 +1,107 @@
+package nodes_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPseudonyms(t *testing.T) {
+	pseudonyms := nodes.NewPseudonyms([]byte("secret1"))
+	p1 := pseudonyms.Pseudonym("node1")
+	assert.Len(t, p1, nodes.PseudonymLength)
+	assert.NotEqual(t, "node1", p1)
+	assert.True(t, pseudonyms.IsUpdated(true))
+	assert.False(t, pseudonyms.IsUpdated(true))
+
+	// pseudonyms are stable
+	assert.Equal(t, p1, pseudonyms.Pseudonym("node1"))
+	assert.False(t, pseudonyms.IsUpdated(false))
+	p1b := nodes.NewPseudonyms([]byte("secret1")).Pseudonym("node1")
+	assert.Equal(t, p1, p1b)
+	// but differ with a different secret
+	p1c := nodes.NewPseudonyms([]byte("secret2")).Pseudonym("node1")
+	assert.NotEqual(t, p1, p1c)
+	assert.Empty(t, pseudonyms.Pseudonym(""))
+
+	value, exists := pseudonyms.Resolve(p1)
+	assert.True(t, exists)
+	assert.Equal(t, "node1", value)
+	_, exists = pseudonyms.Resolve("notapseudonym")
+	assert.False(t, exists)
+
+	node := nodes.NewNode("test", "pub1", "node1", types.NodeTypeAdapter)
+	node.Attr[types.NodeAttrName] = "bob"
+	node.Attr[types.NodeAttrModel] = "model1"
+	pubNode := pseudonyms.PseudonymizeNode(node, []types.NodeAttr{types.NodeAttrName})
+	assert.Equal(t, p1, pubNode.HWID)
+	assert.Equal(t, pseudonyms.Pseudonym("bob"), pubNode.Attr[types.NodeAttrName])
+	assert.Equal(t, "model1", pubNode.Attr[types.NodeAttrModel])
+	assert.Equal(t, "bob", node.Attr[types.NodeAttrName])
+	assert.Len(t, pseudonyms.GetMapping(), 2)
+}
+
+func TestPublishPseudonyms(t *testing.T) {
+	readerKey := messaging.CreateAsymKeys()
+	otherKey := messaging.CreateAsymKeys()
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer := messaging.NewMessageSigner(messenger, readerKey, nil)
+	signer.SetSignMessages(false)
+	pseudonyms := nodes.NewPseudonyms([]byte("secret1"))
+	p1 := pseudonyms.Pseudonym("node1")
+
+	address := nodes.MakePseudonymsAddress("test", "pub1")
+	// no readers, no publication
+	err := nodes.PublishPseudonyms(address, pseudonyms.GetMapping(), nil, signer)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, messenger.NrPublications())
+
+	err = nodes.PublishPseudonyms(address, pseudonyms.GetMapping(),
+		[]*ecdsa.PublicKey{&readerKey.PublicKey}, signer)
+	require.NoError(t, err)
+	raw := messenger.FindLastPublication(address)
+	require.NotEmpty(t, raw)
+	msg := types.PseudonymsMessage{}
+	err = json.Unmarshal([]byte(raw), &msg)
+	require.NoError(t, err)
+
+	mapping, err := nodes.DecryptPseudonyms(&msg, readerKey)
+	require.NoError(t, err)
+	assert.Equal(t, "node1", mapping[p1])
+	_, err = nodes.DecryptPseudonyms(&msg, otherKey)
+	assert.Error(t, err)
+}
+
+func TestLoadPseudonymSecret(t *testing.T) {
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	filename := path.Join(folder, "pub1-pseudonyms.key")
+
+	// a new secret is generated and saved
+	secret, err := nodes.LoadPseudonymSecret(filename)
+	require.NoError(t, err)
+	assert.Len(t, secret, nodes.PseudonymSecretSize)
+	secret2, err := nodes.LoadPseudonymSecret(filename)
+	require.NoError(t, err)
+	assert.Equal(t, secret, secret2)
+
+	// error case - an invalid secret isn't replaced
+	filename = path.Join(folder, "pub2-pseudonyms.key")
+	err = ioutil.WriteFile(filename, []byte("notasecret"), 0600)
+	require.NoError(t, err)
+	secret, err = nodes.LoadPseudonymSecret(filename)
+	assert.Error(t, err)
+	assert.Len(t, secret, nodes.PseudonymSecretSize)
+	content, _ := ioutil.ReadFile(filename)
+	assert.Equal(t, "notasecret", string(content))
+}
@@ -3,38 +3,49 @@ package nodes
 
 import (
 	"crypto/ecdsa"
-	"strings"
-	"time"
 
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
 )
 
 // PublishNodeConfigure sends a command to update the configuration of a remote node.
-// If an encryption key is given then the signed message will be encrypted, otherwise just signed.
+// The signed message is encrypted with the given encryption key. If no key is given then the key
+// of the destination publisher is looked up. If no key is found the command is only sent signed
+// if command encryption is disabled in the message signer.
+// The message carries a new message ID so the receiver can ignore duplicate deliveries.
+// Returns an error if the address is invalid or the command can't be encrypted.
 func PublishNodeConfigure(
 	destinationAddress string, attr types.NodeAttrMap, sender string,
-	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) {
+	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) error {
+	return PublishNodeConfigureWithID(destinationAddress, attr, lib.MakeMessageID(), sender, messageSigner, encryptionKey)
+}
+
+// PublishNodeConfigureWithID sends a node configuration command with a message ID that requests the
+// receiving publisher to publish a $ack acknowledgement of the command. See also PublishNodeConfigure.
+func PublishNodeConfigureWithID(
+	destinationAddress string, attr types.NodeAttrMap, messageID string, sender string,
+	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) error {
 
 	logrus.Infof("PublishNodeConfigure: publishing encrypted configuration to %s", destinationAddress)
-	// Check that address is one of our inputs
-	segments := strings.Split(destinationAddress, "/")
+	destinationAddr, err := types.ParseAddress(destinationAddress)
 	// a full address is required
-	if len(segments) < 4 {
-		return
+	if err != nil || destinationAddr.NodeID() == "" {
+		return lib.MakeErrorf("PublishNodeConfigure: Destination address '%s' is incomplete", destinationAddress)
 	}
 	// domain/publisherID/nodeID/$configure
-	segments[3] = types.MessageTypeConfigure
-	configAddr := strings.Join(segments, "/")
+	configAddr := types.MakeNodeAddress(destinationAddr.Domain(), destinationAddr.PublisherID(),
+		destinationAddr.NodeID(), types.MessageTypeConfigure).String()
 
 	// Encecode the SetMessage
-	timeStampStr := time.Now().Format("2006-01-02T15:04:05.000-0700")
+	timeStampStr := lib.Now().Format("2006-01-02T15:04:05.000-0700")
 	var configureMessage = types.NodeConfigureMessage{
 		Address:   configAddr,
+		MessageID: messageID,
 		Sender:    sender,
 		Timestamp: timeStampStr,
 		Attr:      attr,
 	}
-	messageSigner.PublishObject(configAddr, false, &configureMessage, encryptionKey)
+	return messageSigner.PublishCommand(configAddr, &configureMessage, encryptionKey)
 }
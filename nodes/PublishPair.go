@@ -0,0 +1,49 @@
+// Package nodes with publication of pairing commands and status
+package nodes
+
+import (
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PublishPairCommand publishes a command to control the inclusion mode of a publisher
+//  action is one of types.PairActionStart, Stop, Adopt or Reject
+//  candidateID is the candidate to adopt or reject, "" for start and stop
+//  timeout is the inclusion mode duration in seconds for start, 0 for the publisher's default
+//  sender is the address of the sending publisher
+func PublishPairCommand(domain string, publisherID string, action string, candidateID string, timeout int,
+	sender string, messageSigner *messaging.MessageSigner) error {
+
+	addr := MakePairAddress(domain, publisherID)
+	logrus.Infof("PublishPairCommand: %s on %s", action, addr)
+	message := &types.PairMessage{
+		Action:      action,
+		Address:     addr,
+		CandidateID: candidateID,
+		Sender:      sender,
+		Timeout:     timeout,
+		Timestamp:   lib.Now().Format(types.TimeFormat),
+	}
+	return messageSigner.PublishObject(addr, false, message, nil)
+}
+
+// PublishPairStatus publishes the retained inclusion mode status and candidate devices of a publisher
+func PublishPairStatus(domain string, publisherID string, active bool, until time.Time,
+	candidates []types.PairCandidate, messageSigner *messaging.MessageSigner) error {
+
+	addr := MakePairStatusAddress(domain, publisherID)
+	message := &types.PairStatusMessage{
+		Active:     active,
+		Address:    addr,
+		Candidates: candidates,
+		Timestamp:  lib.Now().Format(types.TimeFormat),
+	}
+	if active {
+		message.Until = until.Format(types.TimeFormat)
+	}
+	return messageSigner.PublishObject(addr, true, message, nil)
+}
@@ -17,7 +17,7 @@ func PublishRegisteredNodes(
 	// publish updated nodes
 	for _, node := range updatedNodes {
 		if node != nil {
-			logrus.Infof("PublishRegisteredNodes: publish node discovery: %s", node.Address)
+			logrus.Infof("PublishRegisteredNodes: publish node discovery: %s, reasons: %v", node.Address, node.ChangeReasons)
 			messageSigner.PublishObject(node.Address, true, node, nil)
 		} else {
 			// node was deleted
@@ -3,7 +3,9 @@ package nodes
 
 import (
 	"crypto/ecdsa"
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
@@ -15,10 +17,16 @@ import (
 // The handler is only invoked if the node is confirmed to exist.
 type NodeConfigureHandler func(nodeHWID string, params types.NodeAttrMap)
 
+// NodeConfigChangedHandler is invoked for each configuration value that is changed by a configure
+// command after the configuration is applied
+type NodeConfigChangedHandler func(nodeHWID string, attrName types.NodeAttr, oldValue string, newValue string)
+
 // ReceiveNodeConfigure with handling of node configure commands aimed at nodes managed by this publisher.
 // This decrypts incoming messages determines the sender and verifies the signature with
 // the sender public key.
 type ReceiveNodeConfigure struct {
+	configChangedHandler NodeConfigChangedHandler // optional handler of changed configuration values
+	deduplicator         *lib.MessageDeduplicator // ignore duplicate deliveries of commands
 	domain               string                   // the domain of this publisher
 	publisherID          string                   // the registered publisher for the inputs
 	nodeConfigureHandler NodeConfigureHandler     // handler to pass the command to
@@ -28,12 +36,37 @@ type ReceiveNodeConfigure struct {
 	updateMutex          *sync.Mutex              // mutex for async handling of inputs
 }
 
+// ConfigureNode applies a configuration update to a registered node in the same way as a received
+// configure command, eg for local management of the publisher.
+// Returns an error if the node is not registered.
+func (nodeConfigure *ReceiveNodeConfigure) ConfigureNode(nodeHWID string, params types.NodeAttrMap) error {
+	node := nodeConfigure.registeredNodes.GetNodeByHWID(nodeHWID)
+	if node == nil {
+		return lib.MakeErrorf("ConfigureNode: Unknown node '%s'", nodeHWID)
+	}
+	nodeConfigure.applyConfiguration(node.HWID, params)
+	return nil
+}
+
+// SetConfigChangedHandler sets the handler that is invoked for each configuration value that is
+// changed after a configure command is applied. Use nil to remove the handler.
+func (nodeConfigure *ReceiveNodeConfigure) SetConfigChangedHandler(handler NodeConfigChangedHandler) {
+	nodeConfigure.updateMutex.Lock()
+	defer nodeConfigure.updateMutex.Unlock()
+	nodeConfigure.configChangedHandler = handler
+}
+
 // SetConfigureNodeHandler set the handler for updating node inputs
 func (nodeConfigure *ReceiveNodeConfigure) SetConfigureNodeHandler(
 	handler func(nodeHWID string, params types.NodeAttrMap)) {
 	nodeConfigure.nodeConfigureHandler = handler
 }
 
+// SetDedupWindow sets the time that duplicate configure commands are ignored
+func (nodeConfigure *ReceiveNodeConfigure) SetDedupWindow(window time.Duration) {
+	nodeConfigure.deduplicator.SetWindow(window)
+}
+
 // Start listening for configure commands
 func (nodeConfigure *ReceiveNodeConfigure) Start() {
 	nodeConfigure.updateMutex.Lock()
@@ -58,12 +91,16 @@ func (nodeConfigure *ReceiveNodeConfigure) Stop() {
 // - if a configuration handler is set, let it apply the configuration
 // - save node configuration if persistence is set
 // TODO: support for authorization per node
-func (nodeConfigure *ReceiveNodeConfigure) receiveConfigureCommand(nodeAddress string, message string) error {
+func (nodeConfigure *ReceiveNodeConfigure) receiveConfigureCommand(nodeAddress string, message string) (err error) {
 	var configureMessage types.NodeConfigureMessage
+	var isEncrypted, isSigned bool
+	defer func() {
+		nodeConfigure.messageSigner.AuditCommand(nodeAddress, configureMessage.Sender, isEncrypted, isSigned, err)
+	}()
 
-	isEncrypted, isSigned, err := nodeConfigure.messageSigner.DecodeMessage(message, &configureMessage)
+	isEncrypted, isSigned, err = nodeConfigure.messageSigner.DecodeCommand(message, &configureMessage)
 
-	if !isEncrypted {
+	if !isEncrypted && nodeConfigure.messageSigner.EncryptCommands() {
 		return lib.MakeErrorf("receiveConfigureCommand: Configuration update of '%s' is not encrypted. Message discarded.", nodeAddress)
 	} else if !isSigned {
 		return lib.MakeErrorf("receiveConfigureCommand: Configuration update of '%s' is not signed. Message discarded.", nodeAddress)
@@ -72,21 +109,54 @@ func (nodeConfigure *ReceiveNodeConfigure) receiveConfigureCommand(nodeAddress s
 	}
 
 	// TODO: authorization check
+	ackSender := nodeConfigure.domain + "/" + nodeConfigure.publisherID
 	node := nodeConfigure.registeredNodes.GetNodeByAddress(nodeAddress)
 	if node == nil || message == "" {
+		lib.PublishAck(nodeAddress, configureMessage.MessageID, ackSender, errors.New("unknown node"),
+			nodeConfigure.messageSigner)
 		return lib.MakeErrorf("receiveConfigureCommand unknown node for address %s or missing message", nodeAddress)
 	}
 	logrus.Infof("receiveConfigureCommand configure command on address %s. isEncrypted=%t, isSigned=%t", nodeAddress, isEncrypted, isSigned)
+	// a redelivered command is acknowledged again but not applied
+	if nodeConfigure.deduplicator.IsDuplicate(configureMessage.Sender, configureMessage.MessageID) {
+		logrus.Infof("receiveConfigureCommand: Ignored duplicate command %s to %s from sender %s",
+			configureMessage.MessageID, nodeAddress, configureMessage.Sender)
+		lib.PublishAck(nodeAddress, configureMessage.MessageID, ackSender, nil, nodeConfigure.messageSigner)
+		return nil
+	}
 
-	params := configureMessage.Attr
+	nodeConfigure.applyConfiguration(node.HWID, configureMessage.Attr)
+	lib.PublishAck(nodeAddress, configureMessage.MessageID, ackSender, nil, nodeConfigure.messageSigner)
+	return nil
+}
+
+// applyConfiguration passes the configuration update to the handler or applies it if no handler is set.
+// Afterwards the changed configuration values are passed to the config changed handler.
+func (nodeConfigure *ReceiveNodeConfigure) applyConfiguration(nodeHWID string, params types.NodeAttrMap) {
+	oldValues := make(map[types.NodeAttr]string)
+	for attrName := range params {
+		oldValues[attrName], _ = nodeConfigure.registeredNodes.GetNodeConfigString(nodeHWID, attrName, "")
+	}
 	if nodeConfigure.nodeConfigureHandler != nil {
 		// A handler can determine which configuration updates are applied
-		nodeConfigure.nodeConfigureHandler(node.HWID, params)
+		nodeConfigure.nodeConfigureHandler(nodeHWID, params)
 	} else {
 		// Without a handler apply the configuration update
-		nodeConfigure.registeredNodes.UpdateNodeConfigValues(node.HWID, params)
+		nodeConfigure.registeredNodes.UpdateNodeConfigValues(nodeHWID, params)
+	}
+
+	nodeConfigure.updateMutex.Lock()
+	changedHandler := nodeConfigure.configChangedHandler
+	nodeConfigure.updateMutex.Unlock()
+	if changedHandler == nil {
+		return
+	}
+	for attrName, oldValue := range oldValues {
+		newValue, _ := nodeConfigure.registeredNodes.GetNodeConfigString(nodeHWID, attrName, "")
+		if newValue != oldValue {
+			changedHandler(nodeHWID, attrName, oldValue, newValue)
+		}
 	}
-	return nil
 }
 
 // NewReceiveNodeConfigure returns a new instance of handling of node configuration commands.
@@ -98,6 +168,7 @@ func NewReceiveNodeConfigure(
 	registeredNodes *RegisteredNodes,
 	privateKey *ecdsa.PrivateKey) *ReceiveNodeConfigure {
 	sin := &ReceiveNodeConfigure{
+		deduplicator:         lib.NewMessageDeduplicator(),
 		domain:               domain,
 		messageSigner:        messageSigner,
 		nodeConfigureHandler: configHandler,
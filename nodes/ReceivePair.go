@@ -0,0 +1,100 @@
+// Package nodes with receiving of the pairing command
+package nodes
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PairHandler callback when a pairing command is received
+type PairHandler func(message *types.PairMessage)
+
+// ReceivePair listens for commands that control the inclusion mode of this publisher
+// In secured domains the command must be signed by a known publisher.
+type ReceivePair struct {
+	domain        string                   // the domain of this publisher
+	handler       PairHandler              // handler to pass the command to
+	messageSigner *messaging.MessageSigner // subscription to command
+	publisherID   string                   // the publisher receiving the command
+	updateMutex   *sync.Mutex              // mutex for async handling of commands
+}
+
+// SetPairHandler set the handler for pairing commands
+func (rxPair *ReceivePair) SetPairHandler(handler PairHandler) {
+	rxPair.updateMutex.Lock()
+	defer rxPair.updateMutex.Unlock()
+	rxPair.handler = handler
+}
+
+// Start listening for pairing commands
+func (rxPair *ReceivePair) Start() {
+	addr := MakePairAddress(rxPair.domain, rxPair.publisherID)
+	rxPair.messageSigner.Subscribe(addr, rxPair.decodePair)
+}
+
+// Stop listening for pairing commands
+func (rxPair *ReceivePair) Stop() {
+	addr := MakePairAddress(rxPair.domain, rxPair.publisherID)
+	rxPair.messageSigner.Unsubscribe(addr, rxPair.decodePair)
+}
+
+// decodePair verifies the signature of an incoming pairing command and passes it to the handler.
+func (rxPair *ReceivePair) decodePair(address string, message string) error {
+	var pairMessage types.PairMessage
+
+	_, isSigned, err := rxPair.messageSigner.DecodeCommand(message, &pairMessage)
+	if err != nil {
+		return lib.MakeErrorf("decodePair: Invalid pairing command on '%s': %s. Message discarded.", address, err)
+	} else if !isSigned && rxPair.messageSigner.SignMessages() {
+		return lib.MakeErrorf("decodePair: Pairing command on '%s' isn't signed but must be. Message discarded.", address)
+	}
+	switch pairMessage.Action {
+	case types.PairActionStart, types.PairActionStop:
+	case types.PairActionAdopt, types.PairActionReject:
+		if pairMessage.CandidateID == "" {
+			return lib.MakeErrorf("decodePair: Missing candidate to %s. Message discarded.", pairMessage.Action)
+		}
+	default:
+		return lib.MakeErrorf("decodePair: Unknown pairing action '%s'. Message discarded.", pairMessage.Action)
+	}
+
+	rxPair.updateMutex.Lock()
+	handler := rxPair.handler
+	rxPair.updateMutex.Unlock()
+
+	logrus.Infof("decodePair: Pairing command '%s' from %s", pairMessage.Action, pairMessage.Sender)
+	if handler != nil {
+		handler(&pairMessage)
+	}
+	return nil
+}
+
+// MakePairAddress creates the address of the pairing command: domain/publisherID/$pair
+func MakePairAddress(domain string, publisherID string) string {
+	address := fmt.Sprintf("%s/%s/%s", domain, publisherID, types.MessageTypePair)
+	return address
+}
+
+// MakePairStatusAddress creates the address of the pairing status: domain/publisherID/$pairStatus
+func MakePairStatusAddress(domain string, publisherID string) string {
+	address := fmt.Sprintf("%s/%s/%s", domain, publisherID, types.MessageTypePairStatus)
+	return address
+}
+
+// NewReceivePair returns a new instance of handling of the pairing command
+func NewReceivePair(
+	domain string, publisherID string, handler PairHandler, messageSigner *messaging.MessageSigner) *ReceivePair {
+	rxPair := &ReceivePair{
+		domain:        domain,
+		handler:       handler,
+		messageSigner: messageSigner,
+		publisherID:   publisherID,
+		updateMutex:   &sync.Mutex{},
+	}
+	return rxPair
+}
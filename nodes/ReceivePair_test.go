@@ -0,0 +1,53 @@
+package nodes_test
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceivePair(t *testing.T) {
+	const domain = "test"
+	const publisherID = "pub1"
+	const sender = "test/pub2"
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+
+	received := make([]types.PairMessage, 0)
+	rxPair := nodes.NewReceivePair(domain, publisherID, func(message *types.PairMessage) {
+		received = append(received, *message)
+	}, signer)
+	rxPair.Start()
+
+	err := nodes.PublishPairCommand(domain, publisherID, types.PairActionStart, "", 30, sender, signer)
+	assert.NoError(t, err)
+	require.Equal(t, 1, len(received))
+	assert.Equal(t, sender, received[0].Sender)
+	assert.Equal(t, 30, received[0].Timeout)
+
+	// adopt and reject require a candidate
+	nodes.PublishPairCommand(domain, publisherID, types.PairActionAdopt, "", 0, sender, signer)
+	assert.Equal(t, 1, len(received))
+	nodes.PublishPairCommand(domain, publisherID, types.PairActionAdopt, "zigbee-1", 0, sender, signer)
+	assert.Equal(t, 2, len(received))
+
+	// unknown actions and unsigned commands are not accepted
+	nodes.PublishPairCommand(domain, publisherID, "explode", "", 0, sender, signer)
+	assert.Equal(t, 2, len(received))
+	addr := nodes.MakePairAddress(domain, publisherID)
+	messenger.OnReceive(addr, `{"action":"stop","address":"test/pub1/$pair","sender":"test/pub2"}`)
+	assert.Equal(t, 2, len(received))
+
+	rxPair.Stop()
+	nodes.PublishPairCommand(domain, publisherID, types.PairActionStop, "", 0, sender, signer)
+	assert.Equal(t, 2, len(received))
+}
@@ -4,7 +4,6 @@ package nodes
 import (
 	"crypto/ecdsa"
 	"fmt"
-	"strings"
 	"sync"
 
 	"github.com/iotdomain/iotdomain-go/lib"
@@ -56,17 +55,16 @@ func (setNodeID *ReceiveSetNodeID) Stop() {
 func (setNodeID *ReceiveSetNodeID) decodeSetNodeIDCommand(setAddress string, message string) error {
 	var setNodeIDMessage types.SetNodeIDMessage
 
-	// Check that address is one of our inputs
-	segments := strings.Split(setAddress, "/")
+	// Check that address is one of our nodes
+	setAddr, err := types.ParseAddress(setAddress)
 	// a full address is required: domain/pub/node/$setNodeId
-	if len(segments) < 4 {
+	if err != nil || setAddr.NodeID() == "" || setAddr.IOType() != "" {
 		return lib.MakeErrorf("decodeSetNodeIDCommand: address '%s' is incomplete", setAddress)
 	}
 	// determine which node this message is for
-	segments[3] = types.MessageTypeNodeDiscovery
-	nodeAddr := strings.Join(segments, "/")
+	nodeAddr := setAddr.WithMessageType(types.MessageTypeNodeDiscovery).String()
 
-	isEncrypted, isSigned, err := setNodeID.messageSigner.DecodeMessage(message, &setNodeIDMessage)
+	isEncrypted, isSigned, err := setNodeID.messageSigner.DecodeCommand(message, &setNodeIDMessage)
 
 	if !isEncrypted {
 		return lib.MakeErrorf("decodeSetNodeIDCommand: Update of '%s' is not encrypted. Message discarded.", setAddress)
@@ -5,9 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"os"
+	"sort"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -23,13 +23,22 @@ import (
 // A registered node is identified by its hwID which is immutable and relates to the hardware the
 // node is attached to. Its nodeID is used for publication and can change.
 type RegisteredNodes struct {
-	domain      string                                 // domain these nodes belong to
-	publisherID string                                 // ID of the publisher these nodes belong to
-	deviceMap   map[string]*types.NodeDiscoveryMessage // registered nodes by device ID
+	domain         string                                 // domain these nodes belong to
+	publisherID    string                                 // ID of the publisher these nodes belong to
+	changedNodes   map[string]bool                        // hardware IDs of nodes changed since they were saved
+	deviceMap      map[string]*types.NodeDiscoveryMessage // registered nodes by device ID
+	deletedMap     map[string]*types.NodeDiscoveryMessage // soft deleted nodes by device ID
+	journalEntries int                                    // nr of entries in the journal of the nodes file
+	mergePolicy    NodeMergePolicy                        // merge policy for nodes with a changed node ID
+	onConflict     NodeConflictHandler                    // optional handler to select the merge policy of a conflict
 	// onSetNodeID  func(node *types.NodeDiscoveryMessage, newID string) // notify of a change in node ID. Use this to update input and output addresses
-	nodeMap      map[string]*types.NodeDiscoveryMessage // registered nodes by node ID
-	updatedNodes map[string]*types.NodeDiscoveryMessage // updated nodes by device ID
-	updateMutex  *sync.Mutex                            // mutex for async updating of nodes
+	nodeMap         map[string]*types.NodeDiscoveryMessage // registered nodes by node ID
+	publishedHashes map[string]string                      // content hash of the last published node by address
+	saveMutex       *sync.Mutex                            // mutex for saving the nodes file and its journal
+	savedFilename   string                                 // nodes file that was last loaded or saved
+	savedHash       string                                 // hash of the content of the saved nodes file
+	updatedNodes    map[string]*types.NodeDiscoveryMessage // updated nodes by device ID
+	updateMutex     *sync.RWMutex                          // mutex for async updating of nodes
 }
 
 // Clone returns a copy of the node with new Attr, Config and Status maps
@@ -64,7 +73,7 @@ func (regNodes *RegisteredNodes) CreateNode(hwID string, nodeType types.NodeType
 	defer regNodes.updateMutex.Unlock()
 
 	newNode := NewNode(regNodes.domain, regNodes.publisherID, hwID, nodeType)
-	regNodes.updateNode(newNode)
+	regNodes.updateNode(newNode, types.ChangeReasonCreated)
 	return newNode
 }
 
@@ -101,19 +110,42 @@ func (regNodes *RegisteredNodes) CreateNodeConfig(
 	}
 	newNode := regNodes.Clone(node)
 	newNode.Config[attrName] = config
-	regNodes.updateNode(newNode)
+	regNodes.updateNode(newNode, types.ChangeReasonConfig)
 	return &config
 }
 
-// DeleteNode deletes a node from the collection of registered nodes
-func (regNodes *RegisteredNodes) DeleteNode(hwAddress string) {
-	// TODO
+// DeleteNode soft deletes a node from the collection of registered nodes.
+// The node is no longer published or available for commands, but it is retained with its
+// configuration until it is restored with RestoreNode or purged with PurgeDeletedNodes.
+// Returns false if the node doesn't exist.
+func (regNodes *RegisteredNodes) DeleteNode(hwID string) bool {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	node := regNodes.deviceMap[hwID]
+	if node == nil {
+		return false
+	}
+	deletedNode := regNodes.Clone(node)
+	deletedNode.Deleted = lib.Now().Format(types.TimeFormat)
+	regNodes.deletedMap[hwID] = deletedNode
+	regNodes.changedNodes[hwID] = true
+
+	delete(regNodes.deviceMap, hwID)
+	delete(regNodes.nodeMap, node.NodeID)
+	if regNodes.updatedNodes == nil {
+		regNodes.updatedNodes = make(map[string]*types.NodeDiscoveryMessage)
+	}
+	regNodes.updatedNodes[node.Address] = nil      // inform the publisher this node is no longer valid
+	delete(regNodes.publishedHashes, node.Address) // a restored node is published again
+	logrus.Infof("DeleteNode: Node %s is deleted", hwID)
+	return true
 }
 
 // GetAllNodes returns a list of nodes
 func (regNodes *RegisteredNodes) GetAllNodes() []*types.NodeDiscoveryMessage {
-	regNodes.updateMutex.Lock()
-	defer regNodes.updateMutex.Unlock()
+	regNodes.updateMutex.RLock()
+	defer regNodes.updateMutex.RUnlock()
 
 	var nodeList = make([]*types.NodeDiscoveryMessage, 0)
 	for _, node := range regNodes.nodeMap {
@@ -122,10 +154,22 @@ func (regNodes *RegisteredNodes) GetAllNodes() []*types.NodeDiscoveryMessage {
 	return nodeList
 }
 
+// GetDeletedNodes returns a list of soft deleted nodes
+func (regNodes *RegisteredNodes) GetDeletedNodes() []*types.NodeDiscoveryMessage {
+	regNodes.updateMutex.RLock()
+	defer regNodes.updateMutex.RUnlock()
+
+	var nodeList = make([]*types.NodeDiscoveryMessage, 0)
+	for _, node := range regNodes.deletedMap {
+		nodeList = append(nodeList, node)
+	}
+	return nodeList
+}
+
 // GetNodeAttr returns a node attribute value
 func (regNodes *RegisteredNodes) GetNodeAttr(nodeHWID string, attrName types.NodeAttr) string {
-	regNodes.updateMutex.Lock()
-	defer regNodes.updateMutex.Unlock()
+	regNodes.updateMutex.RLock()
+	defer regNodes.updateMutex.RUnlock()
 	var node = regNodes.deviceMap[nodeHWID]
 	if node == nil {
 		return ""
@@ -137,22 +181,22 @@ func (regNodes *RegisteredNodes) GetNodeAttr(nodeHWID string, attrName types.Nod
 // GetNodeByAddress returns a node by its address using the nodeID
 // Returns nil if the nodeID is not registered
 func (regNodes *RegisteredNodes) GetNodeByAddress(address string) *types.NodeDiscoveryMessage {
-	regNodes.updateMutex.Lock()
-	defer regNodes.updateMutex.Unlock()
+	regNodes.updateMutex.RLock()
+	defer regNodes.updateMutex.RUnlock()
 
-	segments := strings.Split(address, "/")
-	if len(segments) < 3 {
+	nodeAddr, err := types.ParseAddress(address)
+	if err != nil {
 		return nil
 	}
-	var node = regNodes.nodeMap[segments[2]]
+	var node = regNodes.nodeMap[nodeAddr.NodeID()]
 	return node
 }
 
 // GetNodeByHWID returns a registered node by its device ID
 // Returns nil if hwID does not exist
 func (regNodes *RegisteredNodes) GetNodeByHWID(nodeHWID string) *types.NodeDiscoveryMessage {
-	regNodes.updateMutex.Lock()
-	defer regNodes.updateMutex.Unlock()
+	regNodes.updateMutex.RLock()
+	defer regNodes.updateMutex.RUnlock()
 
 	var node = regNodes.deviceMap[nodeHWID]
 	return node
@@ -161,8 +205,8 @@ func (regNodes *RegisteredNodes) GetNodeByHWID(nodeHWID string) *types.NodeDisco
 // GetNodeByNodeID returns a nodes from the publisher
 // Returns nil if address has no known node
 func (regNodes *RegisteredNodes) GetNodeByNodeID(nodeID string) *types.NodeDiscoveryMessage {
-	regNodes.updateMutex.Lock()
-	defer regNodes.updateMutex.Unlock()
+	regNodes.updateMutex.RLock()
+	defer regNodes.updateMutex.RUnlock()
 
 	var node = regNodes.nodeMap[nodeID]
 	return node
@@ -242,8 +286,8 @@ func (regNodes *RegisteredNodes) GetNodeConfigInt(
 // An error is returned when the node or configuration doesn't exist.
 func (regNodes *RegisteredNodes) GetNodeConfigString(
 	nodeHWID string, attrName types.NodeAttr, defaultValue string) (value string, err error) {
-	regNodes.updateMutex.Lock()
-	defer regNodes.updateMutex.Unlock()
+	regNodes.updateMutex.RLock()
+	defer regNodes.updateMutex.RUnlock()
 
 	var node = regNodes.deviceMap[nodeHWID]
 	if node == nil {
@@ -272,6 +316,8 @@ func (regNodes *RegisteredNodes) GetNodeConfigString(
 }
 
 // GetUpdatedNodes returns the list of nodes that have been updated
+// Nodes whose content is unchanged since their last publication are skipped unless their
+// republication is forced. Deleted nodes are included as nil.
 // clearUpdates clears the list of updates. Intended for publishing only updated nodes.
 func (regNodes *RegisteredNodes) GetUpdatedNodes(clearUpdates bool) []*types.NodeDiscoveryMessage {
 	var updateList []*types.NodeDiscoveryMessage = make([]*types.NodeDiscoveryMessage, 0)
@@ -280,8 +326,21 @@ func (regNodes *RegisteredNodes) GetUpdatedNodes(clearUpdates bool) []*types.Nod
 	defer regNodes.updateMutex.Unlock()
 
 	if regNodes.updatedNodes != nil {
-		for _, node := range regNodes.updatedNodes {
+		for address, node := range regNodes.updatedNodes {
+			if node == nil {
+				updateList = append(updateList, node)
+				continue
+			}
+			// nodes that are updated without change are only republished when forced
+			hash := MakeNodeHash(node)
+			if regNodes.publishedHashes[address] == hash &&
+				!types.HasChangeReason(node.ChangeReasons, types.ChangeReasonForced) {
+				continue
+			}
 			updateList = append(updateList, node)
+			if clearUpdates {
+				regNodes.publishedHashes[address] = hash
+			}
 		}
 		if clearUpdates {
 			regNodes.updatedNodes = nil
@@ -298,12 +357,21 @@ func (regNodes *RegisteredNodes) GetUpdatedNodes(clearUpdates bool) []*types.Nod
 // 	regNodes.SetAlias(node, msg.Alias)
 // }
 
-// LoadNodes loads previously saved registered nodes.
-// Intended to persist changes to node configuration.
+// IsDeleted returns true if the node with the given hardware ID is soft deleted
+func (regNodes *RegisteredNodes) IsDeleted(hwID string) bool {
+	regNodes.updateMutex.RLock()
+	defer regNodes.updateMutex.RUnlock()
+	_, isDeleted := regNodes.deletedMap[hwID]
+	return isDeleted
+}
+
+// LoadNodes loads the nodes from a JSON file and replays the changes from its journal, if any.
+// If the file is missing or invalid, its previous version is loaded, see lib.ReadFileWithRecovery.
+// Loaded nodes are not marked as changed. See also SaveChangedNodes.
 func (regNodes *RegisteredNodes) LoadNodes(filename string) error {
 	nodeList := make([]*types.NodeDiscoveryMessage, 0)
 
-	jsonNodes, err := ioutil.ReadFile(filename)
+	jsonNodes, err := lib.ReadFileWithRecovery(filename, lib.ValidateJSON)
 	if err != nil {
 		return lib.MakeErrorf("LoadNodes: Unable to open file %s: %s", filename, err)
 	}
@@ -311,24 +379,115 @@ func (regNodes *RegisteredNodes) LoadNodes(filename string) error {
 	if err != nil {
 		return lib.MakeErrorf("LoadNodes: Error parsing JSON node file %s: %v", filename, err)
 	}
+	regNodes.saveMutex.Lock()
+	defer regNodes.saveMutex.Unlock()
+	fileHash := makeFileHash(jsonNodes)
+	nodeList, entryCount, appliedCount := replayJournal(filename+NodesJournalSuffix, fileHash, nodeList)
+	if entryCount > 0 && appliedCount == 0 {
+		// the journal belongs to a replaced nodes file
+		os.Remove(filename + NodesJournalSuffix)
+		entryCount = 0
+	}
+	regNodes.savedFilename = filename
+	regNodes.savedHash = fileHash
+	regNodes.journalEntries = entryCount
 	logrus.Infof("LoadNodes: Node list loaded successfully from %s", filename)
-	regNodes.UpdateNodes(nodeList)
+
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+	// nodes that changed before loading are still to be saved
+	changedNodes := regNodes.changedNodes
+	regNodes.changedNodes = make(map[string]bool)
+	regNodes.updateNodeList(nodeList, types.ChangeReasonConfig)
+	regNodes.changedNodes = changedNodes
 	return nil
 }
 
-// SaveNodes saves the current registered nodes to a JSON file
+// PurgeDeletedNodes permanently removes soft deleted nodes that were deleted longer than the
+// retention period ago.
+// Returns the hardware IDs of the purged nodes.
+func (regNodes *RegisteredNodes) PurgeDeletedNodes(retention time.Duration) []string {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	purged := make([]string, 0)
+	for hwID, node := range regNodes.deletedMap {
+		deleted, err := time.Parse(types.TimeFormat, node.Deleted)
+		if err != nil || time.Since(deleted) > retention {
+			delete(regNodes.deletedMap, hwID)
+			regNodes.changedNodes[hwID] = true
+			purged = append(purged, hwID)
+		}
+	}
+	if len(purged) > 0 {
+		logrus.Infof("PurgeDeletedNodes: Purged deleted nodes %v", purged)
+	}
+	return purged
+}
+
+// RestoreNode restores a soft deleted node with its configuration. The restored node replaces an
+// existing node with the same hardware ID, and is published with its former node ID.
+// Returns the restored node or nil if the node is not deleted.
+func (regNodes *RegisteredNodes) RestoreNode(hwID string) *types.NodeDiscoveryMessage {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	deletedNode := regNodes.deletedMap[hwID]
+	if deletedNode == nil {
+		return nil
+	}
+	delete(regNodes.deletedMap, hwID)
+	existingNode := regNodes.deviceMap[hwID]
+	if existingNode != nil && existingNode.NodeID != deletedNode.NodeID {
+		delete(regNodes.nodeMap, existingNode.NodeID)
+	}
+	node := regNodes.Clone(deletedNode)
+	node.Deleted = ""
+	regNodes.updateNode(node, types.ChangeReasonCreated)
+	logrus.Infof("RestoreNode: Node %s is restored", hwID)
+	return node
+}
+
+// SaveNodes saves the current registered nodes, including soft deleted nodes, to a JSON file and
+// removes its journal. Local-only nodes are not saved. Use SaveChangedNodes to only save changes.
 func (regNodes *RegisteredNodes) SaveNodes(filename string) error {
-	collection := regNodes.GetAllNodes()
-	jsonText, err := json.MarshalIndent(collection, "", "  ")
-	if err != nil {
-		return lib.MakeErrorf("SaveNodes: Error Marshalling JSON collection '%s': %v", filename, err)
+	regNodes.saveMutex.Lock()
+	defer regNodes.saveMutex.Unlock()
+	return regNodes.compactNodes(filename)
+}
+
+// SetLocalOnly sets whether a registered node is for internal use only, eg for bookkeeping of an
+// adapter. Local-only nodes are not published or saved. A node that is no longer local-only is
+// republished.
+// Returns false if the node doesn't exist.
+func (regNodes *RegisteredNodes) SetLocalOnly(hwID string, localOnly bool) bool {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+	node := regNodes.deviceMap[hwID]
+	if node == nil {
+		return false
 	}
-	err = ioutil.WriteFile(filename, jsonText, 0664)
-	if err != nil {
-		return lib.MakeErrorf("SaveNodes: Error saving collection to JSON file %s: %v", filename, err)
+	if node.LocalOnly != localOnly {
+		newNode := regNodes.Clone(node)
+		newNode.LocalOnly = localOnly
+		// the flag isn't part of the content so the republication is forced
+		regNodes.updateNode(newNode, types.ChangeReasonForced)
 	}
-	logrus.Infof("SaveNodes: Collection saved successfully to JSON file %s", filename)
-	return nil
+	return true
+}
+
+// SetMergePolicy sets the policy for merging a discovered node with an existing node that has the
+// same hardware ID but a different node ID.
+//  policy is the default policy to apply. Use "" for DefaultNodeMergePolicy
+//  handler is an optional callback to select the policy of each conflict. nil to always use the default policy
+func (regNodes *RegisteredNodes) SetMergePolicy(policy NodeMergePolicy, handler NodeConflictHandler) {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+	if policy == "" {
+		policy = DefaultNodeMergePolicy
+	}
+	regNodes.mergePolicy = policy
+	regNodes.onConflict = handler
 }
 
 // SetNodeID changes the nodeID and address of the node
@@ -364,7 +523,7 @@ func (regNodes *RegisteredNodes) SetNodeID(node *types.NodeDiscoveryMessage, new
 	regNodes.updateMutex.Unlock()
 
 	newNode.Address = MakeNodeDiscoveryAddress(regNodes.domain, regNodes.publisherID, newNode.NodeID)
-	regNodes.updateNode(newNode)
+	regNodes.updateNode(newNode, types.ChangeReasonAttr)
 	// if regNodes.onSetNodeID != nil {
 	// 	regNodes.onSetNodeID(node, newNodeID)
 	// }
@@ -378,6 +537,26 @@ func (regNodes *RegisteredNodes) SetNodeID(node *types.NodeDiscoveryMessage, new
 // 	regNodes.onSetNodeID = handler
 // }
 
+// Snapshot returns copies of the registered nodes, sorted by node ID. The copies can be iterated
+// and modified without locking and without affecting the registered nodes.
+func (regNodes *RegisteredNodes) Snapshot() []*types.NodeDiscoveryMessage {
+	// nodes are immutable so they can be cloned outside the locked section
+	allNodes := regNodes.GetAllNodes()
+	snapshot := make([]*types.NodeDiscoveryMessage, 0, len(allNodes))
+	for _, node := range allNodes {
+		if node != nil {
+			newNode := regNodes.Clone(node)
+			newNode.Config = make(types.ConfigAttrMap)
+			for key, value := range node.Config {
+				newNode.Config[key] = value
+			}
+			snapshot = append(snapshot, newNode)
+		}
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].NodeID < snapshot[j].NodeID })
+	return snapshot
+}
+
 // UpdateErrorStatus sets the device RunState to the given status with a lasterror message
 // Use NodeRunStateError for errors and NodeRunStateReady to clear error
 // This only updates the node if the status or lastError message changes
@@ -403,7 +582,7 @@ func (regNodes *RegisteredNodes) UpdateErrorStatus(nodeHWID string, runState str
 	}
 	// Don't unnecesarily republish the node if the status doesnt change
 	if changed {
-		regNodes.updateNode(newNode)
+		regNodes.updateNode(newNode, types.ChangeReasonStatus)
 	}
 	return changed
 }
@@ -431,7 +610,7 @@ func (regNodes *RegisteredNodes) UpdateNodeAttr(nodeHWID string, attrParams map[
 	}
 	// changed := newNode.SetNodeAttr(attrParams)
 	if changed {
-		regNodes.updateNode(newNode)
+		regNodes.updateNode(newNode, types.ChangeReasonAttr)
 	}
 	return changed
 }
@@ -469,7 +648,7 @@ func (regNodes *RegisteredNodes) UpdateNodeConfigValues(nodeHWID string, params
 	}
 
 	if changed {
-		regNodes.updateNode(newNode)
+		regNodes.updateNode(newNode, types.ChangeReasonConfig)
 	}
 	return changed
 }
@@ -499,19 +678,38 @@ func (regNodes *RegisteredNodes) UpdateNodeConfig(nodeHWID string, attrName type
 
 	newNode := regNodes.Clone(node)
 	newNode.Config[attrName] = *configAttr
-	regNodes.updateNode(newNode)
+	regNodes.updateNode(newNode, types.ChangeReasonConfig)
 }
 
 // UpdateNodes updates a list of nodes.
 //
 // Intended to update the list with nodes from persistent storage
 func (regNodes *RegisteredNodes) UpdateNodes(updates []*types.NodeDiscoveryMessage) {
+	regNodes.updateNodes(updates, types.ChangeReasonConfig)
+}
+
+// RepublishNodes marks a list of nodes as updated without change, so they are republished
+// with the next publication of updates, eg to refresh the discovery.
+func (regNodes *RegisteredNodes) RepublishNodes(nodes []*types.NodeDiscoveryMessage) {
+	regNodes.updateNodes(nodes, types.ChangeReasonForced)
+}
+
+// updateNodes updates a list of nodes with the given reason for the update
+func (regNodes *RegisteredNodes) updateNodes(updates []*types.NodeDiscoveryMessage, reason types.ChangeReason) {
 	regNodes.updateMutex.Lock()
 	defer regNodes.updateMutex.Unlock()
+	regNodes.updateNodeList(updates, reason)
+}
 
+// updateNodeList updates a list of nodes with the given reason for the update
+//  Use within a locked section.
+func (regNodes *RegisteredNodes) updateNodeList(updates []*types.NodeDiscoveryMessage, reason types.ChangeReason) {
 	for _, node := range updates {
 		// fill in missing fields
-		if node != nil {
+		if node != nil && node.Deleted != "" {
+			regNodes.deletedMap[node.HWID] = node
+			regNodes.changedNodes[node.HWID] = true
+		} else if node != nil {
 			if node.Attr == nil {
 				node.Attr = map[types.NodeAttr]string{}
 			}
@@ -521,7 +719,11 @@ func (regNodes *RegisteredNodes) UpdateNodes(updates []*types.NodeDiscoveryMessa
 			if node.Status == nil {
 				node.Status = make(map[types.NodeStatus]string)
 			}
-			regNodes.updateNode(node)
+			existingNode := regNodes.deviceMap[node.HWID]
+			if existingNode != nil && existingNode.NodeID != node.NodeID {
+				node = regNodes.mergeNode(existingNode, node)
+			}
+			regNodes.updateNode(node, reason)
 		}
 	}
 }
@@ -550,26 +752,74 @@ func (regNodes *RegisteredNodes) UpdateNodeStatus(nodeHWID string, statusAttr ma
 	}
 
 	if changed {
-		regNodes.updateNode(newNode)
+		regNodes.updateNode(newNode, types.ChangeReasonStatus)
 	}
 	return changed
 }
 
+// mergeNode resolves the conflict between an existing node and a discovered node with the same
+// hardware ID and a different node ID, using the merge policy.
+// Returns the node to update, or nil to keep the existing node.
+// For internal use only. Use within locked section.
+func (regNodes *RegisteredNodes) mergeNode(
+	existing *types.NodeDiscoveryMessage, discovered *types.NodeDiscoveryMessage) *types.NodeDiscoveryMessage {
+
+	policy := regNodes.mergePolicy
+	if regNodes.onConflict != nil {
+		if conflictPolicy := regNodes.onConflict(existing, discovered); conflictPolicy != "" {
+			policy = conflictPolicy
+		}
+	}
+	logrus.Infof("mergeNode: Node %s has ID '%s' and is discovered with ID '%s'. Applying policy %s",
+		existing.HWID, existing.NodeID, discovered.NodeID, policy)
+
+	switch policy {
+	case NodeMergeKeepExisting:
+		return nil
+	case NodeMergeAttrs:
+		return MergeNodeAttrs(existing, discovered)
+	default:
+		// replace the existing node and inform the publisher its address is no longer valid
+		delete(regNodes.nodeMap, existing.NodeID)
+		if regNodes.updatedNodes == nil {
+			regNodes.updatedNodes = make(map[string]*types.NodeDiscoveryMessage)
+		}
+		regNodes.updatedNodes[existing.Address] = nil
+		return discovered
+	}
+}
+
 // updateNode replaces a node and adds it to the list of updated nodes.
+// The reason is added to the reasons of a pending update of the node, if any.
 //  Use within a locked section.
-func (regNodes *RegisteredNodes) updateNode(node *types.NodeDiscoveryMessage) {
+func (regNodes *RegisteredNodes) updateNode(node *types.NodeDiscoveryMessage, reason types.ChangeReason) {
 	if node == nil {
 		return
 	}
 	regNodes.nodeMap[node.NodeID] = node
 	regNodes.deviceMap[node.HWID] = node
+	regNodes.changedNodes[node.HWID] = true
 	if regNodes.updatedNodes == nil {
 		regNodes.updatedNodes = make(map[string]*types.NodeDiscoveryMessage)
 	}
-	node.Timestamp = time.Now().Format(types.TimeFormat)
+	var pendingReasons []types.ChangeReason
+	if pendingNode := regNodes.updatedNodes[node.Address]; pendingNode != nil {
+		pendingReasons = pendingNode.ChangeReasons
+	}
+	node.ChangeReasons = types.AddChangeReason(pendingReasons, reason)
+	node.Timestamp = lib.Now().Format(types.TimeFormat)
 	regNodes.updatedNodes[node.Address] = node
 }
 
+// MakeNodeHash returns the hash of the content of a node. The timestamp and change reasons are
+// excluded as they change with each update.
+func MakeNodeHash(node *types.NodeDiscoveryMessage) string {
+	content := *node
+	content.ChangeReasons = nil
+	content.Timestamp = ""
+	return lib.MakeContentHash(&content)
+}
+
 // MakeNodeAddress generates the publication address of a node: domain/publisherID/nodeID[/messageType].
 //
 // As per standard, the domain of the domain the node lives in; publisherID of the publisher for this node,
@@ -627,11 +877,12 @@ func NewNode(domain string, publisherID string, nodeHWID string, nodeType types.
 		NodeID:      nodeHWID,
 		PublisherID: publisherID,
 		Status:      make(map[types.NodeStatus]string),
-		Timestamp:   time.Now().Format(types.TimeFormat),
+		Timestamp:   lib.Now().Format(types.TimeFormat),
 	}
 	newNode.Attr[types.NodeAttrType] = string(nodeType)
 	newNode.Config[types.NodeAttrName] = *NewNodeConfig(types.DataTypeString, "Human friendly node name", "")
 	newNode.Config[types.NodeAttrPublishEvent] = *NewNodeConfig(types.DataTypeString, "Enable publishing outputs as event", "false")
+	newNode.Config[types.NodeAttrPublishForecast] = *NewNodeConfig(types.DataTypeBool, "Enable publishing output forecast", "true")
 	newNode.Config[types.NodeAttrPublishHistory] = *NewNodeConfig(types.DataTypeBool, "Enable publishing output history", "true")
 	newNode.Config[types.NodeAttrPublishLatest] = *NewNodeConfig(types.DataTypeBool, "Enable publishing latest output", "true")
 	newNode.Config[types.NodeAttrPublishRaw] = *NewNodeConfig(types.DataTypeBool, "Enable publishing raw outputs", "true")
@@ -642,12 +893,17 @@ func NewNode(domain string, publisherID string, nodeHWID string, nodeType types.
 // onSetNodeID is the handler for changes in nodeID configuration. Use this to update input and output addresses
 func NewRegisteredNodes(domain string, publisherID string) *RegisteredNodes {
 	nodes := RegisteredNodes{
-		domain:       domain,
-		publisherID:  publisherID,
-		deviceMap:    make(map[string]*types.NodeDiscoveryMessage),
-		nodeMap:      make(map[string]*types.NodeDiscoveryMessage),
-		updatedNodes: make(map[string]*types.NodeDiscoveryMessage),
-		updateMutex:  &sync.Mutex{},
+		domain:          domain,
+		publisherID:     publisherID,
+		changedNodes:    make(map[string]bool),
+		deviceMap:       make(map[string]*types.NodeDiscoveryMessage),
+		deletedMap:      make(map[string]*types.NodeDiscoveryMessage),
+		mergePolicy:     DefaultNodeMergePolicy,
+		nodeMap:         make(map[string]*types.NodeDiscoveryMessage),
+		publishedHashes: make(map[string]string),
+		saveMutex:       &sync.Mutex{},
+		updatedNodes:    make(map[string]*types.NodeDiscoveryMessage),
+		updateMutex:     &sync.RWMutex{},
 	}
 	return &nodes
 }
@@ -3,8 +3,14 @@ package nodes_test
 import (
 	"crypto/ecdsa"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/iotdomain/iotdomain-go/internal/perftest"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/nodes"
 	"github.com/iotdomain/iotdomain-go/types"
@@ -109,6 +115,126 @@ func TestAttrStatus(t *testing.T) {
 
 }
 
+// TestSoftDelete tests deleting, restoring and purging of nodes
+func TestChangeReasons(t *testing.T) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode(node1ID, types.NodeTypeUnknown)
+	collection.UpdateNodeAttr(node1ID, types.NodeAttrMap{types.NodeAttrDescription: "changed"})
+	updated := collection.GetUpdatedNodes(true)
+	require.Equal(t, 1, len(updated))
+	assert.Equal(t, []types.ChangeReason{types.ChangeReasonCreated, types.ChangeReasonAttr}, updated[0].ChangeReasons)
+
+	// reasons are reset after the updates are cleared
+	collection.UpdateNodeStatus(node1ID, types.NodeStatusMap{types.NodeStatusRunState: types.NodeRunStateReady})
+	collection.UpdateNodeStatus(node1ID, types.NodeStatusMap{types.NodeStatusRunState: types.NodeRunStateError})
+	updated = collection.GetUpdatedNodes(true)
+	require.Equal(t, 1, len(updated))
+	assert.Equal(t, []types.ChangeReason{types.ChangeReasonStatus}, updated[0].ChangeReasons)
+
+	collection.RepublishNodes(collection.GetAllNodes())
+	updated = collection.GetUpdatedNodes(true)
+	require.Equal(t, 1, len(updated))
+	assert.Equal(t, []types.ChangeReason{types.ChangeReasonForced}, updated[0].ChangeReasons)
+}
+
+func TestUpdateUnchangedNode(t *testing.T) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode(node1ID, types.NodeTypeUnknown)
+	collection.UpdateNodeAttr(node1ID, types.NodeAttrMap{types.NodeAttrDescription: "first"})
+	updated := collection.GetUpdatedNodes(true)
+	require.Equal(t, 1, len(updated))
+
+	// an update with identical values is not published
+	collection.UpdateNodeAttr(node1ID, types.NodeAttrMap{types.NodeAttrDescription: "first"})
+	updated = collection.GetUpdatedNodes(true)
+	assert.Equal(t, 0, len(updated), "Unchanged node should not be published")
+
+	collection.UpdateNodeAttr(node1ID, types.NodeAttrMap{types.NodeAttrDescription: "second"})
+	updated = collection.GetUpdatedNodes(true)
+	assert.Equal(t, 1, len(updated), "Changed node should be published")
+
+	// a forced republication is always published
+	collection.RepublishNodes(collection.GetAllNodes())
+	updated = collection.GetUpdatedNodes(true)
+	assert.Equal(t, 1, len(updated), "Forced republication should be published")
+}
+
+func TestSoftDelete(t *testing.T) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode(node1ID, types.NodeTypeUnknown)
+	collection.UpdateNodeAttr(node1ID, map[types.NodeAttr]string{types.NodeAttrName: "bob"})
+	collection.GetUpdatedNodes(true)
+
+	deleted := collection.DeleteNode(node1ID)
+	assert.True(t, deleted)
+	assert.True(t, collection.IsDeleted(node1ID))
+	assert.Nil(t, collection.GetNodeByHWID(node1ID))
+	assert.Equal(t, 0, len(collection.GetAllNodes()))
+	assert.Equal(t, 1, len(collection.GetDeletedNodes()))
+	deleted = collection.DeleteNode(node1ID)
+	assert.False(t, deleted, "Deleting a deleted node should fail")
+
+	// the deleted node is published as removed
+	updated := collection.GetUpdatedNodes(true)
+	require.Equal(t, 1, len(updated))
+	assert.Nil(t, updated[0])
+
+	// restore keeps the attributes
+	node := collection.RestoreNode(node1ID)
+	require.NotNil(t, node)
+	assert.Empty(t, node.Deleted)
+	assert.Equal(t, "bob", node.Attr[types.NodeAttrName])
+	assert.False(t, collection.IsDeleted(node1ID))
+	assert.NotNil(t, collection.GetNodeByHWID(node1ID))
+	assert.Nil(t, collection.RestoreNode(node1ID), "Restoring a restored node should fail")
+
+	// purge removes nodes past their retention
+	collection.DeleteNode(node1ID)
+	purged := collection.PurgeDeletedNodes(time.Hour)
+	assert.Equal(t, 0, len(purged))
+	purged = collection.PurgeDeletedNodes(0)
+	assert.Equal(t, 1, len(purged))
+	assert.False(t, collection.IsDeleted(node1ID))
+	assert.Nil(t, collection.RestoreNode(node1ID))
+}
+
+func TestNodesSnapshot(t *testing.T) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode("node2", types.NodeTypeUnknown)
+	collection.CreateNode(node1ID, types.NodeTypeUnknown)
+	collection.UpdateNodeAttr(node1ID, types.NodeAttrMap{types.NodeAttrName: "bob"})
+
+	snapshot := collection.Snapshot()
+	require.Equal(t, 2, len(snapshot))
+	assert.Equal(t, node1ID, snapshot[0].NodeID)
+	assert.Equal(t, "node2", snapshot[1].NodeID)
+
+	// modifying the snapshot doesn't affect the registered nodes
+	snapshot[0].Attr[types.NodeAttrName] = "alice"
+	assert.Equal(t, "bob", collection.GetNodeAttr(node1ID, types.NodeAttrName))
+
+	// concurrent reads and updates
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		for i := 0; i < 100; i++ {
+			collection.UpdateNodeAttr(node1ID, types.NodeAttrMap{types.NodeAttrName: fmt.Sprint(i)})
+		}
+		wg.Done()
+	}()
+	go func() {
+		for i := 0; i < 100; i++ {
+			for _, node := range collection.Snapshot() {
+				_ = node.Attr[types.NodeAttrName]
+			}
+			collection.GetNodeByHWID(node1ID)
+		}
+		wg.Done()
+	}()
+	wg.Wait()
+	assert.Equal(t, "99", collection.GetNodeAttr(node1ID, types.NodeAttrName))
+}
+
 // TestConfigure tests if the node configuration is handled
 func TestConfigure(t *testing.T) {
 	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
@@ -261,6 +387,39 @@ func TestLoadSave(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLocalOnlyNode(t *testing.T) {
+	const node2ID = "node2"
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	filename := path.Join(folder, "nodes.json")
+
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode(node1ID, types.NodeTypeUnknown)
+	collection.CreateNode(node2ID, types.NodeTypeUnknown)
+	ok := collection.SetLocalOnly(node2ID, true)
+	assert.True(t, ok)
+	assert.True(t, collection.GetNodeByHWID(node2ID).LocalOnly)
+
+	// local-only nodes are not saved
+	err = collection.SaveNodes(filename)
+	require.NoError(t, err)
+	collection2 := nodes.NewRegisteredNodes(domain, publisher1ID)
+	err = collection2.LoadNodes(filename)
+	require.NoError(t, err)
+	assert.NotNil(t, collection2.GetNodeByHWID(node1ID))
+	assert.Nil(t, collection2.GetNodeByHWID(node2ID))
+
+	// a node that is no longer local-only is republished although its content is unchanged
+	collection.GetUpdatedNodes(true)
+	collection.SetLocalOnly(node2ID, false)
+	updated := collection.GetUpdatedNodes(true)
+	require.Equal(t, 1, len(updated))
+	assert.False(t, updated[0].LocalOnly)
+	ok = collection.SetLocalOnly("notanode", true)
+	assert.False(t, ok)
+}
+
 func TestChangeNodeID(t *testing.T) {
 	const newNodeID = "newID"
 
@@ -335,3 +494,44 @@ func TestPublishReceive(t *testing.T) {
 	nodes.PublishRegisteredNodes(allNodes, signer)
 
 }
+
+// Performance budget of registry lookups on a CI host, see the README. The budget is enforced by
+// TestNodeLookupBudget unless tests run in short mode.
+const getNodeBudget = time.Microsecond
+
+func TestNodeLookupBudget(t *testing.T) {
+	perftest.SkipUnlessEnabled(t)
+	result := testing.Benchmark(BenchmarkGetNodeByHWID)
+	assert.LessOrEqualf(t, result.NsPerOp(), int64(getNodeBudget),
+		"GetNodeByHWID exceeds the budget of %s: %s", getNodeBudget, result)
+	result = testing.Benchmark(BenchmarkGetNodeByAddress)
+	assert.LessOrEqualf(t, result.NsPerOp(), int64(getNodeBudget),
+		"GetNodeByAddress exceeds the budget of %s: %s", getNodeBudget, result)
+}
+
+// BenchmarkGetNodeByHWID measures the lookup of registered nodes, done for each updated output value
+func BenchmarkGetNodeByHWID(b *testing.B) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	for count := 0; count < 50; count++ {
+		collection.CreateNode(fmt.Sprintf("node%d", count), types.NodeTypeMultisensor)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for count := 0; count < b.N; count++ {
+		collection.GetNodeByHWID("node25")
+	}
+}
+
+// BenchmarkGetNodeByAddress measures the lookup of registered nodes by the address of a received message
+func BenchmarkGetNodeByAddress(b *testing.B) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	for count := 0; count < 50; count++ {
+		collection.CreateNode(fmt.Sprintf("node%d", count), types.NodeTypeMultisensor)
+	}
+	address := nodes.MakeNodeDiscoveryAddress(domain, publisher1ID, "node25")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for count := 0; count < b.N; count++ {
+		collection.GetNodeByAddress(address)
+	}
+}
@@ -0,0 +1,76 @@
+// Package outputs with calibration of output values
+package outputs
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// CalibrationDecimals is the max nr of decimals of a calibrated value. This removes floating point
+// noise from the result.
+const CalibrationDecimals = 6
+
+// ApplyCalibration returns the calibrated value of a numeric output value
+// A table is interpolated linearly between its nearest points and extrapolated from its first or last
+// two points. The gain and offset are applied to the result.
+// Returns the value unchanged if calibration is nil, or an error if the value is not numeric.
+func ApplyCalibration(calibration *types.OutputCalibration, value string) (string, error) {
+	if calibration == nil {
+		return value, nil
+	}
+	rawValue, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return value, lib.MakeErrorf("ApplyCalibration: Value '%s' is not numeric", value)
+	}
+	newValue := rawValue
+	table := calibration.Table
+	if len(table) >= 2 {
+		// the segment containing the value, or the first or last segment when outside the table
+		i := sort.Search(len(table)-2, func(i int) bool { return table[i+1].Raw >= rawValue })
+		p1, p2 := table[i], table[i+1]
+		newValue = p1.Value + (rawValue-p1.Raw)*(p2.Value-p1.Value)/(p2.Raw-p1.Raw)
+	}
+	if calibration.Gain != 0 {
+		newValue *= calibration.Gain
+	}
+	newValue += calibration.Offset
+
+	scale := math.Pow10(CalibrationDecimals)
+	newValue = math.Round(newValue*scale) / scale
+	return strconv.FormatFloat(newValue, 'f', -1, 64), nil
+}
+
+// MakeCalibrateAddress returns the address of the calibrate command of an output:
+// domain/publisher/node/outputType/instance/$calibrate
+func MakeCalibrateAddress(outputAddress string) string {
+	return ReplaceMessageType(outputAddress, types.MessageTypeCalibrate)
+}
+
+// NormalizeCalibration returns a copy of the calibration with its table sorted by raw value
+// Returns an error if the table has a single point or duplicate raw values.
+func NormalizeCalibration(calibration *types.OutputCalibration) (*types.OutputCalibration, error) {
+	if calibration == nil {
+		return nil, nil
+	}
+	newCalibration := *calibration
+	if len(calibration.Table) == 1 {
+		return nil, lib.MakeErrorf("NormalizeCalibration: A calibration table needs at least 2 points")
+	} else if len(calibration.Table) > 1 {
+		newCalibration.Table = append([]types.CalibrationPoint(nil), calibration.Table...)
+		sort.Slice(newCalibration.Table, func(i, j int) bool {
+			return newCalibration.Table[i].Raw < newCalibration.Table[j].Raw
+		})
+		for i := 1; i < len(newCalibration.Table); i++ {
+			if newCalibration.Table[i].Raw == newCalibration.Table[i-1].Raw {
+				return nil, lib.MakeErrorf("NormalizeCalibration: Duplicate raw value %v in calibration table",
+					newCalibration.Table[i].Raw)
+			}
+		}
+	}
+	return &newCalibration, nil
+}
@@ -0,0 +1,64 @@
+package outputs_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyCalibration(t *testing.T) {
+	// without calibration the value is unchanged
+	value, err := outputs.ApplyCalibration(nil, "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", value)
+
+	// gain and offset
+	calibration := &types.OutputCalibration{Gain: 1.1, Offset: -0.5}
+	value, err = outputs.ApplyCalibration(calibration, "21.5")
+	assert.NoError(t, err)
+	assert.Equal(t, "23.15", value)
+	_, err = outputs.ApplyCalibration(calibration, "abc")
+	assert.Error(t, err)
+
+	// a table is interpolated and extrapolated
+	calibration, err = outputs.NormalizeCalibration(&types.OutputCalibration{
+		Table: []types.CalibrationPoint{{Raw: 100, Value: 50}, {Raw: 0, Value: 0}, {Raw: 200, Value: 150}},
+	})
+	require.NoError(t, err)
+	value, _ = outputs.ApplyCalibration(calibration, "50")
+	assert.Equal(t, "25", value)
+	value, _ = outputs.ApplyCalibration(calibration, "150")
+	assert.Equal(t, "100", value)
+	value, _ = outputs.ApplyCalibration(calibration, "-10")
+	assert.Equal(t, "-5", value)
+	value, _ = outputs.ApplyCalibration(calibration, "300")
+	assert.Equal(t, "250", value)
+
+	// the offset is applied after the table
+	calibration.Offset = 1
+	value, _ = outputs.ApplyCalibration(calibration, "50")
+	assert.Equal(t, "26", value)
+}
+
+func TestNormalizeCalibration(t *testing.T) {
+	calibration, err := outputs.NormalizeCalibration(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, calibration)
+
+	_, err = outputs.NormalizeCalibration(&types.OutputCalibration{
+		Table: []types.CalibrationPoint{{Raw: 1, Value: 2}}})
+	assert.Error(t, err)
+	_, err = outputs.NormalizeCalibration(&types.OutputCalibration{
+		Table: []types.CalibrationPoint{{Raw: 1, Value: 2}, {Raw: 1, Value: 3}}})
+	assert.Error(t, err)
+
+	// the table of the original is not modified
+	original := &types.OutputCalibration{Table: []types.CalibrationPoint{{Raw: 2, Value: 2}, {Raw: 1, Value: 1}}}
+	calibration, err = outputs.NormalizeCalibration(original)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, calibration.Table[0].Raw)
+	assert.Equal(t, 2.0, original.Table[0].Raw)
+}
@@ -0,0 +1,65 @@
+// Package outputs with outputs whose value is derived from other outputs
+package outputs
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// ExpressionDecimals is the max nr of decimals of a derived value. This removes floating point
+// noise from the result.
+const ExpressionDecimals = 6
+
+// EvaluateDerivedValue evaluates the expression of a derived output of a node with the latest values
+// of the outputs it refers to. See ResolveExpressionVariable for the outputs the variables refer to.
+//  getValue returns the latest value of an output by output ID, and false if the output has no value
+// Returns the derived value, or an error if an output has no numeric value or the result isn't a number.
+func EvaluateDerivedValue(expression *Expression, nodeHWID string,
+	getValue func(outputID string) (string, bool)) (string, error) {
+
+	variables := make(map[string]float64, len(expression.Variables()))
+	for _, name := range expression.Variables() {
+		outputID := ResolveExpressionVariable(nodeHWID, name)
+		value, found := getValue(outputID)
+		if !found {
+			return "", lib.MakeErrorf("EvaluateDerivedValue: Output %s of variable '%s' has no value", outputID, name)
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return "", lib.MakeErrorf("EvaluateDerivedValue: Value '%s' of output %s is not numeric", value, outputID)
+		}
+		variables[name] = number
+	}
+	result, err := expression.Evaluate(variables)
+	if err != nil {
+		return "", lib.MakeErrorf("EvaluateDerivedValue: %s", err)
+	}
+	scale := math.Pow10(ExpressionDecimals)
+	result = math.Round(result*scale) / scale
+	return strconv.FormatFloat(result, 'f', -1, 64), nil
+}
+
+// MakeOutputExpressionAttr returns the node configuration attribute that holds the expression of a
+// derived output: expression/{outputType}/{instance}
+func MakeOutputExpressionAttr(outputType types.OutputType, instance string) types.NodeAttr {
+	return types.NodeAttr("expression/" + string(outputType) + "/" + instance)
+}
+
+// ResolveExpressionVariable returns the ID of the output that a variable in the expression of a
+// derived output of a node refers to:
+//  {outputType} refers to the output of the node with the default instance, eg: voltage
+//  {outputType}.{instance} refers to an output of the node, eg: temperature.1
+//  {nodeHWID}.{outputType}.{instance} refers to an output of another node, eg: meter.power.0
+func ResolveExpressionVariable(nodeHWID string, name string) string {
+	switch strings.Count(name, ".") {
+	case 0:
+		return MakeOutputID(nodeHWID, types.OutputType(name), types.DefaultOutputInstance)
+	case 1:
+		return nodeHWID + "." + name
+	}
+	return name
+}
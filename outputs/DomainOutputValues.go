@@ -3,7 +3,9 @@ package outputs
 
 import (
 	"sync"
+	"time"
 
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/types"
 )
@@ -15,10 +17,27 @@ type DomainOutputValues struct {
 	latest        map[string]*types.OutputLatestMessage
 	history       map[string]*types.OutputHistoryMessage
 	event         map[string]*types.OutputEventMessage
+	forecast      map[string]*types.OutputForecastMessage
 	messageSigner *messaging.MessageSigner // subscription to output discovery messages
 	updateMutex   *sync.Mutex              // mutex for async updating of outputs
 }
 
+// GetEvent returns the last received event message of a node
+func (dov *DomainOutputValues) GetEvent(eventAddress string) (value *types.OutputEventMessage, found bool) {
+	dov.updateMutex.Lock()
+	defer dov.updateMutex.Unlock()
+	value, found = dov.event[eventAddress]
+	return value, found
+}
+
+// GetForecast returns the last received forecast message of an output
+func (dov *DomainOutputValues) GetForecast(forecastAddress string) (value *types.OutputForecastMessage, found bool) {
+	dov.updateMutex.Lock()
+	defer dov.updateMutex.Unlock()
+	value, found = dov.forecast[forecastAddress]
+	return value, found
+}
+
 // GetRaw returns the latest raw value of an output
 func (dov *DomainOutputValues) GetRaw(rawAddress string) (value string, found bool) {
 	dov.updateMutex.Lock()
@@ -35,6 +54,29 @@ func (dov *DomainOutputValues) GetLatest(latestAddress string) (value *types.Out
 	return value, found
 }
 
+// GetExpiredLatest returns the latest value messages that are no longer valid
+func (dov *DomainOutputValues) GetExpiredLatest() []*types.OutputLatestMessage {
+	dov.updateMutex.Lock()
+	defer dov.updateMutex.Unlock()
+	now := lib.Now()
+	expired := make([]*types.OutputLatestMessage, 0)
+	for _, latest := range dov.latest {
+		if isExpired(latest, now) {
+			expired = append(expired, latest)
+		}
+	}
+	return expired
+}
+
+// IsLatestExpired returns true if the latest value of an output has passed its valid until time.
+// Values without valid until time don't expire.
+func (dov *DomainOutputValues) IsLatestExpired(latestAddress string) bool {
+	dov.updateMutex.Lock()
+	defer dov.updateMutex.Unlock()
+	latest, found := dov.latest[latestAddress]
+	return found && isExpired(latest, lib.Now())
+}
+
 // UpdateEvent replaces the node event value
 func (dov *DomainOutputValues) UpdateEvent(value *types.OutputEventMessage) {
 	dov.updateMutex.Lock()
@@ -42,6 +84,13 @@ func (dov *DomainOutputValues) UpdateEvent(value *types.OutputEventMessage) {
 	dov.event[value.Address] = value
 }
 
+// UpdateForecast replaces the output forecast
+func (dov *DomainOutputValues) UpdateForecast(value *types.OutputForecastMessage) {
+	dov.updateMutex.Lock()
+	defer dov.updateMutex.Unlock()
+	dov.forecast[value.Address] = value
+}
+
 // UpdateHistory replaces the output history value
 func (dov *DomainOutputValues) UpdateHistory(value *types.OutputHistoryMessage) {
 	dov.updateMutex.Lock()
@@ -50,10 +99,18 @@ func (dov *DomainOutputValues) UpdateHistory(value *types.OutputHistoryMessage)
 }
 
 // UpdateLatest replaces the latest output value by output address
-func (dov *DomainOutputValues) UpdateLatest(value *types.OutputLatestMessage) {
+// Values with a sequence nr lower than that of the current value are older and are discarded, so
+// a value that is delayed never replaces a newer value. Values without sequence nr are always accepted.
+// Returns true if the value is accepted, false if it is discarded.
+func (dov *DomainOutputValues) UpdateLatest(value *types.OutputLatestMessage) bool {
 	dov.updateMutex.Lock()
 	defer dov.updateMutex.Unlock()
+	current, found := dov.latest[value.Address]
+	if found && value.Sequence != 0 && value.Sequence < current.Sequence {
+		return false
+	}
 	dov.latest[value.Address] = value
+	return true
 }
 
 // UpdateRaw replaces the output raw value
@@ -63,6 +120,15 @@ func (dov *DomainOutputValues) UpdateRaw(address string, value string) {
 	dov.raw[address] = value
 }
 
+// isExpired returns true if a latest value message is valid until before the given time
+func isExpired(latest *types.OutputLatestMessage, now time.Time) bool {
+	if latest.ValidUntil == "" {
+		return false
+	}
+	validUntil, err := lib.ParseTimestamp(latest.ValidUntil)
+	return err == nil && now.After(validUntil)
+}
+
 // NewDomainOutputValues creates a new instance for handling of discovered output values
 func NewDomainOutputValues(messageSigner *messaging.MessageSigner) *DomainOutputValues {
 	return &DomainOutputValues{
@@ -73,5 +139,6 @@ func NewDomainOutputValues(messageSigner *messaging.MessageSigner) *DomainOutput
 		latest:        make(map[string]*types.OutputLatestMessage, 0),
 		history:       make(map[string]*types.OutputHistoryMessage, 0),
 		event:         make(map[string]*types.OutputEventMessage, 0),
+		forecast:      make(map[string]*types.OutputForecastMessage, 0),
 	}
 }
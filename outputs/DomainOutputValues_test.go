@@ -4,11 +4,14 @@ import (
 	"crypto/ecdsa"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/outputs"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateDomainOutputValues(t *testing.T) {
@@ -39,3 +42,48 @@ func TestCreateDomainOutputValues(t *testing.T) {
 	collection.UpdateLatest(&types.OutputLatestMessage{})
 	collection.UpdateRaw(out1Addr, "raw")
 }
+
+func TestDomainOutputLatestSequence(t *testing.T) {
+	const latestAddr = "test/pub1/node1/switch/0/$latest"
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, nil, nil)
+	collection := outputs.NewDomainOutputValues(signer)
+
+	accepted := collection.UpdateLatest(&types.OutputLatestMessage{Address: latestAddr, Sequence: 10, Value: "on"})
+	assert.True(t, accepted)
+	// older values are discarded, newer and repeated values are accepted
+	accepted = collection.UpdateLatest(&types.OutputLatestMessage{Address: latestAddr, Sequence: 9, Value: "off"})
+	assert.False(t, accepted)
+	latest, _ := collection.GetLatest(latestAddr)
+	assert.Equal(t, "on", latest.Value)
+	assert.True(t, collection.UpdateLatest(&types.OutputLatestMessage{Address: latestAddr, Sequence: 10, Value: "on"}))
+	assert.True(t, collection.UpdateLatest(&types.OutputLatestMessage{Address: latestAddr, Sequence: 11, Value: "off"}))
+	// values without sequence nr are always accepted
+	assert.True(t, collection.UpdateLatest(&types.OutputLatestMessage{Address: latestAddr, Value: "on"}))
+}
+
+func TestDomainOutputExpired(t *testing.T) {
+	const latestAddr = "test/pub1/node1/temperature/0/$latest"
+	const otherAddr = "test/pub1/node2/temperature/0/$latest"
+	clock := lib.NewManualClock(time.Date(2020, 6, 1, 12, 0, 0, 0, time.Local))
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, nil, nil)
+	collection := outputs.NewDomainOutputValues(signer)
+
+	validUntil := lib.Now().Add(15 * time.Minute).Format(types.TimeFormat)
+	collection.UpdateLatest(&types.OutputLatestMessage{Address: latestAddr, ValidUntil: validUntil, Value: "12.5"})
+	collection.UpdateLatest(&types.OutputLatestMessage{Address: otherAddr, Value: "14.0"})
+	assert.False(t, collection.IsLatestExpired(latestAddr))
+	assert.Empty(t, collection.GetExpiredLatest())
+
+	// values without valid until time don't expire
+	clock.Add(16 * time.Minute)
+	assert.True(t, collection.IsLatestExpired(latestAddr))
+	assert.False(t, collection.IsLatestExpired(otherAddr))
+	assert.False(t, collection.IsLatestExpired("notanaddress"))
+	expired := collection.GetExpiredLatest()
+	require.Len(t, expired, 1)
+	assert.Equal(t, latestAddr, expired[0].Address)
+}
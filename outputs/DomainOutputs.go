@@ -4,6 +4,7 @@ package outputs
 import (
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
@@ -49,6 +50,21 @@ func (domainOutputs *DomainOutputs) GetOutput(
 	return outputObject.(*types.OutputDiscoveryMessage)
 }
 
+// GetOutputsByPattern returns the outputs whose address matches a pattern with '+' wildcards
+//  pattern is the output address pattern, eg domain/+/+/temperature/+ for all temperature outputs
+func (domainOutputs *DomainOutputs) GetOutputsByPattern(pattern string) []*types.OutputDiscoveryMessage {
+	var outputList = make([]*types.OutputDiscoveryMessage, 0)
+	domainOutputs.c.GetByPattern(pattern, &outputList)
+	return outputList
+}
+
+// GetOutputsByType returns the outputs of the given type
+func (domainOutputs *DomainOutputs) GetOutputsByType(outputType types.OutputType) []*types.OutputDiscoveryMessage {
+	var outputList = make([]*types.OutputDiscoveryMessage, 0)
+	domainOutputs.c.GetByType(string(outputType), &outputList)
+	return outputList
+}
+
 // GetOutputByAddress returns an output by its address
 // outputAddr must contain the full domain output address, eg <zone>/<publisher>/<node>/"$output"/<type>/<instance>
 // Returns nil if address has no known output
@@ -66,6 +82,13 @@ func (domainOutputs *DomainOutputs) RemoveOutput(address string) {
 	domainOutputs.c.Remove(address)
 }
 
+// StartBulkLoad defers building the lookup indexes of the discovered outputs until the burst of
+// retained discovery messages on subscribing subsides, see lib.DomainCollection.StartBulkLoad
+//  quietPeriod is the time without discovery messages after which the indexes are built
+func (domainOutputs *DomainOutputs) StartBulkLoad(quietPeriod time.Duration) {
+	domainOutputs.c.StartBulkLoad(quietPeriod)
+}
+
 // Subscribe to outputs from a domain publisher
 func (domainOutputs *DomainOutputs) Subscribe(domain string, publisherID string) {
 	// subscription address for all outputs domain/publisher/node/type/instance/$output
@@ -97,8 +120,14 @@ func MakeOutputDiscoveryAddress(domain string, publisherID string, nodeID string
 
 // NewDomainOutputs creates a new instance for handling of discovered domain outputs
 func NewDomainOutputs(messageSigner *messaging.MessageSigner) *DomainOutputs {
+	domainCollection := lib.NewDomainCollection(
+		reflect.TypeOf(&types.OutputDiscoveryMessage{}), messageSigner.GetPublicKey)
+	domainCollection.ReceiveHook = messageSigner.ApplyReceiveHooks
+	domainCollection.GetItemType = func(item interface{}) string {
+		return string(item.(*types.OutputDiscoveryMessage).OutputType)
+	}
 	return &DomainOutputs{
-		c:             lib.NewDomainCollection(reflect.TypeOf(&types.OutputDiscoveryMessage{}), messageSigner.GetPublicKey),
+		c:             domainCollection,
 		messageSigner: messageSigner,
 	}
 }
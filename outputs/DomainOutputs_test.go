@@ -53,6 +53,19 @@ func TestCreateDomainOutputs(t *testing.T) {
 	out1 = collection.GetOutputByAddress("not/an address")
 	assert.Nil(t, out1)
 
+	// filter by type and address pattern
+	output2 := outputs.NewOutput(domain, "pub2", node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	collection.AddOutput(output2)
+	outList = collection.GetOutputsByType(types.OutputTypeTemperature)
+	require.Equal(t, 1, len(outList))
+	assert.Equal(t, output2.Address, outList[0].Address)
+	outList = collection.GetOutputsByPattern(domain + "/+/" + node1ID + "/+/+")
+	assert.Equal(t, 2, len(outList))
+	outList = collection.GetOutputsByPattern(domain + "/+/+/" + string(out1Type) + "/+/$output")
+	assert.Equal(t, 1, len(outList))
+	collection.RemoveOutput(output2.Address)
+	assert.Equal(t, 0, len(collection.GetOutputsByType(types.OutputTypeTemperature)))
+
 	// remove the output
 	collection.RemoveOutput(output1.Address)
 	outList = collection.GetAllOutputs()
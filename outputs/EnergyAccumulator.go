@@ -0,0 +1,280 @@
+// Package outputs with accumulation of power values into energy counters
+package outputs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// DefaultMaxPowerAge is the default max nr of seconds that a power value is accumulated after it is
+// measured. Power values are only updated when they change or after the repeat delay of an hour.
+const DefaultMaxPowerAge = 7200
+
+// EnergyDecimals is the nr of decimals of published energy counters in kWh
+const EnergyDecimals = 3
+
+// Energy counter instances. Counters of a power output with another than the default instance
+// are prefixed with the power instance, see MakeEnergyInstance.
+const (
+	EnergyInstanceDay   = "day"   // energy of the current day, reset at midnight
+	EnergyInstanceMonth = "month" // energy of the current month, reset on the first of the month
+	EnergyInstanceTotal = "total" // total accumulated energy
+)
+
+// EnergyCounters holds the accumulated energy of a power output in kWh. The counters are persisted
+// so accumulation continues after a restart.
+type EnergyCounters struct {
+	AccumulatedUntil string             `json:"accumulatedUntil"`  // time up to which power is accumulated, in TimeFormat
+	Day              string             `json:"day"`               // date of the day counter, yyyy-mm-dd
+	DayEnergy        float64            `json:"dayEnergy"`         // energy of the day
+	Month            string             `json:"month"`             // month of the month counter, yyyy-mm
+	MonthEnergy      float64            `json:"monthEnergy"`       // energy of the month
+	Power            float64            `json:"power"`             // latest power in Watt
+	PowerTime        string             `json:"powerTime"`         // time the latest power was measured, in TimeFormat
+	Tariffs          map[string]float64 `json:"tariffs,omitempty"` // total energy by tariff name
+	Total            float64            `json:"total"`             // total energy
+}
+
+// EnergyTariff is a time-of-use window whose energy is counted separately, eg peak and off-peak
+type EnergyTariff struct {
+	Name   string   `yaml:"name"`   // name of the tariff, used as output instance
+	After  string   `yaml:"after"`  // the time of day is at or after hh:mm. Default is midnight
+	Before string   `yaml:"before"` // the time of day is before hh:mm. Wraps past midnight if before 'after'
+	Days   []string `yaml:"days"`   // the weekday is one of, eg sat, sun. Default is every day
+}
+
+// EnergyOptions for accumulating a power output into energy counters
+type EnergyOptions struct {
+	MaxPowerAge int            `yaml:"maxPowerAge"` // max seconds a power value is accumulated after it is measured. Default (0) is DefaultMaxPowerAge
+	Tariffs     []EnergyTariff `yaml:"tariffs"`     // optional tariff windows. Energy is counted for the first tariff whose window matches
+}
+
+// EnergyAccumulator integrates the values of a power output over time into energy counters.
+// A power value holds until the next value is measured, as values are only updated on change,
+// for up to the max power age. Counters of the day and month roll over at local midnight.
+type EnergyAccumulator struct {
+	counters    EnergyCounters // accumulated energy
+	maxPowerAge time.Duration  // max duration a power value is accumulated after it is measured
+	tariffs     []EnergyTariff // tariff windows
+	updateMutex *sync.Mutex    // mutex for concurrent accumulation
+}
+
+// energyWeekdays by the first three letters of their name
+var energyWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Accumulate the latest power up to the given time and roll over the counters of the day and month
+// Power is not accumulated past the max power age or before the time it is measured.
+func (accumulator *EnergyAccumulator) Accumulate(until time.Time) {
+	accumulator.updateMutex.Lock()
+	defer accumulator.updateMutex.Unlock()
+	accumulator.accumulate(until)
+}
+
+// Counters returns a copy of the energy counters
+func (accumulator *EnergyAccumulator) Counters() EnergyCounters {
+	accumulator.updateMutex.Lock()
+	defer accumulator.updateMutex.Unlock()
+	counters := accumulator.counters
+	counters.Tariffs = make(map[string]float64, len(accumulator.counters.Tariffs))
+	for name, energy := range accumulator.counters.Tariffs {
+		counters.Tariffs[name] = energy
+	}
+	return counters
+}
+
+// GetTariff returns the name of the tariff at the given time, or "" if no tariff window matches
+func (accumulator *EnergyAccumulator) GetTariff(at time.Time) string {
+	at = at.Local()
+	minutes := at.Hour()*60 + at.Minute()
+	for _, tariff := range accumulator.tariffs {
+		if tariff.isActive(minutes, at.Weekday()) {
+			return tariff.Name
+		}
+	}
+	return ""
+}
+
+// SetPower accumulates the previous power up to the time the new power is measured and holds the
+// new power from then on. Values measured before the latest power are ignored.
+//  watt is the measured power
+//  measured is the time the power is measured
+// Returns false if the value is ignored
+func (accumulator *EnergyAccumulator) SetPower(watt float64, measured time.Time) bool {
+	accumulator.updateMutex.Lock()
+	defer accumulator.updateMutex.Unlock()
+	powerTime, err := time.Parse(types.TimeFormat, accumulator.counters.PowerTime)
+	if err == nil && !measured.After(powerTime) {
+		return false
+	}
+	accumulator.accumulate(measured)
+	accumulator.counters.Power = watt
+	accumulator.counters.PowerTime = measured.Format(types.TimeFormat)
+	return true
+}
+
+// accumulate the power up to the given time in steps that don't cross a minute, so each step
+// falls within a single tariff window, day and month.
+// For internal use only. Use within locked section.
+func (accumulator *EnergyAccumulator) accumulate(until time.Time) {
+	counters := &accumulator.counters
+	from, errFrom := time.Parse(types.TimeFormat, counters.AccumulatedUntil)
+	if errFrom == nil && !until.After(from) {
+		return
+	}
+	powerTime, errPower := time.Parse(types.TimeFormat, counters.PowerTime)
+	if errFrom == nil && errPower == nil {
+		end := until
+		if maxEnd := powerTime.Add(accumulator.maxPowerAge); end.After(maxEnd) {
+			end = maxEnd
+		}
+		for from.Before(end) {
+			to := from.Truncate(time.Minute).Add(time.Minute)
+			if to.After(end) {
+				to = end
+			}
+			accumulator.rollover(from)
+			energy := counters.Power * to.Sub(from).Hours() / 1000
+			counters.DayEnergy += energy
+			counters.MonthEnergy += energy
+			counters.Total += energy
+			if tariff := accumulator.GetTariff(from); tariff != "" {
+				counters.Tariffs[tariff] += energy
+			}
+			from = to
+		}
+	}
+	counters.AccumulatedUntil = until.Format(types.TimeFormat)
+	accumulator.rollover(until)
+}
+
+// rollover resets the counters of the day and month when the given time is in another day or month
+// For internal use only. Use within locked section.
+func (accumulator *EnergyAccumulator) rollover(at time.Time) {
+	at = at.Local()
+	if day := at.Format("2006-01-02"); day != accumulator.counters.Day {
+		accumulator.counters.Day = day
+		accumulator.counters.DayEnergy = 0
+	}
+	if month := at.Format("2006-01"); month != accumulator.counters.Month {
+		accumulator.counters.Month = month
+		accumulator.counters.MonthEnergy = 0
+	}
+}
+
+// isActive returns true if the tariff window includes the minutes since midnight on the weekday
+func (tariff *EnergyTariff) isActive(minutes int, weekday time.Weekday) bool {
+	if len(tariff.Days) > 0 {
+		isDay := false
+		for _, day := range tariff.Days {
+			isDay = isDay || energyWeekdays[energyWeekdayKey(day)] == weekday
+		}
+		if !isDay {
+			return false
+		}
+	}
+	after, errAfter := parseEnergyTimeOfDay(tariff.After)
+	before, errBefore := parseEnergyTimeOfDay(tariff.Before)
+	if errAfter == nil && errBefore == nil && before < after {
+		// the window wraps past midnight
+		return minutes >= after || minutes < before
+	}
+	return (errAfter != nil || minutes >= after) && (errBefore != nil || minutes < before)
+}
+
+// validate returns an error if the tariff window is invalid
+func (tariff *EnergyTariff) validate() error {
+	if tariff.Name == "" || strings.ContainsAny(tariff.Name, "./#+ ") {
+		return fmt.Errorf("Invalid tariff name '%s'", tariff.Name)
+	}
+	for _, timeOfDay := range []string{tariff.After, tariff.Before} {
+		if _, err := parseEnergyTimeOfDay(timeOfDay); timeOfDay != "" && err != nil {
+			return fmt.Errorf("Tariff '%s': %s", tariff.Name, err)
+		}
+	}
+	for _, day := range tariff.Days {
+		if _, found := energyWeekdays[energyWeekdayKey(day)]; !found {
+			return fmt.Errorf("Tariff '%s' has invalid day '%s'", tariff.Name, day)
+		}
+	}
+	return nil
+}
+
+// FormatEnergy returns the energy counter value to publish
+func FormatEnergy(energy float64) string {
+	scale := math.Pow10(EnergyDecimals)
+	return strconv.FormatFloat(math.Round(energy*scale)/scale, 'f', EnergyDecimals, 64)
+}
+
+// MakeEnergyInstance returns the output instance of an energy counter of a power output
+//  powerInstance is the instance of the power output
+//  counter is EnergyInstanceTotal, EnergyInstanceDay, EnergyInstanceMonth or the name of a tariff
+func MakeEnergyInstance(powerInstance string, counter string) string {
+	if powerInstance == "" || powerInstance == types.DefaultOutputInstance {
+		return counter
+	}
+	return powerInstance + "-" + counter
+}
+
+// energyWeekdayKey returns the key of a day name in energyWeekdays
+func energyWeekdayKey(day string) string {
+	day = strings.ToLower(strings.TrimSpace(day))
+	if len(day) > 3 {
+		day = day[:3]
+	}
+	return day
+}
+
+// parseEnergyTimeOfDay returns the minutes since midnight of a hh:mm time
+func parseEnergyTimeOfDay(timeOfDay string) (int, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(timeOfDay))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time of day '%s'", timeOfDay)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// NewEnergyAccumulator creates an accumulator of power values into energy counters
+//  options with the max power age and tariff windows
+//  counters are the previously persisted counters to continue with, nil to start at 0
+// Returns an error if a tariff is invalid or its name is used twice or by a counter.
+func NewEnergyAccumulator(options EnergyOptions, counters *EnergyCounters) (*EnergyAccumulator, error) {
+	isUsed := map[string]bool{EnergyInstanceDay: true, EnergyInstanceMonth: true, EnergyInstanceTotal: true}
+	for _, tariff := range options.Tariffs {
+		err := tariff.validate()
+		if err == nil && isUsed[tariff.Name] {
+			err = fmt.Errorf("Tariff name '%s' is already used", tariff.Name)
+		}
+		if err != nil {
+			return nil, lib.MakeErrorf("NewEnergyAccumulator: %s", err)
+		}
+		isUsed[tariff.Name] = true
+	}
+	maxPowerAge := options.MaxPowerAge
+	if maxPowerAge <= 0 {
+		maxPowerAge = DefaultMaxPowerAge
+	}
+	accumulator := &EnergyAccumulator{
+		maxPowerAge: time.Duration(maxPowerAge) * time.Second,
+		tariffs:     options.Tariffs,
+		updateMutex: &sync.Mutex{},
+	}
+	if counters != nil {
+		accumulator.counters = *counters
+	}
+	tariffs := make(map[string]float64, len(options.Tariffs))
+	for _, tariff := range options.Tariffs {
+		tariffs[tariff.Name] = accumulator.counters.Tariffs[tariff.Name]
+	}
+	accumulator.counters.Tariffs = tariffs
+	return accumulator, nil
+}
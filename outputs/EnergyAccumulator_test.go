@@ -0,0 +1,82 @@
+package outputs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnergyAccumulator(t *testing.T) {
+	options := outputs.EnergyOptions{
+		MaxPowerAge: 3 * 3600,
+		Tariffs: []outputs.EnergyTariff{
+			{Name: "peak", After: "07:00", Before: "23:00", Days: []string{"mon", "tue", "wed", "thu", "fri"}},
+			{Name: "offpeak"},
+		},
+	}
+	accumulator, err := outputs.NewEnergyAccumulator(options, nil)
+	require.NoError(t, err)
+	// friday 22:00
+	start := time.Date(2020, 1, 31, 22, 0, 0, 0, time.Local)
+	assert.Equal(t, "peak", accumulator.GetTariff(start))
+	assert.Equal(t, "offpeak", accumulator.GetTariff(start.Add(2*time.Hour)))
+
+	// 1000W holds for 2.5 hours of which an hour in peak and half an hour in the next day and month
+	assert.True(t, accumulator.SetPower(1000, start))
+	accumulator.Accumulate(start.Add(time.Hour))
+	counters := accumulator.Counters()
+	assert.InDelta(t, 1, counters.Total, 0.0001)
+	assert.InDelta(t, 1, counters.DayEnergy, 0.0001)
+	assert.InDelta(t, 1, counters.Tariffs["peak"], 0.0001)
+	assert.Equal(t, "2020-01-31", counters.Day)
+
+	// the previous power is accumulated up to the time the new power is measured
+	assert.True(t, accumulator.SetPower(500, start.Add(150*time.Minute)))
+	assert.False(t, accumulator.SetPower(100, start.Add(time.Hour)))
+	counters = accumulator.Counters()
+	assert.InDelta(t, 2.5, counters.Total, 0.0001)
+	assert.InDelta(t, 0.5, counters.DayEnergy, 0.0001)
+	assert.InDelta(t, 0.5, counters.MonthEnergy, 0.0001)
+	assert.InDelta(t, 1.5, counters.Tariffs["offpeak"], 0.0001)
+	assert.Equal(t, "2020-02-01", counters.Day)
+	assert.Equal(t, "2020-02", counters.Month)
+
+	// power is not accumulated past its max age
+	accumulator.Accumulate(start.Add(10 * time.Hour))
+	counters = accumulator.Counters()
+	assert.InDelta(t, 4, counters.Total, 0.0001)
+	assert.InDelta(t, 3, counters.Tariffs["offpeak"], 0.0001)
+
+	// persisted counters continue
+	accumulator2, err := outputs.NewEnergyAccumulator(options, &counters)
+	require.NoError(t, err)
+	accumulator2.SetPower(1000, start.Add(11*time.Hour))
+	accumulator2.Accumulate(start.Add(12 * time.Hour))
+	assert.InDelta(t, 5, accumulator2.Counters().Total, 0.0001)
+	// the day rolls over without power
+	accumulator2.Accumulate(start.Add(27 * time.Hour))
+	assert.Equal(t, "2020-02-02", accumulator2.Counters().Day)
+	assert.InDelta(t, 0, accumulator2.Counters().DayEnergy, 0.0001)
+
+	assert.Equal(t, "1.235", outputs.FormatEnergy(1.23456))
+	assert.Equal(t, "total", outputs.MakeEnergyInstance("0", outputs.EnergyInstanceTotal))
+	assert.Equal(t, "2-day", outputs.MakeEnergyInstance("2", outputs.EnergyInstanceDay))
+}
+
+func TestEnergyAccumulatorBadTariffs(t *testing.T) {
+	badTariffs := [][]outputs.EnergyTariff{
+		{{Name: ""}},
+		{{Name: "a.b"}},
+		{{Name: "total"}},
+		{{Name: "peak"}, {Name: "peak"}},
+		{{Name: "peak", After: "7am"}},
+		{{Name: "peak", Days: []string{"someday"}}},
+	}
+	for _, tariffs := range badTariffs {
+		_, err := outputs.NewEnergyAccumulator(outputs.EnergyOptions{Tariffs: tariffs}, nil)
+		assert.Error(t, err)
+	}
+}
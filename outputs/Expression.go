@@ -0,0 +1,312 @@
+// Package outputs with a small expression language for derived output values
+package outputs
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+)
+
+// MaxExpressionLength is the max nr of characters of an expression. This limits the nesting and
+// the time to evaluate an expression.
+const MaxExpressionLength = 1000
+
+// Expression is a parsed arithmetic expression, eg: voltage * current
+// Expressions support numbers, variables, the operators + - * / % ^, parentheses and the functions
+// in expressionFunctions. Variables hold the values of outputs, see EvaluateDerivedValue.
+// Expressions can't loop, assign or call anything but the built-in functions, so they are safe to
+// evaluate when received from the node configuration.
+type Expression struct {
+	root      *expressionNode // root of the syntax tree
+	text      string          // the expression as parsed
+	variables []string        // names of the variables in the expression, sorted
+}
+
+// expressionNode is a node in the syntax tree of an expression
+type expressionNode struct {
+	args  []*expressionNode // operands of an operator or arguments of a function
+	name  string            // name of a variable or function
+	op    byte              // operator, or 'n' for a number, 'v' for a variable, 'f' for a function
+	value float64           // value of a number
+}
+
+// expressionFunction is a function that can be used in expressions
+type expressionFunction struct {
+	argCount int // nr of arguments, -1 for one or more
+	call     func(args []float64) float64
+}
+
+// expressionFunctions are the functions that can be used in expressions
+var expressionFunctions = map[string]expressionFunction{
+	"abs":   {1, func(args []float64) float64 { return math.Abs(args[0]) }},
+	"exp":   {1, func(args []float64) float64 { return math.Exp(args[0]) }},
+	"ln":    {1, func(args []float64) float64 { return math.Log(args[0]) }},
+	"log10": {1, func(args []float64) float64 { return math.Log10(args[0]) }},
+	"pow":   {2, func(args []float64) float64 { return math.Pow(args[0], args[1]) }},
+	"round": {1, func(args []float64) float64 { return math.Round(args[0]) }},
+	"sqrt":  {1, func(args []float64) float64 { return math.Sqrt(args[0]) }},
+	"max": {-1, func(args []float64) float64 {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result
+	}},
+	"min": {-1, func(args []float64) float64 {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+		return result
+	}},
+	// dewpoint(temperature in C, relative humidity in %) using the Magnus formula
+	"dewpoint": {2, func(args []float64) float64 {
+		const a, b = 17.62, 243.12
+		gamma := math.Log(args[1]/100) + a*args[0]/(b+args[0])
+		return b * gamma / (a - gamma)
+	}},
+}
+
+// Evaluate the expression with the given variable values
+// Returns an error if a variable has no value or the result is not a number, eg after a division by zero.
+func (expression *Expression) Evaluate(variables map[string]float64) (float64, error) {
+	result, err := expression.root.evaluate(variables)
+	if err == nil && (math.IsNaN(result) || math.IsInf(result, 0)) {
+		err = fmt.Errorf("Expression '%s' has no numeric result", expression.text)
+	}
+	return result, err
+}
+
+// String returns the expression text
+func (expression *Expression) String() string {
+	return expression.text
+}
+
+// Variables returns the names of the variables that are used in the expression, sorted by name
+func (expression *Expression) Variables() []string {
+	return expression.variables
+}
+
+// evaluate the syntax tree node
+func (node *expressionNode) evaluate(variables map[string]float64) (float64, error) {
+	switch node.op {
+	case 'n':
+		return node.value, nil
+	case 'v':
+		value, found := variables[node.name]
+		if !found {
+			return 0, fmt.Errorf("Variable '%s' has no value", node.name)
+		}
+		return value, nil
+	}
+	args := make([]float64, len(node.args))
+	for i, arg := range node.args {
+		value, err := arg.evaluate(variables)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = value
+	}
+	switch node.op {
+	case 'f':
+		return expressionFunctions[node.name].call(args), nil
+	case 'u':
+		return -args[0], nil
+	case '+':
+		return args[0] + args[1], nil
+	case '-':
+		return args[0] - args[1], nil
+	case '*':
+		return args[0] * args[1], nil
+	case '/':
+		return args[0] / args[1], nil
+	case '%':
+		return math.Mod(args[0], args[1]), nil
+	case '^':
+		return math.Pow(args[0], args[1]), nil
+	}
+	return 0, fmt.Errorf("Unknown operator '%c'", node.op)
+}
+
+// expressionParser is a recursive descent parser of expressions:
+//  expression = term { ("+" | "-") term }
+//  term       = unary { ("*" | "/" | "%") unary }
+//  unary      = ("-" | "+") unary | power
+//  power      = primary [ "^" unary ]
+//  primary    = number | variable | function "(" expression { "," expression } ")" | "(" expression ")"
+type expressionParser struct {
+	pos       int             // position of the next character
+	text      string          // text being parsed
+	variables map[string]bool // variables found
+}
+
+// parseExpression parses an expression with operators of the lowest precedence
+func (parser *expressionParser) parseExpression() (*expressionNode, error) {
+	left, err := parser.parseTerm()
+	for err == nil && (parser.peek() == '+' || parser.peek() == '-') {
+		op := parser.next()
+		var right *expressionNode
+		right, err = parser.parseTerm()
+		left = &expressionNode{op: op, args: []*expressionNode{left, right}}
+	}
+	return left, err
+}
+
+// parseTerm parses a multiplication, division or remainder
+func (parser *expressionParser) parseTerm() (*expressionNode, error) {
+	left, err := parser.parseUnary()
+	for err == nil && (parser.peek() == '*' || parser.peek() == '/' || parser.peek() == '%') {
+		op := parser.next()
+		var right *expressionNode
+		right, err = parser.parseUnary()
+		left = &expressionNode{op: op, args: []*expressionNode{left, right}}
+	}
+	return left, err
+}
+
+// parseUnary parses a negation or a power
+func (parser *expressionParser) parseUnary() (*expressionNode, error) {
+	if parser.peek() == '+' {
+		parser.next()
+		return parser.parseUnary()
+	} else if parser.peek() == '-' {
+		parser.next()
+		operand, err := parser.parseUnary()
+		return &expressionNode{op: 'u', args: []*expressionNode{operand}}, err
+	}
+	return parser.parsePower()
+}
+
+// parsePower parses a value that is optionally raised to a power. Powers are right associative.
+func (parser *expressionParser) parsePower() (*expressionNode, error) {
+	base, err := parser.parsePrimary()
+	if err == nil && parser.peek() == '^' {
+		parser.next()
+		var exponent *expressionNode
+		exponent, err = parser.parseUnary()
+		base = &expressionNode{op: '^', args: []*expressionNode{base, exponent}}
+	}
+	return base, err
+}
+
+// parsePrimary parses a number, variable, function call or expression in parentheses
+func (parser *expressionParser) parsePrimary() (*expressionNode, error) {
+	c := parser.peek()
+	switch {
+	case c == '(':
+		parser.next()
+		node, err := parser.parseExpression()
+		if err == nil && parser.next() != ')' {
+			err = fmt.Errorf("Missing ')' at position %d", parser.pos)
+		}
+		return node, err
+	case c >= '0' && c <= '9' || c == '.':
+		start := parser.pos
+		for parser.pos < len(parser.text) && strings.IndexByte("0123456789.eE", parser.text[parser.pos]) >= 0 {
+			// the sign of an exponent is part of the number
+			if c := parser.text[parser.pos]; (c == 'e' || c == 'E') && parser.pos+1 < len(parser.text) &&
+				(parser.text[parser.pos+1] == '-' || parser.text[parser.pos+1] == '+') {
+				parser.pos++
+			}
+			parser.pos++
+		}
+		value, err := strconv.ParseFloat(parser.text[start:parser.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number '%s'", parser.text[start:parser.pos])
+		}
+		return &expressionNode{op: 'n', value: value}, nil
+	case isNameChar(c, true):
+		start := parser.pos
+		for parser.pos < len(parser.text) && isNameChar(parser.text[parser.pos], false) {
+			parser.pos++
+		}
+		name := parser.text[start:parser.pos]
+		if parser.peek() != '(' {
+			parser.variables[name] = true
+			return &expressionNode{op: 'v', name: name}, nil
+		}
+		return parser.parseFunction(name)
+	case c == 0:
+		return nil, fmt.Errorf("Unexpected end of expression")
+	}
+	return nil, fmt.Errorf("Unexpected '%c' at position %d", c, parser.pos)
+}
+
+// parseFunction parses the arguments of a function call
+func (parser *expressionParser) parseFunction(name string) (*expressionNode, error) {
+	function, found := expressionFunctions[name]
+	if !found {
+		return nil, fmt.Errorf("Unknown function '%s'", name)
+	}
+	parser.next()
+	node := &expressionNode{op: 'f', name: name}
+	for {
+		arg, err := parser.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		node.args = append(node.args, arg)
+		if separator := parser.next(); separator == ')' {
+			break
+		} else if separator != ',' {
+			return nil, fmt.Errorf("Missing ')' after arguments of '%s'", name)
+		}
+	}
+	if function.argCount >= 0 && len(node.args) != function.argCount {
+		return nil, fmt.Errorf("Function '%s' takes %d arguments", name, function.argCount)
+	}
+	return node, nil
+}
+
+// next returns the next character after whitespace and advances past it, or 0 at the end
+func (parser *expressionParser) next() byte {
+	c := parser.peek()
+	if c != 0 {
+		parser.pos++
+	}
+	return c
+}
+
+// peek returns the next character after whitespace, or 0 at the end
+func (parser *expressionParser) peek() byte {
+	for parser.pos < len(parser.text) && (parser.text[parser.pos] == ' ' || parser.text[parser.pos] == '\t') {
+		parser.pos++
+	}
+	if parser.pos >= len(parser.text) {
+		return 0
+	}
+	return parser.text[parser.pos]
+}
+
+// isNameChar returns true if the character can be used in a variable or function name.
+// Names start with a letter and can contain digits, '_' and '.'.
+func isNameChar(c byte, isFirst bool) bool {
+	isLetter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+	return isLetter || (!isFirst && ((c >= '0' && c <= '9') || c == '.'))
+}
+
+// ParseExpression parses an arithmetic expression, eg: dewpoint(temperature, humidity)
+// Returns an error if the expression is invalid or longer than MaxExpressionLength.
+func ParseExpression(text string) (*Expression, error) {
+	if len(text) > MaxExpressionLength {
+		return nil, lib.MakeErrorf("ParseExpression: Expression is longer than %d characters", MaxExpressionLength)
+	}
+	parser := expressionParser{text: text, variables: make(map[string]bool)}
+	root, err := parser.parseExpression()
+	if err == nil && parser.peek() != 0 {
+		err = fmt.Errorf("Unexpected '%c' at position %d", parser.peek(), parser.pos)
+	}
+	if err != nil {
+		return nil, lib.MakeErrorf("ParseExpression: Invalid expression '%s': %s", text, err)
+	}
+	expression := &Expression{root: root, text: text, variables: make([]string, 0, len(parser.variables))}
+	for name := range parser.variables {
+		expression.variables = append(expression.variables, name)
+	}
+	sort.Strings(expression.variables)
+	return expression, nil
+}
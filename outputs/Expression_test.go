@@ -0,0 +1,82 @@
+package outputs_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpression(t *testing.T) {
+	variables := map[string]float64{"voltage": 230, "current": 2.5, "temperature.1": 20, "humidity": 50}
+	testCases := map[string]float64{
+		"voltage * current":            575,
+		"1 + 2 * 3":                    7,
+		"(1 + 2) * 3":                  9,
+		"-2 ^ 2":                       -4,
+		"2 ^ 3 ^ 2":                    512,
+		"10 % 4 - 1.5e1 / 3":           -3,
+		"+voltage - -current":          232.5,
+		"max(1, current, 2) + abs(-1)": 3.5,
+		"min(voltage, 3)":              3,
+		"round(sqrt(2) * 100)":         141,
+		"pow(2, 10) + ln(exp(1))":      1025,
+		"log10(1000)":                  3,
+	}
+	for text, expected := range testCases {
+		expression, err := outputs.ParseExpression(text)
+		require.NoError(t, err, text)
+		result, err := expression.Evaluate(variables)
+		require.NoError(t, err, text)
+		assert.InDelta(t, expected, result, 1e-9, text)
+	}
+	expression, err := outputs.ParseExpression("dewpoint(temperature.1, humidity)")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"humidity", "temperature.1"}, expression.Variables())
+	result, err := expression.Evaluate(variables)
+	require.NoError(t, err)
+	assert.InDelta(t, 9.26, result, 0.01)
+
+	// errors
+	invalid := []string{"", "1 +", "(1 + 2", "1 2", "2 * * 3", "unknown(1)", "min()", "pow(1)",
+		"abs(1, 2)", "1.2.3", "voltage $ 2", strings.Repeat("1+", outputs.MaxExpressionLength)}
+	for _, text := range invalid {
+		_, err = outputs.ParseExpression(text)
+		assert.Error(t, err, text)
+	}
+	expression, _ = outputs.ParseExpression("voltage / (current - 2.5)")
+	_, err = expression.Evaluate(variables)
+	assert.Error(t, err, "division by zero")
+	expression, _ = outputs.ParseExpression("power * 2")
+	_, err = expression.Evaluate(variables)
+	assert.Error(t, err, "variable without value")
+}
+
+func TestEvaluateDerivedValue(t *testing.T) {
+	values := map[string]string{
+		"node1.voltage.0": "230", "node1.current.0": "0.1", "node1.temperature.1": "20", "meter.power.0": "1000"}
+	getValue := func(outputID string) (string, bool) {
+		value, found := values[outputID]
+		return value, found
+	}
+	assert.Equal(t, "node1.voltage.0", outputs.ResolveExpressionVariable("node1", "voltage"))
+	assert.Equal(t, "node1.temperature.1", outputs.ResolveExpressionVariable("node1", "temperature.1"))
+	assert.Equal(t, "meter.power.0", outputs.ResolveExpressionVariable("node1", "meter.power.0"))
+
+	// floating point noise is removed
+	expression, _ := outputs.ParseExpression("voltage * current + temperature.1 - meter.power.0")
+	value, err := outputs.EvaluateDerivedValue(expression, "node1", getValue)
+	require.NoError(t, err)
+	assert.Equal(t, "-957", value)
+
+	// outputs without value or with a value that isn't a number can't be used
+	expression, _ = outputs.ParseExpression("voltage * power")
+	_, err = outputs.EvaluateDerivedValue(expression, "node1", getValue)
+	assert.Error(t, err)
+	values["node1.current.0"] = "off"
+	expression, _ = outputs.ParseExpression("voltage * current")
+	_, err = outputs.EvaluateDerivedValue(expression, "node1", getValue)
+	assert.Error(t, err)
+}
@@ -0,0 +1,90 @@
+// Package outputs with retention policy of the output value history
+package outputs
+
+import (
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// DefaultHistoryMaxAge is the default maximum age of history values in seconds, 24 hours
+const DefaultHistoryMaxAge = 24 * 3600
+
+// historyValueOverhead is the estimated memory use of a history value in addition to its value and timestamp
+const historyValueOverhead = 32
+
+// HistoryRetention policy for the output value history.
+// When the number of values or memory use exceeds the limit, the older half of the history is
+// downsampled by removing every other value until the history is within its limits. Values that
+// exceed the maximum age are removed. A limit of 0 means no limit. Outputs without a retention
+// policy use DefaultHistoryRetention, which limits the age to DefaultHistoryMaxAge.
+type HistoryRetention struct {
+	MaxCount int `yaml:"maxCount"` // max nr of values in the history, 0 for no limit
+	MaxAge   int `yaml:"maxAge"`   // max age of values in seconds, 0 for no limit
+	MaxBytes int `yaml:"maxBytes"` // max estimated memory use of the history in bytes, 0 for no limit
+}
+
+// DefaultHistoryRetention retains 24 hours of history without count or memory limit
+var DefaultHistoryRetention = HistoryRetention{MaxAge: DefaultHistoryMaxAge}
+
+// HistorySize returns the estimated memory use of the history in bytes
+func HistorySize(history OutputHistory) int {
+	size := 0
+	for _, entry := range history {
+		size += len(entry.Value) + len(entry.Timestamp) + historyValueOverhead
+	}
+	return size
+}
+
+// ApplyRetention returns the history with the retention policy applied.
+// The latest value at the front of the history is always retained.
+// This function is not thread-safe and should only be used from within a locked section
+//  now is the time to determine the age of the values
+func ApplyRetention(history OutputHistory, retention HistoryRetention, now time.Time) OutputHistory {
+	// remove values that are too old, oldest values are at the end
+	if retention.MaxAge > 0 {
+		maxAge := time.Duration(retention.MaxAge) * time.Second
+		newLength := len(history)
+		for ; newLength > 1; newLength-- {
+			entryTime := time.Unix(history[newLength-1].EpochTime, 0)
+			if now.Sub(entryTime) <= maxAge {
+				break
+			}
+		}
+		history = history[0:newLength]
+	}
+	// downsample until within the count and memory limits
+	for len(history) > 1 {
+		overCount := retention.MaxCount > 0 && len(history) > retention.MaxCount
+		overSize := retention.MaxBytes > 0 && HistorySize(history) > retention.MaxBytes
+		if !overCount && !overSize {
+			break
+		}
+		history = downsampleHistory(history)
+	}
+	return history
+}
+
+// downsampleHistory removes every other value from the older half of the history
+// This keeps the recent values at full resolution while retaining the trend of older values.
+func downsampleHistory(history OutputHistory) OutputHistory {
+	if len(history) <= 2 {
+		return history[0:1]
+	}
+	half := len(history) / 2
+	newHistory := make(OutputHistory, 0, len(history))
+	newHistory = append(newHistory, history[0:half]...)
+	for i := half; i < len(history); i += 2 {
+		newHistory = append(newHistory, history[i])
+	}
+	return newHistory
+}
+
+// NewHistoryValue creates a history value with the given time
+func NewHistoryValue(value string, timeStamp time.Time) types.OutputValue {
+	return types.OutputValue{
+		Timestamp: timeStamp.Format(types.TimeFormat),
+		EpochTime: timeStamp.Unix(),
+		Value:     value,
+	}
+}
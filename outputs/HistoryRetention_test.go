@@ -0,0 +1,70 @@
+package outputs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// makeHistory creates a history with the given nr of values, one per minute, newest first
+func makeHistory(count int, now time.Time) outputs.OutputHistory {
+	history := make(outputs.OutputHistory, 0)
+	for i := 0; i < count; i++ {
+		history = append(history, outputs.NewHistoryValue("value", now.Add(-time.Duration(i)*time.Minute)))
+	}
+	return history
+}
+
+func TestApplyRetention(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+
+	// max age removes old values
+	history := makeHistory(100, now)
+	history = outputs.ApplyRetention(history, outputs.HistoryRetention{MaxAge: 30 * 60}, now)
+	assert.Equal(t, 31, len(history))
+
+	// max count downsamples the older half
+	history = makeHistory(100, now)
+	history = outputs.ApplyRetention(history, outputs.HistoryRetention{MaxCount: 60}, now)
+	assert.LessOrEqual(t, len(history), 60)
+	assert.Equal(t, now.Unix(), history[0].EpochTime, "latest value must be retained")
+	assert.Equal(t, now.Add(-time.Minute).Unix(), history[1].EpochTime, "recent values must be retained")
+	assert.Less(t, history[len(history)-1].EpochTime, now.Add(-90*time.Minute).Unix(), "trend must be retained")
+
+	// max bytes downsamples until within the memory budget
+	history = makeHistory(100, now)
+	maxBytes := outputs.HistorySize(history) / 4
+	history = outputs.ApplyRetention(history, outputs.HistoryRetention{MaxBytes: maxBytes}, now)
+	assert.LessOrEqual(t, outputs.HistorySize(history), maxBytes)
+
+	// the latest value is always kept
+	history = makeHistory(3, now)
+	history = outputs.ApplyRetention(history, outputs.HistoryRetention{MaxCount: 1, MaxBytes: 1}, now)
+	assert.Equal(t, 1, len(history))
+}
+
+func TestOutputValueRetention(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	const node1ID = "node1"
+	collection := outputs.NewRegisteredOutputValues(domain, publisher1ID)
+	outputID := outputs.MakeOutputID(node1ID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+	assert.Equal(t, outputs.DefaultHistoryRetention, collection.GetRetention(outputID))
+
+	collection.SetRetention(outputID, &outputs.HistoryRetention{MaxCount: 3})
+	assert.Equal(t, 3, collection.GetRetention(outputID).MaxCount)
+	for _, value := range []string{"1", "2", "3", "4", "5", "6"} {
+		collection.UpdateOutputValue(outputID, value)
+	}
+	history := collection.GetHistory(outputID)
+	assert.LessOrEqual(t, len(history), 3)
+	assert.Equal(t, "6", history[0].Value)
+
+	// default applies after removing the policy
+	collection.SetDefaultRetention(outputs.HistoryRetention{MaxCount: 10})
+	collection.SetRetention(outputID, nil)
+	assert.Equal(t, 10, collection.GetRetention(outputID).MaxCount)
+}
@@ -0,0 +1,29 @@
+// Package outputs with publication of the output calibrate command
+package outputs
+
+import (
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PublishCalibrateCommand publishes a command to set the calibration of an output of a publisher
+//  outputAddress is the discovery address of the output to calibrate
+//  calibration is the new calibration, nil to remove the calibration
+//  messageID is an optional ID to request an acknowledgement, "" for none
+//  sender is the address of the sending publisher
+func PublishCalibrateCommand(outputAddress string, calibration *types.OutputCalibration, messageID string,
+	sender string, messageSigner *messaging.MessageSigner) error {
+
+	addr := MakeCalibrateAddress(outputAddress)
+	logrus.Infof("PublishCalibrateCommand: publishing calibration to %s", addr)
+	message := &types.OutputCalibrateMessage{
+		Address:     addr,
+		Calibration: calibration,
+		MessageID:   messageID,
+		Sender:      sender,
+		Timestamp:   lib.Now().Format(types.TimeFormat),
+	}
+	return messageSigner.PublishObject(addr, false, message, nil)
+}
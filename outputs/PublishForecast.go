@@ -1,38 +1,46 @@
 package outputs
 
 import (
-	"time"
-
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
 )
 
 // PublishForecast publishes the $forecast output values retained=true
+// The duration of the forecast is the time in seconds between its first and last value.
 // not thread-safe, using within a locked section
 func PublishForecast(
 	output *types.OutputDiscoveryMessage,
 	forecast OutputForecast,
 	messageSigner *messaging.MessageSigner,
-) {
+) error {
 
 	aliasAddress := ReplaceMessageType(output.Address, types.MessageTypeForecast)
-	timeStampStr := time.Now().Format("2006-01-02T15:04:05.000-0700")
+	timeStampStr := lib.Now().Format(types.TimeFormat)
+	duration := 0
+	if len(forecast) > 1 {
+		duration = int(forecast[len(forecast)-1].EpochTime - forecast[0].EpochTime)
+	}
+	if forecast == nil {
+		forecast = OutputForecast{}
+	}
 
 	forecastMessage := &types.OutputForecastMessage{
 		Address:   aliasAddress,
-		Duration:  0, // tbd
+		Duration:  duration,
 		Timestamp: timeStampStr,
 		Unit:      output.Unit,
 		Forecast:  forecast,
 	}
 	logrus.Debugf("Publisher.publishForecast: %d entries on %s", len(forecastMessage.Forecast), aliasAddress)
-	messageSigner.PublishObject(aliasAddress, true, forecastMessage, nil)
+	return messageSigner.PublishObject(aliasAddress, true, forecastMessage, nil)
 }
 
 // PublishUpdatedForecasts publishes the output forecasts
 // While every output has a history, forecasts are only available for outputs that are able to
 // provide a prediction. For example a weather forecast. This is therefore a separate collection
+// Forecasts of outputs that are not registered are ignored.
 func PublishUpdatedForecasts(
 	regFCValues *RegisteredForecastValues,
 	regOutputs *RegisteredOutputs,
@@ -40,6 +48,10 @@ func PublishUpdatedForecasts(
 
 	for _, outputID := range regFCValues.GetUpdatedForecasts(true) {
 		output := regOutputs.GetOutputByID(outputID)
+		if output == nil {
+			logrus.Warningf("PublishUpdatedForecasts: Forecast of unknown output %s is ignored", outputID)
+			continue
+		}
 		forecast := regFCValues.GetForecast(outputID)
 
 		PublishForecast(output, forecast, messageSigner)
@@ -5,20 +5,42 @@ import (
 	"strings"
 	"time"
 
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
 )
 
+// PublishOutputEvent publishes a discrete event of a node, eg motion detected, on the node $event
+// address. Events are not retained as they are transitions rather than state.
+//  payload is optional event information, eg the zone that triggered an alarm
+func PublishOutputEvent(
+	node *types.NodeDiscoveryMessage,
+	eventType types.OutputEventType,
+	payload string,
+	messageSigner *messaging.MessageSigner,
+) error {
+	addr := ReplaceMessageType(node.Address, types.MessageTypeEvent)
+	logrus.Infof("PublishOutputEvent: %s to %s", eventType, addr)
+	eventMessage := &types.OutputEventMessage{
+		Address:   addr,
+		EventType: eventType,
+		Payload:   payload,
+		Timestamp: lib.Now().Format(types.TimeFormat),
+	}
+	err := messageSigner.PublishObject(addr, false, eventMessage, nil)
+	return err
+}
+
 // PublishOutputHistory publishes the $history output values retained=true
 func PublishOutputHistory(
 	output *types.OutputDiscoveryMessage,
 	history OutputHistory,
 	messageSigner *messaging.MessageSigner,
-) {
+) error {
 	// output values are published using their alias address, if any
-	addr := ReplaceMessageType(output.Address, types.MessageTypeHistory)
-	timeStampStr := time.Now().Format("2006-01-02T15:04:05.000-0700")
+	addr := GetOutputAddresses(output).History
+	timeStampStr := lib.Now().Format("2006-01-02T15:04:05.000-0700")
 	logrus.Infof("PublishOutputHistory to: %s", addr)
 
 	// todo: use output configuration to determine if history is published for this output
@@ -30,29 +52,39 @@ func PublishOutputHistory(
 		History:   history,
 	}
 	logrus.Debugf("PublishOutputHistory: %d entries to: %s", len(historyMessage.History), addr)
-	messageSigner.PublishObject(addr, true, historyMessage, nil)
+	err := messageSigner.PublishObject(addr, true, historyMessage, nil)
+	return err
 }
 
 // PublishOutputLatest publishes the $latest output value
+// The value timestamp is the time it was measured. The published timestamp is the time it was first published.
 // not thread-safe, using within a locked section
 func PublishOutputLatest(
 	output *types.OutputDiscoveryMessage,
 	latest *types.OutputValue,
 	messageSigner *messaging.MessageSigner,
-) {
+) error {
 	// output values are published using their alias address, if any
-	addr := ReplaceMessageType(output.Address, types.MessageTypeLatest)
+	addr := GetOutputAddresses(output).Latest
 	logrus.Infof("PublishOutputLatest to: %s", addr)
 
 	// todo: use output configuration to determine if latest message is published for this output
 	// zone/publisher/node/iotype/instance/$latest
 	latestMessage := &types.OutputLatestMessage{
 		Address:   addr,
+		Published: latest.Published,
+		Sequence:  latest.Sequence,
 		Timestamp: latest.Timestamp,
 		Unit:      output.Unit,
 		Value:     latest.Value,
 	}
-	messageSigner.PublishObject(addr, true, latestMessage, nil)
+	// consumers can tell when the value is no longer valid
+	if output.ValueTTL > 0 {
+		validUntil := time.Unix(latest.EpochTime+int64(output.ValueTTL), 0)
+		latestMessage.ValidUntil = validUntil.Format(types.TimeFormat)
+	}
+	err := messageSigner.PublishObject(addr, true, latestMessage, nil)
+	return err
 }
 
 // PublishOutputRaw publishes the raw output $raw (retained)
@@ -61,7 +93,7 @@ func PublishOutputRaw(output *types.OutputDiscoveryMessage, value string, messag
 ) error {
 
 	// replace output discovery with raw message type: domain/pub/nodeId/type/instance/messagetype
-	addr := ReplaceMessageType(output.Address, types.MessageTypeRaw)
+	addr := GetOutputAddresses(output).Raw
 
 	// publish raw value with the $raw command
 	s := value
@@ -75,10 +107,26 @@ func PublishOutputRaw(output *types.OutputDiscoveryMessage, value string, messag
 	return err
 }
 
+// GetOutputAddresses returns the publication addresses of an output. The precomputed addresses of
+// registered outputs are returned without building them, unless the output address has changed.
+func GetOutputAddresses(output *types.OutputDiscoveryMessage) types.OutputAddresses {
+	if output.Addresses.Discovery == output.Address && output.Address != "" {
+		return output.Addresses
+	}
+	return MakeOutputAddresses(output.Address)
+}
+
+// MakeOutputAddresses returns the publication addresses of the output with the given address
+func MakeOutputAddresses(outputAddress string) types.OutputAddresses {
+	return types.OutputAddresses{
+		Discovery: outputAddress,
+		History:   ReplaceMessageType(outputAddress, types.MessageTypeHistory),
+		Latest:    ReplaceMessageType(outputAddress, types.MessageTypeLatest),
+		Raw:       ReplaceMessageType(outputAddress, types.MessageTypeRaw),
+	}
+}
+
 // ReplaceMessageType replace the last segment  with a new message type
 func ReplaceMessageType(addr string, newMessageType types.MessageType) string {
-	segments := strings.Split(addr, "/")
-	segments[len(segments)-1] = string(newMessageType)
-	newAddr := strings.Join(segments, "/")
-	return newAddr
+	return addr[:strings.LastIndexByte(addr, '/')+1] + string(newMessageType)
 }
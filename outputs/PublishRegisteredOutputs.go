@@ -12,11 +12,13 @@ func PublishRegisteredOutputs(
 	outputs []*types.OutputDiscoveryMessage,
 	messageSigner *messaging.MessageSigner) {
 
-	// publish updated output discovery
+	// publish updated output discovery, signed as a batch
+	publications := make([]messaging.Publication, 0, len(outputs))
 	for _, output := range outputs {
-		logrus.Infof("PublishRegisteredOutputs: publish output discovery for: %s", output.Address)
-		messageSigner.PublishObject(output.Address, true, output, nil)
+		logrus.Infof("PublishRegisteredOutputs: publish output discovery for: %s, reasons: %v", output.Address, output.ChangeReasons)
+		publications = append(publications, messaging.Publication{Address: output.Address, Object: output, Retained: true})
 	}
+	messageSigner.PublishObjects(publications)
 	// todo: move save output configuration
 	// if len(outputs) > 0 && publisher.cacheFolder != "" {
 	// 	allOutputs := publisher.Outputs.GetAllOutputs()
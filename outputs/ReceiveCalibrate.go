@@ -0,0 +1,98 @@
+// Package outputs with receiving of the output calibrate command
+package outputs
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// CalibrateHandler callback when a command to calibrate a registered output is received
+// The handler is only invoked if the output exists. It returns an error if the calibration is rejected.
+type CalibrateHandler func(outputID string, calibration *types.OutputCalibration) error
+
+// ReceiveCalibrate listens for commands that set the calibration of outputs of this publisher
+// In secured domains the command must be signed by a known publisher. A command with a message ID
+// is acknowledged.
+type ReceiveCalibrate struct {
+	domain            string                   // the domain of this publisher
+	handler           CalibrateHandler         // handler to pass the command to
+	messageSigner     *messaging.MessageSigner // subscription to command
+	publisherID       string                   // the publisher receiving the command
+	registeredOutputs *RegisteredOutputs       // outputs of this publisher
+	updateMutex       *sync.Mutex              // mutex for async handling of commands
+}
+
+// SetCalibrateHandler set the handler for calibrate commands
+func (rxCalibrate *ReceiveCalibrate) SetCalibrateHandler(handler CalibrateHandler) {
+	rxCalibrate.updateMutex.Lock()
+	defer rxCalibrate.updateMutex.Unlock()
+	rxCalibrate.handler = handler
+}
+
+// Start listening for calibrate commands
+func (rxCalibrate *ReceiveCalibrate) Start() {
+	addr := MakeCalibrateAddress(
+		MakeOutputDiscoveryAddress(rxCalibrate.domain, rxCalibrate.publisherID, "+", "+", "+"))
+	rxCalibrate.messageSigner.Subscribe(addr, rxCalibrate.decodeCalibrate)
+}
+
+// Stop listening for calibrate commands
+func (rxCalibrate *ReceiveCalibrate) Stop() {
+	addr := MakeCalibrateAddress(
+		MakeOutputDiscoveryAddress(rxCalibrate.domain, rxCalibrate.publisherID, "+", "+", "+"))
+	rxCalibrate.messageSigner.Unsubscribe(addr, rxCalibrate.decodeCalibrate)
+}
+
+// decodeCalibrate verifies the signature of an incoming calibrate command and passes it to the handler.
+func (rxCalibrate *ReceiveCalibrate) decodeCalibrate(address string, message string) error {
+	var calibrateMessage types.OutputCalibrateMessage
+
+	_, isSigned, err := rxCalibrate.messageSigner.DecodeCommand(message, &calibrateMessage)
+	if err != nil {
+		return lib.MakeErrorf("decodeCalibrate: Invalid calibrate command on '%s': %s. Message discarded.", address, err)
+	} else if !isSigned && rxCalibrate.messageSigner.SignMessages() {
+		return lib.MakeErrorf("decodeCalibrate: Calibrate command on '%s' isn't signed but must be. Message discarded.", address)
+	}
+
+	ackSender := rxCalibrate.domain + "/" + rxCalibrate.publisherID
+	output := rxCalibrate.registeredOutputs.GetOutputByAddress(
+		ReplaceMessageType(address, types.MessageTypeOutputDiscovery))
+	if output == nil {
+		lib.PublishAck(address, calibrateMessage.MessageID, ackSender, errors.New("unknown output"),
+			rxCalibrate.messageSigner)
+		return lib.MakeErrorf("decodeCalibrate: Unknown output for address %s", address)
+	}
+
+	rxCalibrate.updateMutex.Lock()
+	handler := rxCalibrate.handler
+	rxCalibrate.updateMutex.Unlock()
+
+	logrus.Infof("decodeCalibrate: Calibrate command on %s from %s", address, calibrateMessage.Sender)
+	if handler != nil {
+		err = handler(output.OutputID, calibrateMessage.Calibration)
+	}
+	lib.PublishAck(address, calibrateMessage.MessageID, ackSender, err, rxCalibrate.messageSigner)
+	if err != nil {
+		return lib.MakeErrorf("decodeCalibrate: Calibration of %s rejected: %s", address, err)
+	}
+	return nil
+}
+
+// NewReceiveCalibrate returns a new instance of handling of the output calibrate command
+func NewReceiveCalibrate(domain string, publisherID string, handler CalibrateHandler,
+	messageSigner *messaging.MessageSigner, registeredOutputs *RegisteredOutputs) *ReceiveCalibrate {
+	rxCalibrate := &ReceiveCalibrate{
+		domain:            domain,
+		handler:           handler,
+		messageSigner:     messageSigner,
+		publisherID:       publisherID,
+		registeredOutputs: registeredOutputs,
+		updateMutex:       &sync.Mutex{},
+	}
+	return rxCalibrate
+}
@@ -2,31 +2,61 @@
 package outputs
 
 import (
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/types"
 )
 
-// OutputForecast with forecasted values
+// DefaultForecastMaxHorizon is the default max time in seconds a forecast looks ahead, 7 days
+const DefaultForecastMaxHorizon = 7 * 24 * 3600
+
+// OutputForecast with forecasted values, ordered by time with the nearest value first
 type OutputForecast []types.OutputValue
 
+// ForecastRetention rules for output forecasts. Values whose time has passed are always removed.
+// A limit of 0 means no limit.
+type ForecastRetention struct {
+	MaxCount   int `yaml:"maxCount"`   // max nr of values in the forecast, the nearest are kept
+	MaxHorizon int `yaml:"maxHorizon"` // max time in seconds from now of forecasted values. Default is DefaultForecastMaxHorizon
+}
+
+// DefaultForecastRetention retains up to 7 days of forecast without count limit
+var DefaultForecastRetention = ForecastRetention{MaxHorizon: DefaultForecastMaxHorizon}
+
 // RegisteredForecastValues with registered forecasts for outputs
 // A forecast is a list of timestamps with future projected values similar to history
 type RegisteredForecastValues struct {
 	domain           string // domain this forcast list belongs to
 	publisherID      string // publisher of the forcasts
 	forecastMap      map[string]OutputForecast
+	retention        ForecastRetention // retention rules of the forecasts
 	updateMutex      *sync.Mutex
 	updatedForecasts map[string]string // map of output IDs with updated forecasts
 }
 
-// GetForecast returns the output's forecast by outputID
+// DeleteForecast removes the forecast of an output
+func (regForecasts *RegisteredForecastValues) DeleteForecast(outputID string) {
+	regForecasts.updateMutex.Lock()
+	defer regForecasts.updateMutex.Unlock()
+
+	delete(regForecasts.forecastMap, outputID)
+	delete(regForecasts.updatedForecasts, outputID)
+}
+
+// GetForecast returns the output's forecast by outputID with values whose time has passed removed
 // Returns nil if the output has no forecast
 func (regForecasts *RegisteredForecastValues) GetForecast(outputID string) OutputForecast {
 	regForecasts.updateMutex.Lock()
 	defer regForecasts.updateMutex.Unlock()
 
 	var forecast = regForecasts.forecastMap[outputID]
+	if forecast != nil {
+		forecast = ApplyForecastRetention(forecast, regForecasts.retention, lib.Now())
+		regForecasts.forecastMap[outputID] = forecast
+	}
 	return forecast
 }
 
@@ -49,21 +79,77 @@ func (regForecasts *RegisteredForecastValues) GetUpdatedForecasts(clearUpdates b
 	return idList
 }
 
+// SetRetention sets the retention rules of the forecasts
+func (regForecasts *RegisteredForecastValues) SetRetention(retention ForecastRetention) {
+	regForecasts.updateMutex.Lock()
+	defer regForecasts.updateMutex.Unlock()
+	regForecasts.retention = retention
+}
+
 // UpdateForecast updates the output forecast list of values
+// The values are sorted by time and the retention rules are applied. Values without an epoch time
+// get the epoch time of their timestamp.
 func (regForecasts *RegisteredForecastValues) UpdateForecast(
 	outputID string, forecast OutputForecast) {
 
 	regForecasts.updateMutex.Lock()
 	defer regForecasts.updateMutex.Unlock()
 
-	regForecasts.forecastMap[outputID] = forecast
+	forecast = sortForecast(forecast)
+	regForecasts.forecastMap[outputID] = ApplyForecastRetention(forecast, regForecasts.retention, lib.Now())
 
 	if regForecasts.updatedForecasts == nil {
 		regForecasts.updatedForecasts = make(map[string]string)
 	}
 	regForecasts.updatedForecasts[outputID] = outputID
+}
 
-	// publisher.publishForecast(aliasAddress, output)
+// ApplyForecastRetention returns the forecast with values whose time has passed removed and the
+// retention rules applied. The forecast must be sorted with the nearest value first.
+//  now is the time to determine which values have passed
+func ApplyForecastRetention(forecast OutputForecast, retention ForecastRetention, now time.Time) OutputForecast {
+	first := 0
+	for first < len(forecast) && forecast[first].EpochTime < now.Unix() {
+		first++
+	}
+	last := len(forecast)
+	if retention.MaxHorizon > 0 {
+		horizon := now.Unix() + int64(retention.MaxHorizon)
+		for last > first && forecast[last-1].EpochTime > horizon {
+			last--
+		}
+	}
+	if retention.MaxCount > 0 && last-first > retention.MaxCount {
+		last = first + retention.MaxCount
+	}
+	return forecast[first:last]
+}
+
+// MakeForecastValue returns a forecast entry of a value at the given time
+func MakeForecastValue(forecastTime time.Time, value string) types.OutputValue {
+	return types.OutputValue{
+		EpochTime: forecastTime.Unix(),
+		Timestamp: forecastTime.Format(types.TimeFormat),
+		Value:     value,
+	}
+}
+
+// sortForecast returns a copy of the forecast sorted by time with the nearest value first
+func sortForecast(forecast OutputForecast) OutputForecast {
+	sorted := make(OutputForecast, 0, len(forecast))
+	for _, entry := range forecast {
+		if entry.EpochTime == 0 && entry.Timestamp != "" {
+			timestamp, err := time.Parse(types.TimeFormat, entry.Timestamp)
+			if err == nil {
+				entry.EpochTime = timestamp.Unix()
+			}
+		}
+		sorted = append(sorted, entry)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].EpochTime < sorted[j].EpochTime
+	})
+	return sorted
 }
 
 // NewRegisteredForecastValues creates a new instance for storing output forecasts
@@ -72,6 +158,7 @@ func NewRegisteredForecastValues(domain string, publisherID string) *RegisteredF
 		domain:      domain,
 		publisherID: publisherID,
 		forecastMap: make(map[string]OutputForecast),
+		retention:   DefaultForecastRetention,
 		updateMutex: &sync.Mutex{},
 	}
 	return &rfv
@@ -3,11 +3,13 @@ package outputs_test
 import (
 	"crypto/ecdsa"
 	"testing"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/outputs"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateForecastValues(t *testing.T) {
@@ -65,7 +67,44 @@ func TestPublishForecast(t *testing.T) {
 	forecast := make([]types.OutputValue, 0)
 	collection.UpdateForecast(output1.OutputID, forecast)
 
+	forecastAddr := outputs.ReplaceMessageType(output1.Address, types.MessageTypeForecast)
+	var published types.OutputForecastMessage
+	msgr.Subscribe(forecastAddr, func(address string, message string) error {
+		_, err := messaging.VerifySenderJWSSignature(message, &published, getPublisherKey)
+		return err
+	})
 	outputs.PublishUpdatedForecasts(collection, regOutputs, signer)
+	assert.Equal(t, forecastAddr, published.Address)
+	assert.NotNil(t, published.Forecast)
 
-	// TODO: verify the forecast was published
+	// forecasts of unknown outputs are ignored
+	collection.UpdateForecast("unknown", forecast)
+	assert.NotPanics(t, func() { outputs.PublishUpdatedForecasts(collection, regOutputs, signer) })
+}
+
+func TestForecastRetention(t *testing.T) {
+	now := time.Now()
+	hour := time.Hour
+	collection := outputs.NewRegisteredForecastValues("test", "publisher1")
+	collection.SetRetention(outputs.ForecastRetention{MaxCount: 3, MaxHorizon: 48 * 3600})
+	forecast := outputs.OutputForecast{
+		outputs.MakeForecastValue(now.Add(3*hour), "13"),
+		outputs.MakeForecastValue(now.Add(-hour), "9"),
+		outputs.MakeForecastValue(now.Add(hour), "11"),
+		outputs.MakeForecastValue(now.Add(72*hour), "20"),
+		// the epoch time is determined from the timestamp
+		{Timestamp: now.Add(2 * hour).Format(types.TimeFormat), Value: "12"},
+	}
+	collection.UpdateForecast("output1", forecast)
+
+	// past values and values beyond the horizon are removed, nearest first
+	result := collection.GetForecast("output1")
+	require.Equal(t, 3, len(result))
+	assert.Equal(t, "11", result[0].Value)
+	assert.Equal(t, "12", result[1].Value)
+	assert.Equal(t, "13", result[2].Value)
+
+	// values are removed when their time has passed
+	result = outputs.ApplyForecastRetention(result, outputs.DefaultForecastRetention, now.Add(90*time.Minute))
+	assert.Equal(t, 2, len(result))
 }
@@ -6,39 +6,114 @@ import (
 	"sync"
 	"time"
 
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/types"
 )
 
 // OutputHistory with history values
 type OutputHistory []types.OutputValue
 
+// OutputValueShards is the nr of shards the output values are divided over. Each shard has its own
+// lock, so updates of the values of different outputs by concurrent pollers rarely wait for each other.
+const OutputValueShards = 16
+
 // RegisteredOutputValues with values for all registered outputs, stored in the history map.
+// The values are divided over shards by output ID.
 type RegisteredOutputValues struct {
-	domain         string                   // the domain of this publisher
-	publisherID    string                   // the registered publisher for the inputs
-	historyMap     map[string]OutputHistory // history lists by output ID
-	updateMutex    *sync.Mutex              // mutex for async updating of outputs
-	updatedOutputs map[string]string        // IDs of updated outputs
+	domain           string               // the domain of this publisher
+	publisherID      string               // the registered publisher for the inputs
+	defaultRetention HistoryRetention     // history retention of outputs without retention policy
+	shards           []*outputValuesShard // output values by shard of output ID
+	updateMutex      *sync.RWMutex        // mutex for async updating of the default retention
+}
+
+// outputValuesShard holds the values of the outputs whose ID belongs to the shard
+type outputValuesShard struct {
+	historyMap      map[string]OutputHistory             // history lists by output ID
+	publishedValues map[string]map[uint64]publishedValue // values changed by the before publish hook by output ID and sequence nr
+	retention       map[string]HistoryRetention          // history retention policy by output ID
+	sequences       map[string]uint64                    // sequence nr of the last value by output ID
+	updateMutex     *sync.RWMutex                        // mutex for async updating of outputs of the shard
+	updatedOutputs  map[string]string                    // IDs of updated outputs
+	valueTTL        map[string]int                       // time to live of values in seconds by output ID
+}
+
+// publishedValue is the result of the before publish hook for a value in the history
+type publishedValue struct {
+	value  string // the published value
+	vetoed bool   // the publication of the value is vetoed
+}
+
+// DeleteOutputValues removes the history, sequence and retention policy of an output
+func (outputValues *RegisteredOutputValues) DeleteOutputValues(outputID string) {
+	shard := outputValues.getShard(outputID)
+	shard.updateMutex.Lock()
+	defer shard.updateMutex.Unlock()
+
+	delete(shard.historyMap, outputID)
+	delete(shard.publishedValues, outputID)
+	delete(shard.retention, outputID)
+	delete(shard.sequences, outputID)
+	delete(shard.updatedOutputs, outputID)
+	delete(shard.valueTTL, outputID)
 }
 
 // GetHistory returns the history list
 // Returns nil if the type or instance is unknown
 func (outputValues *RegisteredOutputValues) GetHistory(outputID string) OutputHistory {
-	outputValues.updateMutex.Lock()
-	var historyList = outputValues.historyMap[outputID]
-	outputValues.updateMutex.Unlock()
+	shard := outputValues.getShard(outputID)
+	shard.updateMutex.RLock()
+	var historyList = shard.historyMap[outputID]
+	shard.updateMutex.RUnlock()
 	return historyList
 }
 
+// GetPublishedHistory returns the history as it is published. Values that are modified by the before
+// publish hook have the modified value and values whose publication is vetoed are left out.
+// See SetPublishedValue.
+func (outputValues *RegisteredOutputValues) GetPublishedHistory(outputID string) OutputHistory {
+	shard := outputValues.getShard(outputID)
+	shard.updateMutex.RLock()
+	defer shard.updateMutex.RUnlock()
+	history := shard.historyMap[outputID]
+	published := shard.publishedValues[outputID]
+	if len(published) == 0 {
+		return history
+	}
+	publishedHistory := make(OutputHistory, 0, len(history))
+	for _, value := range history {
+		if result, found := published[value.Sequence]; found {
+			if result.vetoed {
+				continue
+			}
+			value.Value = result.value
+		}
+		publishedHistory = append(publishedHistory, value)
+	}
+	return publishedHistory
+}
+
+// GetPublishedValue returns the most recent output value as it is published, see GetPublishedHistory.
+// Returns nil if the output has no published value.
+func (outputValues *RegisteredOutputValues) GetPublishedValue(outputID string) *types.OutputValue {
+	history := outputValues.GetPublishedHistory(outputID)
+	if len(history) == 0 {
+		return nil
+	}
+	latest := history[0]
+	return &latest
+}
+
 // GetOutputValueByID returns the most recent output value by output ID
 // This returns a HistoryValue object with the latest value and timestamp it was updated
 func (outputValues *RegisteredOutputValues) GetOutputValueByID(outputID string) *types.OutputValue {
 	var latest *types.OutputValue
 
-	outputValues.updateMutex.Lock()
-	defer outputValues.updateMutex.Unlock()
+	shard := outputValues.getShard(outputID)
+	shard.updateMutex.RLock()
+	defer shard.updateMutex.RUnlock()
 
-	history := outputValues.historyMap[outputID]
+	history := shard.historyMap[outputID]
 
 	if history == nil || len(history) == 0 {
 		return nil
@@ -54,25 +129,94 @@ func (outputValues *RegisteredOutputValues) GetOutputValueByType(
 	return outputValues.GetOutputValueByID(outputID)
 }
 
+// GetRetention returns the history retention policy of an output
+// This returns the default retention if the output has no retention policy of its own
+func (outputValues *RegisteredOutputValues) GetRetention(outputID string) HistoryRetention {
+	shard := outputValues.getShard(outputID)
+	shard.updateMutex.RLock()
+	defer shard.updateMutex.RUnlock()
+	return outputValues.getRetention(shard, outputID)
+}
+
+// getRetention returns the history retention policy of an output
+// For internal use only. Use within locked section of the shard.
+func (outputValues *RegisteredOutputValues) getRetention(shard *outputValuesShard, outputID string) HistoryRetention {
+	retention, exists := shard.retention[outputID]
+	if !exists {
+		outputValues.updateMutex.RLock()
+		retention = outputValues.defaultRetention
+		outputValues.updateMutex.RUnlock()
+	}
+	return retention
+}
+
+// getShard returns the shard of an output ID. The shard is selected with the FNV-1a hash of the ID.
+func (outputValues *RegisteredOutputValues) getShard(outputID string) *outputValuesShard {
+	hash := uint32(2166136261)
+	for index := 0; index < len(outputID); index++ {
+		hash ^= uint32(outputID[index])
+		hash *= 16777619
+	}
+	return outputValues.shards[hash%uint32(len(outputValues.shards))]
+}
+
 // GetUpdatedOutputValues returns a list of output IDs that have updated values
 //  clearUpdates clears the list upon return
 func (outputValues *RegisteredOutputValues) GetUpdatedOutputValues(clearUpdates bool) []string {
 	var idList []string = make([]string, 0)
 
-	outputValues.updateMutex.Lock()
-	defer outputValues.updateMutex.Unlock()
-
-	if outputValues.updatedOutputs != nil {
-		for _, outputID := range outputValues.updatedOutputs {
+	// lock all shards so a batch of updated values is returned together
+	for _, shard := range outputValues.shards {
+		shard.updateMutex.Lock()
+		defer shard.updateMutex.Unlock()
+	}
+	for _, shard := range outputValues.shards {
+		for _, outputID := range shard.updatedOutputs {
 			idList = append(idList, outputID)
 		}
 		if clearUpdates {
-			outputValues.updatedOutputs = nil
+			shard.updatedOutputs = nil
 		}
 	}
 	return idList
 }
 
+// SetDefaultRetention sets the history retention policy for outputs without a policy of their own
+// The policy is applied the next time a value is added to the history.
+func (outputValues *RegisteredOutputValues) SetDefaultRetention(retention HistoryRetention) {
+	outputValues.updateMutex.Lock()
+	defer outputValues.updateMutex.Unlock()
+	outputValues.defaultRetention = retention
+}
+
+// SetRetention sets the history retention policy of an output
+// The policy is applied the next time a value is added to the history.
+//  retention is the policy to use, or nil to use the default retention
+func (outputValues *RegisteredOutputValues) SetRetention(outputID string, retention *HistoryRetention) {
+	shard := outputValues.getShard(outputID)
+	shard.updateMutex.Lock()
+	defer shard.updateMutex.Unlock()
+	if retention == nil {
+		delete(shard.retention, outputID)
+	} else {
+		shard.retention[outputID] = *retention
+	}
+}
+
+// SetValueTTL sets the time to live of the values of an output. Unchanged values are repeated at
+// half the TTL, instead of hourly, so consumers receive a value before the previous one expires.
+//  seconds is the time to live, 0 if values don't expire
+func (outputValues *RegisteredOutputValues) SetValueTTL(outputID string, seconds int) {
+	shard := outputValues.getShard(outputID)
+	shard.updateMutex.Lock()
+	defer shard.updateMutex.Unlock()
+	if seconds <= 0 {
+		delete(shard.valueTTL, outputID)
+	} else {
+		shard.valueTTL[outputID] = seconds
+	}
+}
+
 // UpdateOutputFloatList adds a list of floats as the output value in the format: "[value1, value2, ...]"
 func (outputValues *RegisteredOutputValues) UpdateOutputFloatList(outputID string, values []float32) bool {
 	valuesAsString, _ := json.Marshal(values)
@@ -91,101 +235,205 @@ func (outputValues *RegisteredOutputValues) UpdateOutputStringList(outputID stri
 	return outputValues.UpdateOutputValue(outputID, string(valuesAsString))
 }
 
+// SetPublished sets the time the latest value of an output is published, unless it was published before.
+// Returns a copy of the latest value or nil if the output has no value.
+func (outputValues *RegisteredOutputValues) SetPublished(outputID string, published time.Time) *types.OutputValue {
+	shard := outputValues.getShard(outputID)
+	shard.updateMutex.Lock()
+	defer shard.updateMutex.Unlock()
+	history := shard.historyMap[outputID]
+	if len(history) == 0 {
+		return nil
+	}
+	if history[0].Published == "" {
+		history[0].Published = published.Format(types.TimeFormat)
+	}
+	latest := history[0]
+	return &latest
+}
+
+// SetPublishedValue records the result of the before publish hook for a value in the history, so
+// the published history contains the value as it is published. Values that are no longer in the
+// history are forgotten.
+//  sequence is the sequence nr of the value in the history
+//  value is the value that is published instead
+//  vetoed is true if the publication of the value is vetoed
+func (outputValues *RegisteredOutputValues) SetPublishedValue(outputID string, sequence uint64, value string, vetoed bool) {
+	shard := outputValues.getShard(outputID)
+	shard.updateMutex.Lock()
+	defer shard.updateMutex.Unlock()
+	published := shard.publishedValues[outputID]
+	if published == nil {
+		published = make(map[uint64]publishedValue)
+		shard.publishedValues[outputID] = published
+	}
+	published[sequence] = publishedValue{value: value, vetoed: vetoed}
+	history := shard.historyMap[outputID]
+	if len(published) > len(history) {
+		inHistory := make(map[uint64]bool, len(history))
+		for _, historyValue := range history {
+			inHistory[historyValue.Sequence] = true
+		}
+		for historySequence := range published {
+			if !inHistory[historySequence] {
+				delete(published, historySequence)
+			}
+		}
+	}
+}
+
 // UpdateOutputValue adds the new node output value to the front of the history
 // If the node has a repeatDelay configured, then the value is only added if
 //  it has changed, or if the previous update was older than the repeatDelay.
-// The history retains a max of 24 hours
+// The history is limited by the retention policy of the output
 // returns true if history is updated, false if history has not been updated
 func (outputValues *RegisteredOutputValues) UpdateOutputValue(outputID string, newValue string) bool {
+	return outputValues.UpdateOutputValueAt(outputID, newValue, lib.Now())
+}
+
+// UpdateOutputValueAt adds the new node output value that was measured at the given time to the history.
+// Use this when the device provides the time of measurement, so consumers can correct for polling
+// and transport delays. The value is inserted in the history by its measurement time.
+// See UpdateOutputValue for the repeat delay and retention.
+// returns true if history is updated, false if history has not been updated
+func (outputValues *RegisteredOutputValues) UpdateOutputValueAt(outputID string, newValue string, measured time.Time) bool {
+	shard := outputValues.getShard(outputID)
+	shard.updateMutex.Lock()
+	defer shard.updateMutex.Unlock()
+	return outputValues.updateOutputValue(shard, outputID, newValue, measured)
+}
+
+// UpdateOutputValues adds a batch of new output values measured at the given time to their history.
+// The batch is applied atomically so the updated values are published together in the same
+// publication pass. See UpdateOutputValue for the repeat delay and retention.
+//  values contains the new values by output ID
+// returns the IDs of the outputs whose history is updated
+func (outputValues *RegisteredOutputValues) UpdateOutputValues(values map[string]string, measured time.Time) []string {
+	updatedIDs := make([]string, 0, len(values))
+	// lock the shards of the batch in shard order to avoid a deadlock with other batches
+	batchShards := make(map[*outputValuesShard]bool)
+	for outputID := range values {
+		batchShards[outputValues.getShard(outputID)] = true
+	}
+	for _, shard := range outputValues.shards {
+		if batchShards[shard] {
+			shard.updateMutex.Lock()
+			defer shard.updateMutex.Unlock()
+		}
+	}
+	for outputID, newValue := range values {
+		shard := outputValues.getShard(outputID)
+		if outputValues.updateOutputValue(shard, outputID, newValue, measured) {
+			updatedIDs = append(updatedIDs, outputID)
+		}
+	}
+	return updatedIDs
+}
+
+// updateOutputValue adds a new output value to the history if it changed or the repeat delay has passed.
+// For internal use only. Use within locked section of the shard.
+func (outputValues *RegisteredOutputValues) updateOutputValue(
+	shard *outputValuesShard, outputID string, newValue string, measured time.Time) bool {
 	var previous *types.OutputValue
 	var repeatDelay = 3600 // default repeat delay is 1 hour
 	var ageSeconds = -1
 	var hasUpdated = false
 
-	outputValues.updateMutex.Lock()
-	defer outputValues.updateMutex.Unlock()
-
 	// auto create the output if it hasn't been discovered yet
 	// output := outputvalue.Outputs.GetOutputByAddress(addr)
-	history := outputValues.historyMap[outputID]
+	history := shard.historyMap[outputID]
 
 	// only update output if value changes or delay has passed
 	// for now use 1 hour repeat delay. Need to get the config from somewhere
 	// if history.RepeatDelay != 0 {
 	// 	repeatDelay = history.RepeatDelay
 	// }
+	// values that expire are repeated before they expire
+	if ttl := shard.valueTTL[outputID]; ttl > 0 && ttl/2 < repeatDelay {
+		repeatDelay = ttl / 2
+	}
 	if len(history) > 0 {
 		previous = &history[0]
 		prevTime := time.Unix(previous.EpochTime, 0)
-		age := time.Now().Sub(prevTime)
+		age := measured.Sub(prevTime)
 		ageSeconds = int(age.Seconds())
 	}
 	doUpdate := ageSeconds < 0 || ageSeconds > repeatDelay || newValue != previous.Value
 	if doUpdate {
-		// 24 hour history
-		newHistory := updateHistory(history, newValue, 0)
+		retention := outputValues.getRetention(shard, outputID)
+		sequence := shard.nextSequence(outputID, lib.Now())
+		newHistory := updateHistory(history, newValue, measured, sequence, retention)
 
-		outputValues.historyMap[outputID] = newHistory
+		shard.historyMap[outputID] = newHistory
 		hasUpdated = true
 
-		if outputValues.updatedOutputs == nil {
-			outputValues.updatedOutputs = make(map[string]string)
+		if shard.updatedOutputs == nil {
+			shard.updatedOutputs = make(map[string]string)
 		}
-		outputValues.updatedOutputs[outputID] = outputID
+		shard.updatedOutputs[outputID] = outputID
 
 	}
 	return hasUpdated
 }
 
-// updateHistory inserts a new value at the front of the history
-// The resulting list contains a max of historySize entries limited to 24 hours
+// nextSequence returns the sequence nr of a new value of an output. Sequence nrs start at the time in
+// milliseconds and increase with each value, so consumers can discard values that are older than
+// a value they already received, also after the publisher restarts.
+// For internal use only. Use within locked section of the shard.
+func (shard *outputValuesShard) nextSequence(outputID string, now time.Time) uint64 {
+	sequence := uint64(now.UnixNano() / int64(time.Millisecond))
+	if previous := shard.sequences[outputID]; sequence <= previous {
+		sequence = previous + 1
+	}
+	shard.sequences[outputID] = sequence
+	return sequence
+}
+
+// updateHistory inserts a new value in the history, ordered by time of measurement with the newest first
+// The resulting list is limited by the given retention policy
 // This function is not thread-safe and should only be used from within a locked section
 // history is optional and used to insert the value in the front. If nil then a new history is returned
-// newValue contains the value to include in the history along with the current timestamp
-// maxHistorySize is optional and limits the size in addition to the 24 hour limit
-// returns the history list with the new value at the front of the list
-func updateHistory(history OutputHistory, newValue string, maxHistorySize int) OutputHistory {
+// newValue contains the value to include in the history
+// measured is the time the value was measured
+// sequence is the sequence nr of the new value
+// retention is the retention policy to apply to the history
+// returns the history list with the new value inserted
+func updateHistory(history OutputHistory, newValue string, measured time.Time, sequence uint64, retention HistoryRetention) OutputHistory {
 
-	timeStamp := time.Now()
-	timeStampStr := timeStamp.Format(types.TimeFormat)
-
-	latest := types.OutputValue{
-		Timestamp: timeStampStr,
-		EpochTime: timeStamp.Unix(),
-		Value:     newValue,
-	}
-	if history == nil {
-		history = make(OutputHistory, 1)
-	} else {
-		// make room at the front of the slice
-		history = append(history, latest)
-		copy(history[1:], history[0:])
-	}
-	history[0] = latest
-
-	// remove old entries, determine the max
-	if maxHistorySize == 0 || len(history) < maxHistorySize {
-		maxHistorySize = len(history)
-	}
-	// cap at 24 hours
-	for ; maxHistorySize > 1; maxHistorySize-- {
-		entry := history[maxHistorySize-1]
-		entrytime := time.Unix(entry.EpochTime, 0)
-		if timeStamp.Sub(entrytime) <= time.Hour*24 {
-			break
-		}
+	latest := NewHistoryValue(newValue, measured)
+	latest.Sequence = sequence
+	// values measured before newer values are inserted after them
+	index := 0
+	for index < len(history) && history[index].EpochTime > latest.EpochTime {
+		index++
 	}
-	history = history[0:maxHistorySize]
+	// make room for the new value
+	history = append(history, latest)
+	copy(history[index+1:], history[index:])
+	history[index] = latest
+
+	history = ApplyRetention(history, retention, lib.Now())
 	return history
 }
 
 // NewRegisteredOutputValues creates a new instance for output value and history management
 func NewRegisteredOutputValues(domain string, publisherID string) *RegisteredOutputValues {
 	outputs := RegisteredOutputValues{
-		domain:      domain,
-		publisherID: publisherID,
-		historyMap:  make(map[string]OutputHistory),
-		updateMutex: &sync.Mutex{},
+		domain:           domain,
+		publisherID:      publisherID,
+		defaultRetention: DefaultHistoryRetention,
+		shards:           make([]*outputValuesShard, OutputValueShards),
+		updateMutex:      &sync.RWMutex{},
+	}
+	for index := range outputs.shards {
+		outputs.shards[index] = &outputValuesShard{
+			historyMap:      make(map[string]OutputHistory),
+			publishedValues: make(map[string]map[uint64]publishedValue),
+			retention:       make(map[string]HistoryRetention),
+			sequences:       make(map[string]uint64),
+			updateMutex:     &sync.RWMutex{},
+			valueTTL:        make(map[string]int),
+		}
 	}
 	return &outputs
 }
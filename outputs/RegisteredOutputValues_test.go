@@ -2,8 +2,12 @@ package outputs_test
 
 import (
 	"crypto/ecdsa"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/iotdomain/iotdomain-go/internal/perftest"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/outputs"
 	"github.com/iotdomain/iotdomain-go/types"
@@ -58,6 +62,165 @@ func TestCreateOutputValues(t *testing.T) {
 	assert.Equal(t, val3.Value, "[\"a\",\"b\",\"c\"]")
 }
 
+func TestMeasuredAndPublishedTime(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	collection := outputs.NewRegisteredOutputValues(domain, publisher1ID)
+	outputID := outputs.MakeOutputID("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	now := time.Now()
+
+	// values measured by the device are ordered by their measurement time
+	collection.UpdateOutputValueAt(outputID, "2", now.Add(-time.Minute))
+	collection.UpdateOutputValueAt(outputID, "3", now)
+	collection.UpdateOutputValueAt(outputID, "1", now.Add(-2*time.Minute))
+	history := collection.GetHistory(outputID)
+	require.Equal(t, 3, len(history))
+	assert.Equal(t, "3", history[0].Value)
+	assert.Equal(t, "2", history[1].Value)
+	assert.Equal(t, "1", history[2].Value)
+	assert.Equal(t, now.Add(-2*time.Minute).Unix(), history[2].EpochTime)
+
+	// the first publication time is kept
+	latest := collection.GetOutputValueByID(outputID)
+	assert.Empty(t, latest.Published)
+	published := collection.SetPublished(outputID, now)
+	require.NotNil(t, published)
+	assert.Equal(t, now.Format(types.TimeFormat), published.Published)
+	published = collection.SetPublished(outputID, now.Add(time.Minute))
+	assert.Equal(t, now.Format(types.TimeFormat), published.Published)
+	assert.Nil(t, collection.SetPublished("not an output", now))
+}
+
+func TestUpdateOutputValues(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	collection := outputs.NewRegisteredOutputValues(domain, publisher1ID)
+	out1ID := outputs.MakeOutputID("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	out2ID := outputs.MakeOutputID("node2", types.OutputTypeHumidity, types.DefaultOutputInstance)
+	collection.UpdateOutputValue(out1ID, "20")
+	collection.GetUpdatedOutputValues(true)
+
+	// unchanged values are not updated
+	updatedIDs := collection.UpdateOutputValues(map[string]string{out1ID: "20", out2ID: "50"}, time.Now())
+	assert.Equal(t, []string{out2ID}, updatedIDs)
+	updatedIDs = collection.UpdateOutputValues(map[string]string{out1ID: "21", out2ID: "51"}, time.Now())
+	assert.Len(t, updatedIDs, 2)
+	assert.Len(t, collection.GetUpdatedOutputValues(true), 2)
+	assert.Equal(t, "21", collection.GetOutputValueByID(out1ID).Value)
+	assert.Equal(t, 2, len(collection.GetHistory(out2ID)))
+}
+
+func TestDeleteOutputValues(t *testing.T) {
+	collection := outputs.NewRegisteredOutputValues("test", "publisher1")
+	out1ID := outputs.MakeOutputID("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	out2ID := outputs.MakeOutputID("node2", types.OutputTypeHumidity, types.DefaultOutputInstance)
+	collection.UpdateOutputValue(out1ID, "20")
+	collection.UpdateOutputValue(out2ID, "50")
+
+	collection.DeleteOutputValues(out1ID)
+	assert.Nil(t, collection.GetOutputValueByID(out1ID))
+	assert.Empty(t, collection.GetHistory(out1ID))
+	assert.Equal(t, []string{out2ID}, collection.GetUpdatedOutputValues(true))
+}
+
+func TestPublishedHistory(t *testing.T) {
+	collection := outputs.NewRegisteredOutputValues("test", "publisher1")
+	out1ID := outputs.MakeOutputID("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	collection.SetRetention(out1ID, &outputs.HistoryRetention{MaxCount: 3})
+	assert.Nil(t, collection.GetPublishedValue(out1ID))
+
+	// modified values are published with the modified value and vetoed values are left out
+	collection.UpdateOutputValue(out1ID, "20")
+	collection.SetPublishedValue(out1ID, collection.GetOutputValueByID(out1ID).Sequence, "200", false)
+	collection.UpdateOutputValue(out1ID, "21")
+	collection.SetPublishedValue(out1ID, collection.GetOutputValueByID(out1ID).Sequence, "21", true)
+	assert.Equal(t, "21", collection.GetOutputValueByID(out1ID).Value)
+	history := collection.GetPublishedHistory(out1ID)
+	require.Equal(t, 1, len(history))
+	assert.Equal(t, "200", history[0].Value)
+	assert.Equal(t, "200", collection.GetPublishedValue(out1ID).Value)
+
+	// values that are no longer in the history are forgotten
+	for _, value := range []string{"22", "23", "24"} {
+		collection.UpdateOutputValue(out1ID, value)
+	}
+	collection.SetPublishedValue(out1ID, collection.GetOutputValueByID(out1ID).Sequence, "240", false)
+	history = collection.GetPublishedHistory(out1ID)
+	require.Equal(t, 3, len(history))
+	assert.Equal(t, "240", history[0].Value)
+	assert.Equal(t, "22", history[2].Value)
+}
+
+func TestOutputValueTTLRepeat(t *testing.T) {
+	collection := outputs.NewRegisteredOutputValues("test", "publisher1")
+	outputID := outputs.MakeOutputID("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	collection.SetValueTTL(outputID, 600)
+	now := time.Now()
+
+	// unchanged values are repeated at half the TTL instead of hourly
+	assert.True(t, collection.UpdateOutputValueAt(outputID, "20", now))
+	assert.False(t, collection.UpdateOutputValueAt(outputID, "20", now.Add(4*time.Minute)))
+	assert.True(t, collection.UpdateOutputValueAt(outputID, "20", now.Add(6*time.Minute)))
+	collection.SetValueTTL(outputID, 0)
+	assert.False(t, collection.UpdateOutputValueAt(outputID, "20", now.Add(12*time.Minute)))
+}
+
+func TestOutputValueSequence(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	collection := outputs.NewRegisteredOutputValues(domain, publisher1ID)
+	out1ID := outputs.MakeOutputID("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	out2ID := outputs.MakeOutputID("node2", types.OutputTypeHumidity, types.DefaultOutputInstance)
+
+	// sequence nrs increase with each value of an output, also when updated in the same millisecond
+	var previous uint64
+	for i := 0; i < 10; i++ {
+		collection.UpdateOutputValue(out1ID, fmt.Sprint(i))
+		latest := collection.GetOutputValueByID(out1ID)
+		assert.Greater(t, latest.Sequence, previous)
+		previous = latest.Sequence
+	}
+	// sequence nrs start at the time so they increase after a restart
+	assert.GreaterOrEqual(t, previous, uint64(time.Now().Add(-time.Minute).UnixNano()/int64(time.Millisecond)))
+	collection.UpdateOutputValue(out2ID, "50")
+	assert.NotZero(t, collection.GetOutputValueByID(out2ID).Sequence)
+
+	// the history keeps the sequence nrs and the published copy includes it
+	history := collection.GetHistory(out1ID)
+	assert.Greater(t, history[0].Sequence, history[1].Sequence)
+	published := collection.SetPublished(out1ID, time.Now())
+	assert.Equal(t, previous, published.Sequence)
+}
+
+func TestConcurrentOutputValues(t *testing.T) {
+	collection := outputs.NewRegisteredOutputValues("test", "publisher1")
+	var wg sync.WaitGroup
+	for poller := 0; poller < 10; poller++ {
+		wg.Add(1)
+		go func(poller int) {
+			defer wg.Done()
+			for count := 0; count < 100; count++ {
+				outputID := outputs.MakeOutputID(fmt.Sprintf("node%d", poller), types.OutputTypeTemperature, fmt.Sprint(count))
+				collection.UpdateOutputValue(outputID, fmt.Sprint(count))
+			}
+		}(poller)
+	}
+	wg.Wait()
+	assert.Equal(t, 1000, len(collection.GetUpdatedOutputValues(true)))
+	assert.Empty(t, collection.GetUpdatedOutputValues(true))
+
+	// a batch over multiple shards is returned together
+	batch := make(map[string]string)
+	for count := 0; count < 50; count++ {
+		batch[outputs.MakeOutputID("batch", types.OutputTypeTemperature, fmt.Sprint(count))] = "20"
+	}
+	assert.Equal(t, 50, len(collection.UpdateOutputValues(batch, time.Now())))
+	assert.Equal(t, 50, len(collection.GetUpdatedOutputValues(true)))
+	latest := collection.GetOutputValueByID(outputs.MakeOutputID("batch", types.OutputTypeTemperature, "1"))
+	require.NotNil(t, latest)
+	assert.Equal(t, "20", latest.Value)
+}
+
 func TestPublishOutputValues(t *testing.T) {
 	const domain = "test"
 	const publisher1ID = "publisher1"
@@ -87,3 +250,49 @@ func TestPublishOutputValues(t *testing.T) {
 		"and Gregorian calendars.", signer)
 
 }
+
+// Performance budget of output values and lookups on a CI host, see the README. The budget is
+// enforced by TestOutputPerformanceBudget unless tests run in short mode.
+const updateOutputValueBudget = 20 * time.Microsecond
+const getOutputBudget = time.Microsecond
+
+func TestOutputPerformanceBudget(t *testing.T) {
+	perftest.SkipUnlessEnabled(t)
+	result := testing.Benchmark(BenchmarkUpdateOutputValue)
+	assert.LessOrEqualf(t, result.NsPerOp(), int64(updateOutputValueBudget),
+		"UpdateOutputValue exceeds the budget of %s: %s", updateOutputValueBudget, result)
+	result = testing.Benchmark(BenchmarkGetOutputByID)
+	assert.LessOrEqualf(t, result.NsPerOp(), int64(getOutputBudget),
+		"GetOutputByID exceeds the budget of %s: %s", getOutputBudget, result)
+}
+
+// BenchmarkUpdateOutputValue measures the update of the value of one of 50 outputs, including its
+// history of a day of values at one value per minute
+func BenchmarkUpdateOutputValue(b *testing.B) {
+	collection := outputs.NewRegisteredOutputValues("test", "publisher1")
+	collection.SetDefaultRetention(outputs.HistoryRetention{MaxAge: outputs.DefaultHistoryMaxAge, MaxCount: 1440})
+	outputIDs := make([]string, 50)
+	for count := range outputIDs {
+		outputIDs[count] = outputs.MakeOutputID(fmt.Sprintf("node%d", count),
+			types.OutputTypeTemperature, types.DefaultOutputInstance)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for count := 0; count < b.N; count++ {
+		collection.UpdateOutputValue(outputIDs[count%len(outputIDs)], fmt.Sprint(count))
+	}
+}
+
+// BenchmarkGetOutputByID measures the lookup of registered outputs, done for each updated output value
+func BenchmarkGetOutputByID(b *testing.B) {
+	regOutputs := outputs.NewRegisteredOutputs("test", "publisher1")
+	for count := 0; count < 50; count++ {
+		regOutputs.CreateOutput(fmt.Sprintf("node%d", count), types.OutputTypeTemperature, types.DefaultOutputInstance)
+	}
+	outputID := outputs.MakeOutputID("node25", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for count := 0; count < b.N; count++ {
+		regOutputs.GetOutputByID(outputID)
+	}
+}
@@ -2,20 +2,56 @@
 package outputs
 
 import (
+	"reflect"
+	"sort"
 	"sync"
-	"time"
 
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/types"
 )
 
+// BeforePublishHook is invoked before an output value is published.
+// It returns the value to publish, which can be modified, and false to veto the publication.
+type BeforePublishHook func(output *types.OutputDiscoveryMessage, value string) (newValue string, publish bool)
+
+// AfterPublishHook is invoked after an output value is published, with the result of the publication
+type AfterPublishHook func(output *types.OutputDiscoveryMessage, value string, err error)
+
 // RegisteredOutputs manages registration of publisher outputs
 type RegisteredOutputs struct {
 	addressMap       map[string]string                        // lookup outputID by output publication address
+	afterPublish     map[string]AfterPublishHook              // after publish hooks by output ID
+	beforePublish    map[string]BeforePublishHook             // before publish hooks by output ID
 	domain           string                                   // the domain of this publisher
+	nodeIndex        lib.RegistryIndex                        // output IDs by node HWID
 	publisherID      string                                   // the registered publisher for the inputs
 	outputsByID      map[string]*types.OutputDiscoveryMessage // lookup output by output ID
+	publishedHashes  map[string]string                        // content hash of the last published output by output ID
+	typeIndex        lib.RegistryIndex                        // output IDs by output type
 	updatedOutputIDs map[string]string                        // IDs of updated outputs
-	updateMutex      *sync.Mutex                              // mutex for async updating of outputs
+	updateMutex      *sync.RWMutex                            // mutex for async updating of outputs
+}
+
+// AfterPublish invokes the after publish hook of the output, if any
+func (regOutputs *RegisteredOutputs) AfterPublish(output *types.OutputDiscoveryMessage, value string, err error) {
+	regOutputs.updateMutex.RLock()
+	hook := regOutputs.afterPublish[output.OutputID]
+	regOutputs.updateMutex.RUnlock()
+	if hook != nil {
+		hook(output, value, err)
+	}
+}
+
+// BeforePublish invokes the before publish hook of the output, if any.
+// This returns the value to publish and false if the publication is vetoed.
+func (regOutputs *RegisteredOutputs) BeforePublish(output *types.OutputDiscoveryMessage, value string) (string, bool) {
+	regOutputs.updateMutex.RLock()
+	hook := regOutputs.beforePublish[output.OutputID]
+	regOutputs.updateMutex.RUnlock()
+	if hook == nil {
+		return value, true
+	}
+	return hook(output, value)
 }
 
 // CreateOutput creates and registers a new output. If the output already exists, it is replaced.
@@ -26,15 +62,36 @@ func (regOutputs *RegisteredOutputs) CreateOutput(
 
 	regOutputs.updateMutex.Lock()
 	defer regOutputs.updateMutex.Unlock()
-	regOutputs.updateOutput(output)
+	regOutputs.updateOutput(output, types.ChangeReasonCreated)
 	return output
 }
 
-// GetAllOutputs returns the list of outputs
-func (regOutputs *RegisteredOutputs) GetAllOutputs() []*types.OutputDiscoveryMessage {
+// DeleteOutput unregisters the output and its publish hooks
+// Returns false if the output doesn't exist.
+func (regOutputs *RegisteredOutputs) DeleteOutput(outputID string) bool {
 	regOutputs.updateMutex.Lock()
 	defer regOutputs.updateMutex.Unlock()
 
+	output := regOutputs.outputsByID[outputID]
+	if output == nil {
+		return false
+	}
+	regOutputs.nodeIndex.Remove(output.NodeHWID, outputID)
+	regOutputs.typeIndex.Remove(string(output.OutputType), outputID)
+	delete(regOutputs.addressMap, output.Address)
+	delete(regOutputs.afterPublish, outputID)
+	delete(regOutputs.beforePublish, outputID)
+	delete(regOutputs.outputsByID, outputID)
+	delete(regOutputs.publishedHashes, outputID)
+	delete(regOutputs.updatedOutputIDs, outputID)
+	return true
+}
+
+// GetAllOutputs returns the list of outputs
+func (regOutputs *RegisteredOutputs) GetAllOutputs() []*types.OutputDiscoveryMessage {
+	regOutputs.updateMutex.RLock()
+	defer regOutputs.updateMutex.RUnlock()
+
 	var outputList = make([]*types.OutputDiscoveryMessage, 0)
 	for _, output := range regOutputs.outputsByID {
 		outputList = append(outputList, output)
@@ -46,20 +103,33 @@ func (regOutputs *RegisteredOutputs) GetAllOutputs() []*types.OutputDiscoveryMes
 // outputAddr must contain the full output address, eg <zone>/<publisher>/<node>/"$output"/<type>/<instance>
 // Returns nil if address has no known output
 func (regOutputs *RegisteredOutputs) GetOutputByAddress(outputAddr string) *types.OutputDiscoveryMessage {
-	regOutputs.updateMutex.Lock()
-	defer regOutputs.updateMutex.Unlock()
+	regOutputs.updateMutex.RLock()
+	defer regOutputs.updateMutex.RUnlock()
 	var outputID = regOutputs.addressMap[outputAddr]
 	output := regOutputs.outputsByID[outputID]
 	return output
 }
 
-// GetOutputsByNodeHWID returns a list of all outputs of a given device
+// GetOutputsByNodeHWID returns a list of all outputs of a given device, ordered by output ID
 func (regOutputs *RegisteredOutputs) GetOutputsByNodeHWID(hwID string) []*types.OutputDiscoveryMessage {
-	outputList := make([]*types.OutputDiscoveryMessage, 0)
-	regOutputs.updateMutex.Lock()
-	defer regOutputs.updateMutex.Unlock()
-	for _, output := range regOutputs.outputsByID {
-		if output.NodeHWID == hwID {
+	regOutputs.updateMutex.RLock()
+	defer regOutputs.updateMutex.RUnlock()
+	return regOutputs.getIndexedOutputs(regOutputs.nodeIndex, hwID)
+}
+
+// GetOutputsByType returns a list of all outputs of a given type, ordered by output ID
+func (regOutputs *RegisteredOutputs) GetOutputsByType(outputType types.OutputType) []*types.OutputDiscoveryMessage {
+	regOutputs.updateMutex.RLock()
+	defer regOutputs.updateMutex.RUnlock()
+	return regOutputs.getIndexedOutputs(regOutputs.typeIndex, string(outputType))
+}
+
+// getIndexedOutputs returns the outputs with the given key in a secondary index
+// For internal use only. Use within locked section.
+func (regOutputs *RegisteredOutputs) getIndexedOutputs(index lib.RegistryIndex, key string) []*types.OutputDiscoveryMessage {
+	outputList := make([]*types.OutputDiscoveryMessage, 0, index.Count(key))
+	for _, outputID := range index.IDs(key) {
+		if output := regOutputs.outputsByID[outputID]; output != nil {
 			outputList = append(outputList, output)
 		}
 	}
@@ -79,13 +149,15 @@ func (regOutputs *RegisteredOutputs) GetOutputByNodeHWID(
 // GetOutputByID returns an output by its ID (device.type.instance)
 // Returns nil if there is no known output
 func (regOutputs *RegisteredOutputs) GetOutputByID(outputID string) *types.OutputDiscoveryMessage {
-	regOutputs.updateMutex.Lock()
-	defer regOutputs.updateMutex.Unlock()
+	regOutputs.updateMutex.RLock()
+	defer regOutputs.updateMutex.RUnlock()
 	var output = regOutputs.outputsByID[outputID]
 	return output
 }
 
 // GetUpdatedOutputs returns the list of discovered outputs that have been updated
+// Outputs whose content is unchanged since their last publication are skipped unless their
+// republication is forced.
 // clear the update on return
 func (regOutputs *RegisteredOutputs) GetUpdatedOutputs(clearUpdates bool) []*types.OutputDiscoveryMessage {
 	var updateList []*types.OutputDiscoveryMessage = make([]*types.OutputDiscoveryMessage, 0)
@@ -94,8 +166,18 @@ func (regOutputs *RegisteredOutputs) GetUpdatedOutputs(clearUpdates bool) []*typ
 	if regOutputs.updatedOutputIDs != nil {
 		for _, outputID := range regOutputs.updatedOutputIDs {
 			output := regOutputs.outputsByID[outputID]
-			if output != nil {
-				updateList = append(updateList, output)
+			if output == nil {
+				continue
+			}
+			// outputs that are updated without change are only republished when forced
+			hash := MakeOutputHash(output)
+			if regOutputs.publishedHashes[outputID] == hash &&
+				!types.HasChangeReason(output.ChangeReasons, types.ChangeReasonForced) {
+				continue
+			}
+			updateList = append(updateList, output)
+			if clearUpdates {
+				regOutputs.publishedHashes[outputID] = hash
 			}
 		}
 		if clearUpdates {
@@ -106,43 +188,208 @@ func (regOutputs *RegisteredOutputs) GetUpdatedOutputs(clearUpdates bool) []*typ
 	return updateList
 }
 
+// SetPublishHooks sets the hooks that are invoked before and after publication of an output value.
+// Use nil to remove a hook.
+func (regOutputs *RegisteredOutputs) SetPublishHooks(
+	outputID string, beforePublish BeforePublishHook, afterPublish AfterPublishHook) {
+
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+	if beforePublish == nil {
+		delete(regOutputs.beforePublish, outputID)
+	} else {
+		regOutputs.beforePublish[outputID] = beforePublish
+	}
+	if afterPublish == nil {
+		delete(regOutputs.afterPublish, outputID)
+	} else {
+		regOutputs.afterPublish[outputID] = afterPublish
+	}
+}
+
+// SetCalibration sets the calibration of an output that is reported in its discovery.
+// The output is republished if the calibration has changed. Use nil to remove the calibration.
+// Returns false if the output doesn't exist.
+func (regOutputs *RegisteredOutputs) SetCalibration(outputID string, calibration *types.OutputCalibration) bool {
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+	output := regOutputs.outputsByID[outputID]
+	if output == nil {
+		return false
+	}
+	if !reflect.DeepEqual(output.Calibration, calibration) {
+		output.Calibration = calibration
+		regOutputs.updateOutput(output, types.ChangeReasonConfig)
+	}
+	return true
+}
+
+// SetEnumValues sets the valid values of an output, eg "heat", "cool", "auto", "off" for a hvac
+// mode. The output data type becomes enum. Use nil to remove the enum values, after which an enum
+// data type becomes string. The output is republished if the enum values have changed.
+// Returns false if the output doesn't exist.
+func (regOutputs *RegisteredOutputs) SetEnumValues(outputID string, enumValues []string) bool {
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+	output := regOutputs.outputsByID[outputID]
+	if output == nil {
+		return false
+	}
+	dataType := types.DataTypeEnum
+	if len(enumValues) == 0 {
+		enumValues = nil
+		dataType = output.DataType
+		if dataType == types.DataTypeEnum {
+			dataType = types.DataTypeString
+		}
+	}
+	if output.DataType != dataType || !reflect.DeepEqual(output.EnumValues, enumValues) {
+		output.DataType = dataType
+		output.EnumValues = append([]string(nil), enumValues...)
+		regOutputs.updateOutput(output, types.ChangeReasonAttr)
+	}
+	return true
+}
+
+// SetLocalOnly sets whether an output is for internal use only. Local-only outputs and their
+// values are not published. An output that is no longer local-only is republished.
+// Returns false if the output doesn't exist.
+func (regOutputs *RegisteredOutputs) SetLocalOnly(outputID string, localOnly bool) bool {
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+	output := regOutputs.outputsByID[outputID]
+	if output == nil {
+		return false
+	}
+	if output.LocalOnly != localOnly {
+		output.LocalOnly = localOnly
+		regOutputs.updateOutput(output, types.ChangeReasonForced)
+	}
+	return true
+}
+
 // SetNodeID updates the address of all outputs with the given node hardware address
 func (regOutputs *RegisteredOutputs) SetNodeID(nodeHWID string, alias string) {
 	outputList := regOutputs.GetOutputsByNodeHWID(nodeHWID)
 	for _, output := range outputList {
 		newAddress := MakeOutputDiscoveryAddress(
 			regOutputs.domain, regOutputs.publisherID, alias, output.OutputType, output.Instance)
-		output.Address = newAddress
 
 		regOutputs.updateMutex.Lock()
-		defer regOutputs.updateMutex.Unlock()
-		regOutputs.updateOutput(output)
+		output.Address = newAddress
+		regOutputs.updateOutput(output, types.ChangeReasonAttr)
+		regOutputs.updateMutex.Unlock()
 	}
 }
 
+// SetValueTTL sets the time a value of an output is valid after it is measured, eg 900 seconds for an
+// outdoor temperature. The output is republished if the TTL has changed.
+//  seconds is the time to live of values, 0 if values don't expire
+// Returns false if the output doesn't exist.
+func (regOutputs *RegisteredOutputs) SetValueTTL(outputID string, seconds int) bool {
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+	output := regOutputs.outputsByID[outputID]
+	if output == nil {
+		return false
+	}
+	if output.ValueTTL != seconds {
+		output.ValueTTL = seconds
+		regOutputs.updateOutput(output, types.ChangeReasonAttr)
+	}
+	return true
+}
+
+// Snapshot returns copies of the registered outputs, sorted by output ID. The copies can be iterated
+// and modified without locking and without affecting the registered outputs.
+func (regOutputs *RegisteredOutputs) Snapshot() []*types.OutputDiscoveryMessage {
+	regOutputs.updateMutex.RLock()
+	defer regOutputs.updateMutex.RUnlock()
+
+	snapshot := make([]*types.OutputDiscoveryMessage, 0, len(regOutputs.outputsByID))
+	for _, output := range regOutputs.outputsByID {
+		newOutput := *output
+		newOutput.Attr = make(types.NodeAttrMap)
+		for key, value := range output.Attr {
+			newOutput.Attr[key] = value
+		}
+		newOutput.Config = make(types.ConfigAttrMap)
+		for key, value := range output.Config {
+			newOutput.Config[key] = value
+		}
+		newOutput.EnumValues = append([]string(nil), output.EnumValues...)
+		snapshot = append(snapshot, &newOutput)
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].OutputID < snapshot[j].OutputID })
+	return snapshot
+}
+
+// RepublishOutput marks the output as updated without change, so it is republished with the
+// next publication of updates, eg to refresh the discovery.
+func (regOutputs *RegisteredOutputs) RepublishOutput(output *types.OutputDiscoveryMessage) {
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+	regOutputs.updateOutput(output, types.ChangeReasonForced)
+}
+
 // UpdateOutput replaces the output and updates its timestamp.
 func (regOutputs *RegisteredOutputs) UpdateOutput(output *types.OutputDiscoveryMessage) {
 	regOutputs.updateMutex.Lock()
 	defer regOutputs.updateMutex.Unlock()
-	regOutputs.updateOutput(output)
+	regOutputs.updateOutput(output, types.ChangeReasonAttr)
 }
 
 // updateOutput replaces the output and updates its timestamp.
+// The reason is added to the reasons of a pending update of the output, if any.
 // For internal use only. Use within locked section.
-func (regOutputs *RegisteredOutputs) updateOutput(output *types.OutputDiscoveryMessage) {
+func (regOutputs *RegisteredOutputs) updateOutput(output *types.OutputDiscoveryMessage, reason types.ChangeReason) {
 	if output == nil {
 		return
 	}
+	var pendingReasons []types.ChangeReason
+	if _, isPending := regOutputs.updatedOutputIDs[output.OutputID]; isPending {
+		if pendingOutput := regOutputs.outputsByID[output.OutputID]; pendingOutput != nil {
+			pendingReasons = pendingOutput.ChangeReasons
+		}
+	}
+	output.ChangeReasons = types.AddChangeReason(pendingReasons, reason)
+	if output.Addresses.Discovery != output.Address {
+		output.Addresses = MakeOutputAddresses(output.Address)
+	}
 	regOutputs.outputsByID[output.OutputID] = output
 	regOutputs.addressMap[output.Address] = output.OutputID
+	regOutputs.nodeIndex.Add(output.NodeHWID, output.OutputID)
+	regOutputs.typeIndex.Add(string(output.OutputType), output.OutputID)
 
 	if regOutputs.updatedOutputIDs == nil {
 		regOutputs.updatedOutputIDs = make(map[string]string)
 	}
-	output.Timestamp = time.Now().Format(types.TimeFormat)
+	output.Timestamp = lib.Now().Format(types.TimeFormat)
 	regOutputs.updatedOutputIDs[output.OutputID] = output.OutputID
 }
 
+// ValidateValue returns an error if the value is not valid for the output, eg it isn't one of the
+// enum values of an enum output. Values of unknown outputs are not validated.
+func (regOutputs *RegisteredOutputs) ValidateValue(outputID string, value string) error {
+	regOutputs.updateMutex.RLock()
+	defer regOutputs.updateMutex.RUnlock()
+	output := regOutputs.outputsByID[outputID]
+	if output != nil && output.DataType == types.DataTypeEnum && !types.IsValidEnumValue(output.EnumValues, value) {
+		return lib.MakeErrorf("ValidateValue: Value '%s' is not one of the enum values %v of output %s",
+			value, output.EnumValues, outputID)
+	}
+	return nil
+}
+
+// MakeOutputHash returns the hash of the content of an output. The timestamp and change reasons are
+// excluded as they change with each update.
+func MakeOutputHash(output *types.OutputDiscoveryMessage) string {
+	content := *output
+	content.ChangeReasons = nil
+	content.Timestamp = ""
+	return lib.MakeContentHash(&content)
+}
+
 // MakeOutputID creates the internal ID to identify the output of the owning node
 func MakeOutputID(nodeHWID string, outputType types.OutputType, instance string) string {
 	outputID := nodeHWID + "." + string(outputType) + "." + instance
@@ -159,7 +406,7 @@ func NewOutput(domain string, publisherID string, nodeHWID string, outputType ty
 
 	output := &types.OutputDiscoveryMessage{
 		Address:   address,
-		Timestamp: time.Now().Format(types.TimeFormat),
+		Timestamp: lib.Now().Format(types.TimeFormat),
 		// internal use only
 		NodeHWID:    nodeHWID,
 		Instance:    instance,
@@ -173,11 +420,16 @@ func NewOutput(domain string, publisherID string, nodeHWID string, outputType ty
 // NewRegisteredOutputs creates a new instance for registered output management
 func NewRegisteredOutputs(domain string, publisherID string) *RegisteredOutputs {
 	regOutputs := RegisteredOutputs{
-		domain:      domain,
-		publisherID: publisherID,
-		addressMap:  make(map[string]string),
-		outputsByID: make(map[string]*types.OutputDiscoveryMessage),
-		updateMutex: &sync.Mutex{},
+		domain:          domain,
+		publisherID:     publisherID,
+		addressMap:      make(map[string]string),
+		afterPublish:    make(map[string]AfterPublishHook),
+		beforePublish:   make(map[string]BeforePublishHook),
+		nodeIndex:       make(lib.RegistryIndex),
+		outputsByID:     make(map[string]*types.OutputDiscoveryMessage),
+		publishedHashes: make(map[string]string),
+		typeIndex:       make(lib.RegistryIndex),
+		updateMutex:     &sync.RWMutex{},
 	}
 	return &regOutputs
 }
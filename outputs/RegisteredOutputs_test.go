@@ -2,8 +2,10 @@ package outputs_test
 
 import (
 	"crypto/ecdsa"
+	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/outputs"
@@ -62,12 +64,25 @@ func TestUpdateOutputs(t *testing.T) {
 	if !(assert.Equal(t, 1, len(updated), "Expected 1 updated output")) {
 		return
 	}
+	// update without change is not published
+	collection.UpdateOutput(output1)
+	updated = collection.GetUpdatedOutputs(true)
+	assert.Equal(t, 0, len(updated), "Expected no updated output when unchanged")
 	// update
+	output1.Attr = types.NodeAttrMap{types.NodeAttrDescription: "new description"}
 	collection.UpdateOutput(output1)
 	updated = collection.GetUpdatedOutputs(false)
 	if !(assert.Equal(t, 1, len(updated), "Expected 1 updated output")) {
 		return
 	}
+	assert.Equal(t, []types.ChangeReason{types.ChangeReasonAttr}, updated[0].ChangeReasons)
+	collection.RepublishOutput(output1)
+	updated = collection.GetUpdatedOutputs(true)
+	assert.Equal(t, []types.ChangeReason{types.ChangeReasonAttr, types.ChangeReasonForced}, updated[0].ChangeReasons)
+	// forced republication is published without change
+	collection.RepublishOutput(output1)
+	updated = collection.GetUpdatedOutputs(true)
+	assert.Equal(t, 1, len(updated), "Expected forced republication")
 }
 
 func TestAlias(t *testing.T) {
@@ -84,6 +99,97 @@ func TestAlias(t *testing.T) {
 	require.NotNilf(t, output1b, "Output not retrievable using alias nodeID")
 }
 
+func TestOutputIndexes(t *testing.T) {
+	collection := outputs.NewRegisteredOutputs("test", "publisher1")
+	for count := 0; count < 1000; count++ {
+		nodeHWID := fmt.Sprintf("node%d", count%100)
+		collection.CreateOutput(nodeHWID, types.OutputTypeTemperature, fmt.Sprint(count))
+	}
+	collection.CreateOutput("node1", types.OutputTypeHumidity, types.DefaultOutputInstance)
+	// replacing an output doesn't duplicate it in the indexes
+	collection.CreateOutput("node1", types.OutputTypeHumidity, types.DefaultOutputInstance)
+
+	nodeOutputs := collection.GetOutputsByNodeHWID("node1")
+	require.Equal(t, 11, len(nodeOutputs))
+	for index, output := range nodeOutputs {
+		assert.Equal(t, "node1", output.NodeHWID)
+		if index > 0 {
+			assert.Less(t, nodeOutputs[index-1].OutputID, output.OutputID, "Expected outputs ordered by ID")
+		}
+	}
+	assert.Equal(t, 1000, len(collection.GetOutputsByType(types.OutputTypeTemperature)))
+	assert.Equal(t, 1, len(collection.GetOutputsByType(types.OutputTypeHumidity)))
+	assert.Empty(t, collection.GetOutputsByType(types.OutputTypeSwitch))
+	assert.Empty(t, collection.GetOutputsByNodeHWID("unknown"))
+}
+
+func TestDeleteOutput(t *testing.T) {
+	collection := outputs.NewRegisteredOutputs("test", "publisher1")
+	output1 := collection.CreateOutput("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	collection.CreateOutput("node1", types.OutputTypeHumidity, types.DefaultOutputInstance)
+
+	assert.True(t, collection.DeleteOutput(output1.OutputID))
+	assert.Nil(t, collection.GetOutputByID(output1.OutputID))
+	assert.Nil(t, collection.GetOutputByAddress(output1.Address))
+	assert.Len(t, collection.GetOutputsByNodeHWID("node1"), 1)
+	assert.Empty(t, collection.GetOutputsByType(types.OutputTypeTemperature))
+	assert.Len(t, collection.GetUpdatedOutputs(true), 1)
+	// error case - the output is already deleted
+	assert.False(t, collection.DeleteOutput(output1.OutputID))
+}
+
+func TestOutputAddresses(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	const device1ID = "device1"
+	collection := outputs.NewRegisteredOutputs(domain, publisher1ID)
+	output := collection.CreateOutput(device1ID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+	addresses := outputs.GetOutputAddresses(output)
+	assert.Equal(t, "test/publisher1/device1/switch/0/$latest", addresses.Latest)
+	assert.Equal(t, "test/publisher1/device1/switch/0/$history", addresses.History)
+	assert.Equal(t, "test/publisher1/device1/switch/0/$raw", addresses.Raw)
+
+	// the addresses of registered outputs are precomputed
+	allocs := testing.AllocsPerRun(100, func() {
+		outputs.GetOutputAddresses(output)
+	})
+	assert.Zero(t, allocs)
+
+	// the addresses follow a change of the output address
+	collection.SetNodeID(device1ID, "bob")
+	assert.Equal(t, "test/publisher1/bob/switch/0/$latest", outputs.GetOutputAddresses(output).Latest)
+	output.Address = "test/publisher1/alice/switch/0/$output"
+	assert.Equal(t, "test/publisher1/alice/switch/0/$raw", outputs.GetOutputAddresses(output).Raw)
+	assert.Equal(t, "$raw", outputs.ReplaceMessageType("noaddress", types.MessageTypeRaw))
+}
+
+func TestOutputsSnapshot(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	const node1ID = "node1"
+	collection := outputs.NewRegisteredOutputs(domain, publisher1ID)
+	collection.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	output := collection.CreateOutput(node1ID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+	output.Attr = types.NodeAttrMap{types.NodeAttrName: "bob"}
+
+	snapshot := collection.Snapshot()
+	require.Equal(t, 2, len(snapshot))
+	assert.Equal(t, output.OutputID, snapshot[0].OutputID)
+
+	// modifying the snapshot doesn't affect the registered outputs
+	snapshot[0].Attr[types.NodeAttrName] = "alice"
+	snapshot[0].Address = "changed"
+	assert.Equal(t, "bob", output.Attr[types.NodeAttrName])
+	assert.NotNil(t, collection.GetOutputByAddress(output.Address))
+
+	// changing the node ID leaves earlier snapshots intact
+	collection.SetNodeID(node1ID, "alias1")
+	for _, aliasOutput := range collection.Snapshot() {
+		assert.Contains(t, aliasOutput.Address, "alias1")
+	}
+	assert.NotContains(t, snapshot[1].Address, "alias1")
+}
+
 func TestPublishOutputs(t *testing.T) {
 	const domain = "test"
 	const publisher1ID = "publisher1"
@@ -107,3 +213,101 @@ func TestPublishOutputs(t *testing.T) {
 
 	outputs.PublishRegisteredOutputs(allOutputs, signer)
 }
+
+func TestPublishHooks(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	const node1ID = "node1"
+	var afterValue = ""
+
+	collection := outputs.NewRegisteredOutputs(domain, publisher1ID)
+	output := collection.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+
+	// without hooks the value is published unchanged
+	value, publish := collection.BeforePublish(output, "35")
+	assert.True(t, publish)
+	assert.Equal(t, "35", value)
+	collection.AfterPublish(output, value, nil)
+
+	// clamp values above 30 and veto empty values
+	collection.SetPublishHooks(output.OutputID,
+		func(output *types.OutputDiscoveryMessage, value string) (string, bool) {
+			if value == "" {
+				return value, false
+			} else if value > "30" {
+				return "30", true
+			}
+			return value, true
+		},
+		func(output *types.OutputDiscoveryMessage, value string, err error) {
+			afterValue = value
+		})
+	value, publish = collection.BeforePublish(output, "35")
+	assert.True(t, publish)
+	assert.Equal(t, "30", value)
+	_, publish = collection.BeforePublish(output, "")
+	assert.False(t, publish)
+	collection.AfterPublish(output, value, nil)
+	assert.Equal(t, "30", afterValue)
+
+	// remove hooks
+	collection.SetPublishHooks(output.OutputID, nil, nil)
+	value, _ = collection.BeforePublish(output, "35")
+	assert.Equal(t, "35", value)
+}
+
+func TestOutputEnumValues(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	const node1ID = "node1"
+	collection := outputs.NewRegisteredOutputs(domain, publisher1ID)
+	output := collection.CreateOutput(node1ID, types.OutputTypeLevel, types.DefaultOutputInstance)
+	collection.GetUpdatedOutputs(true)
+
+	ok := collection.SetEnumValues(output.OutputID, []string{"heat", "cool", "auto", "off"})
+	assert.True(t, ok)
+	output = collection.GetOutputByID(output.OutputID)
+	assert.Equal(t, types.DataTypeEnum, output.DataType)
+	assert.Equal(t, []string{"heat", "cool", "auto", "off"}, output.EnumValues)
+	assert.Len(t, collection.GetUpdatedOutputs(true), 1)
+	assert.NoError(t, collection.ValidateValue(output.OutputID, "heat"))
+	assert.Error(t, collection.ValidateValue(output.OutputID, "dry"))
+
+	// unchanged values don't republish, removing the values accepts any value
+	collection.SetEnumValues(output.OutputID, []string{"heat", "cool", "auto", "off"})
+	assert.Len(t, collection.GetUpdatedOutputs(true), 0)
+	collection.SetEnumValues(output.OutputID, nil)
+	assert.Equal(t, types.DataTypeString, collection.GetOutputByID(output.OutputID).DataType)
+	assert.NoError(t, collection.ValidateValue(output.OutputID, "dry"))
+	ok = collection.SetEnumValues("notanoutput", []string{"on"})
+	assert.False(t, ok)
+}
+
+func TestOutputValueTTL(t *testing.T) {
+	collection := outputs.NewRegisteredOutputs("test", "publisher1")
+	output := collection.CreateOutput("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	collection.GetUpdatedOutputs(true)
+
+	ok := collection.SetValueTTL(output.OutputID, 900)
+	assert.True(t, ok)
+	assert.Equal(t, 900, collection.GetOutputByID(output.OutputID).ValueTTL)
+	assert.Len(t, collection.GetUpdatedOutputs(true), 1)
+	collection.SetValueTTL(output.OutputID, 900)
+	assert.Len(t, collection.GetUpdatedOutputs(true), 0)
+	assert.False(t, collection.SetValueTTL("notanoutput", 900))
+
+	// the latest value is valid until the TTL after it is measured
+	privKey := messaging.CreateAsymKeys()
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, privKey, nil)
+	latest := &types.OutputValue{Value: "20", EpochTime: 1590000000}
+	err := outputs.PublishOutputLatest(output, latest, signer)
+	require.NoError(t, err)
+	raw := messenger.FindLastPublication(outputs.GetOutputAddresses(output).Latest)
+	payload, err := messaging.VerifyJWSMessage(raw, &privKey.PublicKey)
+	require.NoError(t, err)
+	var message types.OutputLatestMessage
+	err = json.Unmarshal([]byte(payload), &message)
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1590000900, 0).Format(types.TimeFormat), message.ValidUntil)
+}
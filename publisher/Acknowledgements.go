@@ -0,0 +1,150 @@
+package publisher
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// PublishNodeConfigureAndWait publishes a $configure command to a domain node and waits until the
+// receiving publisher acknowledges it.
+// This returns the acknowledgement, or an error if the command cannot be sent, is rejected, or is not
+// acknowledged before the timeout.
+func (pub *Publisher) PublishNodeConfigureAndWait(domainNodeAddr string, attr types.NodeAttrMap,
+	timeout time.Duration) (*types.AckMessage, error) {
+
+	ackAddr, err := makeNodeAckAddress(domainNodeAddr)
+	if err != nil {
+		return nil, lib.MakeErrorf("PublishNodeConfigureAndWait: %s", err)
+	}
+	return pub.waitForAck(ackAddr, timeout, func(messageID string) error {
+		return nodes.PublishNodeConfigureWithID(domainNodeAddr, attr, messageID, pub.Address(), pub.messageSigner, nil)
+	})
+}
+
+// PublishSetInputAndWait publishes a $setInput command to the given input address and waits until
+// the receiving publisher acknowledges it. The command is acknowledged after the input handler
+// has processed it.
+// This returns the acknowledgement, or an error if the command cannot be sent, is rejected, or is not
+// acknowledged before the timeout.
+func (pub *Publisher) PublishSetInputAndWait(inputAddr string, value string,
+	timeout time.Duration) (*types.AckMessage, error) {
+
+	ackAddr, err := makeInputAckAddress(inputAddr)
+	if err != nil {
+		return nil, lib.MakeErrorf("PublishSetInputAndWait: %s", err)
+	}
+	return pub.waitForAck(ackAddr, timeout, func(messageID string) error {
+		return inputs.PublishSetInputWithID(inputAddr, value, messageID, pub.Address(), pub.messageSigner, nil)
+	})
+}
+
+// handleAck passes a received acknowledgement to the command that waits for it
+func (pub *Publisher) handleAck(address string, message string) error {
+	var ack types.AckMessage
+	_, err := messaging.VerifySenderJWSSignature(message, &ack, pub.GetPublisherKey)
+	if err != nil {
+		return lib.MakeErrorf("handleAck: Invalid acknowledgement on %s: %s", address, err)
+	}
+	// only the publisher of the input or node can acknowledge its commands
+	if ack.Address != address || !strings.HasPrefix(address, ack.Sender+"/") {
+		return lib.MakeErrorf("handleAck: Acknowledgement on %s from sender %s for address %s is not valid",
+			address, ack.Sender, ack.Address)
+	}
+	pub.updateMutex.Lock()
+	ackChannel := pub.pendingAcks[ack.MessageID]
+	delete(pub.pendingAcks, ack.MessageID)
+	pub.updateMutex.Unlock()
+	if ackChannel != nil {
+		ackChannel <- &ack
+	} else {
+		pub.completeOutboxCommand(&ack)
+	}
+	return nil
+}
+
+// subscribeAck subscribes to acknowledgements on the ack address for a command that waits for it
+func (pub *Publisher) subscribeAck(ackAddr string) {
+	pub.updateMutex.Lock()
+	pub.ackSubscriptions[ackAddr]++
+	isNewAddress := pub.ackSubscriptions[ackAddr] == 1
+	pub.updateMutex.Unlock()
+	if isNewAddress {
+		pub.messageSigner.Subscribe(ackAddr, pub.handleAck)
+	}
+}
+
+// unsubscribeAck removes the subscription to the ack address when no more commands wait for it
+func (pub *Publisher) unsubscribeAck(ackAddr string) {
+	pub.updateMutex.Lock()
+	pub.ackSubscriptions[ackAddr]--
+	isUnused := pub.ackSubscriptions[ackAddr] <= 0
+	if isUnused {
+		delete(pub.ackSubscriptions, ackAddr)
+	}
+	pub.updateMutex.Unlock()
+	if isUnused {
+		pub.messageSigner.Unsubscribe(ackAddr, pub.handleAck)
+	}
+}
+
+// waitForAck sends a command with a new message ID and waits for its acknowledgement on the ack address
+//  send publishes the command with the given message ID
+func (pub *Publisher) waitForAck(ackAddr string, timeout time.Duration,
+	send func(messageID string) error) (*types.AckMessage, error) {
+
+	messageID := lib.MakeMessageID()
+	ackChannel := make(chan *types.AckMessage, 1)
+	// subscribe before sending so the acknowledgement isn't missed
+	pub.updateMutex.Lock()
+	pub.pendingAcks[messageID] = ackChannel
+	pub.updateMutex.Unlock()
+	pub.subscribeAck(ackAddr)
+	defer func() {
+		pub.updateMutex.Lock()
+		delete(pub.pendingAcks, messageID)
+		pub.updateMutex.Unlock()
+		pub.unsubscribeAck(ackAddr)
+	}()
+
+	err := send(messageID)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case ack := <-ackChannel:
+		if ack.Status != types.AckStatusAccepted {
+			return ack, fmt.Errorf("command %s to %s is rejected: %s", messageID, ackAddr, ack.Error)
+		}
+		return ack, nil
+	case <-time.After(timeout):
+		return nil, errors.New("no acknowledgement received on " + ackAddr + " for command " + messageID)
+	}
+}
+
+// makeInputAckAddress returns the address of acknowledgements of set commands to an input
+//  domain/publisherID/nodeID/type/instance/$ack
+func makeInputAckAddress(inputAddr string) (string, error) {
+	addr, err := types.ParseAddress(inputAddr)
+	if err != nil || addr.IOType() == "" {
+		return "", fmt.Errorf("Input address '%s' is incomplete", inputAddr)
+	}
+	return addr.WithMessageType(types.MessageTypeAck).String(), nil
+}
+
+// makeNodeAckAddress returns the address of acknowledgements of configure commands to a node
+//  domain/publisherID/nodeID/$ack
+func makeNodeAckAddress(nodeAddr string) (string, error) {
+	addr, err := types.ParseAddress(nodeAddr)
+	if err != nil || addr.NodeID() == "" {
+		return "", fmt.Errorf("Node address '%s' is incomplete", nodeAddr)
+	}
+	return types.MakeNodeAddress(addr.Domain(), addr.PublisherID(), addr.NodeID(), types.MessageTypeAck).String(), nil
+}
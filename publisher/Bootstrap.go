@@ -0,0 +1,96 @@
+package publisher
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// BootstrapDiscoveryTimeout is the time in seconds to wait for brokers to respond during bootstrap
+const BootstrapDiscoveryTimeout = 2
+
+// NewBootstrapPublisher creates a publisher without prior configuration, for evaluating the library
+// and first-run of hobbyist setups. If the messenger configuration exists this is the same as
+// NewAppPublisher. Otherwise this bootstraps a local domain:
+//  1. Discover a broker on the local network using mDNS, or use a broker running on localhost
+//  2. Save a messenger configuration with domain 'local' and the broker for use on the next run
+//  3. Without a broker, publish on an in-process message bus until a broker is installed
+//  4. Create the publisher which generates and saves its identity
+//
+//  - appID is the application ID, used as publisher ID unless overridden in <appID>.yaml.
+//  - configFolder contains the identity, messenger and application configuration
+//     Use "" for default location (~/.config/iotdomain).
+//  - appConfig optional application object to load <appID>.yaml configuration into
+//
+// Use Run to start publishing. This returns error if the configuration cannot be saved.
+func NewBootstrapPublisher(appID string, configFolder string, appConfig interface{}) (*Publisher, error) {
+	if configFolder == "" {
+		configFolder = lib.DefaultConfigFolder
+	}
+	if _, err := os.Stat(path.Join(configFolder, lib.MessengerConfigFile)); err == nil {
+		return NewAppPublisher(appID, configFolder, appConfig, "", false)
+	}
+	logrus.Warningf("NewBootstrapPublisher: No messenger configuration in %s. Bootstrapping a local domain.", configFolder)
+	messengerConfig := messaging.MessengerConfig{
+		Domain:    types.LocalDomainID,
+		Messenger: "DummyMessenger",
+		Server:    "localhost",
+	}
+	server, port := bootstrapBroker()
+	if server != "" {
+		messengerConfig.Messenger = "MQTTMessenger"
+		messengerConfig.Server = server
+		messengerConfig.Port = port
+		err := lib.SaveYamlConfig(configFolder, lib.MessengerConfigFile, &messengerConfig)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Warningf("NewBootstrapPublisher: Using broker %s:%d. Configuration saved in %s",
+			server, port, path.Join(configFolder, lib.MessengerConfigFile))
+	} else {
+		// without a broker the publisher can still be evaluated on an in-process bus
+		logrus.Warningf("NewBootstrapPublisher: No broker found. Publishing on an in-process message bus." +
+			" Install a broker such as mosquitto to share information with other publishers.")
+		err := os.MkdirAll(configFolder, 0750)
+		if err != nil {
+			return nil, lib.MakeErrorf("NewBootstrapPublisher: Unable to create configuration folder %s: %s", configFolder, err)
+		}
+	}
+	messenger := messaging.NewMessenger(&messengerConfig)
+	pubConfig := &PublisherConfig{
+		ConfigFolder: configFolder,
+		CacheFolder:  lib.DefaultCacheFolder,
+		Loglevel:     "warning",
+		Domain:       messengerConfig.Domain,
+		PublisherID:  appID,
+	}
+	lib.LoadAppConfig(configFolder, appID, &pubConfig)
+	if appConfig != nil {
+		lib.LoadAppConfig(configFolder, appID, appConfig)
+	}
+	pub := NewPublisher(pubConfig, messenger)
+	return pub, nil
+}
+
+// bootstrapBroker returns the server and port of a broker discovered on the local network, or of a
+// broker running on localhost. This returns an empty server if no broker is found.
+func bootstrapBroker() (server string, port uint16) {
+	brokers, _ := messaging.DiscoverBrokers(messaging.MqttServiceType, BootstrapDiscoveryTimeout*time.Second)
+	if len(brokers) > 0 {
+		// the messenger connects using TLS
+		return brokers[0].Server(), messaging.TLSPort
+	}
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", messaging.TLSPort), time.Second)
+	if err == nil {
+		conn.Close()
+		return "localhost", messaging.TLSPort
+	}
+	return "", 0
+}
@@ -0,0 +1,105 @@
+// Package publisher with calibration of output values
+package publisher
+
+import (
+	"encoding/json"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// GetOutputCalibration returns the calibration of a registered output, or nil if the output isn't calibrated
+func (pub *Publisher) GetOutputCalibration(outputID string) *types.OutputCalibration {
+	output := pub.registeredOutputs.GetOutputByID(outputID)
+	if output == nil {
+		return nil
+	}
+	return output.Calibration
+}
+
+// PublishCalibrateCommand publishes a command to set the calibration of a domain output.
+// The receiving publisher acknowledges the command with a $ack message.
+//  outputAddress is the discovery address of the output
+//  calibration is the new calibration, nil to remove the calibration
+func (pub *Publisher) PublishCalibrateCommand(outputAddress string, calibration *types.OutputCalibration) error {
+	return outputs.PublishCalibrateCommand(outputAddress, calibration, lib.MakeMessageID(),
+		pub.Address(), pub.messageSigner)
+}
+
+// SetOutputCalibration sets the calibration of a registered output. The calibration is applied to
+// values when they are updated, and is reported in the output discovery.
+// It is stored in the node's calibration attribute so it is saved with the registered nodes. Adapters
+// can define the calibration configuration on a node to allow calibration with a $configure command.
+// Use nil to remove the calibration.
+// Returns an error if the output doesn't exist or the calibration is invalid.
+func (pub *Publisher) SetOutputCalibration(outputID string, calibration *types.OutputCalibration) error {
+	output := pub.registeredOutputs.GetOutputByID(outputID)
+	if output == nil {
+		return lib.MakeErrorf("SetOutputCalibration: Unknown output '%s'", outputID)
+	}
+	calibration, err := outputs.NormalizeCalibration(calibration)
+	if err != nil {
+		return err
+	}
+	calibrations := pub.getNodeCalibrations(output.NodeHWID)
+	attrID := string(output.OutputType) + "/" + output.Instance
+	if calibration == nil {
+		delete(calibrations, attrID)
+	} else {
+		calibrations[attrID] = calibration
+	}
+	attrValue := ""
+	if len(calibrations) > 0 {
+		jsonText, _ := json.Marshal(calibrations)
+		attrValue = string(jsonText)
+	}
+	logrus.Infof("SetOutputCalibration: output %s", outputID)
+	pub.registeredNodes.UpdateNodeAttr(output.NodeHWID, types.NodeAttrMap{types.NodeAttrCalibration: attrValue})
+	pub.registeredOutputs.SetCalibration(outputID, calibration)
+	return nil
+}
+
+// applyNodeCalibrations updates the calibration of the outputs of a node from the node's calibration
+// attribute, eg after the node is loaded or its configuration has changed.
+func (pub *Publisher) applyNodeCalibrations(nodeHWID string) {
+	calibrations := pub.getNodeCalibrations(nodeHWID)
+	for _, output := range pub.registeredOutputs.GetOutputsByNodeHWID(nodeHWID) {
+		attrID := string(output.OutputType) + "/" + output.Instance
+		calibration, err := outputs.NormalizeCalibration(calibrations[attrID])
+		if err != nil {
+			pub.reportError(ErrorCategoryCalibration, output.Address, err)
+			continue
+		}
+		pub.registeredOutputs.SetCalibration(output.OutputID, calibration)
+	}
+}
+
+// calibrateValue returns the calibrated value of an output. If the value can't be calibrated then
+// the error is reported and the value is returned unchanged.
+func (pub *Publisher) calibrateValue(outputID string, value string) string {
+	output := pub.registeredOutputs.GetOutputByID(outputID)
+	if output == nil || output.Calibration == nil {
+		return value
+	}
+	newValue, err := outputs.ApplyCalibration(output.Calibration, value)
+	pub.reportError(ErrorCategoryCalibration, output.Address, err)
+	return newValue
+}
+
+// getNodeCalibrations returns the output calibrations stored in the node's calibration attribute,
+// by output type/instance. Returns an empty map if the node has no calibrations.
+func (pub *Publisher) getNodeCalibrations(nodeHWID string) map[string]*types.OutputCalibration {
+	calibrations := make(map[string]*types.OutputCalibration)
+	node := pub.registeredNodes.GetNodeByHWID(nodeHWID)
+	if node == nil || node.Attr[types.NodeAttrCalibration] == "" {
+		return calibrations
+	}
+	err := json.Unmarshal([]byte(node.Attr[types.NodeAttrCalibration]), &calibrations)
+	if err != nil {
+		err = lib.MakeErrorf("getNodeCalibrations: Invalid calibration of node '%s': %s", nodeHWID, err)
+		pub.reportError(ErrorCategoryCalibration, node.Address, err)
+	}
+	return calibrations
+}
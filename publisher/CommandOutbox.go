@@ -0,0 +1,339 @@
+package publisher
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultOutboxRetryInterval is the default interval in seconds to resend unacknowledged commands
+const DefaultOutboxRetryInterval = 30
+
+// DefaultOutboxMaxAge is the default time in seconds after which an unacknowledged command expires
+const DefaultOutboxMaxAge = 24 * 3600
+
+// OutboxErrorExpired is the error of the acknowledgement of a command that expired in the outbox
+const OutboxErrorExpired = "expired"
+
+// Commands in the outbox
+const (
+	OutboxCommandConfigure = "configure" // node $configure command
+	OutboxCommandSetInput  = "setInput"  // input $setInput command
+)
+
+// OutboxCommand is a journaled command that is resent until the receiving publisher acknowledges it
+type OutboxCommand struct {
+	Address   string            `json:"address"`         // address of the input or node the command is for
+	Attempts  int               `json:"attempts"`        // nr of times the command was sent
+	Attr      types.NodeAttrMap `json:"attr,omitempty"`  // attributes of a configure command
+	Command   string            `json:"command"`         // OutboxCommandSetInput or OutboxCommandConfigure
+	Created   string            `json:"created"`         // time the command was journaled
+	LastSent  string            `json:"lastSent"`        // time the command was last sent, "" if never sent
+	MessageID string            `json:"messageId"`       // ID of the command, the same on each resend
+	Value     string            `json:"value,omitempty"` // value of a set input command
+}
+
+// CancelOutboxCommand removes a command from the outbox so it is no longer resent
+// Returns false if the command isn't in the outbox.
+func (pub *Publisher) CancelOutboxCommand(messageID string) bool {
+	pub.updateMutex.Lock()
+	command := pub.outbox[messageID]
+	delete(pub.outbox, messageID)
+	pub.updateMutex.Unlock()
+	if command == nil {
+		return false
+	}
+	pub.saveOutbox()
+	pub.unsubscribeAck(makeOutboxAckAddress(command))
+	return true
+}
+
+// GetOutbox returns a copy of the commands that are waiting for acknowledgement, oldest first
+func (pub *Publisher) GetOutbox() []OutboxCommand {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	commands := make([]OutboxCommand, 0, len(pub.outbox))
+	for _, command := range pub.outbox {
+		commands = append(commands, *command)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Created < commands[j].Created
+	})
+	return commands
+}
+
+// LoadOutbox loads the journaled commands from the cache folder and subscribes to their
+// acknowledgements. This is invoked on Start after which the commands are resent.
+func (pub *Publisher) LoadOutbox() error {
+	filename := path.Join(pub.config.CacheFolder, pub.PublisherID()+OutboxFileSuffix)
+	commandList := make([]*OutboxCommand, 0)
+	jsonText, err := lib.ReadFileWithRecovery(filename, lib.ValidateJSON)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return lib.MakeErrorf("LoadOutbox: Unable to open file %s: %s", filename, err)
+	}
+	err = json.Unmarshal(jsonText, &commandList)
+	if err != nil {
+		return lib.MakeErrorf("LoadOutbox: Error parsing JSON outbox file %s: %s", filename, err)
+	}
+	loaded := make([]*OutboxCommand, 0)
+	pub.updateMutex.Lock()
+	for _, command := range commandList {
+		if pub.outbox[command.MessageID] == nil {
+			pub.outbox[command.MessageID] = command
+			loaded = append(loaded, command)
+		}
+	}
+	pub.updateMutex.Unlock()
+	for _, command := range loaded {
+		pub.subscribeAck(makeOutboxAckAddress(command))
+	}
+	logrus.Infof("LoadOutbox: Loaded %d unacknowledged commands from %s", len(loaded), filename)
+	return nil
+}
+
+// PublishNodeConfigureReliable journals a $configure command to a domain node and sends it. The
+// command is resent until the receiving publisher acknowledges it, including after a restart of
+// this publisher. The receiver ignores duplicates within its dedup window, so its
+// CommandDedupWindow should cover the time this publisher can be down.
+// This returns the message ID of the command, or an error if the node address is invalid.
+func (pub *Publisher) PublishNodeConfigureReliable(domainNodeAddr string, attr types.NodeAttrMap) (string, error) {
+	command := &OutboxCommand{
+		Address: domainNodeAddr,
+		Attr:    attr,
+		Command: OutboxCommandConfigure,
+	}
+	return pub.addOutboxCommand(command)
+}
+
+// PublishSetInputReliable journals a $setInput command to an input and sends it. The command is resent
+// until the receiving publisher acknowledges it, including after a restart of this publisher. The
+// receiver ignores duplicates within its dedup window, so its CommandDedupWindow should cover the
+// time this publisher can be down.
+// This returns the message ID of the command, or an error if the input address is invalid.
+func (pub *Publisher) PublishSetInputReliable(inputAddr string, value string) (string, error) {
+	command := &OutboxCommand{
+		Address: inputAddr,
+		Command: OutboxCommandSetInput,
+		Value:   value,
+	}
+	return pub.addOutboxCommand(command)
+}
+
+// SetOutboxHandler sets the handler that is invoked when a command in the outbox is acknowledged.
+// The acknowledgement status tells whether the command is accepted or rejected. Rejected commands
+// are not resent. Commands that are not acknowledged within the outbox max age are removed and
+// passed to the handler as rejected with error OutboxErrorExpired.
+func (pub *Publisher) SetOutboxHandler(handler func(command OutboxCommand, ack *types.AckMessage)) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.outboxHandler = handler
+}
+
+// addOutboxCommand journals the command before sending it
+func (pub *Publisher) addOutboxCommand(command *OutboxCommand) (string, error) {
+	if makeOutboxAckAddress(command) == "" {
+		return "", lib.MakeErrorf("addOutboxCommand: Address '%s' of %s command is incomplete",
+			command.Address, command.Command)
+	}
+	command.MessageID = lib.MakeMessageID()
+	command.Created = lib.Now().Format(types.TimeFormat)
+	pub.updateMutex.Lock()
+	pub.outbox[command.MessageID] = command
+	pub.updateMutex.Unlock()
+	err := pub.saveOutbox()
+	if err != nil {
+		logrus.Errorf("addOutboxCommand: Command %s is not persisted: %s", command.MessageID, err)
+		pub.reportError(ErrorCategoryPersistence, command.Address, err)
+	}
+	pub.subscribeAck(makeOutboxAckAddress(command))
+	pub.sendOutboxCommand(command.MessageID)
+	return command.MessageID, nil
+}
+
+// completeOutboxCommand removes an acknowledged command from the outbox and passes the
+// acknowledgement to the outbox handler. Acknowledgements of unknown commands are ignored.
+func (pub *Publisher) completeOutboxCommand(ack *types.AckMessage) {
+	pub.updateMutex.Lock()
+	command := pub.outbox[ack.MessageID]
+	if command == nil || makeOutboxAckAddress(command) != ack.Address {
+		pub.updateMutex.Unlock()
+		return
+	}
+	delete(pub.outbox, ack.MessageID)
+	handler := pub.outboxHandler
+	pub.updateMutex.Unlock()
+	pub.saveOutbox()
+
+	logrus.Infof("completeOutboxCommand: Command %s to %s is %s after %d attempts",
+		command.MessageID, command.Address, ack.Status, command.Attempts)
+	pub.unsubscribeAck(ack.Address)
+	if handler != nil {
+		defer pub.recoverHandler("outbox", "", command.Address)
+		handler(*command, ack)
+	}
+}
+
+// outboxCount returns the nr of commands waiting for acknowledgement
+func (pub *Publisher) outboxCount() int {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	return len(pub.outbox)
+}
+
+// expireOutbox removes the commands that are not acknowledged within the max age and passes
+// them to the outbox handler as rejected
+func (pub *Publisher) expireOutbox() {
+	maxAge := time.Duration(pub.config.OutboxMaxAge) * time.Second
+	if maxAge <= 0 {
+		maxAge = DefaultOutboxMaxAge * time.Second
+	}
+	now := lib.Now()
+	pub.updateMutex.Lock()
+	expiredList := make([]*OutboxCommand, 0)
+	for messageID, command := range pub.outbox {
+		created, err := lib.ParseTimestamp(command.Created)
+		if err != nil || now.Sub(created) >= maxAge {
+			expiredList = append(expiredList, command)
+			delete(pub.outbox, messageID)
+		}
+	}
+	handler := pub.outboxHandler
+	pub.updateMutex.Unlock()
+	if len(expiredList) == 0 {
+		return
+	}
+	pub.saveOutbox()
+	for _, command := range expiredList {
+		logrus.Warningf("expireOutbox: Command %s to %s expired after %d attempts",
+			command.MessageID, command.Address, command.Attempts)
+		ackAddr := makeOutboxAckAddress(command)
+		pub.unsubscribeAck(ackAddr)
+		if handler != nil {
+			ack := &types.AckMessage{
+				Address:   ackAddr,
+				Error:     OutboxErrorExpired,
+				MessageID: command.MessageID,
+				Sender:    pub.Address(),
+				Status:    types.AckStatusRejected,
+				Timestamp: now.Format(types.TimeFormat),
+			}
+			func() {
+				defer pub.recoverHandler("outbox", "", command.Address)
+				handler(*command, ack)
+			}()
+		}
+	}
+}
+
+// resendOutbox removes expired commands and sends the commands that are not acknowledged within
+// the retry interval
+//  all sends all commands regardless of the retry interval, eg after a restart
+func (pub *Publisher) resendOutbox(all bool) {
+	pub.expireOutbox()
+	retryInterval := time.Duration(pub.config.OutboxRetryInterval) * time.Second
+	if retryInterval <= 0 {
+		retryInterval = DefaultOutboxRetryInterval * time.Second
+	}
+	now := lib.Now()
+	pub.updateMutex.Lock()
+	dueList := make([]string, 0)
+	for messageID, command := range pub.outbox {
+		lastSent, err := time.Parse(types.TimeFormat, command.LastSent)
+		if all || err != nil || now.Sub(lastSent) >= retryInterval {
+			dueList = append(dueList, messageID)
+		}
+	}
+	pub.updateMutex.Unlock()
+	for _, messageID := range dueList {
+		pub.sendOutboxCommand(messageID)
+	}
+}
+
+// saveOutbox saves the commands in the outbox to the cache folder, replacing the previous file
+// atomically so a crash cannot leave a partial journal. The outbox is copied under the update
+// mutex and written outside of it, so publishing doesn't wait for the disk. Saves are serialized
+// so an older copy never replaces a newer one. This must be called without the update mutex locked.
+func (pub *Publisher) saveOutbox() error {
+	pub.outboxSaveMutex.Lock()
+	defer pub.outboxSaveMutex.Unlock()
+	pub.updateMutex.Lock()
+	commandList := make([]OutboxCommand, 0, len(pub.outbox))
+	for _, command := range pub.outbox {
+		commandList = append(commandList, *command)
+	}
+	pub.updateMutex.Unlock()
+	sort.Slice(commandList, func(i, j int) bool {
+		return commandList[i].Created < commandList[j].Created
+	})
+	jsonText, err := json.MarshalIndent(commandList, "", "  ")
+	if err != nil {
+		return lib.MakeErrorf("saveOutbox: Error marshalling outbox: %s", err)
+	}
+	err = os.MkdirAll(pub.config.CacheFolder, 0750)
+	if err != nil {
+		return lib.MakeErrorf("saveOutbox: Unable to create cache folder %s: %s", pub.config.CacheFolder, err)
+	}
+	filename := path.Join(pub.config.CacheFolder, pub.PublisherID()+OutboxFileSuffix)
+	err = lib.WriteFileAtomic(filename, jsonText, 0600)
+	if err != nil {
+		return lib.MakeErrorf("saveOutbox: Error saving outbox to %s: %s", filename, err)
+	}
+	return nil
+}
+
+// sendOutboxCommand sends a command in the outbox and records the attempt
+// The command remains in the outbox if it can't be sent, for example when the public key of the
+// receiving publisher is not yet known, and is sent on a later retry.
+func (pub *Publisher) sendOutboxCommand(messageID string) {
+	pub.updateMutex.Lock()
+	command := pub.outbox[messageID]
+	if command == nil {
+		pub.updateMutex.Unlock()
+		return
+	}
+	destPubKey := pub.domainIdentities.GetPublisherKey(command.Address)
+	canSend := destPubKey != nil || !pub.messageSigner.EncryptCommands()
+	command.LastSent = lib.Now().Format(types.TimeFormat)
+	if canSend {
+		command.Attempts++
+	}
+	cmdCopy := *command
+	pub.updateMutex.Unlock()
+	pub.saveOutbox()
+
+	if !canSend {
+		logrus.Warningf("sendOutboxCommand: No public key for %s. Command %s is sent on a later retry",
+			cmdCopy.Address, messageID)
+		return
+	}
+	switch cmdCopy.Command {
+	case OutboxCommandConfigure:
+		nodes.PublishNodeConfigureWithID(cmdCopy.Address, cmdCopy.Attr, messageID, pub.Address(),
+			pub.messageSigner, destPubKey)
+	case OutboxCommandSetInput:
+		inputs.PublishSetInputWithID(cmdCopy.Address, cmdCopy.Value, messageID, pub.Address(),
+			pub.messageSigner, destPubKey)
+	}
+}
+
+// makeOutboxAckAddress returns the address of the acknowledgement of a command in the outbox
+// Returns "" if the command address is incomplete.
+func makeOutboxAckAddress(command *OutboxCommand) string {
+	var ackAddr string
+	if command.Command == OutboxCommandConfigure {
+		ackAddr, _ = makeNodeAckAddress(command.Address)
+	} else if command.Command == OutboxCommandSetInput {
+		ackAddr, _ = makeInputAckAddress(command.Address)
+	}
+	return ackAddr
+}
@@ -0,0 +1,55 @@
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/sirupsen/logrus"
+)
+
+// SetConfigReloadHandler sets the handler that is invoked when the application configuration file
+// <publisherID>.yaml is changed on disk while WatchConfig is enabled. The handler can reload its
+// configuration and update nodes and configuration values. The updates are published after the
+// handler returns.
+func (pub *Publisher) SetConfigReloadHandler(handler func(filename string)) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.configReloadHandler = handler
+}
+
+// handleConfigChange reloads a changed configuration file and publishes the resulting updates
+//  The registered nodes file is reloaded into the registered nodes.
+//  The application configuration file updates the log level and is passed to the reload handler.
+func (pub *Publisher) handleConfigChange(filename string) {
+	if filename == pub.PublisherID()+RegisteredNodesFileSuffix {
+		err := pub.LoadRegisteredNodes()
+		if err != nil {
+			logrus.Errorf("handleConfigChange: %s", err)
+			return
+		}
+	} else {
+		reloadedConfig := PublisherConfig{}
+		err := lib.LoadYamlConfig(pub.config.ConfigFolder, filename, pub.PublisherID(), &reloadedConfig)
+		if err != nil {
+			return
+		}
+		if reloadedConfig.Loglevel != "" {
+			level, err := logrus.ParseLevel(reloadedConfig.Loglevel)
+			if err == nil {
+				logrus.SetLevel(level)
+			}
+		}
+		pub.updateMutex.Lock()
+		handler := pub.configReloadHandler
+		pub.updateMutex.Unlock()
+		if handler != nil {
+			pub.invokeConfigReloadHandler(handler, filename)
+		}
+	}
+	logrus.Infof("handleConfigChange: Configuration file %s is reloaded", filename)
+	pub.PublishUpdates()
+}
+
+// invokeConfigReloadHandler invokes the reload handler and recovers from a panic in the handler
+func (pub *Publisher) invokeConfigReloadHandler(handler func(filename string), filename string) {
+	defer pub.recoverHandler("configReload", "", filename)
+	handler(filename)
+}
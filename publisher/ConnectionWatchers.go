@@ -0,0 +1,48 @@
+// Package publisher with notification of changes to the connection with the message bus
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/messaging"
+)
+
+// ConnectionWatcher is invoked with the new state of the connection with the message bus.
+// err holds the reason of an unintended disconnect.
+type ConnectionWatcher func(state messaging.ConnectionState, err error)
+
+// ConnectionState returns the current state of the connection with the message bus.
+// Publications while not connected are lost.
+func (pub *Publisher) ConnectionState() messaging.ConnectionState {
+	return pub.messenger.ConnectionState()
+}
+
+// OnConnectionChange adds a handler that is invoked when the connection with the message bus is
+// established, lost or closed. Adapters can use this to pause polling of devices or to set the run
+// state of their nodes while the bus is down, instead of publishing into the void.
+// Use a nil handler to remove the handlers.
+func (pub *Publisher) OnConnectionChange(handler ConnectionWatcher) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	if handler == nil {
+		pub.connectionWatchers = nil
+		return
+	}
+	pub.connectionWatchers = append(pub.connectionWatchers, handler)
+}
+
+// notifyConnectionWatchers invokes the handlers that watch the connection with the message bus
+func (pub *Publisher) notifyConnectionWatchers(err error) {
+	pub.updateMutex.Lock()
+	watchers := pub.connectionWatchers
+	pub.updateMutex.Unlock()
+
+	state := pub.ConnectionState()
+	for _, handler := range watchers {
+		pub.invokeConnectionWatcher(handler, state, err)
+	}
+}
+
+// invokeConnectionWatcher invokes a watcher and recovers from a panic in the handler
+func (pub *Publisher) invokeConnectionWatcher(handler ConnectionWatcher, state messaging.ConnectionState, err error) {
+	defer pub.recoverHandler("connectionChange", "", "")
+	handler(state, err)
+}
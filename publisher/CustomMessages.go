@@ -0,0 +1,113 @@
+package publisher
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// GetMessageTypes returns the registered custom message types, sorted by name
+func (pub *Publisher) GetMessageTypes() []string {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	messageTypes := make([]string, 0, len(pub.customMessageTypes))
+	for messageType := range pub.customMessageTypes {
+		messageTypes = append(messageTypes, messageType)
+	}
+	sort.Strings(messageTypes)
+	return messageTypes
+}
+
+// PublishCustomMessage publishes a signed message with a registered custom message type.
+// The payload is marshalled to JSON and wrapped in a CustomMessage envelope.
+//  address is the address of the node, input or output without message type, eg domain/publisher/node
+//  messageType is the registered custom message type, eg $diag
+//  encrypt encrypts the message with the key of the publisher of the address. Its identity must be known.
+func (pub *Publisher) PublishCustomMessage(address string, messageType string, payload interface{},
+	retained bool, encrypt bool) error {
+
+	if !pub.isMessageTypeRegistered(messageType) {
+		return lib.MakeErrorf("PublishCustomMessage: Message type '%s' is not registered", messageType)
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return lib.MakeErrorf("PublishCustomMessage: Unable to marshal payload for %s: %s", messageType, err)
+	}
+	messageAddress := address + "/" + messageType
+	message := &types.CustomMessage{
+		Address:   messageAddress,
+		Payload:   payloadJSON,
+		Sender:    pub.Address(),
+		Timestamp: lib.Now().Format(types.TimeFormat),
+	}
+	if !encrypt {
+		return pub.messageSigner.PublishObject(messageAddress, retained, message, nil)
+	}
+	destPubKey := pub.GetPublisherKey(address)
+	if destPubKey == nil {
+		return lib.MakeErrorf("PublishCustomMessage: no public key found to encrypt message to %s. Message not sent.", address)
+	}
+	return pub.messageSigner.PublishObject(messageAddress, retained, message, destPubKey)
+}
+
+// RegisterMessageType registers a custom message type so it can be published and subscribed to.
+// Custom message types start with '$' and cannot be one of the standard message types.
+func (pub *Publisher) RegisterMessageType(messageType string) error {
+	if len(messageType) < 2 || !strings.HasPrefix(messageType, "$") ||
+		strings.ContainsAny(messageType, "/+# ") {
+		return lib.MakeErrorf("RegisterMessageType: Invalid message type '%s'", messageType)
+	}
+	for _, standardType := range types.StandardMessageTypes {
+		if messageType == standardType {
+			return lib.MakeErrorf("RegisterMessageType: '%s' is a standard message type", messageType)
+		}
+	}
+	logrus.Infof("RegisterMessageType: Registered custom message type '%s'", messageType)
+	pub.updateMutex.Lock()
+	pub.customMessageTypes[messageType] = true
+	pub.updateMutex.Unlock()
+	return nil
+}
+
+// SubscribeToCustomMessage subscribes to messages with a registered custom message type.
+// Messages are decrypted if needed and their signature is verified with the sender's public key.
+// Messages that fail verification are discarded. Use json.Unmarshal to decode the message payload.
+//  address is the address without message type and can contain wildcards, eg domain/+/+
+func (pub *Publisher) SubscribeToCustomMessage(address string, messageType string,
+	handler func(message *types.CustomMessage)) error {
+
+	if !pub.isMessageTypeRegistered(messageType) {
+		return lib.MakeErrorf("SubscribeToCustomMessage: Message type '%s' is not registered", messageType)
+	}
+	pub.messageSigner.Subscribe(address+"/"+messageType, func(address string, rawMessage string) error {
+		var message types.CustomMessage
+		_, _, err := pub.messageSigner.DecodeMessage(rawMessage, &message)
+		if err != nil {
+			return lib.MakeErrorf("SubscribeToCustomMessage: Invalid message on %s: %s", address, err)
+		}
+		if message.Address != address {
+			return lib.MakeErrorf("SubscribeToCustomMessage: Message address '%s' doesn't match the address it is published on: %s",
+				message.Address, address)
+		}
+		handler(&message)
+		return nil
+	})
+	return nil
+}
+
+// UnsubscribeFromCustomMessage removes the subscriptions to a custom message type
+// Use the same address as used in SubscribeToCustomMessage
+func (pub *Publisher) UnsubscribeFromCustomMessage(address string, messageType string) {
+	pub.messageSigner.Unsubscribe(address+"/"+messageType, nil)
+}
+
+// isMessageTypeRegistered returns true if the custom message type is registered
+func (pub *Publisher) isMessageTypeRegistered(messageType string) bool {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	return pub.customMessageTypes[messageType]
+}
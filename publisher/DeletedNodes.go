@@ -0,0 +1,47 @@
+package publisher
+
+import (
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// isNodeDeleted returns true if the node is soft deleted and not recreated
+func (pub *Publisher) isNodeDeleted(nodeHWID string) bool {
+	return pub.registeredNodes.IsDeleted(nodeHWID) && pub.registeredNodes.GetNodeByHWID(nodeHWID) == nil
+}
+
+// filterDeletedInputs returns the inputs whose node is not deleted
+func (pub *Publisher) filterDeletedInputs(inputList []*types.InputDiscoveryMessage) []*types.InputDiscoveryMessage {
+	filtered := make([]*types.InputDiscoveryMessage, 0, len(inputList))
+	for _, input := range inputList {
+		if !pub.isNodeDeleted(input.NodeHWID) {
+			filtered = append(filtered, input)
+		}
+	}
+	return filtered
+}
+
+// filterDeletedOutputs returns the outputs whose node is not deleted
+func (pub *Publisher) filterDeletedOutputs(outputList []*types.OutputDiscoveryMessage) []*types.OutputDiscoveryMessage {
+	filtered := make([]*types.OutputDiscoveryMessage, 0, len(outputList))
+	for _, output := range outputList {
+		if !pub.isNodeDeleted(output.NodeHWID) {
+			filtered = append(filtered, output)
+		}
+	}
+	return filtered
+}
+
+// purgeDeletedNodes permanently removes deleted nodes that are past their retention period
+func (pub *Publisher) purgeDeletedNodes() {
+	retention := time.Duration(pub.config.DeletedNodeRetention) * 24 * time.Hour
+	purged := pub.registeredNodes.PurgeDeletedNodes(retention)
+	for _, hwID := range purged {
+		pub.registeredInputs.SetNodeDisabled(hwID, false)
+		pub.deviceMap.RemoveDevice(hwID)
+	}
+	if len(purged) > 0 && pub.config.ConfigFolder != "" {
+		pub.SaveRegisteredNodes()
+	}
+}
@@ -0,0 +1,103 @@
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// maxDerivedDepth is the max nr of derived outputs that derive from each other in a chain, eg
+// energy cost derived from power that is derived from voltage and current
+const maxDerivedDepth = 5
+
+// CreateDerivedOutput creates an output whose value is computed with an expression from the values of
+// other outputs, eg: "voltage * current" for a power output or "dewpoint(temperature, humidity)".
+// The value is computed when an output it refers to is updated and is published like other outputs.
+// See outputs.ParseExpression for the expression language and outputs.ResolveExpressionVariable for
+// the outputs that the variables refer to.
+// The expression is a configuration of the node so it can be changed with a $configure command.
+// Returns the output or an error if the expression is invalid or refers to the output itself.
+func (pub *Publisher) CreateDerivedOutput(nodeHWID string, outputType types.OutputType, instance string,
+	expression string) (*types.OutputDiscoveryMessage, error) {
+
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	parsed, err := outputs.ParseExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range parsed.Variables() {
+		if outputs.ResolveExpressionVariable(nodeHWID, name) == outputID {
+			return nil, lib.MakeErrorf("CreateDerivedOutput: Expression of output %s refers to itself", outputID)
+		}
+	}
+	output := pub.CreateOutput(nodeHWID, outputType, instance)
+	pub.registeredNodes.UpdateNodeConfig(nodeHWID, outputs.MakeOutputExpressionAttr(outputType, instance), &types.ConfigAttr{
+		DataType:    types.DataTypeString,
+		Default:     expression,
+		Description: "Expression of the derived output value, eg voltage * current",
+	})
+	pub.updateMutex.Lock()
+	pub.derivedOutputs[outputID] = nodeHWID
+	pub.updateMutex.Unlock()
+	return output, nil
+}
+
+// updateDerivedOutputs computes the values of the derived outputs that refer to updated output values.
+// Derived outputs can refer to other derived outputs up to maxDerivedDepth.
+func (pub *Publisher) updateDerivedOutputs() {
+	pub.updateMutex.Lock()
+	derivedOutputs := make(map[string]string, len(pub.derivedOutputs))
+	for outputID, nodeHWID := range pub.derivedOutputs {
+		derivedOutputs[outputID] = nodeHWID
+	}
+	pub.updateMutex.Unlock()
+	if len(derivedOutputs) == 0 {
+		return
+	}
+	isUpdated := make(map[string]bool)
+	for _, outputID := range pub.registeredOutputValues.GetUpdatedOutputValues(false) {
+		isUpdated[outputID] = true
+	}
+	getValue := func(outputID string) (string, bool) {
+		latest := pub.registeredOutputValues.GetOutputValueByID(outputID)
+		if latest == nil {
+			return "", false
+		}
+		return latest.Value, true
+	}
+	for depth := 0; depth < maxDerivedDepth && len(isUpdated) > 0; depth++ {
+		derivedUpdates := make(map[string]bool)
+		for outputID, nodeHWID := range derivedOutputs {
+			output := pub.registeredOutputs.GetOutputByID(outputID)
+			if output == nil || pub.isNodeDeleted(nodeHWID) {
+				continue
+			}
+			attrName := outputs.MakeOutputExpressionAttr(output.OutputType, output.Instance)
+			text, _ := pub.registeredNodes.GetNodeConfigString(nodeHWID, attrName, "")
+			expression, err := outputs.ParseExpression(text)
+			if err != nil || !refersToUpdated(expression, nodeHWID, isUpdated) {
+				continue
+			}
+			value, err := outputs.EvaluateDerivedValue(expression, nodeHWID, getValue)
+			if err != nil {
+				logrus.Warningf("updateDerivedOutputs: Value of output %s is not updated: %s", outputID, err)
+				continue
+			}
+			if pub.registeredOutputValues.UpdateOutputValue(outputID, value) {
+				derivedUpdates[outputID] = true
+			}
+		}
+		isUpdated = derivedUpdates
+	}
+}
+
+// refersToUpdated returns true if the expression refers to an output that is updated
+func refersToUpdated(expression *outputs.Expression, nodeHWID string, isUpdated map[string]bool) bool {
+	for _, name := range expression.Variables() {
+		if isUpdated[outputs.ResolveExpressionVariable(nodeHWID, name)] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package publisher
+
+import (
+	"path"
+
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// CreateNodeForDevice creates the node of a device that is identified by stable device IDs, such as
+// its MAC address or serial number. If any of the device IDs is mapped to an existing node, that node
+// is returned instead of creating a duplicate node, so the node keeps its node ID and configuration
+// when the hardware ID of the device changes, eg when the hardware re-enumerates. See nodes.DeviceMap
+// for the mapping rules. The mapping is saved in the config folder.
+//  hwID is the hardware ID of the device as used by the adapter
+//  deviceIDs are the stable identifiers of the device
+// Returns the node of the device. Use its HWID to create inputs and outputs.
+func (pub *Publisher) CreateNodeForDevice(hwID string, nodeType types.NodeType, deviceIDs ...string) *types.NodeDiscoveryMessage {
+	nodeHWID := pub.deviceMap.MapDevice(hwID, deviceIDs...)
+	return pub.CreateNode(nodeHWID, nodeType)
+}
+
+// GetDeviceMappings returns the mappings of stable device IDs to the hardware ID of their nodes
+func (pub *Publisher) GetDeviceMappings() []nodes.DeviceMapping {
+	return pub.deviceMap.GetMappings()
+}
+
+// GetNodeByDeviceID returns the node of a device by one of its stable device IDs
+// Returns nil if the device ID isn't mapped or its node doesn't exist
+func (pub *Publisher) GetNodeByDeviceID(deviceID string) *types.NodeDiscoveryMessage {
+	hwID, found := pub.deviceMap.GetHWID(deviceID)
+	if !found {
+		return nil
+	}
+	return pub.registeredNodes.GetNodeByHWID(hwID)
+}
+
+// LoadDeviceMap loads the mapping of stable device IDs to nodes from the config folder.
+// Changes to the mapping are saved to this folder. This is invoked when the publisher is created.
+func (pub *Publisher) LoadDeviceMap() error {
+	if pub.config.ConfigFolder == "" {
+		return nil
+	}
+	filename := path.Join(pub.config.ConfigFolder, pub.PublisherID()+DeviceMapFileSuffix)
+	err := pub.deviceMap.LoadDeviceMap(filename)
+	pub.reportError(ErrorCategoryPersistence, filename, err)
+	return err
+}
+
+// RemoveDeviceMapping removes the mapping of the device IDs of a node, so the device IDs can be
+// mapped to another node. The mapping is removed when the node is purged.
+// Returns false if the node has no mapping.
+func (pub *Publisher) RemoveDeviceMapping(nodeHWID string) bool {
+	return pub.deviceMap.RemoveDevice(nodeHWID)
+}
@@ -0,0 +1,58 @@
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DryRunMessageTypes are the message types of commands that are logged instead of published in
+// dry-run mode
+var DryRunMessageTypes = []string{
+	types.MessageTypeCalibrate,
+	types.MessageTypeConfigure,
+	types.MessageTypeCreate,
+	types.MessageTypeDelete,
+	types.MessageTypePair,
+	types.MessageTypeSetInput,
+	types.MessageTypeSetNodeID,
+	types.MessageTypeUpgrade,
+}
+
+// IsDryRun returns true if the publisher runs in dry-run mode, see SetDryRun
+func (pub *Publisher) IsDryRun() bool {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	return pub.config.DryRun
+}
+
+// SetDryRun sets the dry-run mode, eg to soak-test a new version of an adapter against a production
+// domain. In dry-run mode nodes, inputs and outputs are registered and published as usual, but
+// commands this publisher sends, such as $setInput and $configure, are logged instead of published.
+// Commands that are received are logged instead of passed to the input handlers, so the devices
+// of the adapter aren't affected. Adapters can use IsDryRun to skip other operations that affect
+// their devices.
+func (pub *Publisher) SetDryRun(dryRun bool) {
+	pub.updateMutex.Lock()
+	pub.config.DryRun = dryRun
+	pub.updateMutex.Unlock()
+	pub.registeredInputs.SetDryRun(dryRun)
+	logrus.Infof("SetDryRun: Dry-run mode of publisher %s is %t", pub.PublisherID(), dryRun)
+}
+
+// dryRunHook is the publish hook that vetoes commands in dry-run mode
+func (pub *Publisher) dryRunHook(address string, object interface{}) (interface{}, bool) {
+	if !pub.IsDryRun() {
+		return object, true
+	}
+	addr, err := types.ParseAddress(address)
+	if err != nil {
+		return object, true
+	}
+	for _, messageType := range DryRunMessageTypes {
+		if addr.MessageType() == messageType {
+			logrus.Infof("dryRunHook: Dry run. Command on '%s' is not published: %+v", address, object)
+			return object, false
+		}
+	}
+	return object, true
+}
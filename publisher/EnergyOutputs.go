@@ -0,0 +1,187 @@
+// Package publisher with energy counters that accumulate power outputs
+package publisher
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultEnergySaveInterval is the default interval in seconds of saving changed energy counters
+const DefaultEnergySaveInterval = 60
+
+// energyOutput with the accumulation of a power output into energy counter outputs
+type energyOutput struct {
+	accumulator   *outputs.EnergyAccumulator // accumulated energy counters
+	nodeHWID      string                     // node of the power output
+	powerInstance string                     // instance of the power output
+}
+
+// CreateEnergyOutputs creates energy outputs that accumulate the power of a node's power output in kWh.
+// This creates energy outputs for the total, the current day and the current month and, if tariff
+// windows are given, for the total of each tariff. See outputs.MakeEnergyInstance for their instances.
+// The counters are saved in the cache folder and continue where they left off after a restart.
+//  nodeHWID is the node of the power output
+//  powerInstance is the instance of the power output in Watt
+//  options with the max power age and optional tariff windows
+// Returns the energy outputs or an error if a tariff window is invalid.
+func (pub *Publisher) CreateEnergyOutputs(nodeHWID string, powerInstance string,
+	options outputs.EnergyOptions) ([]*types.OutputDiscoveryMessage, error) {
+
+	powerOutputID := outputs.MakeOutputID(nodeHWID, types.OutputTypeElectricPower, powerInstance)
+	counters := pub.loadEnergyCounters()[powerOutputID]
+	accumulator, err := outputs.NewEnergyAccumulator(options, counters)
+	if err != nil {
+		return nil, err
+	}
+	counterNames := []string{outputs.EnergyInstanceTotal, outputs.EnergyInstanceDay, outputs.EnergyInstanceMonth}
+	for _, tariff := range options.Tariffs {
+		counterNames = append(counterNames, tariff.Name)
+	}
+	energyOutputs := make([]*types.OutputDiscoveryMessage, 0, len(counterNames))
+	for _, counterName := range counterNames {
+		instance := outputs.MakeEnergyInstance(powerInstance, counterName)
+		output := *pub.CreateOutput(nodeHWID, types.OutputTypeElectricEnergy, instance)
+		output.Unit = types.UnitKWH
+		pub.registeredOutputs.UpdateOutput(&output)
+		energyOutputs = append(energyOutputs, &output)
+	}
+	pub.updateMutex.Lock()
+	pub.energyOutputs[powerOutputID] = &energyOutput{
+		accumulator:   accumulator,
+		nodeHWID:      nodeHWID,
+		powerInstance: powerInstance,
+	}
+	pub.updateMutex.Unlock()
+	pub.updateEnergyOutputs(lib.Now())
+	return energyOutputs, nil
+}
+
+// GetEnergyCounters returns a copy of the energy counters of a power output
+// Returns nil if the power output has no energy outputs.
+func (pub *Publisher) GetEnergyCounters(nodeHWID string, powerInstance string) *outputs.EnergyCounters {
+	powerOutputID := outputs.MakeOutputID(nodeHWID, types.OutputTypeElectricPower, powerInstance)
+	pub.updateMutex.Lock()
+	energy := pub.energyOutputs[powerOutputID]
+	pub.updateMutex.Unlock()
+	if energy == nil {
+		return nil
+	}
+	counters := energy.accumulator.Counters()
+	return &counters
+}
+
+// SaveEnergyCounters saves the energy counters of all power outputs to the cache folder
+func (pub *Publisher) SaveEnergyCounters() error {
+	pub.updateMutex.Lock()
+	countersMap := make(map[string]outputs.EnergyCounters, len(pub.energyOutputs))
+	for powerOutputID, energy := range pub.energyOutputs {
+		countersMap[powerOutputID] = energy.accumulator.Counters()
+	}
+	pub.energySaved = lib.Now()
+	pub.updateMutex.Unlock()
+
+	jsonText, err := json.MarshalIndent(countersMap, "", "  ")
+	if err != nil {
+		return lib.MakeErrorf("SaveEnergyCounters: Error marshalling energy counters: %s", err)
+	}
+	err = os.MkdirAll(pub.config.CacheFolder, 0750)
+	if err != nil {
+		return lib.MakeErrorf("SaveEnergyCounters: Unable to create cache folder %s: %s", pub.config.CacheFolder, err)
+	}
+	filename := path.Join(pub.config.CacheFolder, pub.PublisherID()+EnergyFileSuffix)
+	err = lib.WriteFileAtomic(filename, jsonText, 0600)
+	if err != nil {
+		return lib.MakeErrorf("SaveEnergyCounters: Error saving energy counters to %s: %s", filename, err)
+	}
+	return nil
+}
+
+// loadEnergyCounters loads the saved energy counters by power output ID from the cache folder
+// Returns an empty map if no counters are saved or they can't be read.
+func (pub *Publisher) loadEnergyCounters() map[string]*outputs.EnergyCounters {
+	countersMap := make(map[string]*outputs.EnergyCounters)
+	filename := path.Join(pub.config.CacheFolder, pub.PublisherID()+EnergyFileSuffix)
+	jsonText, err := lib.ReadFileWithRecovery(filename, lib.ValidateJSON)
+	if os.IsNotExist(err) {
+		return countersMap
+	} else if err == nil {
+		err = json.Unmarshal(jsonText, &countersMap)
+	}
+	if err != nil {
+		logrus.Errorf("loadEnergyCounters: Energy counters in %s are not loaded: %s", filename, err)
+		pub.reportError(ErrorCategoryPersistence, "", err)
+	}
+	return countersMap
+}
+
+// updateEnergyOutputs accumulates the latest power values up to now into the energy counters and
+// updates the energy output values. Changed counters are saved once the save interval has passed.
+func (pub *Publisher) updateEnergyOutputs(now time.Time) {
+	pub.updateMutex.Lock()
+	energyOutputs := make(map[string]*energyOutput, len(pub.energyOutputs))
+	for powerOutputID, energy := range pub.energyOutputs {
+		energyOutputs[powerOutputID] = energy
+	}
+	energySaved := pub.energySaved
+	pub.updateMutex.Unlock()
+	if len(energyOutputs) == 0 {
+		return
+	}
+	isChanged := false
+	for powerOutputID, energy := range energyOutputs {
+		if pub.isNodeDeleted(energy.nodeHWID) {
+			continue
+		}
+		previous := energy.accumulator.Counters()
+		latest := pub.registeredOutputValues.GetOutputValueByID(powerOutputID)
+		if latest != nil {
+			watt, err := strconv.ParseFloat(strings.TrimSpace(latest.Value), 64)
+			measured, errTime := time.Parse(types.TimeFormat, latest.Timestamp)
+			if errTime != nil {
+				measured = time.Unix(latest.EpochTime, 0)
+			}
+			if err == nil {
+				energy.accumulator.SetPower(watt, measured)
+			}
+		}
+		energy.accumulator.Accumulate(now)
+		counters := energy.accumulator.Counters()
+		isChanged = isChanged || counters.Total != previous.Total || counters.Day != previous.Day ||
+			counters.Month != previous.Month
+
+		values := map[string]float64{
+			outputs.EnergyInstanceTotal: counters.Total,
+			outputs.EnergyInstanceDay:   counters.DayEnergy,
+			outputs.EnergyInstanceMonth: counters.MonthEnergy,
+		}
+		for tariff, tariffEnergy := range counters.Tariffs {
+			values[tariff] = tariffEnergy
+		}
+		for counterName, value := range values {
+			instance := outputs.MakeEnergyInstance(energy.powerInstance, counterName)
+			outputID := outputs.MakeOutputID(energy.nodeHWID, types.OutputTypeElectricEnergy, instance)
+			if pub.registeredOutputs.GetOutputByID(outputID) == nil {
+				// the energy output is deleted
+				continue
+			}
+			pub.registeredOutputValues.UpdateOutputValue(outputID, outputs.FormatEnergy(value))
+		}
+	}
+	saveInterval := time.Duration(pub.config.EnergySaveInterval) * time.Second
+	if saveInterval <= 0 {
+		saveInterval = DefaultEnergySaveInterval * time.Second
+	}
+	if isChanged && now.Sub(energySaved) >= saveInterval {
+		err := pub.SaveEnergyCounters()
+		pub.reportError(ErrorCategoryPersistence, "", err)
+	}
+}
@@ -0,0 +1,35 @@
+// Package publisher with enumeration values of inputs and outputs
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// SetInputEnumValues sets the valid values of a registered input, eg "heat", "cool", "auto", "off"
+// for a hvac mode. The values are published in the input discovery so consumers can present them
+// as choices. Set commands with other values are rejected. Use nil to remove the enum values.
+// Returns an error if the input doesn't exist.
+func (pub *Publisher) SetInputEnumValues(nodeHWID string, inputType types.InputType, instance string,
+	enumValues []string) error {
+	inputID := inputs.MakeInputHWID(nodeHWID, inputType, instance)
+	if !pub.registeredInputs.SetEnumValues(inputID, enumValues) {
+		return lib.MakeErrorf("SetInputEnumValues: Unknown input '%s'", inputID)
+	}
+	return nil
+}
+
+// SetOutputEnumValues sets the valid values of a registered output, eg "heat", "cool", "auto", "off"
+// for a hvac mode. The values are published in the output discovery. Updates of the output value
+// with other values are rejected. Use nil to remove the enum values.
+// Returns an error if the output doesn't exist.
+func (pub *Publisher) SetOutputEnumValues(nodeHWID string, outputType types.OutputType, instance string,
+	enumValues []string) error {
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	if !pub.registeredOutputs.SetEnumValues(outputID, enumValues) {
+		return lib.MakeErrorf("SetOutputEnumValues: Unknown output '%s'", outputID)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package publisher
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrorCategory identifies the source of an error reported to the error handler
+type ErrorCategory string
+
+// Categories of reported errors
+const (
+	ErrorCategoryCalibration  ErrorCategory = "calibration"  // invalid calibration or value that can't be calibrated
+	ErrorCategoryHandlerPanic ErrorCategory = "handlerPanic" // an application handler panicked
+	ErrorCategoryMessenger    ErrorCategory = "messenger"    // connection or publication failure of the message bus
+	ErrorCategoryPersistence  ErrorCategory = "persistence"  // failure to save configuration or cache files
+	ErrorCategorySignature    ErrorCategory = "signature"    // received message failed decryption or signature verification
+)
+
+// PublisherError is an error reported to the error handler
+type PublisherError struct {
+	Address   string        // address or file the error relates to, if known
+	Category  ErrorCategory // source of the error
+	Err       error         // the error
+	Timestamp time.Time     // time the error occurred
+}
+
+// Error returns the error description including its category
+func (pubErr PublisherError) Error() string {
+	if pubErr.Address == "" {
+		return fmt.Sprintf("%s: %s", pubErr.Category, pubErr.Err)
+	}
+	return fmt.Sprintf("%s: %s: %s", pubErr.Category, pubErr.Address, pubErr.Err)
+}
+
+// Unwrap returns the underlying error
+func (pubErr PublisherError) Unwrap() error {
+	return pubErr.Err
+}
+
+// SetErrorHandler sets the handler that is invoked with errors that are otherwise only logged, so the
+// application can react to them, for example alert on persistent decryption failures.
+// The handler must not block. Use nil to remove the handler.
+func (pub *Publisher) SetErrorHandler(handler func(err PublisherError)) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.errorHandler = handler
+}
+
+// reportError passes an error to the error handler, if set. Nil errors are ignored.
+// This must not be called with the update mutex locked.
+func (pub *Publisher) reportError(category ErrorCategory, address string, err error) {
+	if err == nil {
+		return
+	}
+	pub.updateMutex.Lock()
+	handler := pub.errorHandler
+	pub.updateMutex.Unlock()
+	if handler != nil {
+		handler(PublisherError{Address: address, Category: category, Err: err, Timestamp: lib.Now()})
+	}
+}
+
+// recoverHandler recovers from a panic in an application handler so the publisher keeps running.
+// The panic is reported to the error handler and the node of the handler, if known, gets an error
+// status. This must be deferred directly, eg: defer pub.recoverHandler("poll", "", "")
+//  name of the handler for logging
+//  nodeHWID of the node the handler belongs to, "" if not known
+//  address the handler was invoked for, "" if not applicable
+func (pub *Publisher) recoverHandler(name string, nodeHWID string, address string) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	err := fmt.Errorf("%s handler panicked: %v", name, recovered)
+	logrus.Errorf("recoverHandler: %s\n%s", err, debug.Stack())
+	if nodeHWID != "" {
+		pub.registeredNodes.UpdateErrorStatus(nodeHWID, types.NodeRunStateError, err.Error())
+	}
+	pub.reportError(ErrorCategoryHandlerPanic, address, err)
+}
+
+// recoverInputHandler returns the input handler wrapped to recover from a panic
+func (pub *Publisher) recoverInputHandler(nodeHWID string,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string),
+) func(input *types.InputDiscoveryMessage, sender string, value string) {
+	if handler == nil {
+		return nil
+	}
+	return func(input *types.InputDiscoveryMessage, sender string, value string) {
+		address := ""
+		if input != nil {
+			address = input.Address
+		}
+		defer pub.recoverHandler("input", nodeHWID, address)
+		handler(input, sender, value)
+	}
+}
+
+// recoverBeforePublishHook returns the hook wrapped to recover from a panic. A panic vetoes the publication.
+func (pub *Publisher) recoverBeforePublishHook(nodeHWID string, hook outputs.BeforePublishHook) outputs.BeforePublishHook {
+	if hook == nil {
+		return nil
+	}
+	return func(output *types.OutputDiscoveryMessage, value string) (newValue string, publish bool) {
+		defer pub.recoverHandler("beforePublish", nodeHWID, output.Address)
+		return hook(output, value)
+	}
+}
+
+// recoverAfterPublishHook returns the hook wrapped to recover from a panic
+func (pub *Publisher) recoverAfterPublishHook(nodeHWID string, hook outputs.AfterPublishHook) outputs.AfterPublishHook {
+	if hook == nil {
+		return nil
+	}
+	return func(output *types.OutputDiscoveryMessage, value string, err error) {
+		defer pub.recoverHandler("afterPublish", nodeHWID, output.Address)
+		hook(output, value, err)
+	}
+}
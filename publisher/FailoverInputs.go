@@ -0,0 +1,50 @@
+// Package publisher with inputs that are fed by multiple sources with failover
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// AddFailoverSource adds a source to a failover input and returns the handler to use for the input
+// that provides the source values, eg with CreateInputFromHTTP or CreateInputFromFile. A manual
+// override is an input created with CreateInput, a lower priority than the other sources, and a max
+// age after which the override expires.
+// Returns nil if the failover input doesn't exist.
+//  inputID is the ID of the input created with CreateFailoverInput
+//  name is the name of the source that is reported in the activeSource attribute
+//  priority of the source, the healthy source with the lowest priority is active
+//  maxAge is the nr of seconds after the last value that the source is considered failed, 0 to not expire
+func (pub *Publisher) AddFailoverSource(inputID string, name string, priority int, maxAge int) func(
+	input *types.InputDiscoveryMessage, sender string, value string) {
+
+	source := inputs.FailoverSource{Name: name, Priority: priority, MaxAge: maxAge}
+	return pub.inputFailover.AddSource(inputID, source)
+}
+
+// CreateFailoverInput creates an input that is fed by multiple sources with failover. Use
+// AddFailoverSource to add the sources. The handler receives the values of the active source, which
+// is the healthy source with the lowest priority. When a source expires or is reported as failed,
+// the input fails over to the next healthy source and its last value is passed to the handler.
+// The name of the active source is published in the input's activeSource attribute.
+func (pub *Publisher) CreateFailoverInput(
+	nodeHWID string, inputType types.InputType, instance string,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) *types.InputDiscoveryMessage {
+
+	input := pub.inputFailover.CreateInput(nodeHWID, inputType, instance, pub.wrapInputHandler(nodeHWID, handler))
+	pub.applyPseudonym(nodeHWID)
+	return input
+}
+
+// GetFailoverActiveSource returns the name of the active source of a failover input, or "" if none
+// of its sources is healthy
+func (pub *Publisher) GetFailoverActiveSource(inputID string) string {
+	return pub.inputFailover.GetActiveSource(inputID)
+}
+
+// SetFailoverSourceFailed reports a source of a failover input as failed, eg when its handler finds
+// that the upstream returned an error. The source is healthy again when it receives a new value.
+// Returns an error if the input or source doesn't exist.
+func (pub *Publisher) SetFailoverSourceFailed(inputID string, sourceName string) error {
+	return pub.inputFailover.SetSourceFailed(inputID, sourceName)
+}
@@ -0,0 +1,52 @@
+// Package publisher with output history retention from node configuration
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// applyNodeHistoryRetention updates the history retention policy of the outputs of a node with the
+// retention limits from the node configuration. This is applied when the node configuration is updated
+// instead of on each value update.
+func (pub *Publisher) applyNodeHistoryRetention(nodeHWID string) {
+	for _, output := range pub.registeredOutputs.GetOutputsByNodeHWID(nodeHWID) {
+		pub.applyOutputHistoryRetention(nodeHWID, output.OutputID)
+	}
+}
+
+// applyOutputHistoryRetention updates the history retention policy of an output with the retention
+// limits from its node configuration. Configured limits override the output's own or the default policy.
+func (pub *Publisher) applyOutputHistoryRetention(nodeHWID string, outputID string) {
+	pub.updateMutex.Lock()
+	retention, isOwnPolicy := pub.historyRetention[outputID]
+	pub.updateMutex.Unlock()
+	if !isOwnPolicy {
+		retention = outputs.DefaultHistoryRetention
+		if pub.config.HistoryRetention != nil {
+			retention = *pub.config.HistoryRetention
+		}
+	}
+
+	isConfigured := false
+	limits := []struct {
+		attrName types.NodeAttr
+		limit    *int
+	}{
+		{types.NodeAttrHistoryMaxCount, &retention.MaxCount},
+		{types.NodeAttrHistoryMaxAge, &retention.MaxAge},
+		{types.NodeAttrHistoryMaxBytes, &retention.MaxBytes},
+	}
+	for _, limit := range limits {
+		value, _ := pub.registeredNodes.GetNodeConfigInt(nodeHWID, limit.attrName, -1)
+		if value >= 0 {
+			*limit.limit = value
+			isConfigured = true
+		}
+	}
+	if isConfigured || isOwnPolicy {
+		pub.registeredOutputValues.SetRetention(outputID, &retention)
+	} else {
+		pub.registeredOutputValues.SetRetention(outputID, nil)
+	}
+}
@@ -0,0 +1,30 @@
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// SetDefaultInputHandler sets the handler of set commands for inputs that have no handler of their
+// own and no more specific handler set with SetInputHandler. Use nil to remove the handler.
+func (pub *Publisher) SetDefaultInputHandler(
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) {
+	pub.SetInputHandler("", "", "", handler)
+}
+
+// SetInputHandler sets the handler of set commands for inputs that were created without a handler,
+// so adapters don't need a single handler that switches on the node and input type.
+// An empty nodeHWID, inputType or instance matches any. When a set command is received the most
+// specific handler is used: node, type and instance; node and type; node; type; and last the
+// default handler. Use nil to remove the handler.
+func (pub *Publisher) SetInputHandler(nodeHWID string, inputType types.InputType, instance string,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) {
+
+	var routedHandler func(input *types.InputDiscoveryMessage, sender string, value string)
+	if handler != nil {
+		// the node is known when the handler is invoked
+		routedHandler = func(input *types.InputDiscoveryMessage, sender string, value string) {
+			pub.wrapInputHandler(input.NodeHWID, handler)(input, sender, value)
+		}
+	}
+	pub.registeredInputs.SetInputHandler(nodeHWID, inputType, instance, routedHandler)
+}
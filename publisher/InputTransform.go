@@ -0,0 +1,60 @@
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// SetInputTransform sets the transformation that is applied to received values of an input before
+// they are passed to its handler, eg to extract a field from a JSON response of an HTTP input.
+// The transformation is a configuration of the node so it can be changed with a $configure command.
+//  transform is the default transformation. Use nil for none.
+func (pub *Publisher) SetInputTransform(nodeHWID string, inputType types.InputType, instance string,
+	transform inputs.InputTransform) {
+
+	defaultTransform := ""
+	if len(transform) > 0 {
+		defaultTransform = transform.String()
+	}
+	pub.registeredNodes.UpdateNodeConfig(nodeHWID, inputs.MakeInputTransformAttr(inputType, instance), &types.ConfigAttr{
+		DataType:    types.DataTypeJSON,
+		Default:     defaultTransform,
+		Description: "Transformation of received input values, eg [{\"op\":\"json\",\"arg\":\"main.temp\"}]",
+	})
+}
+
+// transformInputValue applies the configured transformation of the input to the value
+func (pub *Publisher) transformInputValue(nodeHWID string, input *types.InputDiscoveryMessage, value string) (string, error) {
+	attrName := inputs.MakeInputTransformAttr(input.InputType, input.Instance)
+	encoded, _ := pub.registeredNodes.GetNodeConfigString(nodeHWID, attrName, "")
+	if encoded == "" {
+		return value, nil
+	}
+	transform, err := inputs.ParseInputTransform(encoded)
+	if err != nil {
+		return value, err
+	}
+	return transform.Apply(value)
+}
+
+// wrapInputHandler returns the input handler wrapped to transform received values and to recover
+// from a panic. Values that fail the transformation are not passed to the handler.
+func (pub *Publisher) wrapInputHandler(nodeHWID string,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string),
+) func(input *types.InputDiscoveryMessage, sender string, value string) {
+	if handler == nil {
+		return nil
+	}
+	return pub.recoverInputHandler(nodeHWID, func(input *types.InputDiscoveryMessage, sender string, value string) {
+		if input != nil {
+			transformed, err := pub.transformInputValue(nodeHWID, input, value)
+			if err != nil {
+				logrus.Warningf("wrapInputHandler: Value of input %s is discarded: %s", input.Address, err)
+				return
+			}
+			value = transformed
+		}
+		handler(input, sender, value)
+	})
+}
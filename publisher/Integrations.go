@@ -0,0 +1,164 @@
+// Package publisher with optional integrations that run along with the publisher
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Integration is an optional component with its own package that runs along with the publisher, eg
+// a bridge, exporter or monitor. See AddIntegration.
+type Integration interface {
+	// Start is invoked when the publisher starts, after it is connected
+	Start() error
+	// Stop is invoked when the publisher stops, before it disconnects
+	Stop()
+}
+
+// PublishObserver is an integration that is notified of the updates the publisher has published, eg
+// to mirror or export them. Observers are notified in the order they are added.
+type PublishObserver interface {
+	// DiscoveryPublished is invoked after updated nodes, inputs or outputs are published
+	DiscoveryPublished()
+	// OutputValuePublished is invoked after an output value is published, with the published history
+	OutputValuePublished(output *types.OutputDiscoveryMessage, value *types.OutputValue, history outputs.OutputHistory)
+	// ValuesPublished is invoked after each pass of publishing updated output values
+	ValuesPublished()
+}
+
+// CommandObserver is an integration that is notified of the outcome of received commands, eg to
+// audit them
+type CommandObserver interface {
+	// CommandReceived is invoked with the outcome of a received command
+	//  rejectErr is the reason the command is rejected, nil if accepted
+	CommandReceived(address string, sender string, isEncrypted bool, isSigned bool, rejectErr error)
+}
+
+// VerificationObserver is an integration that is notified of received messages that fail
+// decryption or signature verification, eg to count them.
+type VerificationObserver interface {
+	// VerificationFailed is invoked with the error of a message that fails verification
+	VerificationFailed(err error)
+}
+
+// AddIntegration attaches an integration that is started and stopped along with the publisher.
+// An integration that implements PublishObserver is also notified of published updates, one that
+// implements CommandObserver of received commands, and one that implements VerificationObserver
+// of received messages that fail verification.
+// If the publisher is already running the integration is started right away.
+func (pub *Publisher) AddIntegration(integration Integration) {
+	pub.updateMutex.Lock()
+	pub.integrations = append(pub.integrations, integration)
+	isRunning := pub.isRunning
+	pub.updateMutex.Unlock()
+	if isRunning {
+		pub.startIntegration(integration)
+	}
+}
+
+// getPublishObservers returns the integrations that observe publications
+func (pub *Publisher) getPublishObservers() []PublishObserver {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	observers := make([]PublishObserver, 0)
+	for _, integration := range pub.integrations {
+		if observer, ok := integration.(PublishObserver); ok {
+			observers = append(observers, observer)
+		}
+	}
+	return observers
+}
+
+// notifyCommandReceived notifies the observers of the outcome of a received command
+func (pub *Publisher) notifyCommandReceived(address string, sender string, isEncrypted bool, isSigned bool, rejectErr error) {
+	pub.updateMutex.Lock()
+	integrations := append([]Integration{}, pub.integrations...)
+	pub.updateMutex.Unlock()
+	for _, integration := range integrations {
+		if observer, ok := integration.(CommandObserver); ok {
+			func() {
+				defer pub.recoverHandler("commandReceived", "", address)
+				observer.CommandReceived(address, sender, isEncrypted, isSigned, rejectErr)
+			}()
+		}
+	}
+}
+
+// notifyDiscoveryPublished notifies the observers that updated discovery is published
+func (pub *Publisher) notifyDiscoveryPublished() {
+	for _, observer := range pub.getPublishObservers() {
+		func() {
+			defer pub.recoverHandler("discoveryPublished", "", "")
+			observer.DiscoveryPublished()
+		}()
+	}
+}
+
+// notifyOutputValuePublished notifies the observers that an output value is published
+func (pub *Publisher) notifyOutputValuePublished(observers []PublishObserver,
+	output *types.OutputDiscoveryMessage, value *types.OutputValue) {
+	if len(observers) == 0 {
+		return
+	}
+	history := pub.registeredOutputValues.GetPublishedHistory(output.OutputID)
+	for _, observer := range observers {
+		func() {
+			defer pub.recoverHandler("outputValuePublished", output.NodeHWID, output.Address)
+			observer.OutputValuePublished(output, value, history)
+		}()
+	}
+}
+
+// notifyValuesPublished notifies the observers that a pass of output values is published
+func (pub *Publisher) notifyValuesPublished(observers []PublishObserver) {
+	for _, observer := range observers {
+		func() {
+			defer pub.recoverHandler("valuesPublished", "", "")
+			observer.ValuesPublished()
+		}()
+	}
+}
+
+// notifyVerificationFailed notifies the observers that a received message failed verification
+func (pub *Publisher) notifyVerificationFailed(err error) {
+	pub.updateMutex.Lock()
+	integrations := append([]Integration{}, pub.integrations...)
+	pub.updateMutex.Unlock()
+	for _, integration := range integrations {
+		if observer, ok := integration.(VerificationObserver); ok {
+			func() {
+				defer pub.recoverHandler("verificationFailed", "", "")
+				observer.VerificationFailed(err)
+			}()
+		}
+	}
+}
+
+// startIntegration starts an integration and logs an error if it fails to start
+func (pub *Publisher) startIntegration(integration Integration) {
+	err := integration.Start()
+	if err != nil {
+		logrus.Errorf("Publisher.startIntegration: %s", err)
+	}
+}
+
+// startIntegrations starts the attached integrations
+func (pub *Publisher) startIntegrations() {
+	pub.updateMutex.Lock()
+	integrations := append([]Integration{}, pub.integrations...)
+	pub.updateMutex.Unlock()
+	for _, integration := range integrations {
+		pub.startIntegration(integration)
+	}
+}
+
+// stopIntegrations stops the attached integrations in reverse order of attaching them
+func (pub *Publisher) stopIntegrations() {
+	pub.updateMutex.Lock()
+	integrations := append([]Integration{}, pub.integrations...)
+	pub.updateMutex.Unlock()
+	for i := len(integrations) - 1; i >= 0; i-- {
+		integrations[i].Stop()
+	}
+}
@@ -0,0 +1,140 @@
+// Package publisher with nodes, inputs and outputs for internal use only
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// SetNodeLocalOnly sets whether a registered node is for internal use only, eg for bookkeeping of
+// an adapter. A local-only node, its inputs, outputs and output values are not published and the
+// node is not saved with the registered nodes. Its retained messages are removed from the message
+// bus when a published node becomes local-only.
+// Returns an error if the node doesn't exist.
+func (pub *Publisher) SetNodeLocalOnly(nodeHWID string, localOnly bool) error {
+	node := pub.registeredNodes.GetNodeByHWID(nodeHWID)
+	if !pub.registeredNodes.SetLocalOnly(nodeHWID, localOnly) {
+		return lib.MakeErrorf("SetNodeLocalOnly: Unknown node '%s'", nodeHWID)
+	}
+	if localOnly && !node.LocalOnly {
+		addresses := []string{node.Address, outputs.ReplaceMessageType(node.Address, types.MessageTypeEvent)}
+		for _, input := range pub.registeredInputs.GetInputsByNodeHWID(nodeHWID) {
+			addresses = append(addresses, input.Address)
+		}
+		for _, output := range pub.registeredOutputs.GetOutputsByNodeHWID(nodeHWID) {
+			addresses = append(addresses, getOutputRetainedAddresses(output)...)
+		}
+		pub.clearRetained(addresses)
+	} else if !localOnly && node.LocalOnly {
+		// the inputs and outputs of the node are published again as well
+		for _, input := range pub.registeredInputs.GetInputsByNodeHWID(nodeHWID) {
+			pub.registeredInputs.RepublishInput(input)
+		}
+		for _, output := range pub.registeredOutputs.GetOutputsByNodeHWID(nodeHWID) {
+			pub.registeredOutputs.RepublishOutput(output)
+		}
+	}
+	return nil
+}
+
+// SetInputLocalOnly sets whether a registered input is for internal use only. A local-only input
+// is not published. Its retained discovery is removed when a published input becomes local-only.
+// Returns an error if the input doesn't exist.
+func (pub *Publisher) SetInputLocalOnly(nodeHWID string, inputType types.InputType, instance string, localOnly bool) error {
+	inputID := inputs.MakeInputHWID(nodeHWID, inputType, instance)
+	input := pub.registeredInputs.GetInputByID(inputID)
+	if !pub.registeredInputs.SetLocalOnly(inputID, localOnly) {
+		return lib.MakeErrorf("SetInputLocalOnly: Unknown input '%s'", inputID)
+	}
+	if localOnly && !input.LocalOnly {
+		pub.clearRetained([]string{input.Address})
+	}
+	return nil
+}
+
+// SetOutputLocalOnly sets whether a registered output is for internal use only. A local-only output
+// and its values are not published. Its retained discovery and values are removed when a published
+// output becomes local-only.
+// Returns an error if the output doesn't exist.
+func (pub *Publisher) SetOutputLocalOnly(nodeHWID string, outputType types.OutputType, instance string, localOnly bool) error {
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	output := pub.registeredOutputs.GetOutputByID(outputID)
+	if output == nil {
+		return lib.MakeErrorf("SetOutputLocalOnly: Unknown output '%s'", outputID)
+	}
+	wasLocalOnly := output.LocalOnly
+	pub.registeredOutputs.SetLocalOnly(outputID, localOnly)
+	if localOnly && !wasLocalOnly {
+		pub.clearRetained(getOutputRetainedAddresses(output))
+	}
+	return nil
+}
+
+// clearRetained removes the retained messages of the addresses from the message bus, if running
+func (pub *Publisher) clearRetained(addresses []string) {
+	pub.updateMutex.Lock()
+	isRunning := pub.isRunning
+	pub.updateMutex.Unlock()
+	if !isRunning {
+		return
+	}
+	// an empty retained message removes the retained message from the broker
+	for _, address := range addresses {
+		err := pub.messenger.Publish(address, true, "")
+		pub.reportError(ErrorCategoryMessenger, address, err)
+	}
+}
+
+// filterLocalOnlyInputs returns the inputs that are not local-only and whose node is not local-only
+func (pub *Publisher) filterLocalOnlyInputs(inputList []*types.InputDiscoveryMessage) []*types.InputDiscoveryMessage {
+	filtered := make([]*types.InputDiscoveryMessage, 0, len(inputList))
+	for _, input := range inputList {
+		if !input.LocalOnly && !pub.isNodeLocalOnly(input.NodeHWID) {
+			filtered = append(filtered, input)
+		}
+	}
+	return filtered
+}
+
+// filterLocalOnlyNodes returns the nodes that are not local-only. Deleted nodes (nil) are retained.
+func (pub *Publisher) filterLocalOnlyNodes(nodeList []*types.NodeDiscoveryMessage) []*types.NodeDiscoveryMessage {
+	filtered := make([]*types.NodeDiscoveryMessage, 0, len(nodeList))
+	for _, node := range nodeList {
+		if node == nil || !node.LocalOnly {
+			filtered = append(filtered, node)
+		}
+	}
+	return filtered
+}
+
+// filterLocalOnlyOutputs returns the outputs that are not local-only and whose node is not local-only
+func (pub *Publisher) filterLocalOnlyOutputs(outputList []*types.OutputDiscoveryMessage) []*types.OutputDiscoveryMessage {
+	filtered := make([]*types.OutputDiscoveryMessage, 0, len(outputList))
+	for _, output := range outputList {
+		if !pub.isOutputLocalOnly(output) {
+			filtered = append(filtered, output)
+		}
+	}
+	return filtered
+}
+
+// isNodeLocalOnly returns true if the registered node is local-only
+func (pub *Publisher) isNodeLocalOnly(nodeHWID string) bool {
+	node := pub.registeredNodes.GetNodeByHWID(nodeHWID)
+	return node != nil && node.LocalOnly
+}
+
+// isOutputLocalOnly returns true if the output or its node is local-only
+func (pub *Publisher) isOutputLocalOnly(output *types.OutputDiscoveryMessage) bool {
+	return output.LocalOnly || pub.isNodeLocalOnly(output.NodeHWID)
+}
+
+// getOutputRetainedAddresses returns the addresses of the retained discovery and values of an output
+func getOutputRetainedAddresses(output *types.OutputDiscoveryMessage) []string {
+	return []string{output.Address,
+		outputs.ReplaceMessageType(output.Address, types.MessageTypeForecast),
+		outputs.ReplaceMessageType(output.Address, types.MessageTypeHistory),
+		outputs.ReplaceMessageType(output.Address, types.MessageTypeLatest)}
+}
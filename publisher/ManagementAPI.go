@@ -0,0 +1,223 @@
+package publisher
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Management API paths
+const (
+	ManagementPathIdentity = "/identity" // GET identity status
+	ManagementPathLoglevel = "/loglevel" // GET or PUT the log level
+	ManagementPathNodes    = "/nodes"    // GET registered nodes, PUT /nodes/{hwID}/config to configure a node
+	ManagementPathValues   = "/values"   // GET current output values
+)
+
+// ManagementIdentityStatus is the response of the identity status request
+type ManagementIdentityStatus struct {
+	Address     string `json:"address"`     // identity address
+	Domain      string `json:"domain"`      // domain of the publisher
+	IssuerID    string `json:"issuerId"`    // issuer of the identity
+	PublisherID string `json:"publisherId"` // ID of the publisher
+	Running     bool   `json:"running"`     // the publisher is running
+	Signed      bool   `json:"signed"`      // the identity is signed by its issuer
+	ValidUntil  string `json:"validUntil"`  // time the identity expires
+}
+
+// ManagementLoglevel is the request and response of the log level
+type ManagementLoglevel struct {
+	Loglevel string `json:"loglevel"` // error, warning, info, debug
+}
+
+// ManagementOutputValue is an output value in the response of the values request
+type ManagementOutputValue struct {
+	Address   string `json:"address"`   // output discovery address
+	NodeHWID  string `json:"hwID"`      // hardware ID of the node of the output
+	OutputID  string `json:"outputId"`  // ID of the output
+	Timestamp string `json:"timestamp"` // time the value was measured, "" if no value
+	Value     string `json:"value"`     // current value
+}
+
+// ManagementAPI is an optional HTTP/JSON endpoint for local management of a running publisher. It lets
+// fleet management tools list nodes and values, configure nodes, view the identity status and change
+// the log level without going through the message bus. As it bypasses message signing, listen on a
+// local address and set a token. Attach the endpoint to its publisher with AddIntegration.
+type ManagementAPI struct {
+	address     string       // address to listen on, eg localhost:9678
+	listener    net.Listener // listener of the running endpoint, nil if not listening
+	pub         *Publisher   // the managed publisher
+	server      *http.Server // server of the running endpoint
+	token       string       // bearer token required in requests, "" to not require a token
+	updateMutex *sync.Mutex  // mutex for concurrent start and stop
+}
+
+// Address returns the address the endpoint listens on, "" if not listening
+func (api *ManagementAPI) Address() string {
+	api.updateMutex.Lock()
+	defer api.updateMutex.Unlock()
+	if api.listener == nil {
+		return ""
+	}
+	return api.listener.Addr().String()
+}
+
+// ServeHTTP handles a management request
+func (api *ManagementAPI) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if api.token != "" {
+		auth := request.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+api.token)) != 1 {
+			http.Error(response, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	logrus.Infof("ManagementAPI.ServeHTTP: %s %s", request.Method, request.URL.Path)
+	path := strings.TrimSuffix(request.URL.Path, "/")
+	switch {
+	case path == ManagementPathIdentity && request.Method == http.MethodGet:
+		api.writeJSON(response, api.getIdentityStatus())
+	case path == ManagementPathLoglevel && request.Method == http.MethodGet:
+		api.writeJSON(response, &ManagementLoglevel{Loglevel: logrus.GetLevel().String()})
+	case path == ManagementPathLoglevel && request.Method == http.MethodPut:
+		api.setLoglevel(response, request)
+	case path == ManagementPathNodes && request.Method == http.MethodGet:
+		api.writeJSON(response, api.pub.GetNodes())
+	case strings.HasPrefix(path, ManagementPathNodes+"/") && strings.HasSuffix(path, "/config") &&
+		request.Method == http.MethodPut:
+		nodeHWID := strings.TrimSuffix(strings.TrimPrefix(path, ManagementPathNodes+"/"), "/config")
+		api.configureNode(response, request, nodeHWID)
+	case path == ManagementPathValues && request.Method == http.MethodGet:
+		api.writeJSON(response, api.getOutputValues())
+	default:
+		http.Error(response, "not found", http.StatusNotFound)
+	}
+}
+
+// Start listening for management requests
+func (api *ManagementAPI) Start() error {
+	api.updateMutex.Lock()
+	defer api.updateMutex.Unlock()
+	if api.listener != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", api.address)
+	if err != nil {
+		return lib.MakeErrorf("ManagementAPI.Start: Unable to listen on %s: %s", api.address, err)
+	}
+	api.listener = listener
+	api.server = &http.Server{Handler: api}
+	go api.server.Serve(listener)
+	logrus.Infof("ManagementAPI.Start: Listening for management requests on %s", listener.Addr())
+	return nil
+}
+
+// Stop listening for management requests
+func (api *ManagementAPI) Stop() {
+	api.updateMutex.Lock()
+	defer api.updateMutex.Unlock()
+	if api.server != nil {
+		api.server.Close()
+	}
+	api.server = nil
+	api.listener = nil
+}
+
+// configureNode applies the configuration in the request body to a registered node
+func (api *ManagementAPI) configureNode(response http.ResponseWriter, request *http.Request, nodeHWID string) {
+	params := types.NodeAttrMap{}
+	err := json.NewDecoder(request.Body).Decode(&params)
+	if err != nil {
+		http.Error(response, "invalid configuration: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = api.pub.receiveNodeConfigure.ConfigureNode(nodeHWID, params)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusNotFound)
+		return
+	}
+	api.writeJSON(response, api.pub.GetNodeByHWID(nodeHWID))
+}
+
+// getIdentityStatus returns the status of the publisher identity
+func (api *ManagementAPI) getIdentityStatus() *ManagementIdentityStatus {
+	ident := api.pub.GetIdentity()
+	api.pub.updateMutex.Lock()
+	isRunning := api.pub.isRunning
+	api.pub.updateMutex.Unlock()
+	return &ManagementIdentityStatus{
+		Address:     ident.Address,
+		Domain:      ident.Domain,
+		IssuerID:    ident.IssuerID,
+		PublisherID: ident.PublisherID,
+		Running:     isRunning,
+		Signed:      ident.IdentitySignature != "",
+		ValidUntil:  ident.ValidUntil,
+	}
+}
+
+// getOutputValues returns the current values of the registered outputs
+func (api *ManagementAPI) getOutputValues() []ManagementOutputValue {
+	values := make([]ManagementOutputValue, 0)
+	for _, output := range api.pub.GetOutputs() {
+		value := ManagementOutputValue{
+			Address:  output.Address,
+			NodeHWID: output.NodeHWID,
+			OutputID: output.OutputID,
+		}
+		outputValue := api.pub.GetOutputValueByID(output.OutputID)
+		if outputValue != nil {
+			value.Timestamp = outputValue.Timestamp
+			value.Value = outputValue.Value
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// setLoglevel changes the log level to the level in the request body
+func (api *ManagementAPI) setLoglevel(response http.ResponseWriter, request *http.Request) {
+	var loglevel ManagementLoglevel
+	err := json.NewDecoder(request.Body).Decode(&loglevel)
+	if err != nil {
+		http.Error(response, "invalid log level: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	level, err := logrus.ParseLevel(loglevel.Loglevel)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logrus.Warningf("ManagementAPI.setLoglevel: Log level changed to %s", level)
+	logrus.SetLevel(level)
+	api.writeJSON(response, &ManagementLoglevel{Loglevel: level.String()})
+}
+
+// writeJSON writes the object as the JSON response
+func (api *ManagementAPI) writeJSON(response http.ResponseWriter, object interface{}) {
+	response.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(response).Encode(object)
+	if err != nil {
+		logrus.Errorf("ManagementAPI.writeJSON: Failed writing response: %s", err)
+	}
+}
+
+// NewManagementAPI creates the management endpoint of a publisher
+//  pub is the publisher to manage
+//  address is the address to listen on, eg localhost:9678
+//  token is the bearer token required in requests, "" to not require a token
+func NewManagementAPI(pub *Publisher, address string, token string) *ManagementAPI {
+	api := &ManagementAPI{
+		address:     address,
+		pub:         pub,
+		token:       token,
+		updateMutex: &sync.Mutex{},
+	}
+	return api
+}
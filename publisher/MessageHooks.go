@@ -0,0 +1,28 @@
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/messaging"
+)
+
+// UsePublishHook adds a hook that inspects, modifies or vetoes messages before they are signed
+// and published, eg to filter, enrich, audit or count publications. Hooks are invoked in the
+// order they are added. A hook that panics is reported and the message is passed on unchanged.
+func (pub *Publisher) UsePublishHook(hook messaging.PublishHook) {
+	pub.messageSigner.AddPublishHook(func(address string, object interface{}) (newObject interface{}, publish bool) {
+		newObject, publish = object, true
+		defer pub.recoverHandler("publish hook", "", address)
+		return hook(address, object)
+	})
+}
+
+// UseReceiveHook adds a hook that inspects, modifies or vetoes received messages after their
+// signature is verified and before they are passed to their handler. This applies to commands,
+// discovery of nodes, inputs and outputs, and other domain messages received by the publisher.
+// A hook that panics is reported and the message is accepted.
+func (pub *Publisher) UseReceiveHook(hook messaging.ReceiveHook) {
+	pub.messageSigner.AddReceiveHook(func(address string, object interface{}) (accept bool) {
+		accept = true
+		defer pub.recoverHandler("receive hook", "", address)
+		return hook(address, object)
+	})
+}
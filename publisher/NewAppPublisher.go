@@ -27,6 +27,11 @@ func NewAppPublisher(appID string, configFolder string, appConfig interface{},
 	// 1: load messenger config shared with other publishers
 	var messengerConfig = messaging.MessengerConfig{}
 	err := lib.LoadMessengerConfig(configFolder, &messengerConfig)
+	if configFolder == "" {
+		messaging.ResolveTLSFiles(&messengerConfig, lib.DefaultConfigFolder)
+	} else {
+		messaging.ResolveTLSFiles(&messengerConfig, configFolder)
+	}
 	messenger := messaging.NewMessenger(&messengerConfig)
 
 	// 2: load Publisher config fields from appconfig
@@ -0,0 +1,89 @@
+// Package publisher with typed updates of common node attributes and the node location
+package publisher
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// SetNodeFirmwareVersion sets the firmware version attribute of a registered node. The version
+// must be a semantic version, eg 1.2.3 or v1.2.3-rc.1. The node is published if the version changes.
+// Returns an error if the node doesn't exist or the version is invalid.
+func (pub *Publisher) SetNodeFirmwareVersion(nodeHWID string, version string) error {
+	if err := types.ValidateVersion(version); err != nil {
+		return lib.MakeErrorf("SetNodeFirmwareVersion: Node '%s': %s", nodeHWID, err)
+	}
+	return pub.setNodeAttr("SetNodeFirmwareVersion", nodeHWID, types.NodeAttrMap{types.NodeAttrFirmwareVersion: version})
+}
+
+// SetNodeLocationLatLon sets the latlon attribute of a registered node for display on a map. The
+// node is published if the location changes.
+// Returns an error if the node doesn't exist or the latitude or longitude is out of range.
+func (pub *Publisher) SetNodeLocationLatLon(nodeHWID string, latitude float64, longitude float64) error {
+	if err := types.ValidateLatLon(latitude, longitude); err != nil {
+		return lib.MakeErrorf("SetNodeLocationLatLon: Node '%s': %s", nodeHWID, err)
+	}
+	latLon := types.FormatLatLon(latitude, longitude)
+	return pub.setNodeAttr("SetNodeLocationLatLon", nodeHWID, types.NodeAttrMap{types.NodeAttrLatLon: latLon})
+}
+
+// SetNodeLocation sets the latlon and altitude attributes of a registered node, eg of a vehicle. The
+// node is published with its location if the location changes.
+//  altitude is the altitude in meters above sea level
+// Returns an error if the node doesn't exist or the latitude or longitude is out of range.
+func (pub *Publisher) SetNodeLocation(nodeHWID string, latitude float64, longitude float64, altitude float64) error {
+	if err := types.ValidateLatLon(latitude, longitude); err != nil {
+		return lib.MakeErrorf("SetNodeLocation: Node '%s': %s", nodeHWID, err)
+	}
+	return pub.setNodeAttr("SetNodeLocation", nodeHWID, types.NodeAttrMap{
+		types.NodeAttrAltitude: strconv.FormatFloat(altitude, 'f', -1, 64),
+		types.NodeAttrLatLon:   types.FormatLatLon(latitude, longitude),
+	})
+}
+
+// SetNodeManufacturer sets the manufacturer attribute of a registered node. The node is published
+// if the manufacturer changes.
+// Returns an error if the node doesn't exist or the manufacturer is empty or has control characters.
+func (pub *Publisher) SetNodeManufacturer(nodeHWID string, manufacturer string) error {
+	if err := validateAttrText(manufacturer); err != nil {
+		return lib.MakeErrorf("SetNodeManufacturer: Node '%s': %s", nodeHWID, err)
+	}
+	return pub.setNodeAttr("SetNodeManufacturer", nodeHWID, types.NodeAttrMap{types.NodeAttrManufacturer: manufacturer})
+}
+
+// SetNodeName sets the name attribute of a registered node, eg the name a user gave the device. The
+// node is published if the name changes.
+// Returns an error if the node doesn't exist or the name is empty or has control characters.
+func (pub *Publisher) SetNodeName(nodeHWID string, name string) error {
+	if err := validateAttrText(name); err != nil {
+		return lib.MakeErrorf("SetNodeName: Node '%s': %s", nodeHWID, err)
+	}
+	return pub.setNodeAttr("SetNodeName", nodeHWID, types.NodeAttrMap{types.NodeAttrName: name})
+}
+
+// setNodeAttr updates attributes of a registered node
+// Returns an error if the node doesn't exist.
+func (pub *Publisher) setNodeAttr(caller string, nodeHWID string, attrs types.NodeAttrMap) error {
+	if pub.registeredNodes.GetNodeByHWID(nodeHWID) == nil {
+		return lib.MakeErrorf("%s: Unknown node '%s'", caller, nodeHWID)
+	}
+	pub.registeredNodes.UpdateNodeAttr(nodeHWID, attrs)
+	return nil
+}
+
+// validateAttrText returns an error if a text attribute value is empty or has control characters
+func validateAttrText(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("value is empty")
+	}
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return fmt.Errorf("value '%s' has control characters", value)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Package publisher with notification of changes to node configuration values
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// NodeConfigWatcher is invoked with the old and new value of a changed node configuration value
+type NodeConfigWatcher func(oldValue string, newValue string)
+
+// HandleNodeConfigChanged invokes the handlers that watch a node configuration value after a
+// configure command changed it
+func (pub *Publisher) HandleNodeConfigChanged(nodeHWID string, attrName types.NodeAttr, oldValue string, newValue string) {
+	pub.updateMutex.Lock()
+	watchers := pub.nodeConfigWatchers[nodeHWID+"/"+string(attrName)]
+	pub.updateMutex.Unlock()
+
+	logrus.Infof("Publisher.HandleNodeConfigChanged: Node '%s' configuration '%s' changed from '%s' to '%s'",
+		nodeHWID, attrName, oldValue, newValue)
+	if attrName == types.NodeAttrPollInterval {
+		pub.resetNodePollInterval(nodeHWID)
+	}
+	for _, handler := range watchers {
+		pub.invokeNodeConfigWatcher(handler, nodeHWID, oldValue, newValue)
+	}
+}
+
+// OnNodeConfigChanged adds a handler that is invoked when a configuration value of a node is changed
+// by a configure command, after the configuration is applied. Adapters can use this to reopen a
+// connection or reauthenticate when its settings change, instead of polling the configuration.
+// Use a nil handler to remove the handlers of the configuration value.
+//  nodeHWID is the hardware ID of the registered node
+//  attrName is the configuration attribute to watch
+//  handler is invoked with the old and new configuration value
+func (pub *Publisher) OnNodeConfigChanged(nodeHWID string, attrName types.NodeAttr, handler NodeConfigWatcher) {
+	key := nodeHWID + "/" + string(attrName)
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	if handler == nil {
+		delete(pub.nodeConfigWatchers, key)
+		return
+	}
+	pub.nodeConfigWatchers[key] = append(pub.nodeConfigWatchers[key], handler)
+}
+
+// invokeNodeConfigWatcher invokes a watcher and recovers from a panic in the handler
+func (pub *Publisher) invokeNodeConfigWatcher(handler NodeConfigWatcher, nodeHWID string, oldValue string, newValue string) {
+	defer pub.recoverHandler("nodeConfigChanged", nodeHWID, "")
+	handler(oldValue, newValue)
+}
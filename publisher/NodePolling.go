@@ -0,0 +1,189 @@
+// Package publisher with polling of nodes at their own interval
+package publisher
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// NodePollHandler polls the values of a node
+type NodePollHandler func(pub *Publisher, nodeHWID string)
+
+// nodePoller polls a node in its own loop so slow nodes don't delay the polling of other nodes
+type nodePoller struct {
+	done     chan bool        // closed when the poll loop has ended
+	handler  NodePollHandler  // handler that polls the node
+	previous chan bool        // done channel of the replaced poll loop, nil if none
+	reset    chan *lib.Ticker // ticker of the new poll interval for the poll loop
+	stop     chan bool        // closed to end the poll loop, nil when not running
+}
+
+// SetNodePollInterval sets the handler that polls a node at its own interval, independent of the
+// publisher poll interval and the polling of other nodes. The interval is a node configuration so
+// it can be changed with a configure command. The handler is invoked right after the publisher
+// starts and then each interval. Updates made by the handler are published with the next heartbeat.
+// This can be called from within the poll handler. A poll in progress is completed before the
+// replacing handler is invoked.
+//  nodeHWID is the hardware ID of the registered node to poll
+//  seconds is the default poll interval. Default (0) is DefaultPollInterval
+//  handler polls the node. Use nil to stop polling the node.
+func (pub *Publisher) SetNodePollInterval(nodeHWID string, seconds int, handler NodePollHandler) {
+	if seconds <= 0 {
+		seconds = DefaultPollInterval
+	}
+	logrus.Infof("Publisher.SetNodePollInterval: node %s interval = %d seconds", nodeHWID, seconds)
+	pub.registeredNodes.UpdateNodeConfig(nodeHWID, types.NodeAttrPollInterval, &types.ConfigAttr{
+		DataType:    types.DataTypeInt,
+		Default:     strconv.Itoa(seconds),
+		Description: "Interval in seconds to poll the node",
+	})
+
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	var previous chan bool
+	if oldPoller := pub.nodePollers[nodeHWID]; oldPoller != nil {
+		// don't wait for the old loop as this can be called from its poll handler
+		if oldPoller.stop != nil {
+			close(oldPoller.stop)
+			previous = oldPoller.done
+		}
+		oldPoller.stop = nil
+		delete(pub.nodePollers, nodeHWID)
+	}
+	if handler != nil {
+		poller := &nodePoller{handler: handler, previous: previous}
+		pub.nodePollers[nodeHWID] = poller
+		if pub.isRunning {
+			pub.startNodePoller(nodeHWID, poller)
+		}
+	}
+}
+
+// getNodePollInterval returns the poll interval of a node from its configuration
+func (pub *Publisher) getNodePollInterval(nodeHWID string) time.Duration {
+	seconds, _ := pub.registeredNodes.GetNodeConfigInt(nodeHWID, types.NodeAttrPollInterval, DefaultPollInterval)
+	if seconds <= 0 {
+		seconds = DefaultPollInterval
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// nodePollLoop polls a node each interval until the poller is stopped. The interval is read from the
+// node configuration when the loop starts and when the poll interval configuration changes.
+// The loop doesn't start polling until the loop it replaces has ended, so stopping the loop also
+// waits for a poll in progress of the replaced loop.
+func (pub *Publisher) nodePollLoop(nodeHWID string, poller nodePoller) {
+	defer close(poller.done)
+	if poller.previous != nil {
+		<-poller.previous
+		select {
+		case <-poller.stop:
+			return
+		default:
+		}
+	}
+	ticker := lib.NewTicker(pub.getNodePollInterval(nodeHWID))
+	for {
+		pub.pollNode(nodeHWID, poller.handler)
+		ticker = pub.waitNodePollInterval(poller, ticker)
+		if ticker == nil {
+			return
+		}
+	}
+}
+
+// resetNodePollInterval passes a ticker with the new poll interval of a node to its poll loop. The
+// new interval starts now, not when the poll loop picks up the ticker.
+func (pub *Publisher) resetNodePollInterval(nodeHWID string) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	poller := pub.nodePollers[nodeHWID]
+	if poller == nil || poller.reset == nil {
+		return
+	}
+	// a reset that is still pending is replaced
+	select {
+	case pending := <-poller.reset:
+		pending.Stop()
+	default:
+	}
+	poller.reset <- lib.NewTicker(pub.getNodePollInterval(nodeHWID))
+}
+
+// waitNodePollInterval waits for the next tick of the poll interval of a node. The ticker is replaced
+// when the interval is reset.
+// This returns the ticker of the poll interval, or nil if the poller is stopped.
+func (pub *Publisher) waitNodePollInterval(poller nodePoller, ticker *lib.Ticker) *lib.Ticker {
+	for {
+		select {
+		case <-poller.stop:
+			ticker.Stop()
+			select {
+			case pending := <-poller.reset:
+				pending.Stop()
+			default:
+			}
+			return nil
+		case newTicker := <-poller.reset:
+			ticker.Stop()
+			ticker = newTicker
+		case <-ticker.C:
+			return ticker
+		}
+	}
+}
+
+// pollNode invokes the poll handler of a node and recovers from a panic in the handler
+func (pub *Publisher) pollNode(nodeHWID string, handler NodePollHandler) {
+	defer pub.recoverHandler("nodePoll", nodeHWID, "")
+	handler(pub, nodeHWID)
+}
+
+// startNodePoller starts the poll loop of a node.
+// For internal use only. Use within locked section.
+func (pub *Publisher) startNodePoller(nodeHWID string, poller *nodePoller) {
+	poller.stop = make(chan bool)
+	poller.done = make(chan bool)
+	poller.reset = make(chan *lib.Ticker, 1)
+	go pub.nodePollLoop(nodeHWID, *poller)
+}
+
+// startNodePollers starts the poll loops of all nodes with a poll handler that aren't running.
+// For internal use only. Use within locked section.
+func (pub *Publisher) startNodePollers() {
+	for nodeHWID, poller := range pub.nodePollers {
+		if poller.stop == nil {
+			pub.startNodePoller(nodeHWID, poller)
+		}
+	}
+}
+
+// stopNodePollers stops the poll loops of all nodes and waits until polls in progress have finished
+func (pub *Publisher) stopNodePollers() {
+	pub.updateMutex.Lock()
+	stoppedPollers := make([]nodePoller, 0, len(pub.nodePollers))
+	for _, poller := range pub.nodePollers {
+		stoppedPollers = append(stoppedPollers, *poller)
+		poller.previous = nil
+		poller.reset = nil
+		poller.stop = nil
+	}
+	pub.updateMutex.Unlock()
+	for _, stoppedPoller := range stoppedPollers {
+		waitNodePoller(stoppedPoller)
+	}
+}
+
+// waitNodePoller ends the poll loop of a stopped poller, if it was running, and waits until it has
+// ended. A poll in progress is completed first.
+func waitNodePoller(stoppedPoller nodePoller) {
+	if stoppedPoller.stop == nil {
+		return
+	}
+	close(stoppedPoller.stop)
+	<-stoppedPoller.done
+}
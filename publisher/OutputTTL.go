@@ -0,0 +1,25 @@
+// Package publisher with the time to live of output values
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// SetOutputValueTTL sets how long values of a registered output remain valid after they are
+// measured, eg 900 seconds for an outdoor temperature. The TTL is published in the output
+// discovery and the latest value includes the time it is valid until. Unchanged values are
+// republished before they expire. Use 0 for values that don't expire.
+// Returns an error if the output doesn't exist.
+func (pub *Publisher) SetOutputValueTTL(nodeHWID string, outputType types.OutputType, instance string, seconds int) error {
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	if seconds < 0 {
+		return lib.MakeErrorf("SetOutputValueTTL: Invalid TTL %d of output '%s'", seconds, outputID)
+	}
+	if !pub.registeredOutputs.SetValueTTL(outputID, seconds) {
+		return lib.MakeErrorf("SetOutputValueTTL: Unknown output '%s'", outputID)
+	}
+	pub.registeredOutputValues.SetValueTTL(outputID, seconds)
+	return nil
+}
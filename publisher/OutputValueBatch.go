@@ -0,0 +1,35 @@
+// Package publisher with batch updates of output values
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// OutputKey identifies a registered output by its node hardware ID, output type and instance
+type OutputKey struct {
+	NodeHWID   string
+	OutputType types.OutputType
+	Instance   string
+}
+
+// UpdateOutputValues updates a batch of registered output values, eg a burst of reports received from
+// a device network. The batch is applied atomically, so the updated values are published together by
+// the next publication pass instead of one at a time. Calibration and history retention are applied
+// as with UpdateOutputValue. Invalid enum values are skipped.
+//  values contains the new values by output key
+// returns the nr of output values that are updated
+func (pub *Publisher) UpdateOutputValues(values map[OutputKey]string) int {
+	outputValues := make(map[string]string, len(values))
+	for key, newValue := range values {
+		outputID := outputs.MakeOutputID(key.NodeHWID, key.OutputType, key.Instance)
+		pub.markNodeSeen(key.NodeHWID)
+		if pub.registeredOutputs.ValidateValue(outputID, newValue) != nil {
+			continue
+		}
+		outputValues[outputID] = pub.calibrateValue(outputID, newValue)
+	}
+	updatedIDs := pub.registeredOutputValues.UpdateOutputValues(outputValues, lib.Now())
+	return len(updatedIDs)
+}
@@ -0,0 +1,165 @@
+package publisher
+
+import (
+	"sort"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PairDefaultTimeout is the default duration of inclusion mode in seconds
+const PairDefaultTimeout = 60
+
+// AddPairCandidate adds a device that is discovered in inclusion mode, so a UI can offer it for
+// adoption. The pairing status is republished with the new candidate.
+func (pub *Publisher) AddPairCandidate(candidate types.PairCandidate) {
+	if candidate.Discovered == "" {
+		candidate.Discovered = lib.Now().Format(types.TimeFormat)
+	}
+	pub.updateMutex.Lock()
+	pub.pairCandidates[candidate.CandidateID] = candidate
+	pub.updateMutex.Unlock()
+	pub.publishPairStatus()
+}
+
+// GetPairCandidates returns the discovered devices that await adoption, sorted by candidate ID
+func (pub *Publisher) GetPairCandidates() []types.PairCandidate {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	candidates := make([]types.PairCandidate, 0, len(pub.pairCandidates))
+	for _, candidate := range pub.pairCandidates {
+		candidates = append(candidates, candidate)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].CandidateID < candidates[j].CandidateID
+	})
+	return candidates
+}
+
+// HandlePairCommand handles a command to control the inclusion mode of this publisher.
+//  Start enables inclusion mode until the timeout and clears the previous candidates.
+//  Stop ends inclusion mode. Candidates remain available for adoption.
+//  Adopt and reject remove the candidate after the handler is invoked. Unknown candidates are ignored.
+// The pairing handler is invoked with the command and the pairing status is republished.
+func (pub *Publisher) HandlePairCommand(message *types.PairMessage) {
+	pub.updateMutex.Lock()
+	handler := pub.pairHandler
+	switch message.Action {
+	case types.PairActionStart:
+		timeout := message.Timeout
+		if timeout <= 0 {
+			timeout = PairDefaultTimeout
+		}
+		if pub.pairTimer != nil {
+			pub.pairTimer.Stop()
+		}
+		pub.pairCandidates = make(map[string]types.PairCandidate)
+		pub.pairUntil = lib.Now().Add(time.Duration(timeout) * time.Second)
+		pub.pairTimer = time.AfterFunc(time.Duration(timeout)*time.Second, pub.stopPairing)
+	case types.PairActionStop:
+		if pub.pairTimer != nil {
+			pub.pairTimer.Stop()
+			pub.pairTimer = nil
+		}
+		pub.pairUntil = time.Time{}
+	case types.PairActionAdopt, types.PairActionReject:
+		if _, found := pub.pairCandidates[message.CandidateID]; !found {
+			pub.updateMutex.Unlock()
+			logrus.Warningf("HandlePairCommand: Unknown candidate '%s' to %s. Command ignored.",
+				message.CandidateID, message.Action)
+			return
+		}
+	}
+	pub.updateMutex.Unlock()
+
+	if handler != nil {
+		pub.invokePairHandler(handler, message)
+	}
+	if message.Action == types.PairActionAdopt || message.Action == types.PairActionReject {
+		pub.updateMutex.Lock()
+		delete(pub.pairCandidates, message.CandidateID)
+		pub.updateMutex.Unlock()
+	}
+	pub.publishPairStatus()
+}
+
+// IsPairing returns true when inclusion mode is active
+func (pub *Publisher) IsPairing() bool {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	return !pub.pairUntil.IsZero()
+}
+
+// PublishPairCommand publishes a command to control the inclusion mode of a publisher in the domain
+//  publisherID of the publisher whose inclusion mode to control
+//  action is one of types.PairActionStart, Stop, Adopt or Reject
+//  candidateID is the candidate to adopt or reject, "" for start and stop
+//  timeout is the inclusion mode duration in seconds for start, 0 for the publisher's default
+func (pub *Publisher) PublishPairCommand(publisherID string, action string, candidateID string, timeout int) error {
+	return nodes.PublishPairCommand(pub.Domain(), publisherID, action, candidateID, timeout,
+		pub.Address(), pub.messageSigner)
+}
+
+// RemovePairCandidate removes a discovered device, for example when it left the network.
+// The pairing status is republished.
+func (pub *Publisher) RemovePairCandidate(candidateID string) {
+	pub.updateMutex.Lock()
+	_, found := pub.pairCandidates[candidateID]
+	delete(pub.pairCandidates, candidateID)
+	pub.updateMutex.Unlock()
+	if found {
+		pub.publishPairStatus()
+	}
+}
+
+// SetPairHandler sets the handler of pairing commands. The adapter starts or stops inclusion mode
+// of its network, adds discovered devices with AddPairCandidate, and creates the node of an adopted
+// candidate. Use nil to remove the handler.
+func (pub *Publisher) SetPairHandler(handler func(message *types.PairMessage)) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.pairHandler = handler
+}
+
+// invokePairHandler invokes the pairing handler and recovers from a panic in the handler
+func (pub *Publisher) invokePairHandler(handler func(message *types.PairMessage), message *types.PairMessage) {
+	defer pub.recoverHandler("pair", "", message.Address)
+	handler(message)
+}
+
+// publishPairStatus publishes the inclusion mode and candidates of this publisher when running
+func (pub *Publisher) publishPairStatus() {
+	candidates := pub.GetPairCandidates()
+	pub.updateMutex.Lock()
+	isRunning := pub.isRunning
+	until := pub.pairUntil
+	pub.updateMutex.Unlock()
+	if !isRunning {
+		return
+	}
+	err := nodes.PublishPairStatus(pub.Domain(), pub.PublisherID(), !until.IsZero(), until,
+		candidates, pub.messageSigner)
+	if err != nil {
+		logrus.Warningf("publishPairStatus: %s", err)
+	}
+}
+
+// stopPairing ends inclusion mode when its timeout has passed, as if a stop command was received
+func (pub *Publisher) stopPairing() {
+	pub.updateMutex.Lock()
+	isExpired := !pub.pairUntil.IsZero() && !lib.Now().Before(pub.pairUntil)
+	pub.updateMutex.Unlock()
+	// inclusion mode was restarted or stopped after the timer fired
+	if !isExpired {
+		return
+	}
+	pub.HandlePairCommand(&types.PairMessage{
+		Action:    types.PairActionStop,
+		Address:   nodes.MakePairAddress(pub.Domain(), pub.PublisherID()),
+		Sender:    pub.Address(),
+		Timestamp: lib.Now().Format(types.TimeFormat),
+	})
+}
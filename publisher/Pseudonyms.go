@@ -0,0 +1,98 @@
+package publisher
+
+import (
+	"crypto/ecdsa"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ResolvePseudonym returns the original value of a pseudonym used by this publisher in pseudonymous mode.
+// This returns false if pseudonymous mode is disabled or the pseudonym is not known.
+func (pub *Publisher) ResolvePseudonym(pseudonym string) (value string, exists bool) {
+	if pub.pseudonyms == nil {
+		return "", false
+	}
+	return pub.pseudonyms.Resolve(pseudonym)
+}
+
+// applyPseudonym sets the node ID of the node, its inputs and outputs to the pseudonym of its hardware ID.
+// This does nothing if pseudonymous mode is disabled. Returns true if the node ID was changed.
+func (pub *Publisher) applyPseudonym(nodeHWID string) bool {
+	if pub.pseudonyms == nil {
+		return false
+	}
+	pseudonym := pub.pseudonyms.Pseudonym(nodeHWID)
+	node := pub.registeredNodes.GetNodeByHWID(nodeHWID)
+	changed := false
+	if node != nil && node.NodeID != pseudonym {
+		changed = pub.registeredNodes.SetNodeID(node, pseudonym)
+	}
+	pub.inputFromSetCommands.SetNodeID(nodeHWID, pseudonym)
+	pub.registeredOutputs.SetNodeID(nodeHWID, pseudonym)
+	return changed
+}
+
+// getAttrReaderKeys returns the public keys of the authorized attribute readers
+// Readers whose identity is not yet known are skipped.
+func (pub *Publisher) getAttrReaderKeys() []*ecdsa.PublicKey {
+	readerKeys := make([]*ecdsa.PublicKey, 0)
+	for _, readerAddress := range pub.GetAttrReaders() {
+		readerKey := pub.domainIdentities.GetPublisherKey(readerAddress)
+		if readerKey == nil {
+			logrus.Warningf("getAttrReaderKeys: public key of attribute reader %s is unknown", readerAddress)
+		} else {
+			readerKeys = append(readerKeys, readerKey)
+		}
+	}
+	return readerKeys
+}
+
+// handleAttrReaderIdentity republishes the nodes and the pseudonym mapping when an attribute reader
+// is discovered or its key changes, as private attributes are not published for readers whose key
+// is unknown.
+func (pub *Publisher) handleAttrReaderIdentity(identity *types.PublisherIdentityMessage) {
+	readerAddress := lib.MakeBaseAddress(identity.Address)
+	for _, address := range pub.GetAttrReaders() {
+		if lib.MakeBaseAddress(address) == readerAddress {
+			logrus.Infof("handleAttrReaderIdentity: Republishing nodes for attribute reader %s", readerAddress)
+			pub.registeredNodes.RepublishNodes(pub.registeredNodes.GetAllNodes())
+			pub.publishPseudonyms(true)
+			return
+		}
+	}
+}
+
+// pseudonymizeNodes returns the list of nodes to publish with their identifying attributes replaced
+// by pseudonyms. This returns the given list if pseudonymous mode is disabled.
+func (pub *Publisher) pseudonymizeNodes(updatedNodes []*types.NodeDiscoveryMessage) []*types.NodeDiscoveryMessage {
+	if pub.pseudonyms == nil {
+		return updatedNodes
+	}
+	pubNodes := make([]*types.NodeDiscoveryMessage, 0, len(updatedNodes))
+	for _, node := range updatedNodes {
+		if node != nil {
+			node = pub.pseudonyms.PseudonymizeNode(node, pub.config.PseudonymAttr)
+		}
+		pubNodes = append(pubNodes, node)
+	}
+	return pubNodes
+}
+
+// publishPseudonyms publishes the pseudonym mapping, encrypted for the attribute readers, if it has
+// changed since the last publication.
+//  force publishes the mapping even if it hasn't changed, eg when the readers have changed
+func (pub *Publisher) publishPseudonyms(force bool) {
+	if pub.pseudonyms == nil {
+		return
+	}
+	if pub.pseudonyms.IsUpdated(true) || force {
+		address := nodes.MakePseudonymsAddress(pub.Domain(), pub.PublisherID())
+		err := nodes.PublishPseudonyms(address, pub.pseudonyms.GetMapping(), pub.getAttrReaderKeys(), pub.messageSigner)
+		if err != nil {
+			logrus.Errorf("publishPseudonyms: %s", err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package publisher
+
+import (
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// SetNodePublishIntervals makes the publication intervals of a node configurable and sets their defaults
+//  publishInterval is the minimum interval in seconds between publications of the node's output
+//   values. Updates within the interval are published when it has passed. 0 publishes each heartbeat.
+//  discoveryInterval is the interval in seconds to republish the node, its inputs and outputs. 0 to disable.
+func (pub *Publisher) SetNodePublishIntervals(nodeHWID string, publishInterval int, discoveryInterval int) {
+	pub.registeredNodes.UpdateNodeConfig(nodeHWID, types.NodeAttrPublishInterval, &types.ConfigAttr{
+		DataType:    types.DataTypeInt,
+		Default:     strconv.Itoa(publishInterval),
+		Description: "Minimum interval in seconds between publications of output values",
+	})
+	pub.registeredNodes.UpdateNodeConfig(nodeHWID, types.NodeAttrDiscoveryInterval, &types.ConfigAttr{
+		DataType:    types.DataTypeInt,
+		Default:     strconv.Itoa(discoveryInterval),
+		Description: "Interval in seconds to republish the node discovery, 0 to disable",
+	})
+}
+
+// republishDueDiscovery marks the nodes whose discovery interval has passed as updated, including
+// their inputs and outputs, so they are republished with the other updates.
+// With a discovery stagger window each node is republished at its own offset within the window to
+// avoid a burst of publications. With DiscoveryOnlyIfChanged the node, inputs and outputs that are
+// unchanged since their last publication are not republished.
+func (pub *Publisher) republishDueDiscovery() {
+	now := lib.Now()
+	dueNodes := make([]*types.NodeDiscoveryMessage, 0)
+	for _, node := range pub.registeredNodes.GetAllNodes() {
+		if node == nil {
+			continue
+		}
+		interval, _ := pub.registeredNodes.GetNodeConfigInt(node.HWID, types.NodeAttrDiscoveryInterval, 0)
+		if interval <= 0 {
+			continue
+		}
+		period := time.Duration(interval) * time.Second
+		pub.updateMutex.Lock()
+		nextDiscovery, found := pub.nodeDiscoveryDue[node.HWID]
+		isDue := found && !now.Before(nextDiscovery)
+		if !found {
+			pub.nodeDiscoveryDue[node.HWID] = now.Add(period + pub.getDiscoveryOffset(node.HWID))
+		} else if isDue {
+			pub.nodeDiscoveryDue[node.HWID] = now.Add(period)
+		}
+		pub.updateMutex.Unlock()
+		if isDue {
+			dueNodes = append(dueNodes, node)
+		}
+	}
+	if len(dueNodes) == 0 {
+		return
+	}
+	republishNodes := make([]*types.NodeDiscoveryMessage, 0, len(dueNodes))
+	for _, node := range dueNodes {
+		if pub.isDiscoveryChanged(node.Address, node) {
+			republishNodes = append(republishNodes, node)
+		}
+		for _, input := range pub.registeredInputs.GetAllInputs() {
+			if input.NodeHWID == node.HWID && pub.isDiscoveryChanged(input.Address, input) {
+				pub.registeredInputs.RepublishInput(input)
+			}
+		}
+		for _, output := range pub.registeredOutputs.GetAllOutputs() {
+			if output.NodeHWID == node.HWID && pub.isDiscoveryChanged(output.Address, output) {
+				pub.registeredOutputs.RepublishOutput(output)
+			}
+		}
+	}
+	pub.registeredNodes.RepublishNodes(republishNodes)
+}
+
+// getDiscoveryOffset returns the offset of the scheduled discovery of a node within the discovery
+// stagger window. The offset is derived from the publisher and node ID so it differs between nodes
+// and publishers, and remains the same after a restart.
+func (pub *Publisher) getDiscoveryOffset(nodeHWID string) time.Duration {
+	window := pub.config.DiscoveryStaggerWindow
+	if window <= 0 {
+		return 0
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(pub.config.PublisherID + "/" + nodeHWID))
+	windowMsec := uint32(window) * 1000
+	return time.Duration(hash.Sum32()%windowMsec) * time.Millisecond
+}
+
+// isDiscoveryChanged returns true if the discovery of a node, input or output differs from its last
+// publication. Always true unless DiscoveryOnlyIfChanged is set.
+func (pub *Publisher) isDiscoveryChanged(address string, discovery interface{}) bool {
+	if !pub.config.DiscoveryOnlyIfChanged {
+		return true
+	}
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	lastHash, found := pub.discoveryHashes[address]
+	return !found || lastHash != makeDiscoveryHash(discovery)
+}
+
+// markDiscoveryPublished records the content hash of a published node, input or output discovery.
+// Only tracked when DiscoveryOnlyIfChanged is set.
+func (pub *Publisher) markDiscoveryPublished(address string, discovery interface{}) {
+	if !pub.config.DiscoveryOnlyIfChanged {
+		return
+	}
+	hash := makeDiscoveryHash(discovery)
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.discoveryHashes[address] = hash
+}
+
+// makeDiscoveryHash returns the hash of the content of a node, input or output discovery. The
+// timestamp and change reasons are excluded as they change with each publication.
+func makeDiscoveryHash(discovery interface{}) string {
+	switch entity := discovery.(type) {
+	case *types.NodeDiscoveryMessage:
+		return nodes.MakeNodeHash(entity)
+	case *types.InputDiscoveryMessage:
+		return inputs.MakeInputHash(entity)
+	case *types.OutputDiscoveryMessage:
+		return outputs.MakeOutputHash(entity)
+	}
+	return lib.MakeContentHash(discovery)
+}
+
+// scheduleOutputValues returns the updated outputs whose node publish interval has passed. The
+// other outputs are deferred until the interval of their node has passed.
+//  updatedOutputIDs are the IDs of outputs with updated values
+func (pub *Publisher) scheduleOutputValues(updatedOutputIDs []string) []string {
+	now := lib.Now()
+	pub.updateMutex.Lock()
+	for _, outputID := range updatedOutputIDs {
+		delete(pub.deferredOutputs, outputID)
+	}
+	for outputID := range pub.deferredOutputs {
+		updatedOutputIDs = append(updatedOutputIDs, outputID)
+	}
+	pub.deferredOutputs = make(map[string]bool)
+	pub.updateMutex.Unlock()
+
+	dueOutputIDs := make([]string, 0, len(updatedOutputIDs))
+	publishedNodes := make(map[string]bool)
+	for _, outputID := range updatedOutputIDs {
+		output := pub.registeredOutputs.GetOutputByID(outputID)
+		if output == nil {
+			dueOutputIDs = append(dueOutputIDs, outputID)
+			continue
+		}
+		interval, _ := pub.registeredNodes.GetNodeConfigInt(output.NodeHWID, types.NodeAttrPublishInterval, 0)
+		pub.updateMutex.Lock()
+		lastPublished, found := pub.nodePublishTimes[output.NodeHWID]
+		isDue := interval <= 0 || !found || publishedNodes[output.NodeHWID] ||
+			now.Sub(lastPublished) >= time.Duration(interval)*time.Second
+		if !isDue {
+			pub.deferredOutputs[outputID] = true
+		} else if interval > 0 {
+			pub.nodePublishTimes[output.NodeHWID] = now
+			publishedNodes[output.NodeHWID] = true
+		}
+		pub.updateMutex.Unlock()
+		if isDue {
+			dueOutputIDs = append(dueOutputIDs, outputID)
+		}
+	}
+	return dueOutputIDs
+}
@@ -2,8 +2,7 @@
 package publisher
 
 import (
-	"time"
-
+	"github.com/iotdomain/iotdomain-go/identities"
 	"github.com/iotdomain/iotdomain-go/inputs"
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
@@ -16,28 +15,141 @@ import (
 // PublishUpdates publishes changes to registered nodes, inputs, outputs, values and this publisher identity
 func (publisher *Publisher) PublishUpdates() {
 
+	publisher.republishDueDiscovery()
 	updatedNodes := publisher.registeredNodes.GetUpdatedNodes(true)
-	nodes.PublishRegisteredNodes(updatedNodes, publisher.messageSigner)
-	if len(updatedNodes) > 0 && publisher.config.ConfigFolder != "" {
+	// local-only nodes are saved without being published
+	saveNodes := len(updatedNodes) > 0
+	updatedNodes = publisher.filterLocalOnlyNodes(updatedNodes)
+	pubNodes := publisher.addNodeLocations(publisher.encryptPrivateNodeAttr(publisher.pseudonymizeNodes(updatedNodes)))
+	nodes.PublishRegisteredNodes(pubNodes, publisher.messageSigner)
+	for _, node := range updatedNodes {
+		if node != nil {
+			publisher.markRetainedPublished(node.Address)
+			publisher.markDiscoveryPublished(node.Address, node)
+			// the calibration and history retention can be changed with the node configuration
+			publisher.applyNodeCalibrations(node.HWID)
+			publisher.applyNodeHistoryRetention(node.HWID)
+		}
+	}
+	publisher.publishPseudonyms(false)
+	if saveNodes && publisher.config.ConfigFolder != "" {
 		publisher.SaveRegisteredNodes()
 	}
 
-	updatedInputs := publisher.registeredInputs.GetUpdatedInputs(true)
+	updatedInputs := publisher.filterLocalOnlyInputs(
+		publisher.filterDeletedInputs(publisher.registeredInputs.GetUpdatedInputs(true)))
 	inputs.PublishRegisteredInputs(updatedInputs, publisher.messageSigner)
+	for _, input := range updatedInputs {
+		publisher.markRetainedPublished(input.Address)
+		publisher.markDiscoveryPublished(input.Address, input)
+	}
 
-	updatedOutputs := publisher.registeredOutputs.GetUpdatedOutputs(true)
+	updatedOutputs := publisher.filterLocalOnlyOutputs(
+		publisher.filterDeletedOutputs(publisher.registeredOutputs.GetUpdatedOutputs(true)))
 	outputs.PublishRegisteredOutputs(updatedOutputs, publisher.messageSigner)
+	for _, output := range updatedOutputs {
+		publisher.markRetainedPublished(output.Address)
+		publisher.markDiscoveryPublished(output.Address, output)
+	}
 
-	updatedOutputIDs := publisher.registeredOutputValues.GetUpdatedOutputValues(true)
+	if len(updatedNodes)+len(updatedInputs)+len(updatedOutputs) > 0 {
+		publisher.notifyDiscoveryPublished()
+	}
+
+	// derived values are published along with the values they are derived from
+	publisher.updateDerivedOutputs()
+	publisher.updateEnergyOutputs(lib.Now())
+	// values of nodes with a publish interval are deferred until the interval has passed
+	updatedOutputIDs := publisher.scheduleOutputValues(publisher.registeredOutputValues.GetUpdatedOutputValues(true))
 	publisher.PublishUpdatedOutputValues(updatedOutputIDs, publisher.messageSigner)
+	publisher.publishUpdatedForecasts()
+}
+
+// publishUpdatedForecasts publishes the updated forecasts of outputs whose node has the
+// PublishForecast configuration enabled, which is the default.
+func (publisher *Publisher) publishUpdatedForecasts() {
+	for _, outputID := range publisher.registeredForecastValues.GetUpdatedForecasts(true) {
+		output := publisher.registeredOutputs.GetOutputByID(outputID)
+		if output == nil || publisher.isNodeDeleted(output.NodeHWID) || publisher.isOutputLocalOnly(output) {
+			continue
+		}
+		pubForecast, _ := publisher.registeredNodes.GetNodeConfigBool(output.NodeHWID, types.NodeAttrPublishForecast, true)
+		if !pubForecast {
+			continue
+		}
+		forecast := publisher.registeredForecastValues.GetForecast(outputID)
+		err := outputs.PublishForecast(output, forecast, publisher.messageSigner)
+		publisher.reportError(ErrorCategoryMessenger, output.Address, err)
+	}
+}
+
+// RepublishDiscovery republishes the publisher status, identity and the discovery of all registered
+// nodes, inputs and outputs. Use this when consumers might have missed the retained discovery, for
+// example after the broker restarted. Nodes, inputs and outputs are published with the next heartbeat.
+func (publisher *Publisher) RepublishDiscovery() {
+	logrus.Infof("RepublishDiscovery: publisher %s", publisher.PublisherID())
+	publisher.SetPublisherStatus(types.PublisherRunStateConnected)
+	myIdent, _ := publisher.registeredIdentity.GetFullIdentity()
+	identities.PublishIdentity(&myIdent.PublisherIdentityMessage, publisher.messageSigner)
+
+	publisher.registeredNodes.RepublishNodes(publisher.registeredNodes.GetAllNodes())
+	for _, input := range publisher.registeredInputs.GetAllInputs() {
+		publisher.registeredInputs.RepublishInput(input)
+	}
+	for _, output := range publisher.registeredOutputs.GetAllOutputs() {
+		publisher.registeredOutputs.RepublishOutput(output)
+	}
+	publisher.publishPseudonyms(true)
+}
+
+// addNodeLocations returns the list of nodes to publish with the machine-readable location of the
+// nodes whose latlon attribute is published. Private or pseudonymized locations are not included.
+func (publisher *Publisher) addNodeLocations(pubNodes []*types.NodeDiscoveryMessage) []*types.NodeDiscoveryMessage {
+	locatedNodes := make([]*types.NodeDiscoveryMessage, 0, len(pubNodes))
+	for _, node := range pubNodes {
+		if node != nil && (node.Attr[types.NodeAttrLatLon] != "" || node.Location != nil) {
+			// only the published attributes determine the location, not the location of the registered node
+			newNode := *node
+			newNode.Location = types.GetNodeLocation(&types.NodeDiscoveryMessage{Attr: node.Attr})
+			node = &newNode
+		}
+		locatedNodes = append(locatedNodes, node)
+	}
+	return locatedNodes
+}
+
+// encryptPrivateNodeAttr returns the list of nodes to publish with the configured private node attributes
+// encrypted for the authorized attribute readers.
+func (publisher *Publisher) encryptPrivateNodeAttr(updatedNodes []*types.NodeDiscoveryMessage) []*types.NodeDiscoveryMessage {
+	if len(publisher.config.PrivateNodeAttr) == 0 {
+		return updatedNodes
+	}
+	readerKeys := publisher.getAttrReaderKeys()
+	pubNodes := make([]*types.NodeDiscoveryMessage, 0, len(updatedNodes))
+	for _, node := range updatedNodes {
+		if node != nil {
+			pubNode, err := nodes.EncryptNodeAttr(node, publisher.config.PrivateNodeAttr, readerKeys)
+			if err != nil {
+				logrus.Errorf("encryptPrivateNodeAttr: %s", err)
+			}
+			node = pubNode
+		}
+		pubNodes = append(pubNodes, node)
+	}
+	return pubNodes
 }
 
 // PublishUpdatedOutputValues publishes updated outputs discovery and values of registered outputs
 // This uses the node config to determine which output publications to use: eg raw, latest, history
+// Concurrent publications are serialized so the values of an output are published in the order of
+// their sequence nr.
 func (publisher *Publisher) PublishUpdatedOutputValues(
 	updatedOutputIDs []string,
 	messageSigner *messaging.MessageSigner) {
 	regOutputValues := publisher.registeredOutputValues
+	observers := publisher.getPublishObservers()
+	publisher.valuePublishMutex.Lock()
+	defer publisher.valuePublishMutex.Unlock()
 
 	for _, outputID := range updatedOutputIDs {
 		var node *types.NodeDiscoveryMessage
@@ -46,6 +158,9 @@ func (publisher *Publisher) PublishUpdatedOutputValues(
 
 		if output == nil {
 			logrus.Warningf("PublishOutputValues: output with ID %s. This is unexpected", outputID)
+		} else if publisher.isNodeDeleted(output.NodeHWID) || publisher.isOutputLocalOnly(output) {
+			// values of deleted nodes and local-only outputs are not published
+			continue
 		} else {
 			node = publisher.registeredNodes.GetNodeByHWID(output.NodeHWID)
 		}
@@ -54,30 +169,67 @@ func (publisher *Publisher) PublishUpdatedOutputValues(
 		} else if latestValue == nil {
 			logrus.Warningf("PublishOutputValues: no latest value for %s. This is unexpected", outputID)
 		} else {
+			// the before publish hook can modify or veto the publication
+			// the result also applies to the published history and events
+			value, publish := publisher.registeredOutputs.BeforePublish(output, latestValue.Value)
+			if !publish {
+				logrus.Infof("PublishOutputValues: publication of output %s is vetoed", outputID)
+				regOutputValues.SetPublishedValue(outputID, latestValue.Sequence, latestValue.Value, true)
+				continue
+			} else if value != latestValue.Value {
+				regOutputValues.SetPublishedValue(outputID, latestValue.Sequence, value, false)
+			}
+			// the time of first publication is kept with the value to determine the transport delay
+			if published := regOutputValues.SetPublished(outputID, lib.Now()); published != nil {
+				latestValue = published
+			}
+			if value != latestValue.Value {
+				modifiedValue := *latestValue
+				modifiedValue.Value = value
+				latestValue = &modifiedValue
+			}
+			var err error
+			var pubErr error
 			pubRaw, _ := publisher.registeredNodes.GetNodeConfigBool(node.Address, types.NodeAttrPublishRaw, true)
 			if pubRaw {
-				outputs.PublishOutputRaw(output, latestValue.Value, messageSigner)
+				pubErr = outputs.PublishOutputRaw(output, latestValue.Value, messageSigner)
+				err = firstError(err, pubErr)
 			}
 			pubLatest, _ := publisher.registeredNodes.GetNodeConfigBool(node.Address, types.NodeAttrPublishLatest, true)
 			if pubLatest {
-				outputs.PublishOutputLatest(output, latestValue, messageSigner)
+				pubErr = outputs.PublishOutputLatest(output, latestValue, messageSigner)
+				err = firstError(err, pubErr)
+				publisher.markRetainedPublished(outputs.GetOutputAddresses(output).Latest)
 			}
 			pubHistory, _ := publisher.registeredNodes.GetNodeConfigBool(node.Address, types.NodeAttrPublishHistory, true)
 			if pubHistory {
-				history := regOutputValues.GetHistory(outputID)
-				outputs.PublishOutputHistory(output, history, messageSigner)
+				history := regOutputValues.GetPublishedHistory(outputID)
+				pubErr = outputs.PublishOutputHistory(output, history, messageSigner)
+				err = firstError(err, pubErr)
 			}
 			pubEvent, _ := publisher.registeredNodes.GetNodeConfigBool(node.Address, types.NodeAttrPublishEvent, false)
 			if pubEvent {
 				PublishOutputEvent(node, publisher.registeredOutputs, publisher.registeredOutputValues, messageSigner)
 			}
+			publisher.registeredOutputs.AfterPublish(output, latestValue.Value, err)
+			publisher.reportError(ErrorCategoryMessenger, output.Address, err)
+			publisher.notifyOutputValuePublished(observers, output, latestValue)
 		}
 	}
+	publisher.notifyValuesPublished(observers)
+}
+
+// firstError returns the first of two errors that is not nil
+func firstError(err1 error, err2 error) error {
+	if err1 != nil {
+		return err1
+	}
+	return err2
 }
 
 // PublishOutputEvent publishes all node output values in the $event command
 // zone/publisher/nodealias/$event
-// TODO: decide when to invoke this
+// This is invoked when output values of a node are published and its PublishEvent configuration is enabled.
 func PublishOutputEvent(
 	node *types.NodeDiscoveryMessage,
 	registeredOutputs *outputs.RegisteredOutputs,
@@ -90,13 +242,17 @@ func PublishOutputEvent(
 
 	nodeOutputs := registeredOutputs.GetOutputsByNodeHWID(node.HWID)
 	event := make(map[string]string)
-	timeStampStr := time.Now().Format("2006-01-02T15:04:05.000-0700")
+	timeStampStr := lib.Now().Format("2006-01-02T15:04:05.000-0700")
 	if len(nodeOutputs) == 0 {
 		return lib.MakeErrorf("PublishOutputEvent: Node %s doesn't have any outputs", node.Address)
 	}
 	for _, output := range nodeOutputs {
+		if output.LocalOnly {
+			continue
+		}
 		var value = ""
-		latest := outputValues.GetOutputValueByID(output.OutputID)
+		// the event holds the values as modified by the before publish hook
+		latest := outputValues.GetPublishedValue(output.OutputID)
 		attrID := string(output.OutputType) + "/" + output.Instance
 		if latest != nil {
 			value = latest.Value
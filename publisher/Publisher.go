@@ -28,53 +28,137 @@ import (
 )
 
 const (
+	// DefaultPublishQueueSize is the default maximum nr of heartbeat work items waiting to be published
+	DefaultPublishQueueSize = 10
+
+	// DefaultDeletedNodeRetention is the default nr of days that deleted nodes can be restored
+	DefaultDeletedNodeRetention = 30
+	// DeletedNodePurgeInterval is the interval in seconds to purge deleted nodes past their retention
+	DeletedNodePurgeInterval = 3600
+
+	// DefaultRetainedRefreshRate is the default max nr of retained messages refreshed per second
+	DefaultRetainedRefreshRate = 10
+
 	// DefaultPollInterval in which the registered nodes, inputs and outputs are queried for
 	// polling based sources
 	DefaultPollInterval = 600
 
 	// RegisteredNodesFileSuffix to append to name of the file containing registered nodes
 	RegisteredNodesFileSuffix = "-nodes.json"
+	// DeviceMapFileSuffix to append to the name of the file containing the mapping of device IDs to nodes
+	DeviceMapFileSuffix = "-devices.json"
 	// RegisteredIdentityFileSuffix to append to the name of the file containing publisher saved identity
 	RegisteredIdentityFileSuffix = "-identity.json"
 	// DomainPublishersFileSuffix to append to the name of the file containing domain publisher identities
 	DomainPublishersFileSuffix = "-domainpublishers.json"
-	// note, domain nodes are not saved
+	// DomainNodesFileSuffix to append to the name of the file containing discovered domain nodes
+	DomainNodesFileSuffix = "-domainnodes.json"
+	// OutboxFileSuffix to append to the name of the file containing unacknowledged commands
+	OutboxFileSuffix = "-outbox.json"
+	// ScheduledCommandsFileSuffix to append to the name of the file containing received commands
+	// that are scheduled to be applied at a later time
+	ScheduledCommandsFileSuffix = "-scheduled.json"
+	// EnergyFileSuffix to append to the name of the file containing the energy counters of power outputs
+	EnergyFileSuffix = "-energy.json"
+	// PseudonymSecretFileSuffix to append to the name of the file containing the secret of pseudonyms
+	PseudonymSecretFileSuffix = "-pseudonyms.key"
 )
 
 // PublisherConfig defined configuration fields read from the application configuration
 type PublisherConfig struct {
-	SaveDiscoveredPublishers bool   `yaml:"cachePublishers"`   // load/save discovered publisher identities to cache
-	SaveDiscoveredNodes      bool   `yaml:"cacheNodes"`        // load/save discovered nodes to cache
-	CacheFolder              string `yaml:"cacheFolder"`       // location of discovered domain nodes and publishers
-	ConfigFolder             string `yaml:"configFolder"`      // location of yaml configuration files and registered nodes and identity
-	Domain                   string `yaml:"domain"`            // optional override per publisher. Default is local
-	PublisherID              string `yaml:"publisherId"`       // this publisher's ID
-	Loglevel                 string `yaml:"loglevel"`          // error, warning, info, debug
-	Logfile                  string `yaml:"logfile"`           //
-	DisableConfig            bool   `yaml:"disableConfig"`     // disable configuration over the bus, default is enabled
-	DisableInput             bool   `yaml:"disableInput"`      // disable inputs over the bus, default is enabled
-	DisablePublishers        bool   `yaml:"disablePublishers"` // disable listening for available publishers (enable for signature verification)
-	SecuredDomain            bool   `yaml:"securedDomain"`     // require secured domain and signed messages
+	SaveDiscoveredPublishers bool   `yaml:"cachePublishers"`          // load/save discovered publisher identities to cache
+	SaveDiscoveredNodes      bool   `yaml:"cacheNodes"`               // load/save discovered nodes to cache
+	CacheFolder              string `yaml:"cacheFolder"`              // location of discovered domain nodes and publishers
+	CacheTTL                 int    `yaml:"cacheTTL"`                 // seconds cached publishers and nodes remain valid after last received. Default is lib.DefaultCacheTTL
+	ConfigFolder             string `yaml:"configFolder"`             // location of yaml configuration files and registered nodes and identity
+	Domain                   string `yaml:"domain"`                   // optional override per publisher. Default is local
+	PublisherID              string `yaml:"publisherId"`              // this publisher's ID
+	Loglevel                 string `yaml:"loglevel"`                 // error, warning, info, debug
+	Logfile                  string `yaml:"logfile"`                  //
+	DisableCommandEncryption bool   `yaml:"disableCommandEncryption"` // allow unencrypted $set and $configure commands if the destination key is unknown. Not in strict security mode
+	DisableConfig            bool   `yaml:"disableConfig"`            // disable configuration over the bus, default is enabled
+	DisableInput             bool   `yaml:"disableInput"`             // disable inputs over the bus, default is enabled
+	DisablePublishers        bool   `yaml:"disablePublishers"`        // disable listening for available publishers (enable for signature verification)
+	DisableRefresh           bool   `yaml:"disableRefresh"`           // disable republishing discovery on domain refresh requests
+	DryRun                   bool   `yaml:"dryRun"`                   // log instead of publish commands and log instead of execute received commands, see SetDryRun
+	RevocationWarning        bool   `yaml:"revocationWarning"`        // publish a warning status when an identity of the domain is revoked by the DSS
+	SecuredDomain            bool   `yaml:"securedDomain"`            // require secured domain and signed messages
+	StrictSecurity           bool   `yaml:"strictSecurity"`           // reject commands that are unencrypted or from publishers that don't chain to a trust anchor, eg self-signed
+	WatchConfig              bool   `yaml:"watchConfig"`              // reload the nodes and application configuration files when changed on disk
+
+	UnsignedMessageTypes []string `yaml:"unsignedMessageTypes"` // output message types to publish without signature, eg $raw. Default is all signed
+
+	PrivateNodeAttr  []types.NodeAttr `yaml:"privateNodeAttr"`  // node attributes that are only readable by attribute readers
+	AttrReaders      []string         `yaml:"attrReaders"`      // addresses of publishers authorized to read private node attributes
+	PublishQueueSize int              `yaml:"publishQueueSize"` // max heartbeat work items waiting to be published. Default is DefaultPublishQueueSize
+	Pseudonymous     bool             `yaml:"pseudonymous"`     // publish pseudonyms instead of node IDs and identifying attributes
+	PseudonymAttr    []types.NodeAttr `yaml:"pseudonymAttr"`    // node attributes to pseudonymize. Default is DefaultPseudonymAttr
+
+	CommandDedupWindow   int                        `yaml:"commandDedupWindow"`   // seconds that duplicate commands with the same message ID are ignored. Default is lib.DefaultDedupWindow
+	ClockSkew            int                        `yaml:"clockSkew"`            // seconds the clocks of remote publishers may differ when validating their timestamps. Default is lib.DefaultClockSkew, negative for no tolerance
+	OutboxRetryInterval  int                        `yaml:"outboxRetryInterval"`  // seconds between resending unacknowledged commands in the outbox. Default is DefaultOutboxRetryInterval
+	OutboxMaxAge         int                        `yaml:"outboxMaxAge"`         // seconds after which unacknowledged commands in the outbox expire. Default is DefaultOutboxMaxAge
+	EnergySaveInterval   int                        `yaml:"energySaveInterval"`   // seconds between saving changed energy counters. Default is DefaultEnergySaveInterval
+	HistoryRetention     *outputs.HistoryRetention  `yaml:"historyRetention"`     // default output history retention. Default is outputs.DefaultHistoryRetention
+	ForecastRetention    *outputs.ForecastRetention `yaml:"forecastRetention"`    // output forecast retention. Default is outputs.DefaultForecastRetention
+	DeletedNodeRetention int                        `yaml:"deletedNodeRetention"` // days that deleted nodes can be restored. Default is DefaultDeletedNodeRetention
+	NodeMergePolicy      nodes.NodeMergePolicy      `yaml:"nodeMergePolicy"`      // merge of rediscovered nodes with a different node ID. Default is nodes.DefaultNodeMergePolicy
+
+	BulkLoadQuiet           int  `yaml:"bulkLoadQuiet"`           // msec without discovery messages after subscribing before the domain indexes are built. Default is lib.DefaultBulkLoadQuiet, negative to disable
+	DiscoveryOnlyIfChanged  bool `yaml:"discoveryOnlyIfChanged"`  // scheduled discovery skips nodes, inputs and outputs that are unchanged since their last publication
+	DiscoveryStaggerWindow  int  `yaml:"discoveryStaggerWindow"`  // seconds over which scheduled discovery of nodes is spread. Default (0) is no staggering
+	RetainedRefreshInterval int  `yaml:"retainedRefreshInterval"` // seconds between refreshing retained messages for brokers that expire them. Default (0) is disabled
+	RetainedRefreshRate     int  `yaml:"retainedRefreshRate"`     // max nr of retained messages refreshed per second. Default is DefaultRetainedRefreshRate
+	StaleOnExpiredValues    bool `yaml:"staleOnExpiredValues"`    // set the run state of nodes to stale when an output value has passed its TTL. Default is disabled
+
+	TrustAnchors     map[string]string `yaml:"trustAnchors"`     // PEM public key files of trusted identity issuers by issuer ID, eg $dss or a CA. Relative to the config folder
+	CABundle         string            `yaml:"caBundle"`         // optional PEM file with CA certificates that issue publisher certificates. Relative to the config folder
+	IdentityCertFile string            `yaml:"identityCertFile"` // optional PEM X.509 certificate of this publisher's identity, issued by the site PKI
+	IdentityKeyFile  string            `yaml:"identityKeyFile"`  // PEM ECDSA private key of the identity certificate
+
+	LastWillReason string   `yaml:"lastWillReason"` // optional reason code included in the last will status message
+	LastWillNodes  []string `yaml:"lastWillNodes"`  // hardware IDs of critical nodes reported offline in the last will
+}
+
+// DefaultPseudonymAttr are the node attributes that are pseudonymized in pseudonymous mode
+var DefaultPseudonymAttr = []types.NodeAttr{
+	types.NodeAttrName, types.NodeAttrDescription, types.NodeAttrLocationName, types.NodeAttrLatLon,
+	types.NodeAttrHostname, types.NodeAttrLocalIP, types.NodeAttrMAC,
 }
 
 // Publisher carries the operating state of 'this' publisher
 type Publisher struct {
 	config PublisherConfig // determines publisher behavior
 
+	deviceMap          *nodes.DeviceMap                      // mapping of stable device IDs to nodes
 	domainIdentities   *identities.DomainPublisherIdentities // discovered publisher identities
 	domainInputs       *inputs.DomainInputs                  // discovered inputs from the domain
 	domainNodes        *nodes.DomainNodes                    // discovered nodes from the domain
 	domainOutputs      *outputs.DomainOutputs                // discovered outputs from the domain
 	domainOutputValues *outputs.DomainOutputValues           // output values from the domain
 
+	configReloadHandler func(filename string) // optional handler of application configuration file changes
+	configWatcher       *lib.ConfigWatcher    // optional watcher of configuration files, nil if disabled
+	connectionWatchers  []ConnectionWatcher   // handlers of changes to the connection with the message bus
+	integrations        []Integration         // optional integrations that run along with the publisher
+
+	inputFailover        *inputs.FailoverInputs         // inputs fed by multiple sources with failover
+	inputFromExec        *inputs.ReceiveFromExec        // trigger inputs with command output
 	inputFromHTTP        *inputs.ReceiveFromHTTP        // trigger inputs with http poll result
 	inputFromFiles       *inputs.ReceiveFromFiles       // trigger inputs on file changes
 	inputFromOutputs     *inputs.ReceiveFromOutputs     // subscribe input to an output (latest) value
 	inputFromSetCommands *inputs.ReceiveFromSetCommands // trigger inputs with set commands for registered inputs
 
+	receiveCalibrate        *outputs.ReceiveCalibrate // listener for output calibrate commands
 	receiveMyIdentityUpdate *identities.ReceiveRegisteredIdentityUpdate
 	receiveDomainIdentities *identities.ReceiveDomainPublisherIdentities // listener for identity updates
+	receiveInputConfigure   *inputs.ReceiveInputConfigure                // listener for input configure for registered inputs
 	receiveNodeConfigure    *nodes.ReceiveNodeConfigure                  // listener for node configure for registered nodes
+	receivePair             *nodes.ReceivePair                           // listener for pairing commands
+	receiveRefresh          *identities.ReceiveRefresh                   // listener for domain refresh requests
+	receiveRetire           *identities.ReceiveRetire                    // listener for retirement notices of domain publishers
+	receiveRevocation       *identities.ReceiveRevocation                // listener for identities revoked by the DSS
+	receiveSigningPolicy    *identities.ReceiveSigningPolicy             // listener for signing policies of domain publishers
 	receiveSetNodeID        *nodes.ReceiveSetNodeID                      // listener for set node alias
 
 	registeredForecastValues *outputs.RegisteredForecastValues // output forecasts values published by this publisher
@@ -84,38 +168,136 @@ type Publisher struct {
 	registeredOutputs        *outputs.RegisteredOutputs        // registered/published outputs from this publisher
 	registeredOutputValues   *outputs.RegisteredOutputValues   // registered/published output values from this publisher
 
+	ackSubscriptions    map[string]int                                     // nr of commands waiting for acknowledgement by $ack address
+	attrReaders         []string                                           // addresses of publishers authorized to read private node attributes
+	customMessageTypes  map[string]bool                                    // registered custom message types
+	errorHandler        func(err PublisherError)                           // optional handler of reported errors
+	deferredOutputs     map[string]bool                                    // output IDs with values deferred by the node publish interval
+	derivedOutputs      map[string]string                                  // node HWID of outputs with a derived value by output ID
+	discoveryHashes     map[string]string                                  // content hash of the last published discovery by address
+	energyOutputs       map[string]*energyOutput                           // energy counters of power outputs by power output ID
+	energySaved         time.Time                                          // time the energy counters were last saved
+	historyRetention    map[string]outputs.HistoryRetention                // output history retention policies set by the application
+	nodeConfigWatchers  map[string][]NodeConfigWatcher                     // handlers of changed node configuration by node HWID/attribute
+	nodeDiscoveryDue    map[string]time.Time                               // time of next scheduled discovery by node HWID
+	nodeLastSeen        map[string]time.Time                               // time output values of nodes were last updated by node HWID
+	nodePollers         map[string]*nodePoller                             // poll loops of nodes with their own poll interval by node HWID
+	nodePublishTimes    map[string]time.Time                               // time of last output value publication by node HWID
+	outbox              map[string]*OutboxCommand                          // journaled commands waiting for acknowledgement by message ID
+	outboxHandler       func(command OutboxCommand, ack *types.AckMessage) // handler of acknowledged outbox commands
+	pairCandidates      map[string]types.PairCandidate                     // devices discovered in inclusion mode by candidate ID
+	pairHandler         func(message *types.PairMessage)                   // optional handler of pairing commands
+	pairTimer           *time.Timer                                        // ends inclusion mode on timeout
+	pairUntil           time.Time                                          // end of inclusion mode, zero when not active
+	pendingAcks         map[string]chan *types.AckMessage                  // commands waiting for acknowledgement by message ID
+	pendingTransactions map[string][]*transaction                          // running transactions by output $latest address
+	queuedWork          map[string]bool                                    // names of heartbeat work waiting in a queue
+	retainedRefreshed   map[string]time.Time                               // time retained messages were last refreshed by address
+	retireHandler       func(message *types.RetireMessage)                 // optional handler of retired domain publishers and nodes
+	retireSuccessor     string                                             // ID of the publisher that takes over after retirement
+	revocationHandler   func(revoked *types.RevokedIdentity)               // optional handler of revoked domain identities
+	retired             bool                                               // the publisher is retired and clears its publications on stop
+	shutdownHooks       []shutdownHook                                     // hooks invoked on an orderly stop
+	staleNodes          map[string]bool                                    // HWIDs of nodes whose run state is set to stale
+
 	// fullIdentity        *types.PublisherFullIdentity                         // this publishers identity
 	// identityPrivateKey  *ecdsa.PrivateKey                                    // key for signing and encryption
-	isRunning bool // publisher was started and is running
+	isRunning      bool // publisher was started and is running
+	connectionLost bool // the connection was lost and retained publications must be republished on reconnect
 	// runStateAddress string
 
-	messenger           messaging.IMessenger                                 // Message bus messenger to use
-	messageSigner       *messaging.MessageSigner                             // publishing signed messages
-	onNodeConfigHandler nodes.NodeConfigureHandler                           // handle before applying configuration
-	onNodeInputHandler  func(address string, message *types.SetInputMessage) // handle to update device/service input
-	pollHandler         func(pub *Publisher)                                 // function that performs value polling
-	pollCountdown       int                                                  // countdown each heartbeat
-	pollInterval        int                                                  // value polling interval in seconds
+	messenger           messaging.IMessenger       // Message bus messenger to use
+	messageSigner       *messaging.MessageSigner   // publishing signed messages
+	onNodeConfigHandler nodes.NodeConfigureHandler // handle before applying configuration
+	pollHandler         func(pub *Publisher)       // function that performs value polling
+	pollCountdown       int                        // countdown each heartbeat
+	pollInterval        int                        // value polling interval in seconds
+	purgeCountdown      int                        // countdown each heartbeat to purge deleted nodes
+	pseudonyms          *nodes.Pseudonyms          // pseudonyms in pseudonymous mode, nil if disabled
 
 	// background publications require a mutex to prevent concurrent access
-	heartbeatChannel chan bool
-	updateMutex      *sync.Mutex // mutex for async updating and publishing
+	heartbeatChannel  chan bool
+	heartbeatStop     chan bool   // closed to end the heartbeat loop
+	outboxSaveMutex   *sync.Mutex // saves the outbox one at a time, outside the update mutex
+	updateMutex       *sync.Mutex // mutex for async updating and publishing
+	valuePublishMutex *sync.Mutex // publishes output values one at a time so they are published in order
+}
+
+// HandleConnectionChange handles a change in connection to the message bus.
+// After a lost connection is restored the discovery is republished as the broker might have
+// lost its retained messages. Polling is paused while the connection is lost. The handlers added
+// with OnConnectionChange are notified last.
+func (pub *Publisher) HandleConnectionChange(connected bool, err error) {
+	pub.updateMutex.Lock()
+	isRunning := pub.isRunning
+	republish := connected && pub.connectionLost
+	if !connected && err != nil {
+		pub.connectionLost = true
+	} else if connected {
+		pub.connectionLost = false
+	}
+	pub.updateMutex.Unlock()
+
+	if !connected {
+		logrus.Warningf("Publisher.HandleConnectionChange: Connection of publisher %s lost: %s", pub.PublisherID(), err)
+		pub.reportError(ErrorCategoryMessenger, "", err)
+	} else if republish && isRunning {
+		logrus.Warningf("Publisher.HandleConnectionChange: Publisher %s reconnected. Resubscribing and republishing.", pub.PublisherID())
+		pub.messageSigner.Resubscribe()
+		pub.RepublishDiscovery()
+	}
+	pub.notifyConnectionWatchers(err)
+}
+
+// isConnectionLost returns true while the connection with the message bus is lost
+func (pub *Publisher) isConnectionLost() bool {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	return pub.connectionLost
+}
+
+// HandleIdentityUpdate handles an update of this publisher's identity by the DSS.
+// The subscriptions are restored so they are made with the new identity.
+func (pub *Publisher) HandleIdentityUpdate(identity *types.PublisherFullIdentity) {
+	logrus.Infof("Publisher.HandleIdentityUpdate: Identity of publisher %s is updated. Resubscribing.", pub.PublisherID())
+	pub.messageSigner.Resubscribe()
+}
+
+// HandleRefreshCommand handles a domain request to republish the discovery of this publisher
+func (pub *Publisher) HandleRefreshCommand(message *types.RefreshMessage) {
+	pub.updateMutex.Lock()
+	isRunning := pub.isRunning
+	pub.updateMutex.Unlock()
+	if isRunning {
+		pub.RepublishDiscovery()
+	}
 }
 
 // HandleSetNodeIDCommand handles the command to change the ID of a node. This updates the address
 // of a node, its inputs and its outputs.
+// In pseudonymous mode the node ID is fixed and the command is ignored.
 func (pub *Publisher) HandleSetNodeIDCommand(address string, message *types.SetNodeIDMessage) {
 	node := pub.registeredNodes.GetNodeByAddress(address)
-	if node == nil {
+	if node == nil || pub.pseudonyms != nil {
 		return
 	}
 	pub.registeredNodes.SetNodeID(node, message.NodeID)
-	pub.registeredInputs.SetNodeID(node.HWID, message.NodeID)
+	pub.inputFromSetCommands.SetNodeID(node.HWID, message.NodeID)
 	pub.registeredOutputs.SetNodeID(node.HWID, message.NodeID)
 }
 
+// LoadDomainNodes loads discovered domain nodes from the cache folder.
+// Nodes that were not received within the cache TTL are ignored.
+func (pub *Publisher) LoadDomainNodes() error {
+	filename := path.Join(pub.config.CacheFolder, pub.PublisherID()+DomainNodesFileSuffix)
+	err := pub.domainNodes.LoadNodes(filename)
+	return err
+}
+
 // LoadDomainPublishers loads discovered publisher identities from the cache folder.
-// Intended to cache the public signing keys to verify messages from these publishers
+// Intended to cache the public signing keys to verify messages from these publishers after a
+// restart, before their identity is received again. Identities that are expired or were not
+// received within the cache TTL are ignored.
 func (pub *Publisher) LoadDomainPublishers() error {
 	filename := path.Join(pub.config.CacheFolder, pub.PublisherID()+DomainPublishersFileSuffix)
 	err := pub.domainIdentities.LoadIdentities(filename)
@@ -127,35 +309,94 @@ func (pub *Publisher) LoadDomainPublishers() error {
 func (pub *Publisher) LoadRegisteredNodes() error {
 	filename := path.Join(pub.config.ConfigFolder, pub.PublisherID()+RegisteredNodesFileSuffix)
 	err := pub.registeredNodes.LoadNodes(filename)
+	for _, node := range pub.registeredNodes.GetDeletedNodes() {
+		pub.registeredInputs.SetNodeDisabled(node.HWID, true)
+	}
+	return err
+}
+
+// SaveDomainNodes saves discovered domain nodes to the cache folder
+func (pub *Publisher) SaveDomainNodes() error {
+	filename := path.Join(pub.config.CacheFolder, pub.PublisherID()+DomainNodesFileSuffix)
+	err := os.MkdirAll(pub.config.CacheFolder, 0750)
+	if err != nil {
+		err = lib.MakeErrorf("SaveDomainNodes: Unable to create cache folder %s: %s", pub.config.CacheFolder, err)
+	} else {
+		err = pub.domainNodes.SaveNodes(filename)
+	}
+	pub.reportError(ErrorCategoryPersistence, filename, err)
 	return err
 }
 
-// SaveDomainPublishers saves discovered domain publisher identities
+// SaveDomainPublishers saves discovered domain publisher identities to the cache folder
 func (pub *Publisher) SaveDomainPublishers() error {
 	filename := path.Join(pub.config.CacheFolder, pub.PublisherID()+DomainPublishersFileSuffix)
-	err := pub.domainIdentities.SaveIdentities(filename)
+	err := os.MkdirAll(pub.config.CacheFolder, 0750)
+	if err != nil {
+		err = lib.MakeErrorf("SaveDomainPublishers: Unable to create cache folder %s: %s", pub.config.CacheFolder, err)
+	} else {
+		err = pub.domainIdentities.SaveIdentities(filename)
+	}
+	pub.reportError(ErrorCategoryPersistence, filename, err)
 	return err
 }
 
-// SaveRegisteredNodes saves current registered nodes to the config folder
+// SaveRegisteredNodes saves the changes to the registered nodes to the config folder.
+// Changes are appended to the journal of the nodes file, which is compacted as it grows.
 func (pub *Publisher) SaveRegisteredNodes() error {
 	filename := path.Join(pub.config.ConfigFolder, pub.PublisherID()+RegisteredNodesFileSuffix)
-	err := pub.registeredNodes.SaveNodes(filename)
+	err := pub.registeredNodes.SaveChangedNodes(filename)
+	pub.reportError(ErrorCategoryPersistence, filename, err)
+	if pub.configWatcher != nil {
+		// don't reload the nodes this publisher saved itself
+		pub.configWatcher.Refresh(pub.PublisherID() + RegisteredNodesFileSuffix)
+	}
 	return err
 }
 
+// SetInputConfigHandler sets the handler for updating input configuration, eg the URL or poll interval
+// of an input. The handler is invoked if a configuration update for an input is received and the input
+// exists. Without handler the configuration values of the input are updated.
+func (pub *Publisher) SetInputConfigHandler(
+	handler func(input *types.InputDiscoveryMessage, config types.NodeAttrMap)) {
+
+	if handler == nil {
+		pub.receiveInputConfigure.SetConfigureInputHandler(nil)
+		return
+	}
+	pub.receiveInputConfigure.SetConfigureInputHandler(func(input *types.InputDiscoveryMessage, config types.NodeAttrMap) {
+		defer pub.recoverHandler("inputConfig", input.NodeHWID, input.Address)
+		handler(input, config)
+	})
+}
+
 // SetNodeConfigHandler set the handler for updating node configuration.
 // The handler is invoked if a configuration update for a node is received and the node exists.
 func (pub *Publisher) SetNodeConfigHandler(
 	handler func(nodeHWID string, config types.NodeAttrMap)) {
 
-	pub.receiveNodeConfigure.SetConfigureNodeHandler(handler)
+	if handler == nil {
+		pub.receiveNodeConfigure.SetConfigureNodeHandler(nil)
+		return
+	}
+	pub.receiveNodeConfigure.SetConfigureNodeHandler(func(nodeHWID string, config types.NodeAttrMap) {
+		defer pub.recoverHandler("config", nodeHWID, "")
+		handler(nodeHWID, config)
+	})
+}
+
+// SetNodeConflictHandler sets the handler that selects the merge policy when a discovered node has the
+// same hardware ID as a registered node but a different node ID. The handler returns "" to apply the
+// configured NodeMergePolicy. Use nil to always apply the configured policy.
+func (pub *Publisher) SetNodeConflictHandler(handler nodes.NodeConflictHandler) {
+	pub.registeredNodes.SetMergePolicy(pub.config.NodeMergePolicy, handler)
 }
 
 // SetPollInterval is a convenience function for periodic polling of updates to registered
 // nodes, inputs, outputs and output values.
 // seconds interval to perform another poll. Default (0) is DefaultPollInterval
 // intended for publishers that need to poll for values
+// Use SetNodePollInterval to poll nodes at their own interval.
 func (pub *Publisher) SetPollInterval(seconds int, handler func(pub *Publisher)) {
 	logrus.Infof("Publisher.SetPoll: interval = %d seconds", seconds)
 	if seconds > 0 {
@@ -166,6 +407,48 @@ func (pub *Publisher) SetPollInterval(seconds int, handler func(pub *Publisher))
 	pub.pollHandler = handler
 }
 
+// makeLastWill returns the address and payload of the last will status message that is published by
+// the message bus when the connection is unexpectedly lost. The status message includes the configured
+// reason code and the addresses of critical nodes that go offline with the publisher.
+func (pub *Publisher) makeLastWill() (address string, message string) {
+	address = identities.MakePublisherStatusAddress(pub.Domain(), pub.PublisherID())
+	pub.updateMutex.Lock()
+	reason := pub.config.LastWillReason
+	nodeHWIDs := pub.config.LastWillNodes
+	pub.updateMutex.Unlock()
+
+	lastWill := types.PublisherStatusMessage{
+		Address: address,
+		Status:  types.PublisherRunStateLost,
+		Reason:  reason,
+	}
+	for _, nodeHWID := range nodeHWIDs {
+		node := pub.registeredNodes.GetNodeByHWID(nodeHWID)
+		if node != nil {
+			lastWill.OfflineNodes = append(lastWill.OfflineNodes, node.Address)
+		} else {
+			logrus.Warningf("Publisher.makeLastWill: Critical node %s not found", nodeHWID)
+		}
+	}
+	message, err := pub.messageSigner.SignObject(&lastWill)
+	if err != nil {
+		logrus.Errorf("Publisher.makeLastWill: %s. Using plain status.", err)
+		message = string(types.PublisherRunStateLost)
+	}
+	return address, message
+}
+
+// SetLastWill sets the reason code and critical nodes included in the last will status message.
+// The last will is registered with the message bus on connect and takes effect on the next start.
+//  reason is an optional reason code
+//  nodeHWIDs are the hardware IDs of critical nodes that are reported offline in the last will
+func (pub *Publisher) SetLastWill(reason string, nodeHWIDs []string) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.config.LastWillReason = reason
+	pub.config.LastWillNodes = append([]string{}, nodeHWIDs...)
+}
+
 // SetPublisherStatus sets the publisher runtime status and publishes the message
 func (pub *Publisher) SetPublisherStatus(status types.PublisherRunState) {
 	addr := identities.MakePublisherStatusAddress(pub.Domain(), pub.PublisherID())
@@ -186,60 +469,107 @@ func (pub *Publisher) Start() {
 		pub.isRunning = true
 		pub.updateMutex.Unlock()
 
-		go pub.heartbeatLoop()
+		pub.heartbeatStop = make(chan bool)
+		go pub.heartbeatLoop(pub.heartbeatStop)
 		// wait for the heartbeat to start
 		<-pub.heartbeatChannel
 
 		// reload our own identity and nodes
 		myIdent, _ := pub.registeredIdentity.GetFullIdentity()
-		pub.domainIdentities.AddIdentity(&myIdent.PublisherIdentityMessage)
+		_, trusted, _ := pub.domainIdentities.GetIssuerKey(&myIdent.PublisherIdentityMessage)
+		pub.domainIdentities.AddVerifiedIdentity(&myIdent.PublisherIdentityMessage, trusted)
 
 		// reload previously discovered publishers
 		if pub.config.SaveDiscoveredPublishers {
-			pub.domainIdentities.LoadIdentities(pub.config.CacheFolder)
+			pub.LoadDomainPublishers()
 		}
 		// reload previously discovered nodes
 		if pub.config.SaveDiscoveredNodes {
-			pub.domainNodes.LoadNodes(pub.config.CacheFolder)
+			pub.LoadDomainNodes()
 		}
 
 		// discover domain entities, eg identities, nodes, inputs and outputs
 		if !pub.config.DisablePublishers {
 			pub.receiveDomainIdentities.Start()
+			pub.receiveRetire.Start()
+			pub.receiveRevocation.Start()
+			pub.receiveSigningPolicy.Start()
 		}
 		// receive registered input set commands
 		if !pub.config.DisableInput {
 			pub.receiveSetNodeID.Start()
+			pub.inputFromExec.Start()
+			pub.LoadScheduledCommands()
 		}
 		// Receive registered node configuration commands
 		if !pub.config.DisableConfig {
 			pub.receiveNodeConfigure.Start()
+			pub.receiveInputConfigure.Start()
+			pub.receiveCalibrate.Start()
 		}
 		// in secured domains the DSS can update the identity
 		if pub.config.SecuredDomain {
 			pub.receiveMyIdentityUpdate.Start()
 		}
+		// consumers can request a republish of the discovery
+		if !pub.config.DisableRefresh {
+			pub.receiveRefresh.Start()
+		}
+		// UIs can control the inclusion mode of device adapters
+		if !pub.config.DisableInput {
+			pub.receivePair.Start()
+		}
 		//  listening
-		lwtStatusAddress := identities.MakePublisherStatusAddress(pub.Domain(), pub.PublisherID())
-		pub.messenger.Connect(lwtStatusAddress, string(types.PublisherRunStateLost))
+		lwtStatusAddress, lwtStatus := pub.makeLastWill()
+		err := pub.messenger.Connect(lwtStatusAddress, lwtStatus)
+		if err != nil {
+			logrus.Errorf("Publisher.Start: Failed connecting to the message bus: %s", err)
+			pub.reportError(ErrorCategoryMessenger, "", err)
+		}
 
 		pub.SetPublisherStatus(types.PublisherRunStateConnected)
 		identities.PublishIdentity(&myIdent.PublisherIdentityMessage, pub.messageSigner)
+		pub.publishSigningPolicy()
+		pub.startIntegrations()
+		if pub.configWatcher != nil {
+			err = pub.configWatcher.Start()
+			if err != nil {
+				logrus.Errorf("Publisher.Start: %s", err)
+			}
+		}
+		pub.updateMutex.Lock()
+		pub.startNodePollers()
+		pub.updateMutex.Unlock()
+		// resend commands that were not acknowledged before the last stop
+		pub.LoadOutbox()
+		pub.resendOutbox(true)
 	}
 }
 
 // Stop publishing, and set the run status to disconnected and disconnect from the
-// message bus. Wait until the heartbeat loop has finished processing messages
+// message bus. Wait until the heartbeat loop has finished processing messages.
+// When running, pending updates are published, shutdown hooks are invoked and discovered
+// publishers are saved if enabled before disconnecting.
 func (pub *Publisher) Stop() {
 	logrus.Warningf("Publisher.Stop: Stopping publisher %s", pub.PublisherID())
 	pub.updateMutex.Lock()
+	wasRunning := pub.isRunning
 	if pub.isRunning {
 		pub.isRunning = false
+		close(pub.heartbeatStop)
 
+		pub.receiveCalibrate.Stop()
 		pub.receiveMyIdentityUpdate.Stop()
 		pub.receiveDomainIdentities.Stop()
+		pub.receiveInputConfigure.Stop()
 		pub.receiveNodeConfigure.Stop()
+		pub.receivePair.Stop()
+		pub.receiveRefresh.Stop()
+		pub.receiveRetire.Stop()
+		pub.receiveRevocation.Stop()
+		pub.receiveSigningPolicy.Stop()
 		pub.receiveSetNodeID.Stop()
+		pub.inputFromExec.Stop()
 
 		pub.updateMutex.Unlock()
 		// wait for heartbeat to end
@@ -247,7 +577,45 @@ func (pub *Publisher) Stop() {
 	} else {
 		pub.updateMutex.Unlock()
 	}
-	pub.SetPublisherStatus(types.PublisherRunStateDisconnected)
+	if wasRunning {
+		pub.stopNodePollers()
+		pub.PublishUpdates()
+		pub.runShutdownHooks()
+		// energy counters are saved periodically while running so save the latest on stop
+		pub.updateMutex.Lock()
+		hasEnergyOutputs := len(pub.energyOutputs) > 0
+		pub.updateMutex.Unlock()
+		if hasEnergyOutputs {
+			err := pub.SaveEnergyCounters()
+			if err != nil {
+				logrus.Errorf("Publisher.Stop: %s", err)
+			}
+		}
+		if pub.config.SaveDiscoveredPublishers {
+			err := pub.SaveDomainPublishers()
+			if err != nil {
+				logrus.Errorf("Publisher.Stop: %s", err)
+			}
+		}
+		if pub.config.SaveDiscoveredNodes {
+			err := pub.SaveDomainNodes()
+			if err != nil {
+				logrus.Errorf("Publisher.Stop: %s", err)
+			}
+		}
+		pub.stopIntegrations()
+	}
+	if pub.configWatcher != nil {
+		pub.configWatcher.Stop()
+	}
+	pub.updateMutex.Lock()
+	retired := pub.retired
+	pub.updateMutex.Unlock()
+	if retired {
+		pub.publishRetirement()
+	} else {
+		pub.SetPublisherStatus(types.PublisherRunStateDisconnected)
+	}
 	pub.messenger.Disconnect()
 	logrus.Info("... bye bye")
 }
@@ -267,39 +635,122 @@ func (pub *Publisher) WaitForSignal() {
 	fmt.Println(sig)
 }
 
-// Main heartbeat loop to publish, discove and poll value updates
-func (pub *Publisher) heartbeatLoop() {
+// Main heartbeat loop to schedule publication, discovery and polling of value updates
+// The heartbeat is purely a ticker. The work is queued and performed by the publishLoop so that
+// slow publications or polling don't delay the heartbeat cadence. If the queue is full then the
+// work of this heartbeat is skipped. Pending updates are published with the next heartbeat.
+// Polling has its own worker so a slow poll handler doesn't hold up the publication of updates.
+// The heartbeat ends when the stop channel is closed.
+func (pub *Publisher) heartbeatLoop(stop chan bool) {
 	logrus.Infof("Publisher.heartbeatLoop: starting heartbeat loop")
+	queueSize := pub.config.PublishQueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultPublishQueueSize
+	}
+	workQueue := make(chan publishWork, queueSize)
+	workerDone := make(chan bool)
+	go pub.publishLoop(workQueue, workerDone)
+	pollQueue := make(chan publishWork, 1)
+	pollDone := make(chan bool)
+	go pub.publishLoop(pollQueue, pollDone)
+
+	ticker := lib.NewTicker(time.Second)
 	pub.heartbeatChannel <- false
 
+heartbeat:
 	for {
-		time.Sleep(time.Second)
-
-		// FIXME: The duration of publishing these updates adds to the heartbeat which delays the heartbeat
-		pub.PublishUpdates()
+		select {
+		case <-stop:
+			break heartbeat
+		case <-ticker.C:
+		}
+		pub.queueWork(workQueue, "publishUpdates", pub.PublishUpdates)
 
 		if pub.config.SaveDiscoveredPublishers && pub.domainIdentities.UpdateCount() > 0 {
-			pub.SaveDomainPublishers()
+			pub.queueWork(workQueue, "saveDomainPublishers", func() { pub.SaveDomainPublishers() })
+		}
+		if pub.config.SaveDiscoveredNodes && pub.domainNodes.UpdateCount() > 0 {
+			pub.queueWork(workQueue, "saveDomainNodes", func() { pub.SaveDomainNodes() })
+		}
+		if pub.outboxCount() > 0 {
+			pub.queueWork(workQueue, "resendOutbox", func() { pub.resendOutbox(false) })
 		}
 
 		// poll for discovery and values of registered nodes, inputs and outputs
-		if (pub.pollCountdown <= 0) && (pub.pollHandler != nil) {
-			pub.pollHandler(pub)
-			pub.pollCountdown = pub.pollInterval
+		// polling is paused while the connection is lost
+		if (pub.pollCountdown <= 0) && (pub.pollHandler != nil) && !pub.isConnectionLost() {
+			pollHandler := pub.pollHandler
+			if pub.queueWork(pollQueue, "poll", func() { pollHandler(pub) }) {
+				pub.pollCountdown = pub.pollInterval
+			}
 		}
 		pub.pollCountdown--
 
-		pub.updateMutex.Lock()
-		isRunning := pub.isRunning
-		pub.updateMutex.Unlock()
-		if !isRunning {
-			break
+		if pub.config.RetainedRefreshInterval > 0 {
+			pub.queueWork(workQueue, "refreshRetained", pub.refreshRetained)
+		}
+		pub.queueWork(workQueue, "checkFailoverSources", pub.inputFailover.CheckSources)
+		pub.queueWork(workQueue, "checkStaleNodes", pub.checkStaleNodes)
+		pub.queueWork(workQueue, "executeScheduledCommands", pub.inputFromSetCommands.ExecuteScheduledCommands)
+		if pub.purgeCountdown <= 0 {
+			pub.queueWork(workQueue, "purgeDeletedNodes", pub.purgeDeletedNodes)
+			pub.purgeCountdown = DeletedNodePurgeInterval
 		}
+		pub.purgeCountdown--
 	}
+	ticker.Stop()
+	// let the publish loop finish the queued work
+	close(workQueue)
+	close(pollQueue)
+	<-workerDone
+	<-pollDone
 	pub.heartbeatChannel <- true
 	logrus.Infof("Publisher.heartbeatLoop: Ending loop of publisher %s", pub.PublisherID())
 }
 
+// publishWork is a unit of work queued by the heartbeat
+type publishWork struct {
+	name string // name of the work for logging
+	work func() // function that performs the work
+}
+
+// publishLoop performs the work queued by the heartbeat until the queue is closed
+func (pub *Publisher) publishLoop(workQueue chan publishWork, workerDone chan bool) {
+	for item := range workQueue {
+		pub.performWork(item)
+	}
+	workerDone <- true
+}
+
+// performWork performs a unit of queued work. A panic, eg in the poll handler, is recovered so the
+// publish loop keeps running.
+func (pub *Publisher) performWork(item publishWork) {
+	pub.updateMutex.Lock()
+	delete(pub.queuedWork, item.name)
+	pub.updateMutex.Unlock()
+	defer pub.recoverHandler(item.name, "", "")
+	item.work()
+}
+
+// queueWork adds work to the publish queue without blocking. Work that is still waiting in a queue
+// is not queued again. If the queue is full the work is skipped.
+// This returns true if the work is queued or already waiting, false if it is skipped.
+func (pub *Publisher) queueWork(workQueue chan publishWork, name string, work func()) bool {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	if pub.queuedWork[name] {
+		return true
+	}
+	select {
+	case workQueue <- publishWork{name: name, work: work}:
+		pub.queuedWork[name] = true
+		return true
+	default:
+		logrus.Warningf("Publisher.queueWork: Publish queue is full. Skipping %s", name)
+		return false
+	}
+}
+
 // SetLogging sets the logging level and output file for this publisher
 // Intended for setting logging from configuration
 //  levelName is the requested logging level: error, warning, info, debug
@@ -349,6 +800,15 @@ func SetLogging(levelName string, filename string) error {
 	return err
 }
 
+// configPath returns the path of a file in the configuration. Relative paths are relative to
+// the config folder.
+func configPath(config *PublisherConfig, filename string) string {
+	if filename == "" || path.IsAbs(filename) {
+		return filename
+	}
+	return path.Join(config.ConfigFolder, filename)
+}
+
 // NewPublisher creates a new publisher instance. This is used for all publications.
 //
 // The configFolder contains the publisher saved identity and node configuration <publisherID>-nodes.json.
@@ -377,31 +837,87 @@ func NewPublisher(config *PublisherConfig, messenger messaging.IMessenger,
 	if config.ConfigFolder == "" {
 		config.ConfigFolder = lib.DefaultConfigFolder
 	}
+	if config.CacheFolder == "" {
+		config.CacheFolder = lib.DefaultCacheFolder
+	}
+	if config.DeletedNodeRetention <= 0 {
+		config.DeletedNodeRetention = DefaultDeletedNodeRetention
+	}
 	SetLogging(config.Loglevel, config.Logfile)
 
 	identityFile := path.Join(config.ConfigFolder, config.PublisherID+RegisteredIdentityFileSuffix)
 	registeredIdentity := identities.NewRegisteredIdentity(
 		config.Domain, config.PublisherID, identityFile)
 	_, privKey, err := registeredIdentity.LoadIdentity()
-	if err != nil {
-		// save the identity as the loaded one isnt' valid
+	isCertIdentity := false
+	if config.IdentityCertFile != "" {
+		// the identity issued by the site PKI replaces the saved identity
+		certErr := registeredIdentity.LoadIdentityCertificate(
+			configPath(config, config.IdentityCertFile), configPath(config, config.IdentityKeyFile))
+		if certErr != nil {
+			logrus.Errorf("NewPublisher: Identity certificate is not used: %s", certErr)
+		}
+		isCertIdentity = certErr == nil
+	}
+	if err != nil || isCertIdentity {
+		// save the identity as the loaded one isnt' valid or is replaced
 		registeredIdentity.SaveIdentity()
+		privKey = registeredIdentity.GetPrivateKey()
+	}
+	// the clock skew tolerance applies to the timestamps received by this publisher
+	clockSkew := lib.DefaultClockSkew
+	if config.ClockSkew > 0 {
+		clockSkew = time.Duration(config.ClockSkew) * time.Second
+	} else if config.ClockSkew < 0 {
+		clockSkew = 0
 	}
 	domainIdentities := identities.NewDomainPublisherIdentities()
+	domainIdentities.SetClockSkew(clockSkew)
+	if config.CacheTTL > 0 {
+		domainIdentities.SetCacheTTL(time.Duration(config.CacheTTL) * time.Second)
+	}
+	for issuerID, keyFile := range config.TrustAnchors {
+		err = domainIdentities.LoadTrustAnchor(issuerID, configPath(config, keyFile))
+		if err != nil {
+			logrus.Errorf("NewPublisher: Trust anchor is ignored: %s", err)
+		}
+	}
+	if config.CABundle != "" {
+		caBundle, err := identities.LoadCABundle(configPath(config, config.CABundle))
+		if err != nil {
+			logrus.Errorf("NewPublisher: CA bundle is ignored: %s", err)
+		}
+		domainIdentities.SetCABundle(caBundle)
+	}
 
 	// These are the basis for signing and identifying publishers
 	messageSigner := messaging.NewMessageSigner(messenger, privKey, domainIdentities.GetPublisherKey)
+	if config.StrictSecurity {
+		messageSigner.SetTrustedSenderCheck(domainIdentities.IsTrusted)
+	} else if config.DisableCommandEncryption {
+		messageSigner.SetEncryptCommands(false)
+	}
 
 	// application services
 	domainInputs := inputs.NewDomainInputs(messageSigner)
 	domainNodes := nodes.NewDomainNodes(messageSigner)
+	if config.CacheTTL > 0 {
+		domainNodes.SetCacheTTL(time.Duration(config.CacheTTL) * time.Second)
+	}
 	domainOutputs := outputs.NewDomainOutputs(messageSigner)
 	domainOutputValues := outputs.NewDomainOutputValues(messageSigner)
 	registeredInputs := inputs.NewRegisteredInputs(config.Domain, config.PublisherID)
 	registeredNodes := nodes.NewRegisteredNodes(config.Domain, config.PublisherID)
+	registeredNodes.SetMergePolicy(config.NodeMergePolicy, nil)
 	registeredOutputs := outputs.NewRegisteredOutputs(config.Domain, config.PublisherID)
 	registeredOutputValues := outputs.NewRegisteredOutputValues(config.Domain, config.PublisherID)
+	if config.HistoryRetention != nil {
+		registeredOutputValues.SetDefaultRetention(*config.HistoryRetention)
+	}
 	registeredForecastValues := outputs.NewRegisteredForecastValues(config.Domain, config.PublisherID)
+	if config.ForecastRetention != nil {
+		registeredForecastValues.SetRetention(*config.ForecastRetention)
+	}
 
 	receiveMyIdentityUpdate := identities.NewReceiveRegisteredIdentityUpdate(
 		registeredIdentity, messageSigner)
@@ -409,24 +925,56 @@ func NewPublisher(config *PublisherConfig, messenger messaging.IMessenger,
 		domainIdentities, messageSigner)
 	receiveNodeConfigure := nodes.NewReceiveNodeConfigure(
 		config.Domain, config.PublisherID, nil, messageSigner, registeredNodes, privKey)
+	receiveInputConfigure := inputs.NewReceiveInputConfigure(
+		config.Domain, config.PublisherID, messageSigner, registeredInputs)
 	receiveSetNodeID := nodes.NewReceiveSetNodeID(
 		config.Domain, config.PublisherID, nil, messageSigner, privKey)
+	receivePair := nodes.NewReceivePair(config.Domain, config.PublisherID, nil, messageSigner)
+	receiveCalibrate := outputs.NewReceiveCalibrate(
+		config.Domain, config.PublisherID, nil, messageSigner, registeredOutputs)
+	receiveRefresh := identities.NewReceiveRefresh(config.Domain, nil, messageSigner)
+	receiveRetire := identities.NewReceiveRetire(nil, messageSigner)
+	receiveRevocation := identities.NewReceiveRevocation(domainIdentities, nil, messageSigner)
+	receiveSigningPolicy := identities.NewReceiveSigningPolicy(messageSigner)
 
 	var pub = &Publisher{
-		config:             *config,
-		domainIdentities:   domainIdentities,
-		domainInputs:       domainInputs,
-		domainNodes:        domainNodes,
-		domainOutputs:      domainOutputs,
-		domainOutputValues: domainOutputValues,
+		ackSubscriptions:    make(map[string]int),
+		attrReaders:         append([]string{}, config.AttrReaders...),
+		customMessageTypes:  make(map[string]bool),
+		deferredOutputs:     make(map[string]bool),
+		derivedOutputs:      make(map[string]string),
+		deviceMap:           nodes.NewDeviceMap(),
+		discoveryHashes:     make(map[string]string),
+		energyOutputs:       make(map[string]*energyOutput),
+		nodeConfigWatchers:  make(map[string][]NodeConfigWatcher),
+		nodeDiscoveryDue:    make(map[string]time.Time),
+		nodeLastSeen:        make(map[string]time.Time),
+		nodePollers:         make(map[string]*nodePoller),
+		nodePublishTimes:    make(map[string]time.Time),
+		pairCandidates:      make(map[string]types.PairCandidate),
+		pendingAcks:         make(map[string]chan *types.AckMessage),
+		pendingTransactions: make(map[string][]*transaction),
+		queuedWork:          make(map[string]bool),
+		retainedRefreshed:   make(map[string]time.Time),
+		staleNodes:          make(map[string]bool),
+		config:              *config,
+		domainIdentities:    domainIdentities,
+		domainInputs:        domainInputs,
+		domainNodes:         domainNodes,
+		domainOutputs:       domainOutputs,
+		domainOutputValues:  domainOutputValues,
 
 		inputFromSetCommands: inputs.NewReceiveFromSetCommands(
 			config.Domain, config.PublisherID, messageSigner, registeredInputs),
+		inputFailover:    inputs.NewFailoverInputs(registeredInputs),
+		inputFromExec:    inputs.NewReceiveFromExec(registeredInputs),
 		inputFromHTTP:    inputs.NewReceiveFromHTTP(registeredInputs),
 		inputFromFiles:   inputs.NewReceiveFromFiles(registeredInputs),
 		inputFromOutputs: inputs.NewReceiveFromOutputs(messageSigner, registeredInputs),
 
 		heartbeatChannel: make(chan bool),
+		historyRetention: make(map[string]outputs.HistoryRetention),
+		outbox:           make(map[string]*OutboxCommand),
 		// fullIdentity:       identity,
 		// identityPrivateKey: privateKey,
 		// runStateAddress: fmt.Sprintf("%s/%s/%s", config.Domain, config.PublisherID, types.MessageTypeRunState),
@@ -435,9 +983,16 @@ func NewPublisher(config *PublisherConfig, messenger messaging.IMessenger,
 		messageSigner:           messageSigner,
 		pollCountdown:           0,
 		pollInterval:            DefaultPollInterval,
+		receiveCalibrate:        receiveCalibrate,
 		receiveDomainIdentities: receiveDomainIdentities,
+		receiveInputConfigure:   receiveInputConfigure,
 		receiveMyIdentityUpdate: receiveMyIdentityUpdate,
 		receiveNodeConfigure:    receiveNodeConfigure,
+		receivePair:             receivePair,
+		receiveRefresh:          receiveRefresh,
+		receiveRetire:           receiveRetire,
+		receiveRevocation:       receiveRevocation,
+		receiveSigningPolicy:    receiveSigningPolicy,
 		receiveSetNodeID:        receiveSetNodeID,
 
 		registeredForecastValues: registeredForecastValues,
@@ -447,12 +1002,61 @@ func NewPublisher(config *PublisherConfig, messenger messaging.IMessenger,
 		registeredOutputs:        registeredOutputs,
 		registeredOutputValues:   registeredOutputValues,
 
-		updateMutex: &sync.Mutex{},
+		outboxSaveMutex:   &sync.Mutex{},
+		updateMutex:       &sync.Mutex{},
+		valuePublishMutex: &sync.Mutex{},
+	}
+	pub.inputFromSetCommands.SetClockSkew(clockSkew)
+	if config.CommandDedupWindow > 0 {
+		dedupWindow := time.Duration(config.CommandDedupWindow) * time.Second
+		pub.inputFromSetCommands.SetDedupWindow(dedupWindow)
+		receiveInputConfigure.SetDedupWindow(dedupWindow)
+		receiveNodeConfigure.SetDedupWindow(dedupWindow)
 	}
 	receiveSetNodeID.SetNodeIDHandler(pub.HandleSetNodeIDCommand)
+	receiveMyIdentityUpdate.SetIdentityUpdateHandler(pub.HandleIdentityUpdate)
+	receiveNodeConfigure.SetConfigChangedHandler(pub.HandleNodeConfigChanged)
+	receivePair.SetPairHandler(pub.HandlePairCommand)
+	receiveCalibrate.SetCalibrateHandler(pub.SetOutputCalibration)
+	receiveRefresh.SetRefreshHandler(pub.HandleRefreshCommand)
+	receiveRetire.SetRetireHandler(pub.HandleRetireNotice)
+	receiveRevocation.SetRevocationHandler(pub.HandleRevocation)
+	receiveDomainIdentities.SetIdentityHandler(pub.handleAttrReaderIdentity)
+	messenger.SetConnectionHandler(pub.HandleConnectionChange)
+	messageSigner.SetErrorHandler(func(err error) {
+		pub.notifyVerificationFailed(err)
+		pub.reportError(ErrorCategorySignature, "", err)
+	})
+	messageSigner.SetAuditHandler(pub.notifyCommandReceived)
+
+	messageSigner.AddPublishHook(pub.dryRunHook)
+	registeredInputs.SetDryRun(config.DryRun)
+
+	err = messageSigner.SetUnsignedMessageTypes(config.UnsignedMessageTypes)
+	if err != nil {
+		logrus.Errorf("NewPublisher: Invalid unsignedMessageTypes: %s", err)
+	}
+	if config.WatchConfig {
+		watchedFiles := []string{config.PublisherID + RegisteredNodesFileSuffix, config.PublisherID + lib.AppConfigSuffix}
+		pub.configWatcher = lib.NewConfigWatcher(config.ConfigFolder, watchedFiles, pub.handleConfigChange)
+	}
+
+	if config.Pseudonymous {
+		// the pseudonym secret is persisted so pseudonyms remain stable
+		secretFile := path.Join(config.ConfigFolder, config.PublisherID+PseudonymSecretFileSuffix)
+		secret, err := nodes.LoadPseudonymSecret(secretFile)
+		if err != nil {
+			logrus.Errorf("NewPublisher: Pseudonyms change after restart: %s", err)
+		}
+		pub.pseudonyms = nodes.NewPseudonyms(secret)
+		if len(pub.config.PseudonymAttr) == 0 {
+			pub.config.PseudonymAttr = DefaultPseudonymAttr
+		}
+	}
 
 	// Load configuration of previously registered nodes from config
 	pub.LoadRegisteredNodes()
+	pub.LoadDeviceMap()
 
 	return pub
 }
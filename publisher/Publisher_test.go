@@ -1,13 +1,31 @@
 package publisher_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/iotdomain/iotdomain-go/audit"
+	"github.com/iotdomain/iotdomain-go/domainstats"
+	"github.com/iotdomain/iotdomain-go/homie"
+	"github.com/iotdomain/iotdomain-go/identities"
 	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/internal/perftest"
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/outputs"
 	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/recorder"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -64,6 +82,97 @@ func TestNewAppPublisher(t *testing.T) {
 	assert.Error(t, err) // no messenger config
 }
 
+func TestNewBootstrapPublisher(t *testing.T) {
+	configFolder, err := ioutil.TempDir("", "bootstrap")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+	configFolder = path.Join(configFolder, "config")
+
+	pub, err := publisher.NewBootstrapPublisher("bootstrapapp", configFolder, nil)
+	require.NoError(t, err)
+	require.NotNil(t, pub)
+	assert.Equal(t, types.LocalDomainID, pub.Domain())
+	_, err = os.Stat(path.Join(configFolder, "bootstrapapp"+publisher.RegisteredIdentityFileSuffix))
+	assert.NoError(t, err, "Expected a generated identity")
+
+	// an existing messenger configuration is used as is
+	err = ioutil.WriteFile(path.Join(configFolder, "messenger.yaml"),
+		[]byte("domain: test\nmessenger: DummyMessenger\n"), 0600)
+	require.NoError(t, err)
+	pub, err = publisher.NewBootstrapPublisher("bootstrapapp", configFolder, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "test", pub.Domain())
+}
+
+func TestCreateNodeForDevice(t *testing.T) {
+	configFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+	deviceConfig := &publisher.PublisherConfig{
+		ConfigFolder: configFolder,
+		Domain:       "test",
+		PublisherID:  "publisher2",
+	}
+	pub1 := publisher.NewPublisher(deviceConfig, messaging.NewDummyMessenger(msgConfig))
+	node1 := pub1.CreateNodeForDevice("usb0", types.NodeTypeMultisensor, "AA:BB:CC:DD:EE:FF")
+	require.NotNil(t, node1)
+	assert.Equal(t, "usb0", node1.HWID)
+
+	// after a restart the re-enumerated device is the same node
+	pub2 := publisher.NewPublisher(deviceConfig, messaging.NewDummyMessenger(msgConfig))
+	node2 := pub2.CreateNodeForDevice("usb1", types.NodeTypeMultisensor, "aa-bb-cc-dd-ee-ff", "SN1")
+	require.NotNil(t, node2)
+	assert.Equal(t, "usb0", node2.HWID)
+	assert.Nil(t, pub2.GetNodeByHWID("usb1"))
+	assert.Equal(t, node2, pub2.GetNodeByDeviceID("sn1"))
+	assert.Nil(t, pub2.GetNodeByDeviceID("unknown"))
+	assert.Equal(t, 1, len(pub2.GetDeviceMappings()))
+	assert.True(t, pub2.RemoveDeviceMapping("usb0"))
+	assert.Nil(t, pub2.GetNodeByDeviceID("sn1"))
+}
+
+func TestDomainPublishersCache(t *testing.T) {
+	cacheFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheFolder)
+	cacheConfig := &publisher.PublisherConfig{
+		ConfigFolder:             cacheFolder,
+		CacheFolder:              path.Join(cacheFolder, "cache"),
+		Domain:                   "test",
+		PublisherID:              "publisher2",
+		SaveDiscoveredNodes:      true,
+		SaveDiscoveredPublishers: true,
+	}
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub2 := publisher.NewPublisher(cacheConfig, testMessenger)
+	pub2.Start()
+	pub1.Start()
+	require.NotNil(t, pub2.GetPublisherKey(pub1.Address()))
+	pub1.Stop()
+	pub2.Stop()
+	_, err = os.Stat(path.Join(cacheConfig.CacheFolder, "publisher2"+publisher.DomainPublishersFileSuffix))
+	assert.NoError(t, err)
+	_, err = os.Stat(path.Join(cacheConfig.CacheFolder, "publisher2"+publisher.DomainNodesFileSuffix))
+	assert.NoError(t, err)
+
+	// after restart the publisher can verify senders before their identity is received again
+	pub3 := publisher.NewPublisher(cacheConfig, messaging.NewDummyMessenger(msgConfig))
+	pub3.Start()
+	assert.NotNil(t, pub3.GetPublisherKey(pub1.Address()))
+	pub3.Stop()
+
+	// expired cache entries are ignored
+	cacheConfig.CacheTTL = 1
+	time.Sleep(time.Millisecond * 1100)
+	pub4 := publisher.NewPublisher(cacheConfig, messaging.NewDummyMessenger(msgConfig))
+	err = pub4.LoadDomainPublishers()
+	assert.NoError(t, err)
+	assert.Nil(t, pub4.GetPublisherKey(pub1.Address()))
+	err = pub4.LoadDomainNodes()
+	assert.NoError(t, err)
+}
+
 func TestStartStop(t *testing.T) {
 	var testMessenger = messaging.NewDummyMessenger(msgConfig)
 	var pollHandlerCalled = false
@@ -93,6 +202,386 @@ func TestStartStop(t *testing.T) {
 
 }
 
+// Run stops in an orderly manner when the context ends and invokes the shutdown hooks in reverse order
+func TestRun(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	var hooks []string
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.AddShutdownHook("hook1", func() error {
+		hooks = append(hooks, "hook1")
+		return nil
+	})
+	pub1.AddShutdownHook("hook2", func() error {
+		hooks = append(hooks, "hook2")
+		return errors.New("hook2 failed")
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err := pub1.Run(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, []string{"hook2", "hook1"}, hooks)
+	statusAddr := identities.MakePublisherStatusAddress(pub1.Domain(), pub1.PublisherID())
+	var status types.PublisherStatusMessage
+	_, err = messaging.VerifySenderJWSSignature(testMessenger.FindLastPublication(statusAddr), &status, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, types.PublisherRunStateDisconnected, status.Status)
+
+	// hooks are only invoked when stopping a running publisher
+	pub1.Stop()
+	assert.Equal(t, 2, len(hooks))
+}
+
+// a slow poll handler must not block the heartbeat or the publication of updates. Stop waits for the
+// queued work to complete.
+func TestSlowPollHandler(t *testing.T) {
+	clock := lib.NewManualClock(time.Now())
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	polling := make(chan bool, 1)
+	releasePoll := make(chan bool)
+
+	config := *test1Config
+	config.PublishQueueSize = 1
+	pub1 := publisher.NewPublisher(&config, testMessenger)
+	pub1.SetPollInterval(1, func(pub *publisher.Publisher) {
+		polling <- true
+		<-releasePoll
+	})
+	pub1.Start()
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	clock.Add(time.Second)
+	select {
+	case <-polling:
+	case <-time.After(time.Second):
+		assert.Fail(t, "poll handler isn't invoked")
+	}
+
+	// updates are published by the heartbeat while the poll handler is busy
+	pub1.CreateOutput(node1ID, node1Output1Type, types.DefaultOutputInstance)
+	clock.Add(time.Second)
+	discoAddr := node1Base + "/" + string(node1Output1Type) + "/0/" + types.MessageTypeOutputDiscovery
+	assert.Eventually(t, func() bool {
+		return testMessenger.FindLastPublication(discoAddr) != ""
+	}, time.Second, time.Millisecond)
+	close(releasePoll)
+	pub1.Stop()
+}
+
+// after a lost connection is restored, the publisher republishes its status and identity
+func TestReconnect(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	var statusCount = 0
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	statusAddr := identities.MakePublisherStatusAddress(pub1.Domain(), pub1.PublisherID())
+	testMessenger.Subscribe(statusAddr, func(address string, message string) error {
+		statusCount++
+		return nil
+	})
+	pub1.Start()
+	assert.Equal(t, 1, statusCount)
+
+	// a reconnect without a lost connection doesn't republish
+	testMessenger.OnConnectionChange(true, nil)
+	assert.Equal(t, 1, statusCount)
+
+	testMessenger.OnConnectionChange(false, errors.New("connection lost"))
+	subscriptions := pub1.GetSubscriptions()
+	testMessenger.OnConnectionChange(true, nil)
+	assert.Equal(t, 2, statusCount)
+	assert.Equal(t, subscriptions, pub1.GetSubscriptions(), "Expected the same subscriptions after reconnect")
+	pub1.Stop()
+}
+
+// connection watchers are notified of the state of the connection and polling is paused while it is lost
+func TestConnectionWatchers(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	states := make([]messaging.ConnectionState, 0)
+	pub1.OnConnectionChange(func(state messaging.ConnectionState, err error) {
+		states = append(states, state)
+	})
+	// a panic in a watcher doesn't stop other watchers
+	pub1.OnConnectionChange(func(state messaging.ConnectionState, err error) {
+		panic("watcher panic")
+	})
+	// the heartbeat is driven by the clock
+	clock := lib.NewManualClock(time.Now())
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+	polls := make(chan bool, 100)
+	pub1.SetPollInterval(1, func(pub *publisher.Publisher) {
+		polls <- true
+	})
+	isPolled := func(timeout time.Duration) bool {
+		select {
+		case <-polls:
+			return true
+		case <-time.After(timeout):
+			return false
+		}
+	}
+	assert.Equal(t, messaging.ConnectionStateDisconnected, pub1.ConnectionState())
+	pub1.Start()
+	assert.Equal(t, messaging.ConnectionStateConnected, pub1.ConnectionState())
+	clock.Add(time.Second)
+	assert.True(t, isPolled(time.Second), "Expected a poll")
+
+	testMessenger.OnConnectionChange(false, errors.New("connection lost"))
+	assert.Equal(t, messaging.ConnectionStateConnecting, pub1.ConnectionState())
+	for i := 0; i < 3; i++ {
+		clock.Add(time.Second)
+	}
+	assert.False(t, isPolled(100*time.Millisecond), "Expected polling to be paused while the connection is lost")
+
+	testMessenger.OnConnectionChange(true, nil)
+	clock.Add(time.Second)
+	assert.True(t, isPolled(time.Second), "Expected polling to resume")
+	pub1.Stop()
+
+	// nil removes the watchers
+	pub1.OnConnectionChange(nil)
+	testMessenger.OnConnectionChange(true, nil)
+	assert.Equal(t, []messaging.ConnectionState{
+		messaging.ConnectionStateConnected,
+		messaging.ConnectionStateConnecting,
+		messaging.ConnectionStateConnected,
+		messaging.ConnectionStateDisconnected,
+	}, states)
+}
+
+func TestRequests(t *testing.T) {
+	const queryAddress = "test/publisher1/node1/$query"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.Start()
+	defer pub1.Stop()
+	pub1.ServeRequests(queryAddress, func(address string, payload string) (string, error) {
+		if payload == "panic" {
+			panic("request handler panic")
+		}
+		return "reply to " + payload, nil
+	})
+	reply, err := pub1.Request(queryAddress, "query", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "reply to query", reply)
+
+	// a handler that panics replies with an error
+	_, err = pub1.Request(queryAddress, "panic", time.Second)
+	assert.Error(t, err)
+
+	// requests are no longer served after the subscription is removed
+	pub1.RemoveSubscription(queryAddress)
+	_, err = pub1.Request(queryAddress, "query", 10*time.Millisecond)
+	assert.Error(t, err)
+}
+
+func TestSubscriptions(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.Start()
+	count := len(pub1.GetSubscriptions())
+	assert.NotZero(t, count)
+
+	pub1.Subscribe("test", "publisher2")
+	subscriptions := pub1.GetSubscriptions()
+	assert.Equal(t, count+3, len(subscriptions))
+	assert.Contains(t, subscriptions, "test/publisher2/+/$node")
+
+	pub1.RemoveSubscription("test/publisher2/+/$node")
+	assert.NotContains(t, pub1.GetSubscriptions(), "test/publisher2/+/$node")
+	pub1.Stop()
+}
+
+func TestCustomMessages(t *testing.T) {
+	type diagPayload struct {
+		Uptime int `json:"uptime"`
+	}
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.Start()
+
+	// only registered non-standard message types are allowed
+	err := pub1.PublishCustomMessage(node1Base, "$diag", diagPayload{}, false, false)
+	assert.Error(t, err)
+	assert.Error(t, pub1.RegisterMessageType(types.MessageTypeLatest))
+	assert.Error(t, pub1.RegisterMessageType("diag"))
+	assert.Error(t, pub1.RegisterMessageType("$diag/x"))
+	assert.NoError(t, pub1.RegisterMessageType("$diag"))
+	assert.Equal(t, []string{"$diag"}, pub1.GetMessageTypes())
+
+	var received *diagPayload
+	err = pub1.SubscribeToCustomMessage("test/+/+", "$diag", func(message *types.CustomMessage) {
+		assert.Equal(t, pub1.Address(), message.Sender)
+		received = &diagPayload{}
+		err := json.Unmarshal(message.Payload, received)
+		assert.NoError(t, err)
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, pub1.GetSubscriptions(), "test/+/+/$diag")
+
+	// signed and encrypted messages are both received
+	err = pub1.PublishCustomMessage(node1Base, "$diag", diagPayload{Uptime: 10}, false, false)
+	assert.NoError(t, err)
+	require.NotNil(t, received)
+	assert.Equal(t, 10, received.Uptime)
+	err = pub1.PublishCustomMessage(node1Base, "$diag", diagPayload{Uptime: 20}, false, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, received.Uptime)
+
+	// encryption requires a known publisher
+	err = pub1.PublishCustomMessage(node2Base, "$diag", diagPayload{}, false, true)
+	assert.Error(t, err)
+
+	// messages with a forged address are discarded
+	received = nil
+	testMessenger.OnReceive(node2Base+"/$diag", `{"address":"test/publisher1/node1/$diag","payload":{}}`)
+	assert.Nil(t, received)
+
+	pub1.UnsubscribeFromCustomMessage("test/+/+", "$diag")
+	assert.NotContains(t, pub1.GetSubscriptions(), "test/+/+/$diag")
+	pub1.Stop()
+}
+
+// TestHomieIntegration tests mirroring the publisher to Homie with an attached integration
+func TestHomieIntegration(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.AddIntegration(homie.NewHomieBridge(homie.HomieConfig{}, pub1.PublisherID(), testMessenger, pub1))
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+
+	// the bridge is started with the publisher and notified of publications
+	pub1.Start()
+	assert.Equal(t, homie.HomieStateReady, testMessenger.FindLastPublication("homie/publisher1/$state"))
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20.5")
+	pub1.PublishUpdates()
+	nodeID := homie.MakeHomieID(pub1.GetNodeByHWID(node1ID).NodeID)
+	assert.Equal(t, nodeID, testMessenger.FindLastPublication("homie/publisher1/$nodes"))
+	assert.Equal(t, "20.5", testMessenger.FindLastPublication("homie/publisher1/"+nodeID+"/temperature-0"))
+
+	pub1.Stop()
+	assert.Equal(t, homie.HomieStateDisconnected, testMessenger.FindLastPublication("homie/publisher1/$state"))
+}
+
+func TestMessageRecorder(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	configFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(configFolder)
+	archiveFolder := path.Join(configFolder, "archive")
+	config := &publisher.PublisherConfig{
+		ConfigFolder: configFolder,
+		Domain:       "test",
+		PublisherID:  "publisher3",
+	}
+	// the recorder wraps the messenger of the publisher and is closed after the publisher stops
+	messageRecorder := recorder.NewMessageRecorder(recorder.RecorderConfig{Folder: archiveFolder})
+	pub3 := publisher.NewPublisher(config, recorder.NewRecordingMessenger(testMessenger, messageRecorder))
+	pub3.Start()
+	pub3.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub3.PublishUpdates()
+	pub3.Stop()
+	err := messageRecorder.Close()
+	assert.NoError(t, err)
+
+	messages, err := recorder.NewArchiveReader(archiveFolder).ReadMessages("test/publisher3/#", time.Time{}, time.Now())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, messages)
+
+	reader := recorder.NewArchiveReader(archiveFolder)
+	published, err := reader.ReadMessages("test/publisher3/node1/$node", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.NotEmpty(t, published)
+	assert.Equal(t, recorder.DirectionPublished, published[0].Direction)
+	received, err := reader.ReadMessages("test/publisher3/$identity", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	directions := make([]string, 0)
+	for _, message := range received {
+		directions = append(directions, message.Direction)
+	}
+	assert.Contains(t, directions, recorder.DirectionReceived)
+}
+
+// TestDomainStatsIntegration tests counting received messages with a wrapped messenger and integration
+func TestDomainStatsIntegration(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	stats := domainstats.NewDomainStats(test1Config.Domain, test1Config.PublisherID, 60)
+	pub1 := publisher.NewPublisher(test1Config, domainstats.NewStatsMessenger(testMessenger, stats))
+	stats.SetMessageSigner(pub1.MessageSigner())
+	pub1.AddIntegration(stats)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.Start()
+	defer pub1.Stop()
+
+	// received messages that fail verification are counted
+	configureAddr := nodes.MakeNodeConfigureAddress(test1Config.Domain, test1Config.PublisherID, node1ID)
+	testMessenger.Publish(configureAddr, false, "not a signed message")
+	current := stats.GetStats()
+	assert.True(t, current.MessagesReceived > 0)
+	assert.Equal(t, 1, current.VerificationFailures)
+}
+
+// TestAuditLogIntegration tests auditing received commands with an attached integration
+func TestAuditLogIntegration(t *testing.T) {
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	auditFile := path.Join(folder, "audit.log")
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.AddIntegration(audit.NewAuditLog(audit.AuditConfig{File: auditFile}))
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.Start()
+
+	// received commands are recorded, including rejected commands
+	configureAddr := nodes.MakeNodeConfigureAddress(test1Config.Domain, test1Config.PublisherID, node1ID)
+	testMessenger.Publish(configureAddr, false, "not a signed message")
+	pub1.Stop()
+	records, err := ioutil.ReadFile(auditFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(records), configureAddr)
+}
+
+func TestRefresh(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	var statusCount = 0
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	statusAddr := identities.MakePublisherStatusAddress(pub1.Domain(), pub1.PublisherID())
+	testMessenger.Subscribe(statusAddr, func(address string, message string) error {
+		statusCount++
+		return nil
+	})
+	pub1.Start()
+	assert.Equal(t, 1, statusCount)
+
+	// a refresh request republishes the discovery
+	err := pub1.PublishRefresh()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, statusCount)
+	pub1.Stop()
+}
+
+func TestLastWill(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	node1 := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.SetLastWill("powerFailure", []string{node1ID, "unknownNode"})
+	pub1.Start()
+
+	statusAddr := identities.MakePublisherStatusAddress(pub1.Domain(), pub1.PublisherID())
+	var lastWill types.PublisherStatusMessage
+	testMessenger.Subscribe(statusAddr, func(address string, message string) error {
+		payload, err := messaging.VerifyJWSMessage(message, &pub1.GetIdentityKeys().PublicKey)
+		assert.NoError(t, err)
+		return json.Unmarshal([]byte(payload), &lastWill)
+	})
+	testMessenger.PublishLastWill(errors.New("connection lost"))
+	assert.Equal(t, types.PublisherRunStateLost, lastWill.Status)
+	assert.Equal(t, "powerFailure", lastWill.Reason)
+	assert.Equal(t, []string{node1.Address}, lastWill.OfflineNodes)
+	pub1.Stop()
+}
+
 func TestSetLogging(t *testing.T) {
 	var logFile = "/tmp/iotdomain-go.log"
 	// var testMessenger = messaging.NewDummyMessenger(msgConfig)
@@ -197,69 +686,1941 @@ func TestReceiveInput(t *testing.T) {
 	pub1.Stop()
 }
 
-func TestPublishEvent(t *testing.T) {
-	// setup
+// TestTransaction tests sending set input commands as a transaction
+// TestRoutedInputHandlers tests routing set commands to handlers by node and input type
+func TestRoutedInputHandlers(t *testing.T) {
 	var testMessenger = messaging.NewDummyMessenger(msgConfig)
 	pub1 := publisher.NewPublisher(test1Config, testMessenger)
-	node1 := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
-	// pub1.CreateInput(node1ID, node1InputType, types.DefaultInputInstance, nil)
+	pub1.Start()
+	defer pub1.Stop()
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance, nil)
+	pub1.CreateInput(node1ID, types.InputTypeChannel, types.DefaultInputInstance, nil)
+	received := make(map[types.InputType]string)
+	pub1.SetInputHandler(node1ID, types.InputTypeSwitch, "", func(input *types.InputDiscoveryMessage, sender string, value string) {
+		received[input.InputType] = "switch:" + value
+	})
+	pub1.SetDefaultInputHandler(func(input *types.InputDiscoveryMessage, sender string, value string) {
+		received[input.InputType] = "default:" + value
+	})
+	pub1.PublishUpdates()
+
+	err := pub1.PublishSetInput(node1Base+"/switch/0/"+types.MessageTypeSetInput, "on")
+	require.NoError(t, err)
+	err = pub1.PublishSetInput(node1Base+"/"+string(types.InputTypeChannel)+"/0/"+types.MessageTypeSetInput, "2")
+	require.NoError(t, err)
+	assert.Equal(t, "switch:on", received[types.InputTypeSwitch])
+	assert.Equal(t, "default:2", received[types.InputTypeChannel])
+
+	// a panic in a routed handler is recovered and reported in the node status
+	pub1.SetInputHandler(node1ID, types.InputTypeSwitch, "", func(input *types.InputDiscoveryMessage, sender string, value string) {
+		panic("test")
+	})
+	pub1.PublishSetInput(node1Base+"/switch/0/"+types.MessageTypeSetInput, "off")
+	node := pub1.GetNodeByHWID(node1ID)
+	require.NotNil(t, node)
+	assert.Equal(t, types.NodeRunStateError, node.Status[types.NodeStatusRunState])
+}
+
+func TestMessageHooks(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.Start()
+	defer pub1.Stop()
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	received := make([]string, 0)
+	pub1.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received = append(received, value)
+		})
+	pub1.PublishUpdates()
+	setAddr := node1Base + "/switch/0/" + types.MessageTypeSetInput
+
+	// the receive hook vetoes commands before they reach the input handler
+	pub1.UseReceiveHook(func(address string, object interface{}) bool {
+		setInput, isSetInput := object.(*types.SetInputMessage)
+		return !isSetInput || setInput.Value != "blocked"
+	})
+	pub1.PublishSetInput(setAddr, "on")
+	pub1.PublishSetInput(setAddr, "blocked")
+	assert.Equal(t, []string{"on"}, received)
+
+	// the publish hook vetoes commands before they are published
+	pub1.UsePublishHook(func(address string, object interface{}) (interface{}, bool) {
+		return object, address != setAddr
+	})
+	pub1.PublishSetInput(setAddr, "off")
+	assert.Equal(t, []string{"on"}, received)
+
+	// a panic in a hook is recovered and the message passes unchanged
+	pub1.UseReceiveHook(func(address string, object interface{}) bool {
+		panic("test")
+	})
+	pub1.CreateInput(node1ID, types.InputTypeChannel, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received = append(received, value)
+		})
+	pub1.PublishUpdates()
+	pub1.PublishSetInput(node1Base+"/"+string(types.InputTypeChannel)+"/0/"+types.MessageTypeSetInput, "2")
+	assert.Equal(t, []string{"on", "2"}, received)
+}
+
+func TestDryRun(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.Start()
+	defer pub1.Stop()
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	received := make([]string, 0)
+	pub1.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received = append(received, value)
+		})
 	pub1.CreateOutput(node1ID, node1Output1Type, types.DefaultOutputInstance)
-	err := pub1.PublishOutputEvent(node1)
+	pub1.SetDryRun(true)
+	assert.True(t, pub1.IsDryRun())
+	pub1.PublishUpdates()
+	setAddr := node1Base + "/switch/0/" + types.MessageTypeSetInput
+
+	// registration and publication of outputs continue in dry-run mode
+	pub1.UpdateOutputValue(node1ID, node1Output1Type, types.DefaultOutputInstance, "1")
+	assert.Equal(t, "1", pub1.GetOutputValueByNodeHWID(node1ID, node1Output1Type, types.DefaultOutputInstance).Value)
+	assert.NotEmpty(t, testMessenger.FindLastPublication(node1Base+"/"+string(node1Output1Type)+"/0/"+types.MessageTypeOutputDiscovery))
+
+	// commands are not published and received commands aren't executed
+	err := pub1.PublishSetInput(setAddr, "on")
 	assert.NoError(t, err)
-	// TODO: check result
+	assert.Empty(t, testMessenger.FindLastPublication(setAddr))
+	pub1.SetDryRun(false)
+	pub1.PublishSetInput(setAddr, "on")
+	assert.Equal(t, []string{"on"}, received)
+	pub1.SetDryRun(true)
+	pub1.PublishSetInput(setAddr, "off")
+	assert.Equal(t, []string{"on"}, received)
 }
 
-// run a bunch of facade commands with invalid arguments
-func TestErrors(t *testing.T) {
+func TestTransaction(t *testing.T) {
 	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	var node1InputBase = fmt.Sprintf("%s/%s/0", node1Base, node1InputType)
+	var rolledBack []string
 	pub1 := publisher.NewPublisher(test1Config, testMessenger)
-	pub1.Address()
-	pub1.CreateInput("fakeid", "faketype", types.DefaultInputInstance, nil)
-	pub1.CreateInputFromFile("fakeid", "faketype", types.DefaultInputInstance, "fakepath", nil)
-	pub1.CreateInputFromHTTP("fakeid", "faketype", types.DefaultInputInstance, "fakeurl", "", "", 0, nil)
-	pub1.CreateInputFromOutput("fakeid", "faketype", types.DefaultInputInstance, "fakeaddr", nil)
-	pub1.CreateNode("fakeid", types.NodeTypeAlarm)
-	out1 := pub1.CreateOutput("fakeid", types.OutputTypeAlarm, types.DefaultOutputInstance)
-	pub1.Domain()
-	pub1.GetDomainInputs()
-	pub1.GetDomainNodes()
-	pub1.GetDomainOutputs()
-	pub1.GetDomainPublishers()
-	pub1.GetDomainInput("fakeaddr")
-	pub1.GetDomainNode("fakeaddr")
-	pub1.GetDomainOutput("fakeaddr")
-	pub1.GetIdentity()
-	pub1.GetIdentityKeys()
-	pub1.GetInputByNodeHWID("fakenode", "", "")
-	pub1.GetInputs()
-	pub1.GetNodeAttr("fakenode", "fakeattr")
-	pub1.GetNodeByAddress("fakeaddr")
-	pub1.GetNodeByHWID("fakenode")
-	pub1.GetNodeByNodeID("fakenode")
-	pub1.GetNodeConfigBool("fakeid", "fakeattr", false)
-	pub1.GetNodeConfigFloat("fakeid", "fakeattr", 42.0)
-	pub1.GetNodeConfigInt("fakeid", "fakeattr", 42)
-	pub1.GetNodeConfigString("fakeid", "fakeattr", "fake")
-	pub1.GetNodes()
-	pub1.GetNodeStatus("fakeid", "fakeattr")
-	pub1.GetNodeStatus("doesntexist", "")
-	pub1.GetOutputByNodeHWID("fakenode", "", "")
-	pub1.GetOutputByID("fakeid")
-	pub1.GetOutputs()
-	pub1.GetOutputValueByNodeHWID("fakedevice", "faketype", "")
-	pub1.GetOutputValueByID("fakeid")
-	pub1.MakeNodeDiscoveryAddress("fakeid")
-	pub1.PublishNodeConfigure("fakeaddr", types.NodeAttrMap{})
-	pub1.PublishRaw(out1, true, "value")
-	pub1.SetNodeConfigHandler(nil)
-	pub1.SetSigningOnOff(true)
-	pub1.Subscribe("", "")
-	pub1.Unsubscribe("", "")
-	pub1.UpdateNodeErrorStatus("fakeid", types.NodeRunStateError, "fake status")
-	pub1.UpdateNodeAttr("fakeid", types.NodeAttrMap{})
-	pub1.UpdateNodeConfig("fakeid", types.NodeAttrName, nil)
-	pub1.UpdateNodeConfigValues("fakeid", types.NodeAttrMap{})
-	pub1.UpdateNodeStatus("fakeid", types.NodeStatusMap{})
-	pub1.UpdateOutput(nil)
-	pub1.UpdateOutputForecast("fakeid", []types.OutputValue{})
+	pub1.Start()
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.CreateOutput(node1ID, node1Output1Type, types.DefaultOutputInstance)
+	pub1.CreateInput(node1ID, node1InputType, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			// the switch only accepts on and off
+			if value == "on" || value == "off" {
+				pub1.UpdateOutputValue(node1ID, node1Output1Type, types.DefaultOutputInstance, value)
+				pub1.PublishUpdates()
+			}
+		})
+	pub1.PublishUpdates()
+	rollback := func(command *publisher.TransactionCommand, acknowledged bool) {
+		rolledBack = append(rolledBack, command.Value)
+	}
+
+	// the command is acknowledged when the output publishes the new value
+	result := pub1.RunTransaction([]*publisher.TransactionCommand{
+		{InputAddress: node1InputBase, Value: "on", Rollback: rollback},
+	}, time.Second)
+	assert.Equal(t, publisher.TransactionStatusSucceeded, result.Status)
+	assert.True(t, result.Commands[0].Acknowledged)
+	assert.Empty(t, rolledBack)
+
+	// sent commands are rolled back in reverse order when not all are acknowledged
+	result = pub1.RunTransaction([]*publisher.TransactionCommand{
+		{InputAddress: node1InputBase, Value: "off", Rollback: rollback},
+		{InputAddress: node1InputBase, Value: "dimmed", Rollback: rollback},
+		{InputAddress: node2Base + "/switch/0", Value: "on", Rollback: rollback},
+	}, time.Millisecond*100)
+	assert.Equal(t, publisher.TransactionStatusPartial, result.Status)
+	assert.True(t, result.Commands[0].Acknowledged)
+	assert.False(t, result.Commands[1].Acknowledged)
+	assert.Error(t, result.Commands[2].Error)
+	assert.Equal(t, []string{"dimmed", "off"}, rolledBack)
+
+	rolledBack = nil
+	result = pub1.RunTransaction([]*publisher.TransactionCommand{
+		{InputAddress: node1InputBase, Value: "dimmed", Rollback: rollback},
+	}, time.Millisecond*100)
+	assert.Equal(t, publisher.TransactionStatusTimedOut, result.Status)
+	assert.Equal(t, []string{"dimmed"}, rolledBack)
+	assert.NotContains(t, pub1.GetSubscriptions(), node1Base+"/switch/0/$latest")
+	pub1.Stop()
+}
+
+// TestCommandAcknowledgement tests waiting for the acknowledgement of set input and configure commands
+func TestCommandAcknowledgement(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	var node1InputSetAddr = fmt.Sprintf("%s/%s/0/%s", node1Base, node1InputType, types.MessageTypeSetInput)
+	var received = ""
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.Start()
+	node1 := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.CreateInput(node1ID, node1InputType, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received = value
+		})
+	pub1.PublishUpdates()
+
+	// the command is acknowledged after it is handled
+	ack, err := pub1.PublishSetInputAndWait(node1InputSetAddr, "on", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "on", received)
+	assert.Equal(t, types.AckStatusAccepted, ack.Status)
+	assert.Equal(t, "test/publisher1", ack.Sender)
+	assert.Equal(t, node1Base+"/switch/0/"+types.MessageTypeAck, ack.Address)
+	assert.NotContains(t, pub1.GetSubscriptions(), ack.Address)
+
+	ack, err = pub1.PublishNodeConfigureAndWait(node1.Address, types.NodeAttrMap{types.NodeAttrName: "bob"}, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, types.AckStatusAccepted, ack.Status)
+
+	// inputs of a deleted node reject commands
+	pub1.DeleteNode(node1ID)
+	ack, err = pub1.PublishSetInputAndWait(node1InputSetAddr, "off", time.Second)
+	assert.Error(t, err)
+	require.NotNil(t, ack)
+	assert.Equal(t, types.AckStatusRejected, ack.Status)
+	assert.Equal(t, "on", received)
+	ack, err = pub1.PublishNodeConfigureAndWait(node1.Address, types.NodeAttrMap{types.NodeAttrName: "bob"}, time.Second)
+	assert.Error(t, err)
+	require.NotNil(t, ack)
+	assert.Equal(t, types.AckStatusRejected, ack.Status)
+
+	// commands to unknown publishers or inputs are not acknowledged
+	_, err = pub1.PublishSetInputAndWait(node2Base+"/switch/0/"+types.MessageTypeSetInput, "on", time.Second)
+	assert.Error(t, err)
+	_, err = pub1.PublishSetInputAndWait(node1Base+"/switch/1/"+types.MessageTypeSetInput, "on", time.Millisecond*100)
+	assert.Error(t, err)
+	_, err = pub1.PublishNodeConfigureAndWait(node2Base+"/$node", types.NodeAttrMap{}, time.Second)
+	assert.Error(t, err)
+	pub1.Stop()
+}
+
+func TestCommandOutbox(t *testing.T) {
+	var node1InputSetAddr = fmt.Sprintf("%s/%s/0/%s", node1Base, node1InputType, types.MessageTypeSetInput)
+	cacheFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheFolder)
+	outboxConfig := *test1Config
+	outboxConfig.CacheFolder = cacheFolder
+	outboxConfig.ConfigFolder = cacheFolder
+	outboxConfig.OutboxRetryInterval = 1
+	// without input the commands are not acknowledged
+	outboxConfig.DisableInput = true
+	clock := lib.NewManualClock(time.Now())
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+
+	pub1 := publisher.NewPublisher(&outboxConfig, messaging.NewDummyMessenger(msgConfig))
+	pub1.Start()
+	messageID, err := pub1.PublishSetInputReliable(node1InputSetAddr, "on")
+	require.NoError(t, err)
+	_, err = pub1.PublishSetInputReliable("test/publisher1", "on")
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool {
+		clock.Add(time.Second)
+		outbox := pub1.GetOutbox()
+		return len(outbox) == 1 && outbox[0].Attempts > 1
+	}, time.Second, time.Millisecond, "Expected a resend")
+	outbox := pub1.GetOutbox()
+	require.Equal(t, 1, len(outbox))
+	assert.Equal(t, messageID, outbox[0].MessageID)
+	pub1.Stop()
+
+	// after a restart the journaled command is resent until acknowledged
+	outboxConfig.DisableInput = false
+	received := ""
+	var ackedCommand publisher.OutboxCommand
+	pub2 := publisher.NewPublisher(&outboxConfig, messaging.NewDummyMessenger(msgConfig))
+	pub2.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub2.CreateInput(node1ID, node1InputType, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received = value
+		})
+	pub2.SetOutboxHandler(func(command publisher.OutboxCommand, ack *types.AckMessage) {
+		ackedCommand = command
+		assert.Equal(t, types.AckStatusAccepted, ack.Status)
+	})
+	pub2.Start()
+	assert.Equal(t, "on", received)
+	assert.Equal(t, messageID, ackedCommand.MessageID)
+	assert.Equal(t, 0, len(pub2.GetOutbox()))
+
+	// configure commands are acknowledged on the node address
+	node1 := pub2.GetNodeByHWID(node1ID)
+	messageID, err = pub2.PublishNodeConfigureReliable(node1.Address, types.NodeAttrMap{types.NodeAttrName: "bob"})
+	require.NoError(t, err)
+	assert.Equal(t, messageID, ackedCommand.MessageID)
+	assert.Equal(t, 0, len(pub2.GetOutbox()))
+
+	// commands to unknown publishers remain in the outbox until cancelled
+	messageID, err = pub2.PublishSetInputReliable(node2Base+"/switch/0/"+types.MessageTypeSetInput, "on")
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(pub2.GetOutbox()))
+	assert.True(t, pub2.CancelOutboxCommand(messageID))
+	assert.False(t, pub2.CancelOutboxCommand(messageID))
+	assert.Equal(t, 0, len(pub2.GetOutbox()))
+
+	// commands that are not acknowledged within the max age expire
+	expired := make(chan publisher.OutboxCommand, 1)
+	pub2.SetOutboxHandler(func(command publisher.OutboxCommand, ack *types.AckMessage) {
+		assert.Equal(t, types.AckStatusRejected, ack.Status)
+		assert.Equal(t, publisher.OutboxErrorExpired, ack.Error)
+		expired <- command
+	})
+	messageID, err = pub2.PublishSetInputReliable(node2Base+"/switch/0/"+types.MessageTypeSetInput, "on")
+	require.NoError(t, err)
+	clock.Add(publisher.DefaultOutboxMaxAge * time.Second)
+	select {
+	case command := <-expired:
+		assert.Equal(t, messageID, command.MessageID)
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "Expected the command to expire")
+	}
+	assert.Equal(t, 0, len(pub2.GetOutbox()))
+	pub2.Stop()
+}
+
+func TestScheduledSetInput(t *testing.T) {
+	var node1InputSetAddr = fmt.Sprintf("%s/%s/0/%s", node1Base, node1InputType, types.MessageTypeSetInput)
+	cacheFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheFolder)
+	scheduleConfig := *test1Config
+	scheduleConfig.CacheFolder = cacheFolder
+	scheduleConfig.ConfigFolder = cacheFolder
+	received := make(chan string, 1)
+	// the heartbeat is driven by the clock
+	clock := lib.NewManualClock(time.Now())
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+
+	pub1 := publisher.NewPublisher(&scheduleConfig, messaging.NewDummyMessenger(msgConfig))
+	pub1.Start()
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.CreateInput(node1ID, node1InputType, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received <- value
+		})
+	messageID, err := pub1.PublishScheduledSetInput(node1InputSetAddr, "on", lib.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, received)
+	scheduled := pub1.GetScheduledCommands()
+	require.Len(t, scheduled, 1)
+	assert.Equal(t, messageID, scheduled[0].MessageID)
+	_, err = os.Stat(path.Join(cacheFolder, pub1.PublisherID()+publisher.ScheduledCommandsFileSuffix))
+	assert.NoError(t, err, "Expected the schedule to be saved")
+
+	assert.True(t, pub1.CancelScheduledCommand(messageID))
+	assert.Len(t, pub1.GetScheduledCommands(), 0)
+
+	// the heartbeat applies the command when its time has come
+	_, err = pub1.PublishScheduledSetInput(node1InputSetAddr, "off", lib.Now().Add(100*time.Millisecond))
+	require.NoError(t, err)
+	assert.Empty(t, received)
+	clock.Add(time.Second)
+	select {
+	case value := <-received:
+		assert.Equal(t, "off", value)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Expected the scheduled command to be applied")
+	}
+	pub1.Stop()
+}
+
+func TestSoftDeleteNode(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	var node1InputSetAddr = fmt.Sprintf("%s/%s/0/%s", node1Base, node1InputType, types.MessageTypeSetInput)
+	var received = 0
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.Start()
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.CreateInput(node1ID, node1InputType, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received++
+		})
+	pub1.PublishUpdates()
+
+	// inputs of a deleted node ignore commands
+	pub1.DeleteNode(node1ID)
+	assert.Nil(t, pub1.GetNodeByHWID(node1ID))
+	assert.Equal(t, 1, len(pub1.GetDeletedNodes()))
+	err := pub1.PublishSetInput(node1InputSetAddr, "true")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, received)
+
+	// a restored node accepts commands again
+	node := pub1.RestoreNode(node1ID)
+	require.NotNil(t, node)
+	assert.Equal(t, 0, len(pub1.GetDeletedNodes()))
+	err = pub1.PublishSetInput(node1InputSetAddr, "true")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, received)
+	assert.Nil(t, pub1.RestoreNode(node1ID))
+	pub1.Stop()
+}
+
+func TestPublishEvent(t *testing.T) {
+	// setup
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	node1 := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	// pub1.CreateInput(node1ID, node1InputType, types.DefaultInputInstance, nil)
+	pub1.CreateOutput(node1ID, node1Output1Type, types.DefaultOutputInstance)
+	pub1.Start()
+	defer pub1.Stop()
+	events := make([]types.OutputEventMessage, 0)
+	eventAddr := outputs.ReplaceMessageType(node1.Address, types.MessageTypeEvent)
+	pub1.SubscribeToOutputEvents("test/+/+/$event", func(event *types.OutputEventMessage) {
+		events = append(events, *event)
+	})
+	err := pub1.PublishNodeOutputsEvent(node1)
+	assert.NoError(t, err)
+	require.Equal(t, 1, len(events))
+	assert.Equal(t, eventAddr, events[0].Address)
+	assert.Contains(t, events[0].Event, string(node1Output1Type)+"/"+types.DefaultOutputInstance)
+
+	// discrete events have a type and payload
+	err = pub1.PublishOutputEvent(node1ID, types.OutputEventMotion, "zone1")
+	assert.NoError(t, err)
+	require.Equal(t, 2, len(events))
+	assert.Equal(t, types.OutputEventMotion, events[1].EventType)
+	assert.Equal(t, "zone1", events[1].Payload)
+	assert.Empty(t, events[1].Event)
+	err = pub1.PublishOutputEvent("unknownNode", types.OutputEventMotion, "")
+	assert.Error(t, err)
+	assert.Equal(t, 2, len(events))
+}
+
+func TestOutputValueOrdering(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	output := pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.Start()
+	defer pub1.Stop()
+	latestAddr := outputs.ReplaceMessageType(output.Address, types.MessageTypeLatest)
+	received := make([]types.OutputLatestMessage, 0)
+	pub1.SubscribeToOutputLatest("test/+/+/+/+/$latest", func(latest *types.OutputLatestMessage) {
+		received = append(received, *latest)
+	})
+
+	// published values have an increasing sequence nr
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub1.PublishUpdates()
+	olderMessage := testMessenger.FindLastPublication(latestAddr)
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "21")
+	pub1.PublishUpdates()
+	require.Equal(t, 2, len(received))
+	assert.Equal(t, "21", received[1].Value)
+	assert.Greater(t, received[1].Sequence, received[0].Sequence)
+
+	// an older value received after a newer value is discarded
+	testMessenger.Publish(latestAddr, true, olderMessage)
+	assert.Equal(t, 2, len(received))
+}
+
+func TestOutputPublishHooks(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	var publishedValue = ""
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	node1 := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	output := pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.SetOutputPublishHooks(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance,
+		func(output *types.OutputDiscoveryMessage, value string) (string, bool) {
+			return value + "0", value != "veto"
+		},
+		func(output *types.OutputDiscoveryMessage, value string, err error) {
+			publishedValue = value
+		})
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "2")
+	pub1.PublishUpdates()
+	assert.Equal(t, "20", publishedValue)
+	latestAddr := outputs.ReplaceMessageType(output.Address, types.MessageTypeLatest)
+	latest, err := messaging.VerifyJWSMessage(testMessenger.FindLastPublication(latestAddr), &pub1.GetIdentityKeys().PublicKey)
+	assert.NoError(t, err)
+	assert.Contains(t, latest, `"20"`)
+
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "veto")
+	pub1.PublishUpdates()
+	assert.Equal(t, "20", publishedValue)
+	latest, _ = messaging.VerifyJWSMessage(testMessenger.FindLastPublication(latestAddr), &pub1.GetIdentityKeys().PublicKey)
+	assert.NotContains(t, latest, "veto")
+
+	// the history and event hold the modified values and leave out vetoed values
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "3")
+	pub1.PublishUpdates()
+	historyAddr := outputs.ReplaceMessageType(output.Address, types.MessageTypeHistory)
+	history, err := messaging.VerifyJWSMessage(testMessenger.FindLastPublication(historyAddr), &pub1.GetIdentityKeys().PublicKey)
+	assert.NoError(t, err)
+	assert.Contains(t, history, `"30"`)
+	assert.Contains(t, history, `"20"`)
+	assert.NotContains(t, history, `"3"`)
+	assert.NotContains(t, history, "veto")
+	eventAddr := outputs.ReplaceMessageType(node1.Address, types.MessageTypeEvent)
+	pub1.PublishNodeOutputsEvent(node1)
+	event, err := messaging.VerifyJWSMessage(testMessenger.FindLastPublication(eventAddr), &pub1.GetIdentityKeys().PublicKey)
+	assert.NoError(t, err)
+	assert.Contains(t, event, `"30"`)
+}
+
+func TestOutputHistoryRetention(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	outputID := outputs.MakeOutputID(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+
+	pub1.SetOutputHistoryRetention(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance,
+		&outputs.HistoryRetention{MaxCount: 4})
+	for _, value := range []string{"1", "2", "3", "4", "5", "6", "7", "8"} {
+		pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, value)
+	}
+	assert.LessOrEqual(t, len(pub1.GetOutputHistory(outputID)), 4)
+
+	// node configuration overrides the output retention once the updated node is published
+	pub1.UpdateNodeConfig(node1ID, types.NodeAttrHistoryMaxCount, &types.ConfigAttr{DataType: types.DataTypeInt})
+	pub1.UpdateNodeConfigValues(node1ID, types.NodeAttrMap{types.NodeAttrHistoryMaxCount: "2"})
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "10")
+	assert.Greater(t, len(pub1.GetOutputHistory(outputID)), 2)
+	pub1.PublishUpdates()
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "9")
+	history := pub1.GetOutputHistory(outputID)
+	assert.LessOrEqual(t, len(history), 2)
+	assert.Equal(t, "9", history[0].Value)
+}
+
+func TestOutputValueTimestamps(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	output := pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	measured := time.Now().Add(-time.Minute)
+	pub1.UpdateOutputValueAt(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20", measured)
+	pub1.PublishUpdates()
+
+	// the latest value carries the time of measurement and of publication
+	output = pub1.GetOutputByID(output.OutputID)
+	raw := testMessenger.FindLastPublication(outputs.ReplaceMessageType(output.Address, types.MessageTypeLatest))
+	payload, err := messaging.VerifyJWSMessage(raw, &pub1.GetIdentityKeys().PublicKey)
+	require.NoError(t, err)
+	var latest types.OutputLatestMessage
+	err = json.Unmarshal([]byte(payload), &latest)
+	require.NoError(t, err)
+	assert.Equal(t, measured.Format(types.TimeFormat), latest.Timestamp)
+	assert.NotEmpty(t, latest.Published)
+	assert.NotEqual(t, latest.Timestamp, latest.Published)
+}
+
+// run a bunch of facade commands with invalid arguments
+func TestErrors(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.Address()
+	pub1.CreateInput("fakeid", "faketype", types.DefaultInputInstance, nil)
+	pub1.CreateInputFromFile("fakeid", "faketype", types.DefaultInputInstance, "fakepath", nil)
+	pub1.CreateInputFromHTTP("fakeid", "faketype", types.DefaultInputInstance, "fakeurl", "", "", 0, nil)
+	pub1.CreateInputFromOutput("fakeid", "faketype", types.DefaultInputInstance, "fakeaddr", nil)
+	pub1.CreateNode("fakeid", types.NodeTypeAlarm)
+	out1 := pub1.CreateOutput("fakeid", types.OutputTypeAlarm, types.DefaultOutputInstance)
+	pub1.Domain()
+	pub1.GetDomainInputs()
+	pub1.GetDomainNodes()
+	pub1.GetDomainOutputs()
+	pub1.GetDomainPublishers()
+	pub1.GetDomainInput("fakeaddr")
+	pub1.GetDomainNode("fakeaddr")
+	pub1.GetDomainOutput("fakeaddr")
+	pub1.GetIdentity()
+	pub1.GetIdentityKeys()
+	pub1.GetInputByNodeHWID("fakenode", "", "")
+	pub1.GetInputs()
+	pub1.GetNodeAttr("fakenode", "fakeattr")
+	pub1.GetNodeByAddress("fakeaddr")
+	pub1.GetNodeByHWID("fakenode")
+	pub1.GetNodeByNodeID("fakenode")
+	pub1.GetNodeConfigBool("fakeid", "fakeattr", false)
+	pub1.GetNodeConfigFloat("fakeid", "fakeattr", 42.0)
+	pub1.GetNodeConfigInt("fakeid", "fakeattr", 42)
+	pub1.GetNodeConfigString("fakeid", "fakeattr", "fake")
+	pub1.GetNodes()
+	pub1.GetNodeStatus("fakeid", "fakeattr")
+	pub1.GetNodeStatus("doesntexist", "")
+	pub1.GetOutputByNodeHWID("fakenode", "", "")
+	pub1.GetOutputByID("fakeid")
+	pub1.GetOutputs()
+	pub1.GetOutputValueByNodeHWID("fakedevice", "faketype", "")
+	pub1.GetOutputValueByID("fakeid")
+	pub1.MakeNodeDiscoveryAddress("fakeid")
+	pub1.PublishNodeConfigure("fakeaddr", types.NodeAttrMap{})
+	pub1.PublishRaw(out1, true, "value")
+	pub1.SetNodeConfigHandler(nil)
+	pub1.SetSigningOnOff(true)
+	pub1.Subscribe("", "")
+	pub1.Unsubscribe("", "")
+	pub1.UpdateNodeErrorStatus("fakeid", types.NodeRunStateError, "fake status")
+	pub1.UpdateNodeAttr("fakeid", types.NodeAttrMap{})
+	pub1.UpdateNodeConfig("fakeid", types.NodeAttrName, nil)
+	pub1.UpdateNodeConfigValues("fakeid", types.NodeAttrMap{})
+	pub1.UpdateNodeStatus("fakeid", types.NodeStatusMap{})
+	pub1.UpdateOutput(nil)
+	pub1.UpdateOutputForecast("fakeid", []types.OutputValue{})
+}
+
+func TestPseudonymousMode(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	configFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(configFolder)
+	config := &publisher.PublisherConfig{
+		ConfigFolder: configFolder,
+		Domain:       "test",
+		PublisherID:  "publisher3",
+		Pseudonymous: true,
+	}
+	pub3 := publisher.NewPublisher(config, testMessenger)
+	node := pub3.CreateNode(node1ID, types.NodeTypeUnknown)
+	require.NotNil(t, node)
+	assert.NotEqual(t, node1ID, node.NodeID)
+	value, exists := pub3.ResolvePseudonym(node.NodeID)
+	assert.True(t, exists)
+	assert.Equal(t, node1ID, value)
+
+	output := pub3.CreateOutput(node1ID, node1Output1Type, types.DefaultOutputInstance)
+	assert.Contains(t, output.Address, node.NodeID)
+	input := pub3.CreateInput(node1ID, node1InputType, types.DefaultInputInstance, nil)
+	assert.Contains(t, input.Address, node.NodeID)
+
+	pub3.UpdateNodeAttr(node1ID, types.NodeAttrMap{types.NodeAttrName: "bob"})
+	pub3.PublishUpdates()
+	raw := testMessenger.FindLastPublication(node.Address)
+	assert.NotContains(t, raw, "bob")
+	assert.NotContains(t, raw, `"hwID":"`+node1ID+`"`)
+
+	// pseudonyms remain the same after a restart
+	pub3b := publisher.NewPublisher(config, testMessenger)
+	node3b := pub3b.CreateNode(node1ID, types.NodeTypeUnknown)
+	assert.Equal(t, node.NodeID, node3b.NodeID)
+}
+
+func TestManagementAPI(t *testing.T) {
+	configFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+	mgmtConfig := *test1Config
+	mgmtConfig.ConfigFolder = configFolder
+	mgmtConfig.CacheFolder = configFolder
+
+	pub1 := publisher.NewPublisher(&mgmtConfig, messaging.NewDummyMessenger(msgConfig))
+	managementAPI := publisher.NewManagementAPI(pub1, "127.0.0.1:0", "secret")
+	pub1.AddIntegration(managementAPI)
+	node := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.UpdateNodeConfig(node1ID, types.NodeAttrName, &types.ConfigAttr{DataType: types.DataTypeString})
+	pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "21")
+	pub1.Start()
+	defer pub1.Stop()
+	baseURL := "http://" + managementAPI.Address()
+
+	request := func(method string, path string, token string, body string) (int, string) {
+		req, _ := http.NewRequest(method, baseURL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(respBody)
+	}
+
+	// requests require the token
+	status, _ := request(http.MethodGet, publisher.ManagementPathNodes, "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, body := request(http.MethodGet, publisher.ManagementPathNodes, "secret", "")
+	require.Equal(t, http.StatusOK, status)
+	var nodeList []types.NodeDiscoveryMessage
+	json.Unmarshal([]byte(body), &nodeList)
+	require.Equal(t, 1, len(nodeList))
+	assert.Equal(t, node.Address, nodeList[0].Address)
+
+	status, body = request(http.MethodGet, publisher.ManagementPathValues, "secret", "")
+	require.Equal(t, http.StatusOK, status)
+	var values []publisher.ManagementOutputValue
+	json.Unmarshal([]byte(body), &values)
+	require.Equal(t, 1, len(values))
+	assert.Equal(t, "21", values[0].Value)
+
+	status, body = request(http.MethodGet, publisher.ManagementPathIdentity, "secret", "")
+	require.Equal(t, http.StatusOK, status)
+	var identStatus publisher.ManagementIdentityStatus
+	json.Unmarshal([]byte(body), &identStatus)
+	assert.Equal(t, mgmtConfig.PublisherID, identStatus.PublisherID)
+	assert.True(t, identStatus.Running)
+
+	// configure a node
+	status, _ = request(http.MethodPut, publisher.ManagementPathNodes+"/"+node1ID+"/config", "secret",
+		`{"name":"kitchen"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "kitchen", pub1.GetNodeAttr(node1ID, types.NodeAttrName))
+	status, _ = request(http.MethodPut, publisher.ManagementPathNodes+"/unknown/config", "secret", `{}`)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// change the log level
+	prevLevel := logrus.GetLevel()
+	status, _ = request(http.MethodPut, publisher.ManagementPathLoglevel, "secret", `{"loglevel":"debug"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+	logrus.SetLevel(prevLevel)
+	status, _ = request(http.MethodPut, publisher.ManagementPathLoglevel, "secret", `{"loglevel":"loud"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestConfigHotReload(t *testing.T) {
+	configFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+	reloadConfig := *test1Config
+	reloadConfig.ConfigFolder = configFolder
+	reloadConfig.CacheFolder = configFolder
+	reloadConfig.WatchConfig = true
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	delay := time.Millisecond * (lib.ConfigWatcherDelay + 300)
+
+	pub1 := publisher.NewPublisher(&reloadConfig, messenger)
+	reloaded := make(chan string, 1)
+	pub1.SetConfigReloadHandler(func(filename string) {
+		reloaded <- filename
+	})
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.Start()
+	defer pub1.Stop()
+	// nodes saved by the publisher are not reloaded
+	pub1.PublishUpdates()
+	time.Sleep(delay)
+
+	// an edited nodes file is applied and published
+	nodesFile := path.Join(configFolder, reloadConfig.PublisherID+publisher.RegisteredNodesFileSuffix)
+	jsonText, err := ioutil.ReadFile(nodesFile)
+	require.NoError(t, err)
+	nodeList := make([]*types.NodeDiscoveryMessage, 0)
+	json.Unmarshal(jsonText, &nodeList)
+	require.Equal(t, 1, len(nodeList))
+	nodeList[0].Attr[types.NodeAttrName] = "edited"
+	jsonText, _ = json.Marshal(nodeList)
+	ioutil.WriteFile(nodesFile, jsonText, 0600)
+	time.Sleep(delay)
+	assert.Equal(t, "edited", pub1.GetNodeAttr(node1ID, types.NodeAttrName))
+	nodeAddr := pub1.GetNodeByHWID(node1ID).Address
+	var publishedNode types.NodeDiscoveryMessage
+	_, err = messaging.VerifySenderJWSSignature(messenger.FindLastPublication(nodeAddr), &publishedNode, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "edited", publishedNode.Attr[types.NodeAttrName])
+
+	// an edited application configuration is passed to the reload handler
+	appFile := reloadConfig.PublisherID + lib.AppConfigSuffix
+	ioutil.WriteFile(path.Join(configFolder, appFile), []byte("loglevel: error\n"), 0600)
+	select {
+	case filename := <-reloaded:
+		assert.Equal(t, appFile, filename)
+	case <-time.After(delay * 2):
+		assert.Fail(t, "Expected the reload handler to be invoked")
+	}
+	assert.Equal(t, logrus.ErrorLevel, logrus.GetLevel())
+	logrus.SetLevel(logrus.InfoLevel)
+}
+
+func TestNodePublishIntervals(t *testing.T) {
+	configFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+	intervalConfig := *test1Config
+	intervalConfig.ConfigFolder = configFolder
+	intervalConfig.CacheFolder = configFolder
+	clock := lib.NewManualClock(time.Now())
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(&intervalConfig, messenger)
+
+	node := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	output := pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.SetNodePublishIntervals(node1ID, 1, 1)
+	latestAddr := outputs.ReplaceMessageType(output.Address, types.MessageTypeLatest)
+	latestCount := 0
+	nodeCount := 0
+	messenger.Subscribe(latestAddr, func(address string, message string) error {
+		latestCount++
+		return nil
+	})
+	messenger.Subscribe(node.Address, func(address string, message string) error {
+		nodeCount++
+		return nil
+	})
+
+	// the first value is published immediately, updates within the interval are deferred
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub1.PublishUpdates()
+	assert.Equal(t, 1, latestCount)
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "21")
+	pub1.PublishUpdates()
+	assert.Equal(t, 1, latestCount)
+	nodeCountBefore := nodeCount
+
+	// after the interval the deferred value and the discovery are published
+	clock.Add(time.Millisecond * 1100)
+	pub1.PublishUpdates()
+	assert.Equal(t, 2, latestCount)
+	assert.Equal(t, "21", pub1.GetOutputValueByID(output.OutputID).Value)
+	assert.Equal(t, nodeCountBefore+1, nodeCount)
+
+	// the intervals are configurable
+	pub1.UpdateNodeConfigValues(node1ID, types.NodeAttrMap{types.NodeAttrPublishInterval: "0"})
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "22")
+	pub1.PublishUpdates()
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "23")
+	pub1.PublishUpdates()
+	assert.Equal(t, 4, latestCount)
+}
+
+func TestErrorHandler(t *testing.T) {
+	configFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+	errorConfig := *test1Config
+	errorConfig.ConfigFolder = configFolder
+	errorConfig.CacheFolder = configFolder
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(&errorConfig, messenger)
+	reported := make([]publisher.PublisherError, 0)
+	pub1.SetErrorHandler(func(pubErr publisher.PublisherError) {
+		reported = append(reported, pubErr)
+	})
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.Start()
+	defer pub1.Stop()
+
+	// a message with an invalid signature is reported as a signature error
+	configureAddr := nodes.MakeNodeConfigureAddress(errorConfig.Domain, errorConfig.PublisherID, node1ID)
+	messenger.Publish(configureAddr, false, "not a signed message")
+	require.NotEmpty(t, reported)
+	assert.Equal(t, publisher.ErrorCategorySignature, reported[len(reported)-1].Category)
+	assert.NotEmpty(t, reported[len(reported)-1].Error())
+
+	// failure to save is reported as a persistence error
+	errorConfig.CacheFolder = path.Join(configFolder, publisher.RegisteredNodesFileSuffix)
+	ioutil.WriteFile(errorConfig.CacheFolder, []byte("not a folder"), 0600)
+	pub2 := publisher.NewPublisher(&errorConfig, messenger)
+	pub2.SetErrorHandler(func(pubErr publisher.PublisherError) {
+		reported = append(reported, pubErr)
+	})
+	err = pub2.SaveDomainNodes()
+	assert.Error(t, err)
+	assert.Equal(t, publisher.ErrorCategoryPersistence, reported[len(reported)-1].Category)
+	assert.Error(t, errors.Unwrap(reported[len(reported)-1]))
+
+	// errors are no longer reported after the handler is removed
+	count := len(reported)
+	pub2.SetErrorHandler(nil)
+	pub2.SaveDomainNodes()
+	assert.Equal(t, count, len(reported))
+}
+
+func TestHandlerPanic(t *testing.T) {
+	configFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+	panicConfig := *test1Config
+	panicConfig.ConfigFolder = configFolder
+	panicConfig.CacheFolder = configFolder
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(&panicConfig, messenger)
+	reported := make([]publisher.PublisherError, 0)
+	pub1.SetErrorHandler(func(pubErr publisher.PublisherError) {
+		reported = append(reported, pubErr)
+	})
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	output := pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.SetOutputPublishHooks(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance,
+		func(output *types.OutputDiscoveryMessage, value string) (string, bool) {
+			panic("before publish failed")
+		}, nil)
+
+	// the panic is recovered, reported and vetoes the publication
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	assert.NotPanics(t, pub1.PublishUpdates)
+	require.Equal(t, 1, len(reported))
+	assert.Equal(t, publisher.ErrorCategoryHandlerPanic, reported[0].Category)
+	assert.Equal(t, output.Address, reported[0].Address)
+	latestAddr := outputs.ReplaceMessageType(output.Address, types.MessageTypeLatest)
+	assert.Empty(t, messenger.FindLastPublication(latestAddr))
+
+	// the node has an error status
+	runState, _ := pub1.GetNodeStatus(node1ID, types.NodeStatusRunState)
+	assert.Equal(t, types.NodeRunStateError, runState)
+}
+
+func TestDerivedOutputs(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, messenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.CreateOutput(node1ID, types.OutputTypeVoltage, types.DefaultOutputInstance)
+	pub1.CreateOutput(node1ID, types.OutputTypeElectricCurrent, types.DefaultOutputInstance)
+	power, err := pub1.CreateDerivedOutput(node1ID, types.OutputTypeElectricPower, types.DefaultOutputInstance, "voltage * current")
+	require.NoError(t, err)
+	// derived outputs can refer to other derived outputs
+	_, err = pub1.CreateDerivedOutput(node1ID, types.OutputTypeValue, "kw", "power / 1000")
+	require.NoError(t, err)
+
+	// the derived value is published with the values it is derived from
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeVoltage, types.DefaultOutputInstance, "230")
+	pub1.PublishUpdates()
+	assert.Nil(t, pub1.GetOutputValueByNodeHWID(node1ID, types.OutputTypeElectricPower, types.DefaultOutputInstance))
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeElectricCurrent, types.DefaultOutputInstance, "2.5")
+	pub1.PublishUpdates()
+	rawAddr := outputs.ReplaceMessageType(power.Address, types.MessageTypeRaw)
+	assert.NotEmpty(t, messenger.FindLastPublication(rawAddr))
+	assert.Equal(t, "575", pub1.GetOutputValueByNodeHWID(node1ID, types.OutputTypeElectricPower, types.DefaultOutputInstance).Value)
+	kw := pub1.GetOutputValueByNodeHWID(node1ID, types.OutputTypeValue, "kw")
+	require.NotNil(t, kw)
+	assert.Equal(t, "0.575", kw.Value)
+
+	// the expression is a node configuration
+	attrName := outputs.MakeOutputExpressionAttr(types.OutputTypeElectricPower, types.DefaultOutputInstance)
+	pub1.UpdateNodeConfigValues(node1ID, types.NodeAttrMap{attrName: "voltage * current * 2"})
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeElectricCurrent, types.DefaultOutputInstance, "2")
+	pub1.PublishUpdates()
+	assert.Equal(t, "920", pub1.GetOutputValueByNodeHWID(node1ID, types.OutputTypeElectricPower, types.DefaultOutputInstance).Value)
+
+	// error cases
+	_, err = pub1.CreateDerivedOutput(node1ID, types.OutputTypeElectricEnergy, types.DefaultOutputInstance, "power *")
+	assert.Error(t, err)
+	_, err = pub1.CreateDerivedOutput(node1ID, types.OutputTypeElectricEnergy, types.DefaultOutputInstance, "energy + power")
+	assert.Error(t, err)
+}
+
+func TestEnergyOutputs(t *testing.T) {
+	cacheFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(cacheFolder)
+	clock := lib.NewManualClock(time.Date(2020, 6, 1, 12, 0, 0, 0, time.Local))
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+	config := *test1Config
+	config.CacheFolder = cacheFolder
+
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(&config, messenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.CreateOutput(node1ID, types.OutputTypeElectricPower, types.DefaultOutputInstance)
+	options := outputs.EnergyOptions{Tariffs: []outputs.EnergyTariff{{Name: "night", After: "23:00", Before: "07:00"}}}
+	energyOutputs, err := pub1.CreateEnergyOutputs(node1ID, types.DefaultOutputInstance, options)
+	require.NoError(t, err)
+	assert.Len(t, energyOutputs, 4)
+	assert.Equal(t, types.UnitKWH, energyOutputs[0].Unit)
+
+	// power holds until it changes
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeElectricPower, types.DefaultOutputInstance, "2000")
+	pub1.PublishUpdates()
+	clock.Add(30 * time.Minute)
+	pub1.PublishUpdates()
+	total := pub1.GetOutputValueByNodeHWID(node1ID, types.OutputTypeElectricEnergy, outputs.EnergyInstanceTotal)
+	require.NotNil(t, total)
+	assert.Equal(t, "1.000", total.Value)
+	day := pub1.GetOutputValueByNodeHWID(node1ID, types.OutputTypeElectricEnergy, outputs.EnergyInstanceDay)
+	assert.Equal(t, "1.000", day.Value)
+	night := pub1.GetOutputValueByNodeHWID(node1ID, types.OutputTypeElectricEnergy, "night")
+	assert.Equal(t, "0.000", night.Value)
+
+	// the counters continue after a restart
+	err = pub1.SaveEnergyCounters()
+	require.NoError(t, err)
+	pub2 := publisher.NewPublisher(&config, messenger)
+	pub2.CreateNode(node1ID, types.NodeTypeUnknown)
+	_, err = pub2.CreateEnergyOutputs(node1ID, types.DefaultOutputInstance, options)
+	require.NoError(t, err)
+	counters := pub2.GetEnergyCounters(node1ID, types.DefaultOutputInstance)
+	require.NotNil(t, counters)
+	assert.InDelta(t, 1, counters.Total, 0.0001)
+	assert.Nil(t, pub2.GetEnergyCounters(node1ID, "1"))
+
+	// power is accumulated up to its max age and the day counter rolls over at midnight
+	clock.Add(12 * time.Hour)
+	pub1.PublishUpdates()
+	counters = pub1.GetEnergyCounters(node1ID, types.DefaultOutputInstance)
+	assert.InDelta(t, 4, counters.Total, 0.0001)
+	assert.Equal(t, "2020-06-02", counters.Day)
+	assert.InDelta(t, 0, counters.DayEnergy, 0.0001)
+
+	_, err = pub1.CreateEnergyOutputs(node1ID, "1", outputs.EnergyOptions{Tariffs: []outputs.EnergyTariff{{Name: "day"}}})
+	assert.Error(t, err)
+}
+
+func TestInputTransform(t *testing.T) {
+	const rawAddr = "test/publisher2/sensor/temperature/0/$raw"
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, messenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	received := ""
+	pub1.CreateInputFromOutput(node1ID, types.InputTypeTemperature, types.DefaultInputInstance, rawAddr,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received = value
+		})
+	pub1.SetInputTransform(node1ID, types.InputTypeTemperature, types.DefaultInputInstance, inputs.InputTransform{
+		{Op: inputs.TransformJSON, Arg: "main.temp"},
+		{Op: inputs.TransformOffset, Arg: "-273"},
+	})
+	messenger.Publish(rawAddr, false, `{"main":{"temp":293}}`)
+	assert.Equal(t, "20", received)
+
+	// the transformation is a node configuration
+	attrName := inputs.MakeInputTransformAttr(types.InputTypeTemperature, types.DefaultInputInstance)
+	pub1.UpdateNodeConfigValues(node1ID, types.NodeAttrMap{attrName: `[{"op":"multiply","arg":"10"}]`})
+	messenger.Publish(rawAddr, false, "2.5")
+	assert.Equal(t, "25", received)
+
+	// values that fail the transformation are discarded
+	messenger.Publish(rawAddr, false, "not a number")
+	assert.Equal(t, "25", received)
+}
+
+func TestPairing(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, messenger)
+	received := make([]types.PairMessage, 0)
+	pub1.SetPairHandler(func(message *types.PairMessage) {
+		received = append(received, *message)
+		if message.Action == types.PairActionStart {
+			pub1.AddPairCandidate(types.PairCandidate{CandidateID: "zwave-5", NodeType: types.NodeTypeMultisensor})
+		}
+	})
+	pub1.Start()
+	defer pub1.Stop()
+	statusAddr := nodes.MakePairStatusAddress(pub1.Domain(), pub1.PublisherID())
+
+	// start inclusion mode and report the discovered candidate
+	err := pub1.PublishPairCommand(pub1.PublisherID(), types.PairActionStart, "", 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(received))
+	assert.Equal(t, pub1.Address(), received[0].Sender)
+	assert.True(t, pub1.IsPairing())
+	require.Equal(t, 1, len(pub1.GetPairCandidates()))
+	assert.NotEmpty(t, pub1.GetPairCandidates()[0].Discovered)
+	assert.NotEmpty(t, messenger.FindLastPublication(statusAddr))
+
+	// unknown candidates are ignored
+	pub1.PublishPairCommand(pub1.PublisherID(), types.PairActionAdopt, "unknown", 0)
+	assert.Equal(t, 1, len(received))
+
+	// adopting removes the candidate
+	pub1.PublishPairCommand(pub1.PublisherID(), types.PairActionAdopt, "zwave-5", 0)
+	require.Equal(t, 2, len(received))
+	assert.Equal(t, "zwave-5", received[1].CandidateID)
+	assert.Empty(t, pub1.GetPairCandidates())
+
+	pub1.PublishPairCommand(pub1.PublisherID(), types.PairActionStop, "", 0)
+	assert.Equal(t, 3, len(received))
+	assert.False(t, pub1.IsPairing())
+
+	// a panic in the handler is recovered
+	pub1.SetPairHandler(func(message *types.PairMessage) {
+		panic("pairing failed")
+	})
+	assert.NotPanics(t, func() {
+		pub1.PublishPairCommand(pub1.PublisherID(), types.PairActionStart, "", 0)
+	})
+	assert.True(t, pub1.IsPairing())
+}
+
+func TestRetainedRefresh(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	refreshConfig := *test1Config
+	refreshConfig.RetainedRefreshInterval = 1
+	refreshConfig.RetainedRefreshRate = 100
+	clock := lib.NewManualClock(time.Now())
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+	pub1 := publisher.NewPublisher(&refreshConfig, messenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	output := pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	latestAddr := outputs.ReplaceMessageType(output.Address, types.MessageTypeLatest)
+	latestCount := 0
+	var countMutex sync.Mutex
+	messenger.Subscribe(latestAddr, func(address string, message string) error {
+		countMutex.Lock()
+		defer countMutex.Unlock()
+		latestCount++
+		return nil
+	})
+	pub1.Start()
+
+	// the latest value is published once and refreshed while unchanged
+	assert.Eventually(t, func() bool {
+		clock.Add(time.Second)
+		countMutex.Lock()
+		defer countMutex.Unlock()
+		return latestCount >= 2
+	}, time.Second, time.Millisecond)
+	pub1.Stop()
+	refreshTimes := pub1.GetRetainedRefreshTimes()
+	assert.Contains(t, refreshTimes, latestAddr)
+	assert.Contains(t, refreshTimes, output.Address)
+}
+
+func TestOutputForecast(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, messenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	output := pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.Start()
+	defer pub1.Stop()
+	forecastAddr := outputs.ReplaceMessageType(output.Address, types.MessageTypeForecast)
+	received := 0
+	pub1.SubscribeToOutputForecast("test/+/+/+/+/$forecast", func(forecast *types.OutputForecastMessage) {
+		received++
+	})
+
+	// the forecast is published with the next update
+	now := time.Now()
+	pub1.UpdateOutputForecast(output.OutputID, outputs.OutputForecast{
+		outputs.MakeForecastValue(now.Add(time.Hour), "21"),
+		outputs.MakeForecastValue(now.Add(2*time.Hour), "22"),
+	})
+	assert.Equal(t, 2, len(pub1.GetOutputForecast(output.OutputID)))
+	pub1.PublishUpdates()
+	assert.Equal(t, 1, received)
+	forecast := pub1.GetDomainOutputForecast(forecastAddr)
+	require.NotNil(t, forecast)
+	assert.Equal(t, 2, len(forecast.Forecast))
+	assert.Equal(t, 3600, forecast.Duration)
+
+	// publication can be disabled in the node configuration
+	pub1.UpdateNodeConfig(node1ID, types.NodeAttrPublishForecast, &types.ConfigAttr{
+		DataType: types.DataTypeBool, Default: "true"})
+	pub1.UpdateNodeConfigValues(node1ID, types.NodeAttrMap{types.NodeAttrPublishForecast: "false"})
+	pub1.UpdateOutputForecast(output.OutputID, outputs.OutputForecast{})
+	pub1.PublishUpdates()
+	assert.Equal(t, 1, received)
+}
+
+func TestOutputCalibration(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, messenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	output := pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.Start()
+	defer pub1.Stop()
+
+	// calibrated values are stored and the calibration is saved in the node
+	err := pub1.SetOutputCalibration(output.OutputID, &types.OutputCalibration{Offset: -1.5})
+	require.NoError(t, err)
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "21")
+	assert.Equal(t, "19.5", pub1.GetOutputValueByID(output.OutputID).Value)
+	node := pub1.GetNodeByHWID(node1ID)
+	assert.Contains(t, node.Attr[types.NodeAttrCalibration], "temperature/0")
+	err = pub1.SetOutputCalibration("unknown", &types.OutputCalibration{Offset: 1})
+	assert.Error(t, err)
+
+	// the calibration is reported in the discovery
+	pub1.PublishUpdates()
+	var discovery types.OutputDiscoveryMessage
+	_, err = messaging.VerifySenderJWSSignature(messenger.FindLastPublication(output.Address), &discovery, nil)
+	require.NoError(t, err)
+	require.NotNil(t, discovery.Calibration)
+	assert.Equal(t, -1.5, discovery.Calibration.Offset)
+
+	// outputs created later use the saved calibration
+	output2 := pub1.CreateOutput(node1ID, types.OutputTypeHumidity, types.DefaultOutputInstance)
+	assert.Nil(t, output2.Calibration)
+	pub1.UpdateNodeAttr(node1ID, types.NodeAttrMap{
+		types.NodeAttrCalibration: `{"humidity/0":{"gain":2}}`})
+	output2 = pub1.CreateOutput(node1ID, types.OutputTypeHumidity, types.DefaultOutputInstance)
+	require.NotNil(t, output2.Calibration)
+	assert.Equal(t, 2.0, output2.Calibration.Gain)
+	assert.Nil(t, pub1.GetOutputCalibration(output.OutputID))
+
+	// the calibrate command sets the calibration and is acknowledged
+	err = pub1.PublishCalibrateCommand(output.Address, &types.OutputCalibration{Gain: 10})
+	require.NoError(t, err)
+	calibration := pub1.GetOutputCalibration(output.OutputID)
+	require.NotNil(t, calibration)
+	assert.Equal(t, 10.0, calibration.Gain)
+	ackAddr := lib.MakeAckAddress(outputs.MakeCalibrateAddress(output.Address))
+	var ack types.AckMessage
+	messaging.VerifySenderJWSSignature(messenger.FindLastPublication(ackAddr), &ack, nil)
+	assert.Equal(t, types.AckStatusAccepted, ack.Status)
+
+	// an invalid calibration is rejected
+	pub1.PublishCalibrateCommand(output.Address, &types.OutputCalibration{
+		Table: []types.CalibrationPoint{{Raw: 1, Value: 1}}})
+	assert.Equal(t, 10.0, pub1.GetOutputCalibration(output.OutputID).Gain)
+	messaging.VerifySenderJWSSignature(messenger.FindLastPublication(ackAddr), &ack, nil)
+	assert.Equal(t, types.AckStatusRejected, ack.Status)
+
+	// the calibration can be configured when the node has the calibration configuration
+	pub1.UpdateNodeConfig(node1ID, types.NodeAttrCalibration, &types.ConfigAttr{DataType: types.DataTypeString})
+	pub1.UpdateNodeConfigValues(node1ID, types.NodeAttrMap{types.NodeAttrCalibration: ""})
+	pub1.PublishUpdates()
+	assert.Nil(t, pub1.GetOutputCalibration(output.OutputID))
+	assert.Nil(t, pub1.GetOutputCalibration(output2.OutputID))
+}
+
+func TestFailoverInput(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, messenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	received := ""
+	input := pub1.CreateFailoverInput(node1ID, types.InputTypeValue, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received = value
+		})
+	primary := pub1.AddFailoverSource(input.InputID, "primary", 1, 0)
+	backup := pub1.AddFailoverSource(input.InputID, "backup", 2, 0)
+	require.NotNil(t, primary)
+
+	backup(nil, "", "b1")
+	primary(nil, "", "p1")
+	assert.Equal(t, "p1", received)
+	assert.Equal(t, "primary", pub1.GetFailoverActiveSource(input.InputID))
+	err := pub1.SetFailoverSourceFailed(input.InputID, "primary")
+	assert.NoError(t, err)
+	assert.Equal(t, "b1", received)
+
+	// the active source is published with the input
+	pub1.PublishUpdates()
+	var discovery types.InputDiscoveryMessage
+	_, err = messaging.VerifySenderJWSSignature(messenger.FindLastPublication(input.Address), &discovery, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "backup", discovery.Attr[types.NodeAttrActiveSource])
+}
+
+func TestStaggeredDiscovery(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(msgConfig)
+	staggerConfig := *test1Config
+	staggerConfig.DiscoveryOnlyIfChanged = true
+	clock := lib.NewManualClock(time.Now())
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+	pub1 := publisher.NewPublisher(&staggerConfig, messenger)
+	node := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	output := pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.SetNodePublishIntervals(node1ID, 0, 1)
+	nodeCount := 0
+	outputCount := 0
+	messenger.Subscribe(node.Address, func(address string, message string) error {
+		nodeCount++
+		return nil
+	})
+	messenger.Subscribe(output.Address, func(address string, message string) error {
+		outputCount++
+		return nil
+	})
+	pub1.PublishUpdates()
+	assert.Equal(t, 1, nodeCount)
+	assert.Equal(t, 1, outputCount)
+
+	// unchanged discovery is not republished when the interval has passed
+	clock.Add(time.Millisecond * 1100)
+	pub1.PublishUpdates()
+	assert.Equal(t, 1, nodeCount)
+	assert.Equal(t, 1, outputCount)
+
+	// with a stagger window the first scheduled discovery is delayed by the node's offset
+	messenger2 := messaging.NewDummyMessenger(msgConfig)
+	staggerConfig.DiscoveryOnlyIfChanged = false
+	staggerConfig.DiscoveryStaggerWindow = 60
+	pub2 := publisher.NewPublisher(&staggerConfig, messenger2)
+	node = pub2.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub2.SetNodePublishIntervals(node1ID, 0, 1)
+	nodeCount = 0
+	messenger2.Subscribe(node.Address, func(address string, message string) error {
+		nodeCount++
+		return nil
+	})
+	pub2.PublishUpdates()
+	clock.Add(time.Millisecond * 1100)
+	pub2.PublishUpdates()
+	assert.Equal(t, 1, nodeCount)
+}
+
+func TestRetire(t *testing.T) {
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	retireConfig := *test1Config
+	retireConfig.CacheFolder = folder
+	retireConfig.ConfigFolder = folder
+	consumerConfig := retireConfig
+	consumerConfig.PublisherID = "publisher2"
+	messenger := messaging.NewDummyMessenger(msgConfig)
+
+	// start the retiring publisher first so its Stop unsubscribes its own handlers
+	pub1 := publisher.NewPublisher(&retireConfig, messenger)
+	pub1.Start()
+	node := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	output := pub1.CreateOutput(node1ID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+
+	pub2 := publisher.NewPublisher(&consumerConfig, messenger)
+	var notices []*types.RetireMessage
+	pub2.SetRetireHandler(func(message *types.RetireMessage) {
+		notices = append(notices, message)
+	})
+	pub2.Start()
+	pub2.Subscribe("", "")
+	pub1.RepublishDiscovery()
+	pub1.PublishUpdates()
+	require.NotNil(t, pub2.GetDomainNode(node.Address))
+	require.NotNil(t, pub2.GetDomainOutput(output.Address))
+	require.NotNil(t, pub2.GetPublisherKey(pub1.Address()))
+
+	// consumers remove the nodes and identity of the retired publisher
+	pub1.Retire("publisher3")
+	require.Len(t, notices, 2)
+	assert.Equal(t, node.Address, notices[0].Retired)
+	assert.Equal(t, "publisher3", notices[1].Successor)
+	assert.Nil(t, pub2.GetDomainNode(node.Address))
+	assert.Nil(t, pub2.GetDomainOutput(output.Address))
+	assert.Nil(t, pub2.GetPublisherKey(pub1.Address()))
+
+	// the retained messages are cleared
+	assert.Empty(t, messenger.FindLastPublication(node.Address))
+	assert.Empty(t, messenger.FindLastPublication(output.Address))
+	assert.Empty(t, messenger.FindLastPublication(pub1.Address()))
+	assert.Empty(t, messenger.FindLastPublication(lib.MakeBaseAddress(pub1.Address())+"/"+types.MessageTypeStatus))
+	pub2.Stop()
+}
+
+func TestRevocation(t *testing.T) {
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	revokedConfig := *test1Config
+	revokedConfig.CacheFolder = folder
+	revokedConfig.ConfigFolder = folder
+	consumerConfig := revokedConfig
+	consumerConfig.PublisherID = "publisher2"
+	consumerConfig.RevocationWarning = true
+	messenger := messaging.NewDummyMessenger(msgConfig)
+
+	pub1 := publisher.NewPublisher(&revokedConfig, messenger)
+	pub1.Start()
+	node := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub2 := publisher.NewPublisher(&consumerConfig, messenger)
+	var revocations []*types.RevokedIdentity
+	pub2.SetRevocationHandler(func(revoked *types.RevokedIdentity) {
+		revocations = append(revocations, revoked)
+	})
+	pub2.Start()
+	pub2.Subscribe("", "")
+	pub1.RepublishDiscovery()
+	pub1.PublishUpdates()
+	require.NotNil(t, pub2.GetDomainNode(node.Address))
+
+	// the DSS revokes the identity of publisher1
+	dssIdent, dssKeys := identities.CreateIdentity(pub1.Domain(), types.DSSPublisherID)
+	dssSigner := messaging.NewMessageSigner(messenger, dssKeys, pub2.GetPublisherKey)
+	dssSigner.PublishObject(dssIdent.Address, true, dssIdent.PublisherIdentityMessage, nil)
+	revoked := types.RevokedIdentity{Address: pub1.Address(), PublicKey: pub1.GetIdentity().PublicKey}
+	err = identities.PublishRevocationList(pub1.Domain(), []types.RevokedIdentity{revoked}, dssIdent.Address, dssSigner)
+	require.NoError(t, err)
+	require.Len(t, revocations, 1)
+	assert.Nil(t, pub2.GetDomainNode(node.Address))
+	assert.Nil(t, pub2.GetPublisherKey(pub1.Address()))
+
+	// the consumer publishes a warning status
+	var status types.PublisherStatusMessage
+	statusMsg := messenger.FindLastPublication(identities.MakePublisherStatusAddress(pub2.Domain(), pub2.PublisherID()))
+	_, err = messaging.VerifySenderJWSSignature(statusMsg, &status, nil)
+	require.NoError(t, err)
+	assert.Equal(t, types.PublisherRunStateWarning, status.Status)
+	assert.Equal(t, types.StatusReasonIdentityRevoked, status.Reason)
+
+	pub1.Stop()
+	pub2.Stop()
+}
+
+func TestSigningPolicy(t *testing.T) {
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	producerConfig := *test1Config
+	producerConfig.CacheFolder = folder
+	producerConfig.ConfigFolder = folder
+	producerConfig.UnsignedMessageTypes = []string{types.MessageTypeRaw}
+	consumerConfig := producerConfig
+	consumerConfig.PublisherID = "publisher2"
+	consumerConfig.StrictSecurity = true
+	consumerConfig.UnsignedMessageTypes = nil
+	messenger := messaging.NewDummyMessenger(msgConfig)
+
+	pub2 := publisher.NewPublisher(&consumerConfig, messenger)
+	pub2.Start()
+	pub1 := publisher.NewPublisher(&producerConfig, messenger)
+	pub1.Start()
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	output := pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	rawAddr := outputs.ReplaceMessageType(output.Address, types.MessageTypeRaw)
+	pub2.CreateNode(node1ID, types.NodeTypeUnknown)
+	received := ""
+	pub2.CreateInputFromOutput(node1ID, types.InputTypeTemperature, types.DefaultInputInstance, rawAddr,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			received = value
+		})
+
+	// the raw value is published unsigned as announced in the policy
+	policyAddr := identities.MakeSigningPolicyAddress(pub1.Domain(), pub1.PublisherID())
+	assert.NotEmpty(t, messenger.FindLastPublication(policyAddr))
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20.5")
+	pub1.PublishUpdates()
+	assert.Equal(t, "20.5", messenger.FindLastPublication(rawAddr))
+	assert.Equal(t, "20.5", received)
+
+	// clearing the retained policy doesn't remove it
+	messenger.Publish(policyAddr, true, "")
+	messenger.Publish(rawAddr, false, "20.6")
+	assert.Equal(t, "20.6", received)
+
+	// after the policy changes, unsigned values are rejected and signed values are accepted
+	err = pub1.SetUnsignedMessageTypes(nil)
+	require.NoError(t, err)
+	messenger.Publish(rawAddr, false, "21.5")
+	assert.Equal(t, "20.6", received)
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "22.5")
+	pub1.PublishUpdates()
+	assert.Equal(t, "22.5", received)
+
+	// error case - discovery must be signed
+	err = pub1.SetUnsignedMessageTypes([]string{types.MessageTypeNodeDiscovery})
+	assert.Error(t, err)
+	pub2.Stop()
+	pub1.Stop()
+}
+
+// TestNodeConfigWatchers tests notification of changed node configuration values
+func TestNodeConfigWatchers(t *testing.T) {
+	const portAttr types.NodeAttr = "port"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.Start()
+	node1 := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.UpdateNodeConfig(node1ID, portAttr, &types.ConfigAttr{DataType: types.DataTypeString, Default: "/dev/ttyUSB0"})
+	changes := make([]string, 0)
+	pub1.OnNodeConfigChanged(node1ID, portAttr, func(oldValue string, newValue string) {
+		changes = append(changes, oldValue+">"+newValue)
+	})
+	pub1.OnNodeConfigChanged(node1ID, types.NodeAttrName, func(oldValue string, newValue string) {
+		panic("watcher of another attribute")
+	})
+
+	// the watcher is notified after the configuration is applied
+	_, err := pub1.PublishNodeConfigureAndWait(node1.Address, types.NodeAttrMap{portAttr: "/dev/ttyUSB1"}, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/dev/ttyUSB0>/dev/ttyUSB1"}, changes)
+	value, _ := pub1.GetNodeConfigString(node1ID, portAttr, "")
+	assert.Equal(t, "/dev/ttyUSB1", value)
+
+	// unchanged values don't notify
+	_, err = pub1.PublishNodeConfigureAndWait(node1.Address, types.NodeAttrMap{portAttr: "/dev/ttyUSB1"}, time.Second)
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+
+	// a panicking watcher is recovered
+	_, err = pub1.PublishNodeConfigureAndWait(node1.Address, types.NodeAttrMap{types.NodeAttrName: "bob"}, time.Second)
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+
+	// removed watchers are not notified
+	pub1.OnNodeConfigChanged(node1ID, portAttr, nil)
+	_, err = pub1.PublishNodeConfigureAndWait(node1.Address, types.NodeAttrMap{portAttr: "/dev/ttyUSB2"}, time.Second)
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+	pub1.Stop()
+}
+
+// TestNodePollIntervals tests polling nodes in independent loops at their own interval
+func TestNodePollIntervals(t *testing.T) {
+	const node2ID = "node2"
+	clock := lib.NewManualClock(time.Now())
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	var pollMutex sync.Mutex
+	polls := make(map[string]int)
+	countPoll := func(pub *publisher.Publisher, nodeHWID string) {
+		pollMutex.Lock()
+		defer pollMutex.Unlock()
+		polls[nodeHWID]++
+	}
+	// isPolled waits until a node is polled the given nr of times
+	isPolled := func(nodeHWID string, count int) bool {
+		return assert.Eventually(t, func() bool {
+			pollMutex.Lock()
+			defer pollMutex.Unlock()
+			return polls[nodeHWID] == count
+		}, time.Second, time.Millisecond, "node %s polled %d times", nodeHWID, count)
+	}
+	configFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+	config := *test1Config
+	config.ConfigFolder = configFolder
+	pub1 := publisher.NewPublisher(&config, testMessenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	node2 := pub1.CreateNode(node2ID, types.NodeTypeUnknown)
+	pub1.SetNodePollInterval(node1ID, 1, countPoll)
+	// a slow node doesn't delay the polling of other nodes
+	releaseNode2 := make(chan bool)
+	pub1.SetNodePollInterval(node2ID, 3600, func(pub *publisher.Publisher, nodeHWID string) {
+		countPoll(pub, nodeHWID)
+		<-releaseNode2
+	})
+	interval, _ := pub1.GetNodeConfigInt(node1ID, types.NodeAttrPollInterval, 0)
+	assert.Equal(t, 1, interval)
+
+	pub1.Start()
+	isPolled(node2ID, 1)
+	isPolled(node1ID, 1)
+	clock.Add(time.Second)
+	isPolled(node1ID, 2)
+	clock.Add(time.Second)
+	isPolled(node1ID, 3)
+	close(releaseNode2)
+
+	// a changed poll interval applies without waiting for the old interval
+	_, err = pub1.PublishNodeConfigureAndWait(node2.Address,
+		types.NodeAttrMap{types.NodeAttrPollInterval: "1"}, time.Second)
+	require.NoError(t, err)
+	// a poll handler can replace itself
+	pub1.SetNodePollInterval(node1ID, 1, func(pub *publisher.Publisher, nodeHWID string) {
+		pub.SetNodePollInterval(nodeHWID, 1, countPoll)
+	})
+	isPolled(node1ID, 4)
+	clock.Add(time.Second)
+	isPolled(node1ID, 5)
+	isPolled(node2ID, 2)
+
+	// a stopped poller is no longer invoked
+	pub1.SetNodePollInterval(node1ID, 1, nil)
+	clock.Add(time.Second)
+	isPolled(node2ID, 3)
+	pub1.Stop()
+	pollMutex.Lock()
+	assert.Equal(t, 5, polls[node1ID])
+	pollMutex.Unlock()
+}
+
+func TestUpdateOutputValues(t *testing.T) {
+	const node2ID = "node2"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.CreateNode(node2ID, types.NodeTypeUnknown)
+	out1 := pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	out2 := pub1.CreateOutput(node2ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.PublishUpdates()
+
+	// the batch is published in a single pass
+	nrUpdated := pub1.UpdateOutputValues(map[publisher.OutputKey]string{
+		{NodeHWID: node1ID, OutputType: types.OutputTypeTemperature, Instance: types.DefaultOutputInstance}: "20",
+		{NodeHWID: node2ID, OutputType: types.OutputTypeTemperature, Instance: types.DefaultOutputInstance}: "21",
+	})
+	assert.Equal(t, 2, nrUpdated)
+	latest1Addr := outputs.ReplaceMessageType(out1.Address, types.MessageTypeLatest)
+	latest2Addr := outputs.ReplaceMessageType(out2.Address, types.MessageTypeLatest)
+	assert.Empty(t, testMessenger.FindLastPublication(latest1Addr))
+	pub1.PublishUpdates()
+	assert.NotEmpty(t, testMessenger.FindLastPublication(latest1Addr))
+	assert.NotEmpty(t, testMessenger.FindLastPublication(latest2Addr))
+	assert.Equal(t, "21", pub1.GetOutputValueByID(out2.OutputID).Value)
+
+	// unchanged values are not updated
+	nrUpdated = pub1.UpdateOutputValues(map[publisher.OutputKey]string{
+		{NodeHWID: node1ID, OutputType: types.OutputTypeTemperature, Instance: types.DefaultOutputInstance}: "20",
+	})
+	assert.Equal(t, 0, nrUpdated)
+}
+
+func TestStaleNodes(t *testing.T) {
+	const node2ID = "node2"
+	clock := lib.NewManualClock(time.Now())
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.CreateNode(node2ID, types.NodeTypeUnknown)
+	pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.SetNodeStaleTimeout(node1ID, 1)
+	pub1.UpdateNodeErrorStatus(node1ID, types.NodeRunStateReady, "")
+	pub1.Start()
+
+	// the node becomes stale when no values are updated within the timeout
+	assert.Eventually(t, func() bool {
+		clock.Add(time.Second)
+		runState, _ := pub1.GetNodeStatus(node1ID, types.NodeStatusRunState)
+		return runState == types.NodeRunStateStale
+	}, time.Second, time.Millisecond)
+	lastSeen, _ := pub1.GetNodeStatus(node1ID, types.NodeStatusLastSeen)
+	assert.NotEmpty(t, lastSeen)
+	runState, _ := pub1.GetNodeStatus(node2ID, types.NodeStatusRunState)
+	assert.NotEqual(t, types.NodeRunStateStale, runState)
+
+	// an unchanged value recovers the node
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	runState, _ = pub1.GetNodeStatus(node1ID, types.NodeStatusRunState)
+	assert.Equal(t, types.NodeRunStateReady, runState)
+	pub1.Stop()
+	node := pub1.GetNodeByHWID(node1ID)
+	published := testMessenger.FindLastPublication(node.Address)
+	assert.NotEmpty(t, published)
+}
+
+func TestOutputValueTTL(t *testing.T) {
+	config := *test1Config
+	config.StaleOnExpiredValues = true
+	clock := lib.NewManualClock(time.Now())
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(&config, testMessenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	err := pub1.SetOutputValueTTL(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, 1)
+	assert.NoError(t, err)
+	err = pub1.SetOutputValueTTL(node1ID, types.OutputTypeHumidity, types.DefaultOutputInstance, 1)
+	assert.Error(t, err)
+	pub1.UpdateNodeErrorStatus(node1ID, types.NodeRunStateReady, "")
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub1.PublishUpdates()
+
+	// the latest value includes the time it is valid until
+	output := pub1.GetOutputByNodeHWID(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	require.NotNil(t, output)
+	assert.Equal(t, 1, output.ValueTTL)
+	raw := testMessenger.FindLastPublication(outputs.ReplaceMessageType(output.Address, types.MessageTypeLatest))
+	payload, err := messaging.VerifyJWSMessage(raw, &pub1.GetIdentityKeys().PublicKey)
+	require.NoError(t, err)
+	var latest types.OutputLatestMessage
+	err = json.Unmarshal([]byte(payload), &latest)
+	require.NoError(t, err)
+	assert.NotEmpty(t, latest.ValidUntil)
+
+	// the node becomes stale when its value expires
+	pub1.Start()
+	assert.Eventually(t, func() bool {
+		clock.Add(time.Second)
+		runState, _ := pub1.GetNodeStatus(node1ID, types.NodeStatusRunState)
+		return runState == types.NodeRunStateStale
+	}, time.Second, time.Millisecond)
+	pub1.Stop()
+}
+
+func TestEnumValues(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.CreateOutput(node1ID, types.OutputTypeLevel, types.DefaultOutputInstance)
+	pub1.CreateInput(node1ID, types.InputTypeLevel, types.DefaultInputInstance, nil)
+	err := pub1.SetOutputEnumValues(node1ID, types.OutputTypeLevel, types.DefaultOutputInstance, []string{"heat", "cool"})
+	assert.NoError(t, err)
+	err = pub1.SetInputEnumValues(node1ID, types.InputTypeLevel, types.DefaultInputInstance, []string{"heat", "cool"})
+	assert.NoError(t, err)
+	input := pub1.GetInputByNodeHWID(node1ID, types.InputTypeLevel, types.DefaultInputInstance)
+	assert.Equal(t, []string{"heat", "cool"}, input.EnumValues)
+
+	// invalid values are rejected, also in a batch
+	updated := pub1.UpdateOutputValue(node1ID, types.OutputTypeLevel, types.DefaultOutputInstance, "heat")
+	assert.True(t, updated)
+	updated = pub1.UpdateOutputValue(node1ID, types.OutputTypeLevel, types.DefaultOutputInstance, "dry")
+	assert.False(t, updated)
+	levelKey := publisher.OutputKey{NodeHWID: node1ID, OutputType: types.OutputTypeLevel, Instance: types.DefaultOutputInstance}
+	count := pub1.UpdateOutputValues(map[publisher.OutputKey]string{levelKey: "dry"})
+	assert.Equal(t, 0, count)
+	value := pub1.GetOutputValueByNodeHWID(node1ID, types.OutputTypeLevel, types.DefaultOutputInstance)
+	require.NotNil(t, value)
+	assert.Equal(t, "heat", value.Value)
+
+	// error case - unknown input or output
+	err = pub1.SetOutputEnumValues(node1ID, types.OutputTypeSwitch, types.DefaultOutputInstance, []string{"on"})
+	assert.Error(t, err)
+	err = pub1.SetInputEnumValues(node1ID, types.InputTypeSwitch, types.DefaultInputInstance, []string{"on"})
+	assert.Error(t, err)
+}
+
+func TestLocalOnly(t *testing.T) {
+	const node2ID = "node2"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	node1 := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	node2 := pub1.CreateNode(node2ID, types.NodeTypeUnknown)
+	output1 := pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	output2 := pub1.CreateOutput(node2ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	input1 := pub1.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance, nil)
+	err := pub1.SetNodeLocalOnly(node2ID, true)
+	assert.NoError(t, err)
+	err = pub1.SetOutputLocalOnly(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, true)
+	assert.NoError(t, err)
+	err = pub1.SetInputLocalOnly(node1ID, types.InputTypeSwitch, types.DefaultInputInstance, true)
+	assert.NoError(t, err)
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub1.UpdateOutputValue(node2ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "21")
+
+	// local-only nodes, inputs, outputs and values are not published
+	pub1.Start()
+	pub1.PublishUpdates()
+	assert.NotEmpty(t, testMessenger.FindLastPublication(node1.Address))
+	assert.Empty(t, testMessenger.FindLastPublication(node2.Address))
+	assert.Empty(t, testMessenger.FindLastPublication(output1.Address))
+	assert.Empty(t, testMessenger.FindLastPublication(output2.Address))
+	assert.Empty(t, testMessenger.FindLastPublication(input1.Address))
+	latestAddr := outputs.ReplaceMessageType(output2.Address, types.MessageTypeLatest)
+	assert.Empty(t, testMessenger.FindLastPublication(latestAddr))
+	assert.NotNil(t, pub1.GetOutputValueByNodeHWID(node2ID, types.OutputTypeTemperature, types.DefaultOutputInstance))
+
+	// a node that is no longer local-only is published with its outputs
+	err = pub1.SetNodeLocalOnly(node2ID, false)
+	assert.NoError(t, err)
+	pub1.PublishUpdates()
+	assert.NotEmpty(t, testMessenger.FindLastPublication(node2.Address))
+	assert.NotEmpty(t, testMessenger.FindLastPublication(output2.Address))
+
+	// error case - unknown node, input or output
+	err = pub1.SetNodeLocalOnly("notanode", true)
+	assert.Error(t, err)
+	err = pub1.SetInputLocalOnly(node2ID, types.InputTypeSwitch, types.DefaultInputInstance, true)
+	assert.Error(t, err)
+	err = pub1.SetOutputLocalOnly(node2ID, types.OutputTypeHumidity, types.DefaultOutputInstance, true)
+	assert.Error(t, err)
+	pub1.Stop()
+}
+
+func TestDeleteInputOutput(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	output1 := pub1.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	input1 := pub1.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance, nil)
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub1.Start()
+	pub1.PublishUpdates()
+	latestAddr := outputs.ReplaceMessageType(output1.Address, types.MessageTypeLatest)
+	require.NotEmpty(t, testMessenger.FindLastPublication(output1.Address))
+	require.NotEmpty(t, testMessenger.FindLastPublication(latestAddr))
+	require.NotEmpty(t, testMessenger.FindLastPublication(input1.Address))
+
+	// deleting removes the retained discovery and values, and the history
+	err := pub1.DeleteOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	assert.NoError(t, err)
+	err = pub1.DeleteInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance)
+	assert.NoError(t, err)
+	assert.Empty(t, testMessenger.FindLastPublication(output1.Address))
+	assert.Empty(t, testMessenger.FindLastPublication(latestAddr))
+	assert.Empty(t, testMessenger.FindLastPublication(input1.Address))
+	assert.Nil(t, pub1.GetOutputByID(output1.OutputID))
+	assert.Nil(t, pub1.GetInputByID(input1.InputID))
+	assert.Nil(t, pub1.GetOutputValueByNodeHWID(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance))
+
+	// a deleted output doesn't get values
+	pub1.UpdateOutputValue(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance, "21")
+	pub1.PublishUpdates()
+	assert.Empty(t, testMessenger.FindLastPublication(latestAddr))
+
+	// error case - unknown input or output
+	err = pub1.DeleteOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	assert.Error(t, err)
+	err = pub1.DeleteInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance)
+	assert.Error(t, err)
+	pub1.Stop()
+}
+
+func TestSetNodeAttributes(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.PublishUpdates()
+
+	err := pub1.SetNodeName(node1ID, "Living room sensor")
+	assert.NoError(t, err)
+	err = pub1.SetNodeManufacturer(node1ID, "Acme")
+	assert.NoError(t, err)
+	err = pub1.SetNodeLocationLatLon(node1ID, 52.370216, 4.895168)
+	assert.NoError(t, err)
+	err = pub1.SetNodeFirmwareVersion(node1ID, "1.2.3")
+	assert.NoError(t, err)
+	assert.Equal(t, "Living room sensor", pub1.GetNodeAttr(node1ID, types.NodeAttrName))
+	assert.Equal(t, "Acme", pub1.GetNodeAttr(node1ID, types.NodeAttrManufacturer))
+	assert.Equal(t, "52.370216,4.895168", pub1.GetNodeAttr(node1ID, types.NodeAttrLatLon))
+	assert.Equal(t, "1.2.3", pub1.GetNodeAttr(node1ID, types.NodeAttrFirmwareVersion))
+	node1 := pub1.GetNodeByHWID(node1ID)
+	assert.True(t, types.HasChangeReason(node1.ChangeReasons, types.ChangeReasonAttr))
+
+	// error cases - invalid values are not set, unknown node
+	err = pub1.SetNodeName(node1ID, " ")
+	assert.Error(t, err)
+	err = pub1.SetNodeManufacturer(node1ID, "Acme\n")
+	assert.Error(t, err)
+	err = pub1.SetNodeLocationLatLon(node1ID, 91, 0)
+	assert.Error(t, err)
+	err = pub1.SetNodeFirmwareVersion(node1ID, "1.2")
+	assert.Error(t, err)
+	assert.Equal(t, "1.2.3", pub1.GetNodeAttr(node1ID, types.NodeAttrFirmwareVersion))
+	err = pub1.SetNodeName("notanode", "name")
+	assert.Error(t, err)
+}
+
+func TestNodeLocation(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	node1 := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	err := pub1.SetNodeLocation(node1ID, 52.370216, 4.895168, 12.5)
+	assert.NoError(t, err)
+	pub1.PublishUpdates()
+
+	// the location is published in machine-readable form
+	payload, err := messaging.VerifyJWSMessage(testMessenger.FindLastPublication(node1.Address),
+		&pub1.GetIdentityKeys().PublicKey)
+	require.NoError(t, err)
+	var published types.NodeDiscoveryMessage
+	err = json.Unmarshal([]byte(payload), &published)
+	require.NoError(t, err)
+	require.NotNil(t, published.Location)
+	assert.Equal(t, types.NodeLocation{Altitude: 12.5, Latitude: 52.370216, Longitude: 4.895168}, *published.Location)
+	assert.Nil(t, pub1.GetNodeByHWID(node1ID).Location)
+
+	// a private location is not published
+	config := *test1Config
+	config.PrivateNodeAttr = []types.NodeAttr{types.NodeAttrLatLon}
+	var testMessenger2 = messaging.NewDummyMessenger(msgConfig)
+	pub2 := publisher.NewPublisher(&config, testMessenger2)
+	pub2.CreateNode(node1ID, types.NodeTypeUnknown)
+	err = pub2.SetNodeLocation(node1ID, 52.370216, 4.895168, 0)
+	assert.NoError(t, err)
+	pub2.PublishUpdates()
+	payload, err = messaging.VerifyJWSMessage(testMessenger2.FindLastPublication(node1.Address),
+		&pub2.GetIdentityKeys().PublicKey)
+	require.NoError(t, err)
+	published = types.NodeDiscoveryMessage{}
+	err = json.Unmarshal([]byte(payload), &published)
+	require.NoError(t, err)
+	assert.Nil(t, published.Location)
+
+	// error case - out of range
+	err = pub1.SetNodeLocation(node1ID, 0, 181, 0)
+	assert.Error(t, err)
+}
+
+// private attributes are published for an attribute reader once its identity is discovered
+func TestAttrReaderIdentity(t *testing.T) {
+	configFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+	readerConfig := &publisher.PublisherConfig{ConfigFolder: configFolder, Domain: "test", PublisherID: "publisher2"}
+	config := &publisher.PublisherConfig{
+		AttrReaders:     []string{"test/publisher2"},
+		ConfigFolder:    configFolder,
+		Domain:          "test",
+		PrivateNodeAttr: []types.NodeAttr{types.NodeAttrLocationName},
+		PublisherID:     "publisher1",
+	}
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(config, testMessenger)
+	node1 := pub1.CreateNode(node1ID, types.NodeTypeUnknown)
+	pub1.UpdateNodeAttr(node1ID, types.NodeAttrMap{types.NodeAttrLocationName: "kitchen"})
+	pub1.Start()
+	defer pub1.Stop()
+	getPublished := func() *types.NodeDiscoveryMessage {
+		payload, err := messaging.VerifyJWSMessage(testMessenger.FindLastPublication(node1.Address),
+			&pub1.GetIdentityKeys().PublicKey)
+		require.NoError(t, err)
+		published := &types.NodeDiscoveryMessage{}
+		err = json.Unmarshal([]byte(payload), published)
+		require.NoError(t, err)
+		return published
+	}
+	// the private attribute is omitted while the key of the reader is unknown
+	pub1.PublishUpdates()
+	assert.Empty(t, getPublished().EncryptedAttr)
+
+	// the node is republished when the reader identity is received
+	pub2 := publisher.NewPublisher(readerConfig, testMessenger)
+	pub2.Start()
+	defer pub2.Stop()
+	require.NotNil(t, pub1.GetPublisherKey(pub2.Address()))
+	pub1.PublishUpdates()
+	readNode, err := nodes.DecryptNodeAttr(getPublished(), pub2.GetIdentityKeys())
+	require.NoError(t, err)
+	assert.Equal(t, "kitchen", readNode.Attr[types.NodeAttrLocationName])
+}
+
+// Performance budget of the publish path on a CI host, see the README. The budget is enforced by
+// TestPublishPerformanceBudget unless tests run in short mode.
+const publishValuesBudget = 50 * time.Millisecond     // update and publish the values of 50 sensors
+const updateOutputValueBudget = 50 * time.Microsecond // update a single output value
+
+func TestPublishPerformanceBudget(t *testing.T) {
+	perftest.SkipUnlessEnabled(t)
+	result := testing.Benchmark(BenchmarkPublishValues)
+	assert.LessOrEqualf(t, result.NsPerOp(), int64(publishValuesBudget),
+		"Publishing 50 values exceeds the budget of %s: %s", publishValuesBudget, result)
+	result = testing.Benchmark(BenchmarkUpdateOutputValue)
+	assert.LessOrEqualf(t, result.NsPerOp(), int64(updateOutputValueBudget),
+		"UpdateOutputValue exceeds the budget of %s: %s", updateOutputValueBudget, result)
+}
+
+// newBenchmarkPublisher returns a publisher with 50 sensors that persists in a temporary folder.
+// The history is limited to a day of values at one value per minute.
+// Logging is limited to warnings so it doesn't dominate the measurement.
+// Returns the publisher and a function to clean up after the benchmark.
+func newBenchmarkPublisher(b *testing.B) (pub1 *publisher.Publisher, cleanup func()) {
+	configFolder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(b, err)
+	prevLevel := logrus.GetLevel()
+	config := &publisher.PublisherConfig{
+		ConfigFolder:     configFolder,
+		Domain:           "test",
+		HistoryRetention: &outputs.HistoryRetention{MaxAge: outputs.DefaultHistoryMaxAge, MaxCount: 1440},
+		PublisherID:      "publisher1",
+	}
+	pub1 = publisher.NewPublisher(config, messaging.NewDummyMessenger(msgConfig))
+	logrus.SetLevel(logrus.WarnLevel)
+	for count := 0; count < 50; count++ {
+		nodeHWID := fmt.Sprintf("sensor%d", count)
+		pub1.CreateNode(nodeHWID, types.NodeTypeMultisensor)
+		pub1.CreateOutput(nodeHWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	}
+	pub1.PublishUpdates()
+	return pub1, func() {
+		logrus.SetLevel(prevLevel)
+		os.RemoveAll(configFolder)
+	}
+}
+
+// BenchmarkPublishValues measures the publish path from UpdateOutputValue to the signed publication
+// of the value, latest value and history of 50 sensors
+func BenchmarkPublishValues(b *testing.B) {
+	pub1, cleanup := newBenchmarkPublisher(b)
+	defer cleanup()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for count := 0; count < b.N; count++ {
+		for sensor := 0; sensor < 50; sensor++ {
+			pub1.UpdateOutputValue(fmt.Sprintf("sensor%d", sensor), types.OutputTypeTemperature,
+				types.DefaultOutputInstance, fmt.Sprint(count))
+		}
+		pub1.PublishUpdates()
+	}
+}
+
+// BenchmarkUpdateOutputValue measures the update of an output value without publishing it
+func BenchmarkUpdateOutputValue(b *testing.B) {
+	pub1, cleanup := newBenchmarkPublisher(b)
+	defer cleanup()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for count := 0; count < b.N; count++ {
+		pub1.UpdateOutputValue("sensor25", types.OutputTypeTemperature, types.DefaultOutputInstance, fmt.Sprint(count))
+	}
 }
@@ -0,0 +1,37 @@
+// Package publisher with request/response exchanges with other publishers
+package publisher
+
+import (
+	"errors"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+)
+
+// Request sends a request to another publisher and waits for its reply, for adapters that need
+// synchronous queries to other publishers. The other publisher serves the address with ServeRequests.
+// The request and reply are signed and, when the key of the other publisher is known, encrypted.
+// The reply must be signed by the publisher of the address.
+//  address the other publisher serves requests on
+//  payload of the request
+//  timeout to wait for the reply
+// Returns the payload of the reply or an error if the request fails or isn't answered in time.
+func (pub *Publisher) Request(address string, payload string, timeout time.Duration) (string, error) {
+	return pub.messageSigner.Request(address, pub.Address(), payload, timeout)
+}
+
+// ServeRequests serves requests from other publishers on an address. The reply to each request
+// is the result of the handler. A handler that panics replies with an error. Requests must be
+// signed by their sender and in strict mode be encrypted and sent by a trusted publisher.
+// Use RemoveSubscription with the address to stop serving requests.
+//  address to serve requests on, eg an address of a node of this publisher
+//  handler returns the payload of the reply, or an error that is returned to the requester
+func (pub *Publisher) ServeRequests(address string, handler messaging.RequestHandler) {
+	pub.messageSigner.ServeRequests(address, pub.Address(), func(requestAddress string, payload string) (reply string, err error) {
+		// the error remains if the handler panics
+		err = errors.New("request handler failed")
+		defer pub.recoverHandler("Request", "", requestAddress)
+		reply, err = handler(requestAddress, payload)
+		return reply, err
+	})
+}
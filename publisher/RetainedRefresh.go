@@ -0,0 +1,162 @@
+package publisher
+
+import (
+	"sort"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// retainedItem is a retained publication that is refreshed periodically
+type retainedItem struct {
+	address string // address of the retained message
+	refresh func() // republishes the message
+}
+
+// GetRetainedRefreshTimes returns the time the retained messages of this publisher were last
+// published or refreshed, by address. Only tracked when RetainedRefreshInterval is enabled.
+func (pub *Publisher) GetRetainedRefreshTimes() map[string]time.Time {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	refreshTimes := make(map[string]time.Time, len(pub.retainedRefreshed))
+	for address, refreshed := range pub.retainedRefreshed {
+		refreshTimes[address] = refreshed
+	}
+	return refreshTimes
+}
+
+// getRetainedItems returns the retained publications of this publisher: its identity, the discovery
+// of nodes, inputs and outputs, and the latest output values.
+func (pub *Publisher) getRetainedItems() []retainedItem {
+	items := make([]retainedItem, 0)
+	items = append(items, retainedItem{
+		address: pub.registeredIdentity.GetAddress(),
+		refresh: func() {
+			myIdent, _ := pub.registeredIdentity.GetFullIdentity()
+			identities.PublishIdentity(&myIdent.PublisherIdentityMessage, pub.messageSigner)
+		},
+	})
+	items = append(items, retainedItem{
+		address: identities.MakeSigningPolicyAddress(pub.Domain(), pub.PublisherID()),
+		refresh: pub.publishSigningPolicy,
+	})
+	for _, node := range pub.registeredNodes.GetAllNodes() {
+		node := node
+		if node == nil || pub.isNodeDeleted(node.HWID) {
+			continue
+		}
+		items = append(items, retainedItem{
+			address: node.Address,
+			refresh: func() { pub.registeredNodes.RepublishNodes([]*types.NodeDiscoveryMessage{node}) },
+		})
+	}
+	for _, input := range pub.registeredInputs.GetAllInputs() {
+		input := input
+		if pub.isNodeDeleted(input.NodeHWID) {
+			continue
+		}
+		items = append(items, retainedItem{
+			address: input.Address,
+			refresh: func() { pub.registeredInputs.RepublishInput(input) },
+		})
+	}
+	for _, output := range pub.registeredOutputs.GetAllOutputs() {
+		output := output
+		if pub.isNodeDeleted(output.NodeHWID) {
+			continue
+		}
+		items = append(items, retainedItem{
+			address: output.Address,
+			refresh: func() { pub.registeredOutputs.RepublishOutput(output) },
+		})
+		pubLatest, _ := pub.registeredNodes.GetNodeConfigBool(output.NodeHWID, types.NodeAttrPublishLatest, true)
+		if pubLatest && pub.registeredOutputValues.GetPublishedValue(output.OutputID) != nil {
+			items = append(items, retainedItem{
+				address: outputs.GetOutputAddresses(output).Latest,
+				refresh: func() {
+					// the value is read when refreshed so a newer value isn't followed by an older one
+					pub.valuePublishMutex.Lock()
+					defer pub.valuePublishMutex.Unlock()
+					latestValue := pub.registeredOutputValues.GetPublishedValue(output.OutputID)
+					if latestValue == nil {
+						return
+					}
+					err := outputs.PublishOutputLatest(output, latestValue, pub.messageSigner)
+					pub.reportError(ErrorCategoryMessenger, output.Address, err)
+				},
+			})
+		}
+	}
+	return items
+}
+
+// markRetainedPublished records that retained messages were published so they aren't refreshed
+// before the refresh interval has passed
+func (pub *Publisher) markRetainedPublished(addresses ...string) {
+	if pub.config.RetainedRefreshInterval <= 0 || len(addresses) == 0 {
+		return
+	}
+	now := lib.Now()
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	for _, address := range addresses {
+		if _, found := pub.retainedRefreshed[address]; found {
+			pub.retainedRefreshed[address] = now
+		}
+	}
+}
+
+// refreshRetained republishes the retained messages that were not refreshed within the refresh
+// interval, so they don't expire on brokers with a retained message TTL. The oldest are refreshed
+// first, up to RetainedRefreshRate messages per invocation to stay below broker rate limits.
+// Newly seen addresses are considered refreshed as they were just published.
+// Invoked by the heartbeat each second.
+func (pub *Publisher) refreshRetained() {
+	interval := time.Duration(pub.config.RetainedRefreshInterval) * time.Second
+	rate := pub.config.RetainedRefreshRate
+	if rate <= 0 {
+		rate = DefaultRetainedRefreshRate
+	}
+	now := lib.Now()
+	items := pub.getRetainedItems()
+	dueItems := make([]retainedItem, 0)
+	current := make(map[string]bool, len(items))
+
+	pub.updateMutex.Lock()
+	for _, item := range items {
+		current[item.address] = true
+		refreshed, found := pub.retainedRefreshed[item.address]
+		if !found {
+			pub.retainedRefreshed[item.address] = now
+		} else if now.Sub(refreshed) >= interval {
+			dueItems = append(dueItems, item)
+		}
+	}
+	// forget addresses that are no longer published
+	for address := range pub.retainedRefreshed {
+		if !current[address] {
+			delete(pub.retainedRefreshed, address)
+		}
+	}
+	sort.SliceStable(dueItems, func(i, j int) bool {
+		return pub.retainedRefreshed[dueItems[i].address].Before(pub.retainedRefreshed[dueItems[j].address])
+	})
+	if len(dueItems) > rate {
+		dueItems = dueItems[:rate]
+	}
+	for _, item := range dueItems {
+		pub.retainedRefreshed[item.address] = now
+	}
+	pub.updateMutex.Unlock()
+
+	if len(dueItems) > 0 {
+		logrus.Infof("refreshRetained: Refreshing %d retained messages", len(dueItems))
+	}
+	for _, item := range dueItems {
+		item.refresh()
+	}
+}
@@ -0,0 +1,112 @@
+// Package publisher with the retirement of publishers
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// HandleRetireNotice removes a retired domain publisher or node, with its inputs and outputs, from
+// the discovered domain entities and passes the notice to the retire handler. The signing policy
+// of a retired publisher is removed.
+func (pub *Publisher) HandleRetireNotice(message *types.RetireMessage) {
+	publisherAddress := lib.MakeBaseAddress(message.Sender)
+	if lib.MakeBaseAddress(message.Retired) == publisherAddress {
+		for _, node := range pub.domainNodes.GetPublisherNodes(publisherAddress + "/") {
+			pub.removeDomainNode(node.Address)
+		}
+		pub.domainIdentities.RemoveIdentity(message.Retired)
+		pub.messageSigner.SetPublisherSigningPolicy(publisherAddress, nil)
+	} else {
+		pub.removeDomainNode(message.Retired)
+	}
+
+	pub.updateMutex.Lock()
+	handler := pub.retireHandler
+	pub.updateMutex.Unlock()
+	if handler != nil {
+		defer pub.recoverHandler("retire", "", message.Retired)
+		handler(message)
+	}
+}
+
+// Retire decommissions this publisher and stops it. Signed retirement notices are published for
+// all its nodes and its identity, so consumers remove them instead of waiting for their cache to
+// expire, and the retained messages of this publisher are cleared.
+//  successorID is the optional ID of the publisher that takes over, "" if none
+func (pub *Publisher) Retire(successorID string) {
+	logrus.Warningf("Publisher.Retire: Retiring publisher %s. Successor is '%s'", pub.PublisherID(), successorID)
+	pub.updateMutex.Lock()
+	pub.retired = true
+	pub.retireSuccessor = successorID
+	pub.updateMutex.Unlock()
+	pub.Stop()
+}
+
+// SetRetireHandler sets the handler that is invoked when a domain publisher or node has retired,
+// eg to switch to its successor. The retired entities are already removed from the domain.
+func (pub *Publisher) SetRetireHandler(handler func(message *types.RetireMessage)) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.retireHandler = handler
+}
+
+// getRetiredAddresses returns the addresses of the retained messages of this publisher
+func (pub *Publisher) getRetiredAddresses() []string {
+	addresses := make([]string, 0)
+	for _, node := range pub.registeredNodes.GetAllNodes() {
+		addresses = append(addresses, node.Address,
+			outputs.ReplaceMessageType(node.Address, types.MessageTypeEvent))
+	}
+	for _, input := range pub.registeredInputs.GetAllInputs() {
+		addresses = append(addresses, input.Address)
+	}
+	for _, output := range pub.registeredOutputs.GetAllOutputs() {
+		addresses = append(addresses, getOutputRetainedAddresses(output)...)
+	}
+	addresses = append(addresses,
+		nodes.MakePairStatusAddress(pub.Domain(), pub.PublisherID()),
+		nodes.MakePseudonymsAddress(pub.Domain(), pub.PublisherID()),
+		identities.MakePublisherStatusAddress(pub.Domain(), pub.PublisherID()),
+		identities.MakeSigningPolicyAddress(pub.Domain(), pub.PublisherID()),
+		pub.registeredIdentity.GetAddress())
+	return addresses
+}
+
+// publishRetirement publishes the retirement notices of the nodes and identity of this publisher
+// and clears its retained messages. Invoked by Stop after Retire.
+func (pub *Publisher) publishRetirement() {
+	pub.updateMutex.Lock()
+	successor := pub.retireSuccessor
+	pub.updateMutex.Unlock()
+
+	for _, node := range pub.registeredNodes.GetAllNodes() {
+		err := identities.PublishRetire(node.Address, successor, pub.Address(), pub.messageSigner)
+		pub.reportError(ErrorCategoryMessenger, node.Address, err)
+	}
+	identityAddr := pub.registeredIdentity.GetAddress()
+	err := identities.PublishRetire(identityAddr, successor, pub.Address(), pub.messageSigner)
+	pub.reportError(ErrorCategoryMessenger, identityAddr, err)
+
+	// an empty retained message removes the retained message from the broker
+	for _, address := range pub.getRetiredAddresses() {
+		err = pub.messenger.Publish(address, true, "")
+		pub.reportError(ErrorCategoryMessenger, address, err)
+	}
+}
+
+// removeDomainNode removes a discovered domain node with its inputs and outputs
+func (pub *Publisher) removeDomainNode(nodeAddress string) {
+	nodePrefix := lib.MakeBaseAddress(nodeAddress) + "/"
+	for _, input := range pub.domainInputs.GetNodeInputs(nodePrefix) {
+		pub.domainInputs.RemoveInput(input.Address)
+	}
+	for _, output := range pub.domainOutputs.GetNodeOutputs(nodePrefix) {
+		pub.domainOutputs.RemoveOutput(output.Address)
+	}
+	pub.domainNodes.RemoveNode(nodeAddress)
+}
@@ -0,0 +1,51 @@
+// Package publisher with the handling of identities revoked by the DSS
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// HandleRevocation removes the discovered nodes, inputs and outputs of a publisher whose identity
+// is revoked by the DSS, as these could have been published with the compromised key. The identity
+// itself is already removed from the domain identities. A warning status is published when enabled
+// in the configuration and the revocation is passed to the revocation handler.
+func (pub *Publisher) HandleRevocation(revoked *types.RevokedIdentity) {
+	publisherAddress := lib.MakeBaseAddress(revoked.Address)
+	if revoked.Address == pub.Address() {
+		logrus.Errorf("Publisher.HandleRevocation: The identity of this publisher is revoked: %s", revoked.Reason)
+	} else {
+		logrus.Warningf("Publisher.HandleRevocation: Identity %s is revoked: %s", revoked.Address, revoked.Reason)
+	}
+	for _, node := range pub.domainNodes.GetPublisherNodes(publisherAddress + "/") {
+		pub.removeDomainNode(node.Address)
+	}
+
+	pub.updateMutex.Lock()
+	handler := pub.revocationHandler
+	publishWarning := pub.config.RevocationWarning
+	pub.updateMutex.Unlock()
+	if publishWarning {
+		statusMsg := types.PublisherStatusMessage{
+			Address: identities.MakePublisherStatusAddress(pub.Domain(), pub.PublisherID()),
+			Status:  types.PublisherRunStateWarning,
+			Reason:  types.StatusReasonIdentityRevoked,
+		}
+		identities.PublishStatus(&statusMsg, pub.messageSigner)
+	}
+	if handler != nil {
+		defer pub.recoverHandler("revocation", "", revoked.Address)
+		handler(revoked)
+	}
+}
+
+// SetRevocationHandler sets the handler that is invoked when an identity of the domain is revoked
+// by the DSS, eg to alert the operator. The revoked publisher and its nodes are already removed
+// from the domain and messages signed with its revoked key are rejected.
+func (pub *Publisher) SetRevocationHandler(handler func(revoked *types.RevokedIdentity)) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.revocationHandler = handler
+}
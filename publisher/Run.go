@@ -0,0 +1,63 @@
+package publisher
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// shutdownHook is a function that is invoked during an orderly shutdown of the publisher
+type shutdownHook struct {
+	name string
+	hook func() error
+}
+
+// AddShutdownHook registers a function that is invoked when the publisher stops, for example to close
+// serial ports or sessions. Hooks are invoked in reverse order of registration after polling has
+// ended and before the publisher disconnects from the message bus. Errors are logged.
+//  name identifies the hook in the log
+func (pub *Publisher) AddShutdownHook(name string, hook func() error) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.shutdownHooks = append(pub.shutdownHooks, shutdownHook{name: name, hook: hook})
+}
+
+// Run starts the publisher and blocks until the context is cancelled or a TERM or INT signal is
+// received, after which the publisher is stopped in an orderly manner. Stopping publishes pending
+// updates, invokes the shutdown hooks, saves discovered publishers when enabled and publishes the
+// disconnected status before disconnecting.
+// This returns the context error if the context was cancelled, or nil if a signal was received.
+func (pub *Publisher) Run(ctx context.Context) error {
+	exitChannel := make(chan os.Signal, 1)
+	signal.Notify(exitChannel, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(exitChannel)
+
+	pub.Start()
+	var err error
+	select {
+	case sig := <-exitChannel:
+		logrus.Warningf("Publisher.Run: Received signal %s", sig)
+	case <-ctx.Done():
+		err = ctx.Err()
+		logrus.Warningf("Publisher.Run: Context ended: %s", err)
+	}
+	pub.Stop()
+	return err
+}
+
+// runShutdownHooks invokes the registered shutdown hooks in reverse order of registration
+func (pub *Publisher) runShutdownHooks() {
+	pub.updateMutex.Lock()
+	hooks := append([]shutdownHook{}, pub.shutdownHooks...)
+	pub.updateMutex.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		logrus.Infof("Publisher.runShutdownHooks: Invoking shutdown hook '%s'", hooks[i].name)
+		err := hooks[i].hook()
+		if err != nil {
+			logrus.Errorf("Publisher.runShutdownHooks: Shutdown hook '%s' failed: %s", hooks[i].name, err)
+		}
+	}
+}
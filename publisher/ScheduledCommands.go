@@ -0,0 +1,45 @@
+// Package publisher with set commands that are scheduled to be applied at a later time
+package publisher
+
+import (
+	"path"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// CancelScheduledCommand removes a received set command from the schedule so it is not applied
+// Returns false if no command with the message ID is scheduled.
+func (pub *Publisher) CancelScheduledCommand(messageID string) bool {
+	return pub.inputFromSetCommands.CancelScheduledCommand(messageID)
+}
+
+// GetScheduledCommands returns the received set commands that are scheduled to be applied at a
+// later time, earliest first
+func (pub *Publisher) GetScheduledCommands() []types.SetInputMessage {
+	return pub.inputFromSetCommands.GetScheduledCommands()
+}
+
+// LoadScheduledCommands loads the received set commands that are scheduled from the cache folder.
+// Changes to the schedule are saved to this folder so they are applied after a restart. This is
+// invoked on Start.
+func (pub *Publisher) LoadScheduledCommands() error {
+	filename := path.Join(pub.config.CacheFolder, pub.PublisherID()+ScheduledCommandsFileSuffix)
+	err := pub.inputFromSetCommands.LoadScheduledCommands(filename)
+	pub.reportError(ErrorCategoryPersistence, filename, err)
+	return err
+}
+
+// PublishScheduledSetInput publishes a $set input command that the receiving publisher applies at
+// the given time. The receiver acknowledges the command when it is scheduled.
+//  This requires that the publisher identity of the receiving input is known so the
+// command can be encrypted, unless command encryption is disabled.
+// Returns the message ID of the command, or an error if the destination publisher is unknown.
+func (pub *Publisher) PublishScheduledSetInput(inputAddr string, value string, executeAt time.Time) (string, error) {
+	messageID := lib.MakeMessageID()
+	err := inputs.PublishScheduledSetInput(inputAddr, value, executeAt, messageID, pub.Address(),
+		pub.messageSigner, nil)
+	return messageID, err
+}
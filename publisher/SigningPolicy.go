@@ -0,0 +1,35 @@
+// Package publisher with the signing policy of output message types that are published unsigned
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/sirupsen/logrus"
+)
+
+// SetUnsignedMessageTypes sets the output message types that are published without signature, eg
+// $raw for high-frequency values. Discovery, identity and command messages are always signed.
+// The policy is published to consumers, who reject unsigned messages of other types in strict mode.
+// Returns an error if one of the message types must always be signed.
+func (pub *Publisher) SetUnsignedMessageTypes(messageTypes []string) error {
+	err := pub.messageSigner.SetUnsignedMessageTypes(messageTypes)
+	if err != nil {
+		return err
+	}
+	pub.updateMutex.Lock()
+	isRunning := pub.isRunning
+	pub.updateMutex.Unlock()
+	if isRunning {
+		pub.publishSigningPolicy()
+	}
+	return nil
+}
+
+// publishSigningPolicy publishes the signing policy of this publisher
+func (pub *Publisher) publishSigningPolicy() {
+	err := identities.PublishSigningPolicy(pub.Domain(), pub.PublisherID(), pub.registeredIdentity.GetAddress(),
+		pub.messageSigner)
+	if err != nil {
+		logrus.Errorf("Publisher.publishSigningPolicy: %s", err)
+		pub.reportError(ErrorCategoryMessenger, identities.MakeSigningPolicyAddress(pub.Domain(), pub.PublisherID()), err)
+	}
+}
@@ -0,0 +1,97 @@
+// Package publisher with detection of nodes whose output values are no longer updated
+package publisher
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// SetNodeStaleTimeout makes the stale timeout of a node configurable and sets its default. When
+// none of the node's output values are updated within the timeout, the node run state is set to
+// stale and its lastSeen status is set to the time of the last update. The run state returns to
+// ready when output values are updated again. This lets consumers distinguish a sensor that
+// reports an unchanged value from a sensor that stopped reporting.
+//  seconds is the timeout in seconds. 0 disables stale detection.
+func (pub *Publisher) SetNodeStaleTimeout(nodeHWID string, seconds int) {
+	pub.registeredNodes.UpdateNodeConfig(nodeHWID, types.NodeAttrStaleTimeout, &types.ConfigAttr{
+		DataType:    types.DataTypeInt,
+		Default:     strconv.Itoa(seconds),
+		Description: "Seconds without output value updates after which the node is stale, 0 to disable",
+	})
+}
+
+// checkStaleNodes sets the run state of nodes whose output values have not been updated within
+// their stale timeout to stale. Invoked each heartbeat. The stale timeout of a node starts when
+// the check first runs, so nodes that don't report after a restart also become stale.
+// With StaleOnExpiredValues configured, nodes with an output value past its TTL are also stale.
+func (pub *Publisher) checkStaleNodes() {
+	now := lib.Now()
+	for _, node := range pub.registeredNodes.GetAllNodes() {
+		if node == nil {
+			continue
+		}
+		timeout, _ := pub.registeredNodes.GetNodeConfigInt(node.HWID, types.NodeAttrStaleTimeout, 0)
+		checkExpired := pub.config.StaleOnExpiredValues
+		if timeout <= 0 && !checkExpired {
+			continue
+		}
+		hasExpired := checkExpired && pub.hasExpiredValues(node.HWID, now)
+		pub.updateMutex.Lock()
+		lastSeen, found := pub.nodeLastSeen[node.HWID]
+		if !found {
+			pub.nodeLastSeen[node.HWID] = now
+		}
+		isTimedOut := found && timeout > 0 && now.Sub(lastSeen) >= time.Duration(timeout)*time.Second
+		isStale := found && !pub.staleNodes[node.HWID] && (isTimedOut || hasExpired)
+		if isStale {
+			pub.staleNodes[node.HWID] = true
+		}
+		pub.updateMutex.Unlock()
+
+		if isStale {
+			logrus.Warningf("Publisher.checkStaleNodes: Node %s has no updated values since %s",
+				node.HWID, lastSeen.Format(types.TimeFormat))
+			pub.registeredNodes.UpdateNodeStatus(node.HWID, types.NodeStatusMap{
+				types.NodeStatusLastSeen: lastSeen.Format(types.TimeFormat),
+				types.NodeStatusRunState: types.NodeRunStateStale,
+			})
+		}
+	}
+}
+
+// hasExpiredValues returns true if the latest value of an output of the node has passed its TTL
+func (pub *Publisher) hasExpiredValues(nodeHWID string, now time.Time) bool {
+	for _, output := range pub.registeredOutputs.GetOutputsByNodeHWID(nodeHWID) {
+		if output.ValueTTL <= 0 {
+			continue
+		}
+		latest := pub.registeredOutputValues.GetOutputValueByID(output.OutputID)
+		if latest != nil && now.Unix() >= latest.EpochTime+int64(output.ValueTTL) {
+			return true
+		}
+	}
+	return false
+}
+
+// markNodeSeen records that an output value of a node is updated. A stale node recovers to the
+// ready run state.
+func (pub *Publisher) markNodeSeen(nodeHWID string) {
+	now := lib.Now()
+	pub.updateMutex.Lock()
+	pub.nodeLastSeen[nodeHWID] = now
+	wasStale := pub.staleNodes[nodeHWID]
+	delete(pub.staleNodes, nodeHWID)
+	pub.updateMutex.Unlock()
+
+	if wasStale {
+		logrus.Infof("Publisher.markNodeSeen: Node %s is updating values again", nodeHWID)
+		pub.registeredNodes.UpdateNodeStatus(nodeHWID, types.NodeStatusMap{
+			types.NodeStatusLastSeen: now.Format(types.TimeFormat),
+			types.NodeStatusRunState: types.NodeRunStateReady,
+		})
+	}
+}
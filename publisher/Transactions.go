@@ -0,0 +1,199 @@
+package publisher
+
+import (
+	"strings"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// TransactionStatus is the outcome of a transaction
+type TransactionStatus string
+
+// Transaction outcomes
+const (
+	TransactionStatusSucceeded TransactionStatus = "succeeded" // all commands are acknowledged
+	TransactionStatusPartial   TransactionStatus = "partial"   // some commands are acknowledged before the timeout
+	TransactionStatusTimedOut  TransactionStatus = "timedout"  // no command is acknowledged before the timeout
+)
+
+// TransactionCommand is a set input command that is part of a transaction. A command is acknowledged
+// when the output of the command publishes the value of the command as its latest value.
+type TransactionCommand struct {
+	InputAddress  string // address of the input to set: domain/publisher/node/type/instance
+	Value         string // value to set the input to
+	OutputAddress string // $latest address of the output that reflects the input. Default is the input's type and instance
+	// Rollback is invoked in reverse order for the commands that are sent when the transaction doesn't
+	// succeed, so the application can restore the previous state. acknowledged tells whether the command
+	// itself was acknowledged.
+	Rollback     func(command *TransactionCommand, acknowledged bool)
+	Acknowledged bool  // the command is acknowledged by its publisher
+	Error        error // error sending the command, nil if sent
+}
+
+// TransactionResult holds the outcome of a transaction and its commands
+type TransactionResult struct {
+	Status   TransactionStatus
+	Commands []*TransactionCommand
+}
+
+// transaction tracks the acknowledgements of the commands in a running transaction
+type transaction struct {
+	commands  []*TransactionCommand
+	isSending bool                         // commands are being sent
+	pending   int                          // nr of sent commands that are not yet acknowledged
+	sent      map[*TransactionCommand]bool // commands that are sent
+	done      chan bool                    // closed when all commands are sent and acknowledged
+}
+
+// RunTransaction sends a set of input commands to one or more publishers and tracks their
+// acknowledgements as a unit. This blocks until all commands are acknowledged or the timeout expires.
+// If the transaction doesn't succeed then the rollback callbacks of the sent commands are invoked.
+// The publishers of the inputs must be known so the commands can be encrypted.
+func (pub *Publisher) RunTransaction(commands []*TransactionCommand, timeout time.Duration) *TransactionResult {
+	trans := &transaction{
+		commands:  commands,
+		isSending: true,
+		sent:      make(map[*TransactionCommand]bool),
+		done:      make(chan bool),
+	}
+	latestAddresses := make([]string, 0, len(commands))
+	for _, command := range commands {
+		command.Acknowledged = false
+		command.Error = nil
+		if command.OutputAddress == "" {
+			command.OutputAddress = makeTransactionAddress(command.InputAddress, types.MessageTypeLatest)
+		}
+		latestAddresses = append(latestAddresses, command.OutputAddress)
+	}
+	// subscribe to the output values before sending so no acknowledgement is missed
+	pub.updateMutex.Lock()
+	newAddresses := make([]string, 0)
+	for _, latestAddress := range latestAddresses {
+		transactions := pub.pendingTransactions[latestAddress]
+		if len(transactions) == 0 {
+			newAddresses = append(newAddresses, latestAddress)
+		}
+		if len(transactions) == 0 || transactions[len(transactions)-1] != trans {
+			pub.pendingTransactions[latestAddress] = append(transactions, trans)
+		}
+	}
+	pub.updateMutex.Unlock()
+	for _, latestAddress := range newAddresses {
+		pub.messageSigner.Subscribe(latestAddress, pub.handleTransactionLatest)
+	}
+
+	sent := make([]*TransactionCommand, 0, len(commands))
+	for _, command := range commands {
+		pub.updateMutex.Lock()
+		trans.pending++
+		trans.sent[command] = true
+		pub.updateMutex.Unlock()
+		setAddress := makeTransactionAddress(command.InputAddress, types.MessageTypeSetInput)
+		err := pub.PublishSetInput(setAddress, command.Value)
+		if err != nil {
+			pub.updateMutex.Lock()
+			command.Error = err
+			trans.pending--
+			delete(trans.sent, command)
+			pub.updateMutex.Unlock()
+			continue
+		}
+		sent = append(sent, command)
+	}
+	pub.updateMutex.Lock()
+	trans.isSending = false
+	if trans.pending == 0 && len(sent) > 0 {
+		close(trans.done)
+	}
+	pub.updateMutex.Unlock()
+	if len(sent) > 0 {
+		select {
+		case <-trans.done:
+		case <-time.After(timeout):
+		}
+	}
+
+	// stop tracking and unsubscribe from addresses that are no longer used
+	pub.updateMutex.Lock()
+	unusedAddresses := make([]string, 0)
+	acknowledged := 0
+	for _, command := range commands {
+		if command.Acknowledged {
+			acknowledged++
+		}
+	}
+	for _, latestAddress := range latestAddresses {
+		transactions := pub.pendingTransactions[latestAddress]
+		remaining := make([]*transaction, 0, len(transactions))
+		for _, t := range transactions {
+			if t != trans {
+				remaining = append(remaining, t)
+			}
+		}
+		if len(remaining) == 0 && len(transactions) > 0 {
+			delete(pub.pendingTransactions, latestAddress)
+			unusedAddresses = append(unusedAddresses, latestAddress)
+		} else if len(remaining) > 0 {
+			pub.pendingTransactions[latestAddress] = remaining
+		}
+	}
+	pub.updateMutex.Unlock()
+	for _, latestAddress := range unusedAddresses {
+		pub.messageSigner.Unsubscribe(latestAddress, pub.handleTransactionLatest)
+	}
+
+	result := &TransactionResult{Status: TransactionStatusTimedOut, Commands: commands}
+	if acknowledged == len(commands) && len(commands) > 0 {
+		result.Status = TransactionStatusSucceeded
+		return result
+	} else if acknowledged > 0 {
+		result.Status = TransactionStatusPartial
+	}
+	logrus.Warningf("RunTransaction: Transaction %s. %d of %d commands acknowledged",
+		result.Status, acknowledged, len(commands))
+	for i := len(sent) - 1; i >= 0; i-- {
+		if sent[i].Rollback != nil {
+			sent[i].Rollback(sent[i], sent[i].Acknowledged)
+		}
+	}
+	return result
+}
+
+// handleTransactionLatest acknowledges the commands of pending transactions whose output
+// publishes the value of the command.
+func (pub *Publisher) handleTransactionLatest(address string, message string) error {
+	var latest types.OutputLatestMessage
+	_, err := messaging.VerifySenderJWSSignature(message, &latest, pub.GetPublisherKey)
+	if err != nil {
+		return lib.MakeErrorf("handleTransactionLatest: Invalid value on %s: %s", address, err)
+	}
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	for _, trans := range pub.pendingTransactions[address] {
+		for _, command := range trans.commands {
+			if command.OutputAddress == address && trans.sent[command] &&
+				!command.Acknowledged && command.Value == latest.Value {
+				command.Acknowledged = true
+				trans.pending--
+				if trans.pending == 0 && !trans.isSending {
+					close(trans.done)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// makeTransactionAddress replaces the message type of an input address
+//  inputAddress is the input address: domain/publisher/node/type/instance[/messageType]
+func makeTransactionAddress(inputAddress string, messageType string) string {
+	segments := strings.Split(inputAddress, "/")
+	if len(segments) > 5 {
+		segments = segments[:5]
+	}
+	return strings.Join(segments, "/") + "/" + messageType
+}
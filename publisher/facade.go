@@ -5,9 +5,12 @@ package publisher
 
 import (
 	"crypto/ecdsa"
+	"time"
 
+	"github.com/iotdomain/iotdomain-go/identities"
 	"github.com/iotdomain/iotdomain-go/inputs"
 	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/nodes"
 	"github.com/iotdomain/iotdomain-go/outputs"
 	"github.com/iotdomain/iotdomain-go/types"
@@ -21,21 +24,88 @@ func (pub *Publisher) Address() string {
 	return pub.registeredIdentity.GetAddress()
 }
 
+// AddAttrReader authorizes a publisher to read the private node attributes of this publisher's nodes,
+// and in pseudonymous mode, the mapping of pseudonyms.
+// The private attributes are set with the PrivateNodeAttr configuration. The reader's identity must be
+// discovered to be able to encrypt for it. Nodes are republished with the updated set of readers.
+//  readerAddress is the address of the reader publisher: domain/publisherID[/$identity]
+func (pub *Publisher) AddAttrReader(readerAddress string) {
+	pub.updateMutex.Lock()
+	for _, addr := range pub.attrReaders {
+		if addr == readerAddress {
+			pub.updateMutex.Unlock()
+			return
+		}
+	}
+	pub.attrReaders = append(pub.attrReaders, readerAddress)
+	pub.updateMutex.Unlock()
+	pub.registeredNodes.RepublishNodes(pub.registeredNodes.GetAllNodes())
+	pub.publishPseudonyms(true)
+}
+
 // CreateInput creates a new node input that handle set commands and add it to the registered inputs
 //  If an input of the given nodeHWID, type and instance already exist it will be replaced. This returns the new input
 func (pub *Publisher) CreateInput(nodeHWID string, inputType types.InputType, instance string,
 	setCommandHandler func(input *types.InputDiscoveryMessage, sender string, value string)) *types.InputDiscoveryMessage {
-	input := pub.inputFromSetCommands.CreateInput(nodeHWID, inputType, instance, setCommandHandler)
+	input := pub.inputFromSetCommands.CreateInput(
+		nodeHWID, inputType, instance, pub.wrapInputHandler(nodeHWID, setCommandHandler))
+	pub.applyPseudonym(nodeHWID)
 	return input
 }
 
+// CreateInputFromExec periodically runs a command and sends its output to an input. Intended for
+// wrapping command line tools, eg ipmitool or smartctl. The command runs without a shell, with only
+// PATH in its environment, and is killed after inputs.DefaultExecTimeout seconds.
+// This returns an error if the command line is invalid.
+//  cmdLine is the command and its arguments. Quotes group arguments that contain spaces.
+//  intervalSec is the interval in seconds to run the command
+func (pub *Publisher) CreateInputFromExec(
+	nodeHWID string, inputType types.InputType, instance string, cmdLine string, intervalSec int,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) (*types.InputDiscoveryMessage, error) {
+
+	options := inputs.ExecInputOptions{PollInterval: intervalSec}
+	return pub.CreateInputFromExecWithOptions(nodeHWID, inputType, instance, cmdLine, options, handler)
+}
+
+// CreateInputFromExecWithOptions periodically runs a command using the given options for its
+// environment, working directory, timeout and output size, and sends its output to an input.
+// This returns an error if the command line is invalid.
+func (pub *Publisher) CreateInputFromExecWithOptions(
+	nodeHWID string, inputType types.InputType, instance string, cmdLine string, options inputs.ExecInputOptions,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) (*types.InputDiscoveryMessage, error) {
+
+	input, err := pub.inputFromExec.CreateExecInputWithOptions(
+		nodeHWID, inputType, instance, cmdLine, options, pub.wrapInputHandler(nodeHWID, handler))
+	if err == nil {
+		pub.applyPseudonym(nodeHWID)
+	}
+	return input, err
+}
+
 // CreateInputFromFile sends a file or folder to an input when it is modified - TODO
 // The input handler is triggered with a message containing the path as value
+// The path can be a glob pattern of files in a single folder, eg /var/data/*.json
 func (pub *Publisher) CreateInputFromFile(
 	nodeHWID string, inputType types.InputType, instance string, path string,
 	handler func(input *types.InputDiscoveryMessage, sender string, value string)) *types.InputDiscoveryMessage {
 
-	input := pub.inputFromFiles.CreateInput(nodeHWID, inputType, instance, path, handler)
+	input := pub.inputFromFiles.CreateInput(
+		nodeHWID, inputType, instance, path, pub.wrapInputHandler(nodeHWID, handler))
+	pub.applyPseudonym(nodeHWID)
+	return input
+}
+
+// CreateInputFromFileTail sends the lines that are appended to a file to an input, like tail -F.
+// The input handler is triggered for each new line with the file path as sender. Rotated files are
+// followed. Intended for log scraping without reprocessing the whole file on each change.
+//  pattern is the file path or a glob pattern of files in a single folder, eg /var/log/app/*.log
+func (pub *Publisher) CreateInputFromFileTail(
+	nodeHWID string, inputType types.InputType, instance string, pattern string,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) *types.InputDiscoveryMessage {
+
+	input := pub.inputFromFiles.CreateTailInput(
+		nodeHWID, inputType, instance, pattern, pub.wrapInputHandler(nodeHWID, handler))
+	pub.applyPseudonym(nodeHWID)
 	return input
 }
 
@@ -45,24 +115,70 @@ func (pub *Publisher) CreateInputFromHTTP(
 	handler func(input *types.InputDiscoveryMessage, sender string, value string)) {
 
 	input := pub.inputFromHTTP.CreateHTTPInput(
-		nodeHWID, inputType, instance, url, login, password, intervalSec, handler)
+		nodeHWID, inputType, instance, url, login, password, intervalSec, pub.wrapInputHandler(nodeHWID, handler))
+	pub.applyPseudonym(nodeHWID)
 	_ = input
 }
 
+// CreateInputFromHTTPWithOptions periodically polls an http address using the given request options
+// for authentication, headers, TLS client certificates and redirects, and sends the response to an input.
+// This returns an error if the TLS certificates of the options cannot be loaded.
+func (pub *Publisher) CreateInputFromHTTPWithOptions(
+	nodeHWID string, inputType types.InputType, instance string, url string, options inputs.HTTPInputOptions,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) (*types.InputDiscoveryMessage, error) {
+
+	input, err := pub.inputFromHTTP.CreateHTTPInputWithOptions(
+		nodeHWID, inputType, instance, url, options, pub.wrapInputHandler(nodeHWID, handler))
+	if err == nil {
+		pub.applyPseudonym(nodeHWID)
+	}
+	return input, err
+}
+
 // CreateInputFromOutput subscribes to an output and triggers the input when a new value is received
 func (pub *Publisher) CreateInputFromOutput(
 	nodeHWID string, inputType types.InputType, instance string, outputAddress string,
 	handler func(input *types.InputDiscoveryMessage, sender string, value string)) {
 
-	input := pub.inputFromOutputs.CreateInput(nodeHWID, inputType, instance, outputAddress, handler)
+	pub.CreateInputFromOutputWithOptions(nodeHWID, inputType, instance, outputAddress, inputs.RemoteOutputOptions{}, handler)
+}
 
-	_ = input
+// CreateInputFromOutputWithOptions subscribes to an output of another publisher and triggers the input
+// when a new value is received. The options control the signature verification, conversion of the value
+// and buffering while the other publisher is offline. The status handler of the options is notified
+// when the run state of the remote node changes, eg when its publisher is lost.
+// Returns the input.
+func (pub *Publisher) CreateInputFromOutputWithOptions(
+	nodeHWID string, inputType types.InputType, instance string, outputAddress string,
+	options inputs.RemoteOutputOptions,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) *types.InputDiscoveryMessage {
+
+	if statusHandler := options.StatusHandler; statusHandler != nil {
+		options.StatusHandler = func(input *types.InputDiscoveryMessage, runState string) {
+			defer pub.recoverHandler("remote status", nodeHWID, outputAddress)
+			statusHandler(input, runState)
+		}
+	}
+	input := pub.inputFromOutputs.CreateInputWithOptions(
+		nodeHWID, inputType, instance, outputAddress, options, pub.wrapInputHandler(nodeHWID, handler))
+	pub.applyPseudonym(nodeHWID)
+	return input
+}
+
+// GetRemoteOutputStatus returns the run state of the remote node whose output is used by an input that
+// is created with CreateInputFromOutput, eg NodeRunStateLost when its publisher is offline.
+// Returns "" if the run state is not known.
+func (pub *Publisher) GetRemoteOutputStatus(nodeHWID string, inputType types.InputType, instance string) string {
+	return pub.inputFromOutputs.GetRemoteStatus(inputs.MakeInputHWID(nodeHWID, inputType, instance))
 }
 
 // CreateNode creates a new node and add it to this publisher's registered nodes
 // returns the new node instance
 func (pub *Publisher) CreateNode(nodeHWID string, nodeType types.NodeType) *types.NodeDiscoveryMessage {
 	node := pub.registeredNodes.CreateNode(nodeHWID, nodeType)
+	if pub.applyPseudonym(nodeHWID) {
+		node = pub.registeredNodes.GetNodeByHWID(nodeHWID)
+	}
 	return node
 }
 
@@ -71,12 +187,71 @@ func (pub *Publisher) CreateNode(nodeHWID string, nodeType types.NodeType) *type
 func (pub *Publisher) CreateOutput(nodeHWID string, outputType types.OutputType,
 	instance string) *types.OutputDiscoveryMessage {
 	output := pub.registeredOutputs.CreateOutput(nodeHWID, outputType, instance)
+	pub.applyPseudonym(nodeHWID)
+	pub.applyNodeCalibrations(nodeHWID)
+	pub.applyOutputHistoryRetention(nodeHWID, output.OutputID)
 	return output
 }
 
-// DeleteNode deletes a node from the collection of registered nodes
+// DeleteInput removes an input, eg when a device loses a capability. The input no longer receives
+// values from its source and its retained discovery is removed from the message bus.
+// Returns an error if the input doesn't exist.
+func (pub *Publisher) DeleteInput(nodeHWID string, inputType types.InputType, instance string) error {
+	inputID := inputs.MakeInputHWID(nodeHWID, inputType, instance)
+	input := pub.registeredInputs.GetInputByID(inputID)
+	if input == nil {
+		return lib.MakeErrorf("DeleteInput: Unknown input '%s'", inputID)
+	}
+	// stop the source that feeds the input
+	switch {
+	case pub.inputFromExec.HasInput(inputID):
+		pub.inputFromExec.DeleteInput(inputID)
+	case pub.inputFromFiles.HasInput(inputID):
+		pub.inputFromFiles.DeleteInput(nodeHWID, inputType, instance)
+	case pub.inputFromHTTP.HasInput(inputID):
+		pub.inputFromHTTP.DeleteInput(inputID)
+	case pub.inputFromOutputs.HasInput(inputID):
+		pub.inputFromOutputs.DeleteInput(inputID)
+	case pub.inputFailover.HasInput(inputID):
+		pub.inputFailover.DeleteInput(inputID)
+	default:
+		pub.inputFromSetCommands.DeleteInput(inputID)
+	}
+	pub.clearRetained([]string{input.Address})
+	return nil
+}
+
+// DeleteNode soft deletes a node from the collection of registered nodes. The node, its inputs and
+// outputs are no longer published and its inputs ignore commands. The node configuration is retained
+// for the configured nr of days during which it can be restored with RestoreNode.
 func (pub *Publisher) DeleteNode(hwAddress string) {
-	pub.registeredNodes.DeleteNode(hwAddress)
+	if pub.registeredNodes.DeleteNode(hwAddress) {
+		pub.registeredInputs.SetNodeDisabled(hwAddress, true)
+	}
+}
+
+// DeleteOutput removes an output, eg when a device loses a capability. The output's value
+// history, forecast and energy counters are dropped and its retained discovery and values are
+// removed from the message bus.
+// Returns an error if the output doesn't exist.
+func (pub *Publisher) DeleteOutput(nodeHWID string, outputType types.OutputType, instance string) error {
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	output := pub.registeredOutputs.GetOutputByID(outputID)
+	if output == nil || !pub.registeredOutputs.DeleteOutput(outputID) {
+		return lib.MakeErrorf("DeleteOutput: Unknown output '%s'", outputID)
+	}
+	pub.registeredOutputValues.DeleteOutputValues(outputID)
+	pub.registeredForecastValues.DeleteForecast(outputID)
+
+	pub.updateMutex.Lock()
+	delete(pub.deferredOutputs, outputID)
+	delete(pub.derivedOutputs, outputID)
+	delete(pub.energyOutputs, outputID)
+	delete(pub.historyRetention, outputID)
+	pub.updateMutex.Unlock()
+
+	pub.clearRetained(getOutputRetainedAddresses(output))
+	return nil
 }
 
 // Domain returns the publication domain
@@ -91,6 +266,18 @@ func (pub *Publisher) Domain() string {
 // 	return *ident
 // }
 
+// GetAttrReaders returns the addresses of publishers authorized to read private node attributes
+func (pub *Publisher) GetAttrReaders() []string {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	return append([]string{}, pub.attrReaders...)
+}
+
+// GetDeletedNodes returns the soft deleted nodes that can still be restored
+func (pub *Publisher) GetDeletedNodes() []*types.NodeDiscoveryMessage {
+	return pub.registeredNodes.GetDeletedNodes()
+}
+
 // GetDomainInput returns a discovered domain input
 func (pub *Publisher) GetDomainInput(address string) *types.InputDiscoveryMessage {
 	return pub.domainInputs.GetInputByAddress(address)
@@ -101,6 +288,17 @@ func (pub *Publisher) GetDomainInputs() []*types.InputDiscoveryMessage {
 	return pub.domainInputs.GetAllInputs()
 }
 
+// GetDomainInputsByPattern returns the discovered domain inputs whose address matches a pattern
+// with '+' wildcards, eg domain/+/+/switch/+
+func (pub *Publisher) GetDomainInputsByPattern(pattern string) []*types.InputDiscoveryMessage {
+	return pub.domainInputs.GetInputsByPattern(pattern)
+}
+
+// GetDomainInputsByType returns the discovered domain inputs of the given type
+func (pub *Publisher) GetDomainInputsByType(inputType types.InputType) []*types.InputDiscoveryMessage {
+	return pub.domainInputs.GetInputsByType(inputType)
+}
+
 // GetDomainNode returns a discovered domain node by its address
 func (pub *Publisher) GetDomainNode(address string) *types.NodeDiscoveryMessage {
 	return pub.domainNodes.GetNodeByAddress(address)
@@ -111,16 +309,65 @@ func (pub *Publisher) GetDomainNodes() []*types.NodeDiscoveryMessage {
 	return pub.domainNodes.GetAllNodes()
 }
 
+// GetDomainNodesByPattern returns the discovered domain nodes whose address matches a pattern
+// with '+' wildcards, eg domain/+/node1
+func (pub *Publisher) GetDomainNodesByPattern(pattern string) []*types.NodeDiscoveryMessage {
+	return pub.domainNodes.GetNodesByPattern(pattern)
+}
+
+// GetDomainNodesByType returns the discovered domain nodes of the given type
+func (pub *Publisher) GetDomainNodesByType(nodeType types.NodeType) []*types.NodeDiscoveryMessage {
+	return pub.domainNodes.GetNodesByType(nodeType)
+}
+
+// GetDomainNodesNear returns the discovered domain nodes located within the radius in meters of a
+// location, nearest first
+func (pub *Publisher) GetDomainNodesNear(latitude float64, longitude float64, radius float64) []*types.NodeDiscoveryMessage {
+	return pub.domainNodes.GetNodesNear(latitude, longitude, radius)
+}
+
 // GetDomainOutput returns a discovered domain output by its address
 func (pub *Publisher) GetDomainOutput(address string) *types.OutputDiscoveryMessage {
 	return pub.domainOutputs.GetOutputByAddress(address)
 }
 
+// GetDomainOutputForecast returns the last received forecast of a domain output, or nil if no
+// forecast was received. Use SubscribeToOutputForecast to receive forecasts.
+//  forecastAddress is the output $forecast address
+func (pub *Publisher) GetDomainOutputForecast(forecastAddress string) *types.OutputForecastMessage {
+	forecast, _ := pub.domainOutputValues.GetForecast(forecastAddress)
+	return forecast
+}
+
+// GetDomainOutputsExpired returns the latest values of domain outputs that have passed their valid
+// until time. Use SubscribeToOutputLatest to receive latest values.
+func (pub *Publisher) GetDomainOutputsExpired() []*types.OutputLatestMessage {
+	return pub.domainOutputValues.GetExpiredLatest()
+}
+
 // GetDomainOutputs returns all discovered domain outputs
 func (pub *Publisher) GetDomainOutputs() []*types.OutputDiscoveryMessage {
 	return pub.domainOutputs.GetAllOutputs()
 }
 
+// GetDomainOutputsByPattern returns the discovered domain outputs whose address matches a pattern
+// with '+' wildcards, eg domain/+/+/temperature/+
+func (pub *Publisher) GetDomainOutputsByPattern(pattern string) []*types.OutputDiscoveryMessage {
+	return pub.domainOutputs.GetOutputsByPattern(pattern)
+}
+
+// IsDomainOutputExpired returns true if the latest value of a domain output has passed its valid
+// until time. Values of outputs without a TTL don't expire.
+//  latestAddress is the output $latest address
+func (pub *Publisher) IsDomainOutputExpired(latestAddress string) bool {
+	return pub.domainOutputValues.IsLatestExpired(latestAddress)
+}
+
+// GetDomainOutputsByType returns the discovered domain outputs of the given type
+func (pub *Publisher) GetDomainOutputsByType(outputType types.OutputType) []*types.OutputDiscoveryMessage {
+	return pub.domainOutputs.GetOutputsByType(outputType)
+}
+
 // GetDomainPublishers returns all discovered domain publishers
 func (pub *Publisher) GetDomainPublishers() []*types.PublisherIdentityMessage {
 	return pub.domainIdentities.GetAllPublishers()
@@ -146,6 +393,11 @@ func (pub *Publisher) GetInputs() []*types.InputDiscoveryMessage {
 	return pub.registeredInputs.GetAllInputs()
 }
 
+// GetInputsByNodeHWID returns the registered inputs of a node
+func (pub *Publisher) GetInputsByNodeHWID(nodeHWID string) []*types.InputDiscoveryMessage {
+	return pub.registeredInputs.GetInputsByNodeHWID(nodeHWID)
+}
+
 // GetIdentity returns the publisher public identity including public signing key
 func (pub *Publisher) GetIdentity() *types.PublisherIdentityMessage {
 	ident, _ := pub.registeredIdentity.GetFullIdentity()
@@ -233,11 +485,27 @@ func (pub *Publisher) GetOutputByID(outputID string) *types.OutputDiscoveryMessa
 	return pub.registeredOutputs.GetOutputByID(outputID)
 }
 
+// GetOutputForecast returns the forecast of a registered output with values whose time has
+// passed removed, or nil if the output has no forecast
+func (pub *Publisher) GetOutputForecast(outputID string) outputs.OutputForecast {
+	return pub.registeredForecastValues.GetForecast(outputID)
+}
+
+// GetOutputHistory returns the value history of a registered output, newest value first
+func (pub *Publisher) GetOutputHistory(outputID string) outputs.OutputHistory {
+	return pub.registeredOutputValues.GetHistory(outputID)
+}
+
 // GetOutputs returns a list of all registered outputs
 func (pub *Publisher) GetOutputs() []*types.OutputDiscoveryMessage {
 	return pub.registeredOutputs.GetAllOutputs()
 }
 
+// GetOutputsByNodeHWID returns the registered outputs of a node
+func (pub *Publisher) GetOutputsByNodeHWID(nodeHWID string) []*types.OutputDiscoveryMessage {
+	return pub.registeredOutputs.GetOutputsByNodeHWID(nodeHWID)
+}
+
 // GetOutputValueByNodeHWID returns the registered output's value object including timestamp
 func (pub *Publisher) GetOutputValueByNodeHWID(nodeHWID string, outputType types.OutputType, instance string) *types.OutputValue {
 	return pub.registeredOutputValues.GetOutputValueByType(nodeHWID, outputType, instance)
@@ -254,28 +522,70 @@ func (pub *Publisher) GetPublisherKey(address string) *ecdsa.PublicKey {
 	return pub.domainIdentities.GetPublisherKey(address)
 }
 
+// GetSubscriptions returns the addresses of the active subscriptions of this publisher, sorted by address
+func (pub *Publisher) GetSubscriptions() []string {
+	return pub.messageSigner.GetSubscriptions()
+}
+
 // MakeNodeDiscoveryAddress makes the node discovery address using the publisher domain and publisherID
 func (pub *Publisher) MakeNodeDiscoveryAddress(nodeID string) string {
 	addr := nodes.MakeNodeDiscoveryAddress(pub.Domain(), pub.PublisherID(), nodeID)
 	return addr
 }
 
+// MessageSigner returns the signer of the publisher's messages, for use by integrations that
+// publish and subscribe on behalf of the publisher.
+func (pub *Publisher) MessageSigner() *messaging.MessageSigner {
+	return pub.messageSigner
+}
+
+// NodesSnapshot returns copies of the registered nodes, sorted by node ID
+// Use this to iterate the nodes from handlers without holding a lock or contending with publication.
+func (pub *Publisher) NodesSnapshot() []*types.NodeDiscoveryMessage {
+	return pub.registeredNodes.Snapshot()
+}
+
+// NotifyInputHandler passes a set command that is received by an integration, eg through another
+// protocol, to the handler of a registered input. Inputs of disabled nodes are not set.
+//  sender identifies the source of the command
+func (pub *Publisher) NotifyInputHandler(inputID string, sender string, value string) {
+	pub.registeredInputs.NotifyInputHandler(inputID, sender, value)
+}
+
+// OutputsSnapshot returns copies of the registered outputs, sorted by output ID
+// Use this to iterate the outputs from handlers without holding a lock or contending with publication.
+func (pub *Publisher) OutputsSnapshot() []*types.OutputDiscoveryMessage {
+	return pub.registeredOutputs.Snapshot()
+}
+
 // PublisherID returns the publisher's ID
 func (pub *Publisher) PublisherID() string {
 	ident, _ := pub.registeredIdentity.GetFullIdentity()
 	return ident.PublisherID
 }
 
+// PublishInputConfigure publishes a $configure command to a domain input, eg to change the URL or
+// poll interval of the input. The command is encrypted with the public key of the input publisher.
+// Returns true if successful, false if the command is not sent.
+func (pub *Publisher) PublishInputConfigure(domainInputAddr string, attr types.NodeAttrMap) bool {
+	err := inputs.PublishInputConfigure(domainInputAddr, attr, pub.Address(), pub.messageSigner, nil)
+	if err != nil {
+		logrus.Warnf("PublishInputConfigure: %s", err)
+		return false
+	}
+	return true
+}
+
 // PublishNodeConfigure publishes a $configure command to a domain node
+// The command is encrypted with the public key of the domain node publisher.
 // Returns true if successful, false if the domain node publisher cannot be found or has no public key
-// and the message is not sent.
+// while command encryption is required, and the message is not sent.
 func (pub *Publisher) PublishNodeConfigure(domainNodeAddr string, attr types.NodeAttrMap) bool {
-	destPubKey := pub.GetPublisherKey(domainNodeAddr)
-	if destPubKey == nil {
-		logrus.Warnf("PublishConfigureNode: no public key found to encrypt command for node %s. Message not sent.", domainNodeAddr)
+	err := nodes.PublishNodeConfigure(domainNodeAddr, attr, pub.Address(), pub.messageSigner, nil)
+	if err != nil {
+		logrus.Warnf("PublishConfigureNode: %s", err)
 		return false
 	}
-	nodes.PublishNodeConfigure(domainNodeAddr, attr, pub.Address(), pub.messageSigner, destPubKey)
 	return true
 }
 
@@ -293,21 +603,36 @@ func (pub *Publisher) PublishRaw(output *types.OutputDiscoveryMessage, sign bool
 	outputs.PublishOutputRaw(output, value, pub.messageSigner)
 }
 
-// PublishOutputEvent publishes all outputs of the node in a single event
-func (pub *Publisher) PublishOutputEvent(node *types.NodeDiscoveryMessage) error {
+// PublishNodeOutputsEvent publishes the values of all outputs of the node in a single event
+func (pub *Publisher) PublishNodeOutputsEvent(node *types.NodeDiscoveryMessage) error {
 	return PublishOutputEvent(node, pub.registeredOutputs, pub.registeredOutputValues, pub.messageSigner)
 }
 
+// PublishOutputEvent immediately publishes a discrete event of a registered node, eg motion detected
+// or door opened, on the node's $event address. Use SubscribeToOutputEvents to receive events.
+//  eventType is the type of event, eg types.OutputEventMotion
+//  payload is optional event information
+// This returns an error if the node doesn't exist or is deleted.
+func (pub *Publisher) PublishOutputEvent(nodeHWID string, eventType types.OutputEventType, payload string) error {
+	node := pub.registeredNodes.GetNodeByHWID(nodeHWID)
+	if node == nil || pub.isNodeDeleted(nodeHWID) {
+		return lib.MakeErrorf("PublishOutputEvent: Node %s not found", nodeHWID)
+	}
+	return outputs.PublishOutputEvent(node, eventType, payload, pub.messageSigner)
+}
+
+// PublishRefresh publishes a request to all publishers in the domain to republish their discovery
+func (pub *Publisher) PublishRefresh() error {
+	err := identities.PublishRefresh(pub.Domain(), pub.Address(), pub.messageSigner)
+	return err
+}
+
 // PublishSetInput publishes a $setInput input command to the given input address
 //  This requires that the publisher identity of the receiving input is known so the
-// command can be encrypted.
+// command can be encrypted, unless command encryption is disabled.
 // Returns error if the destination publisher is unknown and the message cannot be sent.
 func (pub *Publisher) PublishSetInput(inputAddr string, value string) error {
-	destPubKey := pub.GetPublisherKey(inputAddr)
-	if destPubKey == nil {
-		return lib.MakeErrorf("PublishSetInput: no public key found to encrypt command for set input to %s. Message not sent.", inputAddr)
-	}
-	err := inputs.PublishSetInput(inputAddr, value, pub.Address(), pub.messageSigner, destPubKey)
+	err := inputs.PublishSetInput(inputAddr, value, pub.Address(), pub.messageSigner, nil)
 	return err
 }
 
@@ -325,6 +650,86 @@ func (pub *Publisher) PublishSetNodeID(nodeAddr string, newNodeID string) error
 	return err
 }
 
+// RemoveAttrReader revokes a publisher's authorization to read private node attributes.
+// Nodes are republished without encryption for this reader.
+func (pub *Publisher) RemoveAttrReader(readerAddress string) {
+	pub.updateMutex.Lock()
+	for index, addr := range pub.attrReaders {
+		if addr == readerAddress {
+			pub.attrReaders = append(pub.attrReaders[:index], pub.attrReaders[index+1:]...)
+			break
+		}
+	}
+	pub.updateMutex.Unlock()
+	pub.registeredNodes.RepublishNodes(pub.registeredNodes.GetAllNodes())
+	pub.publishPseudonyms(true)
+}
+
+// RemoveSubscription removes all subscriptions of this publisher to the given address
+func (pub *Publisher) RemoveSubscription(address string) {
+	pub.messageSigner.Unsubscribe(address, nil)
+}
+
+// RestoreNode restores a soft deleted node with its configuration and re-enables its inputs.
+// The node, its inputs and outputs are republished with the next heartbeat.
+// Returns the restored node or nil if the node is not deleted or already purged.
+func (pub *Publisher) RestoreNode(nodeHWID string) *types.NodeDiscoveryMessage {
+	node := pub.registeredNodes.RestoreNode(nodeHWID)
+	if node == nil {
+		return nil
+	}
+	pub.registeredInputs.SetNodeDisabled(nodeHWID, false)
+	for _, input := range pub.registeredInputs.GetInputsByNodeHWID(nodeHWID) {
+		pub.registeredInputs.RepublishInput(input)
+	}
+	for _, output := range pub.registeredOutputs.GetOutputsByNodeHWID(nodeHWID) {
+		pub.registeredOutputs.RepublishOutput(output)
+	}
+	return node
+}
+
+// SetDomainNodeMovedHandler sets the handler that is invoked when the location of a discovered
+// domain node changes, eg of a vehicle. Use nil to remove the handler.
+//  previous is the previous location of the node, nil if it had no location
+func (pub *Publisher) SetDomainNodeMovedHandler(
+	handler func(node *types.NodeDiscoveryMessage, previous *types.NodeLocation)) {
+	if handler == nil {
+		pub.domainNodes.SetMovedHandler(nil)
+		return
+	}
+	pub.domainNodes.SetMovedHandler(func(node *types.NodeDiscoveryMessage, previous *types.NodeLocation) {
+		defer pub.recoverHandler("node moved", "", node.Address)
+		handler(node, previous)
+	})
+}
+
+// SetOutputPublishHooks sets the hooks that are invoked before and after publication of an output value.
+// The before publish hook can modify the value or veto the publication, for example to clamp a setpoint.
+// The after publish hook receives the result of the publication. Use nil to remove a hook.
+// A hook that panics is recovered. A panicking before publish hook vetoes the publication.
+func (pub *Publisher) SetOutputPublishHooks(nodeHWID string, outputType types.OutputType, instance string,
+	beforePublish outputs.BeforePublishHook, afterPublish outputs.AfterPublishHook) {
+
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	pub.registeredOutputs.SetPublishHooks(outputID,
+		pub.recoverBeforePublishHook(nodeHWID, beforePublish), pub.recoverAfterPublishHook(nodeHWID, afterPublish))
+}
+
+// SetOutputHistoryRetention sets the history retention policy of an output
+//  retention is the policy to use, or nil to use the publisher's default retention
+func (pub *Publisher) SetOutputHistoryRetention(nodeHWID string, outputType types.OutputType, instance string,
+	retention *outputs.HistoryRetention) {
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	pub.updateMutex.Lock()
+	if retention == nil {
+		delete(pub.historyRetention, outputID)
+	} else {
+		pub.historyRetention[outputID] = *retention
+	}
+	pub.updateMutex.Unlock()
+	pub.applyOutputHistoryRetention(nodeHWID, outputID)
+}
+
 // SetSigningOnOff turns signing of publications on or off.
 //  The default is on (true)
 func (pub *Publisher) SetSigningOnOff(onOff bool) {
@@ -333,6 +738,8 @@ func (pub *Publisher) SetSigningOnOff(onOff bool) {
 
 // Subscribe to receive nodes, inputs and outputs from the selected domain and/or publisher
 // To subscribe to all domains or all publishers use "" as the domain or publisherID
+// The lookup indexes of the domain registries are built once the burst of retained discovery
+// messages subsides, see BulkLoadQuiet in the publisher configuration.
 func (pub *Publisher) Subscribe(domain string, publisherID string) {
 	// subscription address for all outputs domain/publisher/node/type/instance/$output
 	if domain == "" {
@@ -341,11 +748,98 @@ func (pub *Publisher) Subscribe(domain string, publisherID string) {
 	if publisherID == "" {
 		publisherID = "+"
 	}
+	// defer building the indexes while the retained discovery messages are received
+	quietPeriod := pub.config.BulkLoadQuiet
+	if quietPeriod == 0 {
+		quietPeriod = lib.DefaultBulkLoadQuiet
+	}
+	if quietPeriod > 0 {
+		pub.domainNodes.StartBulkLoad(time.Duration(quietPeriod) * time.Millisecond)
+		pub.domainInputs.StartBulkLoad(time.Duration(quietPeriod) * time.Millisecond)
+		pub.domainOutputs.StartBulkLoad(time.Duration(quietPeriod) * time.Millisecond)
+	}
 	pub.domainNodes.Subscribe(domain, publisherID)
 	pub.domainInputs.Subscribe(domain, publisherID)
 	pub.domainOutputs.Subscribe(domain, publisherID)
 }
 
+// SubscribeToOutputEvents subscribes to the events of domain nodes. The event is only passed to the
+// handler if its signature verifies using the public key of the publisher, or if it is unsigned
+// as allowed by the signing policy of the publisher. Events are either
+// discrete events with an EventType, or the values of all outputs of a node.
+//  eventAddress is the node $event address, which can contain wildcards, eg domain/+/+/$event
+func (pub *Publisher) SubscribeToOutputEvents(eventAddress string, handler func(event *types.OutputEventMessage)) {
+	pub.messageSigner.Subscribe(eventAddress, func(address string, message string) error {
+		var event types.OutputEventMessage
+		_, err := pub.messageSigner.VerifyPublication(address, message, &event)
+		if err != nil {
+			return lib.MakeErrorf("SubscribeToOutputEvents: Invalid event on %s: %s", address, err)
+		}
+		pub.domainOutputValues.UpdateEvent(&event)
+		defer pub.recoverHandler("event", "", address)
+		handler(&event)
+		return nil
+	})
+}
+
+// SubscribeToOutputForecast subscribes to the forecast of domain outputs. The forecast is only passed
+// to the handler if its signature verifies using the public key of the publisher. The last received
+// forecast is available with GetDomainOutputForecast.
+//  forecastAddress is the output $forecast address, which can contain wildcards
+func (pub *Publisher) SubscribeToOutputForecast(forecastAddress string, handler func(forecast *types.OutputForecastMessage)) {
+	pub.messageSigner.Subscribe(forecastAddress, func(address string, message string) error {
+		var forecast types.OutputForecastMessage
+		_, err := pub.messageSigner.VerifyPublication(address, message, &forecast)
+		if err != nil {
+			return lib.MakeErrorf("SubscribeToOutputForecast: Invalid forecast on %s: %s", address, err)
+		}
+		pub.domainOutputValues.UpdateForecast(&forecast)
+		if handler != nil {
+			defer pub.recoverHandler("forecast", "", address)
+			handler(&forecast)
+		}
+		return nil
+	})
+}
+
+// SubscribeToOutputHistory subscribes to the history of domain outputs. The history is only passed to
+// the handler if its signature verifies using the public key of the publisher.
+//  historyAddress is the output $history address, which can contain wildcards
+func (pub *Publisher) SubscribeToOutputHistory(historyAddress string, handler func(history *types.OutputHistoryMessage)) {
+	pub.messageSigner.Subscribe(historyAddress, func(address string, message string) error {
+		var history types.OutputHistoryMessage
+		_, err := pub.messageSigner.VerifyPublication(address, message, &history)
+		if err != nil {
+			return lib.MakeErrorf("SubscribeToOutputHistory: Invalid history on %s: %s", address, err)
+		}
+		defer pub.recoverHandler("history", "", address)
+		handler(&history)
+		return nil
+	})
+}
+
+// SubscribeToOutputLatest subscribes to the latest value of domain outputs. The value is only passed to
+// the handler if its signature verifies using the public key of the publisher. Values that are older
+// than a value already received, as determined by their sequence nr, are discarded so the handler never
+// receives an older value after a newer one.
+//  latestAddress is the output $latest address, which can contain wildcards
+func (pub *Publisher) SubscribeToOutputLatest(latestAddress string, handler func(latest *types.OutputLatestMessage)) {
+	pub.messageSigner.Subscribe(latestAddress, func(address string, message string) error {
+		var latest types.OutputLatestMessage
+		_, err := pub.messageSigner.VerifyPublication(address, message, &latest)
+		if err != nil {
+			return lib.MakeErrorf("SubscribeToOutputLatest: Invalid value on %s: %s", address, err)
+		}
+		if !pub.domainOutputValues.UpdateLatest(&latest) {
+			logrus.Infof("SubscribeToOutputLatest: Discarded value with older sequence %d on %s", latest.Sequence, address)
+			return nil
+		}
+		defer pub.recoverHandler("latest", "", address)
+		handler(&latest)
+		return nil
+	})
+}
+
 // Unsubscribe from receiving nodes, inputs and outputs from the selected domain and/or publisher
 // Use the same domain and publisherID as used in Subscribe
 func (pub *Publisher) Unsubscribe(domain string, publisherID string) {
@@ -374,6 +868,15 @@ func (pub *Publisher) UpdateNodeAttr(nodeHWID string, attrParams types.NodeAttrM
 	return pub.registeredNodes.UpdateNodeAttr(nodeHWID, attrParams)
 }
 
+// UpdateInputConfig adds or replaces a configuration attribute of a registered input and publishes
+// the updated input. The attribute value can then be changed with an input configure command.
+// Returns an error if the input doesn't exist.
+func (pub *Publisher) UpdateInputConfig(nodeHWID string, inputType types.InputType, instance string,
+	attrName types.NodeAttr, configAttr *types.ConfigAttr) error {
+	inputID := inputs.MakeInputHWID(nodeHWID, inputType, instance)
+	return pub.registeredInputs.UpdateInputConfig(inputID, attrName, configAttr)
+}
+
 // UpdateNodeConfig updates a registered node's configuration and publishes the updated node.
 //  If a config already exists then its value is retained but its configuration parameters are replaced.
 //  Nodes are immutable. A new node is created and published and the old node instance is discarded.
@@ -412,13 +915,41 @@ func (pub *Publisher) UpdateOutput(output *types.OutputDiscoveryMessage) {
 	pub.registeredOutputs.UpdateOutput(output)
 }
 
-// UpdateOutputForecast replaces a forecast
+// UpdateOutputForecast replaces the forecast of an output. The forecast is published with the next
+// heartbeat if the node PublishForecast configuration is enabled, which is the default.
+// Entries are sorted by time. Entries whose time has passed or that are beyond the retention horizon
+// are removed. Use outputs.MakeForecastValue to create the entries.
 func (pub *Publisher) UpdateOutputForecast(outputID string, forecast outputs.OutputForecast) {
 	pub.registeredForecastValues.UpdateForecast(outputID, forecast)
 }
 
 // UpdateOutputValue adds the registered node's output value to the front of the value history
+// The history is limited by the retention policy of the output. The node configuration
+// attributes historyMaxCount, historyMaxAge and historyMaxBytes override the policy if set. Changes
+// to these attributes apply when the updated node is published.
+// If the output has a calibration then the calibrated value is stored.
+// The update marks the node as seen, also if the value is unchanged. See SetNodeStaleTimeout.
+// Values that are not one of the enum values of an enum output are rejected. See SetOutputEnumValues.
 func (pub *Publisher) UpdateOutputValue(nodeHWID string, outputType types.OutputType, instance string, newValue string) bool {
 	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	pub.markNodeSeen(nodeHWID)
+	if pub.registeredOutputs.ValidateValue(outputID, newValue) != nil {
+		return false
+	}
+	newValue = pub.calibrateValue(outputID, newValue)
 	return pub.registeredOutputValues.UpdateOutputValue(outputID, newValue)
 }
+
+// UpdateOutputValueAt adds the registered node's output value that was measured at the given time to
+// the value history. Use this in poll handlers when the device provides the time of measurement.
+// See also UpdateOutputValue.
+func (pub *Publisher) UpdateOutputValueAt(nodeHWID string, outputType types.OutputType, instance string,
+	newValue string, measured time.Time) bool {
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	pub.markNodeSeen(nodeHWID)
+	if pub.registeredOutputs.ValidateValue(outputID, newValue) != nil {
+		return false
+	}
+	newValue = pub.calibrateValue(outputID, newValue)
+	return pub.registeredOutputValues.UpdateOutputValueAt(outputID, newValue, measured)
+}
@@ -0,0 +1,180 @@
+package recorder
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// MaxMessageSize is the largest recorded message that can be read from an archive
+const MaxMessageSize = 64 * 1024 * 1024
+
+// ArchiveReader reads recorded messages from the archive files in a folder
+type ArchiveReader struct {
+	folder string // folder with archive files
+}
+
+// GetIndexes returns the index of each archive file, oldest first.
+// Archive files without an index, like the file being recorded or a file of a crashed recorder,
+// are indexed by reading the file.
+func (reader *ArchiveReader) GetIndexes() ([]*ArchiveIndex, error) {
+	indexes := make([]*ArchiveIndex, 0)
+	for _, filename := range listArchiveFiles(reader.folder) {
+		index, err := reader.readIndex(filename)
+		if err != nil {
+			return indexes, err
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// ReadMessages returns the recorded messages with an address that matches the filter and
+// a timestamp within the time range, oldest first.
+//  filter is the address with optional '+' and '#' wildcards. Use "" or "#" for all addresses
+//  from and until limit the time range. Use the zero time for no limit.
+func (reader *ArchiveReader) ReadMessages(filter string, from time.Time, until time.Time) ([]*RecordedMessage, error) {
+	messages := make([]*RecordedMessage, 0)
+	indexes, err := reader.GetIndexes()
+	if err != nil {
+		return messages, err
+	}
+	for _, index := range indexes {
+		if !isInRange(index.Start, index.End, from, until) || !indexHasAddress(index, filter) {
+			continue
+		}
+		err = reader.readFile(index.Filename, func(message *RecordedMessage) {
+			if MatchAddress(message.Address, filter) &&
+				isInRange(message.Timestamp, message.Timestamp, from, until) {
+				messages = append(messages, message)
+			}
+		})
+		if err != nil {
+			return messages, err
+		}
+	}
+	return messages, nil
+}
+
+// readFile reads the messages of an archive file.
+// A file that is being recorded, or was not closed, ends without the gzip trailer. Its messages
+// are read up to the last flushed message.
+func (reader *ArchiveReader) readFile(filename string, handler func(message *RecordedMessage)) error {
+	file, err := os.Open(path.Join(reader.folder, filename))
+	if err != nil {
+		return lib.MakeErrorf("ArchiveReader.readFile: Unable to open archive file %s: %s", filename, err)
+	}
+	defer file.Close()
+	unzipper, err := gzip.NewReader(file)
+	if err == io.EOF {
+		// no messages were written yet
+		return nil
+	} else if err != nil {
+		return lib.MakeErrorf("ArchiveReader.readFile: Archive file %s is not compressed: %s", filename, err)
+	}
+	scanner := bufio.NewScanner(unzipper)
+	scanner.Buffer(make([]byte, 64*1024), MaxMessageSize)
+	for scanner.Scan() {
+		var message RecordedMessage
+		err = json.Unmarshal(scanner.Bytes(), &message)
+		if err != nil {
+			return lib.MakeErrorf("ArchiveReader.readFile: Invalid message in %s: %s", filename, err)
+		}
+		handler(&message)
+	}
+	err = scanner.Err()
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return lib.MakeErrorf("ArchiveReader.readFile: Error reading %s: %s", filename, err)
+	}
+	return nil
+}
+
+// readIndex reads the index of an archive file, or indexes the file if it has no index
+func (reader *ArchiveReader) readIndex(filename string) (*ArchiveIndex, error) {
+	indexJSON, err := ioutil.ReadFile(path.Join(reader.folder, MakeIndexFilename(filename)))
+	if err == nil {
+		index := &ArchiveIndex{}
+		err = json.Unmarshal(indexJSON, index)
+		if err == nil {
+			return index, nil
+		}
+	}
+	index := &ArchiveIndex{Filename: filename, Addresses: make(map[string]int)}
+	err = reader.readFile(filename, func(message *RecordedMessage) {
+		if index.Count == 0 {
+			index.Start = message.Timestamp
+		}
+		index.End = message.Timestamp
+		index.Count++
+		index.Addresses[message.Address]++
+	})
+	return index, err
+}
+
+// indexHasAddress returns true if the index has an address that matches the filter
+func indexHasAddress(index *ArchiveIndex, filter string) bool {
+	for address := range index.Addresses {
+		if MatchAddress(address, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// isInRange returns true if the timestamps from start to end overlap with the time range
+// A zero from or until time does not limit the range.
+func isInRange(start string, end string, from time.Time, until time.Time) bool {
+	if !from.IsZero() {
+		endTime, err := time.Parse(types.TimeFormat, end)
+		if err == nil && endTime.Before(from) {
+			return false
+		}
+	}
+	if !until.IsZero() {
+		startTime, err := time.Parse(types.TimeFormat, start)
+		if err == nil && startTime.After(until) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchAddress returns true if the address matches the filter
+//  filter is an address with optional '+' wildcard for a single segment and a trailing '#' wildcard
+//  for the remaining segments. "" matches all addresses.
+func MatchAddress(address string, filter string) bool {
+	if filter == "" {
+		return true
+	}
+	addressSegments := strings.Split(address, "/")
+	filterSegments := strings.Split(filter, "/")
+	for i, filterSegment := range filterSegments {
+		if filterSegment == "#" {
+			return true
+		}
+		if i >= len(addressSegments) {
+			return false
+		}
+		if filterSegment != "+" && filterSegment != addressSegments[i] {
+			return false
+		}
+	}
+	return len(addressSegments) == len(filterSegments)
+}
+
+// NewArchiveReader creates a reader for the archive files in the given folder
+func NewArchiveReader(folder string) *ArchiveReader {
+	reader := &ArchiveReader{
+		folder: folder,
+	}
+	return reader
+}
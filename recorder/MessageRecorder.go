@@ -0,0 +1,216 @@
+// Package recorder with an archive of the messages that are published and received on the message bus
+package recorder
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Recorder defaults
+const (
+	DefaultMaxFileSize = 10 * 1024 * 1024 // uncompressed size in bytes of an archive file before it is rotated
+	DefaultMaxFiles    = 100              // nr of archive files to keep
+)
+
+// Archive file naming. Files sort by the time they are created.
+const (
+	ArchiveFilePrefix = "messages-"
+	ArchiveFileSuffix = ".jsonl.gz"
+	IndexFileSuffix   = ".idx.json"
+	archiveTimeFormat = "20060102-150405.000000000"
+)
+
+// Direction of a recorded message
+const (
+	DirectionPublished = "out" // message published by the recording client
+	DirectionReceived  = "in"  // message received by the recording client
+)
+
+// RecorderConfig with the location and rotation of the message archive
+type RecorderConfig struct {
+	Folder      string `yaml:"folder"`      // folder of the archive files. Required
+	MaxFileSize int64  `yaml:"maxFileSize"` // uncompressed size in bytes of an archive file before it is rotated. Default is DefaultMaxFileSize
+	MaxFiles    int    `yaml:"maxFiles"`    // nr of archive files to keep. The oldest files are removed. Default is DefaultMaxFiles
+}
+
+// RecordedMessage is a message in the archive
+type RecordedMessage struct {
+	Address   string `json:"address"`            // address the message is published on
+	Direction string `json:"direction"`          // DirectionPublished or DirectionReceived
+	Message   string `json:"message"`            // message as published, eg signed and encrypted
+	Retained  bool   `json:"retained,omitempty"` // the message is published as retained
+	Timestamp string `json:"timestamp"`          // time the message was recorded
+}
+
+// ArchiveIndex describes the messages in an archive file for finding messages without reading all files
+type ArchiveIndex struct {
+	Filename  string         `json:"filename"`  // archive file name without folder
+	Start     string         `json:"start"`     // timestamp of the first message
+	End       string         `json:"end"`       // timestamp of the last message
+	Count     int            `json:"count"`     // nr of messages in the file
+	Addresses map[string]int `json:"addresses"` // nr of messages by address
+}
+
+// MessageRecorder writes messages to rotating gzip compressed archive files, one JSON message per
+// line. Each file is flushed after every message so it remains readable after a crash. An index
+// of the addresses and time range is written next to each archive file when it is rotated or closed.
+type MessageRecorder struct {
+	config      RecorderConfig
+	file        *os.File      // the archive file being written, nil if not open
+	writer      *gzip.Writer  // compression of the archive file
+	index       *ArchiveIndex // index of the archive file being written
+	size        int64         // uncompressed bytes written to the archive file
+	updateMutex *sync.Mutex   // mutex for concurrent recording
+}
+
+// Close the archive file being recorded and write its index
+// Recording can continue afterwards in a new archive file.
+func (rec *MessageRecorder) Close() error {
+	rec.updateMutex.Lock()
+	defer rec.updateMutex.Unlock()
+	return rec.closeFile()
+}
+
+// Record appends a message to the archive. The archive file is rotated when it exceeds the
+// configured maximum size.
+func (rec *MessageRecorder) Record(direction string, address string, retained bool, message string) error {
+	rec.updateMutex.Lock()
+	defer rec.updateMutex.Unlock()
+
+	if rec.file == nil {
+		err := rec.openFile()
+		if err != nil {
+			return err
+		}
+	}
+	recorded := RecordedMessage{
+		Address:   address,
+		Direction: direction,
+		Message:   message,
+		Retained:  retained,
+		Timestamp: lib.Now().Format(types.TimeFormat),
+	}
+	line, err := json.Marshal(recorded)
+	if err != nil {
+		return lib.MakeErrorf("MessageRecorder.Record: Unable to marshal message on %s: %s", address, err)
+	}
+	line = append(line, '\n')
+	_, err = rec.writer.Write(line)
+	if err == nil {
+		err = rec.writer.Flush()
+	}
+	if err != nil {
+		return lib.MakeErrorf("MessageRecorder.Record: Unable to write to %s: %s", rec.index.Filename, err)
+	}
+	if rec.index.Count == 0 {
+		rec.index.Start = recorded.Timestamp
+	}
+	rec.index.End = recorded.Timestamp
+	rec.index.Count++
+	rec.index.Addresses[address]++
+	rec.size += int64(len(line))
+	if rec.size >= rec.config.MaxFileSize {
+		return rec.closeFile()
+	}
+	return nil
+}
+
+// closeFile closes the archive file and writes its index
+// For internal use only. Use within locked section.
+func (rec *MessageRecorder) closeFile() error {
+	if rec.file == nil {
+		return nil
+	}
+	rec.writer.Close()
+	err := rec.file.Close()
+	rec.file = nil
+	if err != nil {
+		return lib.MakeErrorf("MessageRecorder.closeFile: Error closing %s: %s", rec.index.Filename, err)
+	}
+	indexJSON, _ := json.MarshalIndent(rec.index, "", "  ")
+	indexFile := path.Join(rec.config.Folder, MakeIndexFilename(rec.index.Filename))
+	err = ioutil.WriteFile(indexFile, indexJSON, 0664)
+	if err != nil {
+		return lib.MakeErrorf("MessageRecorder.closeFile: Error writing index %s: %s", indexFile, err)
+	}
+	logrus.Infof("MessageRecorder.closeFile: Archived %d messages in %s", rec.index.Count, rec.index.Filename)
+	return nil
+}
+
+// openFile creates a new archive file and removes the oldest files beyond the maximum nr of files
+// For internal use only. Use within locked section.
+func (rec *MessageRecorder) openFile() error {
+	err := os.MkdirAll(rec.config.Folder, 0755)
+	if err != nil {
+		return lib.MakeErrorf("MessageRecorder.openFile: Unable to create archive folder %s: %s", rec.config.Folder, err)
+	}
+	filename := ArchiveFilePrefix + time.Now().UTC().Format(archiveTimeFormat) + ArchiveFileSuffix
+	file, err := os.OpenFile(path.Join(rec.config.Folder, filename), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0664)
+	if err != nil {
+		return lib.MakeErrorf("MessageRecorder.openFile: Unable to create archive file %s: %s", filename, err)
+	}
+	rec.file = file
+	rec.writer = gzip.NewWriter(file)
+	rec.index = &ArchiveIndex{Filename: filename, Addresses: make(map[string]int)}
+	rec.size = 0
+	rec.removeOldFiles()
+	return nil
+}
+
+// removeOldFiles removes the oldest archive files and their index when there are more than the
+// configured maximum nr of files.
+// For internal use only. Use within locked section.
+func (rec *MessageRecorder) removeOldFiles() {
+	archiveFiles := listArchiveFiles(rec.config.Folder)
+	for len(archiveFiles) > rec.config.MaxFiles {
+		filename := archiveFiles[0]
+		logrus.Infof("MessageRecorder.removeOldFiles: Removing archive file %s", filename)
+		os.Remove(path.Join(rec.config.Folder, filename))
+		os.Remove(path.Join(rec.config.Folder, MakeIndexFilename(filename)))
+		archiveFiles = archiveFiles[1:]
+	}
+}
+
+// listArchiveFiles returns the names of the archive files in the folder, oldest first
+func listArchiveFiles(folder string) []string {
+	paths, _ := filepath.Glob(path.Join(folder, ArchiveFilePrefix+"*"+ArchiveFileSuffix))
+	filenames := make([]string, 0, len(paths))
+	for _, filePath := range paths {
+		filenames = append(filenames, filepath.Base(filePath))
+	}
+	sort.Strings(filenames)
+	return filenames
+}
+
+// MakeIndexFilename returns the name of the index file of an archive file
+func MakeIndexFilename(archiveFilename string) string {
+	return strings.TrimSuffix(archiveFilename, ArchiveFileSuffix) + IndexFileSuffix
+}
+
+// NewMessageRecorder creates a recorder of messages. The first archive file is created when the
+// first message is recorded.
+func NewMessageRecorder(config RecorderConfig) *MessageRecorder {
+	if config.MaxFileSize <= 0 {
+		config.MaxFileSize = DefaultMaxFileSize
+	}
+	if config.MaxFiles <= 0 {
+		config.MaxFiles = DefaultMaxFiles
+	}
+	rec := &MessageRecorder{
+		config:      config,
+		updateMutex: &sync.Mutex{},
+	}
+	return rec
+}
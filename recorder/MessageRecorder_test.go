@@ -0,0 +1,95 @@
+package recorder_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/recorder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchAddress(t *testing.T) {
+	assert.True(t, recorder.MatchAddress("test/publisher1/node1/$node", ""))
+	assert.True(t, recorder.MatchAddress("test/publisher1/node1/$node", "#"))
+	assert.True(t, recorder.MatchAddress("test/publisher1/node1/$node", "test/+/+/$node"))
+	assert.True(t, recorder.MatchAddress("test/publisher1/node1/$node", "test/publisher1/#"))
+	assert.False(t, recorder.MatchAddress("test/publisher1/node1/$node", "test/+/$node"))
+	assert.False(t, recorder.MatchAddress("test/publisher1", "test/publisher1/+"))
+	assert.False(t, recorder.MatchAddress("test/publisher2/node1/$node", "test/publisher1/#"))
+}
+
+func TestRecordAndRead(t *testing.T) {
+	folder, _ := ioutil.TempDir("", "recorder")
+	defer os.RemoveAll(folder)
+	rec := recorder.NewMessageRecorder(recorder.RecorderConfig{Folder: folder})
+	reader := recorder.NewArchiveReader(folder)
+
+	start := time.Now()
+	err := rec.Record(recorder.DirectionPublished, "test/publisher1/node1/$node", true, "node1")
+	assert.NoError(t, err)
+	err = rec.Record(recorder.DirectionReceived, "test/publisher2/node2/$node", false, "node2")
+	assert.NoError(t, err)
+
+	// messages of the file being recorded can be read before it is closed
+	messages, err := reader.ReadMessages("", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(messages))
+	assert.Equal(t, "node1", messages[0].Message)
+	assert.Equal(t, recorder.DirectionPublished, messages[0].Direction)
+	assert.True(t, messages[0].Retained)
+
+	err = rec.Close()
+	assert.NoError(t, err)
+	indexes, err := reader.GetIndexes()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(indexes))
+	assert.Equal(t, 2, indexes[0].Count)
+	assert.Equal(t, 1, indexes[0].Addresses["test/publisher2/node2/$node"])
+
+	// filter by address and time
+	messages, err = reader.ReadMessages("test/publisher2/#", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(messages))
+	assert.Equal(t, "node2", messages[0].Message)
+	messages, err = reader.ReadMessages("", start.Add(-time.Minute), start.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(messages))
+	messages, err = reader.ReadMessages("", start.Add(time.Minute), time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(messages))
+}
+
+func TestRotate(t *testing.T) {
+	folder, _ := ioutil.TempDir("", "recorder")
+	defer os.RemoveAll(folder)
+	rec := recorder.NewMessageRecorder(recorder.RecorderConfig{Folder: folder, MaxFileSize: 10, MaxFiles: 3})
+	reader := recorder.NewArchiveReader(folder)
+
+	for i := 0; i < 5; i++ {
+		err := rec.Record(recorder.DirectionPublished, "test/publisher1/node1/$node", false, "a message")
+		assert.NoError(t, err)
+		time.Sleep(time.Millisecond)
+	}
+	// each message exceeds the maximum file size and the oldest files are removed
+	files, _ := filepath.Glob(filepath.Join(folder, "*"+recorder.ArchiveFileSuffix))
+	assert.Equal(t, 3, len(files))
+	indexFiles, _ := filepath.Glob(filepath.Join(folder, "*"+recorder.IndexFileSuffix))
+	assert.Equal(t, 3, len(indexFiles))
+	messages, err := reader.ReadMessages("", time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(messages))
+
+	// errors
+	badFile := filepath.Join(folder, recorder.ArchiveFilePrefix+"x"+recorder.ArchiveFileSuffix)
+	err = ioutil.WriteFile(badFile, []byte("bad"), 0664)
+	require.NoError(t, err)
+	_, err = reader.ReadMessages("", time.Time{}, time.Time{})
+	assert.Error(t, err)
+	rec = recorder.NewMessageRecorder(recorder.RecorderConfig{Folder: filepath.Join(badFile, "folder")})
+	err = rec.Record(recorder.DirectionPublished, "test/publisher1/node1/$node", false, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,128 @@
+package recorder
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/sirupsen/logrus"
+)
+
+// RecordingMessenger is a messenger that records the messages it publishes and receives in a
+// message archive before passing them on. Use it in place of the messenger of a publisher or consumer.
+// A received message that matches multiple subscriptions is recorded once.
+type RecordingMessenger struct {
+	messenger    messaging.IMessenger // messenger that is recorded
+	recorder     *MessageRecorder     // archive of recorded messages
+	handlers     []recordingHandler   // recording handlers of subscriptions
+	lastReceived string               // address and message last recorded, to detect repeated delivery
+	updateMutex  *sync.Mutex          // mutex for concurrent subscriptions and delivery
+}
+
+// recordingHandler is a subscription handler that records the message before passing it on
+type recordingHandler struct {
+	address   string
+	onMessage func(address string, message string) error
+	recording func(address string, message string) error
+}
+
+// Connect the messenger
+func (recMessenger *RecordingMessenger) Connect(lastWillAddress string, lastWillValue string) error {
+	return recMessenger.messenger.Connect(lastWillAddress, lastWillValue)
+}
+
+// ConnectionState returns the state of the connection of the wrapped messenger
+func (recMessenger *RecordingMessenger) ConnectionState() messaging.ConnectionState {
+	return recMessenger.messenger.ConnectionState()
+}
+
+// Disconnect the messenger
+func (recMessenger *RecordingMessenger) Disconnect() {
+	recMessenger.messenger.Disconnect()
+}
+
+// Publish records and publishes a message
+func (recMessenger *RecordingMessenger) Publish(address string, retained bool, message string) error {
+	recMessenger.record(DirectionPublished, address, retained, message)
+	return recMessenger.messenger.Publish(address, retained, message)
+}
+
+// Request publishes a request and waits for the reply. Both are recorded.
+func (recMessenger *RecordingMessenger) Request(address string, payload string, timeout time.Duration) (string, error) {
+	return messaging.SendRequest(recMessenger, address, payload, timeout)
+}
+
+// SetConnectionHandler sets the handler that is notified when the connection is established, lost
+// or closed.
+func (recMessenger *RecordingMessenger) SetConnectionHandler(handler func(connected bool, err error)) {
+	recMessenger.messenger.SetConnectionHandler(handler)
+}
+
+// Subscribe to messages on the address. Received messages are recorded before they are passed to onMessage.
+func (recMessenger *RecordingMessenger) Subscribe(
+	address string, onMessage func(address string, message string) error) {
+
+	recording := func(msgAddress string, message string) error {
+		recMessenger.updateMutex.Lock()
+		isRepeated := recMessenger.lastReceived == msgAddress+"\n"+message
+		recMessenger.lastReceived = msgAddress + "\n" + message
+		recMessenger.updateMutex.Unlock()
+		if !isRepeated {
+			recMessenger.record(DirectionReceived, msgAddress, false, message)
+		}
+		return onMessage(msgAddress, message)
+	}
+	recMessenger.updateMutex.Lock()
+	recMessenger.handlers = append(recMessenger.handlers,
+		recordingHandler{address: address, onMessage: onMessage, recording: recording})
+	recMessenger.updateMutex.Unlock()
+	recMessenger.messenger.Subscribe(address, recording)
+}
+
+// Unsubscribe from a previously subscribed address
+// If onMessage is nil then all subscriptions with the address will be removed
+func (recMessenger *RecordingMessenger) Unsubscribe(
+	address string, onMessage func(address string, message string) error) {
+
+	recMessenger.updateMutex.Lock()
+	remaining := make([]recordingHandler, 0, len(recMessenger.handlers))
+	var recording func(address string, message string) error
+	for _, handler := range recMessenger.handlers {
+		isMatch := handler.address == address && (onMessage == nil ||
+			(recording == nil && reflect.ValueOf(handler.onMessage).Pointer() == reflect.ValueOf(onMessage).Pointer()))
+		if isMatch {
+			recording = handler.recording
+		} else {
+			remaining = append(remaining, handler)
+		}
+	}
+	recMessenger.handlers = remaining
+	recMessenger.updateMutex.Unlock()
+
+	if onMessage == nil {
+		recMessenger.messenger.Unsubscribe(address, nil)
+	} else if recording != nil {
+		recMessenger.messenger.Unsubscribe(address, recording)
+	}
+}
+
+// record a message in the archive and log recording errors
+func (recMessenger *RecordingMessenger) record(direction string, address string, retained bool, message string) {
+	err := recMessenger.recorder.Record(direction, address, retained, message)
+	if err != nil {
+		logrus.Errorf("RecordingMessenger.record: %s", err)
+	}
+}
+
+// NewRecordingMessenger creates a messenger that records the messages that are published and
+// received with the given messenger.
+func NewRecordingMessenger(messenger messaging.IMessenger, recorder *MessageRecorder) *RecordingMessenger {
+	recMessenger := &RecordingMessenger{
+		messenger:   messenger,
+		recorder:    recorder,
+		handlers:    make([]recordingHandler, 0),
+		updateMutex: &sync.Mutex{},
+	}
+	return recMessenger
+}
@@ -0,0 +1,50 @@
+package recorder_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/recorder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingMessenger(t *testing.T) {
+	folder, _ := ioutil.TempDir("", "recorder")
+	defer os.RemoveAll(folder)
+	rec := recorder.NewMessageRecorder(recorder.RecorderConfig{Folder: folder})
+	messenger := recorder.NewRecordingMessenger(messaging.NewDummyMessenger(&messaging.MessengerConfig{}), rec)
+	err := messenger.Connect("", "")
+	assert.NoError(t, err)
+
+	received := 0
+	handler := func(address string, message string) error {
+		received++
+		return nil
+	}
+	// a message that matches both subscriptions is delivered twice and recorded once
+	messenger.Subscribe("test/+/node1/$node", handler)
+	messenger.Subscribe("test/#", handler)
+	err = messenger.Publish("test/publisher1/node1/$node", true, "node1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, received)
+
+	messenger.Unsubscribe("test/+/node1/$node", nil)
+	messenger.Unsubscribe("test/#", nil)
+	err = messenger.Publish("test/publisher1/node2/$node", false, "node2")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, received)
+	messenger.Disconnect()
+	rec.Close()
+
+	messages, err := recorder.NewArchiveReader(folder).ReadMessages("", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(messages))
+	assert.Equal(t, recorder.DirectionPublished, messages[0].Direction)
+	assert.Equal(t, recorder.DirectionReceived, messages[1].Direction)
+	assert.Equal(t, "node1", messages[1].Message)
+	assert.Equal(t, "node2", messages[2].Message)
+}
@@ -0,0 +1,206 @@
+// Package rules with automation rules that set inputs when conditions over domain outputs are met
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// Rule match modes
+const (
+	MatchAll = "all" // all conditions must be met
+	MatchAny = "any" // at least one condition must be met
+)
+
+// Action sets an input of a domain node
+type Action struct {
+	Input string `yaml:"input"` // address of the input to set, eg domain/publisher/node/switch/0/$input
+	Value string `yaml:"value"` // value to set the input to
+}
+
+// Condition of a rule. A condition tests the latest value of an output, a schedule, or both.
+// All tests of a condition must be met.
+type Condition struct {
+	Output    string   `yaml:"output"`    // address of the output whose latest value is tested
+	Above     *float64 `yaml:"above"`     // the value is a number above this threshold
+	Below     *float64 `yaml:"below"`     // the value is a number below this threshold
+	Equals    *string  `yaml:"equals"`    // the value equals this text
+	NotEquals *string  `yaml:"notEquals"` // the value differs from this text
+	After     string   `yaml:"after"`     // schedule: the time of day is at or after hh:mm
+	Before    string   `yaml:"before"`    // schedule: the time of day is before hh:mm. Wraps past midnight if before 'after'
+	Days      []string `yaml:"days"`      // schedule: the weekday is one of, eg mon, tue. Default is every day
+}
+
+// Rule triggers actions when its conditions become met and, optionally, when they are no longer met.
+// Actions are only triggered on a change, not for each value that meets the conditions.
+type Rule struct {
+	Name     string      `yaml:"name"`     // name of the rule for logging
+	Cooldown int         `yaml:"cooldown"` // min seconds between triggering the rule. Default (0) is no limit
+	Disabled bool        `yaml:"disabled"` // the rule is not evaluated
+	Else     []Action    `yaml:"else"`     // actions when the conditions are no longer met
+	Match    string      `yaml:"match"`    // MatchAll or MatchAny. Default is MatchAll
+	Then     []Action    `yaml:"then"`     // actions when the conditions become met
+	When     []Condition `yaml:"when"`     // conditions of the rule
+}
+
+// weekdays by the first three letters of their name
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// IsMet returns true if the conditions of the rule are met
+//  values holds the latest value of outputs by output address. Conditions of outputs without value are not met.
+//  now is the time to test schedules with
+func (rule *Rule) IsMet(values map[string]string, now time.Time) bool {
+	if len(rule.When) == 0 {
+		return false
+	}
+	for _, condition := range rule.When {
+		isMet := condition.IsMet(values, now)
+		if isMet && rule.Match == MatchAny {
+			return true
+		} else if !isMet && rule.Match != MatchAny {
+			return false
+		}
+	}
+	return rule.Match != MatchAny
+}
+
+// Validate returns an error if the rule can't be evaluated
+func (rule *Rule) Validate() error {
+	if rule.Match != "" && rule.Match != MatchAll && rule.Match != MatchAny {
+		return fmt.Errorf("Rule '%s' has invalid match '%s'", rule.Name, rule.Match)
+	} else if len(rule.When) == 0 {
+		return fmt.Errorf("Rule '%s' has no conditions", rule.Name)
+	}
+	for _, condition := range rule.When {
+		err := condition.Validate()
+		if err != nil {
+			return fmt.Errorf("Rule '%s': %s", rule.Name, err)
+		}
+	}
+	actions := append(append([]Action{}, rule.Then...), rule.Else...)
+	for _, action := range actions {
+		if !isIOAddress(action.Input) {
+			return fmt.Errorf("Rule '%s' has invalid input address '%s'", rule.Name, action.Input)
+		}
+	}
+	return nil
+}
+
+// IsMet returns true if the latest value of the output and the time meet the condition
+func (condition *Condition) IsMet(values map[string]string, now time.Time) bool {
+	if condition.Output != "" {
+		value, found := values[LatestAddress(condition.Output)]
+		if !found || !condition.isValueMet(value) {
+			return false
+		}
+	}
+	return condition.isScheduleMet(now)
+}
+
+// Validate returns an error if the condition can't be evaluated
+func (condition *Condition) Validate() error {
+	hasValueTest := condition.Above != nil || condition.Below != nil ||
+		condition.Equals != nil || condition.NotEquals != nil
+	hasSchedule := condition.After != "" || condition.Before != "" || len(condition.Days) > 0
+	if condition.Output != "" {
+		if !isIOAddress(condition.Output) {
+			return fmt.Errorf("Invalid output address '%s'", condition.Output)
+		}
+	} else if hasValueTest {
+		return fmt.Errorf("Condition tests a value without output")
+	} else if !hasSchedule {
+		return fmt.Errorf("Condition has no output or schedule")
+	}
+	for _, timeOfDay := range []string{condition.After, condition.Before} {
+		if _, err := parseTimeOfDay(timeOfDay); timeOfDay != "" && err != nil {
+			return err
+		}
+	}
+	for _, day := range condition.Days {
+		if _, found := weekdays[weekdayKey(day)]; !found {
+			return fmt.Errorf("Invalid day '%s'", day)
+		}
+	}
+	return nil
+}
+
+// isScheduleMet returns true if the time is within the schedule of the condition
+func (condition *Condition) isScheduleMet(now time.Time) bool {
+	if len(condition.Days) > 0 {
+		isDay := false
+		for _, day := range condition.Days {
+			isDay = isDay || weekdays[weekdayKey(day)] == now.Weekday()
+		}
+		if !isDay {
+			return false
+		}
+	}
+	minutes := now.Hour()*60 + now.Minute()
+	after, errAfter := parseTimeOfDay(condition.After)
+	before, errBefore := parseTimeOfDay(condition.Before)
+	if errAfter == nil && errBefore == nil && before < after {
+		// the window wraps past midnight
+		return minutes >= after || minutes < before
+	}
+	return (errAfter != nil || minutes >= after) && (errBefore != nil || minutes < before)
+}
+
+// isValueMet returns true if the output value passes the value tests of the condition
+func (condition *Condition) isValueMet(value string) bool {
+	if condition.Equals != nil && value != *condition.Equals {
+		return false
+	} else if condition.NotEquals != nil && value == *condition.NotEquals {
+		return false
+	}
+	if condition.Above != nil || condition.Below != nil {
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return false
+		}
+		if (condition.Above != nil && number <= *condition.Above) ||
+			(condition.Below != nil && number >= *condition.Below) {
+			return false
+		}
+	}
+	return true
+}
+
+// isIOAddress returns true if the address is the publication address of an input or output
+func isIOAddress(address string) bool {
+	addr, err := types.ParseAddress(address)
+	return err == nil && addr.IOType() != "" && addr.Validate() == nil
+}
+
+// LatestAddress returns the $latest address of an output address with any message type
+func LatestAddress(outputAddress string) string {
+	addr, err := types.ParseAddress(outputAddress)
+	if err != nil {
+		return outputAddress
+	}
+	return addr.WithMessageType(types.MessageTypeLatest).String()
+}
+
+// parseTimeOfDay returns the minutes since midnight of a hh:mm time
+func parseTimeOfDay(timeOfDay string) (int, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(timeOfDay))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time of day '%s'", timeOfDay)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// weekdayKey returns the key of a day name in weekdays
+func weekdayKey(day string) string {
+	day = strings.ToLower(strings.TrimSpace(day))
+	if len(day) > 3 {
+		day = day[:3]
+	}
+	return day
+}
@@ -0,0 +1,254 @@
+// Package rules with the engine that evaluates automation rules on the subscriber API
+package rules
+
+import (
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultEvaluateInterval is the default interval in seconds of evaluating rules with a schedule
+const DefaultEvaluateInterval = 30
+
+// RuleConfig with the automation rules, eg loaded from a yaml file with LoadRuleConfig:
+//  rules:
+//    - name: heating
+//      when:
+//        - output: home/publisher1/thermometer/temperature/0/$latest
+//          below: 18
+//        - after: "07:00"
+//          before: "22:00"
+//      then:
+//        - input: home/publisher2/heater/switch/0/$input
+//          value: "on"
+//      else:
+//        - input: home/publisher2/heater/switch/0/$input
+//          value: "off"
+type RuleConfig struct {
+	Interval int    `yaml:"interval"` // seconds between evaluations of schedules. Default is DefaultEvaluateInterval
+	Rules    []Rule `yaml:"rules"`    // the automation rules
+}
+
+// RuleHost is the subscriber API the rule engine runs on. The publisher implements it.
+type RuleHost interface {
+	// PublishSetInput publishes a command to set a domain input
+	PublishSetInput(inputAddr string, value string) error
+	// RemoveSubscription removes the subscriptions to an address
+	RemoveSubscription(address string)
+	// SubscribeToOutputLatest subscribes to the verified latest value of domain outputs
+	SubscribeToOutputLatest(latestAddress string, handler func(latest *types.OutputLatestMessage))
+}
+
+// ruleState with the result of the previous evaluation of a rule
+type ruleState struct {
+	isEvaluated bool      // the rule has been evaluated
+	isMet       bool      // the conditions of the rule were met in the previous evaluation
+	lastTrigger time.Time // time the actions of the rule were last triggered
+}
+
+// RuleEngine evaluates automation rules when an output they use is updated and on an interval for
+// their schedules. When the conditions of a rule become met its 'then' actions set the inputs, and
+// when they are no longer met its 'else' actions set the inputs.
+type RuleEngine struct {
+	config       RuleConfig        // the rules and evaluation interval
+	host         RuleHost          // subscriber API to receive outputs and set inputs
+	outputs      []string          // $latest addresses of the outputs used in the rules
+	ruleStates   []ruleState       // state of each rule
+	stopChannel  chan bool         // closed to stop the evaluation loop, nil when not running
+	triggerCount int               // nr of times rule actions are triggered
+	updateMutex  *sync.Mutex       // mutex for concurrent evaluation
+	values       map[string]string // latest value of outputs by $latest address
+}
+
+// Evaluate all rules and trigger the actions of the rules whose result has changed.
+// This is invoked when an output value is received and on an interval while running.
+func (engine *RuleEngine) Evaluate() {
+	engine.evaluate("")
+}
+
+// GetValue returns the last received value of an output that is used in the rules
+//  outputAddress is the address of the output with any message type
+// Returns "" if no value is received.
+func (engine *RuleEngine) GetValue(outputAddress string) string {
+	engine.updateMutex.Lock()
+	defer engine.updateMutex.Unlock()
+	return engine.values[LatestAddress(outputAddress)]
+}
+
+// Start subscribes to the outputs that are used in the rules and evaluates the rules on an interval
+func (engine *RuleEngine) Start() {
+	engine.updateMutex.Lock()
+	if engine.stopChannel != nil {
+		engine.updateMutex.Unlock()
+		return
+	}
+	engine.stopChannel = make(chan bool)
+	stopChannel := engine.stopChannel
+	engine.updateMutex.Unlock()
+
+	for _, address := range engine.outputs {
+		engine.host.SubscribeToOutputLatest(address, engine.onLatest)
+	}
+	go engine.evaluateLoop(stopChannel)
+	logrus.Infof("RuleEngine.Start: Started with %d rules on %d outputs", len(engine.config.Rules), len(engine.outputs))
+}
+
+// Stop evaluating the rules and remove the subscriptions to the outputs
+func (engine *RuleEngine) Stop() {
+	engine.updateMutex.Lock()
+	if engine.stopChannel == nil {
+		engine.updateMutex.Unlock()
+		return
+	}
+	close(engine.stopChannel)
+	engine.stopChannel = nil
+	engine.updateMutex.Unlock()
+
+	for _, address := range engine.outputs {
+		engine.host.RemoveSubscription(address)
+	}
+	logrus.Infof("RuleEngine.Stop: Stopped")
+}
+
+// TriggerCount returns the nr of times that rule actions have been triggered
+func (engine *RuleEngine) TriggerCount() int {
+	engine.updateMutex.Lock()
+	defer engine.updateMutex.Unlock()
+	return engine.triggerCount
+}
+
+// evaluate the rules and trigger the actions of the rules whose result has changed
+//  outputAddress limits the evaluation to the rules that use the output, "" to evaluate all rules
+func (engine *RuleEngine) evaluate(outputAddress string) {
+	type trigger struct {
+		actions []Action
+		name    string
+	}
+	triggers := make([]trigger, 0)
+	now := lib.Now()
+
+	engine.updateMutex.Lock()
+	for index := range engine.config.Rules {
+		rule := &engine.config.Rules[index]
+		if rule.Disabled || (outputAddress != "" && !usesOutput(rule, outputAddress)) {
+			continue
+		}
+		state := &engine.ruleStates[index]
+		isMet := rule.IsMet(engine.values, now)
+		if state.isEvaluated && isMet == state.isMet {
+			continue
+		}
+		// the actions are triggered once the cooldown has passed and the result still differs
+		cooldown := time.Duration(rule.Cooldown) * time.Second
+		if !state.lastTrigger.IsZero() && now.Sub(state.lastTrigger) < cooldown {
+			continue
+		}
+		actions := rule.Then
+		if !isMet {
+			actions = rule.Else
+		}
+		// no actions are triggered when the rule is first evaluated as not met
+		if state.isEvaluated || isMet {
+			triggers = append(triggers, trigger{actions: actions, name: rule.Name})
+			state.lastTrigger = now
+			engine.triggerCount++
+		}
+		state.isEvaluated = true
+		state.isMet = isMet
+	}
+	engine.updateMutex.Unlock()
+
+	for _, trigger := range triggers {
+		for _, action := range trigger.actions {
+			logrus.Infof("RuleEngine.evaluate: Rule '%s' sets input %s to '%s'", trigger.name, action.Input, action.Value)
+			err := engine.host.PublishSetInput(action.Input, action.Value)
+			if err != nil {
+				logrus.Warningf("RuleEngine.evaluate: Rule '%s' failed to set input %s: %s", trigger.name, action.Input, err)
+			}
+		}
+	}
+}
+
+// evaluateLoop evaluates the rules on the interval until the stop channel is closed
+func (engine *RuleEngine) evaluateLoop(stopChannel chan bool) {
+	ticker := time.NewTicker(time.Duration(engine.config.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChannel:
+			return
+		case <-ticker.C:
+			engine.Evaluate()
+		}
+	}
+}
+
+// onLatest stores the received output value and evaluates the rules that use the output
+func (engine *RuleEngine) onLatest(latest *types.OutputLatestMessage) {
+	address := LatestAddress(latest.Address)
+	engine.updateMutex.Lock()
+	engine.values[address] = latest.Value
+	engine.updateMutex.Unlock()
+	engine.evaluate(address)
+}
+
+// usesOutput returns true if a condition of the rule uses the output with the $latest address
+func usesOutput(rule *Rule, latestAddress string) bool {
+	for _, condition := range rule.When {
+		if condition.Output != "" && LatestAddress(condition.Output) == latestAddress {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadRuleConfig loads the automation rules from a yaml configuration file
+//  configFolder contains the configuration file. Use "" for the default folder
+//  filename is the name of the file in the configuration folder, eg rules.yaml
+// Returns the rules or an error if the file can't be loaded.
+func LoadRuleConfig(configFolder string, filename string) (*RuleConfig, error) {
+	config := &RuleConfig{}
+	err := lib.LoadYamlConfig(configFolder, filename, "", config)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// NewRuleEngine creates a rule engine that runs on the subscriber API of a publisher. Use Start
+// to start evaluating the rules.
+//  config holds the rules
+//  host is the subscriber API, eg the publisher
+// Returns an error if a rule is invalid.
+func NewRuleEngine(config RuleConfig, host RuleHost) (*RuleEngine, error) {
+	if config.Interval <= 0 {
+		config.Interval = DefaultEvaluateInterval
+	}
+	outputs := make([]string, 0)
+	isUsed := make(map[string]bool)
+	for _, rule := range config.Rules {
+		err := rule.Validate()
+		if err != nil {
+			return nil, lib.MakeErrorf("NewRuleEngine: %s", err)
+		}
+		for _, condition := range rule.When {
+			address := LatestAddress(condition.Output)
+			if condition.Output != "" && !isUsed[address] {
+				isUsed[address] = true
+				outputs = append(outputs, address)
+			}
+		}
+	}
+	engine := &RuleEngine{
+		config:      config,
+		host:        host,
+		outputs:     outputs,
+		ruleStates:  make([]ruleState, len(config.Rules)),
+		updateMutex: &sync.Mutex{},
+		values:      make(map[string]string),
+	}
+	return engine, nil
+}
@@ -0,0 +1,136 @@
+package rules_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/rules"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the publisher provides the subscriber API of the rule engine
+var _ rules.RuleHost = &publisher.Publisher{}
+
+// testHost records the set input commands and passes output values to the subscribers
+type testHost struct {
+	handlers    map[string]func(latest *types.OutputLatestMessage)
+	setInputs   []string
+	updateMutex sync.Mutex
+}
+
+func (host *testHost) PublishSetInput(inputAddr string, value string) error {
+	host.updateMutex.Lock()
+	defer host.updateMutex.Unlock()
+	host.setInputs = append(host.setInputs, value)
+	return nil
+}
+
+func (host *testHost) RemoveSubscription(address string) {
+	delete(host.handlers, address)
+}
+
+func (host *testHost) SubscribeToOutputLatest(latestAddress string, handler func(latest *types.OutputLatestMessage)) {
+	host.handlers[latestAddress] = handler
+}
+
+func (host *testHost) publish(address string, value string) {
+	if handler := host.handlers[address]; handler != nil {
+		handler(&types.OutputLatestMessage{Address: address, Value: value})
+	}
+}
+
+const ruleConfig = `
+interval: 1
+rules:
+  - name: heating
+    cooldown: 60
+    when:
+      - output: test/publisher1/thermometer/temperature/0/$latest
+        below: 18
+      - after: "07:00"
+        before: "22:00"
+    then:
+      - input: test/publisher2/heater/switch/0/$input
+        value: "on"
+    else:
+      - input: test/publisher2/heater/switch/0/$input
+        value: "off"
+  - name: disabled
+    disabled: true
+    when:
+      - output: test/publisher1/motion/motion/0/$latest
+        equals: "on"
+    then:
+      - input: test/publisher2/heater/switch/0/$input
+        value: "disabled"
+`
+
+func TestRuleEngine(t *testing.T) {
+	folder, err := ioutil.TempDir("", "iotdomain")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	err = ioutil.WriteFile(path.Join(folder, "rules.yaml"), []byte(ruleConfig), 0600)
+	require.NoError(t, err)
+	config, err := rules.LoadRuleConfig(folder, "rules.yaml")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(config.Rules))
+
+	clock := lib.NewManualClock(time.Date(2020, 6, 1, 8, 0, 0, 0, time.Local))
+	lib.SetClock(clock)
+	defer lib.SetClock(nil)
+	host := &testHost{handlers: make(map[string]func(latest *types.OutputLatestMessage))}
+	engine, err := rules.NewRuleEngine(*config, host)
+	require.NoError(t, err)
+	engine.Start()
+	assert.Equal(t, 2, len(host.handlers))
+
+	// the rule is not triggered until it is met
+	host.publish(temperatureAddr, "19")
+	assert.Empty(t, host.setInputs)
+	assert.Equal(t, "19", engine.GetValue(temperatureAddr))
+	host.publish(temperatureAddr, "17")
+	assert.Equal(t, []string{"on"}, host.setInputs)
+	// values that keep meeting the rule don't trigger it again
+	host.publish(temperatureAddr, "16")
+	assert.Equal(t, 1, engine.TriggerCount())
+
+	// the else actions are triggered after the cooldown
+	host.publish(temperatureAddr, "20")
+	assert.Equal(t, []string{"on"}, host.setInputs)
+	clock.Add(time.Minute)
+	engine.Evaluate()
+	assert.Equal(t, []string{"on", "off"}, host.setInputs)
+
+	// the schedule is evaluated on the interval
+	clock.Add(time.Minute)
+	host.publish(temperatureAddr, "17")
+	assert.Equal(t, []string{"on", "off", "on"}, host.setInputs)
+	clock.Add(15 * time.Hour)
+	time.Sleep(1500 * time.Millisecond)
+	host.updateMutex.Lock()
+	assert.Equal(t, []string{"on", "off", "on", "off"}, host.setInputs)
+	host.updateMutex.Unlock()
+
+	// disabled rules are not triggered
+	host.publish(motionAddr, "on")
+	assert.Equal(t, 4, engine.TriggerCount())
+
+	engine.Stop()
+	assert.Empty(t, host.handlers)
+	engine.Stop()
+
+	// error cases
+	_, err = rules.LoadRuleConfig(folder, "missing.yaml")
+	assert.Error(t, err)
+	config.Rules[0].When[0].Output = "test/publisher1"
+	_, err = rules.NewRuleEngine(*config, host)
+	assert.Error(t, err)
+}
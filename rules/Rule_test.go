@@ -0,0 +1,80 @@
+package rules_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/rules"
+	"github.com/stretchr/testify/assert"
+)
+
+const temperatureAddr = "test/publisher1/thermometer/temperature/0/$latest"
+const motionAddr = "test/publisher1/motion/motion/0/$latest"
+const heaterAddr = "test/publisher2/heater/switch/0/$input"
+
+func TestConditions(t *testing.T) {
+	threshold := 18.0
+	on := "on"
+	// Monday 8:30
+	monday := time.Date(2020, 6, 1, 8, 30, 0, 0, time.UTC)
+	values := map[string]string{temperatureAddr: "17.5", motionAddr: "on"}
+
+	below := rules.Condition{Output: temperatureAddr, Below: &threshold}
+	assert.True(t, below.IsMet(values, monday))
+	above := rules.Condition{Output: temperatureAddr, Above: &threshold}
+	assert.False(t, above.IsMet(values, monday))
+	// the output can be given with another message type
+	equals := rules.Condition{Output: "test/publisher1/motion/motion/0/$output", Equals: &on}
+	assert.True(t, equals.IsMet(values, monday))
+	notEquals := rules.Condition{Output: motionAddr, NotEquals: &on}
+	assert.False(t, notEquals.IsMet(values, monday))
+	// outputs without value don't meet the condition
+	unknown := rules.Condition{Output: "test/publisher1/other/temperature/0/$latest", Below: &threshold}
+	assert.False(t, unknown.IsMet(values, monday))
+	// values that aren't a number don't meet thresholds
+	assert.False(t, below.IsMet(map[string]string{temperatureAddr: "cold"}, monday))
+
+	// schedules
+	morning := rules.Condition{After: "07:00", Before: "09:00", Days: []string{"Monday", "tue"}}
+	assert.True(t, morning.IsMet(nil, monday))
+	assert.False(t, morning.IsMet(nil, monday.Add(time.Hour)))
+	assert.False(t, morning.IsMet(nil, monday.Add(-24*time.Hour)))
+	night := rules.Condition{After: "22:00", Before: "06:00"}
+	assert.True(t, night.IsMet(nil, monday.Add(15*time.Hour)))
+	assert.True(t, night.IsMet(nil, monday.Add(-3*time.Hour)))
+	assert.False(t, night.IsMet(nil, monday))
+	// an output condition with a schedule
+	below.After = "09:00"
+	assert.False(t, below.IsMet(values, monday))
+
+	// rules match all or any of the conditions
+	rule := rules.Rule{Name: "heating", When: []rules.Condition{above, equals}}
+	assert.False(t, rule.IsMet(values, monday))
+	rule.Match = rules.MatchAny
+	assert.True(t, rule.IsMet(values, monday))
+	assert.False(t, (&rules.Rule{}).IsMet(values, monday))
+}
+
+func TestValidateRule(t *testing.T) {
+	threshold := 18.0
+	action := rules.Action{Input: heaterAddr, Value: "on"}
+	rule := rules.Rule{Name: "heating",
+		When: []rules.Condition{{Output: temperatureAddr, Below: &threshold}, {After: "7:00"}},
+		Then: []rules.Action{action},
+	}
+	assert.NoError(t, rule.Validate())
+
+	invalidRules := []rules.Rule{
+		{Name: "no conditions", Then: []rules.Action{action}},
+		{Name: "match", Match: "some", When: rule.When},
+		{Name: "output", When: []rules.Condition{{Output: "test/publisher1/+/temperature/0/$latest"}}},
+		{Name: "value without output", When: []rules.Condition{{Below: &threshold}}},
+		{Name: "empty condition", When: []rules.Condition{{}}},
+		{Name: "time", When: []rules.Condition{{After: "25:00"}}},
+		{Name: "day", When: []rules.Condition{{Days: []string{"someday"}}}},
+		{Name: "input", When: rule.When, Else: []rules.Action{{Input: "test/publisher2/heater"}}},
+	}
+	for _, invalidRule := range invalidRules {
+		assert.Error(t, invalidRule.Validate(), invalidRule.Name)
+	}
+}
@@ -0,0 +1,195 @@
+// Package simulator with a publisher of simulated nodes for load testing, integration testing and demos
+package simulator
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Simulator defaults
+const (
+	DefaultNodeCount = 10 // nr of simulated nodes
+	DefaultInterval  = 10 // seconds between value updates
+)
+
+// DefaultOutputTypes are the outputs of each simulated node
+var DefaultOutputTypes = []types.OutputType{
+	types.OutputTypeTemperature, types.OutputTypeHumidity, types.OutputTypeSwitch,
+}
+
+// SimulatorConfig with the simulated nodes and their behavior
+type SimulatorConfig struct {
+	NodeCount   int                           `yaml:"nodeCount"`   // nr of simulated nodes. Default is DefaultNodeCount
+	Interval    int                           `yaml:"interval"`    // seconds between value updates. Default is DefaultInterval
+	OutputTypes []types.OutputType            `yaml:"outputTypes"` // outputs of each node. Default is DefaultOutputTypes
+	Scripts     map[types.OutputType][]string `yaml:"scripts"`     // values to cycle through by output type instead of random values
+	FaultRate   float64                       `yaml:"faultRate"`   // probability 0-1 that a node fails each interval. Default 0 is no faults
+	RecoverRate float64                       `yaml:"recoverRate"` // probability 0-1 that a failed node recovers each interval. Default 0 is 0.5
+	Seed        int64                         `yaml:"seed"`        // seed of the random values. Default 0 uses the current time
+}
+
+// Simulator publishes simulated nodes with randomized or scripted output values. Nodes fail at random
+// with the configured fault rate, after which they report an error and stop updating their values
+// until they recover.
+type Simulator struct {
+	config      SimulatorConfig
+	pub         *publisher.Publisher        // publisher of the simulated nodes
+	random      *rand.Rand                  // source of random values and faults
+	values      map[string]float64          // last random value by output ID
+	scriptIndex map[string]int              // index of the next scripted value by output ID
+	failed      map[string]bool             // nodes that are failed by hardware ID
+	updateCount int                         // nr of updated values
+	updateMutex *sync.Mutex                 // mutex for concurrent polling
+	nodeHWIDs   []string                    // hardware IDs of the simulated nodes
+	outputIDs   map[string][]string         // IDs of the outputs by node hardware ID
+	outputTypes map[string]types.OutputType // type of output by output ID
+}
+
+// GetNodeHWIDs returns the hardware IDs of the simulated nodes
+func (sim *Simulator) GetNodeHWIDs() []string {
+	return sim.nodeHWIDs
+}
+
+// IsFailed returns true if the simulated node has failed
+func (sim *Simulator) IsFailed(nodeHWID string) bool {
+	sim.updateMutex.Lock()
+	defer sim.updateMutex.Unlock()
+	return sim.failed[nodeHWID]
+}
+
+// UpdateCount returns the number of values that are updated
+func (sim *Simulator) UpdateCount() int {
+	sim.updateMutex.Lock()
+	defer sim.updateMutex.Unlock()
+	return sim.updateCount
+}
+
+// Poll updates the output values of the simulated nodes and injects faults
+// This is invoked by the publisher at the configured interval.
+func (sim *Simulator) Poll(pub *publisher.Publisher) {
+	sim.updateMutex.Lock()
+	defer sim.updateMutex.Unlock()
+	for _, nodeHWID := range sim.nodeHWIDs {
+		if sim.failed[nodeHWID] {
+			if sim.random.Float64() < sim.config.RecoverRate {
+				logrus.Infof("Simulator.Poll: Node %s recovered", nodeHWID)
+				delete(sim.failed, nodeHWID)
+				pub.UpdateNodeErrorStatus(nodeHWID, types.NodeRunStateReady, "")
+			} else {
+				continue
+			}
+		} else if sim.config.FaultRate > 0 && sim.random.Float64() < sim.config.FaultRate {
+			logrus.Infof("Simulator.Poll: Injecting fault in node %s", nodeHWID)
+			sim.failed[nodeHWID] = true
+			pub.UpdateNodeErrorStatus(nodeHWID, types.NodeRunStateError, "Simulated fault")
+			continue
+		}
+		for _, outputID := range sim.outputIDs[nodeHWID] {
+			outputType := sim.outputTypes[outputID]
+			pub.UpdateOutputValue(nodeHWID, outputType, types.DefaultOutputInstance, sim.nextValue(outputID, outputType))
+			sim.updateCount++
+		}
+	}
+}
+
+// Start updating the simulated values at the configured interval
+// The publisher must be started separately.
+func (sim *Simulator) Start() {
+	sim.pub.SetPollInterval(sim.config.Interval, sim.Poll)
+}
+
+// Stop updating the simulated values
+func (sim *Simulator) Stop() {
+	sim.pub.SetPollInterval(sim.config.Interval, nil)
+}
+
+// nextValue returns the next value of an output
+// For internal use only. Use within locked section.
+func (sim *Simulator) nextValue(outputID string, outputType types.OutputType) string {
+	script := sim.config.Scripts[outputType]
+	if len(script) > 0 {
+		index := sim.scriptIndex[outputID] % len(script)
+		sim.scriptIndex[outputID] = index + 1
+		return script[index]
+	}
+	switch outputType {
+	case types.OutputTypeSwitch, types.OutputTypeMotion, types.OutputTypeDoorWindowSensor:
+		return strconv.FormatBool(sim.random.Intn(2) == 1)
+	case types.OutputTypeHumidity:
+		return sim.randomWalk(outputID, 50, 0, 100)
+	case types.OutputTypeTemperature:
+		return sim.randomWalk(outputID, 20, -20, 40)
+	}
+	return sim.randomWalk(outputID, 50, 0, 100)
+}
+
+// randomWalk returns the next value of a random walk within a range
+// For internal use only. Use within locked section.
+func (sim *Simulator) randomWalk(outputID string, initial float64, min float64, max float64) string {
+	value, found := sim.values[outputID]
+	if !found {
+		value = initial
+	}
+	value += (sim.random.Float64() - 0.5) * (max - min) / 20
+	if value < min {
+		value = min
+	} else if value > max {
+		value = max
+	}
+	sim.values[outputID] = value
+	return strconv.FormatFloat(value, 'f', 1, 64)
+}
+
+// NewSimulator creates the simulated nodes and their outputs in the publisher.
+// Use Start() to start updating values.
+func NewSimulator(pub *publisher.Publisher, config SimulatorConfig) *Simulator {
+	if config.NodeCount <= 0 {
+		config.NodeCount = DefaultNodeCount
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if len(config.OutputTypes) == 0 {
+		config.OutputTypes = DefaultOutputTypes
+	}
+	if config.RecoverRate <= 0 {
+		config.RecoverRate = 0.5
+	}
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+	sim := &Simulator{
+		config:      config,
+		pub:         pub,
+		random:      rand.New(rand.NewSource(config.Seed)),
+		values:      make(map[string]float64),
+		scriptIndex: make(map[string]int),
+		failed:      make(map[string]bool),
+		updateMutex: &sync.Mutex{},
+		nodeHWIDs:   make([]string, 0, config.NodeCount),
+		outputIDs:   make(map[string][]string),
+		outputTypes: make(map[string]types.OutputType),
+	}
+	for i := 1; i <= config.NodeCount; i++ {
+		nodeHWID := fmt.Sprintf("sim-%03d", i)
+		pub.CreateNode(nodeHWID, types.NodeTypeMultisensor)
+		pub.UpdateNodeAttr(nodeHWID, types.NodeAttrMap{
+			types.NodeAttrName:         fmt.Sprintf("Simulated node %d", i),
+			types.NodeAttrManufacturer: "iotdomain simulator",
+		})
+		sim.nodeHWIDs = append(sim.nodeHWIDs, nodeHWID)
+		for _, outputType := range config.OutputTypes {
+			output := pub.CreateOutput(nodeHWID, outputType, types.DefaultOutputInstance)
+			sim.outputIDs[nodeHWID] = append(sim.outputIDs[nodeHWID], output.OutputID)
+			sim.outputTypes[output.OutputID] = outputType
+		}
+	}
+	return sim
+}
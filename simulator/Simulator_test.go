@@ -0,0 +1,84 @@
+package simulator_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/simulator"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPublisher(t *testing.T) (*publisher.Publisher, func()) {
+	configFolder, err := ioutil.TempDir("", "simulator")
+	require.NoError(t, err)
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	pub := publisher.NewPublisher(&publisher.PublisherConfig{
+		ConfigFolder: configFolder,
+		Domain:       "test",
+		PublisherID:  "simpub",
+	}, messenger)
+	return pub, func() { os.RemoveAll(configFolder) }
+}
+
+func TestSimulatedNodes(t *testing.T) {
+	pub, cleanup := newTestPublisher(t)
+	defer cleanup()
+	sim := simulator.NewSimulator(pub, simulator.SimulatorConfig{NodeCount: 3, Seed: 1})
+	assert.Equal(t, []string{"sim-001", "sim-002", "sim-003"}, sim.GetNodeHWIDs())
+	assert.Len(t, pub.GetNodes(), 3)
+	assert.Len(t, pub.GetOutputs(), 3*len(simulator.DefaultOutputTypes))
+
+	sim.Poll(pub)
+	assert.Equal(t, 3*len(simulator.DefaultOutputTypes), sim.UpdateCount())
+	value := pub.GetOutputValueByNodeHWID("sim-001", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	require.NotNil(t, value)
+	assert.NotEmpty(t, value.Value)
+	value = pub.GetOutputValueByNodeHWID("sim-001", types.OutputTypeSwitch, types.DefaultOutputInstance)
+	require.NotNil(t, value)
+	assert.Contains(t, []string{"true", "false"}, value.Value)
+}
+
+func TestScriptedValues(t *testing.T) {
+	pub, cleanup := newTestPublisher(t)
+	defer cleanup()
+	sim := simulator.NewSimulator(pub, simulator.SimulatorConfig{
+		NodeCount:   1,
+		OutputTypes: []types.OutputType{types.OutputTypeTemperature},
+		Scripts:     map[types.OutputType][]string{types.OutputTypeTemperature: {"10", "20"}},
+	})
+	expected := []string{"10", "20", "10"}
+	for _, expectedValue := range expected {
+		sim.Poll(pub)
+		value := pub.GetOutputValueByNodeHWID("sim-001", types.OutputTypeTemperature, types.DefaultOutputInstance)
+		require.NotNil(t, value)
+		assert.Equal(t, expectedValue, value.Value)
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	pub, cleanup := newTestPublisher(t)
+	defer cleanup()
+	// a node always fails and recovers in the next poll
+	sim := simulator.NewSimulator(pub, simulator.SimulatorConfig{
+		NodeCount:   1,
+		FaultRate:   1,
+		RecoverRate: 1,
+		Seed:        1,
+	})
+	sim.Poll(pub)
+	assert.True(t, sim.IsFailed("sim-001"))
+	assert.Equal(t, 0, sim.UpdateCount(), "Not expecting values of a failed node")
+	runState, _ := pub.GetNodeStatus("sim-001", types.NodeStatusRunState)
+	assert.Equal(t, types.NodeRunStateError, runState)
+
+	sim.Poll(pub)
+	assert.False(t, sim.IsFailed("sim-001"))
+	assert.Equal(t, len(simulator.DefaultOutputTypes), sim.UpdateCount())
+	runState, _ = pub.GetNodeStatus("sim-001", types.NodeStatusRunState)
+	assert.Equal(t, types.NodeRunStateReady, runState)
+}
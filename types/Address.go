@@ -0,0 +1,172 @@
+// Package types with the address of publications in the domain
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Address of a publication in the domain. Addresses have one of the forms:
+//  domain/publisherID/messageType for publications of a publisher, eg its identity
+//  domain/publisherID/nodeID/messageType for publications of a node
+//  domain/publisherID/nodeID/ioType/instance/messageType for publications of an input or output
+// The message type starts with '$'. Base addresses have no message type.
+type Address struct {
+	domain      string
+	instance    string
+	ioType      string
+	messageType string
+	nodeID      string
+	publisherID string
+}
+
+// Domain returns the domain of the address
+func (addr Address) Domain() string {
+	return addr.domain
+}
+
+// Instance returns the input or output instance of the address, "" if not an input or output address
+func (addr Address) Instance() string {
+	return addr.instance
+}
+
+// IOType returns the input or output type of the address, "" if not an input or output address
+func (addr Address) IOType() string {
+	return addr.ioType
+}
+
+// MessageType returns the message type of the address, "" for a base address
+func (addr Address) MessageType() string {
+	return addr.messageType
+}
+
+// NodeID returns the node ID of the address, "" if the address is of a publisher
+func (addr Address) NodeID() string {
+	return addr.nodeID
+}
+
+// PublisherID returns the publisher ID of the address
+func (addr Address) PublisherID() string {
+	return addr.publisherID
+}
+
+// BaseAddress returns the address without message type, eg domain/publisherID/nodeID
+func (addr Address) BaseAddress() string {
+	return strings.Join(addr.segments(), "/")
+}
+
+// PublisherAddress returns the address of the publisher: domain/publisherID
+func (addr Address) PublisherAddress() string {
+	return addr.domain + "/" + addr.publisherID
+}
+
+// String returns the address as it is used in publications
+func (addr Address) String() string {
+	if addr.messageType == "" {
+		return addr.BaseAddress()
+	}
+	return addr.BaseAddress() + "/" + addr.messageType
+}
+
+// Validate returns an error if the address can't be used for publication. All segments must be
+// provided, and can't contain separators or subscription wildcards.
+func (addr Address) Validate() error {
+	if addr.domain == "" || addr.publisherID == "" {
+		return fmt.Errorf("Address '%s' is missing the domain or publisher", addr)
+	} else if addr.ioType != "" && (addr.nodeID == "" || addr.instance == "") {
+		return fmt.Errorf("Address '%s' is missing the node or instance of the input or output", addr)
+	} else if addr.instance != "" && addr.ioType == "" {
+		return fmt.Errorf("Address '%s' is missing the input or output type", addr)
+	} else if addr.messageType != "" && !strings.HasPrefix(addr.messageType, "$") {
+		return fmt.Errorf("Address '%s' message type doesn't start with '$'", addr)
+	}
+	for _, segment := range append(addr.segments(), addr.messageType) {
+		if strings.ContainsAny(segment, "/+#") {
+			return fmt.Errorf("Address '%s' segment '%s' contains a separator or wildcard", addr, segment)
+		}
+	}
+	return nil
+}
+
+// WithMessageType returns a copy of the address with the given message type, eg to make the
+// $setInput address of an input from its $input discovery address. Use "" for the base address.
+func (addr Address) WithMessageType(messageType string) Address {
+	addr.messageType = messageType
+	return addr
+}
+
+// segments returns the segments of the address without message type
+func (addr Address) segments() []string {
+	segments := []string{addr.domain, addr.publisherID}
+	if addr.nodeID != "" {
+		segments = append(segments, addr.nodeID)
+	}
+	if addr.ioType != "" {
+		segments = append(segments, addr.ioType, addr.instance)
+	}
+	return segments
+}
+
+// MakeInputOutputAddress returns the address of a publication of an input or output
+func MakeInputOutputAddress(domain string, publisherID string, nodeID string,
+	ioType string, instance string, messageType string) Address {
+	return Address{domain: domain, publisherID: publisherID, nodeID: nodeID,
+		ioType: ioType, instance: instance, messageType: messageType}
+}
+
+// MakeNodeAddress returns the address of a publication of a node
+func MakeNodeAddress(domain string, publisherID string, nodeID string, messageType string) Address {
+	return Address{domain: domain, publisherID: publisherID, nodeID: nodeID, messageType: messageType}
+}
+
+// MakePublisherAddress returns the address of a publication of a publisher
+func MakePublisherAddress(domain string, publisherID string, messageType string) Address {
+	return Address{domain: domain, publisherID: publisherID, messageType: messageType}
+}
+
+// ParseAddress parses a publication address or base address. Subscription wildcards are accepted
+// as segments, use Validate to verify the address can be used for publication.
+// Returns an error if the address doesn't have the segments of a publisher, node, input or output.
+// Parsing doesn't allocate memory for valid addresses, as it is used for each received message.
+func ParseAddress(address string) (Address, error) {
+	var addr Address
+	var segmentBuffer [6]string
+	segments := splitAddress(address, segmentBuffer[:0])
+	count := len(segments)
+	// the last segment is the message type, unless it is the publisher ID, eg of the $dss
+	if lastSegment := segments[count-1]; strings.HasPrefix(lastSegment, "$") && count > 2 {
+		addr.messageType = lastSegment
+		segments = segments[:count-1]
+	}
+	for _, segment := range segments {
+		if segment == "" {
+			return addr, fmt.Errorf("ParseAddress: Address '%s' has an empty segment", address)
+		}
+	}
+	switch len(segments) {
+	case 5:
+		addr.ioType, addr.instance = segments[3], segments[4]
+		fallthrough
+	case 3:
+		addr.nodeID = segments[2]
+		fallthrough
+	case 2:
+		addr.domain, addr.publisherID = segments[0], segments[1]
+	default:
+		return addr, fmt.Errorf("ParseAddress: Address '%s' has an invalid nr of segments", address)
+	}
+	return addr, nil
+}
+
+// splitAddress appends the segments of the address to the given slice and returns the result
+// Unlike strings.Split this doesn't allocate if the slice has room for the segments.
+func splitAddress(address string, segments []string) []string {
+	for {
+		separator := strings.IndexByte(address, '/')
+		if separator < 0 {
+			return append(segments, address)
+		}
+		segments = append(segments, address[:separator])
+		address = address[separator+1:]
+	}
+}
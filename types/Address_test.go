@@ -0,0 +1,86 @@
+package types_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddress(t *testing.T) {
+	// input and output addresses
+	addr, err := types.ParseAddress("test/publisher1/node1/switch/0/$input")
+	require.NoError(t, err)
+	assert.Equal(t, "test", addr.Domain())
+	assert.Equal(t, "publisher1", addr.PublisherID())
+	assert.Equal(t, "node1", addr.NodeID())
+	assert.Equal(t, "switch", addr.IOType())
+	assert.Equal(t, "0", addr.Instance())
+	assert.Equal(t, types.MessageTypeInputDiscovery, addr.MessageType())
+	assert.Equal(t, "test/publisher1", addr.PublisherAddress())
+	assert.Equal(t, "test/publisher1/node1/switch/0", addr.BaseAddress())
+	assert.Equal(t, "test/publisher1/node1/switch/0/$setInput", addr.WithMessageType(types.MessageTypeSetInput).String())
+	assert.NoError(t, addr.Validate())
+
+	// node, publisher and base addresses
+	addr, err = types.ParseAddress("test/publisher1/node1/$node")
+	require.NoError(t, err)
+	assert.Equal(t, "node1", addr.NodeID())
+	assert.Empty(t, addr.IOType())
+	addr, err = types.ParseAddress("test/$dss/$revoked")
+	require.NoError(t, err)
+	assert.Equal(t, "$dss", addr.PublisherID())
+	assert.Empty(t, addr.NodeID())
+	assert.Equal(t, types.MessageTypeRevoked, addr.MessageType())
+	addr, err = types.ParseAddress("test/$dss")
+	require.NoError(t, err)
+	assert.Equal(t, "$dss", addr.PublisherID())
+	assert.Empty(t, addr.MessageType())
+	addr, err = types.ParseAddress("test/publisher1/node1/switch/0")
+	require.NoError(t, err)
+	assert.Equal(t, "0", addr.Instance())
+	assert.Equal(t, "test/publisher1/node1/switch/0", addr.String())
+
+	// wildcards are parsed but are not valid for publication
+	addr, err = types.ParseAddress("test/publisher1/+/$configure")
+	require.NoError(t, err)
+	assert.Error(t, addr.Validate())
+
+	// error cases - missing or empty segments
+	_, err = types.ParseAddress("test")
+	assert.Error(t, err)
+	_, err = types.ParseAddress("test/publisher1/node1/switch/$input")
+	assert.Error(t, err)
+	_, err = types.ParseAddress("test//node1/$node")
+	assert.Error(t, err)
+	_, err = types.ParseAddress("")
+	assert.Error(t, err)
+
+	// parsing received addresses doesn't allocate
+	allocs := testing.AllocsPerRun(100, func() {
+		types.ParseAddress("test/publisher1/node1/switch/0/$input")
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestMakeAddress(t *testing.T) {
+	addr := types.MakeInputOutputAddress("test", "publisher1", "node1", "temperature", "0", types.MessageTypeLatest)
+	assert.Equal(t, "test/publisher1/node1/temperature/0/$latest", addr.String())
+	parsed, err := types.ParseAddress(addr.String())
+	require.NoError(t, err)
+	assert.Equal(t, addr, parsed)
+
+	addr = types.MakeNodeAddress("test", "publisher1", "node1", types.MessageTypeConfigure)
+	assert.Equal(t, "test/publisher1/node1/$configure", addr.String())
+	addr = types.MakePublisherAddress("test", "publisher1", types.MessageTypeIdentity)
+	assert.Equal(t, "test/publisher1/$identity", addr.String())
+	assert.NoError(t, addr.Validate())
+
+	// error cases - invalid segments
+	assert.Error(t, types.MakePublisherAddress("test", "", types.MessageTypeIdentity).Validate())
+	assert.Error(t, types.MakeNodeAddress("test", "publisher1", "node/1", types.MessageTypeNodeDiscovery).Validate())
+	assert.Error(t, types.MakeNodeAddress("test", "publisher1", "node1", "configure").Validate())
+	assert.Error(t, types.MakeInputOutputAddress("test", "publisher1", "", "switch", "0", "").Validate())
+	assert.Error(t, types.MakeInputOutputAddress("test", "publisher1", "node1", "", "0", "").Validate())
+}
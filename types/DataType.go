@@ -26,3 +26,17 @@ const (
 	// value is a json object
 	DataTypeJSON DataType = "json"
 )
+
+// IsValidEnumValue returns true if the value is one of the enum values. Without enum values any
+// value is valid.
+func IsValidEnumValue(enumValues []string, value string) bool {
+	if len(enumValues) == 0 {
+		return true
+	}
+	for _, enumValue := range enumValues {
+		if enumValue == value {
+			return true
+		}
+	}
+	return false
+}
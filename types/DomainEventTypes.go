@@ -0,0 +1,75 @@
+// Package types with domain event log type definitions
+package types
+
+// DomainEventType with significant events observed on the domain
+type DomainEventType string
+
+// DomainEventType values
+const (
+	DomainEventIdentityChanged      DomainEventType = "identityChanged"      // publisher identity has changed
+	DomainEventNodeAdded            DomainEventType = "nodeAdded"            // node is discovered
+	DomainEventNodeMoved            DomainEventType = "nodeMoved"            // location of a node has changed
+	DomainEventNodeRemoved          DomainEventType = "nodeRemoved"          // node discovery is removed
+	DomainEventPublisherAppeared    DomainEventType = "publisherAppeared"    // publisher is discovered or reconnected
+	DomainEventPublisherDisappeared DomainEventType = "publisherDisappeared" // publisher disconnected or its connection is lost
+)
+
+// DomainEvent is a record in the domain event log
+type DomainEvent struct {
+	Address   string          `json:"address"`           // address of the publisher or node the event applies to
+	Details   string          `json:"details,omitempty"` // optional event details
+	EventType DomainEventType `json:"event"`             // type of event
+	Timestamp string          `json:"timestamp"`         // time the event was observed
+}
+
+// DomainEventSummaryMessage with the number of domain events observed during a day
+type DomainEventSummaryMessage struct {
+	Address   string                  `json:"address"`   // publication address of this message, eg domain/publisherId/$eventSummary
+	Counts    map[DomainEventType]int `json:"counts"`    // number of events by event type
+	Date      string                  `json:"date"`      // day of the summary, YYYY-MM-DD
+	Timestamp string                  `json:"timestamp"` // time the summary was created
+}
+
+// DomainStatsMessage with anonymous reception statistics of a consumer over an interval, so domain
+// operators can detect broker or publisher problems from aggregate telemetry. It doesn't contain
+// the addresses of received messages.
+type DomainStatsMessage struct {
+	Address              string `json:"address"`              // publication address of this message, eg domain/publisherId/$domainstats
+	Gaps                 int    `json:"gaps"`                 // nr of times a publisher resumed sending after a silence longer than the gap threshold
+	Interval             int    `json:"interval"`             // duration of the statistics in seconds
+	MessagesReceived     int    `json:"messagesReceived"`     // nr of messages received
+	Publishers           int    `json:"publishers"`           // nr of publishers messages were received from
+	Timestamp            string `json:"timestamp"`            // time the statistics were published
+	VerificationFailures int    `json:"verificationFailures"` // nr of received messages that failed decryption or signature verification
+}
+
+// FleetHealth of a publisher in the fleet health report
+type FleetHealth string
+
+// FleetHealth values
+const (
+	FleetHealthOffline FleetHealth = "offline" // publisher disconnected, failed or its connection is lost
+	FleetHealthOnline  FleetHealth = "online"  // publisher is connected and was seen within the offline threshold
+	FleetHealthSilent  FleetHealth = "silent"  // publisher was not seen within the offline threshold
+	FleetHealthWarning FleetHealth = "warning" // publisher is connected with a warning status
+)
+
+// FleetHealthMessage with the health of the publishers of a domain, published by a fleet monitor
+type FleetHealthMessage struct {
+	Address    string                 `json:"address"`    // publication address of this message, eg domain/publisherId/$fleethealth
+	Offline    int                    `json:"offline"`    // nr of publishers that are offline or silent
+	Online     int                    `json:"online"`     // nr of publishers that are online, including those with a warning
+	Publishers []FleetPublisherHealth `json:"publishers"` // health of each publisher, sorted by address
+	Timestamp  string                 `json:"timestamp"`  // time the report was created
+}
+
+// FleetPublisherHealth is the health of a publisher in the fleet health report
+type FleetPublisherHealth struct {
+	Address      string            `json:"address"`                // address of the publisher, eg domain/publisherId
+	Health       FleetHealth       `json:"health"`                 // health of the publisher
+	LastSeen     string            `json:"lastSeen"`               // time the identity or status of the publisher was last received
+	OfflineNodes []string          `json:"offlineNodes,omitempty"` // nodes reported offline in the last status
+	PublisherID  string            `json:"publisherId"`            // ID of the publisher
+	Reason       string            `json:"reason,omitempty"`       // reason of the last status, if any
+	Status       PublisherRunState `json:"status,omitempty"`       // last received run state, "" if no status is received
+}
@@ -47,27 +47,40 @@ const (
 
 // InputDiscoveryMessage with node input description
 type InputDiscoveryMessage struct {
-	Address    string        `json:"address"`              // Discovery address of the input
-	Attr       NodeAttrMap   `json:"attr"`                 // Attributes describing this input
-	Config     ConfigAttrMap `json:"config,omitempty"`     // Optional configuration of input
-	DataType   DataType      `json:"dataType,omitempty"`   // input value data type
-	EnumValues []string      `json:"enumValues,omitempty"` // enum valid input values for enum datatypes
-	Max        float32       `json:"max,omitempty"`        // optional max value of input for numeric data types
-	Min        float32       `json:"min,omitempty"`        // optional min value of input for numeric data types
-	Source     string        `json:"source,omitempty"`     // the input source URL, empty for set commands
-	Timestamp  string        `json:"timestamp"`            // Time the record is last updated
-	Unit       Unit          `json:"unit,omitempty"`       // unit of value
+	Address       string         `json:"address"`                 // Discovery address of the input
+	Attr          NodeAttrMap    `json:"attr"`                    // Attributes describing this input
+	ChangeReasons []ChangeReason `json:"changeReasons,omitempty"` // reasons the input is republished since its previous publication
+	Config        ConfigAttrMap  `json:"config,omitempty"`        // Optional configuration of input
+	DataType      DataType       `json:"dataType,omitempty"`      // input value data type
+	EnumValues    []string       `json:"enumValues,omitempty"`    // enum valid input values for enum datatypes
+	Max           float32        `json:"max,omitempty"`           // optional max value of input for numeric data types
+	Min           float32        `json:"min,omitempty"`           // optional min value of input for numeric data types
+	Source        string         `json:"source,omitempty"`        // the input source URL, empty for set commands
+	Timestamp     string         `json:"timestamp"`               // Time the record is last updated
+	Unit          Unit           `json:"unit,omitempty"`          // unit of value
 	// For internal use. Filled when registering inputs
 	InputID     string    `json:"-"` // ID of input using NodeHWID
+	LocalOnly   bool      `json:"-"` // input for internal use only. Not published
 	NodeHWID    string    `json:"-"` // Hardware address of the node the input belongs to
 	PublisherID string    `json:"-"` // publisher of the input
 	InputType   InputType `json:"-"` // type of input
 	Instance    string    `json:"-"` // instance of input
 }
 
+// InputConfigureMessage with values to update an input configuration
+type InputConfigureMessage struct {
+	Address   string      `json:"address"`             // zone/publisher/node/type/instance/$configure
+	Attr      NodeAttrMap `json:"attr"`                // attributes to configure
+	MessageID string      `json:"messageId,omitempty"` // optional ID of the command to request an acknowledgement
+	Sender    string      `json:"sender"`              // sending node: zone/publisher/node
+	Timestamp string      `json:"timestamp"`
+}
+
 // SetInputMessage to control an input
 type SetInputMessage struct {
-	Address   string `json:"address"` // zone/publisher/node/$set/type/instance
+	Address   string `json:"address"`             // zone/publisher/node/$set/type/instance
+	ExecuteAt string `json:"executeAt,omitempty"` // optional time to apply the command, in TimeFormat. Default is immediately
+	MessageID string `json:"messageId,omitempty"` // optional ID of the command to request an acknowledgement
 	Timestamp string `json:"timestamp"`
 	Sender    string `json:"sender"` // sending node: zone/publisher/nodeId
 	Value     string `json:"value"`  // this can also be a string containing a list, eg "[ a, b, c ]""
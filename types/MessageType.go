@@ -1,29 +1,115 @@
 // Package types with IoTDomain message types used in message addressing
 package types
 
+import "encoding/json"
+
 // MessageType used in message addressing
 type MessageType string
 
 // Available message types from the standard
 const (
-	MessageTypeConfigure       = "$configure"   // node configuration, payload is NodeConfigureMessage
-	MessageTypeCreate          = "$create"      // create node command
-	MessageTypeDelete          = "$delete"      // delete node command
-	MessageTypeEvent           = "$event"       // node outputs event, payload is EventMessage
-	MessageTypeForecast        = "$forecast"    // output forecast, payload is HistoryMessage
-	MessageTypeHistory         = "$history"     // output history, payload is HistoryMessage
-	MessageTypeIdentity        = "$identity"    // publisher identity
-	MessageTypeInputDiscovery  = "$input"       // input discovery, payload is InOutput object
-	MessageTypeLatest          = "$latest"      // latest output, payload is latest message
-	MessageTypeNodeDiscovery   = "$node"        // node discovery, payload is Node object
-	MessageTypeOutputDiscovery = "$output"      // output discovery, payload output definition
-	MessageTypeStatus          = "$status"      // publisher runtime status, connected, disconnected, lost
-	MessageTypeSetIdentity     = "$setIdentity" // renew publisher identity keys
-	MessageTypeSetInput        = "$setInput"    // command to set input value, payload is input value
-	MessageTypeSetNodeID       = "$setNodeId"   // set node ID, payload is SetNodeIDMessage
-	MessageTypeUpgrade         = "$upgrade"     // perform firmware upgrade, payload is UpgradeMessage
-	MessageTypeRaw             = "$raw"         // raw output value
+	MessageTypeAck             = "$ack"          // acknowledgement of a command, payload is AckMessage
+	MessageTypeCalibrate       = "$calibrate"    // output calibration command, payload is OutputCalibrateMessage
+	MessageTypeConfigure       = "$configure"    // node or input configuration, payload is NodeConfigureMessage or InputConfigureMessage
+	MessageTypeCreate          = "$create"       // create node command
+	MessageTypeDelete          = "$delete"       // delete node command
+	MessageTypeDomainStats     = "$domainstats"  // reception statistics of a consumer, payload is DomainStatsMessage
+	MessageTypeEvent           = "$event"        // node outputs event, payload is EventMessage
+	MessageTypeEventSummary    = "$eventSummary" // daily summary of the domain event log, payload is DomainEventSummaryMessage
+	MessageTypeFleetHealth     = "$fleethealth"  // health of the publishers of a domain, payload is FleetHealthMessage
+	MessageTypeForecast        = "$forecast"     // output forecast, payload is OutputForecastMessage
+	MessageTypeHistory         = "$history"      // output history, payload is HistoryMessage
+	MessageTypeIdentity        = "$identity"     // publisher identity
+	MessageTypeInputDiscovery  = "$input"        // input discovery, payload is InOutput object
+	MessageTypeLatest          = "$latest"       // latest output, payload is latest message
+	MessageTypeNodeDiscovery   = "$node"         // node discovery, payload is Node object
+	MessageTypeOutputDiscovery = "$output"       // output discovery, payload output definition
+	MessageTypePair            = "$pair"         // pairing command, payload is PairMessage
+	MessageTypePairStatus      = "$pairStatus"   // pairing mode and candidate devices, payload is PairStatusMessage
+	MessageTypePseudonyms      = "$pseudonyms"   // encrypted pseudonym mapping, payload is PseudonymsMessage
+	MessageTypeRefresh         = "$refresh"      // domain request to republish discovery, payload is RefreshMessage
+	MessageTypeReply           = "$reply"        // reply to a request, payload is messaging.ReplyMessage
+	MessageTypeRetire          = "$retire"       // retirement notice of a publisher or node, payload is RetireMessage
+	MessageTypeRevoked         = "$revoked"      // identities revoked by the DSS, payload is RevocationListMessage
+	MessageTypeStatus          = "$status"       // publisher runtime status, connected, disconnected, lost
+	MessageTypeSetIdentity     = "$setIdentity"  // renew publisher identity keys
+	MessageTypeSigningPolicy   = "$signing"      // message types a publisher publishes unsigned, payload is SigningPolicyMessage
+	MessageTypeSetInput        = "$setInput"     // command to set input value, payload is input value
+	MessageTypeSetNodeID       = "$setNodeId"    // set node ID, payload is SetNodeIDMessage
+	MessageTypeUpgrade         = "$upgrade"      // perform firmware upgrade, payload is UpgradeMessage
+	MessageTypeRaw             = "$raw"          // raw output value
 	// LocaldomainID for local-only domains (eg, no sharing outside this domain)
 	LocalDomainID = "local" // local area domain
 	TestDomainID  = "test"  // Domain to use in testing
 )
+
+// StandardMessageTypes lists the message types defined by the standard. Custom message types
+// registered by adapters cannot use these.
+var StandardMessageTypes = []string{
+	MessageTypeAck, MessageTypeCalibrate, MessageTypeConfigure, MessageTypeCreate, MessageTypeDelete, MessageTypeDomainStats,
+	MessageTypeEvent, MessageTypeEventSummary, MessageTypeFleetHealth,
+	MessageTypeForecast, MessageTypeHistory, MessageTypeIdentity, MessageTypeInputDiscovery, MessageTypeLatest,
+	MessageTypeNodeDiscovery, MessageTypeOutputDiscovery, MessageTypePair, MessageTypePairStatus,
+	MessageTypePseudonyms, MessageTypeRefresh, MessageTypeReply, MessageTypeRetire, MessageTypeRevoked,
+	MessageTypeStatus, MessageTypeSetIdentity, MessageTypeSetInput, MessageTypeSetNodeID, MessageTypeSigningPolicy,
+	MessageTypeUpgrade,
+	MessageTypeRaw,
+}
+
+// CustomMessage is the envelope of messages with a custom message type, eg $diag.
+// The payload is defined by the adapter that registered the message type.
+type CustomMessage struct {
+	Address   string          `json:"address"`   // address the message is published on
+	Payload   json.RawMessage `json:"payload"`   // JSON encoded payload of the message
+	Sender    string          `json:"sender"`    // address of the sending publisher
+	Timestamp string          `json:"timestamp"` // time the message was created
+}
+
+// ChangeReason describes why a node, input or output discovery is republished
+type ChangeReason string
+
+// Reasons for republishing a discovery, included in the discovery message for troubleshooting
+const (
+	ChangeReasonAttr    ChangeReason = "attr"    // attributes or address have changed
+	ChangeReasonConfig  ChangeReason = "config"  // configuration or configuration values have changed
+	ChangeReasonCreated ChangeReason = "created" // newly created or restored
+	ChangeReasonForced  ChangeReason = "forced"  // republished without change, eg on refresh or discovery interval
+	ChangeReasonStatus  ChangeReason = "status"  // status has changed
+)
+
+// AddChangeReason returns the list of reasons with the given reason added, if not yet included
+func AddChangeReason(reasons []ChangeReason, reason ChangeReason) []ChangeReason {
+	for _, existing := range reasons {
+		if existing == reason {
+			return reasons
+		}
+	}
+	return append(append([]ChangeReason(nil), reasons...), reason)
+}
+
+// HasChangeReason returns true if the list of reasons includes the given reason
+func HasChangeReason(reasons []ChangeReason, reason ChangeReason) bool {
+	for _, existing := range reasons {
+		if existing == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// Command acknowledgement status
+const (
+	AckStatusAccepted = "accepted" // the command is passed on to its handler
+	AckStatusRejected = "rejected" // the command is discarded, the error holds the reason
+)
+
+// AckMessage acknowledges the receipt of a command that carries a message ID. It is published by the
+// receiving publisher on the command address with message type $ack.
+type AckMessage struct {
+	Address   string `json:"address"`         // address of the acknowledgement, eg domain/publisher/node/type/instance/$ack
+	Error     string `json:"error,omitempty"` // reason the command is rejected
+	MessageID string `json:"messageId"`       // message ID of the acknowledged command
+	Sender    string `json:"sender"`          // address of the acknowledging publisher
+	Status    string `json:"status"`          // AckStatusAccepted or AckStatusRejected
+	Timestamp string `json:"timestamp"`       // time the command was acknowledged
+}
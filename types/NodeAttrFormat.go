@@ -0,0 +1,101 @@
+// Package types with formats of node attribute values and the location of nodes
+package types
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LatLonDecimals is the nr of decimals of the latitude and longitude in the latlon attribute, about 0.1m
+const LatLonDecimals = 6
+
+// EarthRadius is the mean radius of the earth in meters, used to determine the distance between locations
+const EarthRadius = 6371008.8
+
+// NodeLocation is the machine-readable location of a node, published with the node discovery
+type NodeLocation struct {
+	Altitude  float64 `json:"altitude,omitempty"` // meters above sea level
+	Latitude  float64 `json:"latitude"`           // degrees north of the equator, -90 to 90
+	Longitude float64 `json:"longitude"`          // degrees east of Greenwich, -180 to 180
+}
+
+// Distance returns the distance in meters to another location along the surface of the earth.
+// The altitude is not taken into account.
+func (location *NodeLocation) Distance(other *NodeLocation) float64 {
+	lat1 := location.Latitude * math.Pi / 180
+	lat2 := other.Latitude * math.Pi / 180
+	deltaLat := lat2 - lat1
+	deltaLon := (other.Longitude - location.Longitude) * math.Pi / 180
+	// haversine formula
+	a := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(deltaLon/2)*math.Sin(deltaLon/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// versionExpr matches a semantic version major.minor.patch with optional pre-release and build
+// metadata, eg 1.2.3, 1.2.3-beta.1 or 1.2.3+20200601. A leading 'v' is accepted.
+var versionExpr = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(-[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?(\+[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`)
+
+// FormatLatLon returns the value of the latlon attribute of a location, eg "52.370216,4.895168"
+func FormatLatLon(latitude float64, longitude float64) string {
+	return strconv.FormatFloat(latitude, 'f', LatLonDecimals, 64) + "," +
+		strconv.FormatFloat(longitude, 'f', LatLonDecimals, 64)
+}
+
+// GetNodeLocation returns the location of a node from its latlon and altitude attributes, or from
+// its published location if the attributes are not readable, eg when they are private.
+// Returns nil if the node has no valid location.
+func GetNodeLocation(node *NodeDiscoveryMessage) *NodeLocation {
+	latitude, longitude, err := ParseLatLon(node.Attr[NodeAttrLatLon])
+	if err != nil {
+		if node.Location != nil && ValidateLatLon(node.Location.Latitude, node.Location.Longitude) == nil {
+			location := *node.Location
+			return &location
+		}
+		return nil
+	}
+	location := &NodeLocation{Latitude: latitude, Longitude: longitude}
+	location.Altitude, _ = strconv.ParseFloat(node.Attr[NodeAttrAltitude], 64)
+	return location
+}
+
+// ParseLatLon returns the latitude and longitude of a latlon attribute value
+// Returns an error if the value isn't two comma separated numbers or they are out of range.
+func ParseLatLon(value string) (latitude float64, longitude float64, err error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("ParseLatLon: Value '%s' is not a latitude,longitude pair", value)
+	}
+	latitude, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err == nil {
+		longitude, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("ParseLatLon: Value '%s' is not a latitude,longitude pair", value)
+	}
+	return latitude, longitude, ValidateLatLon(latitude, longitude)
+}
+
+// ValidateLatLon returns an error if the latitude is not within -90 and 90 degrees or the
+// longitude is not within -180 and 180 degrees
+func ValidateLatLon(latitude float64, longitude float64) error {
+	if !(latitude >= -90 && latitude <= 90) {
+		return fmt.Errorf("ValidateLatLon: Latitude %f is not within -90 and 90 degrees", latitude)
+	}
+	if !(longitude >= -180 && longitude <= 180) {
+		return fmt.Errorf("ValidateLatLon: Longitude %f is not within -180 and 180 degrees", longitude)
+	}
+	return nil
+}
+
+// ValidateVersion returns an error if the version is not a semantic version, eg 1.2.3 or v1.2.3-rc.1
+func ValidateVersion(version string) error {
+	if !versionExpr.MatchString(version) {
+		return fmt.Errorf("ValidateVersion: Version '%s' is not a semantic version major.minor.patch", version)
+	}
+	return nil
+}
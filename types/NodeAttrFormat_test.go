@@ -0,0 +1,57 @@
+package types_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatLon(t *testing.T) {
+	value := types.FormatLatLon(52.3702157, 4.8951679)
+	assert.Equal(t, "52.370216,4.895168", value)
+	latitude, longitude, err := types.ParseLatLon(value)
+	require.NoError(t, err)
+	assert.InDelta(t, 52.370216, latitude, 0.0000001)
+	assert.InDelta(t, 4.895168, longitude, 0.0000001)
+	_, _, err = types.ParseLatLon("-33.86, 151.21")
+	assert.NoError(t, err)
+
+	// error cases - not a pair or out of range
+	for _, badValue := range []string{"", "52.37", "52.37,4.89,0", "north,east", "91,0", "0,-181"} {
+		_, _, err = types.ParseLatLon(badValue)
+		assert.Error(t, err, badValue)
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	for _, version := range []string{"0.0.1", "1.2.3", "v10.20.30", "1.0.0-rc.1", "1.0.0-beta+exp.sha.5114f85"} {
+		assert.NoError(t, types.ValidateVersion(version), version)
+	}
+	for _, version := range []string{"", "1", "1.2", "01.2.3", "1.2.3-", "1.2.3.4", "version1"} {
+		assert.Error(t, types.ValidateVersion(version), version)
+	}
+}
+
+func TestNodeLocation(t *testing.T) {
+	// Amsterdam to Utrecht is about 35km
+	amsterdam := &types.NodeLocation{Latitude: 52.370216, Longitude: 4.895168}
+	utrecht := &types.NodeLocation{Latitude: 52.090737, Longitude: 5.121420}
+	assert.InDelta(t, 34900, amsterdam.Distance(utrecht), 500)
+	assert.InDelta(t, 0, amsterdam.Distance(amsterdam), 0.001)
+
+	// the attributes take precedence over the published location
+	node := &types.NodeDiscoveryMessage{Attr: types.NodeAttrMap{
+		types.NodeAttrLatLon:   "52.370216,4.895168",
+		types.NodeAttrAltitude: "12.5",
+	}, Location: utrecht}
+	location := types.GetNodeLocation(node)
+	require.NotNil(t, location)
+	assert.Equal(t, 52.370216, location.Latitude)
+	assert.Equal(t, 12.5, location.Altitude)
+	node.Attr = nil
+	assert.Equal(t, utrecht, types.GetNodeLocation(node))
+	node.Location = nil
+	assert.Nil(t, types.GetNodeLocation(node))
+}
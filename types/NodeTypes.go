@@ -14,42 +14,52 @@ type NodeAttrMap map[NodeAttr]string
 // Predefined node attribute names that describe the node.
 // When they are configurable they also appear in Node Config section.
 const (
-	NodeAttrAddress         NodeAttr = "address"         // device domain or ip address
-	NodeAttrBatch           NodeAttr = "batch"           // Batch publishing size
-	NodeAttrColor           NodeAttr = "color"           // Color in hex notation
-	NodeAttrDescription     NodeAttr = "description"     // Device description
-	NodeAttrDisabled        NodeAttr = "disabled"        // device or sensor is disabled
-	NodeAttrEvent           NodeAttr = "event"           // Enable/disable event publishing
-	NodeAttrFilename        NodeAttr = "filename"        // filename to write images or other values to
-	NodeAttrGatewayAddress  NodeAttr = "gatewayAddress"  // the node gateway address
-	NodeAttrHostname        NodeAttr = "hostname"        // network device hostname
-	NodeAttrIotcVersion     NodeAttr = "iotcVersion"     // IoTDomain version
-	NodeAttrLatLon          NodeAttr = "latlon"          // latitude, longitude of the device for display on a map r/w
-	NodeAttrLocalIP         NodeAttr = "localIP"         // for IP nodes
-	NodeAttrLocationName    NodeAttr = "locationName"    // name of a location
-	NodeAttrLoginName       NodeAttr = "loginName"       // login name to connect to the device. Value is not published
-	NodeAttrMAC             NodeAttr = "mac"             // MAC address for IP nodes
-	NodeAttrManufacturer    NodeAttr = "manufacturer"    // device manufacturer
-	NodeAttrMax             NodeAttr = "max"             // maximum value of sensor or config
-	NodeAttrMin             NodeAttr = "min"             // minimum value of sensor or config
-	NodeAttrModel           NodeAttr = "model"           // device model
-	NodeAttrName            NodeAttr = "name"            // Name of device or service
-	NodeAttrNetmask         NodeAttr = "netmask"         // IP network mask
-	NodeAttrPassword        NodeAttr = "password"        // password to connect. Value is not published.
-	NodeAttrPublishBatch    NodeAttr = "publishBatch"    // int with nr of events per batch, 0 to disable
-	NodeAttrPublishEvent    NodeAttr = "publishEvent"    // enable publishing as event
-	NodeAttrPublishForecast NodeAttr = "publishForecast" // bool, publish output with $forecast message
-	NodeAttrPublishHistory  NodeAttr = "publishHistory"  // bool, publish output with $history message
-	NodeAttrPublishLatest   NodeAttr = "publishLatest"   // bool, publish output with $latest message
-	NodeAttrPublishRaw      NodeAttr = "publishRaw"      // bool, publish output with $raw message
-	NodeAttrPollInterval    NodeAttr = "pollInterval"    // polling interval in seconds
-	NodeAttrPowerSource     NodeAttr = "powerSource"     // battery, usb, mains
-	NodeAttrProduct         NodeAttr = "product"         // device product or model name
-	NodeAttrPublicKey       NodeAttr = "publicKey"       // public key for encrypting sensitive configuration settings
-	NodeAttrSoftwareVersion NodeAttr = "softwareVersion" // version of the software running the node
-	NodeAttrSubnet          NodeAttr = "subnet"          // IP subnets configuration
-	NodeAttrType            NodeAttr = "type"            // Node type
-	NodeAttrURL             NodeAttr = "url"             // node URL
+	NodeAttrActiveSource      NodeAttr = "activeSource"      // name of the active source of a failover input
+	NodeAttrAddress           NodeAttr = "address"           // device domain or ip address
+	NodeAttrAltitude          NodeAttr = "altitude"          // altitude of the device in meters above sea level
+	NodeAttrBatch             NodeAttr = "batch"             // Batch publishing size
+	NodeAttrCalibration       NodeAttr = "calibration"       // JSON object with OutputCalibration of outputs by "outputType/instance"
+	NodeAttrColor             NodeAttr = "color"             // Color in hex notation
+	NodeAttrDescription       NodeAttr = "description"       // Device description
+	NodeAttrDisabled          NodeAttr = "disabled"          // device or sensor is disabled
+	NodeAttrDiscoveryInterval NodeAttr = "discoveryInterval" // int, interval in seconds to republish the node discovery, 0 to disable
+	NodeAttrEvent             NodeAttr = "event"             // Enable/disable event publishing
+	NodeAttrFilename          NodeAttr = "filename"          // filename to write images or other values to
+	NodeAttrFirmwareVersion   NodeAttr = "firmwareVersion"   // semantic version of the device firmware
+	NodeAttrGatewayAddress    NodeAttr = "gatewayAddress"    // the node gateway address
+	NodeAttrHistoryMaxAge     NodeAttr = "historyMaxAge"     // int, max age of output history values in seconds
+	NodeAttrHistoryMaxBytes   NodeAttr = "historyMaxBytes"   // int, max memory use of the output history in bytes
+	NodeAttrHistoryMaxCount   NodeAttr = "historyMaxCount"   // int, max nr of output history values
+	NodeAttrHostname          NodeAttr = "hostname"          // network device hostname
+	NodeAttrIotcVersion       NodeAttr = "iotcVersion"       // IoTDomain version
+	NodeAttrLatLon            NodeAttr = "latlon"            // latitude, longitude of the device for display on a map r/w
+	NodeAttrLocalIP           NodeAttr = "localIP"           // for IP nodes
+	NodeAttrLocationName      NodeAttr = "locationName"      // name of a location
+	NodeAttrLoginName         NodeAttr = "loginName"         // login name to connect to the device. Value is not published
+	NodeAttrMAC               NodeAttr = "mac"               // MAC address for IP nodes
+	NodeAttrManufacturer      NodeAttr = "manufacturer"      // device manufacturer
+	NodeAttrMax               NodeAttr = "max"               // maximum value of sensor or config
+	NodeAttrMin               NodeAttr = "min"               // minimum value of sensor or config
+	NodeAttrModel             NodeAttr = "model"             // device model
+	NodeAttrName              NodeAttr = "name"              // Name of device or service
+	NodeAttrNetmask           NodeAttr = "netmask"           // IP network mask
+	NodeAttrPassword          NodeAttr = "password"          // password to connect. Value is not published.
+	NodeAttrPublishBatch      NodeAttr = "publishBatch"      // int with nr of events per batch, 0 to disable
+	NodeAttrPublishEvent      NodeAttr = "publishEvent"      // enable publishing as event
+	NodeAttrPublishForecast   NodeAttr = "publishForecast"   // bool, publish output with $forecast message
+	NodeAttrPublishHistory    NodeAttr = "publishHistory"    // bool, publish output with $history message
+	NodeAttrPublishInterval   NodeAttr = "publishInterval"   // int, minimum interval in seconds between publications of output values
+	NodeAttrPublishLatest     NodeAttr = "publishLatest"     // bool, publish output with $latest message
+	NodeAttrPublishRaw        NodeAttr = "publishRaw"        // bool, publish output with $raw message
+	NodeAttrPollInterval      NodeAttr = "pollInterval"      // polling interval in seconds
+	NodeAttrPowerSource       NodeAttr = "powerSource"       // battery, usb, mains
+	NodeAttrProduct           NodeAttr = "product"           // device product or model name
+	NodeAttrPublicKey         NodeAttr = "publicKey"         // public key for encrypting sensitive configuration settings
+	NodeAttrSoftwareVersion   NodeAttr = "softwareVersion"   // version of the software running the node
+	NodeAttrStaleTimeout      NodeAttr = "staleTimeout"      // int, seconds without output values after which the node is stale, 0 to disable
+	NodeAttrSubnet            NodeAttr = "subnet"            // IP subnets configuration
+	NodeAttrType              NodeAttr = "type"              // Node type
+	NodeAttrURL               NodeAttr = "url"               // node URL
 )
 
 // NodeStatus various node status attributes
@@ -75,11 +85,14 @@ const (
 
 // Values for Node State
 // These reflect whether a node is ready, sleeping or in error
+// A node is stale when its output values haven't been updated within its staleTimeout. The
+// lastSeen status then holds the time its values were last updated.
 const (
 	NodeRunStateError    string = "error"    // Node reports an error
 	NodeRunStateReady    string = "ready"    // Node is ready for use
 	NodeRunStateSleeping string = "sleeping" // Node has gone into sleep mode, often a battery powered devie
 	NodeRunStateLost     string = "lost"     // Node is is no longer reachable
+	NodeRunStateStale    string = "stale"    // Node output values are not updated within the stale timeout
 )
 
 // NodeType identifying  the purpose of the node
@@ -137,25 +150,73 @@ type ConfigAttr struct {
 
 // NodeConfigureMessage with values to update a node configuration
 type NodeConfigureMessage struct {
-	Address   string      `json:"address"` // zone/publisher/node/$configure
-	Attr      NodeAttrMap `json:"attr"`    // attributes to configure
-	Sender    string      `json:"sender"`  // sending node: zone/publisher/node
+	Address   string      `json:"address"`             // zone/publisher/node/$configure
+	Attr      NodeAttrMap `json:"attr"`                // attributes to configure
+	MessageID string      `json:"messageId,omitempty"` // optional ID of the command to request an acknowledgement
+	Sender    string      `json:"sender"`              // sending node: zone/publisher/node
 	Timestamp string      `json:"timestamp"`
 }
 
 // NodeDiscoveryMessage definition published in node discovery
 type NodeDiscoveryMessage struct {
-	Address   string        `json:"address"`          // Node discovery address using NodeID
-	Attr      NodeAttrMap   `json:"attr,omitempty"`   // Attributes describing this node
-	Config    ConfigAttrMap `json:"config,omitempty"` // Description of configurable attributes
-	HWID      string        `json:"hwID"`             // The node or service immutable hardware related ID
-	NodeID    string        `json:"nodeId"`           // nodeID used in address. Mutable. Default is HWAddress
-	Status    NodeStatusMap `json:"status,omitempty"` // Node performance status information
-	Timestamp string        `json:"timestamp"`        // time the record is last updated
+	Address       string         `json:"address"`                 // Node discovery address using NodeID
+	Attr          NodeAttrMap    `json:"attr,omitempty"`          // Attributes describing this node
+	ChangeReasons []ChangeReason `json:"changeReasons,omitempty"` // reasons the node is republished since its previous publication
+	Config        ConfigAttrMap  `json:"config,omitempty"`        // Description of configurable attributes
+	Deleted       string         `json:"deleted,omitempty"`       // time the node was soft deleted, "" if not deleted
+	EncryptedAttr string         `json:"encryptedAttr,omitempty"` // JWE serialized private attributes for authorized readers
+	HWID          string         `json:"hwID"`                    // The node or service immutable hardware related ID
+	Location      *NodeLocation  `json:"location,omitempty"`      // location from the public latlon and altitude attributes
+	NodeID        string         `json:"nodeId"`                  // nodeID used in address. Mutable. Default is HWAddress
+	Status        NodeStatusMap  `json:"status,omitempty"`        // Node performance status information
+	Timestamp     string         `json:"timestamp"`               // time the record is last updated
 	// For convenience, filled when registering or receiving
+	LocalOnly   bool   `json:"-"` // registered node for internal use only. Not published or saved
 	PublisherID string `json:"-"`
 }
 
+// Pairing command actions
+const (
+	PairActionAdopt  = "adopt"  // adopt a candidate device as a node
+	PairActionReject = "reject" // reject a candidate device
+	PairActionStart  = "start"  // start inclusion mode to discover candidate devices
+	PairActionStop   = "stop"   // stop inclusion mode
+)
+
+// PairCandidate is a device that is discovered in inclusion mode and can be adopted as a node
+type PairCandidate struct {
+	Attr        NodeAttrMap `json:"attr,omitempty"` // attributes describing the device, eg make and model
+	CandidateID string      `json:"candidateId"`    // ID of the candidate, unique for the publisher
+	Discovered  string      `json:"discovered"`     // time the candidate was discovered
+	NodeType    NodeType    `json:"nodeType"`       // type of node the device becomes when adopted
+}
+
+// PairMessage is a command to control the inclusion mode of a publisher, eg for Zigbee or Z-Wave pairing
+type PairMessage struct {
+	Action      string `json:"action"`                // PairActionStart, PairActionStop, PairActionAdopt or PairActionReject
+	Address     string `json:"address"`               // domain/publisher/$pair
+	CandidateID string `json:"candidateId,omitempty"` // candidate to adopt or reject
+	Sender      string `json:"sender"`                // address of the sending publisher
+	Timeout     int    `json:"timeout,omitempty"`     // seconds until inclusion mode stops. 0 for the default
+	Timestamp   string `json:"timestamp"`             // time the command was sent
+}
+
+// PairStatusMessage reports the inclusion mode of a publisher and the discovered candidate devices
+type PairStatusMessage struct {
+	Active     bool            `json:"active"`               // inclusion mode is active
+	Address    string          `json:"address"`              // domain/publisher/$pairStatus
+	Candidates []PairCandidate `json:"candidates,omitempty"` // discovered devices that await adoption
+	Until      string          `json:"until,omitempty"`      // time inclusion mode stops
+	Timestamp  string          `json:"timestamp"`            // time the status was published
+}
+
+// PseudonymsMessage shares the mapping of pseudonyms to their original values with authorized consumers
+type PseudonymsMessage struct {
+	Address   string `json:"address"`   // publisher address domain/publisherID/$pseudonyms
+	Mapping   string `json:"mapping"`   // JWE serialized map of pseudonym to original value
+	Timestamp string `json:"timestamp"` // time the mapping was published
+}
+
 // SetNodeIDMessage to change a node's ID
 type SetNodeIDMessage struct {
 	Address   string `json:"address"` // zone/publisher/node/$alias - existing address
@@ -155,27 +155,81 @@ type OutputBatchMessage struct {
 
 // OutputDiscoveryMessage with node output description
 type OutputDiscoveryMessage struct {
-	Address    string        `json:"address"`              // Address of the publication: zone/publisher/node/$output/type/instance
-	Attr       NodeAttrMap   `json:"attr,omitempty"`       // Attributes describing this output
-	Config     ConfigAttrMap `json:"config,omitempty"`     // Optional configuration of output
-	DataType   DataType      `json:"dataType,omitempty"`   // output value data type, default is string
-	EnumValues []string      `json:"enumValues,omitempty"` // possible enum output values for enum datatype
-	Max        float32       `json:"max,omitempty"`        // optional max value of output for numeric data types
-	Min        float32       `json:"min,omitempty"`        // optional min value of output for numeric data types
-	Timestamp  string        `json:"timestamp"`            // time the record is last updated
-	Unit       Unit          `json:"unit,omitempty"`       // unit of output value
+	Address       string             `json:"address"`                 // Address of the publication: zone/publisher/node/$output/type/instance
+	Attr          NodeAttrMap        `json:"attr,omitempty"`          // Attributes describing this output
+	Calibration   *OutputCalibration `json:"calibration,omitempty"`   // calibration applied to the output values
+	ChangeReasons []ChangeReason     `json:"changeReasons,omitempty"` // reasons the output is republished since its previous publication
+	Config        ConfigAttrMap      `json:"config,omitempty"`        // Optional configuration of output
+	DataType      DataType           `json:"dataType,omitempty"`      // output value data type, default is string
+	EnumValues    []string           `json:"enumValues,omitempty"`    // possible enum output values for enum datatype
+	Max           float32            `json:"max,omitempty"`           // optional max value of output for numeric data types
+	Min           float32            `json:"min,omitempty"`           // optional min value of output for numeric data types
+	Timestamp     string             `json:"timestamp"`               // time the record is last updated
+	Unit          Unit               `json:"unit,omitempty"`          // unit of output value
+	ValueTTL      int                `json:"valueTTL,omitempty"`      // seconds a value is valid after it is measured, 0 if values don't expire
 	// For convenience, filled when registering or receiving
-	OutputID    string     `json:"-"`
-	NodeHWID    string     `json:"-"`
-	PublisherID string     `json:"-"`
-	OutputType  OutputType `json:"-"`
-	Instance    string     `json:"-"`
+	Addresses   OutputAddresses `json:"-"` // precomputed publication addresses of registered outputs
+	LocalOnly   bool            `json:"-"` // output for internal use only. Not published
+	OutputID    string          `json:"-"`
+	NodeHWID    string          `json:"-"`
+	PublisherID string          `json:"-"`
+	OutputType  OutputType      `json:"-"`
+	Instance    string          `json:"-"`
 }
 
-// OutputEventMessage message with multiple output values
+// OutputAddresses holds the addresses the values of an output are published on. They are computed
+// from the output address when the output is registered, so publishing a value doesn't build addresses.
+type OutputAddresses struct {
+	Discovery string // output address the addresses are computed from
+	History   string // address of the $history publication
+	Latest    string // address of the $latest publication
+	Raw       string // address of the $raw publication
+}
+
+// CalibrationPoint maps a raw sensor value to its calibrated value
+type CalibrationPoint struct {
+	Raw   float64 `json:"raw"`   // value reported by the sensor
+	Value float64 `json:"value"` // calibrated value
+}
+
+// OutputCalibration converts raw sensor values to calibrated values before they are published.
+// If a table is provided the value is interpolated between the nearest points of the table. The
+// result is multiplied by the gain, if not 0, and the offset is added.
+type OutputCalibration struct {
+	Gain   float64            `json:"gain,omitempty"`   // multiplier of the value, 0 is not applied
+	Offset float64            `json:"offset,omitempty"` // offset added to the value
+	Table  []CalibrationPoint `json:"table,omitempty"`  // optional table with at least 2 points for non-linear sensors
+}
+
+// OutputCalibrateMessage is a command to set the calibration of an output
+// The address is domain/publisher/node/outputType/instance/$calibrate
+type OutputCalibrateMessage struct {
+	Address     string             `json:"address"`               // address of the command
+	Calibration *OutputCalibration `json:"calibration,omitempty"` // new calibration, nil to remove the calibration
+	MessageID   string             `json:"messageId,omitempty"`   // optional ID to request an acknowledgement
+	Sender      string             `json:"sender"`                // address of the sending publisher
+	Timestamp   string             `json:"timestamp"`             // time the command was sent
+}
+
+// OutputEventType with discrete events of a node
+type OutputEventType string
+
+// OutputEventType values. Adapters can use other event types if these don't apply.
+const (
+	OutputEventAlarm   OutputEventType = "alarm"   // alarm is triggered
+	OutputEventClosed  OutputEventType = "closed"  // door, window or valve is closed
+	OutputEventMotion  OutputEventType = "motion"  // motion is detected
+	OutputEventOpened  OutputEventType = "opened"  // door, window or valve is opened
+	OutputEventPressed OutputEventType = "pressed" // button is pressed
+	OutputEventTamper  OutputEventType = "tamper"  // device is tampered with
+)
+
+// OutputEventMessage message with either multiple output values of a node or a discrete event
 type OutputEventMessage struct {
-	Address   string            `json:"address"` // Address of the publication: zone/publisher/node/$output/type/instance
-	Event     map[string]string `json:"event"`
+	Address   string            `json:"address"`             // Address of the publication: zone/publisher/node/$event
+	Event     map[string]string `json:"event,omitempty"`     // output values by type/instance
+	EventType OutputEventType   `json:"eventType,omitempty"` // type of discrete event, eg motion
+	Payload   string            `json:"payload,omitempty"`   // optional payload of the discrete event
 	Timestamp string            `json:"timestamp"`
 }
 
@@ -184,10 +238,10 @@ type OutputEventMessage struct {
 
 // OutputForecastMessage with prediction output values
 type OutputForecastMessage struct {
-	Address   string        `json:"address"` // Address of the publication: zone/publisher/node/$output/type/instance
-	Duration  int           `json:"duration,omitempty"`
-	Forecast  []OutputValue `json:"forecast"`  // list of timestamp and value pairs
-	Timestamp string        `json:"timestamp"` // timestamp the forecast was created
+	Address   string        `json:"address"`            // Address of the publication: zone/publisher/node/$output/type/instance
+	Duration  int           `json:"duration,omitempty"` // seconds between the first and last forecasted value
+	Forecast  []OutputValue `json:"forecast"`           // list of timestamp and value pairs, nearest first
+	Timestamp string        `json:"timestamp"`          // timestamp the forecast was created
 	Unit      Unit          `json:"unit,omitempty"`
 }
 
@@ -202,15 +256,20 @@ type OutputHistoryMessage struct {
 
 // OutputLatestMessage struct to send/receive the '$latest' command
 type OutputLatestMessage struct {
-	Address   string `json:"address"`   // Address of the publication: zone/publisher/node/$output/type/instance
-	Timestamp string `json:"timestamp"` // timestamp of value
-	Unit      Unit   `json:"unit,omitempty"`
-	Value     string `json:"value"` // this can also be a string containing a list, eg "[ a, b, c ]""
+	Address    string `json:"address"`             // Address of the publication: zone/publisher/node/$output/type/instance
+	Published  string `json:"published,omitempty"` // timestamp the value was first published
+	Sequence   uint64 `json:"sequence,omitempty"`  // sequence nr of the value, increases with each value of the output
+	Timestamp  string `json:"timestamp"`           // timestamp the value was measured
+	Unit       Unit   `json:"unit,omitempty"`
+	ValidUntil string `json:"validUntil,omitempty"` // time the value expires, empty if it doesn't expire
+	Value      string `json:"value"`                // this can also be a string containing a list, eg "[ a, b, c ]""
 }
 
 // OutputValue struct for history and forecast
 type OutputValue struct {
-	Timestamp string `json:"timestamp"` // Timestamp of the value is ISO 8601
-	Value     string `json:"value"`     // this can also be a string containing a list, eg "[ a, b, c ]""
-	EpochTime int64  `json:"epoch"`     // seconds since jan 1st, 1970,
+	Published string `json:"published,omitempty"` // Timestamp the value was first published, ISO 8601. Empty if not yet published
+	Sequence  uint64 `json:"sequence,omitempty"`  // Sequence nr of the value, increases with each value of the output
+	Timestamp string `json:"timestamp"`           // Timestamp the value was measured, ISO 8601
+	Value     string `json:"value"`               // this can also be a string containing a list, eg "[ a, b, c ]""
+	EpochTime int64  `json:"epoch"`               // seconds since jan 1st, 1970, the value was measured
 }
@@ -15,12 +15,13 @@ const (
 	PublisherRunStateFailed       PublisherRunState = "failed"       // Publisher failed to start
 	PublisherRunStateInitializing PublisherRunState = "initializing" // Publisher is initializing
 	PublisherRunStateLost         PublisherRunState = "lost"         // Publisher unexpectedly disconnected
+	PublisherRunStateWarning      PublisherRunState = "warning"      // Publisher is working but needs attention, see the status reason
 )
 
 // PublisherIdentityMessage contains the public identity of a publisher
 type PublisherIdentityMessage struct {
 	Address           string `json:"address"`               // publication address of this identity, eg domain/publisherId/\$identity
-	Certificate       string `json:"certificate,omitempty"` // optional x509 cert base64 encoded
+	Certificate       string `json:"certificate,omitempty"` // optional X.509 certificate of the public key in PEM format
 	Domain            string `json:"domain"`                // IoT domain name for this publisher
 	IssuerID          string `json:"issuerId"`              // Issuer of the identity, the DSS, publisherId or CA
 	Location          string `json:"location,omitempty"`    // city, province, country
@@ -41,8 +42,58 @@ type PublisherFullIdentity struct {
 	Sender     string `json:"sender"`     // sender of this update, usually the DSS
 }
 
+// RefreshMessage requests all publishers of a domain to republish their discovery
+type RefreshMessage struct {
+	Address   string `json:"address"`   // publication address of this message, eg domain/$refresh
+	Sender    string `json:"sender"`    // address of the requesting publisher
+	Timestamp string `json:"timestamp"` // time the request was made
+}
+
+// RetireMessage notifies consumers that a publisher or one of its nodes is decommissioned. Consumers
+// remove the retired publisher or node with its inputs and outputs.
+type RetireMessage struct {
+	Address   string `json:"address"`             // publication address of this message, eg domain/publisherId/$retire
+	Retired   string `json:"retired"`             // address of the retired identity or node discovery
+	Sender    string `json:"sender"`              // identity address of the retiring publisher
+	Successor string `json:"successor,omitempty"` // optional ID of the publisher that takes over
+	Timestamp string `json:"timestamp"`           // time the publisher retired
+}
+
+// RevocationListMessage lists the publisher identities that are revoked by the DSS, eg because
+// their key is compromised. The list is published retained by the DSS of the domain. Consumers
+// remove the revoked identities and reject messages signed with the revoked keys.
+type RevocationListMessage struct {
+	Address   string            `json:"address"`   // publication address of this message, eg domain/$dss/$revoked
+	Revoked   []RevokedIdentity `json:"revoked"`   // the revoked identities
+	Sender    string            `json:"sender"`    // identity address of the DSS
+	Timestamp string            `json:"timestamp"` // time the list was published
+}
+
+// RevokedIdentity is an identity in the revocation list of the DSS
+type RevokedIdentity struct {
+	Address   string `json:"address"`          // identity address of the revoked publisher, eg domain/publisherId/$identity
+	PublicKey string `json:"publicKey"`        // the revoked public key in PEM format
+	Reason    string `json:"reason,omitempty"` // optional reason of revocation, eg compromised
+	RevokedAt string `json:"revokedAt"`        // time the identity was revoked
+}
+
+// SigningPolicyMessage announces the message types that a publisher publishes without signature,
+// eg high-frequency $raw values. Consumers reject unsigned messages of other types. The policy is
+// published retained and is always signed.
+type SigningPolicyMessage struct {
+	Address       string   `json:"address"`       // publication address of this message, eg domain/publisherId/$signing
+	Sender        string   `json:"sender"`        // identity address of the publisher
+	Timestamp     string   `json:"timestamp"`     // time the policy was published
+	UnsignedTypes []string `json:"unsignedTypes"` // message types that are published without signature
+}
+
+// StatusReasonIdentityRevoked is the publisher status reason when a domain identity is revoked
+const StatusReasonIdentityRevoked = "identityRevoked"
+
 // PublisherStatusMessage containing 'alive' status, used in LWT
 type PublisherStatusMessage struct {
-	Address string            `json:"address"` // publication address of this message
-	Status  PublisherRunState `json:"status"`
+	Address      string            `json:"address"`                // publication address of this message
+	Status       PublisherRunState `json:"status"`                 // runtime status of the publisher
+	Reason       string            `json:"reason,omitempty"`       // optional reason code of the status, eg for the LWT
+	OfflineNodes []string          `json:"offlineNodes,omitempty"` // addresses of nodes that are offline along with the publisher
 }